@@ -66,8 +66,13 @@ func NewPlan(id string, units []Unit) (*Plan, error) {
 		if p, ok := u.(*PCollection); ok {
 			pcols = append(pcols, p)
 		}
-		if p, ok := u.(*ParDo); ok {
+		switch p := u.(type) {
+		case *ParDo:
 			p.bf = &bf
+		case *ProcessSizedElementsAndRestrictions:
+			p.PDo.bf = &bf
+		case *SdfFallback:
+			p.PDo.bf = &bf
 		}
 	}
 	if len(roots) == 0 {
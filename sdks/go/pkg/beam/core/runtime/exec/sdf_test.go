@@ -1371,6 +1371,83 @@ func TestMultiWindowProcessing(t *testing.T) {
 	}
 }
 
+// TestSdfBundleFinalization tests that SDFs executed by
+// ProcessSizedElementsAndRestrictions and SdfFallback receive the
+// BundleFinalization of their plan, so that callbacks registered while
+// processing are run once when the bundle is finalized.
+func TestSdfBundleFinalization(t *testing.T) {
+	tests := []struct {
+		name string
+		node func(n *ParDo) Unit
+		in   FullValue
+	}{
+		{
+			name: "ProcessSizedElementsAndRestrictions",
+			node: func(n *ParDo) Unit { return &ProcessSizedElementsAndRestrictions{PDo: n} },
+			in: FullValue{
+				Elm: &FullValue{
+					Elm: 1,
+					Elm2: &FullValue{
+						Elm:  offsetrange.Restriction{Start: 0, End: 4},
+						Elm2: false,
+					},
+				},
+				Elm2:      4.0,
+				Timestamp: testTimestamp,
+				Windows:   testWindows,
+			},
+		},
+		{
+			name: "SdfFallback",
+			node: func(n *ParDo) Unit { return &SdfFallback{PDo: n} },
+			in: FullValue{
+				Elm:       1,
+				Timestamp: testTimestamp,
+				Windows:   testWindows,
+			},
+		},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			fn := &FinalizingSdf{}
+			dfn, err := graph.NewDoFn(fn, graph.NumMainInputs(graph.MainSingle))
+			if err != nil {
+				t.Fatalf("invalid function: %v", err)
+			}
+			capt := &CaptureNode{UID: 2}
+			node := test.node(&ParDo{UID: 1, Fn: dfn, Out: []Node{capt}})
+			root := &FixedRoot{UID: 0, Elements: []MainInput{{Key: test.in}}, Out: node.(Node)}
+			p, err := NewPlan("a", []Unit{root, node, capt})
+			if err != nil {
+				t.Fatalf("failed to construct plan: %v", err)
+			}
+			if err := p.Execute(context.Background(), "1", DataContext{}); err != nil {
+				t.Fatalf("execute failed: %v", err)
+			}
+			if got, want := fn.finalized, 0; got != want {
+				t.Errorf("callbacks run before finalization = %v, want %v", got, want)
+			}
+			if err := p.Finalize(); err != nil {
+				t.Fatalf("finalize failed: %v", err)
+			}
+			if got, want := fn.finalized, 1; got != want {
+				t.Errorf("callbacks run after finalization = %v, want %v", got, want)
+			}
+			// Callbacks are dropped once they succeed, so they only run once.
+			if err := p.Finalize(); err != nil {
+				t.Fatalf("second finalize failed: %v", err)
+			}
+			if got, want := fn.finalized, 1; got != want {
+				t.Errorf("callbacks run after second finalization = %v, want %v", got, want)
+			}
+			if err := p.Down(context.Background()); err != nil {
+				t.Fatalf("down failed: %v", err)
+			}
+		})
+	}
+}
+
 // NegativeSizeSdf is a very basic SDF that returns a negative restriction size
 // if the passed in restriction matches otherwise it uses offsetrange.Restriction's default size.
 type NegativeSizeSdf struct {
@@ -1455,6 +1532,46 @@ func (fn *WindowBlockingSdf) ProcessElement(w typex.Window, rt *sdf.LockRTracker
 	emit(elm)
 }
 
+// FinalizingSdf is a very basic SDF that registers a bundle finalization
+// callback for each restriction it processes, counting the callbacks run.
+type FinalizingSdf struct {
+	finalized int
+}
+
+// CreateInitialRestriction creates a four-element offset range.
+func (fn *FinalizingSdf) CreateInitialRestriction(_ int) offsetrange.Restriction {
+	return offsetrange.Restriction{Start: 0, End: 4}
+}
+
+// SplitRestriction is a no-op, and does not split.
+func (fn *FinalizingSdf) SplitRestriction(_ int, rest offsetrange.Restriction) []offsetrange.Restriction {
+	return []offsetrange.Restriction{rest}
+}
+
+// RestrictionSize defers to the default offset range restriction size.
+func (fn *FinalizingSdf) RestrictionSize(_ int, rest offsetrange.Restriction) float64 {
+	return rest.Size()
+}
+
+// CreateTracker creates an offset range RTracker.
+func (fn *FinalizingSdf) CreateTracker(rest offsetrange.Restriction) *offsetrange.Tracker {
+	return offsetrange.NewTracker(rest)
+}
+
+// ProcessElement registers a callback counting its runs, and then emits the
+// element after consuming the entire restriction tracker.
+func (fn *FinalizingSdf) ProcessElement(bf typex.BundleFinalization, rt *offsetrange.Tracker, elm int, emit func(int)) {
+	bf.RegisterCallback(time.Hour, func() error {
+		fn.finalized++
+		return nil
+	})
+	i := rt.GetRestriction().(offsetrange.Restriction).Start
+	for rt.TryClaim(i) {
+		i++
+	}
+	emit(elm)
+}
+
 // SplittableUnitRTracker is a VetRTracker with some added behavior needed for
 // TestAsSplittableUnit.
 type SplittableUnitRTracker struct {
@@ -0,0 +1,150 @@
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements.  See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License.  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package amqpio contains transforms to read from AMQP 0.9.1 queues and write
+// to exchanges, such as those of RabbitMQ, similar to Java's RabbitMqIO.
+//
+// Reading is implemented with a splittable DoFn, so it runs on portable
+// runners that support unbounded splittable DoFns and bundle finalization.
+// Experimental.
+package amqpio
+
+import (
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/apache/beam/sdks/v2/go/pkg/beam"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/internal/errors"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+func init() {
+	beam.RegisterType(reflect.TypeOf((*Message)(nil)).Elem())
+}
+
+// Message is an AMQP message, as read by Read from a queue, or written by
+// Write to an exchange.
+type Message struct {
+	// Exchange is the exchange the message was published to. Write ignores
+	// it.
+	Exchange string
+	// RoutingKey is the routing key the message was published with.
+	RoutingKey string
+	Body       []byte
+	// Headers are the headers of the message. Headers of other types than
+	// strings and byte slices are read formatted as strings.
+	Headers       map[string]string
+	ContentType   string
+	CorrelationID string
+	MessageID     string
+	// Timestamp is the timestamp property of the message, in milliseconds
+	// since the epoch, or 0 if unset. AMQP timestamps have a resolution of
+	// seconds.
+	Timestamp int64
+	// Redelivered is whether the message was delivered before, but not
+	// acknowledged. Write ignores it.
+	Redelivered bool
+}
+
+// channel is the subset of the methods of *amqp.Channel used by the
+// transforms, so that it can be faked in tests.
+type channel interface {
+	Qos(prefetchCount, prefetchSize int, global bool) error
+	Consume(queue, consumer string, autoAck, exclusive, noLocal, noWait bool, args amqp.Table) (<-chan amqp.Delivery, error)
+	Confirm(noWait bool) error
+	NotifyPublish(confirm chan amqp.Confirmation) chan amqp.Confirmation
+	Publish(exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error
+	Close() error
+}
+
+// dialFunc opens a channel to the broker at the URL.
+type dialFunc func(url string) (channel, error)
+
+// connChannel is a channel that closes its connection when it's closed.
+type connChannel struct {
+	*amqp.Channel
+	conn *amqp.Connection
+}
+
+func (c *connChannel) Close() error {
+	c.Channel.Close()
+	return c.conn.Close()
+}
+
+// dial opens a channel on a new connection to the broker at the URL.
+func dial(url string) (channel, error) {
+	conn, err := amqp.Dial(url)
+	if err != nil {
+		return nil, errors.Wrap(err, "connecting to broker")
+	}
+	ch, err := conn.Channel()
+	if err != nil {
+		conn.Close()
+		return nil, errors.Wrap(err, "opening channel")
+	}
+	return &connChannel{Channel: ch, conn: conn}, nil
+}
+
+// newMessage returns the message of the delivery.
+func newMessage(d amqp.Delivery) Message {
+	m := Message{
+		Exchange:      d.Exchange,
+		RoutingKey:    d.RoutingKey,
+		Body:          d.Body,
+		ContentType:   d.ContentType,
+		CorrelationID: d.CorrelationId,
+		MessageID:     d.MessageId,
+		Redelivered:   d.Redelivered,
+	}
+	if len(d.Headers) > 0 {
+		m.Headers = make(map[string]string, len(d.Headers))
+		for k, v := range d.Headers {
+			switch v := v.(type) {
+			case string:
+				m.Headers[k] = v
+			case []byte:
+				m.Headers[k] = string(v)
+			default:
+				m.Headers[k] = fmt.Sprint(v)
+			}
+		}
+	}
+	if !d.Timestamp.IsZero() {
+		m.Timestamp = d.Timestamp.UnixMilli()
+	}
+	return m
+}
+
+// publishing returns the publishing of the message.
+func publishing(m Message, deliveryMode uint8) amqp.Publishing {
+	p := amqp.Publishing{
+		Body:          m.Body,
+		ContentType:   m.ContentType,
+		CorrelationId: m.CorrelationID,
+		MessageId:     m.MessageID,
+		DeliveryMode:  deliveryMode,
+	}
+	if len(m.Headers) > 0 {
+		p.Headers = make(amqp.Table, len(m.Headers))
+		for k, v := range m.Headers {
+			p.Headers[k] = v
+		}
+	}
+	if m.Timestamp != 0 {
+		p.Timestamp = time.UnixMilli(m.Timestamp)
+	}
+	return p
+}
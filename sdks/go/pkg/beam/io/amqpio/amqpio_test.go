@@ -0,0 +1,310 @@
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements.  See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License.  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package amqpio
+
+import (
+	"context"
+	"errors"
+	"math"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/apache/beam/sdks/v2/go/pkg/beam"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/core/graph/mtime"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/core/sdf"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/io/rtrackers/offsetrange"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/io/rtrackers/unbounded/unboundedtest"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/testing/ptest"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+const testQueue = "q"
+
+func TestMain(m *testing.M) {
+	ptest.Main(m)
+}
+
+// published is a message published to a fakeChannel.
+type published struct {
+	exchange, key string
+	msg           amqp.Publishing
+}
+
+// fakeChannel is a channel that delivers the messages sent on its
+// deliveries, records the messages published and acknowledged, and confirms
+// published messages unless nack is set.
+type fakeChannel struct {
+	deliveries chan amqp.Delivery
+	nack       bool
+
+	mu        sync.Mutex
+	prefetch  int
+	consumed  string
+	confirms  chan amqp.Confirmation
+	published []published
+	acked     []uint64
+	closed    bool
+}
+
+func newFakeChannel() *fakeChannel {
+	return &fakeChannel{deliveries: make(chan amqp.Delivery, 10)}
+}
+
+func (c *fakeChannel) dial(string) (channel, error) {
+	return c, nil
+}
+
+// deliver sends a delivery of the message, with the next delivery tag.
+func (c *fakeChannel) deliver(d amqp.Delivery) {
+	d.Acknowledger = c
+	d.DeliveryTag = uint64(len(c.deliveries) + 1)
+	c.deliveries <- d
+}
+
+func (c *fakeChannel) Qos(prefetchCount, _ int, _ bool) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.prefetch = prefetchCount
+	return nil
+}
+
+func (c *fakeChannel) Consume(queue, _ string, autoAck, _, _, _ bool, _ amqp.Table) (<-chan amqp.Delivery, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if autoAck {
+		return nil, amqp.ErrClosed
+	}
+	c.consumed = queue
+	return c.deliveries, nil
+}
+
+func (c *fakeChannel) Confirm(bool) error {
+	return nil
+}
+
+func (c *fakeChannel) NotifyPublish(confirms chan amqp.Confirmation) chan amqp.Confirmation {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.confirms = confirms
+	return confirms
+}
+
+func (c *fakeChannel) Publish(exchange, key string, _, _ bool, msg amqp.Publishing) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.published = append(c.published, published{exchange, key, msg})
+	c.confirms <- amqp.Confirmation{DeliveryTag: uint64(len(c.published)), Ack: !c.nack}
+	return nil
+}
+
+func (c *fakeChannel) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closed = true
+	return nil
+}
+
+func (c *fakeChannel) Ack(tag uint64, _ bool) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.acked = append(c.acked, tag)
+	return nil
+}
+
+func (c *fakeChannel) Nack(uint64, bool, bool) error {
+	return nil
+}
+
+func (c *fakeChannel) Reject(uint64, bool) error {
+	return nil
+}
+
+// process processes a restriction with the reader, returning the messages
+// output and their event times.
+func process(t *testing.T, fn *readFn, we *sdf.ManualWatermarkEstimator, bf *unboundedtest.Finalization) ([]Message, []beam.EventTime) {
+	t.Helper()
+	rt := fn.CreateTracker(fn.CreateInitialRestriction(nil))
+	var got []Message
+	var ets []beam.EventTime
+	pc, err := fn.ProcessElement(context.Background(), we, bf, rt, nil, func(et beam.EventTime, m Message) {
+		got = append(got, m)
+		ets = append(ets, et)
+	})
+	if err != nil {
+		t.Fatalf("ProcessElement() failed: %v", err)
+	}
+	if !pc.ShouldResume() {
+		t.Errorf("ProcessElement() = %v, want it to resume", pc)
+	}
+	return got, ets
+}
+
+func TestReadFn(t *testing.T) {
+	ch := newFakeChannel()
+	sent := time.Now().Add(-time.Hour).Truncate(time.Second)
+	ch.deliver(amqp.Delivery{Body: []byte("a"), Timestamp: sent, Headers: amqp.Table{"h": "v", "n": int32(1)}})
+	ch.deliver(amqp.Delivery{Body: []byte("b"), RoutingKey: "k", Redelivered: true})
+
+	fn := &readFn{URL: "amqp://fake", Queue: testQueue, Readers: 1, Prefetch: 5, dial: ch.dial}
+	if err := fn.Setup(context.Background()); err != nil {
+		t.Fatalf("Setup() failed: %v", err)
+	}
+	if ch.consumed != testQueue || ch.prefetch != 5 {
+		t.Errorf("Setup() consumed %q with prefetch %v, want %q with prefetch 5", ch.consumed, ch.prefetch, testQueue)
+	}
+
+	var bf unboundedtest.Finalization
+	rest := fn.CreateInitialRestriction(nil)
+	we := fn.CreateWatermarkEstimator(fn.InitialWatermarkEstimatorState(mtime.MinTimestamp, rest, nil))
+	start := time.Now()
+	got, ets := process(t, fn, we, &bf)
+
+	want := []Message{
+		{Body: []byte("a"), Headers: map[string]string{"h": "v", "n": "1"}, Timestamp: sent.UnixMilli()},
+		{Body: []byte("b"), RoutingKey: "k", Redelivered: true},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("ProcessElement() output %+v, want %+v", got, want)
+	}
+	// Messages are output at their timestamp, or else the time they're read.
+	if ets[0] != mtime.FromTime(sent) {
+		t.Errorf("ProcessElement() output %v at %v, want its timestamp %v", got[0], ets[0], mtime.FromTime(sent))
+	}
+	if ets[1] < mtime.FromTime(start) {
+		t.Errorf("ProcessElement() output %v at %v, want the time it was read", got[1], ets[1])
+	}
+	// The watermark is the earliest timestamp of the messages.
+	if wm := we.CurrentWatermark(); !wm.Equal(sent) {
+		t.Errorf("ProcessElement() watermark = %v, want %v", wm, sent)
+	}
+
+	// The messages are acknowledged once the bundle is finalized.
+	if len(ch.acked) != 0 {
+		t.Errorf("ProcessElement() acknowledged %v before finalization, want none", ch.acked)
+	}
+	if len(bf.Callbacks) != 1 {
+		t.Fatalf("ProcessElement() registered %v finalization callbacks, want 1", len(bf.Callbacks))
+	}
+	if err := bf.Callbacks[0](); err != nil {
+		t.Fatalf("finalization callback failed: %v", err)
+	}
+	if want := []uint64{1, 2}; !reflect.DeepEqual(ch.acked, want) {
+		t.Errorf("finalization acknowledged %v, want %v", ch.acked, want)
+	}
+
+	// There are no more messages, so the watermark advances to the current
+	// time.
+	if got, _ := process(t, fn, we, &bf); len(got) != 0 {
+		t.Errorf("ProcessElement() of an empty queue output %v, want none", got)
+	}
+	if wm := we.CurrentWatermark(); wm.Before(start.Truncate(time.Millisecond)) {
+		t.Errorf("ProcessElement() watermark = %v, want at least %v", wm, start)
+	}
+	if len(bf.Callbacks) != 1 {
+		t.Errorf("ProcessElement() of an empty queue registered a finalization callback, want none")
+	}
+
+	if err := fn.Teardown(); err != nil {
+		t.Fatalf("Teardown() failed: %v", err)
+	}
+	if !ch.closed {
+		t.Error("Teardown() didn't close the channel, so unacknowledged messages aren't redelivered")
+	}
+}
+
+func TestReadFn_Reconnect(t *testing.T) {
+	ch := newFakeChannel()
+	fn := &readFn{URL: "amqp://fake", Queue: testQueue, Readers: 1, Prefetch: 1, dial: ch.dial}
+	if err := fn.Setup(context.Background()); err != nil {
+		t.Fatalf("Setup() failed: %v", err)
+	}
+	defer fn.Teardown()
+
+	// A reader whose deliveries stop, when the channel is closed by the
+	// broker, reconnects.
+	close(ch.deliveries)
+	var bf unboundedtest.Finalization
+	rest := fn.CreateInitialRestriction(nil)
+	we := fn.CreateWatermarkEstimator(fn.InitialWatermarkEstimatorState(mtime.MinTimestamp, rest, nil))
+	process(t, fn, we, &bf)
+	if !ch.closed || fn.ch != nil {
+		t.Fatal("ProcessElement() didn't close the channel whose deliveries stopped")
+	}
+
+	// A reader that fails to reconnect checkpoints to retry.
+	fn.dial = func(string) (channel, error) {
+		return nil, errors.New("connection refused")
+	}
+	rt := fn.CreateTracker(rest)
+	pc, err := fn.ProcessElement(context.Background(), we, &bf, rt, nil, func(beam.EventTime, Message) {})
+	if err != nil || !pc.ShouldResume() {
+		t.Fatalf("ProcessElement() failing to reconnect = %v, %v, want it to resume", pc, err)
+	}
+	if _, residual, _ := rt.TrySplit(0); residual == nil {
+		t.Error("ProcessElement() failing to reconnect left no residual to retry")
+	}
+
+	ch2 := newFakeChannel()
+	ch2.deliver(amqp.Delivery{Body: []byte("a")})
+	fn.dial = ch2.dial
+	if got, _ := process(t, fn, we, &bf); len(got) != 1 {
+		t.Errorf("ProcessElement() after reconnecting output %v, want 1 message", got)
+	}
+}
+
+func TestReadFn_SplitRestriction(t *testing.T) {
+	fn := &readFn{Readers: 3}
+	splits := fn.SplitRestriction(nil, fn.CreateInitialRestriction(nil))
+	if len(splits) != 3 || splits[0].Start != 0 || splits[2].End != math.MaxInt64 {
+		t.Fatalf("SplitRestriction() = %v, want 3 restrictions covering the range", splits)
+	}
+	for i := 1; i < len(splits); i++ {
+		if splits[i].Start != splits[i-1].End {
+			t.Errorf("SplitRestriction() = %v, want contiguous restrictions", splits)
+		}
+	}
+}
+
+func TestCreateTracker(t *testing.T) {
+	rt := (&readFn{}).CreateTracker(offsetrange.Restriction{Start: 0, End: math.MaxInt64})
+	if rt.IsBounded() {
+		t.Error("CreateTracker() is bounded, want unbounded so that draining stops reads")
+	}
+}
+
+func TestRead_Options(t *testing.T) {
+	tests := []struct {
+		name string
+		fn   func()
+	}{
+		{"no queue", func() { Read(beam.NewPipeline().Root(), "amqp://fake", "") }},
+		{"zero readers", func() { ReadNumReaders(0) }},
+		{"zero prefetch", func() { ReadPrefetch(0) }},
+		{"zero batch size", func() { WriteBatchSize(0) }},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%v succeeded, want panic", test.name)
+				}
+			}()
+			test.fn()
+		})
+	}
+}
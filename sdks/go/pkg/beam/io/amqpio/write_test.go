@@ -0,0 +1,92 @@
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements.  See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License.  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package amqpio
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/apache/beam/sdks/v2/go/pkg/beam"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// write publishes the messages with the writer in one bundle, and returns
+// the error of finishing it.
+func write(t *testing.T, fn *writeFn, msgs ...Message) error {
+	t.Helper()
+	ctx := context.Background()
+	if err := fn.Setup(ctx); err != nil {
+		t.Fatalf("Setup() failed: %v", err)
+	}
+	for _, m := range msgs {
+		if err := fn.ProcessElement(ctx, m); err != nil {
+			return err
+		}
+	}
+	return fn.FinishBundle(ctx)
+}
+
+func TestWriteFn(t *testing.T) {
+	ch := newFakeChannel()
+	fn := &writeFn{URL: "amqp://fake", Exchange: "x", RoutingKey: "default", Persistent: true, BatchSize: 1, dial: ch.dial}
+	defer fn.Teardown()
+
+	ts := time.Now().Truncate(time.Second)
+	err := write(t, fn,
+		Message{Body: []byte("a"), RoutingKey: "k", Headers: map[string]string{"h": "v"}, Timestamp: ts.UnixMilli()},
+		Message{Body: []byte("b")},
+	)
+	if err != nil {
+		t.Fatalf("FinishBundle() failed: %v", err)
+	}
+	if len(ch.published) != 2 {
+		t.Fatalf("Write() published %v messages, want 2", len(ch.published))
+	}
+	a, b := ch.published[0], ch.published[1]
+	if a.exchange != "x" || a.key != "k" || string(a.msg.Body) != "a" || a.msg.Headers["h"] != "v" || !a.msg.Timestamp.Equal(ts) {
+		t.Errorf("Write() published %+v, want it to exchange x with routing key k, its header and its timestamp", a)
+	}
+	// Messages without a routing key are published with the default one.
+	if b.key != "default" {
+		t.Errorf("Write() published %q with routing key %q, want %q", b.msg.Body, b.key, "default")
+	}
+	for _, p := range ch.published {
+		if p.msg.DeliveryMode != amqp.Persistent {
+			t.Errorf("Write() published %q with delivery mode %v, want persistent", p.msg.Body, p.msg.DeliveryMode)
+		}
+	}
+}
+
+func TestWriteFn_Nack(t *testing.T) {
+	ch := newFakeChannel()
+	ch.nack = true
+	fn := &writeFn{URL: "amqp://fake", Exchange: "x", BatchSize: 10, dial: ch.dial}
+	defer fn.Teardown()
+
+	// The bundle fails if the broker doesn't confirm its messages.
+	if err := write(t, fn, Message{Body: []byte("a")}); err == nil {
+		t.Error("FinishBundle() of a rejected message succeeded, want error")
+	}
+}
+
+func TestWrite_Bytes(t *testing.T) {
+	s := beam.NewPipeline().Root()
+	Write(s, "amqp://fake", "", beam.Create(s, []byte("a")), WriteRoutingKey(testQueue))
+	if got := bodyMessageFn([]byte("a")); string(got.Body) != "a" {
+		t.Errorf("bodyMessageFn() = %+v, want the body a", got)
+	}
+}
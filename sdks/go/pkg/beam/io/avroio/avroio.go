@@ -19,10 +19,12 @@ package avroio
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"reflect"
 	"strings"
 
 	"github.com/apache/beam/sdks/v2/go/pkg/beam"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/internal/errors"
 	"github.com/apache/beam/sdks/v2/go/pkg/beam/io/filesystem"
 	"github.com/apache/beam/sdks/v2/go/pkg/beam/log"
 	"github.com/linkedin/goavro"
@@ -34,21 +36,52 @@ func init() {
 	beam.RegisterType(reflect.TypeOf((*writeAvroFn)(nil)).Elem())
 }
 
+// readOption holds the options of Read.
+type readOption struct {
+	Schema string
+}
+
+// ReadOptionFn is an option for Read.
+type ReadOptionFn func(*readOption)
+
+// ReadSchema sets a reader schema to resolve the records of the files
+// against, so that files written with an older or newer version of the
+// schema can be read alike. Fields of the files that are not in the reader
+// schema are dropped, and fields of the reader schema that are not in the
+// files, under their name or an alias, take their default. Reading fails if
+// such a field has no default. Panics if schema is not a valid AVRO record
+// schema.
+func ReadSchema(schema string) ReadOptionFn {
+	if _, err := parseReaderSchema(schema); err != nil {
+		panic(fmt.Sprintf("avroio.ReadSchema invalid schema: %v", err))
+	}
+	return func(o *readOption) {
+		o.Schema = schema
+	}
+}
+
 // Read reads a set of files and returns lines as a PCollection<elem>
 // based on the internal avro schema of the file.
 // A type - reflect.TypeOf( YourType{} ) -  with
 // JSON tags can be defined or if you wish to return the raw JSON string,
-// use - reflect.TypeOf("") -
-func Read(s beam.Scope, glob string, t reflect.Type) beam.PCollection {
+// use - reflect.TypeOf("") - or to return the records without a Go type,
+// use - reflect.TypeOf(avroio.Record{}) -
+// The records are decoded with the schema of each file, unless a reader
+// schema is set with ReadSchema.
+func Read(s beam.Scope, glob string, t reflect.Type, opts ...ReadOptionFn) beam.PCollection {
 	s = s.Scope("avroio.Read")
 	filesystem.ValidateScheme(glob)
-	return read(s, t, beam.Create(s, glob))
+	var o readOption
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return read(s, t, beam.Create(s, glob), o)
 }
 
-func read(s beam.Scope, t reflect.Type, col beam.PCollection) beam.PCollection {
+func read(s beam.Scope, t reflect.Type, col beam.PCollection, o readOption) beam.PCollection {
 	files := beam.ParDo(s, expandFn, col)
 	return beam.ParDo(s,
-		&avroReadFn{Type: beam.EncodedType{T: t}},
+		&avroReadFn{Type: beam.EncodedType{T: t}, Schema: o.Schema},
 		files,
 		beam.TypeDefinition{Var: beam.XType, T: t},
 	)
@@ -78,6 +111,17 @@ func expandFn(ctx context.Context, glob string, emit func(string)) error {
 type avroReadFn struct {
 	// Avro schema type
 	Type beam.EncodedType
+	// Schema is the reader schema, if any.
+	Schema string
+
+	reader *recordSchema
+}
+
+func (f *avroReadFn) Setup() (err error) {
+	if f.Schema != "" {
+		f.reader, err = parseReaderSchema(f.Schema)
+	}
+	return
 }
 
 func (f *avroReadFn) ProcessElement(ctx context.Context, filename string, emit func(beam.X)) (err error) {
@@ -109,6 +153,24 @@ func (f *avroReadFn) ProcessElement(ctx context.Context, filename string, emit f
 			log.Errorf(ctx, "error reading avro row: %v", err)
 			continue
 		}
+		if f.reader != nil {
+			record, ok := i.(map[string]interface{})
+			if !ok {
+				return errors.Errorf("avro data in %v is not a record, so can't be read with a reader schema", filename)
+			}
+			if i, err = f.reader.resolve(record); err != nil {
+				return errors.WithContextf(err, "resolving avro data in %v", filename)
+			}
+		}
+
+		if f.Type.T == recordType {
+			record, ok := i.(map[string]interface{})
+			if !ok {
+				return errors.Errorf("avro data in %v is not a record", filename)
+			}
+			emit(Record(record))
+			continue
+		}
 
 		// marshal interface to bytes
 		var b []byte
@@ -133,21 +195,75 @@ func (f *avroReadFn) ProcessElement(ctx context.Context, filename string, emit f
 	return ar.Err()
 }
 
+// defaultBlockLength is the number of records in each block of a written
+// AVRO file, unless another is set with WriteBlockLength.
+const defaultBlockLength = 1000
+
+// writeOption holds the options of Write.
+type writeOption struct {
+	Codec       string
+	BlockLength int
+}
+
+// WriteOptionFn is an option for Write.
+type WriteOptionFn func(*writeOption)
+
+// WriteSnappy compresses the blocks of the written file with snappy. This is
+// the default.
+func WriteSnappy() WriteOptionFn {
+	return func(o *writeOption) {
+		o.Codec = goavro.CompressionSnappyLabel
+	}
+}
+
+// WriteDeflate compresses the blocks of the written file with deflate.
+func WriteDeflate() WriteOptionFn {
+	return func(o *writeOption) {
+		o.Codec = goavro.CompressionDeflateLabel
+	}
+}
+
+// WriteUncompressed writes the blocks of the file without compression.
+func WriteUncompressed() WriteOptionFn {
+	return func(o *writeOption) {
+		o.Codec = goavro.CompressionNullLabel
+	}
+}
+
+// WriteBlockLength sets the number of records in each block of the written
+// file. Each block is compressed separately, so larger blocks generally
+// compress better, at the cost of buffering more records while writing.
+func WriteBlockLength(n int) WriteOptionFn {
+	if n < 1 {
+		panic(fmt.Sprintf("avroio.WriteBlockLength block length must be positive. Got: %v", n))
+	}
+	return func(o *writeOption) {
+		o.BlockLength = n
+	}
+}
+
 // Write writes a PCollection<string> to an AVRO file.
 // Write expects a JSON string with a matching AVRO schema.
 // the process will fail if the schema does not match the JSON
-// provided
-func Write(s beam.Scope, filename, schema string, col beam.PCollection) {
+// provided. The file's blocks are compressed with snappy, unless another
+// codec is set with an option such as WriteDeflate.
+func Write(s beam.Scope, filename, schema string, col beam.PCollection, opts ...WriteOptionFn) {
 	s = s.Scope("avroio.Write")
 	filesystem.ValidateScheme(filename)
+	o := writeOption{Codec: goavro.CompressionSnappyLabel, BlockLength: defaultBlockLength}
+	for _, opt := range opts {
+		opt(&o)
+	}
 	pre := beam.AddFixedKey(s, col)
 	post := beam.GroupByKey(s, pre)
-	beam.ParDo0(s, &writeAvroFn{Schema: schema, Filename: filename}, post)
+	beam.ParDo0(s, &writeAvroFn{Schema: schema, Filename: filename, Codec: o.Codec, BlockLength: o.BlockLength}, post)
 }
 
 type writeAvroFn struct {
-	Schema   string `json:"schema"`
-	Filename string `json:"filename"`
+	Schema      string `json:"schema"`
+	Filename    string `json:"filename"`
+	Codec       string `json:"codec"`
+	BlockLength int    `json:"block_length"`
 }
 
 func (w *writeAvroFn) ProcessElement(ctx context.Context, _ int, lines func(*string) bool) (err error) {
@@ -173,7 +289,7 @@ func (w *writeAvroFn) ProcessElement(ctx context.Context, _ int, lines func(*str
 
 	ocfw, err := goavro.NewOCFWriter(goavro.OCFConfig{
 		Codec:           codec,
-		CompressionName: goavro.CompressionSnappyLabel,
+		CompressionName: w.Codec,
 		Schema:          w.Schema,
 		W:               fd,
 	})
@@ -183,6 +299,9 @@ func (w *writeAvroFn) ProcessElement(ctx context.Context, _ int, lines func(*str
 		return
 	}
 
+	// Records are appended a block at a time, since each call to Append
+	// writes a block.
+	block := make([]interface{}, 0, w.BlockLength)
 	var j string
 	for lines(&j) {
 		native, _, err := codec.NativeFromTextual([]byte(j))
@@ -191,7 +310,18 @@ func (w *writeAvroFn) ProcessElement(ctx context.Context, _ int, lines func(*str
 			return err
 		}
 
-		if err := ocfw.Append([]interface{}{native}); err != nil {
+		block = append(block, native)
+		if len(block) < w.BlockLength {
+			continue
+		}
+		if err := ocfw.Append(block); err != nil {
+			log.Errorf(ctx, "error writing avro: %v", err)
+			return err
+		}
+		block = block[:0]
+	}
+	if len(block) > 0 {
+		if err := ocfw.Append(block); err != nil {
 			log.Errorf(ctx, "error writing avro: %v", err)
 			return err
 		}
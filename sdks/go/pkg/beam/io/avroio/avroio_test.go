@@ -21,6 +21,7 @@ import (
 	"errors"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"reflect"
 	"testing"
 
@@ -123,3 +124,218 @@ func TestWrite(t *testing.T) {
 		t.Fatalf("User.User=%v, want %v", got, want)
 	}
 }
+
+// TestWrite_Codec tests that Write compresses blocks with the given codec,
+// and that records spanning several blocks are all written.
+func TestWrite_Codec(t *testing.T) {
+	tests := []struct {
+		name string
+		opt  WriteOptionFn
+		want string
+	}{
+		{name: "Default", opt: WriteBlockLength(2), want: goavro.CompressionSnappyLabel},
+		{name: "Deflate", opt: WriteDeflate(), want: goavro.CompressionDeflateLabel},
+		{name: "Uncompressed", opt: WriteUncompressed(), want: goavro.CompressionNullLabel},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			avroFile := filepath.Join(t.TempDir(), "user.avro")
+			p, s := beam.NewPipelineWithRoot()
+			users := beam.Create(s,
+				`{"username": "user1", "info": "a"}`,
+				`{"username": "user2", "info": "b"}`,
+				`{"username": "user3", "info": "c"}`)
+			Write(s, avroFile, userSchema, users, test.opt)
+
+			ptest.RunAndValidate(t, p)
+
+			fd, err := os.Open(avroFile)
+			if err != nil {
+				t.Fatalf("Failed to open avro file: %v", err)
+			}
+			defer fd.Close()
+			ocf, err := goavro.NewOCFReader(fd)
+			if err != nil {
+				t.Fatalf("Failed to make OCF Reader: %v", err)
+			}
+			if got := ocf.CompressionName(); got != test.want {
+				t.Errorf("Write() used codec %v, want %v", got, test.want)
+			}
+			var n int
+			for ocf.Scan() {
+				if _, err := ocf.Read(); err != nil {
+					break // Read error sets OCFReader error
+				}
+				n++
+			}
+			if err := ocf.Err(); err != nil {
+				t.Fatalf("Error decoding avro data: %v", err)
+			}
+			if n != 3 {
+				t.Errorf("Avro data, got %v records, want 3", n)
+			}
+		})
+	}
+}
+
+type evolvedUser struct {
+	Handle  string `json:"handle"`
+	Country string `json:"country"`
+}
+
+const evolvedUserSchema = `{
+	"type": "record",
+	"name": "user",
+	"namespace": "twitter",
+	"fields": [
+		{ "name": "handle", "type": "string", "aliases": ["username"] },
+		{ "name": "country", "type": "string", "default": "unknown" }
+	]
+}`
+
+// writeUsers writes user records with userSchema to a new AVRO file, and
+// returns its name.
+func writeUsers(t *testing.T, users ...string) string {
+	t.Helper()
+	avroFile := filepath.Join(t.TempDir(), "user.avro")
+	fd, err := os.Create(avroFile)
+	if err != nil {
+		t.Fatalf("Failed to create avro file: %v", err)
+	}
+	defer fd.Close()
+	ocfw, err := goavro.NewOCFWriter(goavro.OCFConfig{W: fd, Schema: userSchema})
+	if err != nil {
+		t.Fatalf("Failed to make OCF Writer: %v", err)
+	}
+	var records []interface{}
+	for _, user := range users {
+		records = append(records, map[string]interface{}{"username": user, "info": "info"})
+	}
+	if err := ocfw.Append(records); err != nil {
+		t.Fatalf("Failed to write avro data: %v", err)
+	}
+	return avroFile
+}
+
+// TestRead_Schema tests that records are resolved against a reader schema
+// that renames a field, drops a field and adds a field with a default.
+func TestRead_Schema(t *testing.T) {
+	avroFile := writeUsers(t, "user1", "user2")
+
+	p, s := beam.NewPipelineWithRoot()
+	users := Read(s, avroFile, reflect.TypeOf(evolvedUser{}), ReadSchema(evolvedUserSchema))
+	passert.Equals(s, users,
+		evolvedUser{Handle: "user1", Country: "unknown"},
+		evolvedUser{Handle: "user2", Country: "unknown"})
+
+	ptest.RunAndValidate(t, p)
+}
+
+// TestRead_SchemaNoDefault tests that reading fails if a field of the reader
+// schema is missing from the data and has no default.
+func TestRead_SchemaNoDefault(t *testing.T) {
+	avroFile := writeUsers(t, "user1")
+	const schema = `{
+		"type": "record",
+		"name": "user",
+		"fields": [
+			{ "name": "username", "type": "string" },
+			{ "name": "country", "type": "string" }
+		]
+	}`
+
+	p, s := beam.NewPipelineWithRoot()
+	Read(s, avroFile, reflect.TypeOf(""), ReadSchema(schema))
+
+	if err := ptest.Run(p); err == nil {
+		t.Error("Read() with a field without default succeeded, want error")
+	}
+}
+
+func TestRecordSchema_Resolve(t *testing.T) {
+	const schema = `{
+		"type": "record",
+		"name": "outer",
+		"fields": [
+			{ "name": "inner", "type": {
+				"type": "record",
+				"name": "inner",
+				"fields": [{ "name": "a", "type": "long", "default": 1 }]
+			}},
+			{ "name": "maybe", "type": ["null", "string"], "default": null },
+			{ "name": "tag", "type": ["string", "null"], "default": "none" }
+		]
+	}`
+	reader, err := parseReaderSchema(schema)
+	if err != nil {
+		t.Fatalf("parseReaderSchema() failed: %v", err)
+	}
+	got, err := reader.resolve(map[string]interface{}{
+		"inner":   map[string]interface{}{"b": "dropped"},
+		"dropped": int64(2),
+	})
+	if err != nil {
+		t.Fatalf("resolve() failed: %v", err)
+	}
+	want := map[string]interface{}{
+		"inner": map[string]interface{}{"a": float64(1)},
+		"maybe": nil,
+		"tag":   map[string]interface{}{"string": "none"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("resolve() = %v, want %v", got, want)
+	}
+}
+
+func TestReadSchema_Invalid(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("ReadSchema() with a non-record schema succeeded, want panic")
+		}
+	}()
+	ReadSchema(`"string"`)
+}
+
+func TestRead_Record(t *testing.T) {
+	avroFile := "../../../../data/tweet.avro"
+
+	p, s := beam.NewPipelineWithRoot()
+	records := Read(s, avroFile, reflect.TypeOf(Record{}))
+	tweets := beam.ParDo(s, func(r Record) Tweet {
+		return Tweet{
+			Stamp: int64(r["timestamp"].(float64)),
+			Tweet: r["tweet"].(string),
+			User:  r["username"].(string),
+		}
+	}, records)
+	passert.Equals(s, tweets, Tweet{
+		Stamp: int64(20),
+		Tweet: "Hello twitter",
+		User:  "user1",
+	})
+
+	ptest.RunAndValidate(t, p)
+}
+
+func TestRecordCoder(t *testing.T) {
+	want := Record{
+		"long":   int64(1),
+		"int":    int32(2),
+		"null":   nil,
+		"union":  map[string]interface{}{"string": "a"},
+		"array":  []interface{}{float64(1.5), "b"},
+		"record": map[string]interface{}{"bytes": []byte("c")},
+	}
+	data, err := encodeRecord(want)
+	if err != nil {
+		t.Fatalf("encodeRecord() failed: %v", err)
+	}
+	got, err := decodeRecord(data)
+	if err != nil {
+		t.Fatalf("decodeRecord() failed: %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("decodeRecord(encodeRecord(%v)) = %v", want, got)
+	}
+}
@@ -0,0 +1,64 @@
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements.  See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License.  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package avroio
+
+import (
+	"bytes"
+	"encoding/gob"
+	"reflect"
+
+	"github.com/apache/beam/sdks/v2/go/pkg/beam"
+)
+
+var recordType = reflect.TypeOf((*Record)(nil)).Elem()
+
+func init() {
+	// Register the composite native types goavro decodes into, so gob can
+	// encode them as values of interfaces.
+	gob.Register(map[string]interface{}{})
+	gob.Register([]interface{}{})
+	beam.RegisterCoder(recordType, encodeRecord, decodeRecord)
+}
+
+// Record is an AVRO record decoded without a Go type, for files whose
+// schema is only known at runtime. To read records as Records, pass
+// reflect.TypeOf(avroio.Record{}) to Read.
+//
+// Fields have the native form of goavro: nil for null, bool, int32 for int,
+// int64 for long, float32 for float, float64 for double, []byte for bytes
+// and fixed, string for string and enum, []interface{} for arrays,
+// map[string]interface{} for maps and records, and a map from the name of
+// the type to the value for non-null union values.
+//
+// The encoding of a Record is not deterministic, so Records must not be used
+// as keys.
+type Record map[string]interface{}
+
+func encodeRecord(r Record) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(map[string]interface{}(r)); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func decodeRecord(data []byte) (Record, error) {
+	var r map[string]interface{}
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&r); err != nil {
+		return nil, err
+	}
+	return Record(r), nil
+}
@@ -0,0 +1,153 @@
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements.  See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License.  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package avroio
+
+import (
+	"encoding/json"
+
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/internal/errors"
+	"github.com/linkedin/goavro"
+)
+
+// recordSchema is a parsed AVRO record schema, used as a reader schema to
+// resolve records written with a different writer schema.
+type recordSchema struct {
+	Name   string
+	Fields []fieldSchema
+}
+
+// fieldSchema is a field of a recordSchema.
+type fieldSchema struct {
+	Name    string
+	Aliases []string
+	// Record is the schema of the field if its type is a record, and nil
+	// otherwise.
+	Record *recordSchema
+	// Default is the field's default in the native form of goavro, used when
+	// the writer's record has no such field. Only valid if HasDefault is true.
+	Default    interface{}
+	HasDefault bool
+}
+
+// parseReaderSchema parses an AVRO record schema to use as a reader schema.
+func parseReaderSchema(schema string) (*recordSchema, error) {
+	if _, err := goavro.NewCodec(schema); err != nil {
+		return nil, errors.Wrap(err, "invalid reader schema")
+	}
+	var parsed interface{}
+	if err := json.Unmarshal([]byte(schema), &parsed); err != nil {
+		return nil, errors.Wrap(err, "invalid reader schema")
+	}
+	record, ok := parseRecord(parsed)
+	if !ok {
+		return nil, errors.New("reader schema must be a record")
+	}
+	return record, nil
+}
+
+// parseRecord returns the recordSchema of a JSON schema if it is a record.
+func parseRecord(schema interface{}) (*recordSchema, bool) {
+	m, ok := schema.(map[string]interface{})
+	if !ok || m["type"] != "record" {
+		return nil, false
+	}
+	record := &recordSchema{Name: fullName(m)}
+	fields, _ := m["fields"].([]interface{})
+	for _, f := range fields {
+		fm := f.(map[string]interface{})
+		field := fieldSchema{Name: fm["name"].(string)}
+		if aliases, ok := fm["aliases"].([]interface{}); ok {
+			for _, alias := range aliases {
+				field.Aliases = append(field.Aliases, alias.(string))
+			}
+		}
+		field.Record, _ = parseRecord(fm["type"])
+		if def, ok := fm["default"]; ok {
+			field.Default, field.HasDefault = nativeDefault(fm["type"], def), true
+		}
+		record.Fields = append(record.Fields, field)
+	}
+	return record, true
+}
+
+// fullName returns the name of a named type, qualified by its namespace.
+func fullName(m map[string]interface{}) string {
+	name, _ := m["name"].(string)
+	if ns, ok := m["namespace"].(string); ok && ns != "" {
+		return ns + "." + name
+	}
+	return name
+}
+
+// nativeDefault converts a field's JSON default to the native form of goavro.
+// Defaults of unions have the type of the union's first branch, and non-null
+// union values are represented as a map from the branch's name to the value.
+func nativeDefault(schema, def interface{}) interface{} {
+	branches, ok := schema.([]interface{})
+	if !ok || len(branches) == 0 || def == nil {
+		return def
+	}
+	switch branch := branches[0].(type) {
+	case string:
+		return map[string]interface{}{branch: def}
+	case map[string]interface{}:
+		if name := fullName(branch); name != "" {
+			return map[string]interface{}{name: def}
+		}
+		return map[string]interface{}{branch["type"].(string): def}
+	}
+	return def
+}
+
+// resolve converts a record decoded with the writer's schema to the reader
+// schema. Fields missing from the reader schema are dropped, and fields
+// missing from the writer's record, under their name or any alias, take the
+// reader schema's default. It fails if such a field has no default.
+func (r *recordSchema) resolve(datum map[string]interface{}) (map[string]interface{}, error) {
+	out := make(map[string]interface{}, len(r.Fields))
+	for _, f := range r.Fields {
+		v, ok := f.lookup(datum)
+		if !ok {
+			if !f.HasDefault {
+				return nil, errors.Errorf("field %v of record %v is missing from the data and has no default", f.Name, r.Name)
+			}
+			out[f.Name] = f.Default
+			continue
+		}
+		if nested, isMap := v.(map[string]interface{}); isMap && f.Record != nil {
+			var err error
+			if v, err = f.Record.resolve(nested); err != nil {
+				return nil, err
+			}
+		}
+		out[f.Name] = v
+	}
+	return out, nil
+}
+
+// lookup returns the value of the field in the writer's record, under its
+// name or one of its aliases.
+func (f *fieldSchema) lookup(datum map[string]interface{}) (interface{}, bool) {
+	if v, ok := datum[f.Name]; ok {
+		return v, true
+	}
+	for _, alias := range f.Aliases {
+		if v, ok := datum[alias]; ok {
+			return v, true
+		}
+	}
+	return nil, false
+}
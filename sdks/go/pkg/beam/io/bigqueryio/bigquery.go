@@ -19,6 +19,7 @@ package bigqueryio
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"reflect"
@@ -27,8 +28,9 @@ import (
 
 	"cloud.google.com/go/bigquery"
 	"github.com/apache/beam/sdks/v2/go/pkg/beam"
-	"github.com/apache/beam/sdks/v2/go/pkg/beam/core/util/reflectx"
 	"github.com/apache/beam/sdks/v2/go/pkg/beam/internal/errors"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/log"
+	"github.com/google/uuid"
 	bq "google.golang.org/api/bigquery/v2"
 	"google.golang.org/api/googleapi"
 	"google.golang.org/api/iterator"
@@ -46,6 +48,8 @@ const writeOverheadBytes = 1024
 func init() {
 	beam.RegisterType(reflect.TypeOf((*queryFn)(nil)).Elem())
 	beam.RegisterType(reflect.TypeOf((*writeFn)(nil)).Elem())
+	beam.RegisterType(reflect.TypeOf((*writeWithFailuresFn)(nil)).Elem())
+	beam.RegisterType(reflect.TypeOf((*FailedRow)(nil)).Elem())
 }
 
 // QualifiedTableName is a fully qualified name of a bigquery table.
@@ -97,6 +101,13 @@ func Read(s beam.Scope, project, table string, t reflect.Type) beam.PCollection
 type QueryOptions struct {
 	// UseStandardSQL enables BigQuery's Standard SQL dialect when executing a query.
 	UseStandardSQL bool
+	// Priority is the priority of the query job. Queries run interactively
+	// by default.
+	Priority bigquery.QueryPriority
+	// TempDataset is the dataset, as "<project>:<dataset>", to write the
+	// results of the query to before they are read. If empty, BigQuery
+	// writes them to an anonymous dataset.
+	TempDataset string
 }
 
 // UseStandardSQL enables BigQuery's Standard SQL dialect when executing a query.
@@ -107,8 +118,43 @@ func UseStandardSQL() func(qo *QueryOptions) error {
 	}
 }
 
+// UseBatchPriority runs the query as a batch job, which is queued until
+// idle resources are available instead of counting towards the concurrent
+// rate limit of interactive queries.
+func UseBatchPriority() func(qo *QueryOptions) error {
+	return func(qo *QueryOptions) error {
+		qo.Priority = bigquery.BatchPriority
+		return nil
+	}
+}
+
+// WithTempDataset writes the results of the query to a temporary table in
+// the given dataset, as "<project>:<dataset>", which is deleted once they
+// are read. This is needed when the results are too large for an anonymous
+// dataset, or must stay in a specific location.
+func WithTempDataset(dataset string) func(qo *QueryOptions) error {
+	return func(qo *QueryOptions) error {
+		if _, err := parseDataset(dataset); err != nil {
+			return err
+		}
+		qo.TempDataset = dataset
+		return nil
+	}
+}
+
+// parseDataset parses "<project>:<dataset>" into a QualifiedTableName
+// without a table.
+func parseDataset(s string) (QualifiedTableName, error) {
+	c := strings.LastIndex(s, ":")
+	if c == -1 || strings.TrimSpace(s[:c]) == "" || strings.TrimSpace(s[c+1:]) == "" {
+		return QualifiedTableName{}, errors.Errorf("dataset name must be <project>:<dataset>: %v", s)
+	}
+	return QualifiedTableName{Project: s[:c], Dataset: s[c+1:]}, nil
+}
+
 // Query executes a query. The output must have a schema compatible with the given
-// type, t. It returns a PCollection<t>.
+// type, t. It returns a PCollection<t>. Options such as UseStandardSQL,
+// UseBatchPriority and WithTempDataset configure the query job.
 func Query(s beam.Scope, project, q string, t reflect.Type, options ...func(*QueryOptions) error) beam.PCollection {
 	s = s.Scope("bigquery.Query")
 	return query(s, project, q, t, options...)
@@ -150,6 +196,25 @@ func (f *queryFn) ProcessElement(ctx context.Context, _ []byte, emit func(beam.X
 	if !f.Options.UseStandardSQL {
 		q.UseLegacySQL = true
 	}
+	if f.Options.Priority != "" {
+		q.Priority = f.Options.Priority
+	}
+	if f.Options.TempDataset != "" {
+		ds, err := parseDataset(f.Options.TempDataset)
+		if err != nil {
+			return err
+		}
+		temp := client.DatasetInProject(ds.Project, ds.Dataset).Table("beam_temp_" + strings.ReplaceAll(uuid.New().String(), "-", "_"))
+		defer func() {
+			if err := temp.Delete(ctx); err != nil {
+				log.Warnf(ctx, "failed to delete temporary table %v: %v", temp.FullyQualifiedName(), err)
+			}
+		}()
+		q.Dst = temp
+		q.CreateDisposition = bigquery.CreateIfNeeded
+		q.WriteDisposition = bigquery.WriteTruncate
+		q.AllowLargeResults = true
+	}
 
 	it, err := q.Read(ctx)
 	if err != nil {
@@ -189,15 +254,129 @@ func mustParseTable(table string) QualifiedTableName {
 	return qn
 }
 
-// TODO(herohde) 7/14/2017: allow CreateDispositions and WriteDispositions. The default
-// is not quite what the Dataflow examples do.
+// WriteOptions represents additional options for writing to tables.
+type WriteOptions struct {
+	// CreateDisposition specifies whether missing tables are created. Tables
+	// are created if needed by default.
+	CreateDisposition bigquery.TableCreateDisposition
+	// WriteDisposition specifies how rows are written to tables that already
+	// have rows. Rows are appended by default.
+	WriteDisposition bigquery.TableWriteDisposition
+	// Schemas are the schemas of the tables, by their qualified names. The
+	// schema of a table without one is inferred from the element type.
+	Schemas map[string]bigquery.Schema
+	// TimePartitioning specifies the time partitioning of created tables, if
+	// any.
+	TimePartitioning *bigquery.TimePartitioning
+	// Clustering specifies the clustering columns of created tables, if any.
+	Clustering *bigquery.Clustering
+}
+
+// newWriteOptions returns the write options with the given options applied
+// to the defaults. Panics if an option fails.
+func newWriteOptions(options []func(*WriteOptions) error) WriteOptions {
+	opts := WriteOptions{CreateDisposition: bigquery.CreateIfNeeded, WriteDisposition: bigquery.WriteAppend}
+	for _, opt := range options {
+		if err := opt(&opts); err != nil {
+			panic(err)
+		}
+	}
+	return opts
+}
+
+// tableMetadata returns the metadata to create a table with the given schema.
+func (o WriteOptions) tableMetadata(schema bigquery.Schema) *bigquery.TableMetadata {
+	return &bigquery.TableMetadata{
+		Schema:           schema,
+		TimePartitioning: o.TimePartitioning,
+		Clustering:       o.Clustering,
+	}
+}
+
+// WithCreateDisposition sets whether missing tables are created, which is
+// either bigquery.CreateIfNeeded or bigquery.CreateNever.
+func WithCreateDisposition(d bigquery.TableCreateDisposition) func(*WriteOptions) error {
+	return func(o *WriteOptions) error {
+		if d != bigquery.CreateIfNeeded && d != bigquery.CreateNever {
+			return errors.Errorf("unsupported create disposition: %v", d)
+		}
+		o.CreateDisposition = d
+		return nil
+	}
+}
+
+// WithWriteDisposition sets how rows are written to tables that already
+// have rows: bigquery.WriteAppend appends them, bigquery.WriteTruncate
+// deletes the existing rows first, and bigquery.WriteEmpty fails the write.
+func WithWriteDisposition(d bigquery.TableWriteDisposition) func(*WriteOptions) error {
+	return func(o *WriteOptions) error {
+		if d != bigquery.WriteAppend && d != bigquery.WriteTruncate && d != bigquery.WriteEmpty {
+			return errors.Errorf("unsupported write disposition: %v", d)
+		}
+		o.WriteDisposition = d
+		return nil
+	}
+}
+
+// WithTimePartitioning partitions created tables by time, in partitions of
+// the given type, such as bigquery.DayPartitioningType. The partitions are
+// by the given TIMESTAMP, DATE or DATETIME column, or by ingestion time if
+// the column is empty. Partitions expire after the given duration, unless
+// it's zero.
+func WithTimePartitioning(column string, typ bigquery.TimePartitioningType, expiration time.Duration) func(*WriteOptions) error {
+	return func(o *WriteOptions) error {
+		switch typ {
+		case bigquery.HourPartitioningType, bigquery.DayPartitioningType, bigquery.MonthPartitioningType, bigquery.YearPartitioningType:
+		default:
+			return errors.Errorf("unsupported time partitioning type: %v", typ)
+		}
+		if expiration < 0 {
+			return errors.Errorf("invalid partition expiration: %v", expiration)
+		}
+		o.TimePartitioning = &bigquery.TimePartitioning{Type: typ, Field: column, Expiration: expiration}
+		return nil
+	}
+}
+
+// WithClustering clusters created tables by the given columns, in order. At
+// most 4 columns can be given.
+func WithClustering(columns ...string) func(*WriteOptions) error {
+	return func(o *WriteOptions) error {
+		if len(columns) == 0 || len(columns) > 4 {
+			return errors.Errorf("clustering requires 1 to 4 columns, got %v", len(columns))
+		}
+		o.Clustering = &bigquery.Clustering{Fields: columns}
+		return nil
+	}
+}
+
+// WithDestinationSchema sets the schema of a table, as "<project>:<dataset>.<table>".
+// The schema is used to create the table, and only the fields of the
+// element type in the schema are written to it, so that destinations can
+// have different subsets of the columns.
+func WithDestinationSchema(table string, schema bigquery.Schema) func(*WriteOptions) error {
+	return func(o *WriteOptions) error {
+		if _, err := NewQualifiedTableName(table); err != nil {
+			return err
+		}
+		if o.Schemas == nil {
+			o.Schemas = make(map[string]bigquery.Schema)
+		}
+		o.Schemas[table] = schema
+		return nil
+	}
+}
 
 // Write writes the elements of the given PCollection<T> to bigquery. T is required
-// to be the schema type.
-func Write(s beam.Scope, project, table string, col beam.PCollection) {
+// to be the schema type. The table is created if missing and rows are appended
+// to it, unless other dispositions are set with WithCreateDisposition and
+// WithWriteDisposition. Created tables are partitioned and clustered as set by
+// WithTimePartitioning and WithClustering.
+func Write(s beam.Scope, project, table string, col beam.PCollection, options ...func(*WriteOptions) error) {
 	t := col.Type().Type()
 	mustInferSchema(t)
 	qn := mustParseTable(table)
+	opts := newWriteOptions(options)
 
 	s = s.Scope("bigquery.Write")
 
@@ -205,7 +384,7 @@ func Write(s beam.Scope, project, table string, col beam.PCollection) {
 
 	pre := beam.AddFixedKey(s, col)
 	post := beam.GroupByKey(s, pre)
-	beam.ParDo0(s, &writeFn{Project: project, Table: qn, Type: beam.EncodedType{T: t}}, post)
+	beam.ParDo0(s, &writeFn{Project: project, Table: qn, Type: beam.EncodedType{T: t}, Options: opts}, post)
 }
 
 type writeFn struct {
@@ -215,6 +394,8 @@ type writeFn struct {
 	Table QualifiedTableName `json:"table"`
 	// Type is the encoded schema type.
 	Type beam.EncodedType `json:"type"`
+	// Options specifies additional write options.
+	Options WriteOptions `json:"options"`
 }
 
 // Approximate the size of an element as it would appear in a BQ insert request.
@@ -251,63 +432,205 @@ func (f *writeFn) ProcessElement(ctx context.Context, _ int, iter func(*beam.X)
 	}
 	defer client.Close()
 
-	// TODO(herohde) 7/14/2017: should we create datasets? For now, "no".
+	schema := mustInferSchema(f.Type.T)
+	table, err := prepareTable(ctx, client, f.Table, schema, f.Options)
+	if err != nil {
+		return err
+	}
 
-	dataset := client.DatasetInProject(f.Table.Project, f.Table.Dataset)
-	if _, err := dataset.Metadata(ctx); err != nil {
+	return insertRows(ctx, table, f.Table, schema, iter, nil)
+}
+
+// FailedRow is a row that couldn't be written to BigQuery, such as a row that
+// doesn't match the schema of its table. It's a schema type, so failed rows
+// can be written to a dead-letter table with Write.
+type FailedRow struct {
+	// Table is the table the row was written to, as "<project>:<dataset>.<table>".
+	Table string `bigquery:"table"`
+	// Row is the row, as JSON.
+	Row string `bigquery:"row"`
+	// Error describes why the row couldn't be written.
+	Error string `bigquery:"error"`
+}
+
+// newFailedRow returns the failed row for the saver's row, which failed with
+// the given error.
+func newFailedRow(qn QualifiedTableName, saver *bigquery.StructSaver, err error) FailedRow {
+	var row string
+	if values, _, serr := saver.Save(); serr == nil {
+		if data, jerr := json.Marshal(values); jerr == nil {
+			row = string(data)
+		}
+	}
+	if row == "" {
+		row = fmt.Sprintf("%+v", saver.Struct)
+	}
+	return FailedRow{Table: qn.String(), Row: row, Error: err.Error()}
+}
+
+// WriteWithFailures writes the elements of the given PCollection<T> to bigquery,
+// like Write, but returns the rows that couldn't be written as a
+// PCollection<FailedRow> instead of failing the write. Valid rows are written
+// even if other rows in their batch are invalid. Requests that fail as a
+// whole, such as when the table can't be created, still fail the write.
+func WriteWithFailures(s beam.Scope, project, table string, col beam.PCollection, options ...func(*WriteOptions) error) beam.PCollection {
+	t := col.Type().Type()
+	mustInferSchema(t)
+	qn := mustParseTable(table)
+	opts := newWriteOptions(options)
+
+	s = s.Scope("bigquery.WriteWithFailures")
+
+	pre := beam.AddFixedKey(s, col)
+	post := beam.GroupByKey(s, pre)
+	return beam.ParDo(s, &writeWithFailuresFn{Project: project, Table: qn, Type: beam.EncodedType{T: t}, Options: opts}, post)
+}
+
+type writeWithFailuresFn struct {
+	// Project is the project
+	Project string `json:"project"`
+	// Table is the qualified table identifier.
+	Table QualifiedTableName `json:"table"`
+	// Type is the encoded schema type.
+	Type beam.EncodedType `json:"type"`
+	// Options specifies additional write options.
+	Options WriteOptions `json:"options"`
+}
+
+func (f *writeWithFailuresFn) ProcessElement(ctx context.Context, _ int, iter func(*beam.X) bool, emit func(FailedRow)) error {
+	client, err := bigquery.NewClient(ctx, f.Project)
+	if err != nil {
 		return err
 	}
+	defer client.Close()
 
 	schema := mustInferSchema(f.Type.T)
-	table := dataset.Table(f.Table.Table)
+	table, err := prepareTable(ctx, client, f.Table, schema, f.Options)
+	if err != nil {
+		return err
+	}
+	return insertRows(ctx, table, f.Table, schema, iter, emit)
+}
+
+// prepareTable returns the table, after applying the dispositions of the
+// options. Missing tables are created with the partitioning and clustering
+// of the options.
+func prepareTable(ctx context.Context, client *bigquery.Client, qn QualifiedTableName, schema bigquery.Schema, opts WriteOptions) (*bigquery.Table, error) {
+	table := client.DatasetInProject(qn.Project, qn.Dataset).Table(qn.Table)
+	md, err := table.Metadata(ctx)
+	if err != nil {
+		if !isNotFound(err) {
+			return nil, err
+		}
+		if opts.CreateDisposition == bigquery.CreateNever {
+			return nil, errors.New("table doesn't exist, and the create disposition is CREATE_NEVER")
+		}
+		return createTableIfMissing(ctx, client, qn, opts.tableMetadata(schema))
+	}
+
+	switch opts.WriteDisposition {
+	case bigquery.WriteEmpty:
+		if md.NumRows > 0 || md.StreamingBuffer != nil {
+			return nil, errors.New("table isn't empty, and the write disposition is WRITE_EMPTY")
+		}
+	case bigquery.WriteTruncate:
+		log.Infof(ctx, "Truncating table %v", qn)
+		q := client.Query(fmt.Sprintf("TRUNCATE TABLE `%v.%v.%v`", qn.Project, qn.Dataset, qn.Table))
+		job, err := q.Run(ctx)
+		if err != nil {
+			return nil, err
+		}
+		status, err := job.Wait(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if err := status.Err(); err != nil {
+			return nil, err
+		}
+	}
+	return table, nil
+}
+
+// createTableIfMissing returns the table, which it creates with the given
+// metadata if it doesn't exist. The dataset must exist.
+func createTableIfMissing(ctx context.Context, client *bigquery.Client, qn QualifiedTableName, md *bigquery.TableMetadata) (*bigquery.Table, error) {
+	// TODO(herohde) 7/14/2017: should we create datasets? For now, "no".
+
+	dataset := client.DatasetInProject(qn.Project, qn.Dataset)
+	if _, err := dataset.Metadata(ctx); err != nil {
+		return nil, err
+	}
+
+	table := dataset.Table(qn.Table)
 	if _, err := table.Metadata(ctx); err != nil {
 		if !isNotFound(err) {
-			return err
+			return nil, err
 		}
-		if err := table.Create(ctx, &bigquery.TableMetadata{Schema: schema}); err != nil {
-			return err
+		if err := table.Create(ctx, md); err != nil {
+			return nil, err
 		}
 	}
+	return table, nil
+}
 
-	var data []reflect.Value
+// insertRows inserts the rows of iter into the table, in batches that comply
+// with the BigQuery limits. If failed is non-nil, rows that can't be inserted
+// are passed to it instead of failing the write.
+func insertRows(ctx context.Context, table *bigquery.Table, qn QualifiedTableName, schema bigquery.Schema, iter func(*beam.X) bool, failed func(FailedRow)) error {
+	var rows []*bigquery.StructSaver
 	// This stores the running byte size estimate of a BQ request.
 	size := writeOverheadBytes
 
 	var val beam.X
 	for iter(&val) {
-		current, err := getInsertSize(val.(interface{}), schema)
+		current, err := getInsertSize(val, schema)
 		if err != nil {
-			return errors.Wrapf(err, "bigquery write error")
+			if failed == nil {
+				return errors.Wrapf(err, "bigquery write error")
+			}
+			failed(newFailedRow(qn, &bigquery.StructSaver{Struct: val, Schema: schema}, err))
+			continue
 		}
-		if len(data)+1 > writeRowLimit || size+current > writeSizeLimit {
+		if len(rows) > 0 && (len(rows)+1 > writeRowLimit || size+current > writeSizeLimit) {
 			// Write rows in batches to comply with BQ limits.
-			if err := put(ctx, table, f.Type.T, data); err != nil {
-				return errors.Wrapf(err, "bigquery write error [len=%d, size=%d]", len(data), size)
+			if err := putRows(ctx, table, qn, rows, failed); err != nil {
+				return errors.Wrapf(err, "bigquery write error [table=%v, len=%d, size=%d]", qn, len(rows), size)
 			}
-			data = nil
+			rows = nil
 			size = writeOverheadBytes
-		} else {
-			data = append(data, reflect.ValueOf(val.(interface{})))
-			size += current
 		}
+		rows = append(rows, &bigquery.StructSaver{Struct: val, Schema: schema})
+		size += current
 	}
-	if len(data) == 0 {
+	if len(rows) == 0 {
 		return nil
 	}
-	if err := put(ctx, table, f.Type.T, data); err != nil {
-		return errors.Wrapf(err, "bigquery write error [len=%d, size=%d]", len(data), size)
+	if err := putRows(ctx, table, qn, rows, failed); err != nil {
+		return errors.Wrapf(err, "bigquery write error [table=%v, len=%d, size=%d]", qn, len(rows), size)
 	}
 	return nil
 }
 
-func put(ctx context.Context, table *bigquery.Table, t reflect.Type, data []reflect.Value) error {
-	// list : []T to allow Put to infer the schema
-	list := reflectx.MakeSlice(t, data...).Interface()
-
+// putRows inserts the rows into the table. If failed is non-nil, invalid rows
+// are skipped and passed to it, and only errors of the request are returned.
+func putRows(ctx context.Context, table *bigquery.Table, qn QualifiedTableName, rows []*bigquery.StructSaver, failed func(FailedRow)) error {
 	ctx, cancel := context.WithTimeout(ctx, 10*time.Minute)
 	defer cancel()
 
-	return table.Uploader().Put(ctx, list)
+	inserter := table.Inserter()
+	inserter.SkipInvalidRows = failed != nil
+	err := inserter.Put(ctx, rows)
+	if err == nil || failed == nil {
+		return err
+	}
+	perr, ok := err.(bigquery.PutMultiError)
+	if !ok {
+		return err
+	}
+	for _, e := range perr {
+		failed(newFailedRow(qn, rows[e.RowIndex], e.Errors))
+	}
+	return nil
 }
 
 func isNotFound(err error) bool {
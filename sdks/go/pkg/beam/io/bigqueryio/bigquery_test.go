@@ -15,7 +15,14 @@
 
 package bigqueryio
 
-import "testing"
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+)
 
 func TestNewQualifiedTableName(t *testing.T) {
 	tests := []struct {
@@ -36,3 +43,97 @@ func TestNewQualifiedTableName(t *testing.T) {
 		}
 	}
 }
+
+func TestQueryOptions(t *testing.T) {
+	var qo QueryOptions
+	for _, opt := range []func(*QueryOptions) error{UseStandardSQL(), UseBatchPriority(), WithTempDataset("a:b")} {
+		if err := opt(&qo); err != nil {
+			t.Fatalf("option failed: %v", err)
+		}
+	}
+	want := QueryOptions{UseStandardSQL: true, Priority: bigquery.BatchPriority, TempDataset: "a:b"}
+	if qo != want {
+		t.Errorf("QueryOptions = %+v, want %+v", qo, want)
+	}
+
+	for _, dataset := range []string{"b", "a:", ":b"} {
+		if err := WithTempDataset(dataset)(&qo); err == nil {
+			t.Errorf("WithTempDataset(%v) succeeded, want error", dataset)
+		}
+	}
+}
+
+func TestWriteOptions(t *testing.T) {
+	schema := bigquery.Schema{{Name: "a", Type: bigquery.StringFieldType}}
+	var wo WriteOptions
+	for _, opt := range []func(*WriteOptions) error{
+		WithCreateDisposition(bigquery.CreateNever),
+		WithWriteDisposition(bigquery.WriteTruncate),
+		WithDestinationSchema("a:b.c", schema),
+	} {
+		if err := opt(&wo); err != nil {
+			t.Fatalf("option failed: %v", err)
+		}
+	}
+	if wo.CreateDisposition != bigquery.CreateNever || wo.WriteDisposition != bigquery.WriteTruncate {
+		t.Errorf("WriteOptions = %+v, want CREATE_NEVER and WRITE_TRUNCATE", wo)
+	}
+	if got := wo.Schemas["a:b.c"]; len(got) != 1 || got[0] != schema[0] {
+		t.Errorf("WriteOptions.Schemas[a:b.c] = %v, want %v", got, schema)
+	}
+
+	if err := WithCreateDisposition("CREATE_SOMETIMES")(&wo); err == nil {
+		t.Error("WithCreateDisposition(CREATE_SOMETIMES) succeeded, want error")
+	}
+	if err := WithWriteDisposition("WRITE_SOMETIMES")(&wo); err == nil {
+		t.Error("WithWriteDisposition(WRITE_SOMETIMES) succeeded, want error")
+	}
+	if err := WithDestinationSchema("c", schema)(&wo); err == nil {
+		t.Error("WithDestinationSchema(c) succeeded, want error")
+	}
+}
+
+func TestWriteOptions_TableMetadata(t *testing.T) {
+	schema := bigquery.Schema{{Name: "ts", Type: bigquery.TimestampFieldType}, {Name: "a", Type: bigquery.StringFieldType}}
+	wo := newWriteOptions([]func(*WriteOptions) error{
+		WithTimePartitioning("ts", bigquery.DayPartitioningType, 24*time.Hour),
+		WithClustering("a"),
+	})
+	md := wo.tableMetadata(schema)
+	want := bigquery.TimePartitioning{Type: bigquery.DayPartitioningType, Field: "ts", Expiration: 24 * time.Hour}
+	if md.TimePartitioning == nil || *md.TimePartitioning != want {
+		t.Errorf("tableMetadata().TimePartitioning = %v, want %v", md.TimePartitioning, want)
+	}
+	if md.Clustering == nil || !reflect.DeepEqual(md.Clustering.Fields, []string{"a"}) {
+		t.Errorf("tableMetadata().Clustering = %v, want [a]", md.Clustering)
+	}
+	if wo.CreateDisposition != bigquery.CreateIfNeeded || wo.WriteDisposition != bigquery.WriteAppend {
+		t.Errorf("newWriteOptions() = %+v, want CREATE_IF_NEEDED and WRITE_APPEND", wo)
+	}
+
+	if err := WithTimePartitioning("ts", "WEEK", 0)(&wo); err == nil {
+		t.Error("WithTimePartitioning(WEEK) succeeded, want error")
+	}
+	if err := WithTimePartitioning("ts", bigquery.DayPartitioningType, -time.Hour)(&wo); err == nil {
+		t.Error("WithTimePartitioning(-1h) succeeded, want error")
+	}
+	if err := WithClustering("a", "b", "c", "d", "e")(&wo); err == nil {
+		t.Error("WithClustering() with 5 columns succeeded, want error")
+	}
+}
+
+func TestNewFailedRow(t *testing.T) {
+	type row struct {
+		Name  string `bigquery:"name"`
+		Count int64  `bigquery:"count"`
+	}
+	saver := &bigquery.StructSaver{Struct: row{Name: "a", Count: 2}, Schema: mustInferSchema(reflect.TypeOf(row{}))}
+	got := newFailedRow(QualifiedTableName{Project: "p", Dataset: "d", Table: "t"}, saver, errors.New("invalid row"))
+	want := FailedRow{Table: "p:d.t", Row: `{"count":2,"name":"a"}`, Error: "invalid row"}
+	if got != want {
+		t.Errorf("newFailedRow() = %+v, want %+v", got, want)
+	}
+
+	// FailedRow must be a schema type, to be written to a dead-letter table.
+	mustInferSchema(reflect.TypeOf(FailedRow{}))
+}
@@ -0,0 +1,108 @@
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements.  See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License.  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bigqueryio
+
+import (
+	"context"
+	"reflect"
+
+	"cloud.google.com/go/bigquery"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/core/funcx"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/core/util/reflectx"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/internal/errors"
+)
+
+func init() {
+	beam.RegisterType(reflect.TypeOf((*destinationFn)(nil)).Elem())
+	beam.RegisterType(reflect.TypeOf((*writeDynamicFn)(nil)).Elem())
+}
+
+// WriteDynamic writes the elements of the given PCollection<T> to the tables
+// chosen per element by the given destination function, which must be of
+// the form: T -> string, and return the table as "<project>:<dataset>.<table>".
+// T is required to be a schema type. For example:
+//
+//    bigqueryio.WriteDynamic(s, project, events, func(e Event) string {
+//        return fmt.Sprintf("%v:events.%v_%v", project, e.Tenant, e.Time.Format("20060102"))
+//    })
+//
+// Here, each event is written to a table per tenant and day.
+func WriteDynamic(s beam.Scope, project string, col beam.PCollection, fn interface{}, options ...func(*WriteOptions) error) {
+	t := col.Type().Type()
+	mustInferSchema(t)
+
+	s = s.Scope("bigquery.WriteDynamic")
+
+	funcx.MustSatisfy(fn, &funcx.Signature{Args: []reflect.Type{t}, Return: []reflect.Type{reflectx.String}})
+	opts := newWriteOptions(options)
+
+	// The rows of each table are grouped, so that each table is written by
+	// a single invocation, which applies its dispositions.
+	keyed := beam.ParDo(s, &destinationFn{Destination: beam.EncodedFunc{Fn: reflectx.MakeFunc(fn)}}, col)
+	grouped := beam.GroupByKey(s, keyed)
+	beam.ParDo0(s, &writeDynamicFn{Project: project, Type: beam.EncodedType{T: t}, Options: opts}, grouped)
+}
+
+// destinationFn keys each element by its table.
+type destinationFn struct {
+	// Destination is the encoded destination function.
+	Destination beam.EncodedFunc `json:"destination"`
+
+	fn reflectx.Func1x1
+}
+
+func (f *destinationFn) Setup() {
+	f.fn = reflectx.ToFunc1x1(f.Destination.Fn)
+}
+
+func (f *destinationFn) ProcessElement(val beam.X, emit func(string, beam.X)) {
+	emit(f.fn.Call1x1(val).(string), val)
+}
+
+// writeDynamicFn writes the elements of a table.
+type writeDynamicFn struct {
+	// Project is the project used to run the writes.
+	Project string `json:"project"`
+	// Type is the encoded schema type.
+	Type beam.EncodedType `json:"type"`
+	// Options specifies additional write options.
+	Options WriteOptions `json:"options"`
+}
+
+func (f *writeDynamicFn) ProcessElement(ctx context.Context, dest string, iter func(*beam.X) bool) error {
+	qn, err := NewQualifiedTableName(dest)
+	if err != nil {
+		return errors.Wrap(err, "invalid destination")
+	}
+	schema, ok := f.Options.Schemas[dest]
+	if !ok {
+		schema = mustInferSchema(f.Type.T)
+	}
+
+	client, err := bigquery.NewClient(ctx, f.Project)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	table, err := prepareTable(ctx, client, qn, schema, f.Options)
+	if err != nil {
+		return errors.WithContextf(err, "preparing table %v", qn)
+	}
+
+	return insertRows(ctx, table, qn, schema, iter, nil)
+}
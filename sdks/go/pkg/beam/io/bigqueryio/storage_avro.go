@@ -0,0 +1,243 @@
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements.  See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License.  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bigqueryio
+
+import (
+	"encoding/json"
+	"math/big"
+	"reflect"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/civil"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/internal/errors"
+	"github.com/linkedin/goavro"
+)
+
+// avroRowDecoder decodes the AVRO rows of the BigQuery Storage Read API into
+// values of a schema type.
+type avroRowDecoder struct {
+	codec  *goavro.Codec
+	schema interface{}
+	t      reflect.Type
+	// fields maps lower case column names to the indices of their fields in t.
+	fields map[string]int
+}
+
+// newAvroRowDecoder returns a decoder of rows with the given AVRO schema, as
+// returned in a read session, into values of type t.
+func newAvroRowDecoder(schema string, t reflect.Type) (*avroRowDecoder, error) {
+	codec, err := goavro.NewCodec(schema)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid read session schema")
+	}
+	var parsed interface{}
+	if err := json.Unmarshal([]byte(schema), &parsed); err != nil {
+		return nil, errors.Wrap(err, "invalid read session schema")
+	}
+	return &avroRowDecoder{codec: codec, schema: parsed, t: t, fields: columnFields(t)}, nil
+}
+
+// columnFields maps the lower case column names of a schema type to the
+// indices of their fields. As in the bigquery package, columns are named by
+// the bigquery tag of a field, or else its name.
+func columnFields(t reflect.Type) map[string]int {
+	fields := make(map[string]int)
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue // unexported
+		}
+		name := f.Name
+		if tag := strings.Split(f.Tag.Get("bigquery"), ",")[0]; tag == "-" {
+			continue
+		} else if tag != "" {
+			name = tag
+		}
+		fields[strings.ToLower(name)] = i
+	}
+	return fields
+}
+
+// Decode decodes the rows serialized in data, and calls emit for each.
+func (d *avroRowDecoder) Decode(data []byte, emit func(interface{})) error {
+	for len(data) > 0 {
+		native, rest, err := d.codec.NativeFromBinary(data)
+		if err != nil {
+			return errors.Wrap(err, "decoding bigquery row")
+		}
+		data = rest
+
+		row, err := avroValue(d.schema, native)
+		if err != nil {
+			return err
+		}
+		v, err := d.load(row.(map[string]interface{}))
+		if err != nil {
+			return err
+		}
+		emit(v)
+	}
+	return nil
+}
+
+// load converts a row to a value of the schema type. Each column is
+// converted to its field by encoding/json, so fields may be of the types
+// supported by the bigquery package, such as civil.Date or
+// bigquery.NullInt64, or of other types with compatible JSON encodings.
+// Columns without a field are ignored.
+func (d *avroRowDecoder) load(row map[string]interface{}) (interface{}, error) {
+	out := reflect.New(d.t).Elem()
+	for name, v := range row {
+		i, ok := d.fields[strings.ToLower(name)]
+		if !ok || v == nil {
+			continue
+		}
+		b, err := json.Marshal(v)
+		if err != nil {
+			return nil, errors.Wrapf(err, "converting column %v", name)
+		}
+		if err := json.Unmarshal(b, out.Field(i).Addr().Interface()); err != nil {
+			return nil, errors.Wrapf(err, "converting column %v to field %v", name, d.t.Field(i).Name)
+		}
+	}
+	return out.Interface(), nil
+}
+
+// avroValue converts a value decoded by goavro to a value with the JSON
+// encoding of the corresponding type of the bigquery package, guided by its
+// AVRO schema. Nullable values, which are unions with null, are unwrapped.
+func avroValue(schema, v interface{}) (interface{}, error) {
+	if v == nil {
+		return nil, nil
+	}
+	switch s := schema.(type) {
+	case []interface{}:
+		// A union, which goavro decodes as a map from the type's name to the
+		// value.
+		m, ok := v.(map[string]interface{})
+		if !ok || len(m) != 1 {
+			return nil, errors.Errorf("invalid union value %v", v)
+		}
+		for name, value := range m {
+			for _, branch := range s {
+				if avroTypeName(branch) == name {
+					return avroValue(branch, value)
+				}
+			}
+			return nil, errors.Errorf("union value of unknown type %v", name)
+		}
+	case map[string]interface{}:
+		switch s["type"] {
+		case "record":
+			m, ok := v.(map[string]interface{})
+			if !ok {
+				return nil, errors.Errorf("invalid record value %v", v)
+			}
+			fields, _ := s["fields"].([]interface{})
+			out := make(map[string]interface{}, len(fields))
+			for _, f := range fields {
+				field := f.(map[string]interface{})
+				name := field["name"].(string)
+				value, err := avroValue(field["type"], m[name])
+				if err != nil {
+					return nil, errors.WithContextf(err, "converting field %v", name)
+				}
+				out[name] = value
+			}
+			return out, nil
+		case "array":
+			items, ok := v.([]interface{})
+			if !ok {
+				return nil, errors.Errorf("invalid array value %v", v)
+			}
+			out := make([]interface{}, len(items))
+			for i, item := range items {
+				value, err := avroValue(s["items"], item)
+				if err != nil {
+					return nil, err
+				}
+				out[i] = value
+			}
+			return out, nil
+		}
+		return logicalValue(s, v)
+	}
+	return v, nil
+}
+
+// logicalValue converts a value of a primitive type with a logical type.
+func logicalValue(schema map[string]interface{}, v interface{}) (interface{}, error) {
+	switch schema["logicalType"] {
+	case "timestamp-micros":
+		micros, ok := v.(int64)
+		if !ok {
+			return nil, errors.Errorf("invalid timestamp %v", v)
+		}
+		return time.Unix(micros/1e6, (micros%1e6)*1e3).UTC(), nil
+	case "date":
+		days, ok := v.(int32)
+		if !ok {
+			return nil, errors.Errorf("invalid date %v", v)
+		}
+		return epochDate.AddDays(int(days)), nil
+	case "time-micros":
+		micros, ok := v.(int64)
+		if !ok {
+			return nil, errors.Errorf("invalid time %v", v)
+		}
+		return civil.TimeOf(time.Unix(micros/1e6, (micros%1e6)*1e3).UTC()), nil
+	case "decimal":
+		b, ok := v.([]byte)
+		if !ok {
+			return nil, errors.Errorf("invalid decimal %v", v)
+		}
+		scale, _ := schema["scale"].(float64)
+		return decodeDecimal(b, int(scale)).FloatString(int(scale)), nil
+	default:
+		return v, nil
+	}
+}
+
+// avroTypeName returns the name goavro uses for a type in union values.
+func avroTypeName(schema interface{}) string {
+	switch s := schema.(type) {
+	case string:
+		return s
+	case map[string]interface{}:
+		if name, ok := s["name"].(string); ok {
+			if ns, ok := s["namespace"].(string); ok && ns != "" {
+				return ns + "." + name
+			}
+			return name
+		}
+		t, _ := s["type"].(string)
+		return t
+	default:
+		return ""
+	}
+}
+
+// decodeDecimal decodes an AVRO decimal, which is its unscaled value as a
+// big-endian two's complement integer.
+func decodeDecimal(b []byte, scale int) *big.Rat {
+	n := new(big.Int).SetBytes(b)
+	if len(b) > 0 && b[0]&0x80 != 0 {
+		n.Sub(n, new(big.Int).Lsh(big.NewInt(1), uint(8*len(b))))
+	}
+	denom := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(scale)), nil)
+	return new(big.Rat).SetFrac(n, denom)
+}
@@ -0,0 +1,115 @@
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements.  See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License.  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bigqueryio
+
+import (
+	"math/big"
+	"reflect"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+	"cloud.google.com/go/civil"
+	"github.com/linkedin/goavro"
+)
+
+// sessionSchema is an AVRO schema as returned by a read session.
+const sessionSchema = `{
+	"type": "record",
+	"name": "__root__",
+	"fields": [
+		{"name": "name", "type": ["null", "string"]},
+		{"name": "count", "type": ["null", "long"]},
+		{"name": "created", "type": ["null", {"type": "long", "logicalType": "timestamp-micros"}]},
+		{"name": "day", "type": ["null", {"type": "int", "logicalType": "date"}]},
+		{"name": "price", "type": ["null", {"type": "bytes", "logicalType": "decimal", "precision": 38, "scale": 9}]},
+		{"name": "tags", "type": {"type": "array", "items": "string"}},
+		{"name": "address", "type": ["null", {"type": "record", "name": "address", "fields": [
+			{"name": "city", "type": ["null", "string"]}
+		]}]},
+		{"name": "ignored", "type": ["null", "string"]}
+	]
+}`
+
+type sessionRow struct {
+	Name    string
+	Total   bigquery.NullInt64 `bigquery:"count"`
+	Created time.Time
+	Day     civil.Date
+	Price   *big.Rat
+	Tags    []string
+	Address testAddress
+}
+
+func TestAvroRowDecoder(t *testing.T) {
+	codec, err := goavro.NewCodec(sessionSchema)
+	if err != nil {
+		t.Fatalf("NewCodec() failed: %v", err)
+	}
+	var data []byte
+	for _, native := range []map[string]interface{}{
+		{
+			"name":    map[string]interface{}{"string": "a"},
+			"count":   map[string]interface{}{"long": int64(2)},
+			"created": map[string]interface{}{"long": int64(10000005)},
+			"day":     map[string]interface{}{"int": int32(2)},
+			"price":   map[string]interface{}{"bytes": []byte{0xc4, 0x65, 0x36, 0x00}}, // -1.000000000
+			"tags":    []interface{}{"x", "y"},
+			"address": map[string]interface{}{"address": map[string]interface{}{"city": map[string]interface{}{"string": "c"}}},
+			"ignored": nil,
+		},
+		{
+			"name": nil, "count": nil, "created": nil, "day": nil, "price": nil,
+			"tags": []interface{}{}, "address": nil, "ignored": nil,
+		},
+	} {
+		if data, err = codec.BinaryFromNative(data, native); err != nil {
+			t.Fatalf("BinaryFromNative() failed: %v", err)
+		}
+	}
+
+	dec, err := newAvroRowDecoder(sessionSchema, reflect.TypeOf(sessionRow{}))
+	if err != nil {
+		t.Fatalf("newAvroRowDecoder() failed: %v", err)
+	}
+	var got []sessionRow
+	if err := dec.Decode(data, func(v interface{}) { got = append(got, v.(sessionRow)) }); err != nil {
+		t.Fatalf("Decode() failed: %v", err)
+	}
+	want := []sessionRow{
+		{
+			Name:    "a",
+			Total:   bigquery.NullInt64{Int64: 2, Valid: true},
+			Created: time.Unix(10, 5000).UTC(),
+			Day:     civil.Date{Year: 1970, Month: time.January, Day: 3},
+			Price:   big.NewRat(-1, 1),
+			Tags:    []string{"x", "y"},
+			Address: testAddress{City: "c"},
+		},
+		{Tags: []string{}},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("Decode() = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i].Price != nil && want[i].Price != nil && got[i].Price.Cmp(want[i].Price) == 0 {
+			got[i].Price = want[i].Price
+		}
+		if !reflect.DeepEqual(got[i], want[i]) {
+			t.Errorf("Decode() row %v = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
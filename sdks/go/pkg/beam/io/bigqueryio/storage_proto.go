@@ -0,0 +1,317 @@
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements.  See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License.  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bigqueryio
+
+import (
+	"math/big"
+	"reflect"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+	"cloud.google.com/go/bigquery/storage/managedwriter/adapt"
+	"cloud.google.com/go/civil"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/internal/errors"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// epochDate is the date from which DATE values count days.
+var epochDate = civil.Date{Year: 1970, Month: time.January, Day: 1}
+
+// numericScale and bigNumericScale are the number of decimal digits after
+// the point of NUMERIC and BIGNUMERIC values.
+const (
+	numericScale    = 9
+	bigNumericScale = 38
+)
+
+// rowEncoder encodes rows of a schema type as the protocol buffer messages
+// expected by the BigQuery Storage Write API.
+type rowEncoder struct {
+	schema     bigquery.Schema
+	descriptor protoreflect.MessageDescriptor
+	// proto is the self-contained descriptor of the messages, as sent to the
+	// Storage Write API.
+	proto *descriptorpb.DescriptorProto
+}
+
+// newRowEncoder returns an encoder for rows with the given schema.
+func newRowEncoder(schema bigquery.Schema) (*rowEncoder, error) {
+	ts, err := adapt.BQSchemaToStorageTableSchema(schema)
+	if err != nil {
+		return nil, err
+	}
+	d, err := adapt.StorageSchemaToProto2Descriptor(ts, "root")
+	if err != nil {
+		return nil, err
+	}
+	md, ok := d.(protoreflect.MessageDescriptor)
+	if !ok {
+		return nil, errors.Errorf("schema converted to %T, not a message descriptor", d)
+	}
+	dp, err := adapt.NormalizeDescriptor(md)
+	if err != nil {
+		return nil, err
+	}
+	return &rowEncoder{schema: schema, descriptor: md, proto: dp}, nil
+}
+
+// Encode encodes a row, which is a value of a schema type.
+func (e *rowEncoder) Encode(v interface{}) ([]byte, error) {
+	row, _, err := (&bigquery.StructSaver{Schema: e.schema, Struct: v}).Save()
+	if err != nil {
+		return nil, err
+	}
+	msg, err := rowMessage(e.descriptor, e.schema, row)
+	if err != nil {
+		return nil, err
+	}
+	return proto.Marshal(msg)
+}
+
+// rowMessage converts a row saved by the bigquery package to a message.
+func rowMessage(md protoreflect.MessageDescriptor, schema bigquery.Schema, row map[string]bigquery.Value) (*dynamicpb.Message, error) {
+	msg := dynamicpb.NewMessage(md)
+	for _, fs := range schema {
+		v, ok := row[fs.Name]
+		if !ok || v == nil {
+			continue
+		}
+		fd := md.Fields().ByName(protoreflect.Name(strings.ToLower(fs.Name)))
+		if fd == nil {
+			return nil, errors.Errorf("field %v is missing from the message descriptor", fs.Name)
+		}
+		if fs.Repeated {
+			list := msg.Mutable(fd).List()
+			rv := reflect.ValueOf(v)
+			if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+				return nil, errors.Errorf("repeated field %v has non-list value %v", fs.Name, v)
+			}
+			for i := 0; i < rv.Len(); i++ {
+				pv, valid, err := fieldValue(fd, fs, rv.Index(i).Interface())
+				if err != nil {
+					return nil, err
+				}
+				if !valid {
+					return nil, errors.Errorf("repeated field %v has a null element", fs.Name)
+				}
+				list.Append(pv)
+			}
+			continue
+		}
+		pv, valid, err := fieldValue(fd, fs, v)
+		if err != nil {
+			return nil, err
+		}
+		if valid {
+			msg.Set(fd, pv)
+		}
+	}
+	return msg, nil
+}
+
+// fieldValue converts a single value of a field to a protocol buffer value.
+// It returns false if the value is null.
+func fieldValue(fd protoreflect.FieldDescriptor, fs *bigquery.FieldSchema, v interface{}) (protoreflect.Value, bool, error) {
+	v, valid := unwrapNull(v)
+	if !valid {
+		return protoreflect.Value{}, false, nil
+	}
+	fail := func() (protoreflect.Value, bool, error) {
+		return protoreflect.Value{}, false, errors.Errorf("field %v of type %v has unsupported value %v of type %T", fs.Name, fs.Type, v, v)
+	}
+	rv := reflect.ValueOf(v)
+
+	switch fs.Type {
+	case bigquery.StringFieldType, bigquery.GeographyFieldType:
+		if rv.Kind() != reflect.String {
+			return fail()
+		}
+		return protoreflect.ValueOfString(rv.String()), true, nil
+	case bigquery.BytesFieldType:
+		b, ok := v.([]byte)
+		if !ok {
+			return fail()
+		}
+		return protoreflect.ValueOfBytes(b), true, nil
+	case bigquery.IntegerFieldType:
+		switch rv.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			return protoreflect.ValueOfInt64(rv.Int()), true, nil
+		case reflect.Uint8, reflect.Uint16, reflect.Uint32:
+			return protoreflect.ValueOfInt64(int64(rv.Uint())), true, nil
+		}
+		return fail()
+	case bigquery.FloatFieldType:
+		if rv.Kind() != reflect.Float32 && rv.Kind() != reflect.Float64 {
+			return fail()
+		}
+		return protoreflect.ValueOfFloat64(rv.Float()), true, nil
+	case bigquery.BooleanFieldType:
+		if rv.Kind() != reflect.Bool {
+			return fail()
+		}
+		return protoreflect.ValueOfBool(rv.Bool()), true, nil
+	case bigquery.TimestampFieldType:
+		t, ok := v.(time.Time)
+		if !ok {
+			return fail()
+		}
+		return protoreflect.ValueOfInt64(t.UnixNano() / int64(time.Microsecond)), true, nil
+	case bigquery.DateFieldType:
+		d, ok := v.(civil.Date)
+		if !ok {
+			return fail()
+		}
+		return protoreflect.ValueOfInt32(int32(d.DaysSince(epochDate))), true, nil
+	case bigquery.TimeFieldType:
+		t, err := civilTime(v)
+		if err != nil {
+			return fail()
+		}
+		return protoreflect.ValueOfInt64(packTime(t)), true, nil
+	case bigquery.DateTimeFieldType:
+		dt, err := civilDateTime(v)
+		if err != nil {
+			return fail()
+		}
+		return protoreflect.ValueOfInt64(packDateTime(dt)), true, nil
+	case bigquery.NumericFieldType, bigquery.BigNumericFieldType:
+		r, err := rat(v)
+		if err != nil {
+			return fail()
+		}
+		scale := numericScale
+		if fs.Type == bigquery.BigNumericFieldType {
+			scale = bigNumericScale
+		}
+		return protoreflect.ValueOfBytes(encodeNumeric(r, scale)), true, nil
+	case bigquery.RecordFieldType:
+		m, ok := v.(map[string]bigquery.Value)
+		if !ok {
+			return fail()
+		}
+		nested, err := rowMessage(fd.Message(), fs.Schema, m)
+		if err != nil {
+			return protoreflect.Value{}, false, err
+		}
+		return protoreflect.ValueOfMessage(nested), true, nil
+	default:
+		return fail()
+	}
+}
+
+// unwrapNull returns the value of a bigquery.NullXxx type, and whether it is
+// valid. Other values are returned as is, and are valid.
+func unwrapNull(v interface{}) (interface{}, bool) {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Struct || rv.NumField() != 2 || rv.Type().PkgPath() != reflect.TypeOf(bigquery.NullInt64{}).PkgPath() {
+		return v, true
+	}
+	valid := rv.FieldByName("Valid")
+	if !valid.IsValid() || valid.Kind() != reflect.Bool {
+		return v, true
+	}
+	if !valid.Bool() {
+		return nil, false
+	}
+	return rv.Field(0).Interface(), true
+}
+
+// civilTime returns a TIME value, which the bigquery package saves as a
+// string.
+func civilTime(v interface{}) (civil.Time, error) {
+	switch v := v.(type) {
+	case civil.Time:
+		return v, nil
+	case string:
+		return civil.ParseTime(v)
+	default:
+		return civil.Time{}, errors.Errorf("unsupported time %v", v)
+	}
+}
+
+// civilDateTime returns a DATETIME value, which the bigquery package saves
+// as a string with a space between the date and the time.
+func civilDateTime(v interface{}) (civil.DateTime, error) {
+	switch v := v.(type) {
+	case civil.DateTime:
+		return v, nil
+	case string:
+		return civil.ParseDateTime(strings.Replace(v, " ", "T", 1))
+	default:
+		return civil.DateTime{}, errors.Errorf("unsupported datetime %v", v)
+	}
+}
+
+// rat returns a NUMERIC or BIGNUMERIC value, which the bigquery package
+// saves as a string.
+func rat(v interface{}) (*big.Rat, error) {
+	switch v := v.(type) {
+	case *big.Rat:
+		return v, nil
+	case string:
+		r, ok := new(big.Rat).SetString(v)
+		if !ok {
+			return nil, errors.Errorf("invalid numeric %v", v)
+		}
+		return r, nil
+	default:
+		return nil, errors.Errorf("unsupported numeric %v", v)
+	}
+}
+
+// packTime encodes a TIME value as a bit field of its hour, minute, second
+// and microseconds, as expected by the Storage Write API.
+func packTime(t civil.Time) int64 {
+	seconds := int64(t.Hour)<<12 | int64(t.Minute)<<6 | int64(t.Second)
+	return seconds<<20 | int64(t.Nanosecond/1000)
+}
+
+// packDateTime encodes a DATETIME value as a bit field of its date and time,
+// as expected by the Storage Write API.
+func packDateTime(dt civil.DateTime) int64 {
+	seconds := int64(dt.Date.Year)<<26 | int64(dt.Date.Month)<<22 | int64(dt.Date.Day)<<17 |
+		int64(dt.Time.Hour)<<12 | int64(dt.Time.Minute)<<6 | int64(dt.Time.Second)
+	return seconds<<20 | int64(dt.Time.Nanosecond/1000)
+}
+
+// encodeNumeric encodes a NUMERIC or BIGNUMERIC value as its value scaled by
+// 10^scale, as a little-endian two's complement integer.
+func encodeNumeric(r *big.Rat, scale int) []byte {
+	scaled := new(big.Rat).Mul(r, new(big.Rat).SetInt(new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(scale)), nil)))
+	n := new(big.Int).Quo(scaled.Num(), scaled.Denom())
+
+	// Two's complement of negative values: invert the bits of |n|-1.
+	negative := n.Sign() < 0
+	if negative {
+		n.Neg(n).Sub(n, big.NewInt(1))
+	}
+	b := n.Bytes()
+	out := make([]byte, len(b)+1) // An extra byte for the sign bit.
+	for i := range b {
+		out[i] = b[len(b)-1-i]
+	}
+	if negative {
+		for i := range out {
+			out[i] = ^out[i]
+		}
+	}
+	return out
+}
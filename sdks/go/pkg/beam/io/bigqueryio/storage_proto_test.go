@@ -0,0 +1,140 @@
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements.  See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License.  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bigqueryio
+
+import (
+	"bytes"
+	"math/big"
+	"reflect"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+	"cloud.google.com/go/civil"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+type testAddress struct {
+	City string
+}
+
+type testRow struct {
+	Name     string
+	Count    int64
+	Score    float64
+	Tags     []string
+	Created  time.Time
+	Day      civil.Date
+	Missing  bigquery.NullInt64
+	Present  bigquery.NullString
+	Address  testAddress
+	Previous []testAddress
+}
+
+func TestRowEncoder(t *testing.T) {
+	enc, err := newRowEncoder(mustInferSchema(reflect.TypeOf(testRow{})))
+	if err != nil {
+		t.Fatalf("newRowEncoder() failed: %v", err)
+	}
+	row := testRow{
+		Name:     "a",
+		Count:    2,
+		Score:    1.5,
+		Tags:     []string{"x", "y"},
+		Created:  time.Unix(10, 5000),
+		Day:      civil.Date{Year: 1970, Month: time.January, Day: 3},
+		Present:  bigquery.NullString{StringVal: "b", Valid: true},
+		Address:  testAddress{City: "c"},
+		Previous: []testAddress{{City: "d"}},
+	}
+	data, err := enc.Encode(row)
+	if err != nil {
+		t.Fatalf("Encode(%v) failed: %v", row, err)
+	}
+	msg := dynamicpb.NewMessage(enc.descriptor)
+	if err := proto.Unmarshal(data, msg); err != nil {
+		t.Fatalf("Unmarshal() failed: %v", err)
+	}
+
+	get := func(m protoreflect.Message, name string) protoreflect.Value {
+		return m.Get(m.Descriptor().Fields().ByName(protoreflect.Name(name)))
+	}
+	has := func(m protoreflect.Message, name string) bool {
+		return m.Has(m.Descriptor().Fields().ByName(protoreflect.Name(name)))
+	}
+	if got, want := get(msg, "name").String(), "a"; got != want {
+		t.Errorf("name = %v, want %v", got, want)
+	}
+	if got, want := get(msg, "count").Int(), int64(2); got != want {
+		t.Errorf("count = %v, want %v", got, want)
+	}
+	if got, want := get(msg, "score").Float(), 1.5; got != want {
+		t.Errorf("score = %v, want %v", got, want)
+	}
+	if got, want := get(msg, "tags").List().Len(), 2; got != want {
+		t.Errorf("len(tags) = %v, want %v", got, want)
+	}
+	if got, want := get(msg, "created").Int(), int64(10000005); got != want {
+		t.Errorf("created = %v, want %v", got, want)
+	}
+	if got, want := get(msg, "day").Int(), int64(2); got != want {
+		t.Errorf("day = %v, want %v", got, want)
+	}
+	if has(msg, "missing") {
+		t.Errorf("missing is set, want null")
+	}
+	if got, want := get(msg, "present").String(), "b"; got != want {
+		t.Errorf("present = %v, want %v", got, want)
+	}
+	if got, want := get(get(msg, "address").Message(), "city").String(), "c"; got != want {
+		t.Errorf("address.city = %v, want %v", got, want)
+	}
+	previous := get(msg, "previous").List()
+	if previous.Len() != 1 || get(previous.Get(0).Message(), "city").String() != "d" {
+		t.Errorf("previous = %v, want [{city: d}]", previous)
+	}
+}
+
+func TestPackTime(t *testing.T) {
+	tm := civil.Time{Hour: 12, Minute: 34, Second: 56, Nanosecond: 789012000}
+	if got, want := packTime(tm), int64(0xc8b8c0a14); got != want {
+		t.Errorf("packTime(%v) = %#x, want %#x", tm, got, want)
+	}
+	dt := civil.DateTime{Date: civil.Date{Year: 2022, Month: time.May, Day: 1}, Time: tm}
+	if got, want := packDateTime(dt), int64(0x1f9942c8b8c0a14); got != want {
+		t.Errorf("packDateTime(%v) = %#x, want %#x", dt, got, want)
+	}
+}
+
+func TestEncodeNumeric(t *testing.T) {
+	tests := []struct {
+		value string
+		want  []byte
+	}{
+		{"0", []byte{0}},
+		{"1", []byte{0x00, 0xca, 0x9a, 0x3b, 0x00}},
+		{"-1", []byte{0x00, 0x36, 0x65, 0xc4, 0xff}},
+		{"0.000000001", []byte{0x01, 0x00}},
+	}
+	for _, test := range tests {
+		r, _ := new(big.Rat).SetString(test.value)
+		if got := encodeNumeric(r, numericScale); !bytes.Equal(got, test.want) {
+			t.Errorf("encodeNumeric(%v) = %x, want %x", test.value, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,239 @@
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements.  See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License.  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bigqueryio
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"reflect"
+	"time"
+
+	bqstorage "cloud.google.com/go/bigquery/storage/apiv1"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/internal/errors"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/log"
+	storagepb "google.golang.org/genproto/googleapis/cloud/bigquery/storage/v1"
+)
+
+// storageReadRetries is the number of times reading a stream is resumed
+// after a transient failure.
+const storageReadRetries = 3
+
+func init() {
+	beam.RegisterType(reflect.TypeOf((*readStream)(nil)).Elem())
+	beam.RegisterType(reflect.TypeOf((*createReadSessionFn)(nil)).Elem())
+	beam.RegisterType(reflect.TypeOf((*readStreamFn)(nil)).Elem())
+}
+
+// StorageReadOptions represents additional options for reading with the
+// BigQuery Storage Read API.
+type StorageReadOptions struct {
+	// SelectedFields are the columns to read. If empty, the columns of the
+	// schema type are read.
+	SelectedFields []string
+	// RowRestriction is a SQL predicate that rows must satisfy to be read.
+	RowRestriction string
+	// MaxStreams is the maximum number of streams to read in parallel. If
+	// zero, the service chooses the number of streams.
+	MaxStreams int
+}
+
+// WithSelectedFields reads only the given columns. By default, the columns
+// of the schema type are read.
+func WithSelectedFields(fields ...string) func(*StorageReadOptions) error {
+	return func(o *StorageReadOptions) error {
+		o.SelectedFields = append(o.SelectedFields, fields...)
+		return nil
+	}
+}
+
+// WithRowRestriction reads only the rows that satisfy a SQL predicate, such
+// as "age > 18 AND country = 'NL'". The predicate is evaluated by BigQuery,
+// so rows that don't satisfy it are never transferred.
+func WithRowRestriction(restriction string) func(*StorageReadOptions) error {
+	return func(o *StorageReadOptions) error {
+		o.RowRestriction = restriction
+		return nil
+	}
+}
+
+// WithMaxStreams limits the number of streams the table is read with, which
+// are read in parallel.
+func WithMaxStreams(n int) func(*StorageReadOptions) error {
+	return func(o *StorageReadOptions) error {
+		if n < 1 {
+			return errors.Errorf("max streams must be positive: %v", n)
+		}
+		o.MaxStreams = n
+		return nil
+	}
+}
+
+// ReadStorage reads rows from the given table with the BigQuery Storage Read
+// API. The table must have a schema compatible with the given type, t, and
+// ReadStorage returns a PCollection<t>. Only the columns of t are read, unless
+// others are selected with WithSelectedFields, and rows can be filtered by
+// BigQuery with WithRowRestriction.
+//
+// The table is read with a read session, whose streams are read in
+// parallel. The billing project of the session is project.
+func ReadStorage(s beam.Scope, project, table string, t reflect.Type, options ...func(*StorageReadOptions) error) beam.PCollection {
+	schema := mustInferSchema(t)
+	qn := mustParseTable(table)
+
+	s = s.Scope("bigquery.ReadStorage")
+
+	opts := StorageReadOptions{}
+	for _, opt := range options {
+		if err := opt(&opts); err != nil {
+			panic(err)
+		}
+	}
+	if len(opts.SelectedFields) == 0 {
+		for _, f := range schema {
+			opts.SelectedFields = append(opts.SelectedFields, f.Name)
+		}
+	}
+
+	imp := beam.Impulse(s)
+	streams := beam.ParDo(s, &createReadSessionFn{Project: project, Table: qn, Options: opts}, imp)
+	streams = beam.Reshuffle(s, streams)
+	return beam.ParDo(s, &readStreamFn{Type: beam.EncodedType{T: t}}, streams, beam.TypeDefinition{Var: beam.XType, T: t})
+}
+
+// readStream is a stream of a read session.
+type readStream struct {
+	// Name is the name of the stream.
+	Name string `json:"name"`
+	// Schema is the AVRO schema of the session's rows.
+	Schema string `json:"schema"`
+}
+
+// createReadSessionFn creates a read session of the table, and emits its
+// streams.
+type createReadSessionFn struct {
+	// Project is the project
+	Project string `json:"project"`
+	// Table is the qualified table identifier.
+	Table QualifiedTableName `json:"table"`
+	// Options specifies additional read options.
+	Options StorageReadOptions `json:"options"`
+}
+
+func (f *createReadSessionFn) ProcessElement(ctx context.Context, _ []byte, emit func(readStream)) error {
+	client, err := bqstorage.NewBigQueryReadClient(ctx)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	session, err := client.CreateReadSession(ctx, &storagepb.CreateReadSessionRequest{
+		Parent: fmt.Sprintf("projects/%v", f.Project),
+		ReadSession: &storagepb.ReadSession{
+			Table:      storageTable(f.Table),
+			DataFormat: storagepb.DataFormat_AVRO,
+			ReadOptions: &storagepb.ReadSession_TableReadOptions{
+				SelectedFields: f.Options.SelectedFields,
+				RowRestriction: f.Options.RowRestriction,
+			},
+		},
+		MaxStreamCount: int32(f.Options.MaxStreams),
+	})
+	if err != nil {
+		return errors.Wrapf(err, "creating read session of %v", f.Table)
+	}
+
+	log.Infof(ctx, "Reading %v with %v streams", f.Table, len(session.GetStreams()))
+	schema := session.GetAvroSchema().GetSchema()
+	for _, stream := range session.GetStreams() {
+		emit(readStream{Name: stream.GetName(), Schema: schema})
+	}
+	return nil
+}
+
+// readStreamFn reads the rows of a stream of a read session.
+type readStreamFn struct {
+	// Type is the encoded schema type.
+	Type beam.EncodedType `json:"type"`
+
+	client *bqstorage.BigQueryReadClient
+}
+
+func (f *readStreamFn) Setup(ctx context.Context) error {
+	var err error
+	f.client, err = bqstorage.NewBigQueryReadClient(ctx)
+	return err
+}
+
+func (f *readStreamFn) ProcessElement(ctx context.Context, stream readStream, emit func(beam.X)) error {
+	dec, err := newAvroRowDecoder(stream.Schema, f.Type.T)
+	if err != nil {
+		return err
+	}
+
+	// A failed stream is resumed from the offset of the first row not yet
+	// read.
+	var offset int64
+	backoff := storageRetryBackoff
+	for attempt := 0; ; attempt++ {
+		n, err := f.read(ctx, stream.Name, offset, dec, emit)
+		offset += n
+		if err == nil {
+			return nil
+		}
+		if attempt >= storageReadRetries || !isRetryable(err) {
+			return errors.Wrapf(err, "reading bigquery stream %v", stream.Name)
+		}
+		log.Warnf(ctx, "reading bigquery stream failed at offset %v, retrying in %v: %v", offset, backoff, err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// read reads the rows of a stream from offset, and returns the number of
+// rows read.
+func (f *readStreamFn) read(ctx context.Context, name string, offset int64, dec *avroRowDecoder, emit func(beam.X)) (int64, error) {
+	rows, err := f.client.ReadRows(ctx, &storagepb.ReadRowsRequest{ReadStream: name, Offset: offset})
+	if err != nil {
+		return 0, err
+	}
+	var n int64
+	for {
+		resp, err := rows.Recv()
+		if err == io.EOF {
+			return n, nil
+		}
+		if err != nil {
+			return n, err
+		}
+		if err := dec.Decode(resp.GetAvroRows().GetSerializedBinaryRows(), func(v interface{}) { emit(v) }); err != nil {
+			return n, err
+		}
+		n += resp.GetRowCount()
+	}
+}
+
+func (f *readStreamFn) Teardown() error {
+	if f.client != nil {
+		return f.client.Close()
+	}
+	return nil
+}
@@ -0,0 +1,359 @@
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements.  See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License.  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bigqueryio
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+	"cloud.google.com/go/bigquery/storage/managedwriter"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/internal/errors"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/log"
+	storagepb "google.golang.org/genproto/googleapis/cloud/bigquery/storage/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// storageWriteSizeLimit is the maximum number of bytes allowed in an append
+// request of the Storage Write API.
+const storageWriteSizeLimit = 10000000
+
+// storageRetryBackoff is the delay before the first retry of a failed append,
+// which doubles with each further retry.
+const storageRetryBackoff = time.Second
+
+func init() {
+	beam.RegisterType(reflect.TypeOf((*storageWriteFn)(nil)).Elem())
+	beam.RegisterType(reflect.TypeOf((*storageStreamFn)(nil)).Elem())
+}
+
+// StorageWriteOptions represents additional options for writing with the
+// BigQuery Storage Write API.
+type StorageWriteOptions struct {
+	// AtLeastOnce writes rows to the table's default stream as they arrive,
+	// instead of committing each group of rows atomically.
+	AtLeastOnce bool
+	// MaxBatchRows is the maximum number of rows in an append request.
+	MaxBatchRows int
+	// MaxBatchBytes is the maximum size in bytes of an append request.
+	MaxBatchBytes int
+	// MaxRetries is the number of times a failed append request is retried.
+	MaxRetries int
+}
+
+// WithAtLeastOnce writes rows to the table's default stream as they arrive,
+// which is suitable for unbounded inputs. Rows may be written more than once
+// if appends are retried.
+func WithAtLeastOnce() func(*StorageWriteOptions) error {
+	return func(o *StorageWriteOptions) error {
+		o.AtLeastOnce = true
+		return nil
+	}
+}
+
+// WithMaxBatchRows sets the maximum number of rows in an append request.
+func WithMaxBatchRows(n int) func(*StorageWriteOptions) error {
+	return func(o *StorageWriteOptions) error {
+		if n < 1 {
+			return errors.Errorf("max batch rows must be positive: %v", n)
+		}
+		o.MaxBatchRows = n
+		return nil
+	}
+}
+
+// WithMaxBatchBytes sets the maximum size in bytes of an append request. It
+// can't exceed the 10MB limit of the Storage Write API.
+func WithMaxBatchBytes(n int) func(*StorageWriteOptions) error {
+	return func(o *StorageWriteOptions) error {
+		if n < 1 || n > storageWriteSizeLimit {
+			return errors.Errorf("max batch bytes must be between 1 and %v: %v", storageWriteSizeLimit, n)
+		}
+		o.MaxBatchBytes = n
+		return nil
+	}
+}
+
+// WithMaxRetries sets the number of times a failed append request is
+// retried, with exponential backoff, if the error is transient.
+func WithMaxRetries(n int) func(*StorageWriteOptions) error {
+	return func(o *StorageWriteOptions) error {
+		if n < 0 {
+			return errors.Errorf("max retries must not be negative: %v", n)
+		}
+		o.MaxRetries = n
+		return nil
+	}
+}
+
+// WriteStorage writes the elements of the given PCollection<T> to bigquery
+// with the BigQuery Storage Write API. T is required to be the schema type.
+// The table is created if it doesn't exist.
+//
+// By default, the rows are grouped and each group is written to a pending
+// stream, which is committed atomically once all its rows are appended, so
+// each row is written exactly once. With WithAtLeastOnce, rows are instead
+// appended to the table's default stream as they arrive, which suits
+// unbounded inputs.
+func WriteStorage(s beam.Scope, project, table string, col beam.PCollection, options ...func(*StorageWriteOptions) error) {
+	t := col.Type().Type()
+	mustInferSchema(t)
+	qn := mustParseTable(table)
+
+	s = s.Scope("bigquery.WriteStorage")
+
+	opts := StorageWriteOptions{MaxBatchRows: writeRowLimit, MaxBatchBytes: storageWriteSizeLimit, MaxRetries: 3}
+	for _, opt := range options {
+		if err := opt(&opts); err != nil {
+			panic(err)
+		}
+	}
+
+	if opts.AtLeastOnce {
+		beam.ParDo0(s, &storageStreamFn{Project: project, Table: qn, Type: beam.EncodedType{T: t}, Options: opts}, col)
+		return
+	}
+	pre := beam.AddFixedKey(s, col)
+	post := beam.GroupByKey(s, pre)
+	beam.ParDo0(s, &storageWriteFn{Project: project, Table: qn, Type: beam.EncodedType{T: t}, Options: opts}, post)
+}
+
+// storageWriter appends encoded rows to a managed stream, in batches.
+type storageWriter struct {
+	stream  *managedwriter.ManagedStream
+	options StorageWriteOptions
+	// pending indicates the stream is a pending stream, to which rows are
+	// appended at explicit offsets, so that retried appends are idempotent.
+	pending bool
+
+	rows   [][]byte
+	size   int
+	offset int64
+}
+
+// Append adds a row to the current batch, and appends the batch if it is
+// full.
+func (w *storageWriter) Append(ctx context.Context, row []byte) error {
+	if len(w.rows) > 0 && (len(w.rows)+1 > w.options.MaxBatchRows || w.size+len(row) > w.options.MaxBatchBytes) {
+		if err := w.Flush(ctx); err != nil {
+			return err
+		}
+	}
+	w.rows = append(w.rows, row)
+	w.size += len(row)
+	return nil
+}
+
+// Flush appends the current batch, if any, retrying transient failures.
+func (w *storageWriter) Flush(ctx context.Context) error {
+	if len(w.rows) == 0 {
+		return nil
+	}
+	backoff := storageRetryBackoff
+	for attempt := 0; ; attempt++ {
+		err := w.append(ctx)
+		if err == nil {
+			w.offset += int64(len(w.rows))
+			w.rows, w.size = nil, 0
+			return nil
+		}
+		if attempt >= w.options.MaxRetries || !isRetryable(err) {
+			return errors.Wrapf(err, "bigquery storage write error [len=%d, size=%d]", len(w.rows), w.size)
+		}
+		log.Warnf(ctx, "bigquery storage write failed, retrying in %v: %v", backoff, err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+func (w *storageWriter) append(ctx context.Context) error {
+	var opts []managedwriter.AppendOption
+	if w.pending {
+		opts = append(opts, managedwriter.WithOffset(w.offset))
+	}
+	result, err := w.stream.AppendRows(ctx, w.rows, opts...)
+	if err != nil {
+		return err
+	}
+	_, err = result.GetResult(ctx)
+	if w.pending && status.Code(err) == codes.AlreadyExists {
+		// An earlier attempt of the append succeeded.
+		return nil
+	}
+	return err
+}
+
+// isRetryable reports whether an append error is transient.
+func isRetryable(err error) bool {
+	switch status.Code(err) {
+	case codes.Unavailable, codes.Internal, codes.ResourceExhausted, codes.Aborted, codes.DeadlineExceeded:
+		return true
+	default:
+		return false
+	}
+}
+
+// storageTable returns the name of the table in the Storage Write API.
+func storageTable(qn QualifiedTableName) string {
+	return fmt.Sprintf("projects/%v/datasets/%v/tables/%v", qn.Project, qn.Dataset, qn.Table)
+}
+
+// setupStorage creates the table if missing, and returns a row encoder and
+// a Storage Write API client.
+func setupStorage(ctx context.Context, project string, qn QualifiedTableName, t reflect.Type) (*rowEncoder, *managedwriter.Client, error) {
+	schema := mustInferSchema(t)
+	bqClient, err := bigquery.NewClient(ctx, project)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer bqClient.Close()
+	if _, err := createTableIfMissing(ctx, bqClient, qn, &bigquery.TableMetadata{Schema: schema}); err != nil {
+		return nil, nil, err
+	}
+
+	enc, err := newRowEncoder(schema)
+	if err != nil {
+		return nil, nil, err
+	}
+	client, err := managedwriter.NewClient(ctx, project)
+	if err != nil {
+		return nil, nil, err
+	}
+	return enc, client, nil
+}
+
+// storageWriteFn writes each group of rows to a pending stream, which it
+// commits once all the rows are appended.
+type storageWriteFn struct {
+	// Project is the project
+	Project string `json:"project"`
+	// Table is the qualified table identifier.
+	Table QualifiedTableName `json:"table"`
+	// Type is the encoded schema type.
+	Type beam.EncodedType `json:"type"`
+	// Options specifies additional write options.
+	Options StorageWriteOptions `json:"options"`
+}
+
+func (f *storageWriteFn) ProcessElement(ctx context.Context, _ int, iter func(*beam.X) bool) error {
+	enc, client, err := setupStorage(ctx, f.Project, f.Table, f.Type.T)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	stream, err := client.NewManagedStream(ctx,
+		managedwriter.WithDestinationTable(storageTable(f.Table)),
+		managedwriter.WithType(managedwriter.PendingStream),
+		managedwriter.WithSchemaDescriptor(enc.proto))
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+
+	w := &storageWriter{stream: stream, options: f.Options, pending: true}
+	var val beam.X
+	for iter(&val) {
+		row, err := enc.Encode(val)
+		if err != nil {
+			return errors.Wrapf(err, "bigquery storage write error")
+		}
+		if err := w.Append(ctx, row); err != nil {
+			return err
+		}
+	}
+	if err := w.Flush(ctx); err != nil {
+		return err
+	}
+
+	if _, err := stream.Finalize(ctx); err != nil {
+		return err
+	}
+	resp, err := client.BatchCommitWriteStreams(ctx, &storagepb.BatchCommitWriteStreamsRequest{
+		Parent:       storageTable(f.Table),
+		WriteStreams: []string{stream.StreamName()},
+	})
+	if err != nil {
+		return err
+	}
+	if errs := resp.GetStreamErrors(); len(errs) > 0 {
+		return errors.Errorf("bigquery storage commit error: %v", errs)
+	}
+	return nil
+}
+
+// storageStreamFn appends rows to the table's default stream, flushing them
+// at the end of each bundle.
+type storageStreamFn struct {
+	// Project is the project
+	Project string `json:"project"`
+	// Table is the qualified table identifier.
+	Table QualifiedTableName `json:"table"`
+	// Type is the encoded schema type.
+	Type beam.EncodedType `json:"type"`
+	// Options specifies additional write options.
+	Options StorageWriteOptions `json:"options"`
+
+	enc    *rowEncoder
+	client *managedwriter.Client
+	w      *storageWriter
+}
+
+func (f *storageStreamFn) Setup(ctx context.Context) error {
+	var err error
+	if f.enc, f.client, err = setupStorage(ctx, f.Project, f.Table, f.Type.T); err != nil {
+		return err
+	}
+	stream, err := f.client.NewManagedStream(ctx,
+		managedwriter.WithDestinationTable(storageTable(f.Table)),
+		managedwriter.WithType(managedwriter.DefaultStream),
+		managedwriter.WithSchemaDescriptor(f.enc.proto))
+	if err != nil {
+		return err
+	}
+	f.w = &storageWriter{stream: stream, options: f.Options}
+	return nil
+}
+
+func (f *storageStreamFn) ProcessElement(ctx context.Context, val beam.X) error {
+	row, err := f.enc.Encode(val)
+	if err != nil {
+		return errors.Wrapf(err, "bigquery storage write error")
+	}
+	return f.w.Append(ctx, row)
+}
+
+func (f *storageStreamFn) FinishBundle(ctx context.Context) error {
+	return f.w.Flush(ctx)
+}
+
+func (f *storageStreamFn) Teardown() error {
+	if f.w != nil {
+		f.w.stream.Close()
+	}
+	if f.client != nil {
+		return f.client.Close()
+	}
+	return nil
+}
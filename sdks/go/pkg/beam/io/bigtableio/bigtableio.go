@@ -0,0 +1,83 @@
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements.  See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License.  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package bigtableio provides transformations and utilities to interact with
+// Google Cloud Bigtable. See also: https://cloud.google.com/bigtable/docs.
+//
+// The transforms use the Bigtable Data API directly. If the
+// BIGTABLE_EMULATOR_HOST environment variable is set, they connect to the
+// emulator at that address instead.
+package bigtableio
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+
+	"github.com/apache/beam/sdks/v2/go/pkg/beam"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/io/internal/gcpx"
+	"google.golang.org/grpc"
+)
+
+const (
+	// emulatorHostEnv is the environment variable with the address of the
+	// Bigtable emulator, if any.
+	emulatorHostEnv = "BIGTABLE_EMULATOR_HOST"
+	endpoint        = "bigtable.googleapis.com:443"
+	dataScope       = "https://www.googleapis.com/auth/bigtable.data"
+)
+
+func init() {
+	beam.RegisterType(reflect.TypeOf((*Row)(nil)).Elem())
+	beam.RegisterType(reflect.TypeOf((*Cell)(nil)).Elem())
+}
+
+// Row is a Bigtable row.
+type Row struct {
+	// Key is the row key.
+	Key string
+	// Cells are the cells of the row, ordered by family, column and
+	// descending timestamp.
+	Cells []Cell
+}
+
+// Cell is a cell of a Bigtable row.
+type Cell struct {
+	// Family is the column family.
+	Family string
+	// Column is the column qualifier.
+	Column string
+	// Timestamp is the timestamp of the cell, in microseconds.
+	Timestamp int64
+	// Value is the value of the cell.
+	Value []byte
+}
+
+// tableName returns the name of the table in the Data API.
+func tableName(project, instance, table string) string {
+	return fmt.Sprintf("projects/%v/instances/%v/tables/%v", project, instance, table)
+}
+
+// dial connects to Bigtable, or to the emulator if BIGTABLE_EMULATOR_HOST
+// is set.
+func dial(ctx context.Context) (*grpc.ClientConn, error) {
+	return gcpx.Dial(ctx, emulatorHostEnv, endpoint, dataScope)
+}
+
+// isRetryable returns whether the request that failed with the error can be
+// retried.
+func isRetryable(err error) bool {
+	return gcpx.IsRetryable(err)
+}
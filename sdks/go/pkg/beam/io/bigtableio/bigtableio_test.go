@@ -0,0 +1,629 @@
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements.  See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License.  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bigtableio
+
+import (
+	"net"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/apache/beam/sdks/v2/go/pkg/beam"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/testing/passert"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/testing/ptest"
+	btpb "google.golang.org/genproto/googleapis/bigtable/v2"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+func TestMain(m *testing.M) {
+	ptest.Main(m)
+}
+
+// fakeServer is an in-memory Bigtable Data API server, which supports
+// family name filters only.
+type fakeServer struct {
+	btpb.UnimplementedBigtableServer
+
+	mu      sync.Mutex
+	rows    map[string]Row
+	samples []string
+	// failures is the number of ReadRows requests to fail with Unavailable
+	// after the first row.
+	failures int
+	// flaky are the number of times mutations of each row fail with
+	// Unavailable. Mutations of rows with keys starting with "bad" fail with
+	// InvalidArgument.
+	flaky       map[string]int
+	inflight    int
+	maxInflight int
+	requests    int
+}
+
+// startFakeServer starts a fake server with the rows, and points the
+// transforms at it.
+func startFakeServer(t *testing.T, samples []string, rows ...Row) *fakeServer {
+	t.Helper()
+	lis, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	fake := &fakeServer{rows: make(map[string]Row), samples: samples, flaky: make(map[string]int)}
+	for _, row := range rows {
+		fake.rows[row.Key] = row
+	}
+	srv := grpc.NewServer()
+	btpb.RegisterBigtableServer(srv, fake)
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+	t.Setenv(emulatorHostEnv, lis.Addr().String())
+	return fake
+}
+
+func (f *fakeServer) SampleRowKeys(_ *btpb.SampleRowKeysRequest, stream btpb.Bigtable_SampleRowKeysServer) error {
+	for _, key := range f.samples {
+		if err := stream.Send(&btpb.SampleRowKeysResponse{RowKey: []byte(key)}); err != nil {
+			return err
+		}
+	}
+	// As Bigtable, the last sample is the end of the table.
+	return stream.Send(&btpb.SampleRowKeysResponse{})
+}
+
+func (f *fakeServer) ReadRows(req *btpb.ReadRowsRequest, stream btpb.Bigtable_ReadRowsServer) error {
+	f.mu.Lock()
+	var keys []string
+	for key := range f.rows {
+		if inRowSet(key, req.GetRows()) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	fail := f.failures > 0 && len(keys) > 1
+	if fail {
+		f.failures--
+	}
+	f.mu.Unlock()
+
+	var family *regexp.Regexp
+	if expr := req.GetFilter().GetFamilyNameRegexFilter(); expr != "" {
+		family = regexp.MustCompile("^" + expr + "$")
+	}
+	for i, key := range keys {
+		if fail && i == 1 {
+			return status.Error(codes.Unavailable, "injected failure")
+		}
+		var chunks []*btpb.ReadRowsResponse_CellChunk
+		for _, cell := range f.rows[key].Cells {
+			if family != nil && !family.MatchString(cell.Family) {
+				continue
+			}
+			// Split each value in two chunks, as Bigtable does for large
+			// values.
+			half := len(cell.Value) / 2
+			chunks = append(chunks, &btpb.ReadRowsResponse_CellChunk{
+				FamilyName:      wrapperspb.String(cell.Family),
+				Qualifier:       wrapperspb.Bytes([]byte(cell.Column)),
+				TimestampMicros: cell.Timestamp,
+				Value:           cell.Value[:half],
+				ValueSize:       int32(len(cell.Value)),
+			}, &btpb.ReadRowsResponse_CellChunk{
+				Value: cell.Value[half:],
+			})
+		}
+		if len(chunks) == 0 {
+			continue
+		}
+		chunks[0].RowKey = []byte(key)
+		chunks[len(chunks)-1].RowStatus = &btpb.ReadRowsResponse_CellChunk_CommitRow{CommitRow: true}
+		if err := stream.Send(&btpb.ReadRowsResponse{Chunks: chunks}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *fakeServer) MutateRows(req *btpb.MutateRowsRequest, stream btpb.Bigtable_MutateRowsServer) error {
+	f.mu.Lock()
+	f.requests++
+	f.inflight++
+	if f.inflight > f.maxInflight {
+		f.maxInflight = f.inflight
+	}
+	f.mu.Unlock()
+	// Hold the request, so that requests in flight overlap.
+	time.Sleep(10 * time.Millisecond)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.inflight--
+
+	resp := &btpb.MutateRowsResponse{}
+	for i, entry := range req.GetEntries() {
+		key := string(entry.GetRowKey())
+		st := status.New(codes.OK, "")
+		switch {
+		case strings.HasPrefix(key, "bad"):
+			st = status.New(codes.InvalidArgument, "bad row")
+		case f.flaky[key] > 0:
+			f.flaky[key]--
+			st = status.New(codes.Unavailable, "flaky row")
+		default:
+			row := f.rows[key]
+			row.Key = key
+			for _, m := range entry.GetMutations() {
+				set := m.GetSetCell()
+				if set == nil {
+					return status.Error(codes.Unimplemented, "only SetCell is supported")
+				}
+				row.Cells = append(row.Cells, Cell{Family: set.GetFamilyName(), Column: string(set.GetColumnQualifier()), Timestamp: set.GetTimestampMicros(), Value: set.GetValue()})
+			}
+			f.rows[key] = row
+		}
+		resp.Entries = append(resp.Entries, &btpb.MutateRowsResponse_Entry{Index: int64(i), Status: st.Proto()})
+	}
+	return stream.Send(resp)
+}
+
+// inRowSet returns whether the key is in a range of the row set.
+func inRowSet(key string, rows *btpb.RowSet) bool {
+	for _, rr := range rows.GetRowRanges() {
+		switch start := rr.GetStartKey().(type) {
+		case *btpb.RowRange_StartKeyClosed:
+			if key < string(start.StartKeyClosed) {
+				continue
+			}
+		case *btpb.RowRange_StartKeyOpen:
+			if key <= string(start.StartKeyOpen) {
+				continue
+			}
+		}
+		if end, ok := rr.GetEndKey().(*btpb.RowRange_EndKeyOpen); ok && key >= string(end.EndKeyOpen) {
+			continue
+		}
+		return true
+	}
+	return false
+}
+
+func testRow(key string) Row {
+	return Row{Key: key, Cells: []Cell{
+		{Family: "cf1", Column: "a", Timestamp: 1000, Value: []byte("value-" + key)},
+		{Family: "cf2", Column: "b", Timestamp: 2000, Value: []byte("x")},
+	}}
+}
+
+func TestRead(t *testing.T) {
+	startFakeServer(t, []string{"b", "d"}, testRow("a"), testRow("b"), testRow("c"), testRow("d"), testRow("e"))
+
+	p, s := beam.NewPipelineWithRoot()
+	rows := Read(s, "project", "instance", "table")
+	passert.Equals(s, rows, testRow("a"), testRow("b"), testRow("c"), testRow("d"), testRow("e"))
+
+	ptest.RunAndValidate(t, p)
+}
+
+func TestRead_RowRangeFilter(t *testing.T) {
+	startFakeServer(t, []string{"c"}, testRow("a"), testRow("b"), testRow("c"), testRow("d"), testRow("e"))
+
+	p, s := beam.NewPipelineWithRoot()
+	rows := Read(s, "project", "instance", "table",
+		ReadRowRange("b", "d"),
+		ReadRowRange("e", ""),
+		ReadFilter(&btpb.RowFilter{Filter: &btpb.RowFilter_FamilyNameRegexFilter{FamilyNameRegexFilter: "cf1"}}))
+	keys := beam.ParDo(s, func(row Row) string {
+		if len(row.Cells) != 1 || row.Cells[0].Family != "cf1" {
+			return "unfiltered " + row.Key
+		}
+		return row.Key
+	}, rows)
+	passert.Equals(s, keys, "b", "c", "e")
+
+	ptest.RunAndValidate(t, p)
+}
+
+func TestRead_Retry(t *testing.T) {
+	fake := startFakeServer(t, nil, testRow("a"), testRow("b"), testRow("c"))
+	fake.failures = 1
+
+	p, s := beam.NewPipelineWithRoot()
+	rows := Read(s, "project", "instance", "table")
+	passert.Equals(s, rows, testRow("a"), testRow("b"), testRow("c"))
+
+	ptest.RunAndValidate(t, p)
+}
+
+func TestSplitRanges(t *testing.T) {
+	tests := []struct {
+		name    string
+		ranges  []keyRange
+		samples []string
+		want    []keyRange
+	}{
+		{
+			name: "Table",
+			want: []keyRange{{}},
+		},
+		{
+			name:    "TableSamples",
+			samples: []string{"b", "d"},
+			want:    []keyRange{{End: "b"}, {Start: "b", End: "d"}, {Start: "d"}},
+		},
+		{
+			name:    "Ranges",
+			ranges:  []keyRange{{Start: "a", End: "c"}, {Start: "d"}},
+			samples: []string{"a", "b", "c", "e"},
+			want:    []keyRange{{Start: "a", End: "b"}, {Start: "b", End: "c"}, {Start: "d", End: "e"}, {Start: "e"}},
+		},
+	}
+	for _, test := range tests {
+		if got := splitRanges(test.ranges, test.samples); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("splitRanges(%v, %v) = %v, want %v", test.ranges, test.samples, got, test.want)
+		}
+	}
+}
+
+func TestRowMerger_Reset(t *testing.T) {
+	var m rowMerger
+	chunks := []*btpb.ReadRowsResponse_CellChunk{
+		{RowKey: []byte("a"), FamilyName: wrapperspb.String("cf"), Qualifier: wrapperspb.Bytes([]byte("q")), Value: []byte("old")},
+		{RowStatus: &btpb.ReadRowsResponse_CellChunk_ResetRow{ResetRow: true}},
+		{RowKey: []byte("a"), FamilyName: wrapperspb.String("cf"), Qualifier: wrapperspb.Bytes([]byte("q")), Value: []byte("new")},
+		{Qualifier: wrapperspb.Bytes([]byte("r")), Value: []byte("v"), RowStatus: &btpb.ReadRowsResponse_CellChunk_CommitRow{CommitRow: true}},
+	}
+	var got *Row
+	for _, chunk := range chunks {
+		row, err := m.add(chunk)
+		if err != nil {
+			t.Fatalf("add() failed: %v", err)
+		}
+		if row != nil {
+			got = row
+		}
+	}
+	want := &Row{Key: "a", Cells: []Cell{
+		{Family: "cf", Column: "q", Value: []byte("new")},
+		{Family: "cf", Column: "r", Value: []byte("v")},
+	}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("merged row = %+v, want %+v", got, want)
+	}
+}
+
+// TestRowMerger checks the merging of ReadRows cell chunks against the rules
+// of the ReadRows protocol, following the cases of the Cloud Bigtable client
+// acceptance tests.
+func TestRowMerger(t *testing.T) {
+	type chunk = btpb.ReadRowsResponse_CellChunk
+	family := func(f string) *wrapperspb.StringValue { return wrapperspb.String(f) }
+	column := func(c string) *wrapperspb.BytesValue { return wrapperspb.Bytes([]byte(c)) }
+	commit := &btpb.ReadRowsResponse_CellChunk_CommitRow{CommitRow: true}
+	reset := &btpb.ReadRowsResponse_CellChunk_ResetRow{ResetRow: true}
+
+	tests := []struct {
+		name    string
+		chunks  []*chunk
+		want    []Row
+		wantErr bool
+	}{
+		{
+			name: "simple row",
+			chunks: []*chunk{
+				{RowKey: []byte("RK"), FamilyName: family("A"), Qualifier: column("C"), TimestampMicros: 100, Value: []byte("value-VAL"), RowStatus: commit},
+			},
+			want: []Row{{Key: "RK", Cells: []Cell{{Family: "A", Column: "C", Timestamp: 100, Value: []byte("value-VAL")}}}},
+		},
+		{
+			name: "empty cell value",
+			chunks: []*chunk{
+				{RowKey: []byte("RK"), FamilyName: family("A"), Qualifier: column("C"), RowStatus: commit},
+			},
+			want: []Row{{Key: "RK", Cells: []Cell{{Family: "A", Column: "C"}}}},
+		},
+		{
+			name: "multi chunk value",
+			chunks: []*chunk{
+				{RowKey: []byte("RK"), FamilyName: family("A"), Qualifier: column("C"), TimestampMicros: 100, Value: []byte("v"), ValueSize: 9},
+				{Value: []byte("alue-"), ValueSize: 9},
+				{Value: []byte("VAL"), RowStatus: commit},
+			},
+			want: []Row{{Key: "RK", Cells: []Cell{{Family: "A", Column: "C", Timestamp: 100, Value: []byte("value-VAL")}}}},
+		},
+		{
+			name: "two cells same column",
+			chunks: []*chunk{
+				{RowKey: []byte("RK"), FamilyName: family("A"), Qualifier: column("C"), TimestampMicros: 100, Value: []byte("v1")},
+				{TimestampMicros: 99, Value: []byte("v2"), RowStatus: commit},
+			},
+			want: []Row{{Key: "RK", Cells: []Cell{
+				{Family: "A", Column: "C", Timestamp: 100, Value: []byte("v1")},
+				{Family: "A", Column: "C", Timestamp: 99, Value: []byte("v2")},
+			}}},
+		},
+		{
+			name: "two qualifiers",
+			chunks: []*chunk{
+				{RowKey: []byte("RK"), FamilyName: family("A"), Qualifier: column("C"), Value: []byte("v1")},
+				{Qualifier: column("D"), Value: []byte("v2"), RowStatus: commit},
+			},
+			want: []Row{{Key: "RK", Cells: []Cell{
+				{Family: "A", Column: "C", Value: []byte("v1")},
+				{Family: "A", Column: "D", Value: []byte("v2")},
+			}}},
+		},
+		{
+			name: "two families",
+			chunks: []*chunk{
+				{RowKey: []byte("RK"), FamilyName: family("A"), Qualifier: column("C"), Value: []byte("v1")},
+				{FamilyName: family("B"), Qualifier: column("C"), Value: []byte("v2"), RowStatus: commit},
+			},
+			want: []Row{{Key: "RK", Cells: []Cell{
+				{Family: "A", Column: "C", Value: []byte("v1")},
+				{Family: "B", Column: "C", Value: []byte("v2")},
+			}}},
+		},
+		{
+			name: "two rows",
+			chunks: []*chunk{
+				{RowKey: []byte("RK_1"), FamilyName: family("A"), Qualifier: column("C"), Value: []byte("v1"), RowStatus: commit},
+				{RowKey: []byte("RK_2"), FamilyName: family("A"), Qualifier: column("C"), Value: []byte("v2"), RowStatus: commit},
+			},
+			want: []Row{
+				{Key: "RK_1", Cells: []Cell{{Family: "A", Column: "C", Value: []byte("v1")}}},
+				{Key: "RK_2", Cells: []Cell{{Family: "A", Column: "C", Value: []byte("v2")}}},
+			},
+		},
+		{
+			name: "row key repeated within row",
+			chunks: []*chunk{
+				{RowKey: []byte("RK"), FamilyName: family("A"), Qualifier: column("C"), Value: []byte("v1")},
+				{RowKey: []byte("RK"), Qualifier: column("D"), Value: []byte("v2"), RowStatus: commit},
+			},
+			want: []Row{{Key: "RK", Cells: []Cell{
+				{Family: "A", Column: "C", Value: []byte("v1")},
+				{Family: "A", Column: "D", Value: []byte("v2")},
+			}}},
+		},
+		{
+			name: "reset in middle of cell",
+			chunks: []*chunk{
+				{RowKey: []byte("RK"), FamilyName: family("A"), Qualifier: column("C"), Value: []byte("v"), ValueSize: 2},
+				{RowStatus: reset},
+				{RowKey: []byte("RK"), FamilyName: family("B"), Qualifier: column("D"), Value: []byte("v2"), RowStatus: commit},
+			},
+			want: []Row{{Key: "RK", Cells: []Cell{{Family: "B", Column: "D", Value: []byte("v2")}}}},
+		},
+		{
+			name: "reset then different row",
+			chunks: []*chunk{
+				{RowKey: []byte("RK_1"), FamilyName: family("A"), Qualifier: column("C"), Value: []byte("v1")},
+				{RowStatus: reset},
+				{RowKey: []byte("RK_2"), FamilyName: family("A"), Qualifier: column("C"), Value: []byte("v2"), RowStatus: commit},
+			},
+			want: []Row{{Key: "RK_2", Cells: []Cell{{Family: "A", Column: "C", Value: []byte("v2")}}}},
+		},
+		{
+			name: "invalid - no commit",
+			chunks: []*chunk{
+				{RowKey: []byte("RK"), FamilyName: family("A"), Qualifier: column("C"), Value: []byte("v")},
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid - commit in middle of cell",
+			chunks: []*chunk{
+				{RowKey: []byte("RK"), FamilyName: family("A"), Qualifier: column("C"), Value: []byte("v"), ValueSize: 2, RowStatus: commit},
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid - no row key",
+			chunks: []*chunk{
+				{FamilyName: family("A"), Qualifier: column("C"), Value: []byte("v"), RowStatus: commit},
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid - no cell key before value",
+			chunks: []*chunk{
+				{RowKey: []byte("RK"), Value: []byte("v"), RowStatus: commit},
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid - new family without qualifier",
+			chunks: []*chunk{
+				{RowKey: []byte("RK"), FamilyName: family("A"), Qualifier: column("C"), Value: []byte("v1")},
+				{FamilyName: family("B"), Value: []byte("v2"), RowStatus: commit},
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid - no commit between rows",
+			chunks: []*chunk{
+				{RowKey: []byte("RK_1"), FamilyName: family("A"), Qualifier: column("C"), Value: []byte("v1")},
+				{RowKey: []byte("RK_2"), FamilyName: family("A"), Qualifier: column("C"), Value: []byte("v2"), RowStatus: commit},
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid - duplicate row key",
+			chunks: []*chunk{
+				{RowKey: []byte("RK"), FamilyName: family("A"), Qualifier: column("C"), Value: []byte("v1"), RowStatus: commit},
+				{RowKey: []byte("RK"), FamilyName: family("A"), Qualifier: column("C"), Value: []byte("v2"), RowStatus: commit},
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid - out of order rows",
+			chunks: []*chunk{
+				{RowKey: []byte("RK_2"), FamilyName: family("A"), Qualifier: column("C"), Value: []byte("v1"), RowStatus: commit},
+				{RowKey: []byte("RK_1"), FamilyName: family("A"), Qualifier: column("C"), Value: []byte("v2"), RowStatus: commit},
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid - cell key change in middle of cell",
+			chunks: []*chunk{
+				{RowKey: []byte("RK"), FamilyName: family("A"), Qualifier: column("C"), Value: []byte("v"), ValueSize: 2},
+				{Qualifier: column("D"), Value: []byte("2"), RowStatus: commit},
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid - bare reset",
+			chunks: []*chunk{
+				{RowStatus: reset},
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid - reset with chunk data",
+			chunks: []*chunk{
+				{RowKey: []byte("RK"), FamilyName: family("A"), Qualifier: column("C"), Value: []byte("v1")},
+				{Value: []byte("v2"), RowStatus: reset},
+			},
+			wantErr: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var m rowMerger
+			var got []Row
+			var err error
+			for _, c := range test.chunks {
+				var row *Row
+				if row, err = m.add(c); err != nil {
+					break
+				}
+				if row != nil {
+					got = append(got, *row)
+				}
+			}
+			if err == nil {
+				err = m.finish()
+			}
+			if test.wantErr {
+				if err == nil {
+					t.Fatalf("merged rows = %+v, want error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("merging failed: %v", err)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("merged rows = %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
+
+func testMutation(key string) Mutation {
+	m := NewMutation(key)
+	m.Set("cf", "q", 1000, []byte("value-"+key))
+	return *m
+}
+
+func TestWrite(t *testing.T) {
+	fake := startFakeServer(t, nil)
+	fake.flaky["b"] = 2
+
+	p, s := beam.NewPipelineWithRoot()
+	muts := beam.Create(s, testMutation("a"), testMutation("b"), testMutation("c"), testMutation("d"), testMutation("e"))
+	Write(s, "project", "instance", "table", muts,
+		WriteMaxBatchRows(2), WriteMaxInflightRequests(2), WriteBackoff(time.Millisecond, time.Millisecond))
+
+	ptest.RunAndValidate(t, p)
+
+	for _, key := range []string{"a", "b", "c", "d", "e"} {
+		want := Row{Key: key, Cells: []Cell{{Family: "cf", Column: "q", Timestamp: 1000, Value: []byte("value-" + key)}}}
+		if got := fake.rows[key]; !reflect.DeepEqual(got, want) {
+			t.Errorf("row %v = %+v, want %+v", key, got, want)
+		}
+	}
+	// 5 mutations in batches of 2, and 2 retries of the flaky mutation.
+	if fake.requests != 5 {
+		t.Errorf("Write() sent %v requests, want 5", fake.requests)
+	}
+	if fake.maxInflight > 2 {
+		t.Errorf("Write() sent %v requests concurrently, want at most 2", fake.maxInflight)
+	}
+}
+
+func TestWrite_Failure(t *testing.T) {
+	startFakeServer(t, nil)
+
+	p, s := beam.NewPipelineWithRoot()
+	muts := beam.Create(s, testMutation("a"), testMutation("bad"))
+	Write(s, "project", "instance", "table", muts)
+
+	if err := ptest.Run(p); err == nil {
+		t.Error("Write() of an invalid mutation succeeded, want error")
+	}
+}
+
+func TestWriteWithFailures(t *testing.T) {
+	fake := startFakeServer(t, nil)
+	fake.flaky["flaky"] = 2
+
+	p, s := beam.NewPipelineWithRoot()
+	muts := beam.Create(s, testMutation("a"), testMutation("bad"), testMutation("flaky"), Mutation{Key: "empty"})
+	failed := WriteWithFailures(s, "project", "instance", "table", muts,
+		WriteMaxRetries(1), WriteBackoff(time.Millisecond, time.Millisecond))
+	keys := beam.ParDo(s, func(f FailedMutation) string {
+		return f.Mutation.Key
+	}, failed)
+	passert.Equals(s, keys, "bad", "flaky", "empty")
+
+	ptest.RunAndValidate(t, p)
+
+	if _, ok := fake.rows["a"]; !ok {
+		t.Error("WriteWithFailures() didn't apply the valid mutation")
+	}
+}
+
+func TestMutation_Entry(t *testing.T) {
+	m := NewMutation("row")
+	m.Set("cf", "a", ServerTime, []byte("v"))
+	m.DeleteColumn("cf", "b")
+	m.DeleteFamily("old")
+	m.DeleteRow()
+	entry, err := m.entry()
+	if err != nil {
+		t.Fatalf("entry() failed: %v", err)
+	}
+	want := &btpb.MutateRowsRequest_Entry{RowKey: []byte("row"), Mutations: []*btpb.Mutation{
+		{Mutation: &btpb.Mutation_SetCell_{SetCell: &btpb.Mutation_SetCell{FamilyName: "cf", ColumnQualifier: []byte("a"), TimestampMicros: -1, Value: []byte("v")}}},
+		{Mutation: &btpb.Mutation_DeleteFromColumn_{DeleteFromColumn: &btpb.Mutation_DeleteFromColumn{FamilyName: "cf", ColumnQualifier: []byte("b")}}},
+		{Mutation: &btpb.Mutation_DeleteFromFamily_{DeleteFromFamily: &btpb.Mutation_DeleteFromFamily{FamilyName: "old"}}},
+		{Mutation: &btpb.Mutation_DeleteFromRow_{DeleteFromRow: &btpb.Mutation_DeleteFromRow{}}},
+	}}
+	if !proto.Equal(entry, want) {
+		t.Errorf("entry() = %v, want %v", entry, want)
+	}
+
+	if _, err := (Mutation{Key: "row"}).entry(); err == nil {
+		t.Error("entry() of a mutation without operations succeeded, want error")
+	}
+}
@@ -0,0 +1,133 @@
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements.  See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License.  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bigtableio
+
+import (
+	"reflect"
+
+	"github.com/apache/beam/sdks/v2/go/pkg/beam"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/internal/errors"
+	btpb "google.golang.org/genproto/googleapis/bigtable/v2"
+)
+
+// ServerTime is the timestamp of a cell set with the time of the Bigtable
+// server.
+const ServerTime int64 = -1
+
+func init() {
+	beam.RegisterType(reflect.TypeOf((*Mutation)(nil)).Elem())
+	beam.RegisterType(reflect.TypeOf((*MutationOp)(nil)).Elem())
+}
+
+// MutationKind is the kind of a mutation operation.
+type MutationKind int
+
+const (
+	// SetCellKind sets the value of a cell.
+	SetCellKind MutationKind = iota
+	// DeleteColumnKind deletes the cells of a column.
+	DeleteColumnKind
+	// DeleteFamilyKind deletes the cells of a column family.
+	DeleteFamilyKind
+	// DeleteRowKind deletes the row.
+	DeleteRowKind
+)
+
+// MutationOp is an operation of a mutation.
+type MutationOp struct {
+	Kind      MutationKind
+	Family    string
+	Column    string
+	Timestamp int64
+	Value     []byte
+}
+
+// Mutation is a set of operations applied atomically to a row. Mutations are
+// built with NewMutation and its methods. For example:
+//
+//    m := bigtableio.NewMutation("row-key")
+//    m.Set("cf", "column", bigtableio.ServerTime, []byte("value"))
+type Mutation struct {
+	// Key is the row key.
+	Key string
+	// Ops are the operations, in order.
+	Ops []MutationOp
+}
+
+// NewMutation returns a mutation of the row with the given key, without
+// operations.
+func NewMutation(key string) *Mutation {
+	return &Mutation{Key: key}
+}
+
+// Set sets the value of the cell in the column at the timestamp, in
+// microseconds, or at ServerTime.
+func (m *Mutation) Set(family, column string, timestamp int64, value []byte) {
+	m.Ops = append(m.Ops, MutationOp{Kind: SetCellKind, Family: family, Column: column, Timestamp: timestamp, Value: value})
+}
+
+// DeleteColumn deletes the cells of the column.
+func (m *Mutation) DeleteColumn(family, column string) {
+	m.Ops = append(m.Ops, MutationOp{Kind: DeleteColumnKind, Family: family, Column: column})
+}
+
+// DeleteFamily deletes the cells of the column family.
+func (m *Mutation) DeleteFamily(family string) {
+	m.Ops = append(m.Ops, MutationOp{Kind: DeleteFamilyKind, Family: family})
+}
+
+// DeleteRow deletes the row.
+func (m *Mutation) DeleteRow() {
+	m.Ops = append(m.Ops, MutationOp{Kind: DeleteRowKind})
+}
+
+// entry returns the mutation as an entry of a MutateRows request.
+func (m Mutation) entry() (*btpb.MutateRowsRequest_Entry, error) {
+	if m.Key == "" {
+		return nil, errors.New("mutation without a row key")
+	}
+	if len(m.Ops) == 0 {
+		return nil, errors.Errorf("mutation of row %q without operations", m.Key)
+	}
+	entry := &btpb.MutateRowsRequest_Entry{RowKey: []byte(m.Key)}
+	for _, op := range m.Ops {
+		var pb *btpb.Mutation
+		switch op.Kind {
+		case SetCellKind:
+			pb = &btpb.Mutation{Mutation: &btpb.Mutation_SetCell_{SetCell: &btpb.Mutation_SetCell{
+				FamilyName:      op.Family,
+				ColumnQualifier: []byte(op.Column),
+				TimestampMicros: op.Timestamp,
+				Value:           op.Value,
+			}}}
+		case DeleteColumnKind:
+			pb = &btpb.Mutation{Mutation: &btpb.Mutation_DeleteFromColumn_{DeleteFromColumn: &btpb.Mutation_DeleteFromColumn{
+				FamilyName:      op.Family,
+				ColumnQualifier: []byte(op.Column),
+			}}}
+		case DeleteFamilyKind:
+			pb = &btpb.Mutation{Mutation: &btpb.Mutation_DeleteFromFamily_{DeleteFromFamily: &btpb.Mutation_DeleteFromFamily{
+				FamilyName: op.Family,
+			}}}
+		case DeleteRowKind:
+			pb = &btpb.Mutation{Mutation: &btpb.Mutation_DeleteFromRow_{DeleteFromRow: &btpb.Mutation_DeleteFromRow{}}}
+		default:
+			return nil, errors.Errorf("mutation of row %q with unknown operation kind %v", m.Key, op.Kind)
+		}
+		entry.Mutations = append(entry.Mutations, pb)
+	}
+	return entry, nil
+}
@@ -0,0 +1,380 @@
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements.  See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License.  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bigtableio
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"reflect"
+	"time"
+
+	"github.com/apache/beam/sdks/v2/go/pkg/beam"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/core/sdf"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/internal/errors"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/io/rtrackers/offsetrange"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/log"
+	btpb "google.golang.org/genproto/googleapis/bigtable/v2"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/proto"
+)
+
+// readRetries is the number of times reading a range is retried after a
+// retryable error, resuming after the last row read.
+const readRetries = 3
+
+func init() {
+	beam.RegisterType(reflect.TypeOf((*keyRange)(nil)).Elem())
+	beam.RegisterType(reflect.TypeOf((*tableSplits)(nil)).Elem())
+	beam.RegisterType(reflect.TypeOf((*sampleFn)(nil)).Elem())
+	beam.RegisterType(reflect.TypeOf((*readFn)(nil)).Elem())
+}
+
+// keyRange is a range of row keys, from Start inclusive to End exclusive. An
+// empty End is the end of the table.
+type keyRange struct {
+	Start string
+	End   string
+}
+
+// readOption holds the options of Read.
+type readOption struct {
+	Ranges []keyRange
+	Filter []byte
+}
+
+// ReadOptionFn is an option for Read.
+type ReadOptionFn func(*readOption)
+
+// ReadRowRange reads the rows with keys from start, inclusive, to end,
+// exclusive. An empty end reads to the end of the table. The option can be
+// given several times to read several ranges, which must not overlap. Panics
+// if end is before start.
+func ReadRowRange(start, end string) ReadOptionFn {
+	if end != "" && end <= start {
+		panic(fmt.Sprintf("bigtableio.ReadRowRange end must be after start. Got: [%q, %q)", start, end))
+	}
+	return func(o *readOption) {
+		o.Ranges = append(o.Ranges, keyRange{Start: start, End: end})
+	}
+}
+
+// ReadFilter applies the filter to the rows read, such as to read only some
+// column families or the latest cell of each column. Panics if the filter
+// can't be serialized.
+func ReadFilter(filter *btpb.RowFilter) ReadOptionFn {
+	data, err := proto.Marshal(filter)
+	if err != nil {
+		panic(fmt.Sprintf("bigtableio.ReadFilter invalid filter: %v", err))
+	}
+	return func(o *readOption) {
+		o.Filter = data
+	}
+}
+
+// Read reads the rows of the given table and returns a PCollection<Row>.
+// The whole table is read, unless row ranges are set with ReadRowRange. The
+// ranges are split at the row keys sampled by Bigtable, which are about
+// evenly spaced in the table, so that they can be read in parallel.
+func Read(s beam.Scope, project, instance, table string, opts ...ReadOptionFn) beam.PCollection {
+	s = s.Scope("bigtableio.Read")
+
+	var o readOption
+	for _, opt := range opts {
+		opt(&o)
+	}
+	name := tableName(project, instance, table)
+
+	imp := beam.Impulse(s)
+	splits := beam.ParDo(s, &sampleFn{Table: name, Ranges: o.Ranges}, imp)
+	return beam.ParDo(s, &readFn{Table: name, Filter: o.Filter}, splits)
+}
+
+// tableSplits are the ranges of row keys to read.
+type tableSplits struct {
+	Ranges []keyRange
+}
+
+// splitRanges splits the ranges at the sampled row keys, which are in
+// ascending order. No ranges is the whole table.
+func splitRanges(ranges []keyRange, samples []string) []keyRange {
+	if len(ranges) == 0 {
+		ranges = []keyRange{{}}
+	}
+	var splits []keyRange
+	for _, r := range ranges {
+		start := r.Start
+		for _, key := range samples {
+			if key > start && (r.End == "" || key < r.End) {
+				splits = append(splits, keyRange{Start: start, End: key})
+				start = key
+			}
+		}
+		splits = append(splits, keyRange{Start: start, End: r.End})
+	}
+	return splits
+}
+
+// sampleFn splits the ranges to read at the row keys sampled by Bigtable.
+type sampleFn struct {
+	// Table is the name of the table.
+	Table string `json:"table"`
+	// Ranges are the ranges to read, or none to read the whole table.
+	Ranges []keyRange `json:"ranges"`
+}
+
+func (f *sampleFn) ProcessElement(ctx context.Context, _ []byte, emit func(tableSplits)) error {
+	conn, err := dial(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	stream, err := btpb.NewBigtableClient(conn).SampleRowKeys(ctx, &btpb.SampleRowKeysRequest{TableName: f.Table})
+	if err != nil {
+		return errors.Wrapf(err, "sampling row keys of %v", f.Table)
+	}
+	var samples []string
+	for {
+		resp, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return errors.Wrapf(err, "sampling row keys of %v", f.Table)
+		}
+		if len(resp.GetRowKey()) > 0 {
+			samples = append(samples, string(resp.GetRowKey()))
+		}
+	}
+	splits := splitRanges(f.Ranges, samples)
+	log.Infof(ctx, "Reading %v in %v ranges", f.Table, len(splits))
+	emit(tableSplits{Ranges: splits})
+	return nil
+}
+
+// readFn reads the rows of the ranges of the table. Its restriction is the
+// indices of the ranges it reads.
+type readFn struct {
+	// Table is the name of the table.
+	Table string `json:"table"`
+	// Filter is the serialized filter of the rows, if any.
+	Filter []byte `json:"filter"`
+
+	conn   *grpc.ClientConn
+	client btpb.BigtableClient
+	filter *btpb.RowFilter
+}
+
+func (f *readFn) Setup(ctx context.Context) error {
+	if len(f.Filter) > 0 {
+		f.filter = &btpb.RowFilter{}
+		if err := proto.Unmarshal(f.Filter, f.filter); err != nil {
+			return errors.Wrap(err, "invalid filter")
+		}
+	}
+	conn, err := dial(ctx)
+	if err != nil {
+		return err
+	}
+	f.conn = conn
+	f.client = btpb.NewBigtableClient(conn)
+	return nil
+}
+
+func (f *readFn) Teardown() error {
+	if f.conn == nil {
+		return nil
+	}
+	return f.conn.Close()
+}
+
+// CreateInitialRestriction creates an offset range restriction representing
+// all the ranges.
+func (f *readFn) CreateInitialRestriction(splits tableSplits) offsetrange.Restriction {
+	return offsetrange.Restriction{
+		Start: 0,
+		End:   int64(len(splits.Ranges)),
+	}
+}
+
+// SplitRestriction splits the restriction into a restriction per range.
+func (f *readFn) SplitRestriction(_ tableSplits, rest offsetrange.Restriction) []offsetrange.Restriction {
+	return rest.SizedSplits(1)
+}
+
+// RestrictionSize returns the size of each restriction as its number of
+// ranges.
+func (f *readFn) RestrictionSize(_ tableSplits, rest offsetrange.Restriction) float64 {
+	return rest.Size()
+}
+
+// CreateTracker creates sdf.LockRTrackers wrapping offsetRange.Trackers for
+// each restriction.
+func (f *readFn) CreateTracker(rest offsetrange.Restriction) *sdf.LockRTracker {
+	return sdf.NewLockRTracker(offsetrange.NewTracker(rest))
+}
+
+// ProcessElement outputs the rows of the ranges in the restriction.
+func (f *readFn) ProcessElement(ctx context.Context, rt *sdf.LockRTracker, splits tableSplits, emit func(Row)) error {
+	rest := rt.GetRestriction().(offsetrange.Restriction)
+	for i := rest.Start; rt.TryClaim(i); i++ {
+		if err := f.readRange(ctx, splits.Ranges[i], emit); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readRange outputs the rows of the range. If reading fails with a
+// retryable error, it's resumed after the last row output.
+func (f *readFn) readRange(ctx context.Context, r keyRange, emit func(Row)) error {
+	rr := &btpb.RowRange{StartKey: &btpb.RowRange_StartKeyClosed{StartKeyClosed: []byte(r.Start)}}
+	if r.End != "" {
+		rr.EndKey = &btpb.RowRange_EndKeyOpen{EndKeyOpen: []byte(r.End)}
+	}
+	for attempt := 0; ; attempt++ {
+		last, err := f.readRows(ctx, rr, emit)
+		if err == nil {
+			return nil
+		}
+		if attempt >= readRetries || !isRetryable(err) {
+			return errors.Wrapf(err, "reading %v", f.Table)
+		}
+		if last != "" {
+			rr.StartKey = &btpb.RowRange_StartKeyOpen{StartKeyOpen: []byte(last)}
+		}
+		log.Warnf(ctx, "Retrying read of %v after %v: %v", f.Table, last, err)
+		time.Sleep(time.Duration(1<<attempt) * time.Second)
+	}
+}
+
+// readRows outputs the rows of the row range, and returns the key of the last
+// row output.
+func (f *readFn) readRows(ctx context.Context, rr *btpb.RowRange, emit func(Row)) (string, error) {
+	stream, err := f.client.ReadRows(ctx, &btpb.ReadRowsRequest{
+		TableName: f.Table,
+		Rows:      &btpb.RowSet{RowRanges: []*btpb.RowRange{rr}},
+		Filter:    f.filter,
+	})
+	if err != nil {
+		return "", err
+	}
+	var last string
+	var m rowMerger
+	for {
+		resp, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return last, err
+		}
+		for _, chunk := range resp.GetChunks() {
+			row, err := m.add(chunk)
+			if err != nil {
+				return last, err
+			}
+			if row != nil {
+				emit(*row)
+				last = row.Key
+			}
+		}
+	}
+	return last, m.finish()
+}
+
+// rowMerger merges the cell chunks of a ReadRows response into rows, and
+// checks that the chunks follow the rules of the ReadRows protocol.
+type rowMerger struct {
+	row    *Row  // The row in progress, if any.
+	cell   *Cell // The cell in progress, if any.
+	family string
+	column string
+	last   string // The key of the last committed row, if any.
+}
+
+// add adds the chunk to the row in progress, and returns the row if the
+// chunk commits it.
+func (m *rowMerger) add(chunk *btpb.ReadRowsResponse_CellChunk) (*Row, error) {
+	if chunk.GetResetRow() {
+		if m.row == nil {
+			return nil, errors.New("reset without a row in progress")
+		}
+		if hasCellData(chunk) {
+			return nil, errors.Errorf("reset of row %q with cell data", m.row.Key)
+		}
+		*m = rowMerger{last: m.last}
+		return nil, nil
+	}
+	key := string(chunk.GetRowKey())
+	switch {
+	case m.row == nil && key == "":
+		return nil, errors.New("cell chunk of a new row without a row key")
+	case m.row == nil && m.last != "" && key <= m.last:
+		return nil, errors.Errorf("row %q out of order after row %q", key, m.last)
+	case m.row == nil:
+		m.row = &Row{Key: key}
+	case key != "" && key != m.row.Key:
+		return nil, errors.Errorf("row %q started before row %q was committed", key, m.row.Key)
+	}
+	if chunk.GetFamilyName() != nil && chunk.GetQualifier() == nil {
+		return nil, errors.Errorf("family of row %q changed without a column", m.row.Key)
+	}
+	if m.cell == nil {
+		if len(m.row.Cells) == 0 && (chunk.GetFamilyName() == nil || chunk.GetQualifier() == nil) {
+			return nil, errors.Errorf("first cell of row %q without a family and column", m.row.Key)
+		}
+		if chunk.GetFamilyName() != nil {
+			m.family = chunk.GetFamilyName().GetValue()
+		}
+		if chunk.GetQualifier() != nil {
+			m.column = string(chunk.GetQualifier().GetValue())
+		}
+		m.cell = &Cell{Family: m.family, Column: m.column, Timestamp: chunk.GetTimestampMicros()}
+	} else if len(chunk.GetRowKey()) > 0 || chunk.GetFamilyName() != nil || chunk.GetQualifier() != nil || chunk.GetTimestampMicros() != 0 {
+		return nil, errors.Errorf("cell of row %q changed in the middle of its value", m.row.Key)
+	}
+	m.cell.Value = append(m.cell.Value, chunk.GetValue()...)
+	if chunk.GetValueSize() == 0 {
+		// The chunk is the last of the cell.
+		m.row.Cells = append(m.row.Cells, *m.cell)
+		m.cell = nil
+	}
+	if !chunk.GetCommitRow() {
+		return nil, nil
+	}
+	if m.cell != nil {
+		return nil, errors.Errorf("row %q committed in the middle of a cell", m.row.Key)
+	}
+	row := m.row
+	*m = rowMerger{last: row.Key}
+	return row, nil
+}
+
+// finish returns an error if the response ended in the middle of a row.
+func (m *rowMerger) finish() error {
+	if m.row != nil {
+		return errors.Errorf("incomplete row %q at the end of the response", m.row.Key)
+	}
+	return nil
+}
+
+// hasCellData returns whether the chunk holds any part of a cell.
+func hasCellData(chunk *btpb.ReadRowsResponse_CellChunk) bool {
+	return len(chunk.GetRowKey()) > 0 || chunk.GetFamilyName() != nil || chunk.GetQualifier() != nil ||
+		chunk.GetTimestampMicros() != 0 || len(chunk.GetLabels()) > 0 || len(chunk.GetValue()) > 0 || chunk.GetValueSize() != 0
+}
@@ -0,0 +1,414 @@
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements.  See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License.  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bigtableio
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/apache/beam/sdks/v2/go/pkg/beam"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/internal/errors"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/log"
+	btpb "google.golang.org/genproto/googleapis/bigtable/v2"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	defaultMaxBatchRows        = 100
+	defaultMaxBatchBytes       = 5 << 20 // 5 MB
+	defaultMaxInflightRequests = 10
+	defaultMaxRetries          = 5
+	defaultInitialBackoff      = 100 * time.Millisecond
+	defaultMaxBackoff          = 10 * time.Second
+)
+
+func init() {
+	beam.RegisterType(reflect.TypeOf((*FailedMutation)(nil)).Elem())
+	beam.RegisterType(reflect.TypeOf((*writeFn)(nil)).Elem())
+	beam.RegisterType(reflect.TypeOf((*writeWithFailuresFn)(nil)).Elem())
+}
+
+// writeOption holds the options of Write and WriteWithFailures.
+type writeOption struct {
+	MaxBatchRows        int
+	MaxBatchBytes       int
+	MaxInflightRequests int
+	MaxRetries          int
+	InitialBackoff      time.Duration
+	MaxBackoff          time.Duration
+}
+
+// WriteOptionFn is an option for Write and WriteWithFailures.
+type WriteOptionFn func(*writeOption)
+
+func newWriteOption(opts []WriteOptionFn) writeOption {
+	o := writeOption{
+		MaxBatchRows:        defaultMaxBatchRows,
+		MaxBatchBytes:       defaultMaxBatchBytes,
+		MaxInflightRequests: defaultMaxInflightRequests,
+		MaxRetries:          defaultMaxRetries,
+		InitialBackoff:      defaultInitialBackoff,
+		MaxBackoff:          defaultMaxBackoff,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// WriteMaxBatchRows limits the number of mutations sent in each request.
+// The default is 100.
+func WriteMaxBatchRows(n int) WriteOptionFn {
+	if n < 1 {
+		panic(fmt.Sprintf("bigtableio.WriteMaxBatchRows max rows must be positive. Got: %v", n))
+	}
+	return func(o *writeOption) {
+		o.MaxBatchRows = n
+	}
+}
+
+// WriteMaxBatchBytes limits the size in bytes of the mutations sent in each
+// request. The default is 5 MB.
+func WriteMaxBatchBytes(bytes int) WriteOptionFn {
+	if bytes < 1 {
+		panic(fmt.Sprintf("bigtableio.WriteMaxBatchBytes max bytes must be positive. Got: %v", bytes))
+	}
+	return func(o *writeOption) {
+		o.MaxBatchBytes = bytes
+	}
+}
+
+// WriteMaxInflightRequests limits the number of requests each worker thread
+// sends concurrently. Once the limit is reached, writing blocks until a
+// request completes, which keeps small clusters from being overwhelmed. The
+// default is 10.
+func WriteMaxInflightRequests(n int) WriteOptionFn {
+	if n < 1 {
+		panic(fmt.Sprintf("bigtableio.WriteMaxInflightRequests max requests must be positive. Got: %v", n))
+	}
+	return func(o *writeOption) {
+		o.MaxInflightRequests = n
+	}
+}
+
+// WriteMaxRetries sets the number of times mutations that fail with a
+// retryable error, such as UNAVAILABLE, are retried. The default is 5.
+func WriteMaxRetries(n int) WriteOptionFn {
+	if n < 0 {
+		panic(fmt.Sprintf("bigtableio.WriteMaxRetries max retries must be non-negative. Got: %v", n))
+	}
+	return func(o *writeOption) {
+		o.MaxRetries = n
+	}
+}
+
+// WriteBackoff sets the backoff between retries, which starts at initial and
+// doubles after each retry, up to max. The default is 100ms, up to 10s.
+func WriteBackoff(initial, max time.Duration) WriteOptionFn {
+	if initial <= 0 || max < initial {
+		panic(fmt.Sprintf("bigtableio.WriteBackoff invalid backoff. Got: %v, %v", initial, max))
+	}
+	return func(o *writeOption) {
+		o.InitialBackoff = initial
+		o.MaxBackoff = max
+	}
+}
+
+// FailedMutation is a mutation that couldn't be applied.
+type FailedMutation struct {
+	// Mutation is the mutation.
+	Mutation Mutation
+	// Error describes why the mutation couldn't be applied.
+	Error string
+}
+
+// Write applies the mutations of the given PCollection<Mutation> to the
+// given table. Mutations are sent in batches, with a limited number of
+// requests in flight, and retried with backoff if they fail with a retryable
+// error. Mutations that still fail fail the bundle.
+func Write(s beam.Scope, project, instance, table string, col beam.PCollection, opts ...WriteOptionFn) {
+	s = s.Scope("bigtableio.Write")
+
+	o := newWriteOption(opts)
+	beam.ParDo0(s, &writeFn{Table: tableName(project, instance, table), Options: o}, col)
+}
+
+// WriteWithFailures applies the mutations of the given PCollection<Mutation>
+// to the given table, like Write, but returns the mutations that couldn't be
+// applied as a PCollection<FailedMutation> instead of failing the bundle.
+// Failed mutations are output in the global window.
+func WriteWithFailures(s beam.Scope, project, instance, table string, col beam.PCollection, opts ...WriteOptionFn) beam.PCollection {
+	s = s.Scope("bigtableio.WriteWithFailures")
+
+	o := newWriteOption(opts)
+	return beam.ParDo(s, &writeWithFailuresFn{Table: tableName(project, instance, table), Options: o}, col)
+}
+
+// writeFn applies mutations, and fails if any can't be applied.
+type writeFn struct {
+	// Table is the name of the table.
+	Table string `json:"table"`
+	// Options specifies the batching and retries.
+	Options writeOption `json:"options"`
+
+	conn *grpc.ClientConn
+	w    *bulkWriter
+}
+
+func (f *writeFn) Setup(ctx context.Context) error {
+	conn, err := dial(ctx)
+	if err != nil {
+		return err
+	}
+	f.conn = conn
+	return nil
+}
+
+func (f *writeFn) StartBundle(ctx context.Context) {
+	f.w = newBulkWriter(btpb.NewBigtableClient(f.conn), f.Table, f.Options)
+}
+
+func (f *writeFn) ProcessElement(ctx context.Context, m Mutation) error {
+	f.w.Add(ctx, m)
+	return failure(f.w.Failed())
+}
+
+func (f *writeFn) FinishBundle(ctx context.Context) error {
+	return failure(f.w.Close(ctx))
+}
+
+func (f *writeFn) Teardown() error {
+	if f.conn == nil {
+		return nil
+	}
+	return f.conn.Close()
+}
+
+// failure returns an error describing the failed mutations, if any.
+func failure(failed []FailedMutation) error {
+	if len(failed) == 0 {
+		return nil
+	}
+	return errors.Errorf("bigtable write error: %v mutations failed, such as of row %q: %v", len(failed), failed[0].Mutation.Key, failed[0].Error)
+}
+
+// writeWithFailuresFn applies mutations, and outputs those that can't be
+// applied.
+type writeWithFailuresFn struct {
+	// Table is the name of the table.
+	Table string `json:"table"`
+	// Options specifies the batching and retries.
+	Options writeOption `json:"options"`
+
+	conn *grpc.ClientConn
+	w    *bulkWriter
+}
+
+func (f *writeWithFailuresFn) Setup(ctx context.Context) error {
+	conn, err := dial(ctx)
+	if err != nil {
+		return err
+	}
+	f.conn = conn
+	return nil
+}
+
+func (f *writeWithFailuresFn) StartBundle(ctx context.Context, _ func(FailedMutation)) {
+	f.w = newBulkWriter(btpb.NewBigtableClient(f.conn), f.Table, f.Options)
+}
+
+func (f *writeWithFailuresFn) ProcessElement(ctx context.Context, m Mutation, emit func(FailedMutation)) {
+	f.w.Add(ctx, m)
+	for _, failed := range f.w.Failed() {
+		emit(failed)
+	}
+}
+
+func (f *writeWithFailuresFn) FinishBundle(ctx context.Context, emit func(FailedMutation)) {
+	for _, failed := range f.w.Close(ctx) {
+		emit(failed)
+	}
+}
+
+func (f *writeWithFailuresFn) Teardown() error {
+	if f.conn == nil {
+		return nil
+	}
+	return f.conn.Close()
+}
+
+// bulkWriter sends mutations to a table in batches, concurrently.
+type bulkWriter struct {
+	client btpb.BigtableClient
+	table  string
+	opts   writeOption
+
+	batch     []*btpb.MutateRowsRequest_Entry
+	mutations []Mutation // The mutations of the batch.
+	size      int
+
+	inflight chan struct{} // Holds a value per request in flight.
+	wg       sync.WaitGroup
+	mu       sync.Mutex
+	failed   []FailedMutation
+}
+
+func newBulkWriter(client btpb.BigtableClient, table string, opts writeOption) *bulkWriter {
+	return &bulkWriter{
+		client:   client,
+		table:    table,
+		opts:     opts,
+		inflight: make(chan struct{}, opts.MaxInflightRequests),
+	}
+}
+
+// Add adds the mutation to the batch, and sends the batch if it's full.
+// Add blocks while the maximum number of requests are in flight.
+func (w *bulkWriter) Add(ctx context.Context, m Mutation) {
+	entry, err := m.entry()
+	if err != nil {
+		w.fail(FailedMutation{Mutation: m, Error: err.Error()})
+		return
+	}
+	size := proto.Size(entry)
+	if len(w.batch) > 0 && (len(w.batch)+1 > w.opts.MaxBatchRows || w.size+size > w.opts.MaxBatchBytes) {
+		w.flush(ctx)
+	}
+	w.batch = append(w.batch, entry)
+	w.mutations = append(w.mutations, m)
+	w.size += size
+}
+
+// Failed returns the mutations that failed since the last call.
+func (w *bulkWriter) Failed() []FailedMutation {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	failed := w.failed
+	w.failed = nil
+	return failed
+}
+
+// Close sends the remaining batch, waits for the requests in flight and
+// returns the mutations that failed since the last call to Failed.
+func (w *bulkWriter) Close(ctx context.Context) []FailedMutation {
+	w.flush(ctx)
+	w.wg.Wait()
+	return w.Failed()
+}
+
+func (w *bulkWriter) fail(failed ...FailedMutation) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.failed = append(w.failed, failed...)
+}
+
+// flush sends the batch in a new request.
+func (w *bulkWriter) flush(ctx context.Context) {
+	if len(w.batch) == 0 {
+		return
+	}
+	entries, mutations := w.batch, w.mutations
+	w.batch, w.mutations, w.size = nil, nil, 0
+
+	w.inflight <- struct{}{}
+	w.wg.Add(1)
+	go func() {
+		defer w.wg.Done()
+		defer func() { <-w.inflight }()
+
+		w.fail(w.mutate(ctx, entries, mutations)...)
+	}()
+}
+
+// mutate applies the mutations, retrying those that fail with a retryable
+// error, and returns those that fail.
+func (w *bulkWriter) mutate(ctx context.Context, entries []*btpb.MutateRowsRequest_Entry, mutations []Mutation) []FailedMutation {
+	var failed []FailedMutation
+	backoff := w.opts.InitialBackoff
+	for attempt := 0; ; attempt++ {
+		errs := w.send(ctx, entries)
+
+		var retryEntries []*btpb.MutateRowsRequest_Entry
+		var retryMutations []Mutation
+		var lastErr error
+		for i, err := range errs {
+			switch {
+			case err == nil:
+			case isRetryable(err) && attempt < w.opts.MaxRetries:
+				retryEntries = append(retryEntries, entries[i])
+				retryMutations = append(retryMutations, mutations[i])
+				lastErr = err
+			default:
+				failed = append(failed, FailedMutation{Mutation: mutations[i], Error: err.Error()})
+			}
+		}
+		if len(retryEntries) == 0 {
+			return failed
+		}
+		log.Warnf(ctx, "Retrying %v mutations of %v in %v: %v", len(retryEntries), w.table, backoff, lastErr)
+		time.Sleep(backoff)
+		if backoff *= 2; backoff > w.opts.MaxBackoff {
+			backoff = w.opts.MaxBackoff
+		}
+		entries, mutations = retryEntries, retryMutations
+	}
+}
+
+// send sends the entries in a request, and returns the error of each, or nil
+// if it was applied.
+func (w *bulkWriter) send(ctx context.Context, entries []*btpb.MutateRowsRequest_Entry) []error {
+	errs := make([]error, len(entries))
+	stream, err := w.client.MutateRows(ctx, &btpb.MutateRowsRequest{TableName: w.table, Entries: entries})
+	if err == nil {
+		var resp *btpb.MutateRowsResponse
+		for resp, err = stream.Recv(); err == nil; resp, err = stream.Recv() {
+			for _, e := range resp.GetEntries() {
+				if i := e.GetIndex(); i >= 0 && int(i) < len(errs) {
+					errs[i] = status.ErrorProto(e.GetStatus())
+					if errs[i] == nil {
+						// Mark the entry as applied.
+						errs[i] = errApplied
+					}
+				}
+			}
+		}
+		if err == io.EOF {
+			// Entries without a status in the response weren't applied.
+			err = status.Error(codes.Unavailable, "no status in the response")
+		}
+	}
+	for i := range errs {
+		switch errs[i] {
+		case nil:
+			errs[i] = err
+		case errApplied:
+			errs[i] = nil
+		}
+	}
+	return errs
+}
+
+// errApplied marks applied entries in send.
+var errApplied = errors.New("applied")
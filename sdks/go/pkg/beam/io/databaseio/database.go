@@ -23,9 +23,8 @@ import (
 	"fmt"
 	"github.com/apache/beam/sdks/v2/go/pkg/beam"
 	"github.com/apache/beam/sdks/v2/go/pkg/beam/internal/errors"
-	"github.com/apache/beam/sdks/v2/go/pkg/beam/log"
 	"reflect"
-	"strings"
+	"time"
 )
 
 func init() {
@@ -33,8 +32,16 @@ func init() {
 	beam.RegisterType(reflect.TypeOf((*writeFn)(nil)).Elem())
 }
 
-// writeSizeLimit is the maximum number of rows allowed to a write.
-const writeRowLimit = 1000
+const (
+	// writeRowLimit is the default number of rows of each INSERT statement.
+	writeRowLimit = 1000
+	// defaultTransactionSize is the default number of rows of each
+	// transaction.
+	defaultTransactionSize = 1000
+	defaultMaxRetries      = 3
+	defaultInitialBackoff  = 500 * time.Millisecond
+	defaultMaxBackoff      = 10 * time.Second
+)
 
 // Read reads all rows from the given table. The table must have a schema
 // compatible with the given type, t, and Read returns a PCollection<t>. If the
@@ -84,57 +91,167 @@ func (f *queryFn) ProcessElement(ctx context.Context, _ []byte, emit func(beam.X
 		return errors.Wrapf(err, "failed to run query: %v", f.Query)
 	}
 	defer rows.Close()
-	var mapper rowMapper
-	var columns []string
+	r := &rowReader{t: f.Type.T}
 	for rows.Next() {
-		reflectRow := reflect.New(f.Type.T)
-		row := reflectRow.Interface() // row : *T
-		if mapper == nil {
-			columns, err = rows.Columns()
-			if err != nil {
-				return err
-			}
-			columnsTypes, _ := rows.ColumnTypes()
-			if mapper, err = newQueryMapper(columns, columnsTypes, f.Type.T); err != nil {
-				return errors.WithContext(err, "creating rowValues mapper")
-			}
-		}
-		rowValues, err := mapper(reflectRow)
+		row, _, err := r.read(rows)
 		if err != nil {
-			return err
+			return errors.Wrapf(err, "failed to scan %v", f.Query)
 		}
-		err = rows.Scan(rowValues...)
+		emit(row)
+	}
+	return nil
+}
+
+// rowReader scans rows into values of a type.
+type rowReader struct {
+	t       reflect.Type
+	mapper  rowMapper
+	columns []string
+}
+
+// read scans the current row, and returns its value and the values of its
+// columns.
+func (r *rowReader) read(rows *sql.Rows) (interface{}, []interface{}, error) {
+	reflectRow := reflect.New(r.t)
+	row := reflectRow.Interface() // row : *T
+	if r.mapper == nil {
+		columns, err := rows.Columns()
 		if err != nil {
-			return errors.Wrapf(err, "failed to scan %v", f.Query)
+			return nil, nil, err
 		}
-		if loader, ok := row.(MapLoader); ok {
-			asDereferenceSlice(rowValues)
-			loader.LoadMap(asMap(columns, rowValues))
-		} else if loader, ok := row.(SliceLoader); ok {
-			asDereferenceSlice(rowValues)
-			loader.LoadSlice(rowValues)
+		columnsTypes, _ := rows.ColumnTypes()
+		if r.mapper, err = newQueryMapper(columns, columnsTypes, r.t); err != nil {
+			return nil, nil, errors.WithContext(err, "creating rowValues mapper")
 		}
-		emit(reflect.ValueOf(row).Elem().Interface()) // emit(*row)
+		r.columns = columns
 	}
-	return nil
+	rowValues, err := r.mapper(reflectRow)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err = rows.Scan(rowValues...); err != nil {
+		return nil, nil, err
+	}
+	if loader, ok := row.(MapLoader); ok {
+		asDereferenceSlice(rowValues)
+		loader.LoadMap(asMap(r.columns, rowValues))
+	} else if loader, ok := row.(SliceLoader); ok {
+		asDereferenceSlice(rowValues)
+		loader.LoadSlice(rowValues)
+	}
+	return reflect.ValueOf(row).Elem().Interface(), rowValues, nil // *row
 }
 
-// Write writes the elements of the given PCollection<T> to database, if columns left empty all table columns are used to insert into, otherwise selected
-func Write(s beam.Scope, driver, dsn, table string, columns []string, col beam.PCollection) {
+// writeOption holds the options of Write.
+type writeOption struct {
+	BatchSize       int
+	TransactionSize int
+	MaxRetries      int
+	InitialBackoff  time.Duration
+	MaxBackoff      time.Duration
+	// ConflictColumns are the columns of rows that are updated on conflict,
+	// if set.
+	ConflictColumns []string
+}
+
+// WriteOptionFn is an option for Write.
+type WriteOptionFn func(*writeOption)
+
+// WriteBatchSize sets the number of rows inserted by each INSERT statement.
+// The default is 1000.
+func WriteBatchSize(n int) WriteOptionFn {
+	if n < 1 {
+		panic(fmt.Sprintf("databaseio.WriteBatchSize size must be positive. Got: %v", n))
+	}
+	return func(o *writeOption) {
+		o.BatchSize = n
+	}
+}
+
+// WriteTransactionSize sets the number of rows written in each transaction,
+// in as many batches as needed. The rows of a bundle are also written at the
+// end of the bundle. The default is 1000.
+func WriteTransactionSize(n int) WriteOptionFn {
+	if n < 1 {
+		panic(fmt.Sprintf("databaseio.WriteTransactionSize size must be positive. Got: %v", n))
+	}
+	return func(o *writeOption) {
+		o.TransactionSize = n
+	}
+}
+
+// WriteMaxRetries sets the number of times transactions that fail with a
+// transient error, such as a lost connection or a deadlock, are retried. The
+// default is 3.
+func WriteMaxRetries(n int) WriteOptionFn {
+	if n < 0 {
+		panic(fmt.Sprintf("databaseio.WriteMaxRetries max retries must be non-negative. Got: %v", n))
+	}
+	return func(o *writeOption) {
+		o.MaxRetries = n
+	}
+}
+
+// WriteBackoff sets the backoff between retries, which starts at initial and
+// doubles after each retry, up to max. The default is 500ms, up to 10s.
+func WriteBackoff(initial, max time.Duration) WriteOptionFn {
+	if initial <= 0 || max < initial {
+		panic(fmt.Sprintf("databaseio.WriteBackoff invalid backoff. Got: %v, %v", initial, max))
+	}
+	return func(o *writeOption) {
+		o.InitialBackoff = initial
+		o.MaxBackoff = max
+	}
+}
+
+// WriteUpsert sets rows that conflict with existing rows on the given
+// columns, such as their primary key, to update the existing rows instead of
+// failing, so that writes can be rerun. Upserts are supported by the
+// PostgreSQL ("postgres", "pgx") and MySQL ("mysql") drivers. With
+// PostgreSQL, rows are upserted with INSERT ... ON CONFLICT, and the columns
+// must have a unique index. With MySQL, rows are upserted with INSERT ... ON
+// DUPLICATE KEY UPDATE on conflicts with any unique index, and the columns
+// aren't updated.
+//
+// Rows of a batch must not conflict with each other.
+func WriteUpsert(columns ...string) WriteOptionFn {
+	if len(columns) == 0 {
+		panic("databaseio.WriteUpsert conflict columns must not be empty")
+	}
+	return func(o *writeOption) {
+		o.ConflictColumns = columns
+	}
+}
+
+// Write writes the elements of the given PCollection<T> to database, if columns left empty all table columns are used to insert into, otherwise selected.
+// Rows are inserted with prepared batched INSERT statements, in transactions
+// that are retried with backoff if they fail with a transient error. Writes
+// are counted by the "rows_written" and "rows_retried" counters of the
+// "databaseio" namespace.
+func Write(s beam.Scope, driver, dsn, table string, columns []string, col beam.PCollection, opts ...WriteOptionFn) {
 	t := col.Type().Type()
 	s = s.Scope(driver + ".Write")
-	pre := beam.AddFixedKey(s, col)
-	post := beam.GroupByKey(s, pre)
-	beam.ParDo0(s, &writeFn{Driver: driver, Dsn: dsn, Table: table, Columns: columns, BatchSize: writeRowLimit, Type: beam.EncodedType{T: t}}, post)
+	o := writeOption{
+		BatchSize:       writeRowLimit,
+		TransactionSize: defaultTransactionSize,
+		MaxRetries:      defaultMaxRetries,
+		InitialBackoff:  defaultInitialBackoff,
+		MaxBackoff:      defaultMaxBackoff,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if len(o.ConflictColumns) > 0 {
+		if _, ok := dialects[driver]; !ok {
+			panic(fmt.Sprintf("databaseio.Write upserts aren't supported by driver %v", driver))
+		}
+	}
+	beam.ParDo0(s, &writeFn{Driver: driver, Dsn: dsn, Table: table, Columns: columns, Options: o, Type: beam.EncodedType{T: t}}, col)
 }
 
 // WriteWithBatchSize writes the elements of the given PCollection<T> to database with custom batch size. Batch size control number of elements in the batch INSERT statement.
 func WriteWithBatchSize(s beam.Scope, batchSize int, driver, dsn, table string, columns []string, col beam.PCollection) {
-	t := col.Type().Type()
-	s = s.Scope(driver + ".Write")
-	pre := beam.AddFixedKey(s, col)
-	post := beam.GroupByKey(s, pre)
-	beam.ParDo0(s, &writeFn{Driver: driver, Dsn: dsn, Table: table, Columns: columns, BatchSize: batchSize, Type: beam.EncodedType{T: t}}, post)
+	Write(s, driver, dsn, table, columns, col, WriteBatchSize(batchSize))
 }
 
 type writeFn struct {
@@ -146,75 +263,87 @@ type writeFn struct {
 	Table string `json:"table"`
 	// Columns to inserts, if empty then all columns
 	Columns []string `json:"columns"`
-	//BatchSize size
-	BatchSize int `json:"batchSize"`
+	// Options specifies the batching and retries.
+	Options writeOption `json:"options"`
 	// Type is the encoded schema type.
 	Type beam.EncodedType `json:"type"`
+
+	db      *sql.DB
+	columns []string
+	mapper  rowMapper
+	writer  *writer
 }
 
-func (f *writeFn) ProcessElement(ctx context.Context, _ int, iter func(*beam.X) bool) error {
-	//TODO move DB Open and Close to Setup and Teardown methods or StartBundle and FinishBundle
+func (f *writeFn) Setup(ctx context.Context) error {
 	db, err := sql.Open(f.Driver, f.Dsn)
 	if err != nil {
 		return errors.Wrapf(err, "failed to open database: %v", f.Driver)
 	}
-	defer db.Close()
-	projection := "*"
+	f.db = db
+	if f.columns, err = f.discoverColumns(ctx); err != nil {
+		return err
+	}
+	if f.mapper, err = newWriterRowMapper(f.columns, f.Type.T); err != nil {
+		return errors.WithContext(err, "creating row mapper")
+	}
+	f.writer, err = newWriter(f.Driver, f.Options, f.Table, f.columns)
+	return err
+}
+
+// discoverColumns returns the columns to insert into, which are all columns
+// of the table unless they're set.
+func (f *writeFn) discoverColumns(ctx context.Context) ([]string, error) {
 	if len(f.Columns) > 0 {
-		projection = strings.Join(f.Columns, ",")
+		return f.Columns, nil
 	}
-	dql := fmt.Sprintf("SELECT %v FROM  %v WHERE 1 = 0", projection, f.Table)
-	query, err := db.Prepare(dql)
+	dql := fmt.Sprintf("SELECT * FROM  %v WHERE 1 = 0", f.Table)
+	query, err := f.db.PrepareContext(ctx, dql)
 	if err != nil {
-		return errors.Wrapf(err, "failed to prepare query: %v", f.Table)
+		return nil, errors.Wrapf(err, "failed to prepare query: %v", f.Table)
 	}
 	defer query.Close()
-	rows, err := query.Query()
+	rows, err := query.QueryContext(ctx)
 	if err != nil {
-		return errors.Wrapf(err, "failed to query: %v", f.Table)
+		return nil, errors.Wrapf(err, "failed to query: %v", f.Table)
 	}
+	defer rows.Close()
 	columns, err := rows.Columns()
 	if err != nil {
-		return errors.Wrapf(err, "failed to discover column: %v", f.Table)
-	}
-	//TODO move to Setup methods
-	mapper, err := newWriterRowMapper(columns, f.Type.T)
-	if err != nil {
-		return errors.WithContext(err, "creating row mapper")
-	}
-	writer, err := newWriter(f.BatchSize, f.Table, columns)
-	if err != nil {
-		return err
+		return nil, errors.Wrapf(err, "failed to discover column: %v", f.Table)
 	}
-	var val beam.X
-	for iter(&val) {
-		var row []interface{}
+	return columns, nil
+}
+
+func (f *writeFn) ProcessElement(ctx context.Context, val beam.X) error {
+	var row []interface{}
+	var err error
+	if writer, ok := val.(Writer); ok {
 		var data map[string]interface{}
-		if writer, ok := val.(Writer); ok {
-			if data, err = writer.SaveData(); err == nil {
-				row = make([]interface{}, len(columns))
-				for i, column := range columns {
-					row[i] = data[column]
-				}
+		if data, err = writer.SaveData(); err == nil {
+			row = make([]interface{}, len(f.columns))
+			for i, column := range f.columns {
+				row[i] = data[column]
 			}
-		} else {
-			row, err = mapper(reflect.ValueOf(val))
-		}
-		if err != nil {
-			return errors.Wrapf(err, "failed to map row %T", val)
-		}
-		if err = writer.add(row); err != nil {
-			return err
-		}
-		if err := writer.writeBatchIfNeeded(ctx, db); err != nil {
-			return err
 		}
+	} else {
+		row, err = f.mapper(reflect.ValueOf(val))
 	}
-
-	if err := writer.writeIfNeeded(ctx, db); err != nil {
+	if err != nil {
+		return errors.Wrapf(err, "failed to map row %T", val)
+	}
+	if err = f.writer.add(row); err != nil {
 		return err
 	}
+	return f.writer.writeIfNeeded(ctx, f.db)
+}
 
-	log.Infof(ctx, "written %v row(s) into %v", writer.totalCount, f.Table)
-	return nil
+func (f *writeFn) FinishBundle(ctx context.Context) error {
+	return f.writer.write(ctx, f.db)
+}
+
+func (f *writeFn) Teardown() error {
+	if f.db == nil {
+		return nil
+	}
+	return f.db.Close()
 }
@@ -0,0 +1,303 @@
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements.  See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License.  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package databaseio
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/apache/beam/sdks/v2/go/pkg/beam"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/core/sdf"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/internal/errors"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/io/rtrackers/offsetrange"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/log"
+)
+
+func init() {
+	beam.RegisterType(reflect.TypeOf((*columnBounds)(nil)).Elem())
+	beam.RegisterType(reflect.TypeOf((*boundsFn)(nil)).Elem())
+	beam.RegisterType(reflect.TypeOf((*partitionedQueryFn)(nil)).Elem())
+}
+
+// ReadPartitioned reads all rows from the given table in parallel, by
+// partitioning them into the given number of ranges of values of the given
+// column. The column must be numeric, or a date or time, and is ideally
+// indexed and evenly distributed. The table must have a schema compatible
+// with the given type, t, and ReadPartitioned returns a PCollection<t>. For
+// example:
+//
+//	rows := databaseio.ReadPartitioned(s, "mysql", dsn, "orders", "id", 16, reflect.TypeOf(Order{}))
+//
+// The range of values is discovered from the minimum and maximum values of
+// the column, and each partition is read with a query of its range, so rows
+// whose column is NULL aren't read. Fractional values are partitioned by
+// their integer part, and dates and times by microsecond. Columns whose
+// maximum value is the maximum int64, math.MaxInt64, can't be partitioned.
+func ReadPartitioned(s beam.Scope, driver, dsn, table, column string, partitions int, t reflect.Type) beam.PCollection {
+	s = s.Scope(driver + ".ReadPartitioned")
+	if partitions < 1 {
+		panic(fmt.Sprintf("databaseio.ReadPartitioned partitions must be positive. Got: %v", partitions))
+	}
+	imp := beam.Impulse(s)
+	bounds := beam.ParDo(s, &boundsFn{Driver: driver, Dsn: dsn, Table: table, Column: column}, imp)
+	return beam.ParDo(s, &partitionedQueryFn{Driver: driver, Dsn: dsn, Table: table, Column: column, Partitions: partitions, Type: beam.EncodedType{T: t}}, bounds, beam.TypeDefinition{Var: beam.XType, T: t})
+}
+
+// columnBounds are the bounds of the positions of the values of a column,
+// which are integers, or microseconds since the epoch if Time is set.
+type columnBounds struct {
+	// Start is the position of the minimum value.
+	Start int64
+	// End is the position after the maximum value.
+	End int64
+	// Time is whether the column is a date or time.
+	Time bool
+}
+
+// arg returns the query argument of the position.
+func (b columnBounds) arg(pos int64) interface{} {
+	if b.Time {
+		return time.UnixMicro(pos).UTC()
+	}
+	return pos
+}
+
+// position returns the position of the value of a column, which may be
+// behind pointers or interfaces.
+func position(value interface{}) (int64, error) {
+	v := reflect.ValueOf(value)
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return 0, errors.New("column is NULL")
+		}
+		v = v.Elem()
+	}
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int(), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return int64(v.Uint()), nil
+	case reflect.Float32, reflect.Float64:
+		return int64(math.Floor(v.Float())), nil
+	case reflect.String:
+		return parsePosition(v.String())
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return parsePosition(string(v.Bytes()))
+		}
+	case reflect.Struct:
+		if t, ok := v.Interface().(time.Time); ok {
+			return t.UnixMicro(), nil
+		}
+	}
+	return 0, errors.Errorf("unsupported partition column type %v", v.Type())
+}
+
+// parsePosition returns the position of a numeric value formatted as text,
+// as some drivers return them.
+func parsePosition(s string) (int64, error) {
+	if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return i, nil
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, errors.Errorf("unsupported partition column value %q", s)
+	}
+	return int64(math.Floor(f)), nil
+}
+
+// boundsFn discovers the bounds of the values of a column.
+type boundsFn struct {
+	// Driver is the database driver.
+	Driver string `json:"driver"`
+	// Dsn is the data source name.
+	Dsn string `json:"dsn"`
+	// Table is the table identifier.
+	Table string `json:"table"`
+	// Column is the partition column.
+	Column string `json:"column"`
+}
+
+func (f *boundsFn) ProcessElement(ctx context.Context, _ []byte, emit func(columnBounds)) error {
+	db, err := sql.Open(f.Driver, f.Dsn)
+	if err != nil {
+		return errors.Wrapf(err, "failed to open database: %v", f.Driver)
+	}
+	defer db.Close()
+	min, err := f.bound(ctx, db, "ASC")
+	if err != nil {
+		return err
+	}
+	if min == nil {
+		log.Infof(ctx, "No rows to read from %v", f.Table)
+		return nil
+	}
+	max, err := f.bound(ctx, db, "DESC")
+	if err != nil {
+		return err
+	}
+	start, err := position(min)
+	if err != nil {
+		return errors.Wrapf(err, "failed to partition %v by %v", f.Table, f.Column)
+	}
+	end, err := position(max)
+	if err != nil {
+		return errors.Wrapf(err, "failed to partition %v by %v", f.Table, f.Column)
+	}
+	// Ranges exclude their end, so a range can't include the maximum int64.
+	if end == math.MaxInt64 {
+		return errors.Errorf("failed to partition %v by %v: its maximum value is the maximum int64", f.Table, f.Column)
+	}
+	end++
+	_, isTime := min.(time.Time)
+	log.Infof(ctx, "Reading %v with %v from %v to %v", f.Table, f.Column, start, end)
+	emit(columnBounds{Start: start, End: end, Time: isTime})
+	return nil
+}
+
+// bound returns the first non-NULL value of the column in the order, or nil
+// if there is none.
+func (f *boundsFn) bound(ctx context.Context, db *sql.DB, order string) (interface{}, error) {
+	q := fmt.Sprintf("SELECT %v FROM %v WHERE %v IS NOT NULL ORDER BY %v %v LIMIT 1", f.Column, f.Table, f.Column, f.Column, order)
+	var value interface{}
+	if err := db.QueryRowContext(ctx, q).Scan(&value); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, errors.Wrapf(err, "failed to run query: %v", q)
+	}
+	return value, nil
+}
+
+// partitionedQueryFn reads the rows of partitions of a table. Its restriction
+// is the range of positions of the values of the partition column it reads,
+// and it claims the position of each value as it reads rows in the order of
+// the column, so that partitions can be split further while they're read.
+type partitionedQueryFn struct {
+	// Driver is the database driver.
+	Driver string `json:"driver"`
+	// Dsn is the data source name.
+	Dsn string `json:"dsn"`
+	// Table is the table identifier.
+	Table string `json:"table"`
+	// Column is the partition column.
+	Column string `json:"column"`
+	// Partitions is the number of partitions.
+	Partitions int `json:"partitions"`
+	// Type is the encoded schema type.
+	Type beam.EncodedType `json:"type"`
+
+	db *sql.DB
+}
+
+func (f *partitionedQueryFn) Setup() error {
+	db, err := sql.Open(f.Driver, f.Dsn)
+	if err != nil {
+		return errors.Wrapf(err, "failed to open database: %v", f.Driver)
+	}
+	f.db = db
+	return nil
+}
+
+func (f *partitionedQueryFn) Teardown() error {
+	if f.db == nil {
+		return nil
+	}
+	return f.db.Close()
+}
+
+func (f *partitionedQueryFn) CreateInitialRestriction(b columnBounds) offsetrange.Restriction {
+	return offsetrange.Restriction{Start: b.Start, End: b.End}
+}
+
+// SplitRestriction splits the restriction into a restriction per partition.
+func (f *partitionedQueryFn) SplitRestriction(_ columnBounds, rest offsetrange.Restriction) []offsetrange.Restriction {
+	return rest.EvenSplits(int64(f.Partitions))
+}
+
+func (f *partitionedQueryFn) RestrictionSize(_ columnBounds, rest offsetrange.Restriction) float64 {
+	return rest.Size()
+}
+
+func (f *partitionedQueryFn) CreateTracker(rest offsetrange.Restriction) *sdf.LockRTracker {
+	return sdf.NewLockRTracker(offsetrange.NewTracker(rest))
+}
+
+func (f *partitionedQueryFn) ProcessElement(ctx context.Context, rt *sdf.LockRTracker, b columnBounds, emit func(beam.X)) error {
+	rest := rt.GetRestriction().(offsetrange.Restriction)
+	q := f.query()
+	rows, err := f.db.QueryContext(ctx, q, b.arg(rest.Start), b.arg(rest.End))
+	if err != nil {
+		return errors.Wrapf(err, "failed to run query: %v", q)
+	}
+	defer rows.Close()
+
+	r := &rowReader{t: f.Type.T}
+	index := -1
+	claimed := int64(math.MinInt64)
+	for rows.Next() {
+		row, values, err := r.read(rows)
+		if err != nil {
+			return errors.Wrapf(err, "failed to scan %v", q)
+		}
+		if index < 0 {
+			if index = columnIndex(r.columns, f.Column); index < 0 {
+				return errors.Errorf("query %v has no column %v", q, f.Column)
+			}
+		}
+		pos, err := position(values[index])
+		if err != nil {
+			return errors.Wrapf(err, "failed to partition %v by %v", f.Table, f.Column)
+		}
+		// Rows with the same position are read by the same claim.
+		if pos > claimed {
+			if !rt.TryClaim(pos) {
+				return nil
+			}
+			claimed = pos
+		}
+		emit(row)
+	}
+	if err := rows.Err(); err != nil {
+		return errors.Wrapf(err, "failed to read %v", q)
+	}
+	rt.TryClaim(rest.End)
+	return nil
+}
+
+// query returns the query of the rows of a range of positions, whose
+// arguments are the start and end of the range.
+func (f *partitionedQueryFn) query() string {
+	return fmt.Sprintf("SELECT * FROM %v WHERE %v >= %v AND %v < %v ORDER BY %v",
+		f.Table, f.Column, placeholder(f.Driver, 1), f.Column, placeholder(f.Driver, 2), f.Column)
+}
+
+// columnIndex returns the index of the column in the columns, ignoring case,
+// or -1 if it's not one of them.
+func columnIndex(columns []string, column string) int {
+	for i, c := range columns {
+		if strings.EqualFold(c, column) {
+			return i
+		}
+	}
+	return -1
+}
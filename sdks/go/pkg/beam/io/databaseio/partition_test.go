@@ -0,0 +1,179 @@
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements.  See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License.  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package databaseio
+
+import (
+	"context"
+	"database/sql"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/apache/beam/sdks/v2/go/pkg/beam"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/io/rtrackers/offsetrange"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/testing/passert"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/testing/ptest"
+)
+
+func TestReadPartitioned(t *testing.T) {
+	db, err := sql.Open("ramsql", "user:password@/dbname3")
+	if err != nil {
+		t.Fatalf("Test infra failure: Failed to open database with error %v", err)
+	}
+	defer db.Close()
+	if err = insertTestData(db); err != nil {
+		t.Fatalf("Test infra failure: Failed to create/populate table with error %v", err)
+	}
+
+	p, s := beam.NewPipelineWithRoot()
+	elements := ReadPartitioned(s, "ramsql", "user:password@/dbname3", "address", "street_number", 3, reflect.TypeOf(Address{}))
+	passert.Equals(s, elements, Address{Street: "orchard lane", Street_number: 1}, Address{Street: "morris st", Street_number: 200})
+
+	ptest.RunAndValidate(t, p)
+}
+
+func TestBoundsFn(t *testing.T) {
+	db, err := sql.Open("ramsql", "user:password@/dbname4")
+	if err != nil {
+		t.Fatalf("Test infra failure: Failed to open database with error %v", err)
+	}
+	defer db.Close()
+	if _, err = db.Exec("CREATE TABLE address (street TEXT, street_number INT);"); err != nil {
+		t.Fatalf("Test infra failure: Failed to create table with error %v", err)
+	}
+
+	// An empty table has no bounds.
+	fn := &boundsFn{Driver: "ramsql", Dsn: "user:password@/dbname4", Table: "address", Column: "street_number"}
+	var got []columnBounds
+	emit := func(b columnBounds) { got = append(got, b) }
+	if err := fn.ProcessElement(context.Background(), nil, emit); err != nil {
+		t.Fatalf("ProcessElement() failed: %v", err)
+	}
+	if len(got) != 0 {
+		t.Errorf("ProcessElement() of an empty table = %v, want no bounds", got)
+	}
+
+	for _, q := range []string{
+		"INSERT INTO address (street, street_number) VALUES ('orchard lane', 1);",
+		"INSERT INTO address (street, street_number) VALUES ('morris st', 200);",
+	} {
+		if _, err := db.Exec(q); err != nil {
+			t.Fatalf("Test infra failure: Failed to populate table with error %v", err)
+		}
+	}
+	if err := fn.ProcessElement(context.Background(), nil, emit); err != nil {
+		t.Fatalf("ProcessElement() failed: %v", err)
+	}
+	if want := []columnBounds{{Start: 1, End: 201}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ProcessElement() = %v, want %v", got, want)
+	}
+
+	// The maximum int64 can't be in a range, so its row can't be read.
+	if _, err := db.Exec("INSERT INTO address (street, street_number) VALUES ('max st', 9223372036854775807);"); err != nil {
+		t.Fatalf("Test infra failure: Failed to populate table with error %v", err)
+	}
+	if err := fn.ProcessElement(context.Background(), nil, emit); err == nil {
+		t.Errorf("ProcessElement() with the maximum int64 succeeded, want error")
+	}
+}
+
+func TestPartitionedQueryFn_Split(t *testing.T) {
+	db, err := sql.Open("ramsql", "user:password@/dbname5")
+	if err != nil {
+		t.Fatalf("Test infra failure: Failed to open database with error %v", err)
+	}
+	defer db.Close()
+	if err = insertTestData(db); err != nil {
+		t.Fatalf("Test infra failure: Failed to create/populate table with error %v", err)
+	}
+
+	fn := &partitionedQueryFn{Driver: "ramsql", Dsn: "user:password@/dbname5", Table: "address", Column: "street_number", Partitions: 2, Type: beam.EncodedType{T: reflect.TypeOf(Address{})}}
+	if err := fn.Setup(); err != nil {
+		t.Fatalf("Setup() failed: %v", err)
+	}
+	defer fn.Teardown()
+
+	b := columnBounds{Start: 1, End: 201}
+	splits := fn.SplitRestriction(b, fn.CreateInitialRestriction(b))
+	if want := []offsetrange.Restriction{{Start: 1, End: 101}, {Start: 101, End: 201}}; !reflect.DeepEqual(splits, want) {
+		t.Fatalf("SplitRestriction() = %v, want %v", splits, want)
+	}
+	// Each partition reads the rows in its range.
+	for i, want := range []Address{{Street: "orchard lane", Street_number: 1}, {Street: "morris st", Street_number: 200}} {
+		rt := fn.CreateTracker(splits[i])
+		var got []Address
+		if err := fn.ProcessElement(context.Background(), rt, b, func(x beam.X) { got = append(got, x.(Address)) }); err != nil {
+			t.Fatalf("ProcessElement(%v) failed: %v", splits[i], err)
+		}
+		if !reflect.DeepEqual(got, []Address{want}) {
+			t.Errorf("ProcessElement(%v) = %v, want %v", splits[i], got, want)
+		}
+		if !rt.IsDone() {
+			t.Errorf("ProcessElement(%v) didn't complete its restriction", splits[i])
+		}
+	}
+}
+
+func TestPartitionedQueryFn_Query(t *testing.T) {
+	tests := []struct {
+		driver string
+		want   string
+	}{
+		{"postgres", "SELECT * FROM orders WHERE id >= $1 AND id < $2 ORDER BY id"},
+		{"pgx", "SELECT * FROM orders WHERE id >= $1 AND id < $2 ORDER BY id"},
+		{"mysql", "SELECT * FROM orders WHERE id >= ? AND id < ? ORDER BY id"},
+		{"ramsql", "SELECT * FROM orders WHERE id >= ? AND id < ? ORDER BY id"},
+	}
+	for _, test := range tests {
+		fn := &partitionedQueryFn{Driver: test.driver, Table: "orders", Column: "id"}
+		if got := fn.query(); got != test.want {
+			t.Errorf("query() of %v = %q, want %q", test.driver, got, test.want)
+		}
+	}
+}
+
+func TestPosition(t *testing.T) {
+	i := 5
+	var iface interface{} = int64(-3)
+	ts := time.Date(2022, 5, 1, 0, 0, 0, 1500, time.UTC)
+	tests := []struct {
+		value interface{}
+		want  int64
+	}{
+		{&i, 5},
+		{&iface, -3},
+		{2.5, 2},
+		{-2.5, -3},
+		{[]byte("42"), 42},
+		{"7.9", 7},
+		{ts, ts.UnixMicro()},
+	}
+	for _, test := range tests {
+		got, err := position(test.value)
+		if err != nil || got != test.want {
+			t.Errorf("position(%v) = %v, %v, want %v", test.value, got, err, test.want)
+		}
+	}
+	for _, value := range []interface{}{"a", true, (*int)(nil)} {
+		if _, err := position(value); err == nil {
+			t.Errorf("position(%v) succeeded, want error", value)
+		}
+	}
+	b := columnBounds{Time: true}
+	if got := b.arg(ts.UnixMicro()); !got.(time.Time).Equal(ts.Truncate(time.Microsecond)) {
+		t.Errorf("arg(%v) = %v, want %v", ts.UnixMicro(), got, ts)
+	}
+}
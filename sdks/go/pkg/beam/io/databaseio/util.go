@@ -18,12 +18,39 @@
 package databaseio
 
 import (
+	"fmt"
 	"reflect"
 	"strings"
 
 	"github.com/apache/beam/sdks/v2/go/pkg/beam/internal/errors"
 )
 
+// dialect is the SQL dialect of a database.
+type dialect int
+
+const (
+	postgreSQL dialect = iota
+	mySQL
+)
+
+// dialects are the dialects of the drivers whose SQL differs from that of
+// other databases, such as in placeholders and upserts.
+var dialects = map[string]dialect{
+	"postgres": postgreSQL,
+	"pgx":      postgreSQL,
+	"mysql":    mySQL,
+}
+
+// placeholder returns the placeholder of the nth argument of statements of
+// the driver, counting from 1. PostgreSQL placeholders are numbered, and
+// those of other databases are "?".
+func placeholder(driver string, n int) string {
+	if d, ok := dialects[driver]; ok && d == postgreSQL {
+		return fmt.Sprintf("$%d", n)
+	}
+	return "?"
+}
+
 //mapFields maps column into field index in record type
 func mapFields(columns []string, recordType reflect.Type) ([]int, error) {
 	var indexedFields = map[string]int{}
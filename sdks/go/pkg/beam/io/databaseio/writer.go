@@ -18,86 +18,234 @@
 package databaseio
 
 import (
+	"context"
 	"database/sql"
+	"database/sql/driver"
+	stderrors "errors"
 	"fmt"
-	"golang.org/x/net/context"
 	"strings"
+	"time"
 
+	"github.com/apache/beam/sdks/v2/go/pkg/beam"
 	"github.com/apache/beam/sdks/v2/go/pkg/beam/internal/errors"
 	"github.com/apache/beam/sdks/v2/go/pkg/beam/log"
 )
 
+var (
+	rowsWritten = beam.NewCounter("databaseio", "rows_written")
+	rowsRetried = beam.NewCounter("databaseio", "rows_retried")
+)
+
 // Writer returns a row of data to be inserted into a table.
 type Writer interface {
 	SaveData() (map[string]interface{}, error)
 }
 
+// writer writes rows to a table in transactions of batched INSERT
+// statements.
 type writer struct {
-	batchSize    int
-	table        string
-	sqlTemplate  string
-	valueTempate string
-	binding      []interface{}
-	columnCount  int
-	rowCount     int
-	totalCount   int
+	opts        writeOption
+	table       string
+	sqlTemplate string
+	columnCount int
+	// driver is the database driver, whose placeholders are used.
+	driver string
+	// upsert is the clause that updates conflicting rows, if any.
+	upsert string
+	rows   [][]interface{}
 }
 
 func (w *writer) add(row []interface{}) error {
-	w.rowCount++
-	w.totalCount++
 	if len(row) != w.columnCount {
 		return errors.Errorf("expected %v row values, but had: %v", w.columnCount, len(row))
 	}
-	w.binding = append(w.binding, row...)
+	w.rows = append(w.rows, row)
 	return nil
 }
 
+// writeIfNeeded writes the rows in a transaction, once there are enough rows
+// for one.
+func (w *writer) writeIfNeeded(ctx context.Context, db *sql.DB) error {
+	if len(w.rows) >= w.opts.TransactionSize {
+		return w.write(ctx, db)
+	}
+	return nil
+}
+
+// write writes the rows in a transaction, which is retried with backoff if it
+// fails with a transient error.
 func (w *writer) write(ctx context.Context, db *sql.DB) error {
-	values := strings.Repeat(w.valueTempate+",", w.rowCount)
-	if len(values) == 0 {
-		log.Info(ctx, "No value(s) to be written....")
+	if len(w.rows) == 0 {
 		return nil
 	}
-	SQL := w.sqlTemplate + string(values[:len(values)-1])
-	resultSet, err := db.ExecContext(ctx, SQL, w.binding...)
+	backoff := w.opts.InitialBackoff
+	for attempt := 0; ; attempt++ {
+		err := w.writeTransaction(ctx, db)
+		if err == nil {
+			break
+		}
+		if attempt >= w.opts.MaxRetries || !isTransient(err) {
+			return errors.Wrapf(err, "failed to write %v row(s) into %v", len(w.rows), w.table)
+		}
+		rowsRetried.Inc(ctx, int64(len(w.rows)))
+		log.Warnf(ctx, "Retrying write of %v row(s) into %v in %v: %v", len(w.rows), w.table, backoff, err)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		if backoff *= 2; backoff > w.opts.MaxBackoff {
+			backoff = w.opts.MaxBackoff
+		}
+	}
+	rowsWritten.Inc(ctx, int64(len(w.rows)))
+	w.rows = nil
+	return nil
+}
+
+// writeTransaction writes the rows in batches in a transaction, with
+// statements prepared in the transaction for each batch size.
+func (w *writer) writeTransaction(ctx context.Context, db *sql.DB) error {
+	tx, err := db.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
-	affected, _ := resultSet.RowsAffected()
-	if int(affected) != w.rowCount {
-		return errors.Errorf("expected to write: %v, but written: %v", w.rowCount, affected)
+	statements := map[int]*sql.Stmt{}
+	for start := 0; start < len(w.rows); start += w.opts.BatchSize {
+		end := start + w.opts.BatchSize
+		if end > len(w.rows) {
+			end = len(w.rows)
+		}
+		stmt, ok := statements[end-start]
+		if !ok {
+			if stmt, err = tx.PrepareContext(ctx, w.statement(end-start)); err != nil {
+				tx.Rollback()
+				return errors.Wrapf(err, "failed to prepare statement: %v", w.sqlTemplate)
+			}
+			statements[end-start] = stmt
+		}
+		if err := w.writeBatch(ctx, stmt, w.rows[start:end]); err != nil {
+			tx.Rollback()
+			return err
+		}
 	}
-	w.binding = []interface{}{}
-	w.rowCount = 0
-	return nil
+	return tx.Commit()
 }
 
-func (w *writer) writeBatchIfNeeded(ctx context.Context, db *sql.DB) error {
-	if w.rowCount >= w.batchSize {
-		return w.write(ctx, db)
+// writeBatch writes the rows with the statement.
+func (w *writer) writeBatch(ctx context.Context, stmt *sql.Stmt, rows [][]interface{}) error {
+	binding := make([]interface{}, 0, len(rows)*w.columnCount)
+	for _, row := range rows {
+		binding = append(binding, row...)
+	}
+	resultSet, err := stmt.ExecContext(ctx, binding...)
+	if err != nil {
+		return err
+	}
+	// Upserted rows may be counted as affected twice, or not at all, so only
+	// inserts are checked.
+	if w.upsert != "" {
+		return nil
+	}
+	affected, err := resultSet.RowsAffected()
+	if err == nil && int(affected) != len(rows) {
+		return errors.Errorf("expected to write: %v, but written: %v", len(rows), affected)
 	}
 	return nil
 }
 
-func (w *writer) writeIfNeeded(ctx context.Context, db *sql.DB) error {
-	if w.rowCount >= 0 {
-		return w.write(ctx, db)
+// statement returns the statement that inserts the number of rows.
+func (w *writer) statement(rows int) string {
+	values := make([]string, rows)
+	placeholders := make([]string, w.columnCount)
+	for i := range values {
+		for j := range placeholders {
+			placeholders[j] = placeholder(w.driver, i*w.columnCount+j+1)
+		}
+		values[i] = "(" + strings.Join(placeholders, ",") + ")"
 	}
-	return nil
+	return w.sqlTemplate + strings.Join(values, ",") + w.upsert
 }
 
-func newWriter(batchSize int, table string, columns []string) (*writer, error) {
+// isTransient returns whether the error is transient, so that the
+// transaction that failed with it can be retried, such as when its
+// connection was lost, or it deadlocked or failed to serialize.
+func isTransient(err error) bool {
+	if stderrors.Is(err, driver.ErrBadConn) || stderrors.Is(err, sql.ErrConnDone) {
+		return true
+	}
+	// PostgreSQL drivers report the SQLSTATE of errors, whose classes 08, 40
+	// and 53 are connection exceptions, transaction rollbacks and
+	// insufficient resources.
+	var state interface{ SQLState() string }
+	if stderrors.As(err, &state) {
+		code := state.SQLState()
+		return strings.HasPrefix(code, "08") || strings.HasPrefix(code, "40") || strings.HasPrefix(code, "53")
+	}
+	// MySQL deadlocks and lock wait timeouts, as reported by its driver.
+	msg := err.Error()
+	return strings.HasPrefix(msg, "Error 1213:") || strings.HasPrefix(msg, "Error 1205:")
+}
+
+// upsertClause returns the clause of INSERT statements of the driver that
+// updates the rows that conflict on the conflict columns.
+func upsertClause(driver string, columns, conflict []string) (string, error) {
+	d, ok := dialects[driver]
+	if !ok {
+		return "", errors.Errorf("upserts aren't supported by driver %v", driver)
+	}
+	for _, column := range conflict {
+		if columnIndex(columns, column) < 0 {
+			return "", errors.Errorf("conflict column %v isn't written", column)
+		}
+	}
+	var updated []string
+	for _, column := range columns {
+		if columnIndex(conflict, column) < 0 {
+			updated = append(updated, column)
+		}
+	}
+	var set []string
+	switch d {
+	case postgreSQL:
+		target := strings.Join(conflict, ",")
+		if len(updated) == 0 {
+			return fmt.Sprintf(" ON CONFLICT (%v) DO NOTHING", target), nil
+		}
+		for _, column := range updated {
+			set = append(set, fmt.Sprintf("%v = EXCLUDED.%v", column, column))
+		}
+		return fmt.Sprintf(" ON CONFLICT (%v) DO UPDATE SET %v", target, strings.Join(set, ",")), nil
+	default:
+		// MySQL has no clause to ignore conflicts, so a conflict column is
+		// set to itself instead.
+		if len(updated) == 0 {
+			return fmt.Sprintf(" ON DUPLICATE KEY UPDATE %v = %v", conflict[0], conflict[0]), nil
+		}
+		for _, column := range updated {
+			set = append(set, fmt.Sprintf("%v = VALUES(%v)", column, column))
+		}
+		return " ON DUPLICATE KEY UPDATE " + strings.Join(set, ","), nil
+	}
+}
+
+func newWriter(driver string, opts writeOption, table string, columns []string) (*writer, error) {
 	if len(columns) == 0 {
 		return nil, errors.New("columns were empty")
 	}
-	values := strings.Repeat("?,", len(columns))
+	var upsert string
+	if len(opts.ConflictColumns) > 0 {
+		var err error
+		if upsert, err = upsertClause(driver, columns, opts.ConflictColumns); err != nil {
+			return nil, err
+		}
+	}
 	return &writer{
-		batchSize:    batchSize,
-		columnCount:  len(columns),
-		table:        table,
-		binding:      make([]interface{}, 0),
-		sqlTemplate:  fmt.Sprintf("INSERT INTO %v(%v) VALUES", table, strings.Join(columns, ",")),
-		valueTempate: fmt.Sprintf("(%s)", values[:len(values)-1]),
+		opts:        opts,
+		columnCount: len(columns),
+		table:       table,
+		sqlTemplate: fmt.Sprintf("INSERT INTO %v(%v) VALUES", table, strings.Join(columns, ",")),
+		driver:      driver,
+		upsert:      upsert,
 	}, nil
 }
@@ -0,0 +1,323 @@
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements.  See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License.  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package databaseio
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/apache/beam/sdks/v2/go/pkg/beam"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/testing/ptest"
+)
+
+func init() {
+	sql.Register("fakedb", fakeDriver{})
+}
+
+// sqlStateError is an error with a SQLSTATE, as reported by PostgreSQL
+// drivers.
+type sqlStateError string
+
+func (e sqlStateError) Error() string    { return "error " + string(e) }
+func (e sqlStateError) SQLState() string { return string(e) }
+
+// fakeDB records the transactions committed to it, as the number of rows
+// inserted by each of their statements. Statements fail with the errors in
+// failures, in order, until there are none left.
+type fakeDB struct {
+	mu        sync.Mutex
+	failures  []error
+	execs     int
+	prepared  []string
+	committed [][]int
+}
+
+var (
+	fakeDBsMu sync.Mutex
+	fakeDBs   = map[string]*fakeDB{}
+)
+
+// newFakeDB returns a database for the test, with the DSN of its name.
+func newFakeDB(t *testing.T, failures ...error) *fakeDB {
+	fakeDBsMu.Lock()
+	defer fakeDBsMu.Unlock()
+	db := &fakeDB{failures: failures}
+	fakeDBs[t.Name()] = db
+	return db
+}
+
+type fakeDriver struct{}
+
+func (fakeDriver) Open(dsn string) (driver.Conn, error) {
+	fakeDBsMu.Lock()
+	defer fakeDBsMu.Unlock()
+	db, ok := fakeDBs[dsn]
+	if !ok {
+		return nil, fmt.Errorf("no database %v", dsn)
+	}
+	return &fakeConn{db: db}, nil
+}
+
+// fakeConn is a connection of a fakeDB, which buffers the statements of its
+// transaction until it's committed.
+type fakeConn struct {
+	db      *fakeDB
+	pending []int
+}
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) {
+	c.db.mu.Lock()
+	defer c.db.mu.Unlock()
+	c.db.prepared = append(c.db.prepared, query)
+	return &fakeStmt{c: c, query: query}, nil
+}
+
+func (c *fakeConn) Close() error { return nil }
+
+func (c *fakeConn) Begin() (driver.Tx, error) {
+	c.pending = nil
+	return c, nil
+}
+
+func (c *fakeConn) Commit() error {
+	c.db.mu.Lock()
+	defer c.db.mu.Unlock()
+	c.db.committed = append(c.db.committed, c.pending)
+	c.pending = nil
+	return nil
+}
+
+func (c *fakeConn) Rollback() error {
+	c.pending = nil
+	return nil
+}
+
+type fakeStmt struct {
+	c     *fakeConn
+	query string
+}
+
+func (s *fakeStmt) Close() error  { return nil }
+func (s *fakeStmt) NumInput() int { return -1 }
+
+func (s *fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	db := s.c.db
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.execs++
+	if len(db.failures) > 0 {
+		err := db.failures[0]
+		db.failures = db.failures[1:]
+		return nil, err
+	}
+	rows := len(args) / 2
+	s.c.pending = append(s.c.pending, rows)
+	return driver.RowsAffected(rows), nil
+}
+
+// Query returns the columns of the table, without rows.
+func (s *fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return fakeRows{}, nil
+}
+
+type fakeRows struct{}
+
+func (fakeRows) Columns() []string              { return []string{"street", "street_number"} }
+func (fakeRows) Close() error                   { return nil }
+func (fakeRows) Next(dest []driver.Value) error { return io.EOF }
+
+// write writes the addresses with the writer in one bundle, and returns the
+// error of finishing it.
+func write(t *testing.T, fn *writeFn, n int) error {
+	t.Helper()
+	ctx := context.Background()
+	if err := fn.Setup(ctx); err != nil {
+		t.Fatalf("Setup() failed: %v", err)
+	}
+	defer fn.Teardown()
+	for i := 0; i < n; i++ {
+		if err := fn.ProcessElement(ctx, Address{Street: "a", Street_number: i}); err != nil {
+			return err
+		}
+	}
+	return fn.FinishBundle(ctx)
+}
+
+// newWriteFn returns a writer of addresses to the database of the test.
+func newWriteFn(t *testing.T, opts ...WriteOptionFn) *writeFn {
+	o := writeOption{BatchSize: writeRowLimit, TransactionSize: defaultTransactionSize, MaxRetries: defaultMaxRetries, InitialBackoff: time.Millisecond, MaxBackoff: time.Millisecond}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return &writeFn{Driver: "fakedb", Dsn: t.Name(), Table: "address", Options: o, Type: beam.EncodedType{T: reflect.TypeOf(Address{})}}
+}
+
+func TestWrite(t *testing.T) {
+	db, err := sql.Open("ramsql", "user:password@/dbname6")
+	if err != nil {
+		t.Fatalf("Test infra failure: Failed to open database with error %v", err)
+	}
+	defer db.Close()
+	if _, err = db.Exec("CREATE TABLE address (street TEXT, street_number INT);"); err != nil {
+		t.Fatalf("Test infra failure: Failed to create table with error %v", err)
+	}
+
+	p, s := beam.NewPipelineWithRoot()
+	addresses := beam.Create(s, Address{Street: "orchard lane", Street_number: 1}, Address{Street: "morris st", Street_number: 200}, Address{Street: "elm st", Street_number: 3})
+	Write(s, "ramsql", "user:password@/dbname6", "address", []string{"street", "street_number"}, addresses, WriteBatchSize(2))
+	ptest.RunAndValidate(t, p)
+
+	var count int
+	if err := db.QueryRow("SELECT COUNT(*) FROM address").Scan(&count); err != nil {
+		t.Fatalf("Failed to count rows with error %v", err)
+	}
+	if count != 3 {
+		t.Errorf("Write() wrote %v rows, want 3", count)
+	}
+}
+
+func TestWriteFn_Batches(t *testing.T) {
+	db := newFakeDB(t)
+	if err := write(t, newWriteFn(t, WriteBatchSize(2), WriteTransactionSize(4)), 5); err != nil {
+		t.Fatalf("Write() failed: %v", err)
+	}
+	// The rows are written in a transaction of two batches, and the rest at
+	// the end of the bundle, with a statement prepared per batch size of each
+	// transaction.
+	if want := [][]int{{2, 2}, {1}}; !reflect.DeepEqual(db.committed, want) {
+		t.Errorf("Write() committed %v, want %v", db.committed, want)
+	}
+	want := []string{
+		"SELECT * FROM  address WHERE 1 = 0",
+		"INSERT INTO address(street,street_number) VALUES(?,?),(?,?)",
+		"INSERT INTO address(street,street_number) VALUES(?,?)",
+	}
+	if !reflect.DeepEqual(db.prepared, want) {
+		t.Errorf("Write() prepared %v, want %v", db.prepared, want)
+	}
+}
+
+func TestWriteFn_Retry(t *testing.T) {
+	db := newFakeDB(t, sqlStateError("40001"), driver.ErrBadConn)
+	if err := write(t, newWriteFn(t, WriteBatchSize(2)), 3); err != nil {
+		t.Fatalf("Write() failed: %v", err)
+	}
+	// The transaction is retried until it's committed.
+	if want := [][]int{{2, 1}}; !reflect.DeepEqual(db.committed, want) {
+		t.Errorf("Write() committed %v, want %v", db.committed, want)
+	}
+}
+
+func TestWriteFn_Errors(t *testing.T) {
+	tests := []struct {
+		name     string
+		failures []error
+		opts     []WriteOptionFn
+		execs    int
+	}{
+		{"permanent error", []error{errors.New("syntax error")}, nil, 1},
+		{"constraint violation", []error{sqlStateError("23505")}, nil, 1},
+		{"retries exhausted", []error{sqlStateError("40P01"), sqlStateError("40P01")}, []WriteOptionFn{WriteMaxRetries(1)}, 2},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			db := newFakeDB(t, test.failures...)
+			if err := write(t, newWriteFn(t, test.opts...), 1); err == nil {
+				t.Fatalf("Write() with %v succeeded, want error", test.name)
+			}
+			if db.execs != test.execs || len(db.committed) != 0 {
+				t.Errorf("Write() with %v executed %v statements and committed %v, want %v statements and none committed", test.name, db.execs, db.committed, test.execs)
+			}
+		})
+	}
+}
+
+func TestWriter_Statement(t *testing.T) {
+	columns := []string{"id", "name", "city"}
+	tests := []struct {
+		driver   string
+		conflict []string
+		want     string
+	}{
+		{"mysql", nil, "INSERT INTO users(id,name,city) VALUES(?,?,?),(?,?,?)"},
+		{"postgres", nil, "INSERT INTO users(id,name,city) VALUES($1,$2,$3),($4,$5,$6)"},
+		{"pgx", []string{"id"}, "INSERT INTO users(id,name,city) VALUES($1,$2,$3),($4,$5,$6) ON CONFLICT (id) DO UPDATE SET name = EXCLUDED.name,city = EXCLUDED.city"},
+		{"postgres", []string{"id", "name", "city"}, "INSERT INTO users(id,name,city) VALUES($1,$2,$3),($4,$5,$6) ON CONFLICT (id,name,city) DO NOTHING"},
+		{"mysql", []string{"id", "name"}, "INSERT INTO users(id,name,city) VALUES(?,?,?),(?,?,?) ON DUPLICATE KEY UPDATE city = VALUES(city)"},
+		{"mysql", []string{"id", "name", "city"}, "INSERT INTO users(id,name,city) VALUES(?,?,?),(?,?,?) ON DUPLICATE KEY UPDATE id = id"},
+	}
+	for _, test := range tests {
+		w, err := newWriter(test.driver, writeOption{ConflictColumns: test.conflict}, "users", columns)
+		if err != nil {
+			t.Fatalf("newWriter(%v, %v) failed: %v", test.driver, test.conflict, err)
+		}
+		if got := w.statement(2); got != test.want {
+			t.Errorf("statement(2) of %v with conflict columns %v = %q, want %q", test.driver, test.conflict, got, test.want)
+		}
+	}
+}
+
+func TestWriter_UpsertErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		driver   string
+		conflict []string
+	}{
+		{"unsupported driver", "ramsql", []string{"id"}},
+		{"unwritten conflict column", "postgres", []string{"email"}},
+	}
+	for _, test := range tests {
+		if _, err := newWriter(test.driver, writeOption{ConflictColumns: test.conflict}, "users", []string{"id", "name"}); err == nil {
+			t.Errorf("newWriter() with %v succeeded, want error", test.name)
+		}
+	}
+}
+
+func TestWrite_Options(t *testing.T) {
+	tests := []struct {
+		name string
+		fn   func()
+	}{
+		{"zero batch size", func() { WriteBatchSize(0) }},
+		{"zero transaction size", func() { WriteTransactionSize(0) }},
+		{"negative retries", func() { WriteMaxRetries(-1) }},
+		{"zero backoff", func() { WriteBackoff(0, time.Second) }},
+		{"max backoff below initial", func() { WriteBackoff(time.Second, time.Millisecond) }},
+		{"no conflict columns", func() { WriteUpsert() }},
+		{"upsert with unsupported driver", func() {
+			s := beam.NewPipeline().Root()
+			Write(s, "ramsql", "dsn", "address", nil, beam.Create(s, Address{}), WriteUpsert("street"))
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%v succeeded, want panic", test.name)
+				}
+			}()
+			test.fn()
+		})
+	}
+}
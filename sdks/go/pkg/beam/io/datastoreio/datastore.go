@@ -119,15 +119,25 @@ func (s *splitQueryFn) ProcessElement(ctx context.Context, _ []byte, emit func(k
 	}
 	defer client.Close()
 
+	splitKeys, err := sampleSplits(ctx, client, datastore.NewQuery(s.Kind), s.Shards)
+	if err != nil {
+		return err
+	}
+	return emitSplits(ctx, splitKeys, emit)
+}
+
+// sampleSplits returns the keys splitting the entities of the query into
+// about the given number of shards, sampled by the scatter property.
+func sampleSplits(ctx context.Context, client clientType, dq *datastore.Query, shards int) ([]*datastore.Key, error) {
 	splits := []*datastore.Key{}
-	iter := client.Run(ctx, datastore.NewQuery(s.Kind).Order(scatterPropertyName).Limit((s.Shards-1)*32).KeysOnly())
+	iter := client.Run(ctx, dq.Order(scatterPropertyName).Limit((shards-1)*32).KeysOnly())
 	for {
 		k, err := iter.Next(nil)
 		if err != nil {
 			if err == iterator.Done {
 				break
 			}
-			return err
+			return nil, err
 		}
 		splits = append(splits, k)
 	}
@@ -135,8 +145,11 @@ func (s *splitQueryFn) ProcessElement(ctx context.Context, _ []byte, emit func(k
 		return keyLessThan(splits[i], splits[j])
 	})
 
-	splitKeys := getSplits(splits, s.Shards-1)
+	return getSplits(splits, shards-1), nil
+}
 
+// emitSplits emits a bounded query per key range between the split keys.
+func emitSplits(ctx context.Context, splitKeys []*datastore.Key, emit func(k string, val string)) error {
 	queries := make([]*BoundedQuery, len(splitKeys))
 	var lastKey *datastore.Key
 	for n, k := range splitKeys {
@@ -244,8 +257,13 @@ func (f *queryFn) ProcessElement(ctx context.Context, _ string, v func(*string)
 		return errors.Errorf("No type registered %s", f.Type)
 	}
 
+	return runBoundedQuery(ctx, client, datastore.NewQuery(f.Kind), q, t, emit)
+}
+
+// runBoundedQuery runs the query within the key range of the bounded query,
+// and emits the entities as values of type t.
+func runBoundedQuery(ctx context.Context, client clientType, dq *datastore.Query, q BoundedQuery, t reflect.Type, emit func(beam.X)) error {
 	// Translate BoundedQuery to datastore.Query
-	dq := datastore.NewQuery(f.Kind)
 	if q.Start != nil {
 		dq = dq.Filter("__key__ >=", q.Start)
 	}
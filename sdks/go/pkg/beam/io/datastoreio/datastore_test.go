@@ -234,3 +234,56 @@ func Test_flatten(t *testing.T) {
 		t.Errorf("Expected A.a in second position")
 	}
 }
+
+// queryClient is a fake client that records the queries it runs.
+type queryClient struct {
+	queries []*datastore.Query
+}
+
+func (client *queryClient) Run(_ context.Context, q *datastore.Query) *datastore.Iterator {
+	client.queries = append(client.queries, q)
+	return new(datastore.Iterator)
+}
+
+func (client *queryClient) Close() error {
+	return nil
+}
+
+func openTasks(q *datastore.Query) *datastore.Query {
+	return q.Namespace("prod").Filter("Done =", false)
+}
+
+func init() {
+	runtime.RegisterFunction(openTasks)
+}
+
+func Test_readQuery(t *testing.T) {
+	client := queryClient{}
+	newClient := func(ctx context.Context, projectID string, opts ...option.ClientOption) (clientType, error) {
+		return &client, nil
+	}
+	itemKey := runtime.RegisterType(reflect.TypeOf(Foo{}))
+
+	p, s := beam.NewPipelineWithRoot()
+	readQuery(s, "project", "Task", 4, openTasks, reflect.TypeOf(Foo{}), itemKey, newClient)
+
+	ptest.RunAndValidate(t, p)
+
+	// The refined query is sampled, and then run as a single shard, since
+	// the sample is empty.
+	base := datastore.NewQuery("Task").Namespace("prod").Filter("Done =", false)
+	want := []*datastore.Query{base.Order(scatterPropertyName).Limit(3 * 32).KeysOnly(), base}
+	if !reflect.DeepEqual(client.queries, want) {
+		t.Errorf("readQuery() ran queries %+v, want %+v", client.queries, want)
+	}
+}
+
+func Test_readQuery_BadFn(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("readQuery() with an invalid query function succeeded, want panic")
+		}
+	}()
+	_, s := beam.NewPipelineWithRoot()
+	readQuery(s, "project", "Task", 1, func(string) string { return "" }, reflect.TypeOf(Foo{}), "Foo", nil)
+}
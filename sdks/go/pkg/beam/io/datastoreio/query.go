@@ -0,0 +1,159 @@
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements.  See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License.  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package datastoreio
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+
+	"cloud.google.com/go/datastore"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/core/funcx"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/core/runtime"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/core/util/reflectx"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/internal/errors"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/log"
+)
+
+var queryType = reflect.TypeOf((*datastore.Query)(nil))
+
+var refineSig = &funcx.Signature{Args: []reflect.Type{queryType}, Return: []reflect.Type{queryType}} // *datastore.Query -> *datastore.Query
+
+func init() {
+	beam.RegisterType(reflect.TypeOf((*splitRefinedQueryFn)(nil)).Elem())
+	beam.RegisterType(reflect.TypeOf((*refinedQueryFn)(nil)).Elem())
+}
+
+// ReadQuery reads the entities of the given kind that match a query, and
+// returns a PCollection<t>. The query is built by the given function, which
+// must be of the form: *datastore.Query -> *datastore.Query, and refines the
+// query of all entities of the kind, such as with filters, an ancestor or a
+// namespace. As with Read, the type must be registered, and typeKey is the
+// key it was registered with. For example:
+//
+//    func openTasks(q *datastore.Query) *datastore.Query {
+//        return q.Namespace("prod").Filter("Done =", false)
+//    }
+//
+//    datastoreio.ReadQuery(s, "project", "Task", 16, openTasks, reflect.TypeOf(Task{}), taskKey)
+//
+// If shards is greater than 1, the query is split into about as many key
+// ranges, which are read in parallel. The key ranges are sampled with the
+// scatter property, as Datastore's query splitter does. Datastore can only
+// split queries without inequality filters, sort orders, limits or offsets;
+// other queries must be read with a single shard. If the sampling query
+// fails, such as for lack of an index, the query is read as a single shard.
+func ReadQuery(s beam.Scope, project, kind string, shards int, fn interface{}, t reflect.Type, typeKey string) beam.PCollection {
+	s = s.Scope("datastore.ReadQuery")
+	return readQuery(s, project, kind, shards, fn, t, typeKey, nil)
+}
+
+func readQuery(s beam.Scope, project, kind string, shards int, fn interface{}, t reflect.Type, typeKey string, newClient newClientFuncType) beam.PCollection {
+	funcx.MustSatisfy(fn, refineSig)
+	refine := beam.EncodedFunc{Fn: reflectx.MakeFunc(fn)}
+
+	imp := beam.Impulse(s)
+	ex := beam.ParDo(s, &splitRefinedQueryFn{Project: project, Kind: kind, Shards: shards, Refine: refine, newClientFunc: newClient}, imp)
+	g := beam.GroupByKey(s, ex)
+	return beam.ParDo(s, &refinedQueryFn{Project: project, Kind: kind, Type: typeKey, Refine: refine, newClientFunc: newClient}, g, beam.TypeDefinition{Var: beam.XType, T: t})
+}
+
+// splitRefinedQueryFn splits a refined query into bounded queries.
+type splitRefinedQueryFn struct {
+	Project string `json:"project"`
+	Kind    string `json:"kind"`
+	Shards  int    `json:"shards"`
+	// Refine is the encoded function refining the query.
+	Refine beam.EncodedFunc `json:"refine"`
+
+	newClientFunc newClientFuncType
+	refine        reflectx.Func1x1
+}
+
+func (s *splitRefinedQueryFn) Setup() {
+	if s.newClientFunc == nil {
+		s.newClientFunc = datastoreNewClient
+	}
+	s.refine = reflectx.ToFunc1x1(s.Refine.Fn)
+}
+
+func (s *splitRefinedQueryFn) ProcessElement(ctx context.Context, _ []byte, emit func(k string, val string)) error {
+	if s.Shards <= 1 {
+		return emitSplits(ctx, nil, emit)
+	}
+
+	client, err := s.newClientFunc(ctx, s.Project)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	dq := s.refine.Call1x1(datastore.NewQuery(s.Kind)).(*datastore.Query)
+	splitKeys, err := sampleSplits(ctx, client, dq, s.Shards)
+	if err != nil {
+		log.Warnf(ctx, "Datastore: Reading query of %v as a single shard, as it can't be split: %v", s.Kind, err)
+		splitKeys = nil
+	}
+	return emitSplits(ctx, splitKeys, emit)
+}
+
+// refinedQueryFn runs the bounded queries of a refined query.
+type refinedQueryFn struct {
+	Project string `json:"project"`
+	Kind    string `json:"kind"`
+	// Type is the name of the global schema type
+	Type string `json:"type"`
+	// Refine is the encoded function refining the query.
+	Refine beam.EncodedFunc `json:"refine"`
+
+	newClientFunc newClientFuncType
+	refine        reflectx.Func1x1
+}
+
+func (f *refinedQueryFn) Setup() {
+	if f.newClientFunc == nil {
+		f.newClientFunc = datastoreNewClient
+	}
+	f.refine = reflectx.ToFunc1x1(f.Refine.Fn)
+}
+
+func (f *refinedQueryFn) ProcessElement(ctx context.Context, shard string, v func(*string) bool, emit func(beam.X)) error {
+	t, ok := runtime.LookupType(f.Type)
+	if !ok {
+		return errors.Errorf("No type registered %s", f.Type)
+	}
+
+	var b string
+	v(&b)
+	var q BoundedQuery
+	if err := json.Unmarshal([]byte(b), &q); err != nil {
+		return errors.Wrapf(err, "invalid bounded query of shard %v", shard)
+	}
+
+	client, err := f.newClientFunc(ctx, f.Project)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	dq := f.refine.Call1x1(datastore.NewQuery(f.Kind)).(*datastore.Query)
+	if err := runBoundedQuery(ctx, client, dq, q, t, emit); err != nil {
+		return errors.WithContextf(err, "running query of %v, shard %v", f.Kind, shard)
+	}
+	return nil
+}
+
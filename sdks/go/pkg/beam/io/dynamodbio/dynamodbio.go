@@ -0,0 +1,205 @@
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements.  See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License.  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package dynamodbio contains transforms to read from and write to Amazon
+// DynamoDB (https://aws.amazon.com/dynamodb) tables.
+//
+// Items are decoded into and encoded from Go types with the attributevalue
+// package of the AWS SDK for Go v2, so types can be annotated with
+// dynamodbav struct tags. Credentials and the region are loaded from the
+// default sources of the SDK, such as the environment, the shared
+// configuration files and the instance role, unless the region is set with
+// an option. Experimental.
+package dynamodbio
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/internal/errors"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// client is the part of a *dynamodb.Client used by the transforms, so that
+// it can be faked in tests.
+type client interface {
+	Scan(ctx context.Context, in *dynamodb.ScanInput, opts ...func(*dynamodb.Options)) (*dynamodb.ScanOutput, error)
+	Query(ctx context.Context, in *dynamodb.QueryInput, opts ...func(*dynamodb.Options)) (*dynamodb.QueryOutput, error)
+	BatchWriteItem(ctx context.Context, in *dynamodb.BatchWriteItemInput, opts ...func(*dynamodb.Options)) (*dynamodb.BatchWriteItemOutput, error)
+}
+
+// newClientFunc returns a client of the region, or of the default region if
+// it's empty, which sends requests to the endpoint, if it's set.
+type newClientFunc func(ctx context.Context, region, endpoint string) (client, error)
+
+// newClient returns a DynamoDB client, with the default credentials.
+func newClient(ctx context.Context, region, endpoint string) (client, error) {
+	var opts []func(*config.LoadOptions) error
+	if region != "" {
+		opts = append(opts, config.WithRegion(region))
+	}
+	cfg, err := config.LoadDefaultConfig(ctx, opts...)
+	if err != nil {
+		return nil, errors.Wrap(err, "loading AWS configuration")
+	}
+	return dynamodb.NewFromConfig(cfg, func(o *dynamodb.Options) {
+		if endpoint != "" {
+			o.EndpointResolver = dynamodb.EndpointResolverFromURL(endpoint)
+		}
+	}), nil
+}
+
+// jsonValue is an attribute value in the DynamoDB JSON format, such as
+// {"S": "text"}, so that items can be serialized with the DoFns that use
+// them, keeping the types of their values.
+type jsonValue struct {
+	S    *string               `json:"S,omitempty"`
+	N    *string               `json:"N,omitempty"`
+	B    *[]byte               `json:"B,omitempty"`
+	BOOL *bool                 `json:"BOOL,omitempty"`
+	NULL *bool                 `json:"NULL,omitempty"`
+	SS   []string              `json:"SS,omitempty"`
+	NS   []string              `json:"NS,omitempty"`
+	BS   [][]byte              `json:"BS,omitempty"`
+	L    *[]jsonValue          `json:"L,omitempty"`
+	M    *map[string]jsonValue `json:"M,omitempty"`
+}
+
+// marshalItem returns the item, or the attribute values of an expression,
+// in the DynamoDB JSON format.
+func marshalItem(item map[string]types.AttributeValue) ([]byte, error) {
+	m, err := toJSONMap(item)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(m)
+}
+
+// unmarshalItem returns the item of a marshalItem encoding, or nil if there
+// is none.
+func unmarshalItem(data []byte) (map[string]types.AttributeValue, error) {
+	if len(data) == 0 {
+		return nil, nil
+	}
+	var m map[string]jsonValue
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, errors.Wrap(err, "decoding item")
+	}
+	return fromJSONMap(m)
+}
+
+func toJSONMap(item map[string]types.AttributeValue) (map[string]jsonValue, error) {
+	m := make(map[string]jsonValue, len(item))
+	for k, v := range item {
+		jv, err := toJSON(v)
+		if err != nil {
+			return nil, errors.Wrapf(err, "encoding attribute %v", k)
+		}
+		m[k] = jv
+	}
+	return m, nil
+}
+
+func toJSON(v types.AttributeValue) (jsonValue, error) {
+	switch v := v.(type) {
+	case *types.AttributeValueMemberS:
+		return jsonValue{S: &v.Value}, nil
+	case *types.AttributeValueMemberN:
+		return jsonValue{N: &v.Value}, nil
+	case *types.AttributeValueMemberB:
+		return jsonValue{B: &v.Value}, nil
+	case *types.AttributeValueMemberBOOL:
+		return jsonValue{BOOL: &v.Value}, nil
+	case *types.AttributeValueMemberNULL:
+		return jsonValue{NULL: &v.Value}, nil
+	case *types.AttributeValueMemberSS:
+		return jsonValue{SS: v.Value}, nil
+	case *types.AttributeValueMemberNS:
+		return jsonValue{NS: v.Value}, nil
+	case *types.AttributeValueMemberBS:
+		return jsonValue{BS: v.Value}, nil
+	case *types.AttributeValueMemberL:
+		l := make([]jsonValue, len(v.Value))
+		for i, e := range v.Value {
+			jv, err := toJSON(e)
+			if err != nil {
+				return jsonValue{}, err
+			}
+			l[i] = jv
+		}
+		return jsonValue{L: &l}, nil
+	case *types.AttributeValueMemberM:
+		m, err := toJSONMap(v.Value)
+		if err != nil {
+			return jsonValue{}, err
+		}
+		return jsonValue{M: &m}, nil
+	default:
+		return jsonValue{}, errors.Errorf("unsupported attribute value %T", v)
+	}
+}
+
+func fromJSONMap(m map[string]jsonValue) (map[string]types.AttributeValue, error) {
+	item := make(map[string]types.AttributeValue, len(m))
+	for k, jv := range m {
+		v, err := fromJSON(jv)
+		if err != nil {
+			return nil, errors.Wrapf(err, "decoding attribute %v", k)
+		}
+		item[k] = v
+	}
+	return item, nil
+}
+
+func fromJSON(jv jsonValue) (types.AttributeValue, error) {
+	switch {
+	case jv.S != nil:
+		return &types.AttributeValueMemberS{Value: *jv.S}, nil
+	case jv.N != nil:
+		return &types.AttributeValueMemberN{Value: *jv.N}, nil
+	case jv.B != nil:
+		return &types.AttributeValueMemberB{Value: *jv.B}, nil
+	case jv.BOOL != nil:
+		return &types.AttributeValueMemberBOOL{Value: *jv.BOOL}, nil
+	case jv.NULL != nil:
+		return &types.AttributeValueMemberNULL{Value: *jv.NULL}, nil
+	case jv.SS != nil:
+		return &types.AttributeValueMemberSS{Value: jv.SS}, nil
+	case jv.NS != nil:
+		return &types.AttributeValueMemberNS{Value: jv.NS}, nil
+	case jv.BS != nil:
+		return &types.AttributeValueMemberBS{Value: jv.BS}, nil
+	case jv.L != nil:
+		l := make([]types.AttributeValue, len(*jv.L))
+		for i, e := range *jv.L {
+			v, err := fromJSON(e)
+			if err != nil {
+				return nil, err
+			}
+			l[i] = v
+		}
+		return &types.AttributeValueMemberL{Value: l}, nil
+	case jv.M != nil:
+		m, err := fromJSONMap(*jv.M)
+		if err != nil {
+			return nil, err
+		}
+		return &types.AttributeValueMemberM{Value: m}, nil
+	default:
+		return nil, errors.New("attribute value has no type")
+	}
+}
@@ -0,0 +1,175 @@
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements.  See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License.  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dynamodbio
+
+import (
+	"context"
+	"reflect"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/testing/ptest"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+func TestMain(m *testing.M) {
+	ptest.Main(m)
+}
+
+type order struct {
+	Customer string  `dynamodbav:"customer"`
+	ID       int     `dynamodbav:"id"`
+	Total    float64 `dynamodbav:"total"`
+}
+
+// fakeClient is a client of a table of items, which returns pages of two
+// items.
+type fakeClient struct {
+	items []map[string]types.AttributeValue
+	// unprocessed is the number of BatchWriteItem requests that leave their
+	// last item unprocessed.
+	unprocessed int
+	// reject returns the error of writes of the item, if it's set.
+	reject func(item map[string]types.AttributeValue) error
+
+	mu      sync.Mutex
+	scans   []*dynamodb.ScanInput
+	queries []*dynamodb.QueryInput
+	writes  [][]types.WriteRequest
+}
+
+// newFakeClient returns a client of a table of the items.
+func newFakeClient(t *testing.T, items ...interface{}) *fakeClient {
+	c := &fakeClient{}
+	for _, item := range items {
+		av, err := attributevalue.MarshalMap(item)
+		if err != nil {
+			t.Fatalf("MarshalMap() failed: %v", err)
+		}
+		c.items = append(c.items, av)
+	}
+	return c
+}
+
+func (c *fakeClient) newClient(context.Context, string, string) (client, error) {
+	return c, nil
+}
+
+// page returns the page of the items after the start key, and the key of
+// its last item if there are more.
+func page(items []map[string]types.AttributeValue, start map[string]types.AttributeValue) ([]map[string]types.AttributeValue, map[string]types.AttributeValue) {
+	i := 0
+	if start != nil {
+		i, _ = strconv.Atoi(start["page"].(*types.AttributeValueMemberN).Value)
+	}
+	if i+2 >= len(items) {
+		return items[i:], nil
+	}
+	return items[i : i+2], map[string]types.AttributeValue{"page": &types.AttributeValueMemberN{Value: strconv.Itoa(i + 2)}}
+}
+
+func (c *fakeClient) Scan(_ context.Context, in *dynamodb.ScanInput, _ ...func(*dynamodb.Options)) (*dynamodb.ScanOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	in2 := *in
+	c.scans = append(c.scans, &in2)
+	var segment []map[string]types.AttributeValue
+	for i, item := range c.items {
+		if int32(i)%*in.TotalSegments == *in.Segment {
+			segment = append(segment, item)
+		}
+	}
+	items, last := page(segment, in.ExclusiveStartKey)
+	return &dynamodb.ScanOutput{Items: items, LastEvaluatedKey: last}, nil
+}
+
+// Query returns the items of the customer of the ":c" value.
+func (c *fakeClient) Query(_ context.Context, in *dynamodb.QueryInput, _ ...func(*dynamodb.Options)) (*dynamodb.QueryOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	in2 := *in
+	c.queries = append(c.queries, &in2)
+	var matches []map[string]types.AttributeValue
+	for _, item := range c.items {
+		if reflect.DeepEqual(item["customer"], in.ExpressionAttributeValues[":c"]) {
+			matches = append(matches, item)
+		}
+	}
+	items, last := page(matches, in.ExclusiveStartKey)
+	return &dynamodb.QueryOutput{Items: items, LastEvaluatedKey: last}, nil
+}
+
+func (c *fakeClient) BatchWriteItem(_ context.Context, in *dynamodb.BatchWriteItemInput, _ ...func(*dynamodb.Options)) (*dynamodb.BatchWriteItemOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var table string
+	var reqs []types.WriteRequest
+	for t, r := range in.RequestItems {
+		table, reqs = t, r
+	}
+	for _, req := range reqs {
+		if c.reject != nil {
+			if err := c.reject(requestItem(req)); err != nil {
+				return nil, err
+			}
+		}
+	}
+	out := &dynamodb.BatchWriteItemOutput{}
+	if c.unprocessed > 0 {
+		c.unprocessed--
+		last := len(reqs) - 1
+		out.UnprocessedItems = map[string][]types.WriteRequest{table: reqs[last:]}
+		reqs = reqs[:last]
+	}
+	c.writes = append(c.writes, reqs)
+	return out, nil
+}
+
+func TestMarshalItem(t *testing.T) {
+	item := map[string]types.AttributeValue{
+		"s":    &types.AttributeValueMemberS{Value: "a"},
+		"n":    &types.AttributeValueMemberN{Value: "1.5"},
+		"b":    &types.AttributeValueMemberB{Value: []byte{1, 2}},
+		"bool": &types.AttributeValueMemberBOOL{Value: false},
+		"null": &types.AttributeValueMemberNULL{Value: true},
+		"ss":   &types.AttributeValueMemberSS{Value: []string{"x", "y"}},
+		"ns":   &types.AttributeValueMemberNS{Value: []string{"1"}},
+		"bs":   &types.AttributeValueMemberBS{Value: [][]byte{{3}}},
+		"l":    &types.AttributeValueMemberL{Value: []types.AttributeValue{&types.AttributeValueMemberS{Value: "e"}}},
+		"el":   &types.AttributeValueMemberL{Value: []types.AttributeValue{}},
+		"m":    &types.AttributeValueMemberM{Value: map[string]types.AttributeValue{"k": &types.AttributeValueMemberN{Value: "2"}}},
+	}
+	data, err := marshalItem(item)
+	if err != nil {
+		t.Fatalf("marshalItem() failed: %v", err)
+	}
+	got, err := unmarshalItem(data)
+	if err != nil {
+		t.Fatalf("unmarshalItem(%s) failed: %v", data, err)
+	}
+	if !reflect.DeepEqual(got, item) {
+		t.Errorf("unmarshalItem(%s) = %v, want %v", data, got, item)
+	}
+	if got, err := unmarshalItem(nil); err != nil || got != nil {
+		t.Errorf("unmarshalItem(nil) = %v, %v, want nil", got, err)
+	}
+	if _, err := unmarshalItem([]byte(`{"a": {}}`)); err == nil {
+		t.Error("unmarshalItem() of a value without a type succeeded, want error")
+	}
+}
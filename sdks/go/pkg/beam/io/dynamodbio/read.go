@@ -0,0 +1,356 @@
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements.  See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License.  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dynamodbio
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+
+	"github.com/apache/beam/sdks/v2/go/pkg/beam"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/core/sdf"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/internal/errors"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/io/rtrackers/offsetrange"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+func init() {
+	beam.RegisterType(reflect.TypeOf((*readFn)(nil)).Elem())
+}
+
+// query is a query of a read, with the attribute values of its key
+// condition in the DynamoDB JSON format.
+type query struct {
+	KeyCondition string
+	Values       []byte
+}
+
+// readOption holds the options of Read.
+type readOption struct {
+	Queries    []query
+	Segments   int
+	Index      string
+	Filter     string
+	Projection string
+	// Values are the attribute values of the filter, in the DynamoDB JSON
+	// format.
+	Values     []byte
+	Names      map[string]string
+	Consistent bool
+	Region     string
+	Endpoint   string
+}
+
+// ReadOptionFn is an option for Read.
+type ReadOptionFn func(*readOption)
+
+// mustMarshalValues returns the attribute values of an expression in the
+// DynamoDB JSON format, or panics with the name of the option if they can't
+// be encoded.
+func mustMarshalValues(option string, values map[string]interface{}) []byte {
+	item, err := attributevalue.MarshalMap(values)
+	if err == nil {
+		var data []byte
+		if data, err = marshalItem(item); err == nil {
+			return data
+		}
+	}
+	panic(fmt.Sprintf("dynamodbio.%v values must be attribute values. Got: %v: %v", option, values, err))
+}
+
+// ReadQuery sets the items read to the items matching the key condition
+// expression, such as "customer = :c", with the values of its placeholders,
+// instead of all the items of the table. It can be set more than once, such
+// as to read partitions, and the queries are read in parallel.
+func ReadQuery(keyCondition string, values map[string]interface{}) ReadOptionFn {
+	if keyCondition == "" {
+		panic("dynamodbio.ReadQuery requires a key condition")
+	}
+	q := query{KeyCondition: keyCondition, Values: mustMarshalValues("ReadQuery", values)}
+	return func(o *readOption) {
+		o.Queries = append(o.Queries, q)
+	}
+}
+
+// ReadSegments sets the number of segments the items of scans are read in,
+// in parallel. The default is 1.
+func ReadSegments(n int) ReadOptionFn {
+	if n < 1 {
+		panic(fmt.Sprintf("dynamodbio.ReadSegments number must be positive. Got: %v", n))
+	}
+	return func(o *readOption) {
+		o.Segments = n
+	}
+}
+
+// ReadIndex sets the items to be read from the given secondary index of the
+// table.
+func ReadIndex(name string) ReadOptionFn {
+	return func(o *readOption) {
+		o.Index = name
+	}
+}
+
+// ReadFilter sets the filter expression of the items read, such as
+// "total > :min", with the values of its placeholders. Items are filtered
+// by DynamoDB after they're read, so they still consume read capacity.
+func ReadFilter(expression string, values map[string]interface{}) ReadOptionFn {
+	data := mustMarshalValues("ReadFilter", values)
+	return func(o *readOption) {
+		o.Filter = expression
+		o.Values = data
+	}
+}
+
+// ReadProjection sets the projection expression of the attributes read,
+// such as "id, total".
+func ReadProjection(expression string) ReadOptionFn {
+	return func(o *readOption) {
+		o.Projection = expression
+	}
+}
+
+// ReadNames sets the attribute names of the placeholders of the
+// expressions, such as "#s" for "status", which is a reserved word.
+func ReadNames(names map[string]string) ReadOptionFn {
+	return func(o *readOption) {
+		o.Names = names
+	}
+}
+
+// ReadConsistent sets the items to be read with strongly consistent reads,
+// instead of eventually consistent reads.
+func ReadConsistent() ReadOptionFn {
+	return func(o *readOption) {
+		o.Consistent = true
+	}
+}
+
+// ReadRegion sets the AWS region of the table.
+func ReadRegion(region string) ReadOptionFn {
+	return func(o *readOption) {
+		o.Region = region
+	}
+}
+
+// ReadEndpoint sets the URL of the endpoint requests are sent to, such as
+// "http://localhost:8000" for DynamoDB Local.
+func ReadEndpoint(url string) ReadOptionFn {
+	return func(o *readOption) {
+		o.Endpoint = url
+	}
+}
+
+// Read reads the items of the given table, and returns a PCollection<T> of
+// the items decoded into the type t. For example:
+//
+//	type Order struct {
+//		Customer string  `dynamodbav:"customer"`
+//		ID       string  `dynamodbav:"id"`
+//		Total    float64 `dynamodbav:"total"`
+//	}
+//
+//	orders := dynamodbio.Read(s, "orders", reflect.TypeOf(Order{}),
+//		dynamodbio.ReadSegments(8))
+//
+// Items are read with a parallel scan of the table in the segments set with
+// ReadSegments, or with the queries set with ReadQuery. Each segment or
+// query is a position of the restriction of the read, so that they're read
+// in parallel.
+func Read(s beam.Scope, table string, t reflect.Type, opts ...ReadOptionFn) beam.PCollection {
+	s = s.Scope("dynamodbio.Read")
+	if table == "" {
+		panic("dynamodbio.Read requires a table")
+	}
+
+	o := readOption{
+		Segments: 1,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	imp := beam.Impulse(s)
+	return beam.ParDo(s, &readFn{Table: table, Type: beam.EncodedType{T: t}, Options: o}, imp, beam.TypeDefinition{Var: beam.XType, T: t})
+}
+
+// readFn reads the items of segments of a scan or queries. Its restriction
+// is a range of the indices of the segments, or of the queries.
+type readFn struct {
+	// Table is the name of the table.
+	Table string `json:"table"`
+	// Type is the type of the items.
+	Type beam.EncodedType `json:"type"`
+	// Options specifies the queries, segments and expressions.
+	Options readOption `json:"options"`
+
+	newClient newClientFunc
+	client    client
+}
+
+func (f *readFn) Setup(ctx context.Context) error {
+	if f.newClient == nil {
+		f.newClient = newClient
+	}
+	c, err := f.newClient(ctx, f.Options.Region, f.Options.Endpoint)
+	if err != nil {
+		return err
+	}
+	f.client = c
+	return nil
+}
+
+func (f *readFn) CreateInitialRestriction(_ []byte) offsetrange.Restriction {
+	if len(f.Options.Queries) > 0 {
+		return offsetrange.Restriction{Start: 0, End: int64(len(f.Options.Queries))}
+	}
+	return offsetrange.Restriction{Start: 0, End: int64(f.Options.Segments)}
+}
+
+// SplitRestriction splits the restriction into a restriction per segment or
+// query.
+func (f *readFn) SplitRestriction(_ []byte, rest offsetrange.Restriction) []offsetrange.Restriction {
+	return rest.SizedSplits(1)
+}
+
+func (f *readFn) RestrictionSize(_ []byte, rest offsetrange.Restriction) float64 {
+	return rest.Size()
+}
+
+func (f *readFn) CreateTracker(rest offsetrange.Restriction) *sdf.LockRTracker {
+	return sdf.NewLockRTracker(offsetrange.NewTracker(rest))
+}
+
+func (f *readFn) ProcessElement(ctx context.Context, rt *sdf.LockRTracker, _ []byte, emit func(beam.X)) error {
+	rest := rt.GetRestriction().(offsetrange.Restriction)
+	for i := rest.Start; rt.TryClaim(i); i++ {
+		var err error
+		if len(f.Options.Queries) > 0 {
+			err = f.query(ctx, f.Options.Queries[i], emit)
+		} else {
+			err = f.scan(ctx, int32(i), emit)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// scan reads the items of the segment of the scan.
+func (f *readFn) scan(ctx context.Context, segment int32, emit func(beam.X)) error {
+	values, err := f.values(nil)
+	if err != nil {
+		return err
+	}
+	in := &dynamodb.ScanInput{
+		TableName:                 aws.String(f.Table),
+		IndexName:                 optional(f.Options.Index),
+		FilterExpression:          optional(f.Options.Filter),
+		ProjectionExpression:      optional(f.Options.Projection),
+		ExpressionAttributeNames:  f.Options.Names,
+		ExpressionAttributeValues: values,
+		ConsistentRead:            aws.Bool(f.Options.Consistent),
+		Segment:                   aws.Int32(segment),
+		TotalSegments:             aws.Int32(int32(f.Options.Segments)),
+	}
+	for {
+		out, err := f.client.Scan(ctx, in)
+		if err != nil {
+			return errors.Wrapf(err, "scanning segment %v of %v", segment, f.Table)
+		}
+		if err := f.emit(out.Items, emit); err != nil {
+			return err
+		}
+		if len(out.LastEvaluatedKey) == 0 {
+			return nil
+		}
+		in.ExclusiveStartKey = out.LastEvaluatedKey
+	}
+}
+
+// query reads the items of the query.
+func (f *readFn) query(ctx context.Context, q query, emit func(beam.X)) error {
+	values, err := f.values(q.Values)
+	if err != nil {
+		return err
+	}
+	in := &dynamodb.QueryInput{
+		TableName:                 aws.String(f.Table),
+		IndexName:                 optional(f.Options.Index),
+		KeyConditionExpression:    aws.String(q.KeyCondition),
+		FilterExpression:          optional(f.Options.Filter),
+		ProjectionExpression:      optional(f.Options.Projection),
+		ExpressionAttributeNames:  f.Options.Names,
+		ExpressionAttributeValues: values,
+		ConsistentRead:            aws.Bool(f.Options.Consistent),
+	}
+	for {
+		out, err := f.client.Query(ctx, in)
+		if err != nil {
+			return errors.Wrapf(err, "querying %v where %v", f.Table, q.KeyCondition)
+		}
+		if err := f.emit(out.Items, emit); err != nil {
+			return err
+		}
+		if len(out.LastEvaluatedKey) == 0 {
+			return nil
+		}
+		in.ExclusiveStartKey = out.LastEvaluatedKey
+	}
+}
+
+// values returns the attribute values of the expressions, which are those
+// of the filter and of the key condition, if any.
+func (f *readFn) values(keyCondition []byte) (map[string]types.AttributeValue, error) {
+	values, err := unmarshalItem(f.Options.Values)
+	if err != nil {
+		return nil, err
+	}
+	kv, err := unmarshalItem(keyCondition)
+	if err != nil {
+		return nil, err
+	}
+	if values == nil {
+		return kv, nil
+	}
+	for k, v := range kv {
+		values[k] = v
+	}
+	return values, nil
+}
+
+// emit decodes the items, and outputs them.
+func (f *readFn) emit(items []map[string]types.AttributeValue, emit func(beam.X)) error {
+	for _, item := range items {
+		v := reflect.New(f.Type.T)
+		if err := attributevalue.UnmarshalMap(item, v.Interface()); err != nil {
+			return errors.Wrapf(err, "decoding item of %v into %v", f.Table, f.Type.T)
+		}
+		emit(v.Elem().Interface())
+	}
+	return nil
+}
+
+// optional returns a pointer to the string, or nil if it's empty.
+func optional(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
@@ -0,0 +1,160 @@
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements.  See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License.  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dynamodbio
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/apache/beam/sdks/v2/go/pkg/beam"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/io/rtrackers/offsetrange"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// orders returns n orders of customers "a" and "b".
+func orders(n int) []interface{} {
+	var orders []interface{}
+	for i := 0; i < n; i++ {
+		orders = append(orders, order{Customer: string(rune('a' + i%2)), ID: i, Total: float64(i)})
+	}
+	return orders
+}
+
+// read reads the restriction with a reader of the client, and returns the
+// IDs of the orders read.
+func read(t *testing.T, c *fakeClient, rest offsetrange.Restriction, opts ...ReadOptionFn) []int {
+	t.Helper()
+	o := readOption{Segments: 1}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	fn := &readFn{Table: "orders", Type: beam.EncodedType{T: reflect.TypeOf(order{})}, Options: o, newClient: c.newClient}
+	if err := fn.Setup(context.Background()); err != nil {
+		t.Fatalf("Setup() failed: %v", err)
+	}
+	var ids []int
+	err := fn.ProcessElement(context.Background(), fn.CreateTracker(rest), nil, func(x beam.X) {
+		ids = append(ids, x.(order).ID)
+	})
+	if err != nil {
+		t.Fatalf("ProcessElement() failed: %v", err)
+	}
+	sort.Ints(ids)
+	return ids
+}
+
+func TestReadFn_Restriction(t *testing.T) {
+	tests := []struct {
+		name string
+		opts []ReadOptionFn
+		want []offsetrange.Restriction
+	}{
+		{"scan", nil, []offsetrange.Restriction{{Start: 0, End: 1}}},
+		{"segments", []ReadOptionFn{ReadSegments(3)}, []offsetrange.Restriction{{Start: 0, End: 1}, {Start: 1, End: 2}, {Start: 2, End: 3}}},
+		{"queries", []ReadOptionFn{ReadSegments(3), ReadQuery("customer = :c", map[string]interface{}{":c": "a"}), ReadQuery("customer = :c", map[string]interface{}{":c": "b"})}, []offsetrange.Restriction{{Start: 0, End: 1}, {Start: 1, End: 2}}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			o := readOption{Segments: 1}
+			for _, opt := range test.opts {
+				opt(&o)
+			}
+			fn := &readFn{Options: o}
+			if got := fn.SplitRestriction(nil, fn.CreateInitialRestriction(nil)); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("SplitRestriction() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestReadFn_Scan(t *testing.T) {
+	c := newFakeClient(t, orders(7)...)
+	filter := ReadFilter("total >= :min", map[string]interface{}{":min": 0})
+	got := read(t, c, offsetrange.Restriction{Start: 1, End: 2}, ReadSegments(2), filter, ReadConsistent())
+	// The orders of the second segment are read in pages.
+	if want := []int{1, 3, 5}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ProcessElement() read %v, want %v", got, want)
+	}
+	if got, want := len(c.scans), 2; got != want {
+		t.Fatalf("ProcessElement() scanned %v pages, want %v", got, want)
+	}
+	in := c.scans[0]
+	if *in.Segment != 1 || *in.TotalSegments != 2 || *in.FilterExpression != "total >= :min" || !*in.ConsistentRead || in.IndexName != nil {
+		t.Errorf("ProcessElement() scanned with %+v, want segment 1 of 2, with the filter, consistently", in)
+	}
+	if got, want := in.ExpressionAttributeValues[":min"], (&types.AttributeValueMemberN{Value: "0"}); !reflect.DeepEqual(got, want) {
+		t.Errorf("ProcessElement() scanned with value %v, want %v", got, want)
+	}
+}
+
+func TestReadFn_Query(t *testing.T) {
+	c := newFakeClient(t, orders(7)...)
+	opts := []ReadOptionFn{
+		ReadQuery("customer = :c", map[string]interface{}{":c": "a"}),
+		ReadQuery("customer = :c", map[string]interface{}{":c": "b"}),
+		ReadIndex("by-customer"),
+		ReadFilter("#t >= :min", map[string]interface{}{":min": 1}),
+		ReadNames(map[string]string{"#t": "total"}),
+	}
+	// The restriction of the second query reads the orders of customer b.
+	if got, want := read(t, c, offsetrange.Restriction{Start: 1, End: 2}, opts...), []int{1, 3, 5}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ProcessElement() read %v, want %v", got, want)
+	}
+	in := c.queries[0]
+	if *in.KeyConditionExpression != "customer = :c" || *in.IndexName != "by-customer" || in.ExpressionAttributeNames["#t"] != "total" {
+		t.Errorf("ProcessElement() queried with %+v, want the key condition, index and names", in)
+	}
+	// The values are those of the key condition and of the filter.
+	if got := len(in.ExpressionAttributeValues); got != 2 {
+		t.Errorf("ProcessElement() queried with %v values, want 2", got)
+	}
+}
+
+func TestRead(t *testing.T) {
+	// The reader can't be given a fake client through the pipeline, so the
+	// pipeline is only built.
+	p, s := beam.NewPipelineWithRoot()
+	col := Read(s, "orders", reflect.TypeOf(order{}), ReadSegments(2))
+	if got, want := col.Type().Type(), reflect.TypeOf(order{}); got != want {
+		t.Errorf("Read() = PCollection<%v>, want PCollection<%v>", got, want)
+	}
+	if _, _, err := p.Build(); err != nil {
+		t.Errorf("Build() failed: %v", err)
+	}
+}
+
+func TestRead_Options(t *testing.T) {
+	tests := []struct {
+		name string
+		fn   func()
+	}{
+		{"no table", func() { Read(beam.NewPipeline().Root(), "", reflect.TypeOf(order{})) }},
+		{"no key condition", func() { ReadQuery("", nil) }},
+		{"zero segments", func() { ReadSegments(0) }},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%v succeeded, want panic", test.name)
+				}
+			}()
+			test.fn()
+		})
+	}
+}
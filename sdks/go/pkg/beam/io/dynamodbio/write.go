@@ -0,0 +1,409 @@
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements.  See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License.  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dynamodbio
+
+import (
+	"context"
+	stderrors "errors"
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/apache/beam/sdks/v2/go/pkg/beam"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/internal/errors"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/log"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/smithy-go"
+)
+
+const (
+	// maxBatchSize is the maximum number of requests of a BatchWriteItem
+	// request.
+	maxBatchSize          = 25
+	defaultMaxRetries     = 5
+	defaultInitialBackoff = 100 * time.Millisecond
+	defaultMaxBackoff     = 10 * time.Second
+)
+
+func init() {
+	beam.RegisterType(reflect.TypeOf((*FailedItem)(nil)).Elem())
+	beam.RegisterType(reflect.TypeOf((*writeFn)(nil)).Elem())
+	beam.RegisterType(reflect.TypeOf((*writeWithFailuresFn)(nil)).Elem())
+}
+
+// FailedItem is an item that couldn't be written, as output by
+// WriteWithFailures.
+type FailedItem struct {
+	// Item is the item, or its key for deletes, in the DynamoDB JSON
+	// format, such as {"id": {"S": "a"}}.
+	Item []byte
+	// Error describes why the item couldn't be written.
+	Error string
+}
+
+// writeOption holds the options of Write and WriteWithFailures.
+type writeOption struct {
+	BatchSize      int
+	MaxRetries     int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	// Keys are the key attributes of the items to delete, if deleting.
+	Keys     []string
+	Region   string
+	Endpoint string
+}
+
+// WriteOptionFn is an option for Write and WriteWithFailures.
+type WriteOptionFn func(*writeOption)
+
+func newWriteOption(opts []WriteOptionFn) writeOption {
+	o := writeOption{
+		BatchSize:      maxBatchSize,
+		MaxRetries:     defaultMaxRetries,
+		InitialBackoff: defaultInitialBackoff,
+		MaxBackoff:     defaultMaxBackoff,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// WriteBatchSize sets the number of items of each BatchWriteItem request,
+// which is at most 25. The default is 25.
+func WriteBatchSize(n int) WriteOptionFn {
+	if n < 1 || n > maxBatchSize {
+		panic(fmt.Sprintf("dynamodbio.WriteBatchSize size must be between 1 and %v. Got: %v", maxBatchSize, n))
+	}
+	return func(o *writeOption) {
+		o.BatchSize = n
+	}
+}
+
+// WriteMaxRetries sets the number of times items that DynamoDB leaves
+// unprocessed, such as when the table is throttled, are retried. The
+// default is 5.
+func WriteMaxRetries(n int) WriteOptionFn {
+	if n < 0 {
+		panic(fmt.Sprintf("dynamodbio.WriteMaxRetries max retries must be non-negative. Got: %v", n))
+	}
+	return func(o *writeOption) {
+		o.MaxRetries = n
+	}
+}
+
+// WriteBackoff sets the backoff between retries of unprocessed items, which
+// starts at initial and doubles after each retry, up to max. The default is
+// 100ms, up to 10s.
+func WriteBackoff(initial, max time.Duration) WriteOptionFn {
+	if initial <= 0 || max < initial {
+		panic(fmt.Sprintf("dynamodbio.WriteBackoff invalid backoff. Got: %v, %v", initial, max))
+	}
+	return func(o *writeOption) {
+		o.InitialBackoff = initial
+		o.MaxBackoff = max
+	}
+}
+
+// WriteDelete sets the items to be deleted, by the values of the key
+// attributes of the elements, instead of put.
+func WriteDelete(keys ...string) WriteOptionFn {
+	if len(keys) == 0 {
+		panic("dynamodbio.WriteDelete requires key attributes")
+	}
+	return func(o *writeOption) {
+		o.Keys = keys
+	}
+}
+
+// WriteRegion sets the AWS region of the table.
+func WriteRegion(region string) WriteOptionFn {
+	return func(o *writeOption) {
+		o.Region = region
+	}
+}
+
+// WriteEndpoint sets the URL of the endpoint requests are sent to, such as
+// "http://localhost:8000" for DynamoDB Local.
+func WriteEndpoint(url string) WriteOptionFn {
+	return func(o *writeOption) {
+		o.Endpoint = url
+	}
+}
+
+// Write puts the elements of the given PCollection<T> into the given table,
+// encoded as items, replacing the items with the same key. For example:
+//
+//	dynamodbio.Write(s, "orders", orders, dynamodbio.WriteRegion("eu-west-1"))
+//
+// Items are written with BatchWriteItem requests of the batch size. Items
+// that DynamoDB leaves unprocessed, such as when the table is throttled, are
+// retried with backoff, and fail the bundle if they're still unprocessed
+// once their retries are exhausted. Items that DynamoDB rejects, such as
+// items without their key attributes, fail the bundle. A batch mustn't hold
+// two items with the same key, so elements should be deduplicated by key
+// first.
+func Write(s beam.Scope, table string, col beam.PCollection, opts ...WriteOptionFn) {
+	s = s.Scope("dynamodbio.Write")
+	if table == "" {
+		panic("dynamodbio.Write requires a table")
+	}
+
+	o := newWriteOption(opts)
+	beam.ParDo0(s, &writeFn{Table: table, Options: o}, col)
+}
+
+// WriteWithFailures writes the elements of the given PCollection<T> like
+// Write, but returns the items that DynamoDB rejects as a
+// PCollection<FailedItem> instead of failing the bundle, such as to write
+// them to a dead-letter destination. Items of rejected batches are written
+// one at a time, to find the items that are rejected. Items that are still
+// unprocessed once their retries are exhausted fail the bundle.
+func WriteWithFailures(s beam.Scope, table string, col beam.PCollection, opts ...WriteOptionFn) beam.PCollection {
+	s = s.Scope("dynamodbio.WriteWithFailures")
+	if table == "" {
+		panic("dynamodbio.WriteWithFailures requires a table")
+	}
+
+	o := newWriteOption(opts)
+	return beam.ParDo(s, &writeWithFailuresFn{Table: table, Options: o}, col)
+}
+
+// writeFn writes items, and fails if any can't be written.
+type writeFn struct {
+	// Table is the name of the table.
+	Table string `json:"table"`
+	// Options specifies the batch size, retries and deletes.
+	Options writeOption `json:"options"`
+
+	newClient newClientFunc
+	w         *batchWriter
+}
+
+func (f *writeFn) Setup(ctx context.Context) error {
+	if f.newClient == nil {
+		f.newClient = newClient
+	}
+	c, err := f.newClient(ctx, f.Options.Region, f.Options.Endpoint)
+	if err != nil {
+		return err
+	}
+	f.w = newBatchWriter(c, f.Table, f.Options)
+	return nil
+}
+
+func (f *writeFn) ProcessElement(ctx context.Context, elem beam.X) error {
+	return failure(f.w.Add(ctx, elem))
+}
+
+func (f *writeFn) FinishBundle(ctx context.Context) error {
+	return failure(f.w.Flush(ctx))
+}
+
+// failure returns the error, or an error describing the failed items, if
+// any.
+func failure(failed []FailedItem, err error) error {
+	if err != nil || len(failed) == 0 {
+		return err
+	}
+	return errors.Errorf("dynamodb write error: %v items failed, such as: %v", len(failed), failed[0].Error)
+}
+
+// writeWithFailuresFn writes items, and outputs those that can't be written.
+type writeWithFailuresFn struct {
+	// Table is the name of the table.
+	Table string `json:"table"`
+	// Options specifies the batch size, retries and deletes.
+	Options writeOption `json:"options"`
+
+	newClient newClientFunc
+	w         *batchWriter
+}
+
+func (f *writeWithFailuresFn) Setup(ctx context.Context) error {
+	if f.newClient == nil {
+		f.newClient = newClient
+	}
+	c, err := f.newClient(ctx, f.Options.Region, f.Options.Endpoint)
+	if err != nil {
+		return err
+	}
+	f.w = newBatchWriter(c, f.Table, f.Options)
+	return nil
+}
+
+func (f *writeWithFailuresFn) ProcessElement(ctx context.Context, elem beam.X, emit func(FailedItem)) error {
+	failed, err := f.w.Add(ctx, elem)
+	for _, fi := range failed {
+		emit(fi)
+	}
+	return err
+}
+
+func (f *writeWithFailuresFn) FinishBundle(ctx context.Context, emit func(FailedItem)) error {
+	failed, err := f.w.Flush(ctx)
+	for _, fi := range failed {
+		emit(fi)
+	}
+	return err
+}
+
+// batchWriter batches items into BatchWriteItem requests.
+type batchWriter struct {
+	client client
+	table  string
+	opts   writeOption
+
+	batch []types.WriteRequest
+}
+
+func newBatchWriter(c client, table string, opts writeOption) *batchWriter {
+	return &batchWriter{client: c, table: table, opts: opts}
+}
+
+// Add adds the element to the batch, and writes the batch if it's full. It
+// returns the items that failed, if any.
+func (w *batchWriter) Add(ctx context.Context, elem interface{}) ([]FailedItem, error) {
+	req, err := w.request(elem)
+	if err != nil {
+		return nil, err
+	}
+	w.batch = append(w.batch, req)
+	if len(w.batch) >= w.opts.BatchSize {
+		return w.Flush(ctx)
+	}
+	return nil, nil
+}
+
+// request returns the put request of the element, or its delete request if
+// deleting.
+func (w *batchWriter) request(elem interface{}) (types.WriteRequest, error) {
+	item, err := attributevalue.MarshalMap(elem)
+	if err != nil {
+		return types.WriteRequest{}, errors.Wrapf(err, "encoding %T", elem)
+	}
+	if len(w.opts.Keys) == 0 {
+		return types.WriteRequest{PutRequest: &types.PutRequest{Item: item}}, nil
+	}
+	key := make(map[string]types.AttributeValue, len(w.opts.Keys))
+	for _, k := range w.opts.Keys {
+		v, ok := item[k]
+		if !ok {
+			return types.WriteRequest{}, errors.Errorf("%T has no key attribute %v", elem, k)
+		}
+		key[k] = v
+	}
+	return types.WriteRequest{DeleteRequest: &types.DeleteRequest{Key: key}}, nil
+}
+
+// Flush writes the batch. If DynamoDB rejects it, the items are written one
+// at a time, and those that are rejected are returned.
+func (w *batchWriter) Flush(ctx context.Context) ([]FailedItem, error) {
+	if len(w.batch) == 0 {
+		return nil, nil
+	}
+	batch := w.batch
+	w.batch = nil
+
+	err := w.write(ctx, batch)
+	if err == nil || isRetryable(err) || len(batch) == 1 {
+		return w.failed(batch, err)
+	}
+	log.Warnf(ctx, "Writing %v items to %v one at a time after failure: %v", len(batch), w.table, err)
+	var failed []FailedItem
+	for _, req := range batch {
+		fi, err := w.failed([]types.WriteRequest{req}, w.write(ctx, []types.WriteRequest{req}))
+		if err != nil {
+			return failed, err
+		}
+		failed = append(failed, fi...)
+	}
+	return failed, nil
+}
+
+// failed returns the items of the requests that DynamoDB rejected with the
+// error, or the error if it isn't a rejection.
+func (w *batchWriter) failed(batch []types.WriteRequest, err error) ([]FailedItem, error) {
+	if err == nil || isRetryable(err) {
+		return nil, err
+	}
+	var failed []FailedItem
+	for _, req := range batch {
+		data, merr := marshalItem(requestItem(req))
+		if merr != nil {
+			return nil, merr
+		}
+		failed = append(failed, FailedItem{Item: data, Error: err.Error()})
+	}
+	return failed, nil
+}
+
+// requestItem returns the item of a put request, or the key of a delete
+// request.
+func requestItem(req types.WriteRequest) map[string]types.AttributeValue {
+	if req.PutRequest != nil {
+		return req.PutRequest.Item
+	}
+	return req.DeleteRequest.Key
+}
+
+// write writes the requests with BatchWriteItem requests, retrying the
+// requests that DynamoDB leaves unprocessed with backoff.
+func (w *batchWriter) write(ctx context.Context, batch []types.WriteRequest) error {
+	items := map[string][]types.WriteRequest{w.table: batch}
+	backoff := w.opts.InitialBackoff
+	for attempt := 0; ; attempt++ {
+		out, err := w.client.BatchWriteItem(ctx, &dynamodb.BatchWriteItemInput{RequestItems: items})
+		if err != nil {
+			return errors.Wrapf(err, "writing %v items to %v", len(items[w.table]), w.table)
+		}
+		if len(out.UnprocessedItems[w.table]) == 0 {
+			return nil
+		}
+		items = out.UnprocessedItems
+		if attempt >= w.opts.MaxRetries {
+			return errors.Errorf("%v items of %v unprocessed after %v retries", len(items[w.table]), w.table, attempt)
+		}
+		log.Warnf(ctx, "Retrying %v unprocessed items of %v in %v", len(items[w.table]), w.table, backoff)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		if backoff *= 2; backoff > w.opts.MaxBackoff {
+			backoff = w.opts.MaxBackoff
+		}
+	}
+}
+
+// isRetryable returns whether the write that failed with the error can be
+// retried, because it wasn't rejected by DynamoDB, or was throttled.
+func isRetryable(err error) bool {
+	var ae smithy.APIError
+	if !stderrors.As(err, &ae) {
+		// The write failed before a response, such as for a lost
+		// connection, or was left unprocessed.
+		return true
+	}
+	switch ae.ErrorCode() {
+	case "ProvisionedThroughputExceededException", "RequestLimitExceeded", "ThrottlingException", "InternalServerError":
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,184 @@
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements.  See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License.  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dynamodbio
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/smithy-go"
+)
+
+// write writes the elements with a writer of the client in one bundle, and
+// returns the items that failed and the error of finishing it.
+func write(t *testing.T, c *fakeClient, opts []WriteOptionFn, elems ...interface{}) ([]FailedItem, error) {
+	t.Helper()
+	opts = append([]WriteOptionFn{WriteBackoff(time.Millisecond, time.Millisecond)}, opts...)
+	fn := &writeWithFailuresFn{Table: "orders", Options: newWriteOption(opts), newClient: c.newClient}
+	ctx := context.Background()
+	if err := fn.Setup(ctx); err != nil {
+		t.Fatalf("Setup() failed: %v", err)
+	}
+	var failed []FailedItem
+	emit := func(fi FailedItem) { failed = append(failed, fi) }
+	for _, e := range elems {
+		if err := fn.ProcessElement(ctx, e, emit); err != nil {
+			return failed, err
+		}
+	}
+	return failed, fn.FinishBundle(ctx, emit)
+}
+
+// sizes returns the number of requests of each write.
+func sizes(writes [][]types.WriteRequest) []int {
+	var sizes []int
+	for _, w := range writes {
+		sizes = append(sizes, len(w))
+	}
+	return sizes
+}
+
+func TestBatchWriter(t *testing.T) {
+	c := newFakeClient(t)
+	if _, err := write(t, c, []WriteOptionFn{WriteBatchSize(2)}, orders(3)...); err != nil {
+		t.Fatalf("Write() failed: %v", err)
+	}
+	if got, want := sizes(c.writes), []int{2, 1}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("Write() wrote batches of %v, want %v", got, want)
+	}
+	put := c.writes[1][0].PutRequest
+	if got, want := put.Item["id"], (&types.AttributeValueMemberN{Value: "2"}); !reflect.DeepEqual(got, want) {
+		t.Errorf("Write() put id %v, want %v", got, want)
+	}
+}
+
+func TestBatchWriter_Delete(t *testing.T) {
+	c := newFakeClient(t)
+	if _, err := write(t, c, []WriteOptionFn{WriteDelete("customer", "id")}, orders(1)...); err != nil {
+		t.Fatalf("Write() failed: %v", err)
+	}
+	del := c.writes[0][0].DeleteRequest
+	want := map[string]types.AttributeValue{
+		"customer": &types.AttributeValueMemberS{Value: "a"},
+		"id":       &types.AttributeValueMemberN{Value: "0"},
+	}
+	if del == nil || !reflect.DeepEqual(del.Key, want) {
+		t.Errorf("Write() deleted %v, want key %v", del, want)
+	}
+
+	// Elements without the key attributes can't be deleted.
+	if _, err := write(t, c, []WriteOptionFn{WriteDelete("sku")}, orders(1)...); err == nil {
+		t.Error("Write() without the key attributes succeeded, want error")
+	}
+}
+
+func TestBatchWriter_Unprocessed(t *testing.T) {
+	c := newFakeClient(t)
+	c.unprocessed = 2
+	if _, err := write(t, c, nil, orders(3)...); err != nil {
+		t.Fatalf("Write() failed: %v", err)
+	}
+	// The unprocessed items are retried.
+	if got, want := sizes(c.writes), []int{2, 0, 1}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Write() wrote batches of %v, want %v", got, want)
+	}
+
+	c = newFakeClient(t)
+	c.unprocessed = 3
+	if _, err := write(t, c, []WriteOptionFn{WriteMaxRetries(2)}, orders(3)...); err == nil {
+		t.Error("Write() with unprocessed items after the retries succeeded, want error")
+	}
+}
+
+func TestBatchWriter_Failures(t *testing.T) {
+	c := newFakeClient(t)
+	c.reject = func(item map[string]types.AttributeValue) error {
+		if reflect.DeepEqual(item["id"], &types.AttributeValueMemberN{Value: "1"}) {
+			return &smithy.GenericAPIError{Code: "ValidationException", Message: "item too large"}
+		}
+		return nil
+	}
+	failed, err := write(t, c, nil, orders(3)...)
+	if err != nil {
+		t.Fatalf("Write() failed: %v", err)
+	}
+	// The items of the rejected batch are written one at a time.
+	if got, want := sizes(c.writes), []int{1, 1}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Write() wrote batches of %v, want %v", got, want)
+	}
+	if len(failed) != 1 {
+		t.Fatalf("Write() failed %v items, want 1", len(failed))
+	}
+	item, err := unmarshalItem(failed[0].Item)
+	if err != nil {
+		t.Fatalf("unmarshalItem() failed: %v", err)
+	}
+	if got, want := item["id"], (&types.AttributeValueMemberN{Value: "1"}); !reflect.DeepEqual(got, want) {
+		t.Errorf("Write() failed item %v, want id %v", item, want)
+	}
+
+	// Throttled writes aren't rejections.
+	c.reject = func(map[string]types.AttributeValue) error {
+		return &smithy.GenericAPIError{Code: "ProvisionedThroughputExceededException"}
+	}
+	if _, err := write(t, c, nil, orders(3)...); err == nil {
+		t.Error("Write() while throttled succeeded, want error")
+	}
+}
+
+func TestWriteFn_Failures(t *testing.T) {
+	c := newFakeClient(t)
+	c.reject = func(map[string]types.AttributeValue) error {
+		return &smithy.GenericAPIError{Code: "ValidationException"}
+	}
+	fn := &writeFn{Table: "orders", Options: newWriteOption(nil), newClient: c.newClient}
+	ctx := context.Background()
+	if err := fn.Setup(ctx); err != nil {
+		t.Fatalf("Setup() failed: %v", err)
+	}
+	if err := fn.ProcessElement(ctx, orders(1)[0]); err != nil {
+		t.Fatalf("ProcessElement() failed: %v", err)
+	}
+	if err := fn.FinishBundle(ctx); err == nil {
+		t.Error("FinishBundle() succeeded, want error")
+	}
+}
+
+func TestWrite_Options(t *testing.T) {
+	tests := []struct {
+		name string
+		fn   func()
+	}{
+		{"zero batch size", func() { WriteBatchSize(0) }},
+		{"batch size above 25", func() { WriteBatchSize(26) }},
+		{"negative max retries", func() { WriteMaxRetries(-1) }},
+		{"zero backoff", func() { WriteBackoff(0, time.Second) }},
+		{"delete without keys", func() { WriteDelete() }},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%v succeeded, want panic", test.name)
+				}
+			}()
+			test.fn()
+		})
+	}
+}
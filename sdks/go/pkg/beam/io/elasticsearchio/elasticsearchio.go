@@ -0,0 +1,198 @@
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements.  See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License.  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package elasticsearchio contains transforms to read from and write to
+// Elasticsearch (https://www.elastic.co/elasticsearch) indices.
+//
+// The transforms use the REST API of Elasticsearch 7.12 or later, and are
+// given the addresses of the nodes of a cluster, such as
+// "https://localhost:9200". Experimental.
+package elasticsearchio
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	stderrors "errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/apache/beam/sdks/v2/go/pkg/beam"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/internal/errors"
+)
+
+func init() {
+	beam.RegisterType(reflect.TypeOf((*Document)(nil)).Elem())
+	beam.RegisterType(reflect.TypeOf((*FailedDocument)(nil)).Elem())
+}
+
+// Document is a document of an index, as read by Read and written by Write.
+type Document struct {
+	// Index is the name of the index of the document. Write writes
+	// documents without an index to its index.
+	Index string
+	// ID is the ID of the document. Write indexes documents without an ID
+	// with an ID generated by Elasticsearch, unless WriteContentIDs is set.
+	ID string
+	// Routing is the custom routing value of the document, if any.
+	Routing string
+	// Source is the JSON source of the document.
+	Source []byte
+}
+
+// FailedDocument is a document that couldn't be written, as output by
+// WriteWithFailures.
+type FailedDocument struct {
+	// Document is the document.
+	Document Document
+	// Status is the HTTP status of its failure, such as 400 for a document
+	// that doesn't match the mapping of its index.
+	Status int
+	// Error describes why the document couldn't be written.
+	Error string
+}
+
+// credentials are the credentials of requests, if any.
+type credentials struct {
+	Username string
+	Password string
+	APIKey   string
+}
+
+// statusError is the error of a request that failed with an HTTP status.
+type statusError struct {
+	Status int
+	Body   string
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("status %v: %v", e.Status, e.Body)
+}
+
+// isRetryable returns whether the request that failed with the error can be
+// retried, because the cluster was unavailable or rejected it to push back.
+func isRetryable(err error) bool {
+	var se *statusError
+	if !stderrors.As(err, &se) {
+		// The request failed before a response, such as for a lost
+		// connection.
+		return true
+	}
+	return isRetryableStatus(se.Status)
+}
+
+// isRetryableStatus returns whether a request, or a write of a document,
+// that failed with the HTTP status can be retried.
+func isRetryableStatus(status int) bool {
+	return status == http.StatusTooManyRequests || status == http.StatusBadGateway ||
+		status == http.StatusServiceUnavailable || status == http.StatusGatewayTimeout
+}
+
+// client sends requests to the nodes of a cluster. Requests that fail to
+// connect to a node are sent to the next.
+type client struct {
+	addresses   []string
+	credentials credentials
+	http        *http.Client
+
+	mu   sync.Mutex
+	next int
+}
+
+func newClient(addresses []string, c credentials) *client {
+	return &client{addresses: addresses, credentials: c, http: http.DefaultClient}
+}
+
+// do sends a request with the body, which is JSON unless the content type is
+// set, and decodes the JSON response into out, if it's set.
+func (c *client) do(ctx context.Context, method, path string, body []byte, contentType string, out interface{}) error {
+	if contentType == "" {
+		contentType = "application/json"
+	}
+	var err error
+	for i := 0; i < len(c.addresses); i++ {
+		var resp *http.Response
+		if resp, err = c.send(ctx, c.address(), method, path, body, contentType); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			c.failed()
+			continue
+		}
+		defer resp.Body.Close()
+		data, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return errors.Wrapf(err, "reading response of %v %v", method, path)
+		}
+		if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+			return errors.Wrapf(&statusError{Status: resp.StatusCode, Body: string(data)}, "%v %v", method, path)
+		}
+		if out == nil {
+			return nil
+		}
+		return errors.Wrapf(json.Unmarshal(data, out), "decoding response of %v %v", method, path)
+	}
+	return errors.Wrapf(err, "%v %v", method, path)
+}
+
+// send sends the request to the node at the address.
+func (c *client) send(ctx context.Context, address, method, path string, body []byte, contentType string) (*http.Response, error) {
+	var r io.Reader
+	if body != nil {
+		r = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimSuffix(address, "/")+path, r)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", contentType)
+	}
+	switch {
+	case c.credentials.APIKey != "":
+		req.Header.Set("Authorization", "ApiKey "+c.credentials.APIKey)
+	case c.credentials.Username != "":
+		req.SetBasicAuth(c.credentials.Username, c.credentials.Password)
+	}
+	return c.http.Do(req)
+}
+
+// address returns the address of the node requests are sent to.
+func (c *client) address() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.addresses[c.next]
+}
+
+// failed sends requests to the next node, after a request failed to connect.
+func (c *client) failed() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.next = (c.next + 1) % len(c.addresses)
+}
+
+// mustMarshal returns the JSON encoding of a request body, which can always
+// be encoded.
+func mustMarshal(v interface{}) []byte {
+	data, err := json.Marshal(v)
+	if err != nil {
+		panic(fmt.Sprintf("elasticsearchio: encoding request %v: %v", v, err))
+	}
+	return data
+}
@@ -0,0 +1,226 @@
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements.  See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License.  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package elasticsearchio
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/testing/ptest"
+)
+
+func TestMain(m *testing.M) {
+	ptest.Main(m)
+}
+
+// fakeCluster is a cluster of a node serving the parts of the REST API used
+// by the transforms, with the documents of an index.
+type fakeCluster struct {
+	// shards is the number of shards of the index.
+	shards int
+	docs   []Document
+	// status returns the status of a write of a document, if it's set.
+	status func(d Document) int
+	// failures is the number of bulk requests that fail with 429 before
+	// bulk requests are handled.
+	failures int
+
+	mu       sync.Mutex
+	requests []string
+	scrolls  map[string][]hit
+	cleared  []string
+	written  []Document
+	auth     []string
+	*httptest.Server
+}
+
+func newFakeCluster(t *testing.T, docs ...Document) *fakeCluster {
+	c := &fakeCluster{shards: 1, docs: docs, scrolls: map[string][]hit{}}
+	c.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body bytes.Buffer
+		body.ReadFrom(r.Body)
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		c.requests = append(c.requests, r.Method+" "+r.URL.Path)
+		c.auth = append(c.auth, r.Header.Get("Authorization"))
+		resp, status := c.handle(r, body.Bytes())
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(resp)
+	}))
+	t.Cleanup(c.Close)
+	return c
+}
+
+func (c *fakeCluster) handle(r *http.Request, body []byte) (interface{}, int) {
+	switch {
+	case strings.HasSuffix(r.URL.Path, "/_search_shards"):
+		return map[string]interface{}{"shards": make([][]struct{}, c.shards)}, http.StatusOK
+	case strings.HasSuffix(r.URL.Path, "/_pit"):
+		return map[string]string{"id": "pit"}, http.StatusOK
+	case r.URL.Path == "/_search/scroll" && r.Method == http.MethodDelete:
+		var req struct {
+			ScrollID []string `json:"scroll_id"`
+		}
+		json.Unmarshal(body, &req)
+		c.cleared = append(c.cleared, req.ScrollID...)
+		return map[string]bool{"succeeded": true}, http.StatusOK
+	case r.URL.Path == "/_search/scroll":
+		var req struct {
+			ScrollID string `json:"scroll_id"`
+		}
+		json.Unmarshal(body, &req)
+		return c.page(req.ScrollID), http.StatusOK
+	case r.URL.Path == "/_search":
+		return c.searchAfter(body), http.StatusOK
+	case strings.HasSuffix(r.URL.Path, "/_search"):
+		id := fmt.Sprintf("scroll%v", len(c.scrolls))
+		c.scrolls[id] = c.slice(body)
+		return c.page(id), http.StatusOK
+	case r.URL.Path == "/_bulk":
+		if c.failures > 0 {
+			c.failures--
+			return map[string]string{"error": "too many requests"}, http.StatusTooManyRequests
+		}
+		return c.bulk(body), http.StatusOK
+	}
+	return map[string]string{"error": "not found"}, http.StatusNotFound
+}
+
+// searchRequest is the body of a search request.
+type searchRequest struct {
+	Size  int `json:"size"`
+	Slice *struct {
+		ID  int `json:"id"`
+		Max int `json:"max"`
+	} `json:"slice"`
+	SearchAfter []int `json:"search_after"`
+}
+
+// slice returns the hits of the slice of the search request.
+func (c *fakeCluster) slice(body []byte) []hit {
+	var req searchRequest
+	json.Unmarshal(body, &req)
+	var hits []hit
+	for i, d := range c.docs {
+		if req.Slice != nil && i%req.Slice.Max != req.Slice.ID {
+			continue
+		}
+		sort, _ := json.Marshal([]int{i})
+		hits = append(hits, hit{Index: d.Index, ID: d.ID, Routing: d.Routing, Source: d.Source, Sort: sort})
+	}
+	return hits
+}
+
+// page returns the next page of hits of the scroll, of a hit.
+func (c *fakeCluster) page(id string) interface{} {
+	hits := c.scrolls[id]
+	var page []hit
+	if len(hits) > 0 {
+		page, c.scrolls[id] = hits[:1], hits[1:]
+	}
+	return map[string]interface{}{"_scroll_id": id, "hits": map[string]interface{}{"hits": page}}
+}
+
+// searchAfter returns the page of hits of the search request of a point in
+// time.
+func (c *fakeCluster) searchAfter(body []byte) interface{} {
+	var req searchRequest
+	json.Unmarshal(body, &req)
+	var page []hit
+	for _, h := range c.slice(body) {
+		var sort []int
+		json.Unmarshal(h.Sort, &sort)
+		if len(req.SearchAfter) > 0 && sort[0] <= req.SearchAfter[0] {
+			continue
+		}
+		if len(page) < req.Size {
+			page = append(page, h)
+		}
+	}
+	return map[string]interface{}{"pit_id": "pit", "hits": map[string]interface{}{"hits": page}}
+}
+
+// bulk writes the documents of the bulk request.
+func (c *fakeCluster) bulk(body []byte) interface{} {
+	var items []interface{}
+	errs := false
+	lines := bufio.NewScanner(bytes.NewReader(body))
+	for lines.Scan() {
+		var action struct {
+			Index struct {
+				Index   string `json:"_index"`
+				ID      string `json:"_id"`
+				Routing string `json:"routing"`
+			} `json:"index"`
+		}
+		json.Unmarshal(lines.Bytes(), &action)
+		lines.Scan()
+		d := Document{Index: action.Index.Index, ID: action.Index.ID, Routing: action.Index.Routing, Source: append([]byte(nil), lines.Bytes()...)}
+		status := http.StatusCreated
+		if c.status != nil {
+			status = c.status(d)
+		}
+		item := map[string]interface{}{"status": status}
+		if status == http.StatusCreated {
+			c.written = append(c.written, d)
+		} else {
+			errs = true
+			item["error"] = map[string]string{"type": "mapper_parsing_exception", "reason": "failed to parse"}
+		}
+		items = append(items, map[string]interface{}{"index": item})
+	}
+	return map[string]interface{}{"errors": errs, "items": items}
+}
+
+func TestClient_Failover(t *testing.T) {
+	c := newFakeCluster(t)
+	// The first address refuses connections.
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+
+	cl := newClient([]string{down.URL, c.URL}, credentials{APIKey: "key"})
+	for i := 0; i < 2; i++ {
+		if err := cl.do(context.Background(), http.MethodGet, "/orders/_search_shards", nil, "", nil); err != nil {
+			t.Fatalf("do() failed: %v", err)
+		}
+	}
+	// Requests are sent to the next node once a node fails.
+	if got, want := len(c.requests), 2; got != want {
+		t.Errorf("do() sent %v requests to the node, want %v", got, want)
+	}
+	if got, want := c.auth[0], "ApiKey key"; got != want {
+		t.Errorf("do() authorized with %q, want %q", got, want)
+	}
+}
+
+func TestClient_Status(t *testing.T) {
+	c := newFakeCluster(t)
+	err := newClient([]string{c.URL}, credentials{}).do(context.Background(), http.MethodGet, "/unknown", nil, "", nil)
+	if err == nil {
+		t.Fatal("do() succeeded, want error")
+	}
+	if isRetryable(err) {
+		t.Errorf("isRetryable(%v) = true, want false", err)
+	}
+}
@@ -0,0 +1,310 @@
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements.  See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License.  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package elasticsearchio
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"reflect"
+
+	"github.com/apache/beam/sdks/v2/go/pkg/beam"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/internal/errors"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/log"
+)
+
+const (
+	// defaultReadBatchSize is the number of documents of each search
+	// request, unless another is set with ReadBatchSize.
+	defaultReadBatchSize = 1000
+	// keepAlive is how long scrolls and points in time are kept alive
+	// between search requests.
+	keepAlive = "5m"
+)
+
+func init() {
+	beam.RegisterType(reflect.TypeOf((*slice)(nil)).Elem())
+	beam.RegisterType(reflect.TypeOf((*splitFn)(nil)).Elem())
+	beam.RegisterType(reflect.TypeOf((*readFn)(nil)).Elem())
+}
+
+// readOption holds the options of Read.
+type readOption struct {
+	// Query is the JSON query of the documents read, if any.
+	Query       string
+	Slices      int
+	BatchSize   int
+	PointInTime bool
+	Credentials credentials
+}
+
+// ReadOptionFn is an option for Read.
+type ReadOptionFn func(*readOption)
+
+// ReadQuery sets the JSON query of the documents read, in the query DSL of
+// Elasticsearch, such as `{"match": {"status": "shipped"}}`. By default, all
+// documents are read.
+func ReadQuery(query string) ReadOptionFn {
+	if !json.Valid([]byte(query)) {
+		panic(fmt.Sprintf("elasticsearchio.ReadQuery query must be JSON. Got: %v", query))
+	}
+	return func(o *readOption) {
+		o.Query = query
+	}
+}
+
+// ReadSlices sets the number of slices the documents are read in, in
+// parallel. By default, documents are read in a slice per shard of the
+// index.
+func ReadSlices(n int) ReadOptionFn {
+	if n < 1 {
+		panic(fmt.Sprintf("elasticsearchio.ReadSlices number must be positive. Got: %v", n))
+	}
+	return func(o *readOption) {
+		o.Slices = n
+	}
+}
+
+// ReadBatchSize sets the number of documents of each search request. The
+// default is 1000.
+func ReadBatchSize(n int) ReadOptionFn {
+	if n < 1 {
+		panic(fmt.Sprintf("elasticsearchio.ReadBatchSize size must be positive. Got: %v", n))
+	}
+	return func(o *readOption) {
+		o.BatchSize = n
+	}
+}
+
+// ReadPointInTime sets the documents to be read from a point in time of the
+// index, which all slices read, instead of from a scroll per slice. Reads of
+// points in time are consistent across slices, and don't hold a search
+// context on the cluster while their documents are processed.
+func ReadPointInTime() ReadOptionFn {
+	return func(o *readOption) {
+		o.PointInTime = true
+	}
+}
+
+// ReadCredentials sets the username and password of basic authentication.
+func ReadCredentials(username, password string) ReadOptionFn {
+	return func(o *readOption) {
+		o.Credentials = credentials{Username: username, Password: password}
+	}
+}
+
+// ReadAPIKey sets the base64 encoded API key to authenticate with.
+func ReadAPIKey(key string) ReadOptionFn {
+	return func(o *readOption) {
+		o.Credentials = credentials{APIKey: key}
+	}
+}
+
+// Read reads the documents of the given index, or indices or aliases, from
+// the cluster at the addresses, and returns a PCollection<Document>. For
+// example:
+//
+//	docs := elasticsearchio.Read(s, []string{"https://localhost:9200"}, "orders",
+//		elasticsearchio.ReadQuery(`{"range": {"total": {"gte": 100}}}`))
+//
+// Documents are read in slices, in parallel, with sliced scrolls, or with
+// sliced searches of a point in time if ReadPointInTime is set. Points in
+// time are closed once they expire, after their last search.
+func Read(s beam.Scope, addresses []string, index string, opts ...ReadOptionFn) beam.PCollection {
+	s = s.Scope("elasticsearchio.Read")
+	if len(addresses) == 0 || index == "" {
+		panic("elasticsearchio.Read requires addresses and an index")
+	}
+
+	o := readOption{
+		BatchSize: defaultReadBatchSize,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	imp := beam.Impulse(s)
+	slices := beam.ParDo(s, &splitFn{Addresses: addresses, Index: index, Options: o}, imp)
+	slices = beam.Reshuffle(s, slices)
+	return beam.ParDo(s, &readFn{Addresses: addresses, Index: index, Options: o}, slices)
+}
+
+// slice is a slice of the documents read.
+type slice struct {
+	ID  int
+	Max int
+	// PIT is the ID of the point in time read, if any.
+	PIT string
+}
+
+// splitFn splits the documents read into slices, opening the point in time
+// they read if needed.
+type splitFn struct {
+	// Addresses are the addresses of the nodes of the cluster.
+	Addresses []string `json:"addresses"`
+	// Index is the index read.
+	Index string `json:"index"`
+	// Options specifies the query, slices and credentials.
+	Options readOption `json:"options"`
+}
+
+func (f *splitFn) ProcessElement(ctx context.Context, _ []byte, emit func(slice)) error {
+	c := newClient(f.Addresses, f.Options.Credentials)
+	n := f.Options.Slices
+	if n == 0 {
+		var shards struct {
+			Shards [][]json.RawMessage `json:"shards"`
+		}
+		if err := c.do(ctx, http.MethodGet, "/"+url.PathEscape(f.Index)+"/_search_shards", nil, "", &shards); err != nil {
+			return errors.Wrapf(err, "getting shards of %v", f.Index)
+		}
+		if n = len(shards.Shards); n == 0 {
+			n = 1
+		}
+	}
+	var pit string
+	if f.Options.PointInTime {
+		var resp struct {
+			ID string `json:"id"`
+		}
+		path := fmt.Sprintf("/%v/_pit?keep_alive=%v", url.PathEscape(f.Index), keepAlive)
+		if err := c.do(ctx, http.MethodPost, path, nil, "", &resp); err != nil {
+			return errors.Wrapf(err, "opening point in time of %v", f.Index)
+		}
+		pit = resp.ID
+	}
+	log.Infof(ctx, "Reading %v in %v slices", f.Index, n)
+	for i := 0; i < n; i++ {
+		emit(slice{ID: i, Max: n, PIT: pit})
+	}
+	return nil
+}
+
+// searchResponse is the response of a search request.
+type searchResponse struct {
+	ScrollID string `json:"_scroll_id"`
+	PITID    string `json:"pit_id"`
+	Hits     struct {
+		Hits []hit `json:"hits"`
+	} `json:"hits"`
+}
+
+// hit is a document found by a search request.
+type hit struct {
+	Index   string          `json:"_index"`
+	ID      string          `json:"_id"`
+	Routing string          `json:"_routing"`
+	Source  json.RawMessage `json:"_source"`
+	Sort    json.RawMessage `json:"sort"`
+}
+
+func (h hit) document() Document {
+	return Document{Index: h.Index, ID: h.ID, Routing: h.Routing, Source: []byte(h.Source)}
+}
+
+// readFn reads the documents of slices.
+type readFn struct {
+	// Addresses are the addresses of the nodes of the cluster.
+	Addresses []string `json:"addresses"`
+	// Index is the index read.
+	Index string `json:"index"`
+	// Options specifies the query, batch size and credentials.
+	Options readOption `json:"options"`
+
+	c *client
+}
+
+func (f *readFn) Setup() {
+	f.c = newClient(f.Addresses, f.Options.Credentials)
+}
+
+func (f *readFn) ProcessElement(ctx context.Context, s slice, emit func(Document)) error {
+	if s.PIT != "" {
+		return f.readPointInTime(ctx, s, emit)
+	}
+	return f.readScroll(ctx, s, emit)
+}
+
+// search returns the body of the first search request of the slice.
+func (f *readFn) search(s slice, sort string) map[string]interface{} {
+	search := map[string]interface{}{
+		"size": f.Options.BatchSize,
+		"sort": []string{sort},
+	}
+	if f.Options.Query != "" {
+		search["query"] = json.RawMessage(f.Options.Query)
+	}
+	if s.Max > 1 {
+		search["slice"] = map[string]int{"id": s.ID, "max": s.Max}
+	}
+	return search
+}
+
+// readScroll reads the slice with a scroll, which it clears once it's read.
+func (f *readFn) readScroll(ctx context.Context, s slice, emit func(Document)) error {
+	var resp searchResponse
+	path := fmt.Sprintf("/%v/_search?scroll=%v", url.PathEscape(f.Index), keepAlive)
+	if err := f.c.do(ctx, http.MethodPost, path, mustMarshal(f.search(s, "_doc")), "", &resp); err != nil {
+		return errors.Wrapf(err, "searching %v", f.Index)
+	}
+	defer func() {
+		if resp.ScrollID == "" {
+			return
+		}
+		body := mustMarshal(map[string][]string{"scroll_id": {resp.ScrollID}})
+		if err := f.c.do(ctx, http.MethodDelete, "/_search/scroll", body, "", nil); err != nil {
+			log.Warnf(ctx, "Failed to clear scroll of %v: %v", f.Index, err)
+		}
+	}()
+	for len(resp.Hits.Hits) > 0 {
+		for _, h := range resp.Hits.Hits {
+			emit(h.document())
+		}
+		body := mustMarshal(map[string]string{"scroll": keepAlive, "scroll_id": resp.ScrollID})
+		resp.Hits.Hits = nil
+		if err := f.c.do(ctx, http.MethodPost, "/_search/scroll", body, "", &resp); err != nil {
+			return errors.Wrapf(err, "scrolling %v", f.Index)
+		}
+	}
+	return nil
+}
+
+// readPointInTime reads the slice of the point in time, with searches after
+// the last document found.
+func (f *readFn) readPointInTime(ctx context.Context, s slice, emit func(Document)) error {
+	search := f.search(s, "_shard_doc")
+	pit := s.PIT
+	for {
+		search["pit"] = map[string]string{"id": pit, "keep_alive": keepAlive}
+		var resp searchResponse
+		if err := f.c.do(ctx, http.MethodPost, "/_search", mustMarshal(search), "", &resp); err != nil {
+			return errors.Wrapf(err, "searching %v", f.Index)
+		}
+		hits := resp.Hits.Hits
+		for _, h := range hits {
+			emit(h.document())
+		}
+		if len(hits) < f.Options.BatchSize {
+			return nil
+		}
+		// The point in time may be updated by searches.
+		if resp.PITID != "" {
+			pit = resp.PITID
+		}
+		search["search_after"] = hits[len(hits)-1].Sort
+	}
+}
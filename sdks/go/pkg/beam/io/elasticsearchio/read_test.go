@@ -0,0 +1,153 @@
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements.  See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License.  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package elasticsearchio
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/apache/beam/sdks/v2/go/pkg/beam"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/testing/passert"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/testing/ptest"
+)
+
+// orders returns n documents of the orders index.
+func orders(n int) []Document {
+	var docs []Document
+	for i := 0; i < n; i++ {
+		docs = append(docs, Document{Index: "orders", ID: fmt.Sprint(i), Source: []byte(fmt.Sprintf(`{"total":%v}`, i))})
+	}
+	return docs
+}
+
+// readOptions returns the read options with the defaults of Read.
+func readOptions(opts ...ReadOptionFn) readOption {
+	o := readOption{BatchSize: defaultReadBatchSize}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// split returns the slices output by the splitter.
+func split(t *testing.T, fn *splitFn) []slice {
+	t.Helper()
+	var got []slice
+	if err := fn.ProcessElement(context.Background(), nil, func(s slice) { got = append(got, s) }); err != nil {
+		t.Fatalf("ProcessElement() failed: %v", err)
+	}
+	return got
+}
+
+// read returns the IDs of the documents of the slices read by the reader.
+func read(t *testing.T, fn *readFn, slices ...slice) []string {
+	t.Helper()
+	fn.Setup()
+	var ids []string
+	for _, s := range slices {
+		if err := fn.ProcessElement(context.Background(), s, func(d Document) { ids = append(ids, d.ID) }); err != nil {
+			t.Fatalf("ProcessElement() failed: %v", err)
+		}
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func TestSplitFn(t *testing.T) {
+	c := newFakeCluster(t)
+	c.shards = 3
+	tests := []struct {
+		name string
+		opts []ReadOptionFn
+		want []slice
+	}{
+		{"shards", nil, []slice{{0, 3, ""}, {1, 3, ""}, {2, 3, ""}}},
+		{"slices", []ReadOptionFn{ReadSlices(2)}, []slice{{0, 2, ""}, {1, 2, ""}}},
+		{"point in time", []ReadOptionFn{ReadSlices(1), ReadPointInTime()}, []slice{{0, 1, "pit"}}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fn := &splitFn{Addresses: []string{c.URL}, Index: "orders", Options: readOptions(test.opts...)}
+			if got := split(t, fn); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("ProcessElement() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestReadFn_Scroll(t *testing.T) {
+	c := newFakeCluster(t, orders(5)...)
+	fn := &readFn{Addresses: []string{c.URL}, Index: "orders", Options: readOptions()}
+	got := read(t, fn, slice{0, 2, ""}, slice{1, 2, ""})
+	if want := []string{"0", "1", "2", "3", "4"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ProcessElement() read %v, want %v", got, want)
+	}
+	// The scrolls of both slices are cleared once they're read.
+	sort.Strings(c.cleared)
+	if want := []string{"scroll0", "scroll1"}; !reflect.DeepEqual(c.cleared, want) {
+		t.Errorf("ProcessElement() cleared %v, want %v", c.cleared, want)
+	}
+}
+
+func TestReadFn_PointInTime(t *testing.T) {
+	c := newFakeCluster(t, orders(5)...)
+	fn := &readFn{Addresses: []string{c.URL}, Index: "orders", Options: readOptions(ReadBatchSize(2))}
+	got := read(t, fn, slice{0, 1, "pit"})
+	if want := []string{"0", "1", "2", "3", "4"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ProcessElement() read %v, want %v", got, want)
+	}
+	// The documents are read in searches of the batch size, after the last
+	// document found.
+	if got, want := len(c.requests), 3; got != want {
+		t.Errorf("ProcessElement() sent %v requests, want %v", got, want)
+	}
+}
+
+func TestRead(t *testing.T) {
+	docs := orders(4)
+	c := newFakeCluster(t, docs...)
+	c.shards = 2
+
+	p, s := beam.NewPipelineWithRoot()
+	got := Read(s, []string{c.URL}, "orders")
+	passert.Equals(s, beam.ParDo(s, func(d Document) string { return d.ID }, got), "0", "1", "2", "3")
+	ptest.RunAndValidate(t, p)
+}
+
+func TestRead_Options(t *testing.T) {
+	tests := []struct {
+		name string
+		fn   func()
+	}{
+		{"invalid query", func() { ReadQuery("{") }},
+		{"zero slices", func() { ReadSlices(0) }},
+		{"zero batch size", func() { ReadBatchSize(0) }},
+		{"no index", func() { Read(beam.NewPipeline().Root(), []string{"http://localhost:9200"}, "") }},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%v succeeded, want panic", test.name)
+				}
+			}()
+			test.fn()
+		})
+	}
+}
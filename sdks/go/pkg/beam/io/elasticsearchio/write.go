@@ -0,0 +1,419 @@
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements.  See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License.  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package elasticsearchio
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"time"
+
+	"github.com/apache/beam/sdks/v2/go/pkg/beam"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/internal/errors"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/log"
+)
+
+const (
+	defaultWriteBatchSize  = 1000
+	defaultWriteBatchBytes = 5 << 20
+	defaultMaxRetries      = 5
+	defaultInitialBackoff  = time.Second
+	defaultMaxBackoff      = time.Minute
+)
+
+var (
+	documentsWritten = beam.NewCounter("elasticsearchio", "documents_written")
+	documentsRetried = beam.NewCounter("elasticsearchio", "documents_retried")
+	documentsFailed  = beam.NewCounter("elasticsearchio", "documents_failed")
+)
+
+func init() {
+	beam.RegisterType(reflect.TypeOf((*writeFn)(nil)).Elem())
+	beam.RegisterType(reflect.TypeOf((*writeWithFailuresFn)(nil)).Elem())
+}
+
+// writeOption holds the options of Write and WriteWithFailures.
+type writeOption struct {
+	BatchSize      int
+	BatchBytes     int
+	MaxRetries     int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	ContentIDs     bool
+	Credentials    credentials
+}
+
+// WriteOptionFn is an option for Write and WriteWithFailures.
+type WriteOptionFn func(*writeOption)
+
+func newWriteOption(opts []WriteOptionFn) writeOption {
+	o := writeOption{
+		BatchSize:      defaultWriteBatchSize,
+		BatchBytes:     defaultWriteBatchBytes,
+		MaxRetries:     defaultMaxRetries,
+		InitialBackoff: defaultInitialBackoff,
+		MaxBackoff:     defaultMaxBackoff,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// WriteBatchSize limits the number of documents of each bulk request. The
+// default is 1000.
+func WriteBatchSize(n int) WriteOptionFn {
+	if n < 1 {
+		panic(fmt.Sprintf("elasticsearchio.WriteBatchSize size must be positive. Got: %v", n))
+	}
+	return func(o *writeOption) {
+		o.BatchSize = n
+	}
+}
+
+// WriteBatchBytes limits the size in bytes of each bulk request. The default
+// is 5 MiB.
+func WriteBatchBytes(n int) WriteOptionFn {
+	if n < 1 {
+		panic(fmt.Sprintf("elasticsearchio.WriteBatchBytes size must be positive. Got: %v", n))
+	}
+	return func(o *writeOption) {
+		o.BatchBytes = n
+	}
+}
+
+// WriteMaxRetries sets the number of times documents that are rejected with
+// a retryable status, such as 429 Too Many Requests when the cluster pushes
+// back, are retried. The default is 5.
+func WriteMaxRetries(n int) WriteOptionFn {
+	if n < 0 {
+		panic(fmt.Sprintf("elasticsearchio.WriteMaxRetries max retries must be non-negative. Got: %v", n))
+	}
+	return func(o *writeOption) {
+		o.MaxRetries = n
+	}
+}
+
+// WriteBackoff sets the backoff between retries, which starts at initial and
+// doubles after each retry, up to max. The default is 1s, up to 1m.
+func WriteBackoff(initial, max time.Duration) WriteOptionFn {
+	if initial <= 0 || max < initial {
+		panic(fmt.Sprintf("elasticsearchio.WriteBackoff invalid backoff. Got: %v, %v", initial, max))
+	}
+	return func(o *writeOption) {
+		o.InitialBackoff = initial
+		o.MaxBackoff = max
+	}
+}
+
+// WriteContentIDs sets documents without an ID to be indexed with the
+// SHA-256 hash of their source as their ID, instead of an ID generated by
+// Elasticsearch, so that retried and rerun writes replace the documents they
+// wrote instead of duplicating them. Documents with the same source are
+// then written as the same document.
+func WriteContentIDs() WriteOptionFn {
+	return func(o *writeOption) {
+		o.ContentIDs = true
+	}
+}
+
+// WriteCredentials sets the username and password of basic authentication.
+func WriteCredentials(username, password string) WriteOptionFn {
+	return func(o *writeOption) {
+		o.Credentials = credentials{Username: username, Password: password}
+	}
+}
+
+// WriteAPIKey sets the base64 encoded API key to authenticate with.
+func WriteAPIKey(key string) WriteOptionFn {
+	return func(o *writeOption) {
+		o.Credentials = credentials{APIKey: key}
+	}
+}
+
+// Write indexes the documents of the given PCollection<Document> in the
+// cluster at the addresses, with bulk requests. Documents without an index
+// are indexed in the given index, and documents with an ID replace the
+// document with the ID. For example:
+//
+//	elasticsearchio.Write(s, []string{"https://localhost:9200"}, "orders", docs)
+//
+// Documents are batched into bulk requests within the limits of the
+// options. Documents rejected with a retryable status, such as 429 Too Many
+// Requests when the cluster pushes back, are retried with backoff. Documents
+// that still fail, or that are rejected, such as for not matching the
+// mapping of their index, fail the bundle.
+func Write(s beam.Scope, addresses []string, index string, col beam.PCollection, opts ...WriteOptionFn) {
+	s = s.Scope("elasticsearchio.Write")
+	if len(addresses) == 0 {
+		panic("elasticsearchio.Write requires addresses")
+	}
+
+	o := newWriteOption(opts)
+	beam.ParDo0(s, &writeFn{Addresses: addresses, Index: index, Options: o}, col)
+}
+
+// WriteWithFailures indexes the documents of the given PCollection<Document>
+// like Write, but returns the documents that are rejected, such as for not
+// matching the mapping of their index, as a PCollection<FailedDocument>
+// instead of failing the bundle, such as to write them to a dead-letter
+// destination. Documents that still fail with a retryable status once their
+// retries are exhausted fail the bundle.
+func WriteWithFailures(s beam.Scope, addresses []string, index string, col beam.PCollection, opts ...WriteOptionFn) beam.PCollection {
+	s = s.Scope("elasticsearchio.WriteWithFailures")
+	if len(addresses) == 0 {
+		panic("elasticsearchio.WriteWithFailures requires addresses")
+	}
+
+	o := newWriteOption(opts)
+	return beam.ParDo(s, &writeWithFailuresFn{Addresses: addresses, Index: index, Options: o}, col)
+}
+
+// writeFn indexes documents, and fails if any can't be indexed.
+type writeFn struct {
+	// Addresses are the addresses of the nodes of the cluster.
+	Addresses []string `json:"addresses"`
+	// Index is the index of documents without one.
+	Index string `json:"index"`
+	// Options specifies the batching, retries, IDs and credentials.
+	Options writeOption `json:"options"`
+
+	w *bulkWriter
+}
+
+func (f *writeFn) Setup() {
+	f.w = newBulkWriter(newClient(f.Addresses, f.Options.Credentials), f.Index, f.Options)
+}
+
+func (f *writeFn) ProcessElement(ctx context.Context, d Document) error {
+	return failure(f.w.Add(ctx, d))
+}
+
+func (f *writeFn) FinishBundle(ctx context.Context) error {
+	return failure(f.w.Flush(ctx))
+}
+
+// failure returns the error, or an error describing the failed documents,
+// if any.
+func failure(failed []FailedDocument, err error) error {
+	if err != nil || len(failed) == 0 {
+		return err
+	}
+	return errors.Errorf("elasticsearch write error: %v documents failed, such as: %v", len(failed), failed[0].Error)
+}
+
+// writeWithFailuresFn indexes documents, and outputs those that can't be
+// indexed.
+type writeWithFailuresFn struct {
+	// Addresses are the addresses of the nodes of the cluster.
+	Addresses []string `json:"addresses"`
+	// Index is the index of documents without one.
+	Index string `json:"index"`
+	// Options specifies the batching, retries, IDs and credentials.
+	Options writeOption `json:"options"`
+
+	w *bulkWriter
+}
+
+func (f *writeWithFailuresFn) Setup() {
+	f.w = newBulkWriter(newClient(f.Addresses, f.Options.Credentials), f.Index, f.Options)
+}
+
+func (f *writeWithFailuresFn) ProcessElement(ctx context.Context, d Document, emit func(FailedDocument)) error {
+	failed, err := f.w.Add(ctx, d)
+	for _, fd := range failed {
+		emit(fd)
+	}
+	return err
+}
+
+func (f *writeWithFailuresFn) FinishBundle(ctx context.Context, emit func(FailedDocument)) error {
+	failed, err := f.w.Flush(ctx)
+	for _, fd := range failed {
+		emit(fd)
+	}
+	return err
+}
+
+// bulkResponse is the response of a bulk request.
+type bulkResponse struct {
+	Errors bool `json:"errors"`
+	// Items are the results of the actions of the request, by action.
+	Items []map[string]struct {
+		Status int `json:"status"`
+		Error  *struct {
+			Type   string `json:"type"`
+			Reason string `json:"reason"`
+		} `json:"error"`
+	} `json:"items"`
+}
+
+// bulkWriter batches documents into bulk requests.
+type bulkWriter struct {
+	c     *client
+	index string
+	opts  writeOption
+
+	batch   []Document
+	actions [][]byte
+	size    int
+}
+
+func newBulkWriter(c *client, index string, opts writeOption) *bulkWriter {
+	return &bulkWriter{c: c, index: index, opts: opts}
+}
+
+// Add adds the document to the batch, and writes the batch first if the
+// document doesn't fit in it. It returns the documents that failed, if any.
+func (w *bulkWriter) Add(ctx context.Context, d Document) ([]FailedDocument, error) {
+	action, err := w.action(d)
+	if err != nil {
+		documentsFailed.Inc(ctx, 1)
+		return []FailedDocument{{Document: d, Status: http.StatusBadRequest, Error: err.Error()}}, nil
+	}
+	var failed []FailedDocument
+	if len(w.batch) > 0 && (len(w.batch) >= w.opts.BatchSize || w.size+len(action) > w.opts.BatchBytes) {
+		if failed, err = w.Flush(ctx); err != nil {
+			return failed, err
+		}
+	}
+	w.batch = append(w.batch, d)
+	w.actions = append(w.actions, action)
+	w.size += len(action)
+	return failed, nil
+}
+
+// action returns the index action of the document, as the lines of a bulk
+// request.
+func (w *bulkWriter) action(d Document) ([]byte, error) {
+	var source bytes.Buffer
+	if err := json.Compact(&source, d.Source); err != nil {
+		return nil, errors.Wrap(err, "invalid document source")
+	}
+	meta := map[string]string{"_index": w.index}
+	if d.Index != "" {
+		meta["_index"] = d.Index
+	}
+	switch {
+	case d.ID != "":
+		meta["_id"] = d.ID
+	case w.opts.ContentIDs:
+		hash := sha256.Sum256(source.Bytes())
+		meta["_id"] = hex.EncodeToString(hash[:])
+	}
+	if d.Routing != "" {
+		meta["routing"] = d.Routing
+	}
+	action := mustMarshal(map[string]interface{}{"index": meta})
+	action = append(action, '\n')
+	action = append(action, source.Bytes()...)
+	return append(action, '\n'), nil
+}
+
+// Flush writes the batch with bulk requests. Documents that are rejected with
+// a retryable status are retried with backoff, and it fails if they're still
+// rejected once their retries are exhausted. It returns the documents that
+// were rejected otherwise.
+func (w *bulkWriter) Flush(ctx context.Context) ([]FailedDocument, error) {
+	if len(w.batch) == 0 {
+		return nil, nil
+	}
+	batch, actions := w.batch, w.actions
+	w.batch, w.actions, w.size = nil, nil, 0
+
+	var failed []FailedDocument
+	backoff := w.opts.InitialBackoff
+	for attempt := 0; ; attempt++ {
+		retry, rejected, err := w.bulk(ctx, batch, actions)
+		if err != nil && !isRetryable(err) {
+			return failed, err
+		}
+		failed = append(failed, rejected...)
+		documentsFailed.Inc(ctx, int64(len(rejected)))
+		if err == nil && len(retry) == 0 {
+			documentsWritten.Inc(ctx, int64(len(batch)-len(rejected)))
+			return failed, nil
+		}
+		if err == nil {
+			documentsWritten.Inc(ctx, int64(len(batch)-len(rejected)-len(retry)))
+			batch, actions = pick(batch, retry), pick(actions, retry)
+		}
+		if attempt >= w.opts.MaxRetries {
+			if err == nil {
+				err = errors.Errorf("%v documents were rejected", len(batch))
+			}
+			return failed, errors.Wrapf(err, "writing %v documents after %v retries", len(batch), attempt)
+		}
+		documentsRetried.Inc(ctx, int64(len(batch)))
+		log.Warnf(ctx, "Retrying write of %v documents in %v: %v", len(batch), backoff, err)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return failed, ctx.Err()
+		}
+		if backoff *= 2; backoff > w.opts.MaxBackoff {
+			backoff = w.opts.MaxBackoff
+		}
+	}
+}
+
+// bulk writes the documents with a bulk request, and returns the indices of
+// the documents that can be retried, and the documents that were rejected
+// otherwise.
+func (w *bulkWriter) bulk(ctx context.Context, batch []Document, actions [][]byte) ([]int, []FailedDocument, error) {
+	var resp bulkResponse
+	if err := w.c.do(ctx, http.MethodPost, "/_bulk", bytes.Join(actions, nil), "application/x-ndjson", &resp); err != nil {
+		return nil, nil, err
+	}
+	if !resp.Errors {
+		return nil, nil, nil
+	}
+	if len(resp.Items) != len(batch) {
+		return nil, nil, errors.Errorf("bulk response has %v items, want %v", len(resp.Items), len(batch))
+	}
+	var retry []int
+	var rejected []FailedDocument
+	for i, item := range resp.Items {
+		for _, result := range item {
+			switch {
+			case result.Status >= 200 && result.Status < 300:
+			case isRetryableStatus(result.Status):
+				retry = append(retry, i)
+			default:
+				msg := fmt.Sprintf("status %v", result.Status)
+				if result.Error != nil {
+					msg = fmt.Sprintf("%v: %v", result.Error.Type, result.Error.Reason)
+				}
+				rejected = append(rejected, FailedDocument{Document: batch[i], Status: result.Status, Error: msg})
+			}
+		}
+	}
+	return retry, rejected, nil
+}
+
+// pick returns the elements of the slice at the indices.
+func pick[T any](s []T, indices []int) []T {
+	picked := make([]T, len(indices))
+	for i, index := range indices {
+		picked[i] = s[index]
+	}
+	return picked
+}
@@ -0,0 +1,198 @@
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements.  See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License.  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package elasticsearchio
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// write writes the documents with a writer in one bundle, and returns the
+// documents that failed and the error of finishing it.
+func write(t *testing.T, c *fakeCluster, opts []WriteOptionFn, docs ...Document) ([]FailedDocument, error) {
+	t.Helper()
+	opts = append([]WriteOptionFn{WriteBackoff(time.Millisecond, time.Millisecond)}, opts...)
+	fn := &writeWithFailuresFn{Addresses: []string{c.URL}, Index: "orders", Options: newWriteOption(opts)}
+	fn.Setup()
+	ctx := context.Background()
+	var failed []FailedDocument
+	emit := func(fd FailedDocument) { failed = append(failed, fd) }
+	for _, d := range docs {
+		if err := fn.ProcessElement(ctx, d, emit); err != nil {
+			return failed, err
+		}
+	}
+	return failed, fn.FinishBundle(ctx, emit)
+}
+
+func TestBulkWriter(t *testing.T) {
+	c := newFakeCluster(t)
+	docs := []Document{
+		{ID: "1", Source: []byte(`{"total": 1}`)},
+		{Index: "returns", ID: "2", Routing: "eu", Source: []byte(`{"total": 2}`)},
+		{ID: "3", Source: []byte(`{"total": 3}`)},
+	}
+	if _, err := write(t, c, []WriteOptionFn{WriteBatchSize(2)}, docs...); err != nil {
+		t.Fatalf("Write() failed: %v", err)
+	}
+	// Documents are written to their index, or the index of the writer, with
+	// compacted sources, in bulk requests of the batch size.
+	want := []Document{
+		{Index: "orders", ID: "1", Source: []byte(`{"total":1}`)},
+		{Index: "returns", ID: "2", Routing: "eu", Source: []byte(`{"total":2}`)},
+		{Index: "orders", ID: "3", Source: []byte(`{"total":3}`)},
+	}
+	if !reflect.DeepEqual(c.written, want) {
+		t.Errorf("Write() wrote %v, want %v", c.written, want)
+	}
+	if got, want := c.requests, []string{"POST /_bulk", "POST /_bulk"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Write() sent %v, want %v", got, want)
+	}
+}
+
+func TestBulkWriter_BatchBytes(t *testing.T) {
+	c := newFakeCluster(t)
+	// Each action is 52 bytes, so a batch holds 2.
+	if _, err := write(t, c, []WriteOptionFn{WriteBatchBytes(110)}, orders(3)...); err != nil {
+		t.Fatalf("Write() failed: %v", err)
+	}
+	if got, want := len(c.requests), 2; got != want {
+		t.Errorf("Write() sent %v requests, want %v", got, want)
+	}
+}
+
+func TestBulkWriter_ContentIDs(t *testing.T) {
+	c := newFakeCluster(t)
+	d := Document{Source: []byte(`{"total": 1}`)}
+	if _, err := write(t, c, []WriteOptionFn{WriteContentIDs()}, d, d); err != nil {
+		t.Fatalf("Write() failed: %v", err)
+	}
+	// The ID is the hash of the compacted source, so rewrites replace the
+	// document.
+	id := "b7e28723407b7750fb0dc3b977699a5223a3b617d41c9a4feaeccbd31feadd06"
+	for _, d := range c.written {
+		if d.ID != id {
+			t.Errorf("Write() wrote ID %q, want %q", d.ID, id)
+		}
+	}
+}
+
+func TestBulkWriter_Retry(t *testing.T) {
+	c := newFakeCluster(t)
+	c.failures = 2
+	rejected := map[string]bool{"1": true}
+	c.status = func(d Document) int {
+		if rejected[d.ID] {
+			delete(rejected, d.ID)
+			return http.StatusTooManyRequests
+		}
+		return http.StatusCreated
+	}
+	if _, err := write(t, c, nil, orders(3)...); err != nil {
+		t.Fatalf("Write() failed: %v", err)
+	}
+	// Rejected requests are retried, and then only the rejected documents.
+	if got, want := len(c.requests), 4; got != want {
+		t.Errorf("Write() sent %v requests, want %v", got, want)
+	}
+	var ids []string
+	for _, d := range c.written {
+		ids = append(ids, d.ID)
+	}
+	if want := []string{"0", "2", "1"}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("Write() wrote %v, want %v", ids, want)
+	}
+}
+
+func TestBulkWriter_RetriesExhausted(t *testing.T) {
+	c := newFakeCluster(t)
+	c.failures = 3
+	if _, err := write(t, c, []WriteOptionFn{WriteMaxRetries(2)}, orders(1)...); err == nil {
+		t.Fatal("Write() succeeded, want error")
+	}
+	if got, want := len(c.requests), 3; got != want {
+		t.Errorf("Write() sent %v requests, want %v", got, want)
+	}
+}
+
+func TestBulkWriter_Failures(t *testing.T) {
+	c := newFakeCluster(t)
+	c.status = func(d Document) int {
+		if d.ID == "1" {
+			return http.StatusBadRequest
+		}
+		return http.StatusCreated
+	}
+	invalid := Document{ID: "invalid", Source: []byte(`{`)}
+	failed, err := write(t, c, nil, append(orders(3), invalid)...)
+	if err != nil {
+		t.Fatalf("Write() failed: %v", err)
+	}
+	// Documents rejected by the cluster, and documents with invalid sources,
+	// are output instead of retried.
+	if got, want := len(failed), 2; got != want {
+		t.Fatalf("Write() failed %v documents, want %v", got, want)
+	}
+	if got, want := failed[0].Document.ID, "invalid"; got != want {
+		t.Errorf("Write() failed %v, want %v", got, want)
+	}
+	if got, want := failed[1], (FailedDocument{Document: orders(3)[1], Status: http.StatusBadRequest, Error: "mapper_parsing_exception: failed to parse"}); !reflect.DeepEqual(got, want) {
+		t.Errorf("Write() failed %v, want %v", got, want)
+	}
+	if got, want := len(c.written), 2; got != want {
+		t.Errorf("Write() wrote %v documents, want %v", got, want)
+	}
+}
+
+func TestWriteFn_Failures(t *testing.T) {
+	c := newFakeCluster(t)
+	c.status = func(Document) int { return http.StatusBadRequest }
+	fn := &writeFn{Addresses: []string{c.URL}, Index: "orders", Options: newWriteOption(nil)}
+	fn.Setup()
+	ctx := context.Background()
+	if err := fn.ProcessElement(ctx, orders(1)[0]); err != nil {
+		t.Fatalf("ProcessElement() failed: %v", err)
+	}
+	if err := fn.FinishBundle(ctx); err == nil {
+		t.Error("FinishBundle() succeeded, want error")
+	}
+}
+
+func TestWrite_Options(t *testing.T) {
+	tests := []struct {
+		name string
+		fn   func()
+	}{
+		{"zero batch size", func() { WriteBatchSize(0) }},
+		{"zero batch bytes", func() { WriteBatchBytes(0) }},
+		{"negative max retries", func() { WriteMaxRetries(-1) }},
+		{"zero backoff", func() { WriteBackoff(0, time.Second) }},
+		{"max backoff below initial", func() { WriteBackoff(time.Second, time.Millisecond) }},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%v succeeded, want panic", test.name)
+				}
+			}()
+			test.fn()
+		})
+	}
+}
@@ -0,0 +1,36 @@
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements.  See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License.  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package fileio contains transforms for finding files, decoupled from
+// reading them, and for writing files. The match transforms expand globs,
+// given at construction time or arriving as data, into the metadata of the
+// matching files, which other transforms can then read in any format.
+package fileio
+
+import (
+	"reflect"
+
+	"github.com/apache/beam/sdks/v2/go/pkg/beam"
+)
+
+func init() {
+	beam.RegisterType(reflect.TypeOf((*FileMetadata)(nil)).Elem())
+}
+
+// FileMetadata is the metadata of a file matched by a glob.
+type FileMetadata struct {
+	Path string // Full name of the file, including the file system scheme.
+	Size int64  // Size of the file in bytes, when it was matched.
+}
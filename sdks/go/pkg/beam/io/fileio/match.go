@@ -0,0 +1,151 @@
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements.  See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License.  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fileio
+
+import (
+	"context"
+	"reflect"
+	"strings"
+
+	"github.com/apache/beam/sdks/v2/go/pkg/beam"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/internal/errors"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/io/filesystem"
+)
+
+func init() {
+	beam.RegisterType(reflect.TypeOf((*matchFn)(nil)).Elem())
+}
+
+// emptyTreatment controls how a glob that matches no files is handled.
+type emptyTreatment int
+
+const (
+	// emptyAllowIfWildcard allows globs with wildcards to match no files.
+	emptyAllowIfWildcard emptyTreatment = iota
+	// emptyAllow allows any glob to match no files.
+	emptyAllow
+	// emptyDisallow fails the pipeline if any glob matches no files.
+	emptyDisallow
+)
+
+// matchOption holds the options of the match transforms.
+type matchOption struct {
+	EmptyTreatment emptyTreatment
+}
+
+// MatchOptionFn is an option for Match and MatchAll.
+type MatchOptionFn func(*matchOption)
+
+// MatchEmptyAllowIfWildcard fails the pipeline if a glob without wildcards,
+// naming a single file, matches no files. Globs with wildcards may match no
+// files. This is the default.
+func MatchEmptyAllowIfWildcard() MatchOptionFn {
+	return func(o *matchOption) {
+		o.EmptyTreatment = emptyAllowIfWildcard
+	}
+}
+
+// MatchEmptyAllow allows any glob to match no files.
+func MatchEmptyAllow() MatchOptionFn {
+	return func(o *matchOption) {
+		o.EmptyTreatment = emptyAllow
+	}
+}
+
+// MatchEmptyDisallow fails the pipeline if any glob matches no files.
+func MatchEmptyDisallow() MatchOptionFn {
+	return func(o *matchOption) {
+		o.EmptyTreatment = emptyDisallow
+	}
+}
+
+// Match expands a glob and returns the metadata of the matching files as a
+// PCollection<FileMetadata>. By default, a glob without wildcards must match
+// a file, which can be changed with an option such as MatchEmptyAllow.
+func Match(s beam.Scope, glob string, opts ...MatchOptionFn) beam.PCollection {
+	s = s.Scope("fileio.Match")
+
+	filesystem.ValidateScheme(glob)
+	return match(s, beam.Create(s, glob), opts)
+}
+
+// MatchAll expands the globs given by the incoming PCollection<string>, and
+// returns the metadata of the files matching any of them as a single
+// PCollection<FileMetadata>. Empty globs are ignored. MatchAll accepts the
+// same options as Match.
+func MatchAll(s beam.Scope, col beam.PCollection, opts ...MatchOptionFn) beam.PCollection {
+	s = s.Scope("fileio.MatchAll")
+
+	return match(s, col, opts)
+}
+
+func match(s beam.Scope, col beam.PCollection, opts []MatchOptionFn) beam.PCollection {
+	var o matchOption
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return beam.ParDo(s, &matchFn{EmptyTreatment: o.EmptyTreatment}, col)
+}
+
+// hasWildcard returns whether the glob contains any wildcard characters.
+func hasWildcard(glob string) bool {
+	return strings.ContainsAny(glob, "*?[")
+}
+
+// matchFn expands a glob into the metadata of its matching files.
+type matchFn struct {
+	EmptyTreatment emptyTreatment `json:"empty_treatment"`
+}
+
+func (fn *matchFn) ProcessElement(ctx context.Context, glob string, emit func(FileMetadata)) error {
+	if strings.TrimSpace(glob) == "" {
+		return nil // ignore empty string elements here
+	}
+
+	fs, err := filesystem.New(ctx, glob)
+	if err != nil {
+		return err
+	}
+	defer fs.Close()
+
+	files, err := fs.List(ctx, glob)
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 && !fn.allowEmpty(glob) {
+		return errors.Errorf("no files matched glob %v", glob)
+	}
+	for _, filename := range files {
+		size, err := fs.Size(ctx, filename)
+		if err != nil {
+			return err
+		}
+		emit(FileMetadata{Path: filename, Size: size})
+	}
+	return nil
+}
+
+// allowEmpty returns whether the glob may match no files.
+func (fn *matchFn) allowEmpty(glob string) bool {
+	switch fn.EmptyTreatment {
+	case emptyAllow:
+		return true
+	case emptyDisallow:
+		return false
+	default:
+		return hasWildcard(glob)
+	}
+}
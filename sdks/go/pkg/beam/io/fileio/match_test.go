@@ -0,0 +1,87 @@
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements.  See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License.  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fileio
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/apache/beam/sdks/v2/go/pkg/beam"
+	_ "github.com/apache/beam/sdks/v2/go/pkg/beam/io/filesystem/local"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/testing/passert"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/testing/ptest"
+)
+
+func TestMatch(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, filepath.Join(dir, "a.txt"), "one\n")
+	writeTestFile(t, filepath.Join(dir, "b.txt"), "three\n")
+	writeTestFile(t, filepath.Join(dir, "c.csv"), "")
+
+	p, s := beam.NewPipelineWithRoot()
+	files := Match(s, filepath.Join(dir, "*.txt"))
+	passert.Equals(s, files,
+		FileMetadata{Path: filepath.Join(dir, "a.txt"), Size: 4},
+		FileMetadata{Path: filepath.Join(dir, "b.txt"), Size: 6})
+
+	ptest.RunAndValidate(t, p)
+}
+
+func TestMatchAll(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, filepath.Join(dir, "a.txt"), "one\n")
+	writeTestFile(t, filepath.Join(dir, "c.csv"), "")
+
+	p, s, globs := ptest.CreateList([]string{
+		filepath.Join(dir, "*.txt"),
+		filepath.Join(dir, "*.csv"),
+		filepath.Join(dir, "*.json"),
+		"",
+	})
+	files := MatchAll(s, globs)
+	passert.Equals(s, files,
+		FileMetadata{Path: filepath.Join(dir, "a.txt"), Size: 4},
+		FileMetadata{Path: filepath.Join(dir, "c.csv"), Size: 0})
+
+	ptest.RunAndValidate(t, p)
+}
+
+// TestMatchFn_Empty tests that globs matching no files fail or succeed
+// according to the empty match treatment.
+func TestMatchFn_Empty(t *testing.T) {
+	dir := t.TempDir()
+	wildcard := filepath.Join(dir, "*.txt")
+	single := filepath.Join(dir, "missing.txt")
+
+	tests := []struct {
+		treatment emptyTreatment
+		glob      string
+		wantErr   bool
+	}{
+		{treatment: emptyAllowIfWildcard, glob: wildcard, wantErr: false},
+		{treatment: emptyAllowIfWildcard, glob: single, wantErr: true},
+		{treatment: emptyAllow, glob: single, wantErr: false},
+		{treatment: emptyDisallow, glob: wildcard, wantErr: true},
+	}
+	for _, test := range tests {
+		fn := &matchFn{EmptyTreatment: test.treatment}
+		err := fn.ProcessElement(context.Background(), test.glob, func(FileMetadata) {})
+		if got := err != nil; got != test.wantErr {
+			t.Errorf("matchFn{%v}.ProcessElement(%v) returned error %v, want error: %v", test.treatment, test.glob, err, test.wantErr)
+		}
+	}
+}
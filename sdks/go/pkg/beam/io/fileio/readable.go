@@ -0,0 +1,217 @@
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements.  See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License.  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fileio
+
+import (
+	"context"
+	"io"
+	"reflect"
+
+	"github.com/apache/beam/sdks/v2/go/pkg/beam"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/internal/errors"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/io/filesystem"
+)
+
+func init() {
+	beam.RegisterType(reflect.TypeOf((*ReadableFile)(nil)).Elem())
+	beam.RegisterFunction(readMatchesFn)
+}
+
+// ReadMatches converts the PCollection<FileMetadata> output by the match
+// transforms into a PCollection<ReadableFile>, whose elements can be opened
+// and read by downstream DoFns in any format.
+//
+// Usage example:
+//
+//    files := fileio.ReadMatches(s, fileio.Match(s, "gs://bucket/*.zip"))
+//    beam.ParDo(s, func(ctx context.Context, f fileio.ReadableFile, emit func(string)) error {
+//        rd, err := f.OpenSeeker(ctx)
+//        ...
+//    }, files)
+func ReadMatches(s beam.Scope, col beam.PCollection) beam.PCollection {
+	s = s.Scope("fileio.ReadMatches")
+
+	return beam.ParDo(s, readMatchesFn, col)
+}
+
+func readMatchesFn(m FileMetadata) ReadableFile {
+	return ReadableFile{Metadata: m}
+}
+
+// ReadableFile is a handle to a matched file, which can be opened for reading
+// through the file system of its path.
+type ReadableFile struct {
+	Metadata FileMetadata
+}
+
+// Open opens the file for reading. The returned reader must be closed.
+func (f ReadableFile) Open(ctx context.Context) (io.ReadCloser, error) {
+	return f.openAt(ctx, 0)
+}
+
+// openAt opens the file for reading from the offset, which must be within
+// the file, with a ranged read of the file system.
+func (f ReadableFile) openAt(ctx context.Context, offset int64) (*fileReader, error) {
+	fs, err := filesystem.New(ctx, f.Metadata.Path)
+	if err != nil {
+		return nil, err
+	}
+	var rd io.ReadCloser
+	if offset > 0 {
+		rd, err = fs.(filesystem.RangeReader).OpenReadRange(ctx, f.Metadata.Path, offset, -1)
+	} else {
+		rd, err = fs.OpenRead(ctx, f.Metadata.Path)
+	}
+	if err != nil {
+		fs.Close()
+		return nil, err
+	}
+	return &fileReader{ReadCloser: rd, fs: fs}, nil
+}
+
+// OpenSeeker opens the file for reading with support for seeking, for formats
+// that need random access, such as zip. The returned reader must be closed.
+//
+// If the file system's reader can't seek, seeking is emulated. If the file
+// system can read part of a file, seeking reopens the file at the position,
+// unless it's shortly ahead. Otherwise, seeking forward discards data, and
+// seeking backward reopens the file. The end of the file is the size in the
+// file's metadata.
+func (f ReadableFile) OpenSeeker(ctx context.Context) (io.ReadSeekCloser, error) {
+	rd, err := f.openAt(ctx, 0)
+	if err != nil {
+		return nil, err
+	}
+	if rs, ok := rd.ReadCloser.(io.ReadSeeker); ok {
+		return &seekableFileReader{fileReader: rd, seeker: rs}, nil
+	}
+	_, ranged := rd.fs.(filesystem.RangeReader)
+	return &reopeningSeeker{ctx: ctx, file: f, rd: rd, ranged: ranged}, nil
+}
+
+// Read reads the whole file.
+func (f ReadableFile) Read(ctx context.Context) ([]byte, error) {
+	rd, err := f.Open(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer rd.Close()
+
+	return io.ReadAll(rd)
+}
+
+// ReadString reads the whole file as a string.
+func (f ReadableFile) ReadString(ctx context.Context) (string, error) {
+	data, err := f.Read(ctx)
+	return string(data), err
+}
+
+// fileReader reads a file, and closes the file system along with the file.
+type fileReader struct {
+	io.ReadCloser
+	fs filesystem.Interface
+}
+
+// Close closes the file and then the file system.
+func (r *fileReader) Close() error {
+	err := r.ReadCloser.Close()
+	if fsErr := r.fs.Close(); err == nil {
+		err = fsErr
+	}
+	return err
+}
+
+// seekableFileReader is a fileReader whose file can seek.
+type seekableFileReader struct {
+	*fileReader
+	seeker io.ReadSeeker
+}
+
+// Seek seeks within the file.
+func (r *seekableFileReader) Seek(offset int64, whence int) (int64, error) {
+	return r.seeker.Seek(offset, whence)
+}
+
+// maxDiscard is how far ahead a reopeningSeeker of a file system that can
+// read part of a file seeks by discarding data, rather than reopening the
+// file.
+const maxDiscard = 1 << 20
+
+// reopeningSeeker emulates seeking on a file whose reader can't seek, by
+// reopening the file at the position if its file system can read part of a
+// file, and otherwise by discarding data to seek forward and reopening the
+// file to seek backward.
+type reopeningSeeker struct {
+	ctx    context.Context
+	file   ReadableFile
+	rd     io.ReadCloser
+	pos    int64
+	ranged bool
+}
+
+// Read reads from the current position of the file.
+func (r *reopeningSeeker) Read(p []byte) (int, error) {
+	n, err := r.rd.Read(p)
+	r.pos += int64(n)
+	return n, err
+}
+
+// Seek moves the position of the file.
+func (r *reopeningSeeker) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.pos
+	case io.SeekEnd:
+		offset += r.file.Metadata.Size
+	default:
+		return r.pos, errors.Errorf("invalid whence %v seeking %v", whence, r.file.Metadata.Path)
+	}
+	if offset < 0 {
+		return r.pos, errors.Errorf("negative position %v seeking %v", offset, r.file.Metadata.Path)
+	}
+	if r.ranged && offset < r.file.Metadata.Size && (offset < r.pos || offset-r.pos > maxDiscard) {
+		rd, err := r.file.openAt(r.ctx, offset)
+		if err != nil {
+			return r.pos, err
+		}
+		r.rd.Close()
+		r.rd, r.pos = rd, offset
+		return r.pos, nil
+	}
+	if offset < r.pos {
+		rd, err := r.file.Open(r.ctx)
+		if err != nil {
+			return r.pos, err
+		}
+		r.rd.Close()
+		r.rd, r.pos = rd, 0
+	}
+	n, err := io.CopyN(io.Discard, r.rd, offset-r.pos)
+	r.pos += n
+	if err != nil && err != io.EOF {
+		return r.pos, err
+	}
+	// Seeking past the end of the file is allowed, and subsequent reads
+	// return io.EOF.
+	r.pos = offset
+	return r.pos, nil
+}
+
+// Close closes the file.
+func (r *reopeningSeeker) Close() error {
+	return r.rd.Close()
+}
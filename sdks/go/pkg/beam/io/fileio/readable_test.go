@@ -0,0 +1,159 @@
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements.  See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License.  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fileio
+
+import (
+	"context"
+	"io"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/apache/beam/sdks/v2/go/pkg/beam"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/io/filesystem"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/io/filesystem/memfs"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/testing/passert"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/testing/ptest"
+)
+
+func init() {
+	beam.RegisterFunction(readContentsFn)
+	filesystem.Register("rangefs", func(ctx context.Context) filesystem.Interface {
+		return rangeFS{memfs.New(ctx)}
+	})
+}
+
+// rangeOffsets are the offsets of the ranged reads of rangeFS.
+var rangeOffsets []int64
+
+// rangeFS is a file system of the memfs files, under the rangefs scheme,
+// which can read part of a file.
+type rangeFS struct {
+	filesystem.Interface
+}
+
+func (f rangeFS) OpenRead(ctx context.Context, filename string) (io.ReadCloser, error) {
+	return f.Interface.OpenRead(ctx, strings.Replace(filename, "rangefs://", "memfs://", 1))
+}
+
+func (f rangeFS) OpenReadRange(ctx context.Context, filename string, offset, length int64) (io.ReadCloser, error) {
+	rangeOffsets = append(rangeOffsets, offset)
+	rd, err := f.OpenRead(ctx, filename)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.CopyN(io.Discard, rd, offset); err != nil {
+		return nil, err
+	}
+	return rd, nil
+}
+
+func readContentsFn(ctx context.Context, f ReadableFile) (string, error) {
+	return f.ReadString(ctx)
+}
+
+func TestReadMatches(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, filepath.Join(dir, "a.txt"), "one")
+	writeTestFile(t, filepath.Join(dir, "b.txt"), "two")
+
+	p, s := beam.NewPipelineWithRoot()
+	files := ReadMatches(s, Match(s, filepath.Join(dir, "*.txt")))
+	contents := beam.ParDo(s, readContentsFn, files)
+	passert.Equals(s, contents, "one", "two")
+
+	ptest.RunAndValidate(t, p)
+}
+
+// TestReadableFile_OpenSeeker tests seeking on files whose file system reader
+// can seek, and on files where seeking is emulated.
+func TestReadableFile_OpenSeeker(t *testing.T) {
+	const contents = "0123456789"
+	local := filepath.Join(t.TempDir(), "digits.txt")
+	writeTestFile(t, local, contents)
+	memfs.Write("memfs://digits.txt", []byte(contents))
+
+	for _, path := range []string{local, "memfs://digits.txt"} {
+		f := ReadableFile{Metadata: FileMetadata{Path: path, Size: int64(len(contents))}}
+		rd, err := f.OpenSeeker(context.Background())
+		if err != nil {
+			t.Fatalf("OpenSeeker(%v) failed: %v", path, err)
+		}
+
+		steps := []struct {
+			offset int64
+			whence int
+			want   string
+		}{
+			{offset: 2, whence: io.SeekStart, want: "23"},
+			{offset: 3, whence: io.SeekCurrent, want: "78"},
+			{offset: 1, whence: io.SeekStart, want: "12"},
+			{offset: -3, whence: io.SeekEnd, want: "78"},
+			{offset: -8, whence: io.SeekCurrent, want: "12"},
+		}
+		for _, step := range steps {
+			if _, err := rd.Seek(step.offset, step.whence); err != nil {
+				t.Fatalf("%v: Seek(%v, %v) failed: %v", path, step.offset, step.whence, err)
+			}
+			buf := make([]byte, 2)
+			if _, err := io.ReadFull(rd, buf); err != nil {
+				t.Fatalf("%v: Read after Seek(%v, %v) failed: %v", path, step.offset, step.whence, err)
+			}
+			if got := string(buf); got != step.want {
+				t.Errorf("%v: Read after Seek(%v, %v) = %q, want %q", path, step.offset, step.whence, got, step.want)
+			}
+		}
+		if err := rd.Close(); err != nil {
+			t.Errorf("%v: Close failed: %v", path, err)
+		}
+	}
+}
+
+// TestReadableFile_OpenSeeker_Ranged tests seeking on files whose file
+// system can read part of a file.
+func TestReadableFile_OpenSeeker_Ranged(t *testing.T) {
+	contents := make([]byte, 3*maxDiscard)
+	for i := range contents {
+		contents[i] = byte(i % 251)
+	}
+	memfs.Write("memfs://ranged.bin", contents)
+	f := ReadableFile{Metadata: FileMetadata{Path: "rangefs://ranged.bin", Size: int64(len(contents))}}
+	rd, err := f.OpenSeeker(context.Background())
+	if err != nil {
+		t.Fatalf("OpenSeeker() failed: %v", err)
+	}
+	defer rd.Close()
+
+	rangeOffsets = nil
+	// Seeking shortly ahead discards data, and seeking far ahead or backward
+	// reads from the position.
+	for _, offset := range []int64{2, 2 * maxDiscard, 1} {
+		if _, err := rd.Seek(offset, io.SeekStart); err != nil {
+			t.Fatalf("Seek(%v) failed: %v", offset, err)
+		}
+		buf := make([]byte, 2)
+		if _, err := io.ReadFull(rd, buf); err != nil {
+			t.Fatalf("Read after Seek(%v) failed: %v", offset, err)
+		}
+		if want := contents[offset : offset+2]; !reflect.DeepEqual(buf, want) {
+			t.Errorf("Read after Seek(%v) = %v, want %v", offset, buf, want)
+		}
+	}
+	if want := []int64{2 * maxDiscard, 1}; !reflect.DeepEqual(rangeOffsets, want) {
+		t.Errorf("Seek() read ranges from %v, want %v", rangeOffsets, want)
+	}
+}
@@ -0,0 +1,300 @@
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements.  See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License.  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fileio
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/apache/beam/sdks/v2/go/pkg/beam"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/core/graph/mtime"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/core/sdf"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/internal/errors"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/io/filesystem"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/log"
+)
+
+func init() {
+	beam.RegisterType(reflect.TypeOf((*watchFn)(nil)).Elem())
+	beam.RegisterType(reflect.TypeOf((*watchRestriction)(nil)).Elem())
+	beam.RegisterType(reflect.TypeOf((*watchTracker)(nil)))
+	beam.RegisterType(reflect.TypeOf((*watchWatermarkEstimator)(nil)))
+}
+
+// MatchContinuously continuously watches a glob and returns the metadata of
+// every file that matches it as a PCollection<FileMetadata>. The glob is
+// polled every interval until end, and each file is output once, by the first
+// poll that matches it. A zero end watches the glob forever.
+//
+// The metadata of a file has the time of the poll that matched it as its
+// event time, and the output watermark advances to the time of the latest poll
+// that matched new files, so files should be complete when they first match
+// the glob. Between polls, the watch checkpoints and asks the runner to resume
+// it at the next poll, so its output is unbounded. Runners that can't resume
+// checkpoints, such as the direct runner, only output the files matched by the
+// first poll.
+func MatchContinuously(s beam.Scope, glob string, interval time.Duration, end time.Time) beam.PCollection {
+	if interval <= 0 {
+		panic(fmt.Sprintf("fileio.MatchContinuously interval must be positive. Got: %v", interval))
+	}
+	s = s.Scope("fileio.MatchContinuously")
+
+	filesystem.ValidateScheme(glob)
+	fn := &watchFn{Interval: interval, End: mtime.MaxTimestamp.Milliseconds()}
+	if !end.IsZero() {
+		fn.End = mtime.FromTime(end).Milliseconds()
+	}
+	return beam.ParDo(s, fn, beam.Create(s, glob))
+}
+
+// watchRestriction is the restriction of a watchFn. It holds the files emitted
+// so far, so that a file is only emitted by the first poll that matches it,
+// even across checkpoints.
+type watchRestriction struct {
+	LastPoll int64    // Time of the latest claimed poll, in milliseconds.
+	End      int64    // Time at or after which polls can't be claimed, in milliseconds.
+	Seen     []string // Files already emitted, in sorted order.
+}
+
+// seen returns whether the file has already been emitted.
+func (r watchRestriction) seen(filename string) bool {
+	i := sort.SearchStrings(r.Seen, filename)
+	return i < len(r.Seen) && r.Seen[i] == filename
+}
+
+// watchPoll is the position claimed by a watchFn for each poll of its glob.
+type watchPoll struct {
+	Time  int64    // Time of the poll, in milliseconds.
+	Files []string // New files matched by the poll.
+}
+
+// watchTracker tracks a watchRestriction. Each claim is a poll, which must
+// happen after the previous one and before the end of the restriction. The
+// tracker can only be split by checkpointing, since the polls have to happen
+// in order.
+type watchTracker struct {
+	rest    watchRestriction
+	stopped bool // Tracks whether TryClaim has indicated to stop processing.
+	err     error
+}
+
+// newWatchTracker is a constructor for a watchTracker given a restriction.
+func newWatchTracker(rest watchRestriction) *watchTracker {
+	return &watchTracker{rest: rest}
+}
+
+// TryClaim accepts a watchPoll and claims it if the poll is within the
+// restriction, adding the poll's files to the files seen. Claiming a poll at
+// or past the end of the restriction signals that the restriction is done.
+func (t *watchTracker) TryClaim(rawPos interface{}) bool {
+	if t.stopped {
+		t.err = errors.New("cannot claim work after restriction tracker returns false")
+		return false
+	}
+	pos := rawPos.(watchPoll)
+	if pos.Time <= t.rest.LastPoll {
+		t.stopped = true
+		t.err = errors.Errorf("cannot claim a poll at %v, at or before the previous poll at %v", pos.Time, t.rest.LastPoll)
+		return false
+	}
+	if pos.Time >= t.rest.End {
+		t.stopped = true
+		return false
+	}
+	t.rest.LastPoll = pos.Time
+	seen := make([]string, 0, len(t.rest.Seen)+len(pos.Files))
+	seen = append(seen, t.rest.Seen...)
+	seen = append(seen, pos.Files...)
+	sort.Strings(seen)
+	t.rest.Seen = seen
+	return true
+}
+
+// GetError returns the error that caused the tracker to stop, if there is one.
+func (t *watchTracker) GetError() error {
+	return t.err
+}
+
+// TrySplit only splits when checkpointing, with a fraction of zero. The
+// primary then ends after the latest claimed poll, and the residual holds the
+// remaining polls and the files seen so far.
+func (t *watchTracker) TrySplit(fraction float64) (primary, residual interface{}, err error) {
+	if t.stopped || t.IsDone() || fraction > 0 {
+		return t.rest, nil, nil
+	}
+	residual = watchRestriction{LastPoll: t.rest.LastPoll, End: t.rest.End, Seen: t.rest.Seen}
+	t.rest.End = t.rest.LastPoll + 1
+	return t.rest, residual, nil
+}
+
+// GetProgress reports the number of files seen as the work done. Since the
+// number of files left to match is unknown, any remaining polls count as one
+// unit of remaining work.
+func (t *watchTracker) GetProgress() (done, remaining float64) {
+	done = float64(len(t.rest.Seen))
+	if !t.IsDone() {
+		remaining = 1
+	}
+	return done, remaining
+}
+
+// IsDone returns true if no more polls can be claimed within the restriction.
+func (t *watchTracker) IsDone() bool {
+	return t.err == nil && (t.stopped || t.rest.LastPoll+1 >= t.rest.End)
+}
+
+// GetRestriction returns a copy of the tracker's underlying watchRestriction.
+func (t *watchTracker) GetRestriction() interface{} {
+	return t.rest
+}
+
+// IsBounded returns false if the restriction watches the glob forever.
+func (t *watchTracker) IsBounded() bool {
+	return t.rest.End < mtime.MaxTimestamp.Milliseconds()
+}
+
+// watchWatermarkEstimator is a watermark estimator that holds the output
+// watermark of a watchFn at the time of the latest poll that emitted files.
+// Since files are emitted with the time of their poll, this is the maximum
+// observed timestamp.
+type watchWatermarkEstimator struct {
+	state int64 // Watermark, in milliseconds.
+}
+
+// CurrentWatermark returns the time of the latest poll that emitted files.
+func (e *watchWatermarkEstimator) CurrentWatermark() time.Time {
+	return mtime.FromMilliseconds(e.state).ToTime()
+}
+
+// ObserveTimestamp moves the watermark forward to t. It is invoked by the SDK
+// after each emit.
+func (e *watchWatermarkEstimator) ObserveTimestamp(t time.Time) {
+	e.state = mtime.Max(mtime.FromMilliseconds(e.state), mtime.FromTime(t)).Milliseconds()
+}
+
+// watchFn is a splittable DoFn that polls a glob every Interval until End and
+// emits the files that weren't matched by a previous poll, with the time of
+// the poll as their event time.
+type watchFn struct {
+	Interval time.Duration
+	End      int64 // Time at or after which the glob isn't polled, in milliseconds.
+}
+
+// CreateInitialRestriction creates a restriction that has seen no files and
+// ends at End.
+func (fn *watchFn) CreateInitialRestriction(_ string) watchRestriction {
+	return watchRestriction{LastPoll: mtime.MinTimestamp.Milliseconds(), End: fn.End}
+}
+
+// SplitRestriction does not split the restriction, since the polls must
+// happen in order.
+func (fn *watchFn) SplitRestriction(_ string, rest watchRestriction) []watchRestriction {
+	return []watchRestriction{rest}
+}
+
+// RestrictionSize outputs a constant size, since the number of files the glob
+// will match is unknown.
+func (fn *watchFn) RestrictionSize(_ string, _ watchRestriction) float64 {
+	return 1
+}
+
+// CreateTracker creates a watchTracker for the restriction.
+func (fn *watchFn) CreateTracker(rest watchRestriction) *sdf.LockRTracker {
+	return sdf.NewLockRTracker(newWatchTracker(rest))
+}
+
+// InitialWatermarkEstimatorState starts the watermark at the latest poll of
+// the restriction.
+func (fn *watchFn) InitialWatermarkEstimatorState(_ beam.EventTime, rest watchRestriction, _ string) int64 {
+	return rest.LastPoll
+}
+
+// CreateWatermarkEstimator creates a watermark estimator that advances the
+// watermark to the latest poll that emitted files.
+func (fn *watchFn) CreateWatermarkEstimator(state int64) *watchWatermarkEstimator {
+	return &watchWatermarkEstimator{state: state}
+}
+
+// WatermarkEstimatorState returns the state of the watermark estimator.
+func (fn *watchFn) WatermarkEstimatorState(e *watchWatermarkEstimator) int64 {
+	return e.state
+}
+
+// nextPoll returns the time of the poll following the latest poll of the
+// restriction, in milliseconds. The first poll happens immediately.
+func (fn *watchFn) nextPoll(rest watchRestriction) mtime.Time {
+	now := mtime.Now()
+	if rest.LastPoll <= mtime.MinTimestamp.Milliseconds() {
+		return now
+	}
+	return mtime.Max(now, mtime.FromMilliseconds(rest.LastPoll).Add(fn.Interval))
+}
+
+// ProcessElement polls the glob, emits the metadata of the files not seen by
+// a previous poll, and then checkpoints until the next poll is due.
+func (fn *watchFn) ProcessElement(ctx context.Context, rt *sdf.LockRTracker, glob string, emit func(beam.EventTime, FileMetadata)) (sdf.ProcessContinuation, error) {
+	rest := rt.GetRestriction().(watchRestriction)
+	poll := fn.nextPoll(rest)
+	if wait := time.Until(poll.ToTime()); wait > 0 {
+		return sdf.ResumeProcessingIn(wait), nil
+	}
+	if poll.Milliseconds() >= rest.End {
+		rt.TryClaim(watchPoll{Time: poll.Milliseconds()})
+		return sdf.StopProcessing(), nil
+	}
+
+	fs, err := filesystem.New(ctx, glob)
+	if err != nil {
+		return sdf.StopProcessing(), err
+	}
+	defer fs.Close()
+
+	files, err := fs.List(ctx, glob)
+	if err != nil {
+		return sdf.StopProcessing(), err
+	}
+	var added []string
+	var metadata []FileMetadata
+	for _, filename := range files {
+		if rest.seen(filename) {
+			continue
+		}
+		size, err := fs.Size(ctx, filename)
+		if err != nil {
+			return sdf.StopProcessing(), err
+		}
+		added = append(added, filename)
+		metadata = append(metadata, FileMetadata{Path: filename, Size: size})
+	}
+	if !rt.TryClaim(watchPoll{Time: poll.Milliseconds(), Files: added}) {
+		return sdf.StopProcessing(), nil
+	}
+	if len(added) > 0 {
+		log.Infof(ctx, "Matched %v new files for %v", len(added), glob)
+	}
+	for _, m := range metadata {
+		emit(poll, m)
+	}
+
+	if poll.Add(fn.Interval).Milliseconds() >= rest.End {
+		rt.TryClaim(watchPoll{Time: rest.End})
+		return sdf.StopProcessing(), nil
+	}
+	return sdf.ResumeProcessingIn(fn.Interval), nil
+}
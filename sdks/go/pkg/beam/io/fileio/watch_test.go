@@ -0,0 +1,115 @@
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements.  See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License.  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fileio
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/apache/beam/sdks/v2/go/pkg/beam"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/core/graph/mtime"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/testing/passert"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/testing/ptest"
+)
+
+// TestMatchContinuously tests that MatchContinuously outputs the files matched
+// by its first poll.
+func TestMatchContinuously(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, filepath.Join(dir, "a.txt"), "one\ntwo\n")
+	writeTestFile(t, filepath.Join(dir, "b.txt"), "three\n")
+
+	p, s := beam.NewPipelineWithRoot()
+	files := MatchContinuously(s, filepath.Join(dir, "*.txt"), time.Minute, time.Now().Add(time.Hour))
+	passert.Equals(s, files,
+		FileMetadata{Path: filepath.Join(dir, "a.txt"), Size: 8},
+		FileMetadata{Path: filepath.Join(dir, "b.txt"), Size: 6})
+
+	ptest.RunAndValidate(t, p)
+}
+
+// TestWatchFn tests that each poll only emits the files not matched by a
+// previous poll, across checkpoints, and that the watch stops at its end.
+func TestWatchFn(t *testing.T) {
+	dir := t.TempDir()
+	glob := filepath.Join(dir, "*.txt")
+	writeTestFile(t, filepath.Join(dir, "a.txt"), "a\n")
+
+	fn := &watchFn{Interval: time.Millisecond, End: mtime.MaxTimestamp.Milliseconds()}
+	rest := fn.CreateInitialRestriction(glob)
+	we := fn.CreateWatermarkEstimator(fn.InitialWatermarkEstimatorState(mtime.MinTimestamp, rest, glob))
+
+	// poll runs a single poll and checkpoints, returning the emitted files.
+	poll := func() []string {
+		t.Helper()
+		var got []string
+		rt := fn.CreateTracker(rest)
+		cont, err := fn.ProcessElement(context.Background(), rt, glob, func(et beam.EventTime, m FileMetadata) {
+			we.ObserveTimestamp(et.ToTime())
+			got = append(got, filepath.Base(m.Path))
+		})
+		if err != nil {
+			t.Fatalf("watchFn.ProcessElement failed: %v", err)
+		}
+		if !cont.ShouldResume() {
+			t.Fatalf("watchFn did not resume for the next poll")
+		}
+		_, residual, err := rt.TrySplit(0)
+		if err != nil || residual == nil {
+			t.Fatalf("watchFn failed to checkpoint: residual %v, error %v", residual, err)
+		}
+		if !rt.IsDone() {
+			t.Fatalf("watchFn primary restriction is not done after checkpointing")
+		}
+		rest = residual.(watchRestriction)
+		time.Sleep(2 * fn.Interval)
+		return got
+	}
+
+	if got := poll(); len(got) != 1 || got[0] != "a.txt" {
+		t.Errorf("watchFn first poll emitted wrong files: got: %v, want: [a.txt]", got)
+	}
+	if got := poll(); len(got) != 0 {
+		t.Errorf("watchFn emitted already seen files: got: %v, want: []", got)
+	}
+	writeTestFile(t, filepath.Join(dir, "b.txt"), "b\n")
+	if got := poll(); len(got) != 1 || got[0] != "b.txt" {
+		t.Errorf("watchFn third poll emitted wrong files: got: %v, want: [b.txt]", got)
+	}
+	if got := mtime.FromTime(we.CurrentWatermark()).Milliseconds(); got != rest.LastPoll {
+		t.Errorf("watchFn watermark not at latest poll: got: %v, want: %v", got, rest.LastPoll)
+	}
+
+	rest.End = rest.LastPoll + 1
+	rt := fn.CreateTracker(rest)
+	cont, err := fn.ProcessElement(context.Background(), rt, glob, func(beam.EventTime, FileMetadata) {})
+	if err != nil {
+		t.Fatalf("watchFn.ProcessElement failed: %v", err)
+	}
+	if cont.ShouldResume() || !rt.IsDone() {
+		t.Errorf("watchFn did not stop after its end: resume %v, done %v", cont.ShouldResume(), rt.IsDone())
+	}
+}
+
+func writeTestFile(t *testing.T, filename, contents string) {
+	t.Helper()
+	if err := os.WriteFile(filename, []byte(contents), 0644); err != nil {
+		t.Fatalf("Failed to write file %v, err: %v", filename, err)
+	}
+}
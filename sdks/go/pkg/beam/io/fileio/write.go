@@ -0,0 +1,225 @@
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements.  See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License.  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fileio
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"math/rand"
+	"reflect"
+	"strings"
+
+	"github.com/apache/beam/sdks/v2/go/pkg/beam"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/core/funcx"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/core/util/reflectx"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/io/filesystem"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/log"
+)
+
+func init() {
+	beam.RegisterType(reflect.TypeOf((*destinationFn)(nil)).Elem())
+	beam.RegisterType(reflect.TypeOf((*writeDestinationFn)(nil)).Elem())
+}
+
+var destinationSig = &funcx.Signature{Args: []reflect.Type{reflectx.String}, Return: []reflect.Type{reflectx.String}} // string -> string
+
+// DefaultShardTemplate is the shard template used to name shards, unless
+// another is set with WriteShardTemplate. For example, the second of ten
+// shards of "out" is named "out-00001-of-00010".
+const DefaultShardTemplate = "-SSSSS-of-NNNNN"
+
+// ShardName returns the name of the shard with the given index, as the
+// prefix, followed by the expanded template, followed by the suffix. In the
+// template, each run of 'S' is replaced by the shard's index and each run of
+// 'N' by the number of shards, zero-padded to the length of the run.
+func ShardName(prefix, template, suffix string, shard, numShards int) string {
+	var b strings.Builder
+	b.WriteString(prefix)
+	for i := 0; i < len(template); {
+		c := template[i]
+		if c != 'S' && c != 'N' {
+			b.WriteByte(c)
+			i++
+			continue
+		}
+		j := i
+		for j < len(template) && template[j] == c {
+			j++
+		}
+		n := shard
+		if c == 'N' {
+			n = numShards
+		}
+		fmt.Fprintf(&b, "%0*d", j-i, n)
+		i = j
+	}
+	b.WriteString(suffix)
+	return b.String()
+}
+
+// writeOption holds the options of WriteDynamic.
+type writeOption struct {
+	NumShards     int
+	ShardTemplate string
+	Suffix        string
+	Header        string
+}
+
+// WriteOptionFn is an option for WriteDynamic.
+type WriteOptionFn func(*writeOption)
+
+// WriteNumShards writes the lines of each destination to n files, or shards.
+// The default is a single shard per destination.
+func WriteNumShards(n int) WriteOptionFn {
+	if n < 1 {
+		panic(fmt.Sprintf("fileio.WriteNumShards number of shards must be positive. Got: %v", n))
+	}
+	return func(o *writeOption) {
+		o.NumShards = n
+	}
+}
+
+// WriteShardTemplate sets the template naming each shard, which follows the
+// destination and precedes the suffix. See ShardName for how the template is
+// expanded. The default template is DefaultShardTemplate.
+func WriteShardTemplate(template string) WriteOptionFn {
+	return func(o *writeOption) {
+		o.ShardTemplate = template
+	}
+}
+
+// WriteSuffix sets the suffix of each shard's name, such as ".txt".
+func WriteSuffix(suffix string) WriteOptionFn {
+	return func(o *writeOption) {
+		o.Suffix = suffix
+	}
+}
+
+// WriteHeader writes the header as the first line of every file. An empty
+// header writes no header line.
+func WriteHeader(header string) WriteOptionFn {
+	return func(o *writeOption) {
+		o.Header = header
+	}
+}
+
+// WriteDynamic writes a PCollection<string> as lines to files chosen per
+// element by the given destination function, which must be of the form:
+// string -> string. The destination of a line is the prefix of the files it
+// is written to, such as a directory per date or per tenant. Each
+// destination's lines are written to its shards, named by ShardName, and
+// shards that receive no lines are not written. For example:
+//
+//    fileio.WriteDynamic(s, events, func(line string) string {
+//        return "gs://bucket/events/" + line[:10] + "/part"
+//    }, fileio.WriteNumShards(3), fileio.WriteSuffix(".json"))
+//
+// Here, each event is written to one of three shards in the directory of its
+// date, such as "gs://bucket/events/2022-05-01/part-00002-of-00003.json".
+func WriteDynamic(s beam.Scope, col beam.PCollection, fn interface{}, opts ...WriteOptionFn) {
+	s = s.Scope("fileio.WriteDynamic")
+
+	funcx.MustSatisfy(fn, destinationSig)
+	o := writeOption{NumShards: 1, ShardTemplate: DefaultShardTemplate}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	// As in textio.Write, the lines of each file are grouped by key so that
+	// every file is written by a single invocation.
+	keyed := beam.ParDo(s, &destinationFn{
+		Destination:   beam.EncodedFunc{Fn: reflectx.MakeFunc(fn)},
+		NumShards:     o.NumShards,
+		ShardTemplate: o.ShardTemplate,
+		Suffix:        o.Suffix,
+	}, col)
+	grouped := beam.GroupByKey(s, keyed)
+	beam.ParDo0(s, &writeDestinationFn{Header: o.Header}, grouped)
+}
+
+// destinationFn keys each line by the name of the shard it is written to,
+// within the line's destination. Shards of a destination are assigned in
+// round robin order, starting from a random shard.
+type destinationFn struct {
+	// Destination is the encoded destination function.
+	Destination   beam.EncodedFunc `json:"destination"`
+	NumShards     int              `json:"num_shards"`
+	ShardTemplate string           `json:"shard_template"`
+	Suffix        string           `json:"suffix"`
+
+	fn   reflectx.Func1x1
+	next map[string]int // Next shard of each destination seen in the bundle.
+}
+
+func (fn *destinationFn) Setup() {
+	fn.fn = reflectx.ToFunc1x1(fn.Destination.Fn)
+}
+
+func (fn *destinationFn) StartBundle(_ func(string, string)) {
+	fn.next = make(map[string]int)
+}
+
+func (fn *destinationFn) ProcessElement(line string, emit func(string, string)) {
+	dest := fn.fn.Call1x1(line).(string)
+	shard, ok := fn.next[dest]
+	if !ok {
+		shard = rand.Intn(fn.NumShards)
+	}
+	fn.next[dest] = (shard + 1) % fn.NumShards
+	emit(ShardName(dest, fn.ShardTemplate, fn.Suffix, shard, fn.NumShards), line)
+}
+
+// writeDestinationFn writes the lines of a shard to the file named by its key.
+type writeDestinationFn struct {
+	Header string `json:"header"`
+}
+
+func (fn *writeDestinationFn) ProcessElement(ctx context.Context, filename string, lines func(*string) bool) error {
+	fs, err := filesystem.New(ctx, filename)
+	if err != nil {
+		return err
+	}
+	defer fs.Close()
+
+	fd, err := fs.OpenWrite(ctx, filename)
+	if err != nil {
+		return err
+	}
+	buf := bufio.NewWriterSize(fd, 1<<20) // use 1MB buffer
+
+	log.Infof(ctx, "Writing to %v", filename)
+
+	if fn.Header != "" {
+		if _, err := buf.WriteString(fn.Header + "\n"); err != nil {
+			return err
+		}
+	}
+	var line string
+	for lines(&line) {
+		if _, err := buf.WriteString(line); err != nil {
+			return err
+		}
+		if err := buf.WriteByte('\n'); err != nil {
+			return err
+		}
+	}
+
+	if err := buf.Flush(); err != nil {
+		return err
+	}
+	return fd.Close()
+}
@@ -0,0 +1,95 @@
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements.  See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License.  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fileio
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/apache/beam/sdks/v2/go/pkg/beam"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/testing/ptest"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestShardName(t *testing.T) {
+	tests := []struct {
+		template string
+		suffix   string
+		want     string
+	}{
+		{template: DefaultShardTemplate, want: "out-00002-of-00010"},
+		{template: DefaultShardTemplate, suffix: ".txt", want: "out-00002-of-00010.txt"},
+		{template: "_S_N", want: "out_2_10"},
+		{template: "/part-SSS", suffix: ".csv", want: "out/part-002.csv"},
+		{template: "", want: "out"},
+	}
+	for _, test := range tests {
+		if got := ShardName("out", test.template, test.suffix, 2, 10); got != test.want {
+			t.Errorf("ShardName(out, %q, %q, 2, 10) = %q, want %q", test.template, test.suffix, got, test.want)
+		}
+	}
+}
+
+var testWriteDir string
+
+func tenantDestination(line string) string {
+	return filepath.Join(testWriteDir, strings.SplitN(line, ",", 2)[0], "part")
+}
+
+func init() {
+	beam.RegisterFunction(tenantDestination)
+}
+
+// TestWriteDynamic tests that WriteDynamic writes each line to a shard of its
+// destination.
+func TestWriteDynamic(t *testing.T) {
+	testWriteDir = t.TempDir()
+	p, s := beam.NewPipelineWithRoot()
+	lines := beam.Create(s, "a,1", "b,2", "a,3", "c,4")
+	WriteDynamic(s, lines, tenantDestination, WriteNumShards(2), WriteShardTemplate("-S"), WriteSuffix(".csv"), WriteHeader("tenant,value"))
+
+	ptest.RunAndValidate(t, p)
+
+	for tenant, want := range map[string][]string{
+		"a": {"a,1", "a,3"},
+		"b": {"b,2"},
+		"c": {"c,4"},
+	} {
+		var got []string
+		files, err := filepath.Glob(filepath.Join(testWriteDir, tenant, "part-?.csv"))
+		if err != nil || len(files) == 0 {
+			t.Fatalf("WriteDynamic() wrote no files for %v: %v", tenant, err)
+		}
+		for _, file := range files {
+			contents, err := os.ReadFile(file)
+			if err != nil {
+				t.Fatalf("Failed to read %v: %v", file, err)
+			}
+			fileLines := strings.Split(strings.TrimSuffix(string(contents), "\n"), "\n")
+			if fileLines[0] != "tenant,value" {
+				t.Errorf("WriteDynamic() file %v starts with %q, want header", file, fileLines[0])
+			}
+			got = append(got, fileLines[1:]...)
+		}
+		sort.Strings(got)
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Errorf("WriteDynamic() wrote the wrong lines for %v (-want, +got):\n%v", tenant, diff)
+		}
+	}
+}
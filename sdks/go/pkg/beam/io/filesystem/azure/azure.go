@@ -0,0 +1,561 @@
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements.  See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License.  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package azure contains an Azure Blob Storage implementation of the Beam
+// file system, for paths of the form "azfs://account/container/blob".
+//
+// Requests are authorized with the default Azure credentials, such as the
+// environment, the managed identity of the host and the Azure CLI, or with
+// a SAS token or a given managed identity instead. The credentials, the
+// endpoints, accounts with a hierarchical namespace (ADLS Gen2), and the
+// blocks of uploads are configured with the azure_* flags, which are loaded
+// from the pipeline options on workers.
+//
+// The file system is configurable with filesystem.Configure: the endpoint of
+// the config replaces the Blob Storage endpoint, its credentials provider
+// provides an azcore.TokenCredential or a SAS token string, and its retry
+// policy replaces the default retries of requests.
+package azure
+
+import (
+	"context"
+	"flag"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/internal/errors"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/io/filesystem"
+)
+
+const (
+	// serviceVersion is the version of the storage REST API of requests to
+	// the Data Lake Storage endpoint, which is the version of azblob.
+	serviceVersion = "2020-10-02"
+	// storageScope is the scope of tokens of Data Lake Storage requests.
+	storageScope = "https://storage.azure.com/.default"
+	// maxReadRetries is the number of times reads of blobs reopen them after
+	// failing.
+	maxReadRetries = 3
+	// maxCopyBackoff is the maximum time between checks of pending copies.
+	maxCopyBackoff = 5 * time.Second
+)
+
+var (
+	sasToken = flag.String("azure_sas_token", "",
+		"SAS token that authorizes requests, instead of the default Azure credentials.")
+	managedIdentity = flag.Bool("azure_managed_identity", false,
+		"Whether requests are authorized with the managed identity of the host, instead of the default Azure credentials.")
+	managedIdentityClientID = flag.String("azure_managed_identity_client_id", "",
+		"Client ID of the user-assigned managed identity that authorizes requests, instead of the default Azure credentials.")
+	blobEndpoint = flag.String("azure_blob_endpoint", "",
+		"URL of the Blob Storage endpoint of all accounts, instead of https://<account>.blob.core.windows.net, such as http://127.0.0.1:10000/devstoreaccount1 for Azurite.")
+	dfsEndpoint = flag.String("azure_dfs_endpoint", "",
+		"URL of the Data Lake Storage endpoint of all accounts, instead of https://<account>.dfs.core.windows.net.")
+	hierarchicalNamespace = flag.Bool("azure_hierarchical_namespace", false,
+		"Whether accounts have a hierarchical namespace (ADLS Gen2), so that directories aren't listed and blobs are renamed atomically.")
+	blockSize = flag.Int("azure_block_size", 4<<20,
+		"Size in bytes of the blocks of uploads of written blobs, between 1 MiB and 4000 MiB.")
+	uploadConcurrency = flag.Int("azure_upload_concurrency", 4,
+		"Number of blocks of each written blob that are uploaded concurrently.")
+)
+
+func init() {
+	filesystem.RegisterConfigurable("azfs", NewWithConfig)
+}
+
+// options holds the configuration of the file system.
+type options struct {
+	SASToken                string
+	ManagedIdentity         bool
+	ManagedIdentityClientID string
+	BlobEndpoint            string
+	DFSEndpoint             string
+	HierarchicalNamespace   bool
+	BlockSize               int
+	UploadConcurrency       int
+	// Retry is the retry policy of requests, or the zero policy for the
+	// default.
+	Retry policy.RetryOptions
+}
+
+// flagOptions returns the options set with the flags.
+func flagOptions() (options, error) {
+	o := options{
+		SASToken:                strings.TrimPrefix(*sasToken, "?"),
+		ManagedIdentity:         *managedIdentity || *managedIdentityClientID != "",
+		ManagedIdentityClientID: *managedIdentityClientID,
+		BlobEndpoint:            strings.TrimSuffix(*blobEndpoint, "/"),
+		DFSEndpoint:             strings.TrimSuffix(*dfsEndpoint, "/"),
+		HierarchicalNamespace:   *hierarchicalNamespace,
+		BlockSize:               *blockSize,
+		UploadConcurrency:       *uploadConcurrency,
+	}
+	if o.SASToken != "" && o.ManagedIdentity {
+		return o, errors.New("--azure_sas_token and --azure_managed_identity are mutually exclusive")
+	}
+	if o.BlockSize < 1<<20 || o.BlockSize > azblob.BlockBlobMaxStageBlockBytes {
+		return o, errors.Errorf("invalid --azure_block_size %v, want between %v and %v", o.BlockSize, 1<<20, azblob.BlockBlobMaxStageBlockBytes)
+	}
+	if o.UploadConcurrency < 1 {
+		return o, errors.Errorf("invalid --azure_upload_concurrency %v, want at least 1", o.UploadConcurrency)
+	}
+	return o, nil
+}
+
+type fs struct {
+	// cred authorizes requests, unless they're authorized with the SAS
+	// token.
+	cred azcore.TokenCredential
+	opts options
+	// dfs is the pipeline of Data Lake Storage requests.
+	dfs runtime.Pipeline
+}
+
+// New creates a new Azure Blob Storage filesystem, configured with the
+// azure_* flags.
+func New(ctx context.Context) filesystem.Interface {
+	fs, err := NewWithConfig(ctx, filesystem.Config{})
+	if err != nil {
+		panic(err)
+	}
+	return fs
+}
+
+// NewWithConfig creates a new Azure Blob Storage filesystem, configured with
+// the azure_* flags and the given config.
+func NewWithConfig(ctx context.Context, cfg filesystem.Config) (filesystem.Interface, error) {
+	if err := filesystem.LoadFlags("azure_"); err != nil {
+		return nil, err
+	}
+	opts, err := flagOptions()
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Endpoint != "" {
+		opts.BlobEndpoint = strings.TrimSuffix(cfg.Endpoint, "/")
+	}
+	if p := cfg.Retry; p != nil {
+		opts.Retry = policy.RetryOptions{RetryDelay: p.InitialBackoff, MaxRetryDelay: p.MaxBackoff}
+		switch {
+		case p.MaxAttempts == 1:
+			// Zero retries are the default, and negative retries disable
+			// them.
+			opts.Retry.MaxRetries = -1
+		case p.MaxAttempts > 1:
+			opts.Retry.MaxRetries = int32(p.MaxAttempts - 1)
+		}
+	}
+	creds, err := cfg.LoadCredentials(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var cred azcore.TokenCredential
+	switch creds := creds.(type) {
+	case nil:
+		if cred, err = credential(opts); err != nil {
+			return nil, errors.Wrap(err, "failed to create Azure credentials")
+		}
+	case azcore.TokenCredential:
+		cred, opts.SASToken = creds, ""
+	case string:
+		opts.SASToken = strings.TrimPrefix(creds, "?")
+	default:
+		return nil, errors.Errorf("Azure credentials must be an azcore.TokenCredential or a SAS token string, got %T", creds)
+	}
+	return newFS(cred, opts), nil
+}
+
+// credential returns the credential that authorizes requests, or nil if
+// they're authorized with the SAS token.
+func credential(opts options) (azcore.TokenCredential, error) {
+	switch {
+	case opts.SASToken != "":
+		return nil, nil
+	case opts.ManagedIdentity:
+		var miOpts *azidentity.ManagedIdentityCredentialOptions
+		if opts.ManagedIdentityClientID != "" {
+			miOpts = &azidentity.ManagedIdentityCredentialOptions{ID: azidentity.ClientID(opts.ManagedIdentityClientID)}
+		}
+		cred, err := azidentity.NewManagedIdentityCredential(miOpts)
+		if err != nil {
+			return nil, err
+		}
+		return cred, nil
+	default:
+		cred, err := azidentity.NewDefaultAzureCredential(nil)
+		if err != nil {
+			return nil, err
+		}
+		return cred, nil
+	}
+}
+
+func newFS(cred azcore.TokenCredential, opts options) *fs {
+	var plOpts runtime.PipelineOptions
+	if cred != nil {
+		plOpts.PerRetry = []policy.Policy{runtime.NewBearerTokenPolicy(cred, []string{storageScope}, nil)}
+	}
+	return &fs{
+		cred: cred,
+		opts: opts,
+		dfs:  runtime.NewPipeline("azure", "", plOpts, &policy.ClientOptions{Retry: opts.Retry}),
+	}
+}
+
+// parseBlob parses a path of the form "azfs://account/container/blob" into
+// its account, container and blob. The path isn't parsed as a URL, since
+// globs may contain '?'.
+func parseBlob(path string) (account, container, blob string, err error) {
+	if !strings.HasPrefix(path, "azfs://") {
+		return "", "", "", errors.Errorf("blob %s must have 'azfs' scheme", path)
+	}
+	parts := strings.SplitN(strings.TrimPrefix(path, "azfs://"), "/", 3)
+	if len(parts) < 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", "", errors.Errorf("blob %s must have account and container", path)
+	}
+	if len(parts) == 3 {
+		blob = parts[2]
+	}
+	return parts[0], parts[1], blob, nil
+}
+
+// containerClient returns a client of the container of the account.
+func (f *fs) containerClient(account, container string) (*azblob.ContainerClient, error) {
+	endpoint := f.opts.BlobEndpoint
+	if endpoint == "" {
+		endpoint = "https://" + account + ".blob.core.windows.net"
+	}
+	u := endpoint + "/" + url.PathEscape(container)
+	clientOpts := &azblob.ClientOptions{Retry: f.opts.Retry}
+	if f.cred == nil {
+		if f.opts.SASToken != "" {
+			u += "?" + f.opts.SASToken
+		}
+		return azblob.NewContainerClientWithNoCredential(u, clientOpts)
+	}
+	return azblob.NewContainerClient(u, f.cred, clientOpts)
+}
+
+// blobClient returns a client of the blob at the path.
+func (f *fs) blobClient(path string) (*azblob.BlockBlobClient, error) {
+	account, container, blob, err := parseBlob(path)
+	if err != nil {
+		return nil, err
+	}
+	if blob == "" {
+		return nil, errors.Errorf("blob %s must have a name", path)
+	}
+	c, err := f.containerClient(account, container)
+	if err != nil {
+		return nil, err
+	}
+	return c.NewBlockBlobClient(blob)
+}
+
+func (f *fs) Close() error {
+	return nil
+}
+
+func (f *fs) List(ctx context.Context, glob string) ([]string, error) {
+	account, container, blob, err := parseBlob(glob)
+	if err != nil {
+		return nil, err
+	}
+
+	index := strings.IndexAny(blob, "*?[")
+	if index < 0 {
+		// Single blob.
+		return []string{glob}, nil
+	}
+	c, err := f.containerClient(account, container)
+	if err != nil {
+		return nil, err
+	}
+	// Globs are handled by listing the blobs with the prefix before the
+	// first special character, and matching them here.
+	listOpts := &azblob.ContainerListBlobsFlatOptions{Prefix: to.Ptr(blob[:index])}
+	if f.opts.HierarchicalNamespace {
+		listOpts.Include = []azblob.ListBlobsIncludeItem{azblob.ListBlobsIncludeItemMetadata}
+	}
+	var ret []string
+	p := c.ListBlobsFlat(listOpts)
+	for p.NextPage(ctx) {
+		for _, item := range p.PageResponse().Segment.BlobItems {
+			// Directories of hierarchical namespaces are listed as empty
+			// blobs with metadata that marks them.
+			if f.opts.HierarchicalNamespace && isDirectory(item.Metadata) {
+				continue
+			}
+			name := *item.Name
+			match, err := filepath.Match(blob, name)
+			if err != nil {
+				return nil, err
+			}
+			if match {
+				ret = append(ret, "azfs://"+account+"/"+container+"/"+name)
+			}
+		}
+	}
+	if err := p.Err(); err != nil {
+		return nil, errors.Wrapf(err, "listing blobs of %v", glob)
+	}
+	return ret, nil
+}
+
+// isDirectory returns whether the metadata of a blob marks a directory.
+func isDirectory(metadata map[string]*string) bool {
+	for k, v := range metadata {
+		if strings.EqualFold(k, "hdi_isfolder") && v != nil && strings.EqualFold(*v, "true") {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *fs) OpenRead(ctx context.Context, filename string) (io.ReadCloser, error) {
+	return f.OpenReadRange(ctx, filename, 0, -1)
+}
+
+// OpenReadRange opens a blob for reading length bytes from the offset, or
+// the rest of the blob if length is negative.
+func (f *fs) OpenReadRange(ctx context.Context, filename string, offset, length int64) (io.ReadCloser, error) {
+	c, err := f.blobClient(filename)
+	if err != nil {
+		return nil, err
+	}
+	if length == 0 {
+		return io.NopCloser(strings.NewReader("")), nil
+	}
+
+	downloadOpts := &azblob.BlobDownloadOptions{Offset: to.Ptr(offset)}
+	if length > 0 {
+		downloadOpts.Count = to.Ptr(length)
+	}
+	resp, err := c.Download(ctx, downloadOpts)
+	if err != nil {
+		return nil, errors.Wrapf(err, "reading %v", filename)
+	}
+	return resp.Body(&azblob.RetryReaderOptions{MaxRetryRequests: maxReadRetries}), nil
+}
+
+// OpenWrite opens a blob for writing. The blob is uploaded as it's written,
+// in blocks of the block size, and is committed when the writer is closed.
+func (f *fs) OpenWrite(ctx context.Context, filename string) (io.WriteCloser, error) {
+	c, err := f.blobClient(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	pr, pw := io.Pipe()
+	w := &writer{pw: pw, done: make(chan error, 1)}
+	go func() {
+		_, err := c.UploadStream(ctx, pr, azblob.UploadStreamOptions{
+			BufferSize: f.opts.BlockSize,
+			MaxBuffers: f.opts.UploadConcurrency,
+		})
+		if err != nil {
+			err = errors.Wrapf(err, "writing %v", filename)
+		}
+		// Writes fail once the upload has failed.
+		pr.CloseWithError(err)
+		w.done <- err
+	}()
+	return w, nil
+}
+
+// writer writes to an upload through a pipe.
+type writer struct {
+	pw   *io.PipeWriter
+	done chan error
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	return w.pw.Write(p)
+}
+
+// Close ends the blob, and waits for its upload to complete.
+func (w *writer) Close() error {
+	w.pw.Close()
+	return <-w.done
+}
+
+func (f *fs) Size(ctx context.Context, filename string) (int64, error) {
+	c, err := f.blobClient(filename)
+	if err != nil {
+		return -1, err
+	}
+
+	resp, err := c.GetProperties(ctx, nil)
+	if err != nil {
+		return -1, errors.Wrapf(err, "getting size of %v", filename)
+	}
+	if resp.ContentLength == nil {
+		return -1, errors.Errorf("getting size of %v: no content length", filename)
+	}
+	return *resp.ContentLength, nil
+}
+
+// Remove the named file from the filesystem.
+func (f *fs) Remove(ctx context.Context, filename string) error {
+	c, err := f.blobClient(filename)
+	if err != nil {
+		return err
+	}
+
+	_, err = c.Delete(ctx, nil)
+	return err
+}
+
+// Copy copies from srcpath to the dstpath, and waits for the copy to
+// complete.
+func (f *fs) Copy(ctx context.Context, srcpath, dstpath string) error {
+	src, err := f.blobClient(srcpath)
+	if err != nil {
+		return err
+	}
+	dst, err := f.blobClient(dstpath)
+	if err != nil {
+		return err
+	}
+
+	resp, err := dst.StartCopyFromURL(ctx, src.URL(), nil)
+	if err != nil {
+		return errors.Wrapf(err, "copying %v to %v", srcpath, dstpath)
+	}
+	status := resp.CopyStatus
+	backoff := 100 * time.Millisecond
+	for status != nil && *status == azblob.CopyStatusTypePending {
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		if backoff *= 2; backoff > maxCopyBackoff {
+			backoff = maxCopyBackoff
+		}
+		props, err := dst.GetProperties(ctx, nil)
+		if err != nil {
+			return errors.Wrapf(err, "copying %v to %v", srcpath, dstpath)
+		}
+		status = props.CopyStatus
+	}
+	if status != nil && *status != azblob.CopyStatusTypeSuccess {
+		return errors.Errorf("copying %v to %v ended with status %v", srcpath, dstpath, *status)
+	}
+	return nil
+}
+
+// Rename renames srcpath to dstpath. Blobs of accounts with a hierarchical
+// namespace are renamed atomically within their account, and other blobs
+// are copied and removed.
+func (f *fs) Rename(ctx context.Context, srcpath, dstpath string) error {
+	srcAccount, srcContainer, src, err := parseBlob(srcpath)
+	if err != nil {
+		return err
+	}
+	account, container, dst, err := parseBlob(dstpath)
+	if err != nil {
+		return err
+	}
+	if !f.opts.HierarchicalNamespace || srcAccount != account {
+		if err := f.Copy(ctx, srcpath, dstpath); err != nil {
+			return err
+		}
+		return f.Remove(ctx, srcpath)
+	}
+
+	// Blobs are only renamed to existing directories.
+	if dir := path.Dir(dst); dir != "." {
+		if err := f.createDirectory(ctx, account, container, dir); err != nil {
+			return errors.Wrapf(err, "renaming %v to %v", srcpath, dstpath)
+		}
+	}
+	source := "/" + pathEscape(srcContainer+"/"+src)
+	if f.opts.SASToken != "" {
+		source += "?" + f.opts.SASToken
+	}
+	resp, err := f.doDFS(ctx, account, container, dst, "", map[string]string{"x-ms-rename-source": source})
+	if err != nil {
+		return errors.Wrapf(err, "renaming %v to %v", srcpath, dstpath)
+	}
+	if !runtime.HasStatusCode(resp, http.StatusCreated) {
+		return errors.Wrapf(runtime.NewResponseError(resp), "renaming %v to %v", srcpath, dstpath)
+	}
+	return nil
+}
+
+// createDirectory creates a directory and its parents, unless it exists.
+func (f *fs) createDirectory(ctx context.Context, account, container, dir string) error {
+	resp, err := f.doDFS(ctx, account, container, dir, "resource=directory", map[string]string{"If-None-Match": "*"})
+	if err != nil {
+		return err
+	}
+	if !runtime.HasStatusCode(resp, http.StatusCreated, http.StatusConflict) {
+		return runtime.NewResponseError(resp)
+	}
+	return nil
+}
+
+// doDFS sends a Data Lake Storage request to create the path of the
+// container, with the query and headers.
+func (f *fs) doDFS(ctx context.Context, account, container, name, query string, headers map[string]string) (*http.Response, error) {
+	endpoint := f.opts.DFSEndpoint
+	if endpoint == "" {
+		endpoint = "https://" + account + ".dfs.core.windows.net"
+	}
+	if f.opts.SASToken != "" {
+		if query != "" {
+			query += "&"
+		}
+		query += f.opts.SASToken
+	}
+	u := endpoint + "/" + pathEscape(container+"/"+name)
+	if query != "" {
+		u += "?" + query
+	}
+	req, err := runtime.NewRequest(ctx, http.MethodPut, u)
+	if err != nil {
+		return nil, err
+	}
+	req.Raw().Header.Set("x-ms-version", serviceVersion)
+	for k, v := range headers {
+		req.Raw().Header.Set(k, v)
+	}
+	return f.dfs.Do(req)
+}
+
+// pathEscape escapes the segments of a path.
+func pathEscape(p string) string {
+	return (&url.URL{Path: p}).EscapedPath()
+}
+
+// Compile time check for interface implementations.
+var (
+	_ filesystem.Remover     = ((*fs)(nil))
+	_ filesystem.Copier      = ((*fs)(nil))
+	_ filesystem.Renamer     = ((*fs)(nil))
+	_ filesystem.RangeReader = ((*fs)(nil))
+)
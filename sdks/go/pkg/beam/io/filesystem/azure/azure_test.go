@@ -0,0 +1,495 @@
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements.  See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License.  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package azure
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/io/filesystem"
+)
+
+func TestAzure_FilesystemNew(t *testing.T) {
+	ctx := context.Background()
+	path := "azfs://account/container/"
+	c, err := filesystem.New(ctx, path)
+	if err != nil {
+		t.Errorf("filesystem.New(ctx, %q) = %v, want nil", path, err)
+	}
+	if _, ok := c.(*fs); !ok {
+		t.Errorf("filesystem.New(ctx, %q) type = %T, want *azure.fs", path, c)
+	}
+	if err := c.Close(); err != nil {
+		t.Errorf("c.Close() = %v, want nil", err)
+	}
+}
+
+func TestParseBlob(t *testing.T) {
+	tests := []struct {
+		path                     string
+		account, container, blob string
+		wantErr                  bool
+	}{
+		{"azfs://a/c/b", "a", "c", "b", false},
+		{"azfs://a/c/d/b?.txt", "a", "c", "d/b?.txt", false},
+		{"azfs://a/c", "a", "c", "", false},
+		{"azfs://a", "", "", "", true},
+		{"azfs:///c/b", "", "", "", true},
+		{"gs://a/c/b", "", "", "", true},
+	}
+	for _, test := range tests {
+		account, container, blob, err := parseBlob(test.path)
+		if (err != nil) != test.wantErr {
+			t.Errorf("parseBlob(%q) = %v, want error %v", test.path, err, test.wantErr)
+			continue
+		}
+		if account != test.account || container != test.container || blob != test.blob {
+			t.Errorf("parseBlob(%q) = %q, %q, %q, want %q, %q, %q", test.path, account, container, blob, test.account, test.container, test.blob)
+		}
+	}
+}
+
+// fakeServer serves the blobs of the container "c" on the Blob Storage
+// endpoint, and its directories on the Data Lake Storage endpoint, like an
+// account with a hierarchical namespace. Requests must be authorized with
+// the SAS token "sig=s" or the bearer token "t". Copies are pending until
+// the properties of their blob are read.
+type fakeServer struct {
+	mu     sync.Mutex
+	blobs  map[string][]byte
+	dirs   map[string]bool
+	blocks map[string][]byte
+	// ops are the blob operations, other than reads, of the server.
+	ops []string
+}
+
+func newFakeServer(blobs map[string]string) *fakeServer {
+	s := &fakeServer{blobs: make(map[string][]byte), dirs: make(map[string]bool), blocks: make(map[string][]byte)}
+	for name, data := range blobs {
+		s.blobs[name] = []byte(data)
+		s.addParents(name)
+	}
+	return s
+}
+
+// addParents adds the parent directories of the path.
+func (s *fakeServer) addParents(name string) {
+	for dir := path.Dir(name); dir != "."; dir = path.Dir(dir) {
+		s.dirs[dir] = true
+	}
+}
+
+func (s *fakeServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	q := r.URL.Query()
+	if q.Get("sig") != "s" && r.Header.Get("Authorization") != "Bearer t" {
+		storageError(w, http.StatusForbidden, "AuthenticationFailed")
+		return
+	}
+	if r.URL.Path != "/c" && !strings.HasPrefix(r.URL.Path, "/c/") {
+		storageError(w, http.StatusNotFound, "ContainerNotFound")
+		return
+	}
+	name := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/c"), "/")
+	switch {
+	case r.Method == http.MethodGet && q.Get("comp") == "list":
+		s.list(w, q.Get("prefix"))
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		data, ok := s.blobs[name]
+		if !ok {
+			storageError(w, http.StatusNotFound, "BlobNotFound")
+			return
+		}
+		if r.Method == http.MethodHead && len(s.ops) > 0 && s.ops[len(s.ops)-1] == "copy "+name {
+			w.Header().Set("x-ms-copy-status", "success")
+		}
+		r.Header.Set("Range", r.Header.Get("x-ms-range"))
+		http.ServeContent(w, r, name, time.Time{}, bytes.NewReader(data))
+	case r.Method == http.MethodPut && q.Get("comp") == "block":
+		s.blocks[q.Get("blockid")], _ = io.ReadAll(r.Body)
+		w.WriteHeader(http.StatusCreated)
+	case r.Method == http.MethodPut && q.Get("comp") == "blocklist":
+		var list struct {
+			Latest []string
+		}
+		if err := xml.NewDecoder(r.Body).Decode(&list); err != nil {
+			storageError(w, http.StatusBadRequest, "InvalidXmlDocument")
+			return
+		}
+		var data []byte
+		for _, id := range list.Latest {
+			data = append(data, s.blocks[id]...)
+		}
+		s.blobs[name] = data
+		s.addParents(name)
+		s.ops = append(s.ops, fmt.Sprintf("commit %v blocks of %v", len(list.Latest), name))
+		w.WriteHeader(http.StatusCreated)
+	case r.Method == http.MethodPut && r.Header.Get("x-ms-copy-source") != "":
+		src, ok := s.source(r.Header.Get("x-ms-copy-source"))
+		if !ok {
+			storageError(w, http.StatusNotFound, "CannotVerifyCopySource")
+			return
+		}
+		s.blobs[name] = s.blobs[src]
+		s.addParents(name)
+		s.ops = append(s.ops, "copy "+name)
+		w.Header().Set("x-ms-copy-status", "pending")
+		w.WriteHeader(http.StatusAccepted)
+	case r.Method == http.MethodPut && r.Header.Get("x-ms-rename-source") != "":
+		src, ok := s.source(r.Header.Get("x-ms-rename-source"))
+		if !ok {
+			storageError(w, http.StatusNotFound, "SourcePathNotFound")
+			return
+		}
+		if dir := path.Dir(name); dir != "." && !s.dirs[dir] {
+			storageError(w, http.StatusNotFound, "RenameDestinationParentPathNotFound")
+			return
+		}
+		s.blobs[name] = s.blobs[src]
+		delete(s.blobs, src)
+		s.ops = append(s.ops, "rename "+name)
+		w.WriteHeader(http.StatusCreated)
+	case r.Method == http.MethodPut && q.Get("resource") == "directory":
+		if s.dirs[name] {
+			storageError(w, http.StatusConflict, "PathAlreadyExists")
+			return
+		}
+		s.dirs[name] = true
+		s.addParents(name)
+		w.WriteHeader(http.StatusCreated)
+	case r.Method == http.MethodDelete:
+		if _, ok := s.blobs[name]; !ok {
+			storageError(w, http.StatusNotFound, "BlobNotFound")
+			return
+		}
+		delete(s.blobs, name)
+		s.ops = append(s.ops, "delete "+name)
+		w.WriteHeader(http.StatusAccepted)
+	default:
+		storageError(w, http.StatusBadRequest, "UnsupportedHttpVerb")
+	}
+}
+
+// source returns the blob of the source of a copy or rename, which must be
+// authorized like requests.
+func (s *fakeServer) source(source string) (string, bool) {
+	u, err := url.Parse(source)
+	if err != nil || (u.Query().Get("sig") != "s" && u.Host != "") {
+		return "", false
+	}
+	name := strings.TrimPrefix(u.Path, "/c/")
+	_, ok := s.blobs[name]
+	return name, ok
+}
+
+// list lists the blobs and directories with the prefix, marking the
+// directories with metadata.
+func (s *fakeServer) list(w http.ResponseWriter, prefix string) {
+	var names []string
+	for name := range s.blobs {
+		names = append(names, name)
+	}
+	for dir := range s.dirs {
+		names = append(names, dir)
+	}
+	sort.Strings(names)
+	var b strings.Builder
+	b.WriteString(`<?xml version="1.0" encoding="utf-8"?><EnumerationResults ContainerName="c"><Blobs>`)
+	for _, name := range names {
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		if s.dirs[name] {
+			fmt.Fprintf(&b, "<Blob><Name>%v</Name><Properties><Content-Length>0</Content-Length></Properties><Metadata><hdi_isfolder>true</hdi_isfolder></Metadata></Blob>", name)
+		} else {
+			fmt.Fprintf(&b, "<Blob><Name>%v</Name><Properties><Content-Length>%v</Content-Length></Properties></Blob>", name, len(s.blobs[name]))
+		}
+	}
+	b.WriteString("</Blobs><NextMarker/></EnumerationResults>")
+	w.Header().Set("Content-Type", "application/xml")
+	io.WriteString(w, b.String())
+}
+
+func storageError(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("x-ms-error-code", code)
+	w.WriteHeader(status)
+}
+
+// newTestFS returns a file system of the server, authorized with the SAS
+// token.
+func newTestFS(t *testing.T, srv *fakeServer, opts options) *fs {
+	t.Helper()
+	ts := httptest.NewServer(srv)
+	t.Cleanup(ts.Close)
+	opts.SASToken = "sig=s"
+	opts.BlobEndpoint, opts.DFSEndpoint = ts.URL, ts.URL
+	if opts.BlockSize == 0 {
+		opts.BlockSize, opts.UploadConcurrency = 1<<20, 2
+	}
+	return newFS(nil, opts)
+}
+
+func TestAzure_List(t *testing.T) {
+	srv := newFakeServer(map[string]string{"a/1.txt": "1", "a/2.txt": "2", "a/b/3.txt": "3", "b/4.txt": "4"})
+	ctx := context.Background()
+	tests := []struct {
+		glob string
+		want []string
+	}{
+		{"azfs://acc/c/a/*", []string{"azfs://acc/c/a/1.txt", "azfs://acc/c/a/2.txt"}},
+		{"azfs://acc/c/a/?.txt", []string{"azfs://acc/c/a/1.txt", "azfs://acc/c/a/2.txt"}},
+		{"azfs://acc/c/*/*/*", []string{"azfs://acc/c/a/b/3.txt"}},
+		{"azfs://acc/c/a/1.txt", []string{"azfs://acc/c/a/1.txt"}},
+	}
+	for _, hns := range []bool{false, true} {
+		f := newTestFS(t, srv, options{HierarchicalNamespace: hns})
+		for _, test := range tests {
+			got, err := f.List(ctx, test.glob)
+			if err != nil {
+				t.Fatalf("List(%q) failed: %v", test.glob, err)
+			}
+			// Directories are listed as blobs, unless the namespace is
+			// hierarchical.
+			want := test.want
+			if !hns && test.glob == "azfs://acc/c/a/*" {
+				want = []string{"azfs://acc/c/a/1.txt", "azfs://acc/c/a/2.txt", "azfs://acc/c/a/b"}
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("List(%q) with hierarchical namespace %v = %v, want %v", test.glob, hns, got, want)
+			}
+		}
+	}
+}
+
+func TestAzure_ReadWrite(t *testing.T) {
+	srv := newFakeServer(nil)
+	f := newTestFS(t, srv, options{})
+	ctx := context.Background()
+	data := bytes.Repeat([]byte("0123456789"), 250_000)
+
+	w, err := f.OpenWrite(ctx, "azfs://acc/c/d/blob")
+	if err != nil {
+		t.Fatalf("OpenWrite() failed: %v", err)
+	}
+	if _, err := w.Write(data); err != nil {
+		t.Fatalf("Write() failed: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close() failed: %v", err)
+	}
+	// Blobs are uploaded in blocks of the block size.
+	if got, want := srv.ops, []string{"commit 3 blocks of d/blob"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("OpenWrite() made %v, want %v", got, want)
+	}
+
+	if size, err := f.Size(ctx, "azfs://acc/c/d/blob"); err != nil || size != int64(len(data)) {
+		t.Errorf("Size() = %v, %v, want %v", size, err, len(data))
+	}
+	tests := []struct {
+		offset, length int64
+		want           []byte
+	}{
+		{0, -1, data},
+		{4, -1, data[4:]},
+		{4, 3, data[4:7]},
+		{4, 0, nil},
+	}
+	for _, test := range tests {
+		rd, err := f.OpenReadRange(ctx, "azfs://acc/c/d/blob", test.offset, test.length)
+		if err != nil {
+			t.Fatalf("OpenReadRange(%v, %v) failed: %v", test.offset, test.length, err)
+		}
+		got, err := io.ReadAll(rd)
+		rd.Close()
+		if err != nil || !bytes.Equal(got, test.want) {
+			t.Errorf("OpenReadRange(%v, %v) read %v bytes, %v, want %v bytes", test.offset, test.length, len(got), err, len(test.want))
+		}
+	}
+
+	if _, err := f.Size(ctx, "azfs://acc/c/missing"); err == nil {
+		t.Error("Size() of a missing blob succeeded, want error")
+	}
+}
+
+func TestAzure_CopyRemove(t *testing.T) {
+	srv := newFakeServer(map[string]string{"src": "data"})
+	f := newTestFS(t, srv, options{})
+	ctx := context.Background()
+
+	if err := f.Copy(ctx, "azfs://acc/c/src", "azfs://acc/c/dst"); err != nil {
+		t.Fatalf("Copy() failed: %v", err)
+	}
+	if err := f.Remove(ctx, "azfs://acc/c/src"); err != nil {
+		t.Fatalf("Remove() failed: %v", err)
+	}
+	if got, want := srv.ops, []string{"copy dst", "delete src"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Copy() and Remove() made %v, want %v", got, want)
+	}
+	if got := string(srv.blobs["dst"]); got != "data" {
+		t.Errorf("Copy() copied %q, want %q", got, "data")
+	}
+}
+
+func TestAzure_Rename(t *testing.T) {
+	tests := []struct {
+		name string
+		hns  bool
+		want []string
+	}{
+		{"flat namespace", false, []string{"copy new/dir/dst", "delete old/src"}},
+		{"hierarchical namespace", true, []string{"rename new/dir/dst"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			srv := newFakeServer(map[string]string{"old/src": "data"})
+			f := newTestFS(t, srv, options{HierarchicalNamespace: test.hns})
+			if err := f.Rename(context.Background(), "azfs://acc/c/old/src", "azfs://acc/c/new/dir/dst"); err != nil {
+				t.Fatalf("Rename() failed: %v", err)
+			}
+			if !reflect.DeepEqual(srv.ops, test.want) {
+				t.Errorf("Rename() made %v, want %v", srv.ops, test.want)
+			}
+			if _, ok := srv.blobs["old/src"]; ok {
+				t.Error("Rename() kept the source blob")
+			}
+			if got := string(srv.blobs["new/dir/dst"]); got != "data" {
+				t.Errorf("Rename() renamed to %q, want %q", got, "data")
+			}
+		})
+	}
+}
+
+// fakeCredential is a credential of the token "t".
+type fakeCredential struct{}
+
+func (fakeCredential) GetToken(context.Context, policy.TokenRequestOptions) (azcore.AccessToken, error) {
+	return azcore.AccessToken{Token: "t", ExpiresOn: time.Now().Add(time.Hour)}, nil
+}
+
+func TestAzure_Credential(t *testing.T) {
+	srv := newFakeServer(map[string]string{"a/src": "data"})
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+	f := newFS(fakeCredential{}, options{BlobEndpoint: ts.URL, DFSEndpoint: ts.URL, HierarchicalNamespace: true})
+	ctx := context.Background()
+
+	if size, err := f.Size(ctx, "azfs://acc/c/a/src"); err != nil || size != 4 {
+		t.Errorf("Size() = %v, %v, want 4", size, err)
+	}
+	if err := f.Rename(ctx, "azfs://acc/c/a/src", "azfs://acc/c/b/dst"); err != nil {
+		t.Errorf("Rename() failed: %v", err)
+	}
+}
+
+func init() {
+	filesystem.RegisterCredentialsProvider("azuresas", func(context.Context, []string) (interface{}, error) {
+		return "?sig=s", nil
+	})
+	filesystem.RegisterCredentialsProvider("azuretoken", func(context.Context, []string) (interface{}, error) {
+		return fakeCredential{}, nil
+	})
+}
+
+func TestNewWithConfig(t *testing.T) {
+	srv := newFakeServer(map[string]string{"blob": "data"})
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+	ctx := context.Background()
+
+	for _, creds := range []string{"azuresas", "azuretoken"} {
+		cfg := filesystem.Config{Endpoint: ts.URL + "/", Credentials: creds, Retry: &filesystem.RetryPolicy{MaxAttempts: 1}}
+		f, err := NewWithConfig(ctx, cfg)
+		if err != nil {
+			t.Fatalf("NewWithConfig() with %v failed: %v", creds, err)
+		}
+		if size, err := f.Size(ctx, "azfs://acc/c/blob"); err != nil || size != 4 {
+			t.Errorf("Size() with %v = %v, %v, want 4", creds, size, err)
+		}
+		if got := f.(*fs).opts.Retry.MaxRetries; got != -1 {
+			t.Errorf("NewWithConfig() with a single attempt has %v retries, want -1", got)
+		}
+	}
+}
+
+func TestFlagOptions(t *testing.T) {
+	defaults := options{BlockSize: 4 << 20, UploadConcurrency: 4}
+	tests := []struct {
+		name    string
+		flags   map[string]string
+		want    options
+		wantErr bool
+	}{
+		{"defaults", nil, defaults, false},
+		{"SAS token", map[string]string{"azure_sas_token": "?sv=1&sig=s"}, options{SASToken: "sv=1&sig=s"}, false},
+		{"managed identity", map[string]string{"azure_managed_identity": "true"}, options{ManagedIdentity: true}, false},
+		{"user-assigned managed identity", map[string]string{"azure_managed_identity_client_id": "id"}, options{ManagedIdentity: true, ManagedIdentityClientID: "id"}, false},
+		{"endpoints", map[string]string{"azure_blob_endpoint": "http://b/", "azure_dfs_endpoint": "http://d"}, options{BlobEndpoint: "http://b", DFSEndpoint: "http://d"}, false},
+		{"SAS token and managed identity", map[string]string{"azure_sas_token": "sig=s", "azure_managed_identity": "true"}, options{}, true},
+		{"small block size", map[string]string{"azure_block_size": "1024"}, options{}, true},
+		{"zero concurrency", map[string]string{"azure_upload_concurrency": "0"}, options{}, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			defer resetFlags(t)
+			for k, v := range test.flags {
+				if err := flag.Set(k, v); err != nil {
+					t.Fatalf("setting --%v: %v", k, err)
+				}
+			}
+			got, err := flagOptions()
+			if (err != nil) != test.wantErr {
+				t.Fatalf("flagOptions() = %v, want error %v", err, test.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			want := test.want
+			want.BlockSize, want.UploadConcurrency = defaults.BlockSize, defaults.UploadConcurrency
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("flagOptions() = %+v, want %+v", got, want)
+			}
+		})
+	}
+}
+
+// resetFlags resets the azure_* flags to their defaults.
+func resetFlags(t *testing.T) {
+	flag.VisitAll(func(f *flag.Flag) {
+		if strings.HasPrefix(f.Name, "azure_") {
+			if err := f.Value.Set(f.DefValue); err != nil {
+				t.Errorf("resetting --%v: %v", f.Name, err)
+			}
+		}
+	})
+}
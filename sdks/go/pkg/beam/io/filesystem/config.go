@@ -0,0 +1,193 @@
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements.  See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License.  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filesystem
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/core/runtime"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/internal/errors"
+)
+
+// Config is the configuration of the file systems of a scheme, such as the
+// endpoint, credentials and retries of their storage service. Configs are
+// stored in the pipeline options, so that file systems on workers are
+// created with the configuration of the launching program, instead of
+// relying on the environment of the workers only. For example:
+//
+//	filesystem.RegisterCredentialsProvider("vault", readVaultSecret)
+//	...
+//	err := filesystem.Configure("s3", filesystem.Config{
+//	    Endpoint:        "http://minio:9000",
+//	    Credentials:     "vault",
+//	    CredentialsArgs: []string{"secret/minio"},
+//	    Retry:           &filesystem.RetryPolicy{MaxAttempts: 5},
+//	})
+type Config struct {
+	// Endpoint is the URL of the storage service, instead of its default.
+	Endpoint string `json:"endpoint,omitempty"`
+	// Credentials is the name of the registered CredentialsProvider of the
+	// credentials of requests, instead of the default credentials of the
+	// environment.
+	Credentials string `json:"credentials,omitempty"`
+	// CredentialsArgs are the arguments of the credentials provider, such as
+	// the name of a secret.
+	CredentialsArgs []string `json:"credentialsArgs,omitempty"`
+	// Retry is the retry policy of requests, instead of the default of the
+	// file system.
+	Retry *RetryPolicy `json:"retry,omitempty"`
+}
+
+// RetryPolicy is the policy of the retries of failed requests. Zero fields
+// are the defaults of the file system, and file systems document the fields
+// they don't support.
+type RetryPolicy struct {
+	// MaxAttempts is the maximum number of attempts of a request, including
+	// its first attempt, so that 1 disables retries.
+	MaxAttempts int `json:"maxAttempts,omitempty"`
+	// InitialBackoff is the backoff before the first retry.
+	InitialBackoff time.Duration `json:"initialBackoff,omitempty"`
+	// MaxBackoff is the maximum backoff between retries.
+	MaxBackoff time.Duration `json:"maxBackoff,omitempty"`
+}
+
+// CredentialsProvider provides the credentials of file systems from the
+// arguments of their Config. The type of the credentials is specific to the
+// file system, such as an aws.CredentialsProvider for S3. Providers run where
+// file systems are created, including on workers, so they can read the
+// credentials from a secret store rather than passing them in the pipeline
+// options.
+type CredentialsProvider func(ctx context.Context, args []string) (interface{}, error)
+
+var credentialsProviders = make(map[string]CredentialsProvider)
+
+// RegisterCredentialsProvider registers a credentials provider under the
+// given name. Like file systems, providers must be registered on workers
+// too, such as in an init function.
+func RegisterCredentialsProvider(name string, p CredentialsProvider) {
+	if _, ok := credentialsProviders[name]; ok {
+		panic(fmt.Sprintf("credentials provider %v already registered", name))
+	}
+	credentialsProviders[name] = p
+}
+
+// configKey returns the pipeline option of the config of the scheme.
+func configKey(scheme string) string {
+	return "filesystem_config_" + scheme
+}
+
+// Configure sets the config of the file systems of the scheme, which must
+// be registered with RegisterConfigurable. It must be called while
+// constructing the pipeline, before it's executed.
+func Configure(scheme string, cfg Config) error {
+	r, ok := registry[scheme]
+	if !ok {
+		return errors.Errorf("file system scheme %v not registered", scheme)
+	}
+	if !r.configurable {
+		return errors.Errorf("file system scheme %v not configurable", scheme)
+	}
+	if cfg.Credentials != "" {
+		if _, ok := credentialsProviders[cfg.Credentials]; !ok {
+			return errors.Errorf("credentials provider %v not registered", cfg.Credentials)
+		}
+	}
+	if p := cfg.Retry; p != nil {
+		if p.MaxAttempts < 0 || p.InitialBackoff < 0 || p.MaxBackoff < 0 || (p.MaxBackoff > 0 && p.MaxBackoff < p.InitialBackoff) {
+			return errors.Errorf("invalid retry policy %+v of file system scheme %v, want non-negative fields, and an initial backoff at most the maximum", *p, scheme)
+		}
+	}
+	data, err := json.Marshal(cfg)
+	if err != nil {
+		return errors.Wrapf(err, "encoding config of file system scheme %v", scheme)
+	}
+	runtime.GlobalOptions.Set(configKey(scheme), string(data))
+	return nil
+}
+
+// config returns the config of the scheme in the pipeline options.
+func config(scheme string) (Config, error) {
+	var cfg Config
+	data := runtime.GlobalOptions.Get(configKey(scheme))
+	if data == "" {
+		return cfg, nil
+	}
+	if err := json.Unmarshal([]byte(data), &cfg); err != nil {
+		return cfg, errors.Wrapf(err, "invalid config of file system scheme %v", scheme)
+	}
+	return cfg, nil
+}
+
+// LoadCredentials returns the credentials of the credentials provider of
+// the config, or nil if it has none.
+func (c Config) LoadCredentials(ctx context.Context) (interface{}, error) {
+	if c.Credentials == "" {
+		return nil, nil
+	}
+	p, ok := credentialsProviders[c.Credentials]
+	if !ok {
+		return nil, errors.Errorf("credentials provider %v not registered", c.Credentials)
+	}
+	creds, err := p(ctx, c.CredentialsArgs)
+	if err != nil {
+		return nil, errors.Wrapf(err, "loading credentials of provider %v", c.Credentials)
+	}
+	return creds, nil
+}
+
+var (
+	flagsMu sync.Mutex
+	// loadedFlags are the prefixes of the flags that are loaded.
+	loadedFlags = make(map[string]bool)
+)
+
+// LoadFlags sets the flags with the prefix that aren't set on the command
+// line to the pipeline options of their names. Workers don't get the command
+// line of the launching program, so file systems that are configured with
+// flags load them before reading them, to be configured like the launching
+// program on workers too.
+func LoadFlags(prefix string) error {
+	flagsMu.Lock()
+	defer flagsMu.Unlock()
+	if loadedFlags[prefix] {
+		return nil
+	}
+
+	set := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+	var err error
+	flag.VisitAll(func(f *flag.Flag) {
+		if err != nil || set[f.Name] || !strings.HasPrefix(f.Name, prefix) {
+			return
+		}
+		if v := runtime.GlobalOptions.Get(f.Name); v != "" {
+			err = errors.Wrapf(flag.Set(f.Name, v), "loading --%v from the pipeline options", f.Name)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	loadedFlags[prefix] = true
+	return nil
+}
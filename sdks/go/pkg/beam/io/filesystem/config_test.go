@@ -0,0 +1,120 @@
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements.  See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License.  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filesystem
+
+import (
+	"context"
+	"flag"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/core/runtime"
+)
+
+// configuredImpl is a file system of its config.
+type configuredImpl struct {
+	*testImpl
+	cfg Config
+}
+
+func init() {
+	RegisterConfigurable("configscheme", func(_ context.Context, cfg Config) (Interface, error) {
+		return &configuredImpl{testImpl: newTestImpl(), cfg: cfg}, nil
+	})
+	Register("plainscheme", func(context.Context) Interface { return newTestImpl() })
+	RegisterCredentialsProvider("joined", func(_ context.Context, args []string) (interface{}, error) {
+		return strings.Join(args, ","), nil
+	})
+}
+
+func TestConfigure(t *testing.T) {
+	defer runtime.GlobalOptions.Set(configKey("configscheme"), "")
+	ctx := context.Background()
+	path := "configscheme://foo"
+
+	fs, err := New(ctx, path)
+	if err != nil {
+		t.Fatalf("New(%q) failed: %v", path, err)
+	}
+	if got := fs.(*configuredImpl).cfg; !reflect.DeepEqual(got, Config{}) {
+		t.Errorf("New(%q) without config has config %+v, want zero config", path, got)
+	}
+
+	cfg := Config{
+		Endpoint:        "http://localhost:9000",
+		Credentials:     "joined",
+		CredentialsArgs: []string{"a", "b"},
+		Retry:           &RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Second, MaxBackoff: time.Minute},
+	}
+	if err := Configure("configscheme", cfg); err != nil {
+		t.Fatalf("Configure() failed: %v", err)
+	}
+	fs, err = New(ctx, path)
+	if err != nil {
+		t.Fatalf("New(%q) failed: %v", path, err)
+	}
+	got := fs.(*configuredImpl).cfg
+	if !reflect.DeepEqual(got, cfg) {
+		t.Errorf("New(%q) has config %+v, want %+v", path, got, cfg)
+	}
+	creds, err := got.LoadCredentials(ctx)
+	if err != nil || creds != "a,b" {
+		t.Errorf("LoadCredentials() = %v, %v, want a,b", creds, err)
+	}
+}
+
+func TestConfigure_Invalid(t *testing.T) {
+	tests := []struct {
+		name   string
+		scheme string
+		cfg    Config
+	}{
+		{"unregistered scheme", "nosuchscheme", Config{}},
+		{"not configurable", "plainscheme", Config{}},
+		{"unregistered credentials provider", "configscheme", Config{Credentials: "nosuchprovider"}},
+		{"negative attempts", "configscheme", Config{Retry: &RetryPolicy{MaxAttempts: -1}}},
+		{"max backoff below initial", "configscheme", Config{Retry: &RetryPolicy{InitialBackoff: time.Minute, MaxBackoff: time.Second}}},
+	}
+	for _, test := range tests {
+		if err := Configure(test.scheme, test.cfg); err == nil {
+			runtime.GlobalOptions.Set(configKey(test.scheme), "")
+			t.Errorf("Configure() with %v succeeded, want error", test.name)
+		}
+	}
+}
+
+func TestLoadFlags(t *testing.T) {
+	unset := flag.String("loadflags_unset", "default", "")
+	set := flag.String("loadflags_set", "default", "")
+	other := flag.String("otherflags_unset", "default", "")
+	if err := flag.Set("loadflags_set", "command line"); err != nil {
+		t.Fatalf("setting --loadflags_set: %v", err)
+	}
+	for _, name := range []string{"loadflags_unset", "loadflags_set", "otherflags_unset"} {
+		runtime.GlobalOptions.Set(name, "option")
+		defer runtime.GlobalOptions.Set(name, "")
+	}
+
+	if err := LoadFlags("loadflags_"); err != nil {
+		t.Fatalf("LoadFlags() failed: %v", err)
+	}
+	// Only the unset flags with the prefix are loaded.
+	if got, want := []string{*unset, *set, *other}, []string{"option", "command line", "default"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("LoadFlags() set flags to %v, want %v", got, want)
+	}
+}
@@ -18,9 +18,15 @@
 //
 // Registered file systems at minimum implement the Interface abstraction, and
 // can then optionally implement Remover, Renamer, and Copier to support
-// rename operations. Filesystems are only expected to handle their own IO, and
-// not cross file system IO. Should cross file system IO be required, additional
-// utility methods should be added to this package to support them.
+// rename operations, and RangeReader to support reading part of a file.
+// Filesystems are only expected to handle their own IO, and not cross file
+// system IO. Should cross file system IO be required, additional utility
+// methods should be added to this package to support them.
+//
+// File systems registered with RegisterConfigurable are created with the
+// Config of their scheme, such as their endpoint, credentials and retries,
+// which is set with Configure and resolved from the pipeline options on
+// workers.
 package filesystem
 
 import (
@@ -32,26 +38,57 @@ import (
 	"github.com/apache/beam/sdks/v2/go/pkg/beam/internal/errors"
 )
 
-var registry = make(map[string]func(context.Context) Interface)
+// registration is a file system backend registered under a scheme.
+type registration struct {
+	mkfs func(context.Context, Config) (Interface, error)
+	// configurable is whether the file system is created with the Config of
+	// the scheme.
+	configurable bool
+}
+
+var registry = make(map[string]registration)
 
 // Register registers a file system backend under the given scheme.  For
 // example, "hdfs" would be registered a HFDS file system and HDFS paths used
 // transparently.
 func Register(scheme string, fs func(context.Context) Interface) {
+	register(scheme, registration{
+		mkfs: func(ctx context.Context, _ Config) (Interface, error) {
+			return fs(ctx), nil
+		},
+	})
+}
+
+// RegisterConfigurable registers a file system backend under the given
+// scheme, which is created with the Config of the scheme set with Configure,
+// or the zero Config if it has none.
+func RegisterConfigurable(scheme string, fs func(context.Context, Config) (Interface, error)) {
+	register(scheme, registration{mkfs: fs, configurable: true})
+}
+
+func register(scheme string, r registration) {
 	if _, ok := registry[scheme]; ok {
 		panic(fmt.Sprintf("scheme %v already registered", scheme))
 	}
-	registry[scheme] = fs
+	registry[scheme] = r
 }
 
 // New returns a new Interface for the given file path's scheme.
 func New(ctx context.Context, path string) (Interface, error) {
 	scheme := getScheme(path)
-	mkfs, ok := registry[scheme]
+	r, ok := registry[scheme]
 	if !ok {
 		return nil, errors.Errorf("file system scheme %v not registered for %v", scheme, path)
 	}
-	return mkfs(ctx), nil
+	cfg, err := config(scheme)
+	if err != nil {
+		return nil, err
+	}
+	fs, err := r.mkfs(ctx, cfg)
+	if err != nil {
+		return nil, errors.Wrapf(err, "creating file system of scheme %v for %v", scheme, path)
+	}
+	return fs, nil
 }
 
 // Interface is a filesystem abstraction that allows beam io sources and sinks
@@ -90,6 +127,14 @@ type Renamer interface {
 	Rename(ctx context.Context, oldpath, newpath string) error
 }
 
+// RangeReader is an interface for reading part of a file, so that readers
+// can seek without reading the file from its start.
+type RangeReader interface {
+	// OpenReadRange opens a file for reading length bytes from the offset,
+	// or the rest of the file if length is negative.
+	OpenReadRange(ctx context.Context, filename string, offset, length int64) (io.ReadCloser, error)
+}
+
 func getScheme(path string) string {
 	if index := strings.Index(path, "://"); index > 0 {
 		return path[:index]
@@ -15,46 +15,172 @@
 
 // Package gcs contains a Google Cloud Storage (GCS) implementation of the
 // Beam file system.
+//
+// The retries of requests and the customer-managed encryption key of written
+// objects are configured with the gcs_* flags, which are loaded from the
+// pipeline options on workers.
+//
+// The file system is configurable with filesystem.Configure: the endpoint of
+// the config replaces the default endpoint, its credentials provider provides
+// an oauth2.TokenSource or the JSON of a service account key, and its retry
+// policy replaces the backoffs of the flags. Requests are retried until their
+// context is done, so a maximum of attempts other than 1, which disables
+// retries, isn't supported.
 package gcs
 
 import (
 	"context"
+	"flag"
 	"fmt"
 	"io"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"cloud.google.com/go/storage"
 	"github.com/apache/beam/sdks/v2/go/pkg/beam/internal/errors"
 	"github.com/apache/beam/sdks/v2/go/pkg/beam/io/filesystem"
 	"github.com/apache/beam/sdks/v2/go/pkg/beam/log"
 	"github.com/apache/beam/sdks/v2/go/pkg/beam/util/gcsx"
+	"github.com/googleapis/gax-go/v2"
+	"golang.org/x/oauth2"
 	"google.golang.org/api/iterator"
 	"google.golang.org/api/option"
 )
 
+var (
+	retryPolicy = flag.String("gcs_retry_policy", "idempotent",
+		"Which failed GCS requests are retried: idempotent, always, or never. Writes are made idempotent with preconditions on the generation of their objects.")
+	retryInitialBackoff = flag.Duration("gcs_retry_initial_backoff", time.Second,
+		"Initial backoff between retries of GCS requests.")
+	retryMaxBackoff = flag.Duration("gcs_retry_max_backoff", 32*time.Second,
+		"Maximum backoff between retries of GCS requests.")
+	retryMultiplier = flag.Float64("gcs_retry_multiplier", 2,
+		"Factor by which the backoff between retries of GCS requests increases.")
+	kmsKey = flag.String("gcs_kms_key", "",
+		"Resource name of the Cloud KMS key that encrypts written objects, instead of the bucket default.")
+)
+
 func init() {
-	filesystem.Register("gs", New)
+	filesystem.RegisterConfigurable("gs", NewWithConfig)
+}
+
+// options holds the configuration of the file system.
+type options struct {
+	RetryPolicy storage.RetryPolicy
+	Backoff     gax.Backoff
+	KMSKey      string
+}
+
+// flagOptions returns the options set with the flags.
+func flagOptions() (options, error) {
+	o := options{
+		Backoff: gax.Backoff{
+			Initial:    *retryInitialBackoff,
+			Max:        *retryMaxBackoff,
+			Multiplier: *retryMultiplier,
+		},
+		KMSKey: *kmsKey,
+	}
+	switch strings.ToLower(*retryPolicy) {
+	case "idempotent":
+		o.RetryPolicy = storage.RetryIdempotent
+	case "always":
+		o.RetryPolicy = storage.RetryAlways
+	case "never":
+		o.RetryPolicy = storage.RetryNever
+	default:
+		return o, errors.Errorf("invalid --gcs_retry_policy %q, want idempotent, always, or never", *retryPolicy)
+	}
+	if o.Backoff.Initial <= 0 || o.Backoff.Max < o.Backoff.Initial || o.Backoff.Multiplier < 1 {
+		return o, errors.Errorf("invalid GCS retry backoff %v to %v by %v, want a positive initial backoff at most the maximum, and a multiplier of at least 1",
+			o.Backoff.Initial, o.Backoff.Max, o.Backoff.Multiplier)
+	}
+	return o, nil
 }
 
 type fs struct {
 	client *storage.Client
+	opts   options
 }
 
 // New creates a new Google Cloud Storage filesystem using application
 // default credentials. If it fails, it falls back to unauthenticated
 // access.
 func New(ctx context.Context) filesystem.Interface {
-	client, err := storage.NewClient(ctx, option.WithScopes(storage.ScopeReadWrite))
+	fs, err := NewWithConfig(ctx, filesystem.Config{})
+	if err != nil {
+		panic(err)
+	}
+	return fs
+}
+
+// NewWithConfig creates a new Google Cloud Storage filesystem, configured
+// with the gcs_* flags and the given config. Without the credentials of a
+// provider, it uses application default credentials, and falls back to
+// unauthenticated access if they fail.
+func NewWithConfig(ctx context.Context, cfg filesystem.Config) (filesystem.Interface, error) {
+	if err := filesystem.LoadFlags("gcs_"); err != nil {
+		return nil, err
+	}
+	opts, err := flagOptions()
+	if err != nil {
+		return nil, err
+	}
+	if p := cfg.Retry; p != nil {
+		if p.MaxAttempts == 1 {
+			opts.RetryPolicy = storage.RetryNever
+		}
+		if p.InitialBackoff > 0 {
+			opts.Backoff.Initial = p.InitialBackoff
+		}
+		if p.MaxBackoff > 0 {
+			opts.Backoff.Max = p.MaxBackoff
+		}
+	}
+	var endpointOpts []option.ClientOption
+	if cfg.Endpoint != "" {
+		endpointOpts = append(endpointOpts, option.WithEndpoint(cfg.Endpoint))
+	}
+	authOpts := []option.ClientOption{option.WithScopes(storage.ScopeReadWrite)}
+	creds, err := cfg.LoadCredentials(ctx)
 	if err != nil {
+		return nil, err
+	}
+	switch creds := creds.(type) {
+	case nil:
+	case oauth2.TokenSource:
+		authOpts = append(authOpts, option.WithTokenSource(creds))
+	case []byte:
+		authOpts = append(authOpts, option.WithCredentialsJSON(creds))
+	default:
+		return nil, errors.Errorf("GCS credentials must be an oauth2.TokenSource or the JSON of a service account key, got %T", creds)
+	}
+	client, err := storage.NewClient(ctx, append(authOpts, endpointOpts...)...)
+	if err != nil {
+		if creds != nil {
+			return nil, errors.Wrap(err, "failed to create GCS client")
+		}
 		log.Warnf(ctx, "Warning: falling back to unauthenticated GCS access: %v", err)
 
-		client, err = storage.NewClient(ctx, option.WithoutAuthentication())
+		client, err = storage.NewClient(ctx, append(endpointOpts, option.WithoutAuthentication())...)
 		if err != nil {
-			panic(errors.Wrapf(err, "failed to create GCS client"))
+			return nil, errors.Wrapf(err, "failed to create GCS client")
 		}
 	}
-	return &fs{client: client}
+	return &fs{client: client, opts: opts}, nil
+}
+
+// bucket returns the handle of a bucket, which retries requests with the
+// retry policy and backoff.
+func (f *fs) bucket(bucket string) *storage.BucketHandle {
+	return f.client.Bucket(bucket).Retryer(storage.WithPolicy(f.opts.RetryPolicy), storage.WithBackoff(f.opts.Backoff))
+}
+
+// object returns the handle of an object, which retries requests like its
+// bucket.
+func (f *fs) object(bucket, object string) *storage.ObjectHandle {
+	return f.bucket(bucket).Object(object)
 }
 
 func (f *fs) Close() error {
@@ -73,7 +199,7 @@ func (f *fs) List(ctx context.Context, glob string) ([]string, error) {
 		// For now, we assume * is the first matching character to make a
 		// prefix listing and not list the entire bucket.
 
-		it := f.client.Bucket(bucket).Objects(ctx, &storage.Query{
+		it := f.bucket(bucket).Objects(ctx, &storage.Query{
 			Prefix: object[:index],
 		})
 		for {
@@ -111,18 +237,48 @@ func (f *fs) OpenRead(ctx context.Context, filename string) (io.ReadCloser, erro
 		return nil, err
 	}
 
-	return f.client.Bucket(bucket).Object(object).NewReader(ctx)
+	return f.object(bucket, object).NewReader(ctx)
+}
+
+// OpenReadRange opens an object for reading length bytes from the offset,
+// or the rest of the object if length is negative, without downloading the
+// data before the offset.
+func (f *fs) OpenReadRange(ctx context.Context, filename string, offset, length int64) (io.ReadCloser, error) {
+	bucket, object, err := gcsx.ParseObject(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	return f.object(bucket, object).NewRangeReader(ctx, offset, length)
 }
 
 // TODO(herohde) 7/12/2017: should we create the bucket in OpenWrite? For now, "no".
 
+// OpenWrite opens an object for writing, encrypted with the KMS key, if it's
+// set. With the idempotent retry policy, the write is conditional on the
+// generation of the object when it's opened, so that GCS considers it
+// idempotent and it's retried.
 func (f *fs) OpenWrite(ctx context.Context, filename string) (io.WriteCloser, error) {
 	bucket, object, err := gcsx.ParseObject(filename)
 	if err != nil {
 		return nil, err
 	}
 
-	return f.client.Bucket(bucket).Object(object).NewWriter(ctx), nil
+	obj := f.object(bucket, object)
+	if f.opts.RetryPolicy == storage.RetryIdempotent {
+		attrs, err := obj.Attrs(ctx)
+		switch {
+		case err == storage.ErrObjectNotExist:
+			obj = obj.If(storage.Conditions{DoesNotExist: true})
+		case err != nil:
+			return nil, err
+		default:
+			obj = obj.If(storage.Conditions{GenerationMatch: attrs.Generation})
+		}
+	}
+	w := obj.NewWriter(ctx)
+	w.KMSKeyName = f.opts.KMSKey
+	return w, nil
 }
 
 func (f *fs) Size(ctx context.Context, filename string) (int64, error) {
@@ -131,7 +287,7 @@ func (f *fs) Size(ctx context.Context, filename string) (int64, error) {
 		return -1, err
 	}
 
-	obj := f.client.Bucket(bucket).Object(object)
+	obj := f.object(bucket, object)
 	attrs, err := obj.Attrs(ctx)
 	if err != nil {
 		return -1, err
@@ -147,7 +303,7 @@ func (f *fs) Remove(ctx context.Context, filename string) error {
 		return err
 	}
 
-	obj := f.client.Bucket(bucket).Object(object)
+	obj := f.object(bucket, object)
 	return obj.Delete(ctx)
 }
 
@@ -157,21 +313,23 @@ func (f *fs) Copy(ctx context.Context, srcpath, dstpath string) error {
 	if err != nil {
 		return err
 	}
-	srcobj := f.client.Bucket(bucket).Object(src)
+	srcobj := f.object(bucket, src)
 
 	bucket, dst, err := gcsx.ParseObject(dstpath)
 	if err != nil {
 		return err
 	}
-	dstobj := f.client.Bucket(bucket).Object(dst)
+	dstobj := f.object(bucket, dst)
 
 	cp := dstobj.CopierFrom(srcobj)
+	cp.DestinationKMSKeyName = f.opts.KMSKey
 	_, err = cp.Run(ctx)
 	return err
 }
 
 // Compile time check for interface implementations.
 var (
-	_ filesystem.Remover = ((*fs)(nil))
-	_ filesystem.Copier  = ((*fs)(nil))
+	_ filesystem.Remover     = ((*fs)(nil))
+	_ filesystem.Copier      = ((*fs)(nil))
+	_ filesystem.RangeReader = ((*fs)(nil))
 )
@@ -17,9 +17,21 @@ package gcs
 
 import (
 	"context"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 
+	"cloud.google.com/go/storage"
 	"github.com/apache/beam/sdks/v2/go/pkg/beam/io/filesystem"
+	"golang.org/x/oauth2"
+	"google.golang.org/api/option"
 )
 
 func TestGCS_FilesystemNew(t *testing.T) {
@@ -36,3 +48,181 @@ func TestGCS_FilesystemNew(t *testing.T) {
 		t.Errorf("c.Close() = %v, want nil", err)
 	}
 }
+
+// fakeServer serves the object "gs://b/o" with the data, and records the
+// query parameters of uploads.
+type fakeServer struct {
+	data       string
+	generation int64
+	uploads    []url.Values
+	// authorization is the Authorization header of the last request.
+	authorization string
+}
+
+func (s *fakeServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.authorization = r.Header.Get("Authorization")
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == "/b/o":
+		http.ServeContent(w, r, "o", time.Time{}, strings.NewReader(s.data))
+	case r.Method == http.MethodGet && r.URL.Path == "/storage/v1/b/b/o/o":
+		if s.generation == 0 {
+			http.Error(w, `{"error": {"code": 404}}`, http.StatusNotFound)
+			return
+		}
+		fmt.Fprintf(w, `{"bucket": "b", "name": "o", "generation": "%v"}`, s.generation)
+	case r.Method == http.MethodPost && r.URL.Path == "/upload/storage/v1/b/b/o":
+		s.uploads = append(s.uploads, r.URL.Query())
+		io.Copy(io.Discard, r.Body)
+		fmt.Fprintf(w, `{"bucket": "b", "name": "o", "generation": "%v"}`, s.generation+1)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// newTestFS returns a file system of a client of the server.
+func newTestFS(t *testing.T, srv *fakeServer, opts options) *fs {
+	t.Helper()
+	ts := httptest.NewServer(srv)
+	t.Cleanup(ts.Close)
+	client, err := storage.NewClient(context.Background(), option.WithEndpoint(ts.URL+"/storage/v1/"), option.WithoutAuthentication())
+	if err != nil {
+		t.Fatalf("storage.NewClient() failed: %v", err)
+	}
+	return &fs{client: client, opts: opts}
+}
+
+func TestGCS_OpenReadRange(t *testing.T) {
+	f := newTestFS(t, &fakeServer{data: "0123456789"}, options{RetryPolicy: storage.RetryNever})
+	ctx := context.Background()
+	tests := []struct {
+		offset, length int64
+		want           string
+	}{
+		{0, -1, "0123456789"},
+		{4, -1, "456789"},
+		{4, 3, "456"},
+	}
+	for _, test := range tests {
+		rd, err := f.OpenReadRange(ctx, "gs://b/o", test.offset, test.length)
+		if err != nil {
+			t.Fatalf("OpenReadRange(%v, %v) failed: %v", test.offset, test.length, err)
+		}
+		data, err := io.ReadAll(rd)
+		rd.Close()
+		if err != nil || string(data) != test.want {
+			t.Errorf("OpenReadRange(%v, %v) read %q, %v, want %q", test.offset, test.length, data, err, test.want)
+		}
+	}
+}
+
+func TestGCS_OpenWrite(t *testing.T) {
+	tests := []struct {
+		name       string
+		generation int64
+		policy     storage.RetryPolicy
+		want       url.Values
+	}{
+		{"new object", 0, storage.RetryIdempotent, url.Values{"ifGenerationMatch": {"0"}, "kmsKeyName": {"key"}}},
+		{"existing object", 7, storage.RetryIdempotent, url.Values{"ifGenerationMatch": {"7"}, "kmsKeyName": {"key"}}},
+		{"retry always", 7, storage.RetryAlways, url.Values{"kmsKeyName": {"key"}}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			srv := &fakeServer{generation: test.generation}
+			f := newTestFS(t, srv, options{RetryPolicy: test.policy, KMSKey: "key"})
+			w, err := f.OpenWrite(context.Background(), "gs://b/o")
+			if err != nil {
+				t.Fatalf("OpenWrite() failed: %v", err)
+			}
+			io.WriteString(w, "data")
+			if err := w.Close(); err != nil {
+				t.Fatalf("Close() failed: %v", err)
+			}
+			if len(srv.uploads) != 1 {
+				t.Fatalf("OpenWrite() made %v uploads, want 1", len(srv.uploads))
+			}
+			for k := range srv.uploads[0] {
+				if k != "ifGenerationMatch" && k != "kmsKeyName" {
+					srv.uploads[0].Del(k)
+				}
+			}
+			if got := srv.uploads[0]; !reflect.DeepEqual(got, test.want) {
+				t.Errorf("OpenWrite() uploaded with parameters %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func init() {
+	filesystem.RegisterCredentialsProvider("gcstoken", func(_ context.Context, args []string) (interface{}, error) {
+		return oauth2.StaticTokenSource(&oauth2.Token{AccessToken: args[0]}), nil
+	})
+}
+
+func TestNewWithConfig(t *testing.T) {
+	srv := &fakeServer{generation: 1}
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+	ctx := context.Background()
+
+	cfg := filesystem.Config{
+		Endpoint:        ts.URL + "/storage/v1/",
+		Credentials:     "gcstoken",
+		CredentialsArgs: []string{"t"},
+		Retry:           &filesystem.RetryPolicy{MaxAttempts: 1, MaxBackoff: time.Minute},
+	}
+	f, err := NewWithConfig(ctx, cfg)
+	if err != nil {
+		t.Fatalf("NewWithConfig() failed: %v", err)
+	}
+	defer f.Close()
+	if _, err := f.Size(ctx, "gs://b/o"); err != nil {
+		t.Fatalf("Size() failed: %v", err)
+	}
+	if got, want := srv.authorization, "Bearer t"; got != want {
+		t.Errorf("Size() was authorized with %q, want %q", got, want)
+	}
+	if got := f.(*fs).opts; got.RetryPolicy != storage.RetryNever || got.Backoff.Max != time.Minute {
+		t.Errorf("NewWithConfig() has retry policy %v and max backoff %v, want %v and %v", got.RetryPolicy, got.Backoff.Max, storage.RetryNever, time.Minute)
+	}
+}
+
+func TestFlagOptions(t *testing.T) {
+	tests := []struct {
+		name    string
+		flags   map[string]string
+		want    storage.RetryPolicy
+		wantErr bool
+	}{
+		{"defaults", nil, storage.RetryIdempotent, false},
+		{"always", map[string]string{"gcs_retry_policy": "always"}, storage.RetryAlways, false},
+		{"never", map[string]string{"gcs_retry_policy": "Never"}, storage.RetryNever, false},
+		{"invalid policy", map[string]string{"gcs_retry_policy": "sometimes"}, 0, true},
+		{"zero initial backoff", map[string]string{"gcs_retry_initial_backoff": "0s"}, 0, true},
+		{"max backoff below initial", map[string]string{"gcs_retry_max_backoff": "100ms"}, 0, true},
+		{"multiplier below 1", map[string]string{"gcs_retry_multiplier": "0.5"}, 0, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			defer func() {
+				flag.VisitAll(func(f *flag.Flag) {
+					if strings.HasPrefix(f.Name, "gcs_") {
+						f.Value.Set(f.DefValue)
+					}
+				})
+			}()
+			for k, v := range test.flags {
+				if err := flag.Set(k, v); err != nil {
+					t.Fatalf("setting --%v: %v", k, err)
+				}
+			}
+			got, err := flagOptions()
+			if (err != nil) != test.wantErr {
+				t.Fatalf("flagOptions() = %v, want error %v", err, test.wantErr)
+			}
+			if err == nil && got.RetryPolicy != test.want {
+				t.Errorf("flagOptions() policy = %v, want %v", got.RetryPolicy, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,402 @@
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements.  See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License.  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package s3 contains an Amazon S3 implementation of the Beam file system,
+// for paths of the form "s3://bucket/key".
+//
+// Credentials and the region are loaded from the default sources of the AWS
+// SDK for Go v2, such as the environment, the shared configuration files and
+// the instance role. The region, the endpoint of S3-compatible stores, the
+// server-side encryption and multipart uploads of written objects, and
+// requester-pays buckets are configured with the s3_* flags, which are
+// loaded from the pipeline options on workers.
+//
+// The file system is configurable with filesystem.Configure: the endpoint of
+// the config replaces the endpoint flag, its credentials provider provides an
+// aws.CredentialsProvider, and its retry policy configures the standard
+// retryer of the SDK, which doesn't support the initial backoff.
+package s3
+
+import (
+	"context"
+	"flag"
+	"io"
+	"net/url"
+	"path/filepath"
+	"strings"
+
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/internal/errors"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/io/filesystem"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	awss3 "github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+var (
+	region = flag.String("s3_region", "",
+		"AWS region of S3 buckets, instead of the default region.")
+	endpoint = flag.String("s3_endpoint", "",
+		"URL of the endpoint of an S3-compatible store, such as http://localhost:9000.")
+	pathStyle = flag.Bool("s3_path_style", false,
+		"Whether buckets are addressed in the path of URLs, as S3-compatible stores often require, instead of in the host.")
+	sse = flag.String("s3_sse", "",
+		"Server-side encryption of written objects: SSE-S3, SSE-KMS, or empty for the bucket default.")
+	sseKMSKeyID = flag.String("s3_sse_kms_key_id", "",
+		"ID or ARN of the KMS key of SSE-KMS, instead of the AWS managed key.")
+	partSize = flag.Int64("s3_part_size", manager.DefaultUploadPartSize,
+		"Size in bytes of the parts of multipart uploads of written objects, at least 5 MiB.")
+	uploadConcurrency = flag.Int("s3_upload_concurrency", manager.DefaultUploadConcurrency,
+		"Number of parts of each written object that are uploaded concurrently.")
+	requesterPays = flag.Bool("s3_requester_pays", false,
+		"Whether requests are charged to the requester, as requester-pays buckets require.")
+)
+
+func init() {
+	filesystem.RegisterConfigurable("s3", NewWithConfig)
+}
+
+// options holds the configuration of the file system.
+type options struct {
+	Region            string
+	Endpoint          string
+	PathStyle         bool
+	SSE               types.ServerSideEncryption
+	SSEKMSKeyID       string
+	PartSize          int64
+	UploadConcurrency int
+	RequesterPays     bool
+}
+
+// flagOptions returns the options set with the flags.
+func flagOptions() (options, error) {
+	o := options{
+		Region:            *region,
+		Endpoint:          *endpoint,
+		PathStyle:         *pathStyle,
+		SSEKMSKeyID:       *sseKMSKeyID,
+		PartSize:          *partSize,
+		UploadConcurrency: *uploadConcurrency,
+		RequesterPays:     *requesterPays,
+	}
+	switch strings.ToUpper(*sse) {
+	case "":
+	case "SSE-S3", strings.ToUpper(string(types.ServerSideEncryptionAes256)):
+		o.SSE = types.ServerSideEncryptionAes256
+	case "SSE-KMS", strings.ToUpper(string(types.ServerSideEncryptionAwsKms)):
+		o.SSE = types.ServerSideEncryptionAwsKms
+	default:
+		return o, errors.Errorf("invalid --s3_sse %q, want SSE-S3 or SSE-KMS", *sse)
+	}
+	if o.SSEKMSKeyID != "" && o.SSE != types.ServerSideEncryptionAwsKms {
+		return o, errors.New("--s3_sse_kms_key_id requires --s3_sse=SSE-KMS")
+	}
+	if o.PartSize < manager.MinUploadPartSize {
+		return o, errors.Errorf("invalid --s3_part_size %v, want at least %v", o.PartSize, manager.MinUploadPartSize)
+	}
+	if o.UploadConcurrency < 1 {
+		return o, errors.Errorf("invalid --s3_upload_concurrency %v, want at least 1", o.UploadConcurrency)
+	}
+	return o, nil
+}
+
+// client is the part of a *s3.Client used by the file system, so that it can
+// be faked in tests.
+type client interface {
+	manager.UploadAPIClient
+	ListObjectsV2(ctx context.Context, in *awss3.ListObjectsV2Input, opts ...func(*awss3.Options)) (*awss3.ListObjectsV2Output, error)
+	GetObject(ctx context.Context, in *awss3.GetObjectInput, opts ...func(*awss3.Options)) (*awss3.GetObjectOutput, error)
+	HeadObject(ctx context.Context, in *awss3.HeadObjectInput, opts ...func(*awss3.Options)) (*awss3.HeadObjectOutput, error)
+	DeleteObject(ctx context.Context, in *awss3.DeleteObjectInput, opts ...func(*awss3.Options)) (*awss3.DeleteObjectOutput, error)
+	CopyObject(ctx context.Context, in *awss3.CopyObjectInput, opts ...func(*awss3.Options)) (*awss3.CopyObjectOutput, error)
+}
+
+type fs struct {
+	client   client
+	uploader *manager.Uploader
+	opts     options
+}
+
+// New creates a new S3 filesystem, configured with the s3_* flags and the
+// default AWS credentials.
+func New(ctx context.Context) filesystem.Interface {
+	fs, err := NewWithConfig(ctx, filesystem.Config{})
+	if err != nil {
+		panic(err)
+	}
+	return fs
+}
+
+// NewWithConfig creates a new S3 filesystem, configured with the s3_* flags
+// and the given config.
+func NewWithConfig(ctx context.Context, fsCfg filesystem.Config) (filesystem.Interface, error) {
+	if err := filesystem.LoadFlags("s3_"); err != nil {
+		return nil, err
+	}
+	opts, err := flagOptions()
+	if err != nil {
+		return nil, err
+	}
+	if fsCfg.Endpoint != "" {
+		opts.Endpoint = fsCfg.Endpoint
+	}
+	var loadOpts []func(*config.LoadOptions) error
+	if opts.Region != "" {
+		loadOpts = append(loadOpts, config.WithRegion(opts.Region))
+	}
+	creds, err := fsCfg.LoadCredentials(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if creds != nil {
+		p, ok := creds.(aws.CredentialsProvider)
+		if !ok {
+			return nil, errors.Errorf("S3 credentials must be an aws.CredentialsProvider, got %T", creds)
+		}
+		loadOpts = append(loadOpts, config.WithCredentialsProvider(p))
+	}
+	if p := fsCfg.Retry; p != nil {
+		loadOpts = append(loadOpts, config.WithRetryer(func() aws.Retryer {
+			return retry.NewStandard(func(o *retry.StandardOptions) {
+				if p.MaxAttempts > 0 {
+					o.MaxAttempts = p.MaxAttempts
+				}
+				if p.MaxBackoff > 0 {
+					o.MaxBackoff = p.MaxBackoff
+				}
+			})
+		}))
+	}
+	cfg, err := config.LoadDefaultConfig(ctx, loadOpts...)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to load AWS configuration")
+	}
+	c := awss3.NewFromConfig(cfg, func(o *awss3.Options) {
+		if opts.Endpoint != "" {
+			o.EndpointResolver = awss3.EndpointResolverFromURL(opts.Endpoint)
+		}
+		o.UsePathStyle = opts.PathStyle
+	})
+	return newFS(c, opts), nil
+}
+
+func newFS(c client, opts options) *fs {
+	return &fs{
+		client: c,
+		uploader: manager.NewUploader(c, func(u *manager.Uploader) {
+			u.PartSize = opts.PartSize
+			u.Concurrency = opts.UploadConcurrency
+		}),
+		opts: opts,
+	}
+}
+
+// requestPayer returns the request payer of requests.
+func (f *fs) requestPayer() types.RequestPayer {
+	if f.opts.RequesterPays {
+		return types.RequestPayerRequester
+	}
+	return ""
+}
+
+// parseObject parses a path of the form "s3://bucket/key" into its bucket
+// and key.
+func parseObject(path string) (bucket, key string, err error) {
+	parsed, err := url.Parse(path)
+	if err != nil {
+		return "", "", err
+	}
+	if parsed.Scheme != "s3" {
+		return "", "", errors.Errorf("object %s must have 's3' scheme", path)
+	}
+	if parsed.Host == "" {
+		return "", "", errors.Errorf("object %s must have bucket", path)
+	}
+	return parsed.Host, strings.TrimPrefix(parsed.Path, "/"), nil
+}
+
+func (f *fs) Close() error {
+	return nil
+}
+
+func (f *fs) List(ctx context.Context, glob string) ([]string, error) {
+	bucket, key, err := parseObject(glob)
+	if err != nil {
+		return nil, err
+	}
+
+	index := strings.IndexAny(key, "*?[")
+	if index < 0 {
+		// Single object.
+		return []string{glob}, nil
+	}
+	// Globs are handled by listing the objects with the prefix before the
+	// first special character, and matching them here.
+	var ret []string
+	in := &awss3.ListObjectsV2Input{
+		Bucket:       aws.String(bucket),
+		Prefix:       aws.String(key[:index]),
+		RequestPayer: f.requestPayer(),
+	}
+	p := awss3.NewListObjectsV2Paginator(f.client, in)
+	for p.HasMorePages() {
+		out, err := p.NextPage(ctx)
+		if err != nil {
+			return nil, errors.Wrapf(err, "listing objects of %v", glob)
+		}
+		for _, obj := range out.Contents {
+			match, err := filepath.Match(key, aws.ToString(obj.Key))
+			if err != nil {
+				return nil, err
+			}
+			if match {
+				ret = append(ret, "s3://"+bucket+"/"+aws.ToString(obj.Key))
+			}
+		}
+	}
+	return ret, nil
+}
+
+func (f *fs) OpenRead(ctx context.Context, filename string) (io.ReadCloser, error) {
+	bucket, key, err := parseObject(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	out, err := f.client.GetObject(ctx, &awss3.GetObjectInput{
+		Bucket:       aws.String(bucket),
+		Key:          aws.String(key),
+		RequestPayer: f.requestPayer(),
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "reading %v", filename)
+	}
+	return out.Body, nil
+}
+
+// OpenWrite opens an object for writing. The object is uploaded as it's
+// written, with a multipart upload of parts of the part size once it
+// exceeds a part, and is committed when the writer is closed.
+func (f *fs) OpenWrite(ctx context.Context, filename string) (io.WriteCloser, error) {
+	bucket, key, err := parseObject(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	in := &awss3.PutObjectInput{
+		Bucket:               aws.String(bucket),
+		Key:                  aws.String(key),
+		ServerSideEncryption: f.opts.SSE,
+		RequestPayer:         f.requestPayer(),
+	}
+	if f.opts.SSEKMSKeyID != "" {
+		in.SSEKMSKeyId = aws.String(f.opts.SSEKMSKeyID)
+	}
+	pr, pw := io.Pipe()
+	in.Body = pr
+	w := &writer{pw: pw, done: make(chan error, 1)}
+	go func() {
+		_, err := f.uploader.Upload(ctx, in)
+		if err != nil {
+			err = errors.Wrapf(err, "writing %v", filename)
+		}
+		// Writes fail once the upload has failed.
+		pr.CloseWithError(err)
+		w.done <- err
+	}()
+	return w, nil
+}
+
+// writer writes to an upload through a pipe.
+type writer struct {
+	pw   *io.PipeWriter
+	done chan error
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	return w.pw.Write(p)
+}
+
+// Close ends the object, and waits for its upload to complete.
+func (w *writer) Close() error {
+	w.pw.Close()
+	return <-w.done
+}
+
+func (f *fs) Size(ctx context.Context, filename string) (int64, error) {
+	bucket, key, err := parseObject(filename)
+	if err != nil {
+		return -1, err
+	}
+
+	out, err := f.client.HeadObject(ctx, &awss3.HeadObjectInput{
+		Bucket:       aws.String(bucket),
+		Key:          aws.String(key),
+		RequestPayer: f.requestPayer(),
+	})
+	if err != nil {
+		return -1, errors.Wrapf(err, "getting size of %v", filename)
+	}
+	return out.ContentLength, nil
+}
+
+// Remove the named file from the filesystem.
+func (f *fs) Remove(ctx context.Context, filename string) error {
+	bucket, key, err := parseObject(filename)
+	if err != nil {
+		return err
+	}
+
+	_, err = f.client.DeleteObject(ctx, &awss3.DeleteObjectInput{
+		Bucket:       aws.String(bucket),
+		Key:          aws.String(key),
+		RequestPayer: f.requestPayer(),
+	})
+	return err
+}
+
+// Copy copies from srcpath to the dstpath, encrypting the copy like written
+// objects. S3 copies objects of at most 5 GiB.
+func (f *fs) Copy(ctx context.Context, srcpath, dstpath string) error {
+	srcBucket, src, err := parseObject(srcpath)
+	if err != nil {
+		return err
+	}
+	bucket, dst, err := parseObject(dstpath)
+	if err != nil {
+		return err
+	}
+
+	in := &awss3.CopyObjectInput{
+		Bucket:               aws.String(bucket),
+		Key:                  aws.String(dst),
+		CopySource:           aws.String(url.PathEscape(srcBucket) + "/" + (&url.URL{Path: src}).EscapedPath()),
+		ServerSideEncryption: f.opts.SSE,
+		RequestPayer:         f.requestPayer(),
+	}
+	if f.opts.SSEKMSKeyID != "" {
+		in.SSEKMSKeyId = aws.String(f.opts.SSEKMSKeyID)
+	}
+	_, err = f.client.CopyObject(ctx, in)
+	return err
+}
+
+// Compile time check for interface implementations.
+var (
+	_ filesystem.Remover = ((*fs)(nil))
+	_ filesystem.Copier  = ((*fs)(nil))
+)
@@ -0,0 +1,452 @@
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements.  See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License.  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package s3
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/io/filesystem"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	awss3 "github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+)
+
+// fakeClient is a client of in-memory buckets, which lists pages of two
+// objects.
+type fakeClient struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+	parts   map[string]map[int32][]byte
+	// puts and uploads are the single and multipart uploads of objects.
+	puts    []*awss3.PutObjectInput
+	uploads []*awss3.CreateMultipartUploadInput
+	copies  []*awss3.CopyObjectInput
+	// payers are the request payers of requests.
+	payers []types.RequestPayer
+}
+
+func newFakeClient(objects map[string]string) *fakeClient {
+	c := &fakeClient{objects: make(map[string][]byte), parts: make(map[string]map[int32][]byte)}
+	for k, v := range objects {
+		c.objects[k] = []byte(v)
+	}
+	return c
+}
+
+func notFound(bucket, key *string) error {
+	return &smithy.GenericAPIError{Code: "NoSuchKey", Message: aws.ToString(bucket) + "/" + aws.ToString(key)}
+}
+
+func (c *fakeClient) pay(p types.RequestPayer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.payers = append(c.payers, p)
+}
+
+func (c *fakeClient) PutObject(_ context.Context, in *awss3.PutObjectInput, _ ...func(*awss3.Options)) (*awss3.PutObjectOutput, error) {
+	c.pay(in.RequestPayer)
+	data, err := io.ReadAll(in.Body)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.puts = append(c.puts, in)
+	c.objects[aws.ToString(in.Bucket)+"/"+aws.ToString(in.Key)] = data
+	return &awss3.PutObjectOutput{}, nil
+}
+
+func (c *fakeClient) CreateMultipartUpload(_ context.Context, in *awss3.CreateMultipartUploadInput, _ ...func(*awss3.Options)) (*awss3.CreateMultipartUploadOutput, error) {
+	c.pay(in.RequestPayer)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.uploads = append(c.uploads, in)
+	id := strconv.Itoa(len(c.uploads))
+	c.parts[id] = make(map[int32][]byte)
+	return &awss3.CreateMultipartUploadOutput{UploadId: aws.String(id)}, nil
+}
+
+func (c *fakeClient) UploadPart(_ context.Context, in *awss3.UploadPartInput, _ ...func(*awss3.Options)) (*awss3.UploadPartOutput, error) {
+	c.pay(in.RequestPayer)
+	data, err := io.ReadAll(in.Body)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.parts[aws.ToString(in.UploadId)][in.PartNumber] = data
+	return &awss3.UploadPartOutput{ETag: aws.String(strconv.Itoa(int(in.PartNumber)))}, nil
+}
+
+func (c *fakeClient) CompleteMultipartUpload(_ context.Context, in *awss3.CompleteMultipartUploadInput, _ ...func(*awss3.Options)) (*awss3.CompleteMultipartUploadOutput, error) {
+	c.pay(in.RequestPayer)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var data []byte
+	for _, p := range in.MultipartUpload.Parts {
+		data = append(data, c.parts[aws.ToString(in.UploadId)][p.PartNumber]...)
+	}
+	c.objects[aws.ToString(in.Bucket)+"/"+aws.ToString(in.Key)] = data
+	return &awss3.CompleteMultipartUploadOutput{}, nil
+}
+
+func (c *fakeClient) AbortMultipartUpload(_ context.Context, in *awss3.AbortMultipartUploadInput, _ ...func(*awss3.Options)) (*awss3.AbortMultipartUploadOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.parts, aws.ToString(in.UploadId))
+	return &awss3.AbortMultipartUploadOutput{}, nil
+}
+
+func (c *fakeClient) ListObjectsV2(_ context.Context, in *awss3.ListObjectsV2Input, _ ...func(*awss3.Options)) (*awss3.ListObjectsV2Output, error) {
+	c.pay(in.RequestPayer)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var keys []string
+	prefix := aws.ToString(in.Bucket) + "/" + aws.ToString(in.Prefix)
+	for k := range c.objects {
+		if strings.HasPrefix(k, prefix) && k > aws.ToString(in.Bucket)+"/"+aws.ToString(in.ContinuationToken) {
+			keys = append(keys, strings.TrimPrefix(k, aws.ToString(in.Bucket)+"/"))
+		}
+	}
+	sort.Strings(keys)
+	out := &awss3.ListObjectsV2Output{}
+	if len(keys) > 2 {
+		keys = keys[:2]
+		out.IsTruncated = true
+		out.NextContinuationToken = aws.String(keys[1])
+	}
+	for _, k := range keys {
+		out.Contents = append(out.Contents, types.Object{Key: aws.String(k)})
+	}
+	return out, nil
+}
+
+func (c *fakeClient) GetObject(_ context.Context, in *awss3.GetObjectInput, _ ...func(*awss3.Options)) (*awss3.GetObjectOutput, error) {
+	c.pay(in.RequestPayer)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	data, ok := c.objects[aws.ToString(in.Bucket)+"/"+aws.ToString(in.Key)]
+	if !ok {
+		return nil, notFound(in.Bucket, in.Key)
+	}
+	return &awss3.GetObjectOutput{Body: io.NopCloser(bytes.NewReader(data)), ContentLength: int64(len(data))}, nil
+}
+
+func (c *fakeClient) HeadObject(_ context.Context, in *awss3.HeadObjectInput, _ ...func(*awss3.Options)) (*awss3.HeadObjectOutput, error) {
+	c.pay(in.RequestPayer)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	data, ok := c.objects[aws.ToString(in.Bucket)+"/"+aws.ToString(in.Key)]
+	if !ok {
+		return nil, notFound(in.Bucket, in.Key)
+	}
+	return &awss3.HeadObjectOutput{ContentLength: int64(len(data))}, nil
+}
+
+func (c *fakeClient) DeleteObject(_ context.Context, in *awss3.DeleteObjectInput, _ ...func(*awss3.Options)) (*awss3.DeleteObjectOutput, error) {
+	c.pay(in.RequestPayer)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.objects, aws.ToString(in.Bucket)+"/"+aws.ToString(in.Key))
+	return &awss3.DeleteObjectOutput{}, nil
+}
+
+func (c *fakeClient) CopyObject(_ context.Context, in *awss3.CopyObjectInput, _ ...func(*awss3.Options)) (*awss3.CopyObjectOutput, error) {
+	c.pay(in.RequestPayer)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.copies = append(c.copies, in)
+	data, ok := c.objects[aws.ToString(in.CopySource)]
+	if !ok {
+		return nil, &smithy.GenericAPIError{Code: "NoSuchKey", Message: aws.ToString(in.CopySource)}
+	}
+	c.objects[aws.ToString(in.Bucket)+"/"+aws.ToString(in.Key)] = data
+	return &awss3.CopyObjectOutput{}, nil
+}
+
+// testOptions returns the default options.
+func testOptions() options {
+	return options{PartSize: manager.DefaultUploadPartSize, UploadConcurrency: manager.DefaultUploadConcurrency}
+}
+
+func TestS3_FilesystemNew(t *testing.T) {
+	ctx := context.Background()
+	path := "s3://tmp/"
+	c, err := filesystem.New(ctx, path)
+	if err != nil {
+		t.Errorf("filesystem.New(ctx, %q) = %v, want nil", path, err)
+	}
+	if _, ok := c.(*fs); !ok {
+		t.Errorf("filesystem.New(ctx, %q) type = %T, want *s3.fs", path, c)
+	}
+	if err := c.Close(); err != nil {
+		t.Errorf("c.Close() = %v, want nil", err)
+	}
+}
+
+func TestS3_List(t *testing.T) {
+	c := newFakeClient(map[string]string{
+		"b/dir/a.txt": "a", "b/dir/b.txt": "b", "b/dir/c.csv": "c", "b/dir/d.txt": "d", "b/other/e.txt": "e",
+	})
+	f := newFS(c, testOptions())
+	ctx := context.Background()
+
+	got, err := f.List(ctx, "s3://b/dir/*.txt")
+	if err != nil {
+		t.Fatalf("List() failed: %v", err)
+	}
+	want := []string{"s3://b/dir/a.txt", "s3://b/dir/b.txt", "s3://b/dir/d.txt"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("List() = %v, want %v", got, want)
+	}
+
+	// Paths without globs aren't listed.
+	if got, err := f.List(ctx, "s3://b/dir/a.txt"); err != nil || !reflect.DeepEqual(got, []string{"s3://b/dir/a.txt"}) {
+		t.Errorf("List() of a single object = %v, %v, want the object", got, err)
+	}
+	if _, err := f.List(ctx, "gs://b/dir/*"); err == nil {
+		t.Error("List() of a gs:// path succeeded, want error")
+	}
+}
+
+func TestS3_ReadWrite(t *testing.T) {
+	c := newFakeClient(nil)
+	f := newFS(c, testOptions())
+	ctx := context.Background()
+
+	w, err := f.OpenWrite(ctx, "s3://b/dir/obj")
+	if err != nil {
+		t.Fatalf("OpenWrite() failed: %v", err)
+	}
+	if _, err := io.WriteString(w, "hello"); err != nil {
+		t.Fatalf("Write() failed: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close() failed: %v", err)
+	}
+
+	if size, err := f.Size(ctx, "s3://b/dir/obj"); err != nil || size != 5 {
+		t.Errorf("Size() = %v, %v, want 5", size, err)
+	}
+	r, err := f.OpenRead(ctx, "s3://b/dir/obj")
+	if err != nil {
+		t.Fatalf("OpenRead() failed: %v", err)
+	}
+	defer r.Close()
+	if data, err := io.ReadAll(r); err != nil || string(data) != "hello" {
+		t.Errorf("OpenRead() read %q, %v, want %q", data, err, "hello")
+	}
+
+	if err := f.Copy(ctx, "s3://b/dir/obj", "s3://b/copy"); err != nil {
+		t.Fatalf("Copy() failed: %v", err)
+	}
+	if err := f.Remove(ctx, "s3://b/dir/obj"); err != nil {
+		t.Fatalf("Remove() failed: %v", err)
+	}
+	if _, err := f.Size(ctx, "s3://b/dir/obj"); err == nil {
+		t.Error("Size() of a removed object succeeded, want error")
+	}
+	if size, err := f.Size(ctx, "s3://b/copy"); err != nil || size != 5 {
+		t.Errorf("Size() of copy = %v, %v, want 5", size, err)
+	}
+}
+
+func TestS3_Multipart(t *testing.T) {
+	c := newFakeClient(nil)
+	opts := testOptions()
+	opts.UploadConcurrency = 2
+	f := newFS(c, opts)
+	ctx := context.Background()
+
+	// Objects larger than a part are uploaded in parts.
+	data := bytes.Repeat([]byte("0123456789"), int(opts.PartSize)*25/100)
+	w, err := f.OpenWrite(ctx, "s3://b/big")
+	if err != nil {
+		t.Fatalf("OpenWrite() failed: %v", err)
+	}
+	if _, err := w.Write(data); err != nil {
+		t.Fatalf("Write() failed: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close() failed: %v", err)
+	}
+	if len(c.puts) != 0 || len(c.uploads) != 1 {
+		t.Fatalf("OpenWrite() made %v single and %v multipart uploads, want 0 and 1", len(c.puts), len(c.uploads))
+	}
+	if got, want := len(c.parts["1"]), 3; got != want {
+		t.Errorf("OpenWrite() uploaded %v parts, want %v", got, want)
+	}
+	if !bytes.Equal(c.objects["b/big"], data) {
+		t.Errorf("OpenWrite() wrote %v bytes, want the %v bytes written", len(c.objects["b/big"]), len(data))
+	}
+}
+
+func TestS3_Encryption(t *testing.T) {
+	c := newFakeClient(map[string]string{"b/src": "x"})
+	opts := testOptions()
+	opts.SSE = types.ServerSideEncryptionAwsKms
+	opts.SSEKMSKeyID = "key"
+	f := newFS(c, opts)
+	ctx := context.Background()
+
+	w, err := f.OpenWrite(ctx, "s3://b/obj")
+	if err != nil {
+		t.Fatalf("OpenWrite() failed: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close() failed: %v", err)
+	}
+	if err := f.Copy(ctx, "s3://b/src", "s3://b/dst"); err != nil {
+		t.Fatalf("Copy() failed: %v", err)
+	}
+
+	put, cp := c.puts[0], c.copies[0]
+	if put.ServerSideEncryption != opts.SSE || aws.ToString(put.SSEKMSKeyId) != "key" {
+		t.Errorf("OpenWrite() encryption = %v, %v, want %v, key", put.ServerSideEncryption, aws.ToString(put.SSEKMSKeyId), opts.SSE)
+	}
+	if cp.ServerSideEncryption != opts.SSE || aws.ToString(cp.SSEKMSKeyId) != "key" {
+		t.Errorf("Copy() encryption = %v, %v, want %v, key", cp.ServerSideEncryption, aws.ToString(cp.SSEKMSKeyId), opts.SSE)
+	}
+}
+
+func TestS3_RequesterPays(t *testing.T) {
+	c := newFakeClient(map[string]string{"b/a": "a"})
+	opts := testOptions()
+	opts.RequesterPays = true
+	f := newFS(c, opts)
+	ctx := context.Background()
+
+	if _, err := f.List(ctx, "s3://b/*"); err != nil {
+		t.Fatalf("List() failed: %v", err)
+	}
+	if _, err := f.Size(ctx, "s3://b/a"); err != nil {
+		t.Fatalf("Size() failed: %v", err)
+	}
+	if err := f.Remove(ctx, "s3://b/a"); err != nil {
+		t.Fatalf("Remove() failed: %v", err)
+	}
+	for _, p := range c.payers {
+		if p != types.RequestPayerRequester {
+			t.Errorf("request payers = %v, want all %v", c.payers, types.RequestPayerRequester)
+			break
+		}
+	}
+}
+
+func init() {
+	filesystem.RegisterCredentialsProvider("s3static", func(_ context.Context, args []string) (interface{}, error) {
+		return credentials.NewStaticCredentialsProvider(args[0], args[1], ""), nil
+	})
+}
+
+func TestNewWithConfig(t *testing.T) {
+	defer resetFlags(t)
+	for k, v := range map[string]string{"s3_region": "eu-west-1", "s3_path_style": "true"} {
+		if err := flag.Set(k, v); err != nil {
+			t.Fatalf("setting --%v: %v", k, err)
+		}
+	}
+	// The server serves objects of 4 bytes, and records the authorization
+	// of requests.
+	var authorization string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization = r.Header.Get("Authorization")
+		w.Header().Set("Content-Length", "4")
+	}))
+	defer ts.Close()
+	ctx := context.Background()
+
+	cfg := filesystem.Config{
+		Endpoint:        ts.URL,
+		Credentials:     "s3static",
+		CredentialsArgs: []string{"id", "secret"},
+		Retry:           &filesystem.RetryPolicy{MaxAttempts: 1},
+	}
+	f, err := NewWithConfig(ctx, cfg)
+	if err != nil {
+		t.Fatalf("NewWithConfig() failed: %v", err)
+	}
+	if size, err := f.Size(ctx, "s3://bucket/key"); err != nil || size != 4 {
+		t.Errorf("Size() = %v, %v, want 4", size, err)
+	}
+	if !strings.Contains(authorization, "Credential=id/") {
+		t.Errorf("Size() was authorized with %q, want credentials of id", authorization)
+	}
+}
+
+func TestFlagOptions(t *testing.T) {
+	tests := []struct {
+		name    string
+		flags   map[string]string
+		want    options
+		wantErr bool
+	}{
+		{"defaults", nil, testOptions(), false},
+		{"SSE-S3", map[string]string{"s3_sse": "sse-s3"}, options{SSE: types.ServerSideEncryptionAes256}, false},
+		{"SSE-KMS", map[string]string{"s3_sse": "aws:kms", "s3_sse_kms_key_id": "k"}, options{SSE: types.ServerSideEncryptionAwsKms, SSEKMSKeyID: "k"}, false},
+		{"invalid SSE", map[string]string{"s3_sse": "none"}, options{}, true},
+		{"KMS key without SSE-KMS", map[string]string{"s3_sse_kms_key_id": "k"}, options{}, true},
+		{"small part size", map[string]string{"s3_part_size": "1024"}, options{}, true},
+		{"zero concurrency", map[string]string{"s3_upload_concurrency": "0"}, options{}, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			defer resetFlags(t)
+			for k, v := range test.flags {
+				if err := flag.Set(k, v); err != nil {
+					t.Fatalf("setting --%v: %v", k, err)
+				}
+			}
+			got, err := flagOptions()
+			if (err != nil) != test.wantErr {
+				t.Fatalf("flagOptions() = %v, want error %v", err, test.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			want := test.want
+			want.PartSize, want.UploadConcurrency = testOptions().PartSize, testOptions().UploadConcurrency
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("flagOptions() = %+v, want %+v", got, want)
+			}
+		})
+	}
+}
+
+// resetFlags resets the s3_* flags to their defaults.
+func resetFlags(t *testing.T) {
+	flag.VisitAll(func(f *flag.Flag) {
+		if strings.HasPrefix(f.Name, "s3_") {
+			if err := f.Value.Set(f.DefValue); err != nil {
+				t.Errorf("resetting --%v: %v", f.Name, err)
+			}
+		}
+	})
+}
@@ -0,0 +1,358 @@
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements.  See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License.  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package firestoreio
+
+import (
+	"math"
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/internal/errors"
+	firestorepb "google.golang.org/genproto/googleapis/firestore/v1"
+	"google.golang.org/genproto/googleapis/type/latlng"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// nameField is the name of the field that holds the name of the document.
+const nameField = "__name__"
+
+var (
+	timeType   = reflect.TypeOf(time.Time{})
+	latLngType = reflect.TypeOf((*latlng.LatLng)(nil))
+
+	simplePathRegexp = regexp.MustCompile(`^[_a-zA-Z][_a-zA-Z0-9]*$`)
+)
+
+// fieldName returns the Firestore field of the struct field, which is named
+// by its firestore tag or its name, or "" if the field isn't stored.
+func fieldName(f reflect.StructField) string {
+	if f.PkgPath != "" {
+		return "" // unexported
+	}
+	name := f.Tag.Get("firestore")
+	if i := strings.IndexByte(name, ','); i >= 0 {
+		name = name[:i]
+	}
+	if name == "-" {
+		return ""
+	}
+	if name == "" {
+		name = f.Name
+	}
+	return name
+}
+
+// quotePath returns the field name as a field path, quoted with backticks if
+// it isn't a simple name.
+func quotePath(name string) string {
+	if simplePathRegexp.MatchString(name) {
+		return name
+	}
+	return "`" + strings.NewReplacer(`\`, `\\`, "`", "\\`").Replace(name) + "`"
+}
+
+// decodeDocument decodes the document into a new value of the struct type.
+// Fields of the document without a struct field are ignored.
+func decodeDocument(doc *firestorepb.Document, t reflect.Type) (reflect.Value, error) {
+	v := reflect.New(t).Elem()
+	for i := 0; i < t.NumField(); i++ {
+		name := fieldName(t.Field(i))
+		if name == nameField {
+			if v.Field(i).Kind() != reflect.String {
+				return reflect.Value{}, errors.Errorf("field %v of %v must be a string to hold the document name", t.Field(i).Name, t)
+			}
+			v.Field(i).SetString(doc.GetName())
+		}
+	}
+	if err := decodeFields(doc.GetFields(), v); err != nil {
+		return reflect.Value{}, errors.WithContextf(err, "decoding document %v", doc.GetName())
+	}
+	return v, nil
+}
+
+// decodeFields decodes the fields into the struct.
+func decodeFields(fields map[string]*firestorepb.Value, dst reflect.Value) error {
+	t := dst.Type()
+	for i := 0; i < t.NumField(); i++ {
+		name := fieldName(t.Field(i))
+		v, ok := fields[name]
+		if name == "" || name == nameField || !ok {
+			continue
+		}
+		if err := decodeValue(v, dst.Field(i)); err != nil {
+			return errors.WithContextf(err, "decoding field %v", name)
+		}
+	}
+	return nil
+}
+
+// decodeValue decodes the Firestore value into dst. Null values decode as
+// the zero value. Values decode into empty interfaces as bool, int64,
+// float64, time.Time, string, []byte, *latlng.LatLng, []interface{} or
+// map[string]interface{}.
+func decodeValue(v *firestorepb.Value, dst reflect.Value) error {
+	if _, ok := v.GetValueType().(*firestorepb.Value_NullValue); ok || v.GetValueType() == nil {
+		dst.Set(reflect.Zero(dst.Type()))
+		return nil
+	}
+	if dst.Kind() == reflect.Interface && dst.NumMethod() == 0 {
+		elem := reflect.New(naturalType(v)).Elem()
+		if err := decodeValue(v, elem); err != nil {
+			return err
+		}
+		dst.Set(elem)
+		return nil
+	}
+	if dst.Kind() == reflect.Ptr && dst.Type() != latLngType {
+		elem := reflect.New(dst.Type().Elem())
+		if err := decodeValue(v, elem.Elem()); err != nil {
+			return err
+		}
+		dst.Set(elem)
+		return nil
+	}
+
+	switch val := v.GetValueType().(type) {
+	case *firestorepb.Value_BooleanValue:
+		if dst.Kind() == reflect.Bool {
+			dst.SetBool(val.BooleanValue)
+			return nil
+		}
+	case *firestorepb.Value_IntegerValue:
+		n := val.IntegerValue
+		switch dst.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			if dst.OverflowInt(n) {
+				return errors.Errorf("integer %v overflows %v", n, dst.Type())
+			}
+			dst.SetInt(n)
+			return nil
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			if n < 0 || dst.OverflowUint(uint64(n)) {
+				return errors.Errorf("integer %v overflows %v", n, dst.Type())
+			}
+			dst.SetUint(uint64(n))
+			return nil
+		case reflect.Float32, reflect.Float64:
+			dst.SetFloat(float64(n))
+			return nil
+		}
+	case *firestorepb.Value_DoubleValue:
+		switch dst.Kind() {
+		case reflect.Float32, reflect.Float64:
+			dst.SetFloat(val.DoubleValue)
+			return nil
+		}
+	case *firestorepb.Value_TimestampValue:
+		if dst.Type() == timeType {
+			dst.Set(reflect.ValueOf(val.TimestampValue.AsTime()))
+			return nil
+		}
+	case *firestorepb.Value_StringValue:
+		if dst.Kind() == reflect.String {
+			dst.SetString(val.StringValue)
+			return nil
+		}
+	case *firestorepb.Value_ReferenceValue:
+		if dst.Kind() == reflect.String {
+			dst.SetString(val.ReferenceValue)
+			return nil
+		}
+	case *firestorepb.Value_BytesValue:
+		if dst.Kind() == reflect.Slice && dst.Type().Elem().Kind() == reflect.Uint8 {
+			dst.SetBytes(append([]byte(nil), val.BytesValue...))
+			return nil
+		}
+	case *firestorepb.Value_GeoPointValue:
+		if dst.Type() == latLngType {
+			dst.Set(reflect.ValueOf(&latlng.LatLng{Latitude: val.GeoPointValue.GetLatitude(), Longitude: val.GeoPointValue.GetLongitude()}))
+			return nil
+		}
+	case *firestorepb.Value_ArrayValue:
+		if dst.Kind() == reflect.Slice {
+			vs := val.ArrayValue.GetValues()
+			s := reflect.MakeSlice(dst.Type(), len(vs), len(vs))
+			for i, ev := range vs {
+				if err := decodeValue(ev, s.Index(i)); err != nil {
+					return err
+				}
+			}
+			dst.Set(s)
+			return nil
+		}
+	case *firestorepb.Value_MapValue:
+		fields := val.MapValue.GetFields()
+		switch {
+		case dst.Kind() == reflect.Struct:
+			return decodeFields(fields, dst)
+		case dst.Kind() == reflect.Map && dst.Type().Key().Kind() == reflect.String:
+			m := reflect.MakeMapWithSize(dst.Type(), len(fields))
+			for k, ev := range fields {
+				elem := reflect.New(dst.Type().Elem()).Elem()
+				if err := decodeValue(ev, elem); err != nil {
+					return errors.WithContextf(err, "decoding key %v", k)
+				}
+				m.SetMapIndex(reflect.ValueOf(k).Convert(dst.Type().Key()), elem)
+			}
+			dst.Set(m)
+			return nil
+		}
+	}
+	return errors.Errorf("can't decode %T into %v", v.GetValueType(), dst.Type())
+}
+
+// naturalType returns the type a value decodes as into an empty interface.
+func naturalType(v *firestorepb.Value) reflect.Type {
+	switch v.GetValueType().(type) {
+	case *firestorepb.Value_BooleanValue:
+		return reflect.TypeOf(false)
+	case *firestorepb.Value_IntegerValue:
+		return reflect.TypeOf(int64(0))
+	case *firestorepb.Value_DoubleValue:
+		return reflect.TypeOf(float64(0))
+	case *firestorepb.Value_TimestampValue:
+		return timeType
+	case *firestorepb.Value_BytesValue:
+		return reflect.TypeOf([]byte(nil))
+	case *firestorepb.Value_GeoPointValue:
+		return latLngType
+	case *firestorepb.Value_ArrayValue:
+		return reflect.TypeOf([]interface{}(nil))
+	case *firestorepb.Value_MapValue:
+		return reflect.TypeOf(map[string]interface{}(nil))
+	default:
+		return reflect.TypeOf("")
+	}
+}
+
+// encodeFields encodes the struct or map as the fields of a document, as
+// decoded by decodeFields. The document name field of a struct is omitted.
+func encodeFields(v reflect.Value) (map[string]*firestorepb.Value, error) {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return nil, errors.New("can't encode nil as a document")
+		}
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct && !(v.Kind() == reflect.Map && v.Type().Key().Kind() == reflect.String) {
+		return nil, errors.Errorf("can't encode %v as a document, want a struct or a map with string keys", v.Type())
+	}
+	ev, err := encodeValue(v)
+	if err != nil {
+		return nil, err
+	}
+	return ev.GetMapValue().GetFields(), nil
+}
+
+// encodeValue encodes the Go value as a Firestore value, as decoded by
+// decodeValue.
+func encodeValue(v reflect.Value) (*firestorepb.Value, error) {
+	if !v.IsValid() {
+		return nullValue(), nil
+	}
+	switch v.Type() {
+	case timeType:
+		return &firestorepb.Value{ValueType: &firestorepb.Value_TimestampValue{TimestampValue: timestamppb.New(v.Interface().(time.Time))}}, nil
+	case latLngType:
+		if v.IsNil() {
+			return nullValue(), nil
+		}
+		ll := v.Interface().(*latlng.LatLng)
+		return &firestorepb.Value{ValueType: &firestorepb.Value_GeoPointValue{GeoPointValue: &latlng.LatLng{Latitude: ll.GetLatitude(), Longitude: ll.GetLongitude()}}}, nil
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return nullValue(), nil
+		}
+		return encodeValue(v.Elem())
+	case reflect.Bool:
+		return &firestorepb.Value{ValueType: &firestorepb.Value_BooleanValue{BooleanValue: v.Bool()}}, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return &firestorepb.Value{ValueType: &firestorepb.Value_IntegerValue{IntegerValue: v.Int()}}, nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if v.Uint() > math.MaxInt64 {
+			return nil, errors.Errorf("integer %v overflows a Firestore integer", v.Uint())
+		}
+		return &firestorepb.Value{ValueType: &firestorepb.Value_IntegerValue{IntegerValue: int64(v.Uint())}}, nil
+	case reflect.Float32, reflect.Float64:
+		return &firestorepb.Value{ValueType: &firestorepb.Value_DoubleValue{DoubleValue: v.Float()}}, nil
+	case reflect.String:
+		return &firestorepb.Value{ValueType: &firestorepb.Value_StringValue{StringValue: v.String()}}, nil
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			return nullValue(), nil
+		}
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			b := make([]byte, v.Len())
+			reflect.Copy(reflect.ValueOf(b), v)
+			return &firestorepb.Value{ValueType: &firestorepb.Value_BytesValue{BytesValue: b}}, nil
+		}
+		array := &firestorepb.ArrayValue{Values: make([]*firestorepb.Value, v.Len())}
+		for i := 0; i < v.Len(); i++ {
+			ev, err := encodeValue(v.Index(i))
+			if err != nil {
+				return nil, err
+			}
+			array.Values[i] = ev
+		}
+		return &firestorepb.Value{ValueType: &firestorepb.Value_ArrayValue{ArrayValue: array}}, nil
+	case reflect.Map:
+		if v.Type().Key().Kind() != reflect.String {
+			break
+		}
+		if v.IsNil() {
+			return nullValue(), nil
+		}
+		fields := make(map[string]*firestorepb.Value, v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			ev, err := encodeValue(iter.Value())
+			if err != nil {
+				return nil, errors.WithContextf(err, "encoding key %v", iter.Key())
+			}
+			fields[iter.Key().String()] = ev
+		}
+		return mapValue(fields), nil
+	case reflect.Struct:
+		fields := make(map[string]*firestorepb.Value)
+		for i := 0; i < v.NumField(); i++ {
+			name := fieldName(v.Type().Field(i))
+			if name == "" || name == nameField {
+				continue
+			}
+			ev, err := encodeValue(v.Field(i))
+			if err != nil {
+				return nil, errors.WithContextf(err, "encoding field %v", name)
+			}
+			fields[name] = ev
+		}
+		return mapValue(fields), nil
+	}
+	return nil, errors.Errorf("can't encode %v as a Firestore value", v.Type())
+}
+
+func nullValue() *firestorepb.Value {
+	return &firestorepb.Value{ValueType: &firestorepb.Value_NullValue{NullValue: structpb.NullValue_NULL_VALUE}}
+}
+
+func mapValue(fields map[string]*firestorepb.Value) *firestorepb.Value {
+	return &firestorepb.Value{ValueType: &firestorepb.Value_MapValue{MapValue: &firestorepb.MapValue{Fields: fields}}}
+}
@@ -0,0 +1,82 @@
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements.  See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License.  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package firestoreio provides transformations and utilities to interact with
+// Google Cloud Firestore in Native mode. See also:
+// https://cloud.google.com/firestore/docs.
+//
+// Databases are named as "projects/<project>/databases/<database>", where the
+// database is usually "(default)", and documents as
+// "projects/<project>/databases/<database>/documents/<collection>/<id>", with
+// any number of further "/<collection>/<id>" pairs for documents of
+// subcollections.
+//
+// Documents are read into, and written from, structs whose fields are named
+// by their firestore tag or their name, as with the Firestore client library.
+// A string field tagged `firestore:"__name__"` holds the name of the document.
+//
+// The transforms use the Firestore API directly. If the
+// FIRESTORE_EMULATOR_HOST environment variable is set, they connect to the
+// emulator at that address instead.
+package firestoreio
+
+import (
+	"context"
+	"regexp"
+
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/internal/errors"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/io/internal/gcpx"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+)
+
+const (
+	// emulatorHostEnv is the environment variable with the address of the
+	// Firestore emulator, if any.
+	emulatorHostEnv = "FIRESTORE_EMULATOR_HOST"
+	endpoint        = "firestore.googleapis.com:443"
+	dataScope       = "https://www.googleapis.com/auth/datastore"
+)
+
+var (
+	databaseRegexp = regexp.MustCompile(`^projects/[^/]+/databases/[^/]+$`)
+	documentRegexp = regexp.MustCompile(`^projects/[^/]+/databases/[^/]+/documents/[^/]+/[^/]+(/[^/]+/[^/]+)*$`)
+)
+
+// mustParseDatabase panics if the database name is invalid.
+func mustParseDatabase(db string) {
+	if !databaseRegexp.MatchString(db) {
+		panic(errors.Errorf("invalid database %q, want projects/<project>/databases/<database>", db))
+	}
+}
+
+// dial connects to Firestore, or to the emulator if FIRESTORE_EMULATOR_HOST
+// is set.
+func dial(ctx context.Context) (*grpc.ClientConn, error) {
+	return gcpx.Dial(ctx, emulatorHostEnv, endpoint, dataScope)
+}
+
+// withDatabase returns a context for requests to the database, which
+// Firestore routes by the resource prefix header.
+func withDatabase(ctx context.Context, db string) context.Context {
+	return metadata.AppendToOutgoingContext(ctx, "google-cloud-resource-prefix", db)
+}
+
+// isRetryable returns whether the request that failed with the error can be
+// retried.
+func isRetryable(err error) bool {
+	return gcpx.IsRetryable(err, codes.ResourceExhausted, codes.Internal)
+}
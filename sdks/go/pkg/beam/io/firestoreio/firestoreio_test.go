@@ -0,0 +1,373 @@
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements.  See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License.  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package firestoreio
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/apache/beam/sdks/v2/go/pkg/beam"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/testing/passert"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/testing/ptest"
+	firestorepb "google.golang.org/genproto/googleapis/firestore/v1"
+	"google.golang.org/genproto/googleapis/type/latlng"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func TestMain(m *testing.M) {
+	ptest.Main(m)
+}
+
+const testDB = "projects/p/databases/(default)"
+
+// testReadTime is the time the fake server reads documents at.
+var testReadTime = time.Date(2022, 5, 1, 10, 0, 0, 0, time.UTC)
+
+// fakeServer is an in-memory Firestore API server, which serves the same
+// documents for all queries.
+type fakeServer struct {
+	firestorepb.UnimplementedFirestoreServer
+
+	mu sync.Mutex
+	// docs is the documents, ordered by name.
+	docs []*firestorepb.Document
+	// partitions is the partition requests, and queries the query requests.
+	partitions []*firestorepb.PartitionQueryRequest
+	queries    []*firestorepb.RunQueryRequest
+	// failures is the number of queries to fail with Unavailable after
+	// their first document.
+	failures int
+	// prefixes is the resource prefix headers of the requests.
+	prefixes []string
+
+	// written is the successful writes, and batches the writes of each
+	// batch request. Writes of documents of the collection "bad" fail, and
+	// the first unavailable batch requests fail with Unavailable.
+	written     []*firestorepb.Write
+	batches     [][]*firestorepb.Write
+	unavailable int
+}
+
+// startFakeServer starts a fake server with the documents, and points the
+// transforms at it.
+func startFakeServer(t *testing.T, docs ...*firestorepb.Document) *fakeServer {
+	t.Helper()
+	lis, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	fake := &fakeServer{docs: docs}
+	srv := grpc.NewServer()
+	firestorepb.RegisterFirestoreServer(srv, fake)
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+	t.Setenv(emulatorHostEnv, lis.Addr().String())
+	return fake
+}
+
+func (f *fakeServer) addPrefix(ctx context.Context) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	f.prefixes = append(f.prefixes, md.Get("google-cloud-resource-prefix")...)
+}
+
+// PartitionQuery returns cursors at the second and fourth documents, in two
+// pages, in reverse order.
+func (f *fakeServer) PartitionQuery(ctx context.Context, req *firestorepb.PartitionQueryRequest) (*firestorepb.PartitionQueryResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.addPrefix(ctx)
+	f.partitions = append(f.partitions, req)
+	cursor := func(doc *firestorepb.Document) *firestorepb.Cursor {
+		return &firestorepb.Cursor{Values: []*firestorepb.Value{
+			{ValueType: &firestorepb.Value_ReferenceValue{ReferenceValue: doc.GetName()}},
+		}}
+	}
+	if req.GetPageToken() == "" {
+		return &firestorepb.PartitionQueryResponse{Partitions: []*firestorepb.Cursor{cursor(f.docs[3])}, NextPageToken: "next"}, nil
+	}
+	return &firestorepb.PartitionQueryResponse{Partitions: []*firestorepb.Cursor{cursor(f.docs[1])}}, nil
+}
+
+// RunQuery sends the documents between the cursors of the query, after a
+// response without a document.
+func (f *fakeServer) RunQuery(req *firestorepb.RunQueryRequest, stream firestorepb.Firestore_RunQueryServer) error {
+	f.mu.Lock()
+	f.addPrefix(stream.Context())
+	f.queries = append(f.queries, req)
+	fail := f.failures > 0
+	if fail {
+		f.failures--
+	}
+	f.mu.Unlock()
+
+	q := req.GetStructuredQuery()
+	readTime := timestamppb.New(testReadTime)
+	if err := stream.Send(&firestorepb.RunQueryResponse{ReadTime: readTime}); err != nil {
+		return err
+	}
+	sent := 0
+	for _, doc := range f.docs {
+		if start := q.GetStartAt(); start != nil && compareNames(doc.GetName(), cursorName(start)) < 0 {
+			continue
+		}
+		if end := q.GetEndAt(); end != nil && compareNames(doc.GetName(), cursorName(end)) >= 0 {
+			continue
+		}
+		if fail && sent == 1 {
+			return status.Error(codes.Unavailable, "unavailable")
+		}
+		if err := stream.Send(&firestorepb.RunQueryResponse{Document: doc, ReadTime: readTime}); err != nil {
+			return err
+		}
+		sent++
+	}
+	return nil
+}
+
+type user struct {
+	Name  string   `firestore:"__name__"`
+	ID    int64    `firestore:"id"`
+	Email string   `firestore:"email"`
+	Tags  []string `firestore:"tags"`
+	Admin *bool    `firestore:"admin"`
+	Notes string   `firestore:"-"`
+}
+
+func userName(id string) string {
+	return testDB + "/documents/users/" + id
+}
+
+// userDocs returns documents of users u1 to un, and the users.
+func userDocs(n int) ([]*firestorepb.Document, []interface{}) {
+	var docs []*firestorepb.Document
+	var users []interface{}
+	for i := 1; i <= n; i++ {
+		u := user{ID: int64(i), Email: strings.Repeat("a", i) + "@example.com", Tags: []string{"t"}}
+		u.Name = userName("u" + string(rune('0'+i)))
+		docs = append(docs, Set(u.Name, u).GetUpdate())
+		users = append(users, u)
+	}
+	return docs, users
+}
+
+func TestRead(t *testing.T) {
+	docs, want := userDocs(5)
+	fake := startFakeServer(t, docs...)
+
+	p, s := beam.NewPipelineWithRoot()
+	users := Read(s, testDB, "users", reflect.TypeOf(user{}), ReadPartitions(3))
+	passert.Equals(s, users, want...)
+	ptest.RunAndValidate(t, p)
+
+	if len(fake.partitions) != 2 {
+		t.Fatalf("Read() made %v partition requests, want 2 pages", len(fake.partitions))
+	}
+	if got, want := fake.partitions[0].GetPartitionCount(), int64(2); got != want {
+		t.Errorf("Read() requested %v partition cursors, want %v", got, want)
+	}
+	if len(fake.queries) != 3 {
+		t.Fatalf("Read() made %v queries, want 3", len(fake.queries))
+	}
+	for _, req := range fake.queries {
+		q := req.GetStructuredQuery()
+		if from := q.GetFrom(); len(from) != 1 || from[0].GetCollectionId() != "users" || !from[0].GetAllDescendants() {
+			t.Errorf("Read() queried %v, want collection group users", from)
+		}
+		if order := q.GetOrderBy(); len(order) != 1 || order[0].GetField().GetFieldPath() != nameField {
+			t.Errorf("Read() ordered query by %v, want %v", order, nameField)
+		}
+		for _, c := range []*firestorepb.Cursor{q.GetStartAt(), q.GetEndAt()} {
+			if c != nil && !c.GetBefore() {
+				t.Errorf("Read() partition cursor %v, want before", c)
+			}
+		}
+	}
+	for _, prefix := range fake.prefixes {
+		if prefix != testDB {
+			t.Errorf("Read() requests have resource prefix %v, want %v", prefix, testDB)
+		}
+	}
+}
+
+func TestQuery(t *testing.T) {
+	docs, want := userDocs(3)
+	fake := startFakeServer(t, docs...)
+	q := &firestorepb.StructuredQuery{
+		From: []*firestorepb.StructuredQuery_CollectionSelector{{CollectionId: "users"}},
+		Where: &firestorepb.StructuredQuery_Filter{FilterType: &firestorepb.StructuredQuery_Filter_FieldFilter{
+			FieldFilter: &firestorepb.StructuredQuery_FieldFilter{
+				Field: &firestorepb.StructuredQuery_FieldReference{FieldPath: "id"},
+				Op:    firestorepb.StructuredQuery_FieldFilter_GREATER_THAN,
+				Value: &firestorepb.Value{ValueType: &firestorepb.Value_IntegerValue{IntegerValue: 0}},
+			},
+		}},
+	}
+	readTime := time.Date(2022, 5, 1, 9, 0, 0, 0, time.UTC)
+
+	p, s := beam.NewPipelineWithRoot()
+	users := Query(s, testDB, q, reflect.TypeOf(user{}), ReadTime(readTime))
+	passert.Equals(s, users, want...)
+	ptest.RunAndValidate(t, p)
+
+	if len(fake.partitions) != 0 {
+		t.Errorf("Query() made %v partition requests, want none", len(fake.partitions))
+	}
+	if len(fake.queries) != 1 {
+		t.Fatalf("Query() made %v queries, want 1", len(fake.queries))
+	}
+	if got := fake.queries[0].GetStructuredQuery(); !proto.Equal(got, q) {
+		t.Errorf("Query() ran %v, want %v", got, q)
+	}
+	if got := fake.queries[0].GetReadTime().AsTime(); !got.Equal(readTime) {
+		t.Errorf("Query() read at %v, want %v", got, readTime)
+	}
+}
+
+func TestRead_Retry(t *testing.T) {
+	docs, want := userDocs(3)
+	fake := startFakeServer(t, docs...)
+	fake.failures = 1
+
+	p, s := beam.NewPipelineWithRoot()
+	users := Read(s, testDB, "users", reflect.TypeOf(user{}))
+	passert.Equals(s, users, want...)
+	ptest.RunAndValidate(t, p)
+
+	if len(fake.queries) != 2 {
+		t.Fatalf("Read() made %v queries, want 2", len(fake.queries))
+	}
+	if got := fake.queries[0].GetReadTime(); got != nil {
+		t.Errorf("Read() first read at %v, want the current time", got.AsTime())
+	}
+	if got := fake.queries[1].GetReadTime(); got == nil || !got.AsTime().Equal(testReadTime) {
+		t.Errorf("Read() retried read at %v, want %v", got, testReadTime)
+	}
+}
+
+type address struct {
+	City string `firestore:"city"`
+}
+
+type record struct {
+	Bool     bool                   `firestore:"bool"`
+	Int      int32                  `firestore:"int"`
+	Uint     uint16                 `firestore:"uint"`
+	Float    float64                `firestore:"float"`
+	String   string                 `firestore:"string"`
+	Bytes    []byte                 `firestore:"bytes"`
+	Time     time.Time              `firestore:"time"`
+	Point    *latlng.LatLng         `firestore:"point"`
+	Ints     []int64                `firestore:"ints"`
+	Address  address                `firestore:"address"`
+	Ptr      *address               `firestore:"ptr"`
+	Null     *string                `firestore:"null"`
+	Counts   map[string]int         `firestore:"counts"`
+	Any      map[string]interface{} `firestore:"any"`
+	Default  string
+	Ignored  string `firestore:"-"`
+	internal string
+}
+
+func TestEncodeDecode(t *testing.T) {
+	in := record{
+		Bool:    true,
+		Int:     -3,
+		Uint:    7,
+		Float:   1.5,
+		String:  "s",
+		Bytes:   []byte("b"),
+		Time:    time.Date(2022, 5, 1, 10, 0, 0, 1000, time.UTC),
+		Point:   &latlng.LatLng{Latitude: 1, Longitude: 2},
+		Ints:    []int64{1, 2},
+		Address: address{City: "Paris"},
+		Ptr:     &address{City: "Rome"},
+		Counts:  map[string]int{"a": 1},
+		Any: map[string]interface{}{
+			"int":   int64(1),
+			"list":  []interface{}{"x", true},
+			"map":   map[string]interface{}{"f": 2.5},
+			"null":  nil,
+			"bytes": []byte("b"),
+		},
+		Default:  "d",
+		Ignored:  "i",
+		internal: "x",
+	}
+	fields, err := encodeFields(reflect.ValueOf(&in))
+	if err != nil {
+		t.Fatalf("encodeFields(%v) failed: %v", in, err)
+	}
+	for _, name := range []string{"Ignored", "internal"} {
+		if _, ok := fields[name]; ok {
+			t.Errorf("encodeFields(%v) encoded field %v", in, name)
+		}
+	}
+	got, err := decodeDocument(&firestorepb.Document{Name: "n", Fields: fields}, reflect.TypeOf(record{}))
+	if err != nil {
+		t.Fatalf("decodeDocument() failed: %v", err)
+	}
+	want := in
+	want.Ignored, want.internal = "", ""
+	if !reflect.DeepEqual(got.Interface(), want) {
+		t.Errorf("decodeDocument(encodeFields(%v)) = %v, want %v", in, got.Interface(), want)
+	}
+}
+
+func TestDecodeValue_Invalid(t *testing.T) {
+	tests := []struct {
+		v   *firestorepb.Value
+		dst interface{}
+	}{
+		{&firestorepb.Value{ValueType: &firestorepb.Value_IntegerValue{IntegerValue: 300}}, int8(0)},
+		{&firestorepb.Value{ValueType: &firestorepb.Value_IntegerValue{IntegerValue: -1}}, uint(0)},
+		{&firestorepb.Value{ValueType: &firestorepb.Value_StringValue{StringValue: "s"}}, 0},
+		{&firestorepb.Value{ValueType: &firestorepb.Value_DoubleValue{DoubleValue: 1}}, int64(0)},
+	}
+	for _, test := range tests {
+		dst := reflect.New(reflect.TypeOf(test.dst)).Elem()
+		if err := decodeValue(test.v, dst); err == nil {
+			t.Errorf("decodeValue(%v) into %T succeeded, want error", test.v, test.dst)
+		}
+	}
+}
+
+func TestCompareNames(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"c/a", "c/b", -1},
+		{"c/a/s/x", "c/a", 1},
+		{"c/a/s/x", "c/a-b", -1}, // "/" sorts before "-" as a separator.
+		{"c/a", "c/a", 0},
+	}
+	for _, test := range tests {
+		got := compareNames(test.a, test.b)
+		if (got < 0) != (test.want < 0) || (got > 0) != (test.want > 0) {
+			t.Errorf("compareNames(%v, %v) = %v, want %v", test.a, test.b, got, test.want)
+		}
+	}
+}
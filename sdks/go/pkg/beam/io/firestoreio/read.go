@@ -0,0 +1,354 @@
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements.  See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License.  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package firestoreio
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/apache/beam/sdks/v2/go/pkg/beam"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/internal/errors"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/log"
+	firestorepb "google.golang.org/genproto/googleapis/firestore/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// readRetries is the number of times reading a partition is retried after
+// a retryable error, skipping the documents already read.
+const readRetries = 3
+
+func init() {
+	beam.RegisterType(reflect.TypeOf((*partition)(nil)).Elem())
+	beam.RegisterType(reflect.TypeOf((*partitionFn)(nil)).Elem())
+	beam.RegisterType(reflect.TypeOf((*readPartitionFn)(nil)).Elem())
+}
+
+// readOption holds the options of Read and Query.
+type readOption struct {
+	Partitions int64
+	ReadTime   time.Time
+}
+
+// ReadOptionFn is an option for Read and Query.
+type ReadOptionFn func(*readOption)
+
+// ReadPartitions hints the number of partitions the query is read in, which
+// are generated by Firestore and read in parallel. Only collection group
+// queries without filters or sort orders can be partitioned; they are read
+// ordered by document name. Queries are read in a single partition by
+// default.
+func ReadPartitions(n int64) ReadOptionFn {
+	if n < 1 {
+		panic(fmt.Sprintf("firestoreio.ReadPartitions partitions must be positive. Got: %v", n))
+	}
+	return func(o *readOption) {
+		o.Partitions = n
+	}
+}
+
+// ReadTime reads the documents as of the given time, which must be within
+// the version retention period of the database, so that all partitions are
+// read at the same time. By default, each partition is read as of the time
+// its read starts.
+func ReadTime(t time.Time) ReadOptionFn {
+	return func(o *readOption) {
+		o.ReadTime = t
+	}
+}
+
+// Read reads all documents of the collections with the given ID, at any
+// depth, and returns a PCollection<t>. The fields of the documents are
+// decoded into the fields of t.
+func Read(s beam.Scope, db, collection string, t reflect.Type, opts ...ReadOptionFn) beam.PCollection {
+	s = s.Scope("firestoreio.Read")
+	mustParseDatabase(db)
+
+	q := &firestorepb.StructuredQuery{From: []*firestorepb.StructuredQuery_CollectionSelector{
+		{CollectionId: collection, AllDescendants: true},
+	}}
+	return read(s, db, q, t, opts)
+}
+
+// Query runs a query on the documents of the database, and returns a
+// PCollection<t> of its documents. The fields of the documents are decoded
+// into the fields of t.
+func Query(s beam.Scope, db string, q *firestorepb.StructuredQuery, t reflect.Type, opts ...ReadOptionFn) beam.PCollection {
+	s = s.Scope("firestoreio.Query")
+	mustParseDatabase(db)
+
+	return read(s, db, q, t, opts)
+}
+
+func read(s beam.Scope, db string, q *firestorepb.StructuredQuery, t reflect.Type, opts []ReadOptionFn) beam.PCollection {
+	var o readOption
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.Partitions > 1 && len(q.GetOrderBy()) == 0 {
+		// Partition cursors are document names, so partitioned queries
+		// must be ordered by name.
+		q = proto.Clone(q).(*firestorepb.StructuredQuery)
+		q.OrderBy = []*firestorepb.StructuredQuery_Order{{
+			Field:     &firestorepb.StructuredQuery_FieldReference{FieldPath: nameField},
+			Direction: firestorepb.StructuredQuery_ASCENDING,
+		}}
+	}
+	encoded, err := proto.Marshal(q)
+	if err != nil {
+		panic(errors.Wrap(err, "firestoreio: invalid query"))
+	}
+
+	imp := beam.Impulse(s)
+	partitions := beam.ParDo(s, &partitionFn{Database: db, Query: encoded, Options: o}, imp)
+	shuffled := beam.Reshuffle(s, partitions)
+	return beam.ParDo(s,
+		&readPartitionFn{Database: db, Query: encoded, Options: o, Type: beam.EncodedType{T: t}},
+		shuffled,
+		beam.TypeDefinition{Var: beam.XType, T: t},
+	)
+}
+
+// partition is a partition of a query.
+type partition struct {
+	// Start and End are the encoded cursors the partition starts at and ends
+	// before, if any.
+	Start []byte
+	End   []byte
+}
+
+// partitionFn partitions a query into ranges between partition cursors.
+type partitionFn struct {
+	// Database is the name of the database.
+	Database string `json:"database"`
+	// Query is the encoded structured query.
+	Query []byte `json:"query"`
+	// Options specifies the partitioning.
+	Options readOption `json:"options"`
+}
+
+func (f *partitionFn) ProcessElement(ctx context.Context, _ []byte, emit func(partition)) error {
+	if f.Options.Partitions <= 1 {
+		emit(partition{})
+		return nil
+	}
+	var q firestorepb.StructuredQuery
+	if err := proto.Unmarshal(f.Query, &q); err != nil {
+		return errors.Wrap(err, "invalid query")
+	}
+
+	conn, err := dial(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	client := firestorepb.NewFirestoreClient(conn)
+
+	var cursors []*firestorepb.Cursor
+	req := &firestorepb.PartitionQueryRequest{
+		Parent:         f.Database + "/documents",
+		QueryType:      &firestorepb.PartitionQueryRequest_StructuredQuery{StructuredQuery: &q},
+		PartitionCount: f.Options.Partitions - 1,
+	}
+	for {
+		resp, err := client.PartitionQuery(withDatabase(ctx, f.Database), req)
+		if err != nil {
+			return errors.Wrapf(err, "partitioning query of %v", f.Database)
+		}
+		cursors = append(cursors, resp.GetPartitions()...)
+		if resp.GetNextPageToken() == "" {
+			break
+		}
+		req.PageToken = resp.GetNextPageToken()
+	}
+	// Pages of cursors aren't ordered with respect to each other.
+	sort.Slice(cursors, func(i, j int) bool {
+		return compareNames(cursorName(cursors[i]), cursorName(cursors[j])) < 0
+	})
+
+	log.Infof(ctx, "Reading query of %v in %v partitions", f.Database, len(cursors)+1)
+	var start []byte
+	for _, c := range cursors {
+		end, err := proto.Marshal(c)
+		if err != nil {
+			return err
+		}
+		emit(partition{Start: start, End: end})
+		start = end
+	}
+	emit(partition{Start: start})
+	return nil
+}
+
+// cursorName returns the document name of a partition cursor.
+func cursorName(c *firestorepb.Cursor) string {
+	if len(c.GetValues()) == 0 {
+		return ""
+	}
+	return c.GetValues()[0].GetReferenceValue()
+}
+
+// compareNames compares document names by their segments, in the order of
+// Firestore.
+func compareNames(a, b string) int {
+	as, bs := strings.Split(a, "/"), strings.Split(b, "/")
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		if c := strings.Compare(as[i], bs[i]); c != 0 {
+			return c
+		}
+	}
+	return len(as) - len(bs)
+}
+
+// readPartitionFn reads the documents of partitions of a query.
+type readPartitionFn struct {
+	// Database is the name of the database.
+	Database string `json:"database"`
+	// Query is the encoded structured query.
+	Query []byte `json:"query"`
+	// Options specifies the read time.
+	Options readOption `json:"options"`
+	// Type is the type of the documents.
+	Type beam.EncodedType `json:"type"`
+
+	conn   *grpc.ClientConn
+	client firestorepb.FirestoreClient
+	query  *firestorepb.StructuredQuery
+}
+
+func (f *readPartitionFn) Setup(ctx context.Context) error {
+	var q firestorepb.StructuredQuery
+	if err := proto.Unmarshal(f.Query, &q); err != nil {
+		return errors.Wrap(err, "invalid query")
+	}
+	f.query = &q
+
+	conn, err := dial(ctx)
+	if err != nil {
+		return err
+	}
+	f.conn = conn
+	f.client = firestorepb.NewFirestoreClient(conn)
+	return nil
+}
+
+func (f *readPartitionFn) Teardown() error {
+	if f.conn == nil {
+		return nil
+	}
+	return f.conn.Close()
+}
+
+func (f *readPartitionFn) ProcessElement(ctx context.Context, p partition, emit func(beam.X)) error {
+	q := proto.Clone(f.query).(*firestorepb.StructuredQuery)
+	var err error
+	if p.Start != nil {
+		if q.StartAt, err = decodeCursor(p.Start); err != nil {
+			return err
+		}
+	}
+	if p.End != nil {
+		if q.EndAt, err = decodeCursor(p.End); err != nil {
+			return err
+		}
+	}
+
+	var r reader
+	if !f.Options.ReadTime.IsZero() {
+		r.readTime = timestamppb.New(f.Options.ReadTime)
+	}
+	for attempt := 0; ; attempt++ {
+		err = f.read(ctx, q, &r, emit)
+		if err == nil {
+			return nil
+		}
+		if attempt >= readRetries || !isRetryable(err) {
+			return errors.Wrapf(err, "reading partition of query of %v", f.Database)
+		}
+		log.Warnf(ctx, "Retrying read of partition of query of %v after %v documents: %v", f.Database, r.count, err)
+		time.Sleep(time.Duration(1<<attempt) * time.Second)
+	}
+}
+
+// decodeCursor decodes a partition cursor, as a cursor that includes the
+// document it points to.
+func decodeCursor(data []byte) (*firestorepb.Cursor, error) {
+	var c firestorepb.Cursor
+	if err := proto.Unmarshal(data, &c); err != nil {
+		return nil, errors.Wrap(err, "invalid partition cursor")
+	}
+	c.Before = true
+	return &c, nil
+}
+
+// reader is the state of the read of a partition.
+type reader struct {
+	// readTime is the time the partition is read at, which is that of the
+	// first response unless set.
+	readTime *timestamppb.Timestamp
+	// count is the number of documents output.
+	count int
+}
+
+// read runs the query at the read time of the reader, and outputs its
+// documents, skipping those already output.
+func (f *readPartitionFn) read(ctx context.Context, q *firestorepb.StructuredQuery, r *reader, emit func(beam.X)) error {
+	req := &firestorepb.RunQueryRequest{
+		Parent:    f.Database + "/documents",
+		QueryType: &firestorepb.RunQueryRequest_StructuredQuery{StructuredQuery: q},
+	}
+	if r.readTime != nil {
+		req.ConsistencySelector = &firestorepb.RunQueryRequest_ReadTime{ReadTime: r.readTime}
+	}
+	stream, err := f.client.RunQuery(withDatabase(ctx, f.Database), req)
+	if err != nil {
+		return err
+	}
+
+	skip := r.count
+	for {
+		resp, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if r.readTime == nil && resp.GetReadTime() != nil {
+			r.readTime = resp.GetReadTime()
+		}
+		doc := resp.GetDocument()
+		if doc == nil {
+			continue
+		}
+		if skip > 0 {
+			skip--
+			continue
+		}
+		v, err := decodeDocument(doc, f.Type.T)
+		if err != nil {
+			return err
+		}
+		emit(v.Interface())
+		r.count++
+	}
+}
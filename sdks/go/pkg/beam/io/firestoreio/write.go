@@ -0,0 +1,421 @@
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements.  See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License.  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package firestoreio
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/apache/beam/sdks/v2/go/pkg/beam"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/internal/errors"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/log"
+	firestorepb "google.golang.org/genproto/googleapis/firestore/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	defaultBatchSize      = 500 // The maximum of BatchWrite.
+	defaultBatchSizeBytes = 9 << 20
+	defaultMaxRetries     = 5
+	defaultInitialBackoff = 100 * time.Millisecond
+	defaultMaxBackoff     = 10 * time.Second
+)
+
+var failedWriteType = reflect.TypeOf((*FailedWrite)(nil)).Elem()
+
+func init() {
+	beam.RegisterCoder(failedWriteType, encodeFailedWrite, decodeFailedWrite)
+	beam.RegisterType(reflect.TypeOf((*writeFn)(nil)).Elem())
+	beam.RegisterType(reflect.TypeOf((*writeWithFailuresFn)(nil)).Elem())
+}
+
+// Set returns a write that creates the document with the fields of v, or
+// replaces the document if it exists. v must be a struct or a map with
+// string keys. Panics if the name or v is invalid.
+func Set(name string, v interface{}) *firestorepb.Write {
+	return &firestorepb.Write{Operation: &firestorepb.Write_Update{Update: document("firestoreio.Set", name, v)}}
+}
+
+// Update returns a write that updates the fields of an existing document
+// with those of v, which fails if the document doesn't exist. Only the given
+// field paths, such as "address.city", are updated, or all fields of v if
+// none are given; fields of the paths that v doesn't have are deleted. See
+// Set for the supported values.
+func Update(name string, v interface{}, paths ...string) *firestorepb.Write {
+	doc := document("firestoreio.Update", name, v)
+	if len(paths) == 0 {
+		for field := range doc.GetFields() {
+			paths = append(paths, quotePath(field))
+		}
+		sort.Strings(paths)
+	}
+	return &firestorepb.Write{
+		Operation:       &firestorepb.Write_Update{Update: doc},
+		UpdateMask:      &firestorepb.DocumentMask{FieldPaths: paths},
+		CurrentDocument: &firestorepb.Precondition{ConditionType: &firestorepb.Precondition_Exists{Exists: true}},
+	}
+}
+
+// Delete returns a write that deletes the document. Deleting a document
+// that doesn't exist succeeds. Panics if the name is invalid.
+func Delete(name string) *firestorepb.Write {
+	mustParseDocument("firestoreio.Delete", name)
+	return &firestorepb.Write{Operation: &firestorepb.Write_Delete{Delete: name}}
+}
+
+// document returns the document with the name and the fields of v.
+func document(op, name string, v interface{}) *firestorepb.Document {
+	mustParseDocument(op, name)
+	fields, err := encodeFields(reflect.ValueOf(v))
+	if err != nil {
+		panic(fmt.Sprintf("%v invalid document %v: %v", op, name, err))
+	}
+	return &firestorepb.Document{Name: name, Fields: fields}
+}
+
+// mustParseDocument panics if the document name is invalid.
+func mustParseDocument(op, name string) {
+	if !documentRegexp.MatchString(name) {
+		panic(fmt.Sprintf("%v invalid document name %q, want projects/<project>/databases/<database>/documents/<collection>/<id>", op, name))
+	}
+}
+
+// writeName returns the name of the document of the write.
+func writeName(w *firestorepb.Write) string {
+	if name := w.GetDelete(); name != "" {
+		return name
+	}
+	return w.GetUpdate().GetName()
+}
+
+// FailedWrite is a write that couldn't be applied.
+type FailedWrite struct {
+	// Write is the write.
+	Write *firestorepb.Write
+	// Error describes why the write couldn't be applied.
+	Error string
+}
+
+// encodeFailedWrite encodes the error, prefixed by its length, followed by
+// the write.
+func encodeFailedWrite(f FailedWrite) ([]byte, error) {
+	w, err := proto.Marshal(f.Write)
+	if err != nil {
+		return nil, err
+	}
+	data := make([]byte, binary.MaxVarintLen64, binary.MaxVarintLen64+len(f.Error)+len(w))
+	data = data[:binary.PutUvarint(data, uint64(len(f.Error)))]
+	data = append(data, f.Error...)
+	return append(data, w...), nil
+}
+
+func decodeFailedWrite(data []byte) (FailedWrite, error) {
+	n, k := binary.Uvarint(data)
+	if k <= 0 || uint64(len(data)-k) < n {
+		return FailedWrite{}, errors.New("invalid failed write")
+	}
+	var w firestorepb.Write
+	if err := proto.Unmarshal(data[k+int(n):], &w); err != nil {
+		return FailedWrite{}, err
+	}
+	return FailedWrite{Write: &w, Error: string(data[k : k+int(n)])}, nil
+}
+
+// writeOption holds the options of Write and WriteWithFailures.
+type writeOption struct {
+	BatchSize      int
+	BatchSizeBytes int
+	MaxRetries     int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+}
+
+// WriteOptionFn is an option for Write and WriteWithFailures.
+type WriteOptionFn func(*writeOption)
+
+func newWriteOption(opts []WriteOptionFn) writeOption {
+	o := writeOption{
+		BatchSize:      defaultBatchSize,
+		BatchSizeBytes: defaultBatchSizeBytes,
+		MaxRetries:     defaultMaxRetries,
+		InitialBackoff: defaultInitialBackoff,
+		MaxBackoff:     defaultMaxBackoff,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// WriteBatchSize limits the number of writes in each batch, which is at most
+// 500, the default.
+func WriteBatchSize(n int) WriteOptionFn {
+	if n < 1 || n > defaultBatchSize {
+		panic(fmt.Sprintf("firestoreio.WriteBatchSize batch size must be between 1 and %v. Got: %v", defaultBatchSize, n))
+	}
+	return func(o *writeOption) {
+		o.BatchSize = n
+	}
+}
+
+// WriteBatchSizeBytes limits the size in bytes of the writes in each batch.
+// Firestore limits requests to 10 MiB. The default is 9 MiB.
+func WriteBatchSizeBytes(bytes int) WriteOptionFn {
+	if bytes < 1 {
+		panic(fmt.Sprintf("firestoreio.WriteBatchSizeBytes batch size must be positive. Got: %v", bytes))
+	}
+	return func(o *writeOption) {
+		o.BatchSizeBytes = bytes
+	}
+}
+
+// WriteMaxRetries sets the number of times writes that fail with a
+// retryable error, such as UNAVAILABLE, are retried. The default is 5.
+func WriteMaxRetries(n int) WriteOptionFn {
+	if n < 0 {
+		panic(fmt.Sprintf("firestoreio.WriteMaxRetries max retries must be non-negative. Got: %v", n))
+	}
+	return func(o *writeOption) {
+		o.MaxRetries = n
+	}
+}
+
+// WriteBackoff sets the backoff between retries, which starts at initial and
+// doubles after each retry, up to max. The default is 100ms, up to 10s.
+func WriteBackoff(initial, max time.Duration) WriteOptionFn {
+	if initial <= 0 || max < initial {
+		panic(fmt.Sprintf("firestoreio.WriteBackoff invalid backoff. Got: %v, %v", initial, max))
+	}
+	return func(o *writeOption) {
+		o.InitialBackoff = initial
+		o.MaxBackoff = max
+	}
+}
+
+// Write applies the writes of the given PCollection<*firestorepb.Write> to
+// the database. Writes are created with Set, Update and Delete. They are
+// applied in batches, which aren't atomic: each write of a batch succeeds or
+// fails on its own, and writes may be applied out of order. Writes that fail
+// with a retryable error are retried with backoff. Writes that still fail
+// fail the bundle.
+func Write(s beam.Scope, db string, col beam.PCollection, opts ...WriteOptionFn) {
+	s = s.Scope("firestoreio.Write")
+	mustParseDatabase(db)
+
+	o := newWriteOption(opts)
+	beam.ParDo0(s, &writeFn{Database: db, Options: o}, col)
+}
+
+// WriteWithFailures applies the writes of the given
+// PCollection<*firestorepb.Write> to the database, like Write, but returns
+// the writes that couldn't be applied as a PCollection<FailedWrite> instead
+// of failing the bundle, such as to write them to a dead-letter collection.
+// Failed writes are output in the global window.
+func WriteWithFailures(s beam.Scope, db string, col beam.PCollection, opts ...WriteOptionFn) beam.PCollection {
+	s = s.Scope("firestoreio.WriteWithFailures")
+	mustParseDatabase(db)
+
+	o := newWriteOption(opts)
+	return beam.ParDo(s, &writeWithFailuresFn{Database: db, Options: o}, col)
+}
+
+// writeFn applies writes, and fails if any can't be applied.
+type writeFn struct {
+	// Database is the name of the database.
+	Database string `json:"database"`
+	// Options specifies the batching and retries.
+	Options writeOption `json:"options"`
+
+	conn *grpc.ClientConn
+	w    *batchWriter
+}
+
+func (f *writeFn) Setup(ctx context.Context) error {
+	conn, err := dial(ctx)
+	if err != nil {
+		return err
+	}
+	f.conn = conn
+	return nil
+}
+
+func (f *writeFn) StartBundle(ctx context.Context) {
+	f.w = newBatchWriter(firestorepb.NewFirestoreClient(f.conn), f.Database, f.Options)
+}
+
+func (f *writeFn) ProcessElement(ctx context.Context, w *firestorepb.Write) error {
+	return failure(f.w.Add(ctx, w))
+}
+
+func (f *writeFn) FinishBundle(ctx context.Context) error {
+	return failure(f.w.Flush(ctx))
+}
+
+func (f *writeFn) Teardown() error {
+	if f.conn == nil {
+		return nil
+	}
+	return f.conn.Close()
+}
+
+// failure returns an error describing the failed writes, if any.
+func failure(failed []FailedWrite) error {
+	if len(failed) == 0 {
+		return nil
+	}
+	return errors.Errorf("firestore write error: %v writes failed, such as of %v: %v", len(failed), writeName(failed[0].Write), failed[0].Error)
+}
+
+// writeWithFailuresFn applies writes, and outputs those that can't be
+// applied.
+type writeWithFailuresFn struct {
+	// Database is the name of the database.
+	Database string `json:"database"`
+	// Options specifies the batching and retries.
+	Options writeOption `json:"options"`
+
+	conn *grpc.ClientConn
+	w    *batchWriter
+}
+
+func (f *writeWithFailuresFn) Setup(ctx context.Context) error {
+	conn, err := dial(ctx)
+	if err != nil {
+		return err
+	}
+	f.conn = conn
+	return nil
+}
+
+func (f *writeWithFailuresFn) StartBundle(ctx context.Context, _ func(FailedWrite)) {
+	f.w = newBatchWriter(firestorepb.NewFirestoreClient(f.conn), f.Database, f.Options)
+}
+
+func (f *writeWithFailuresFn) ProcessElement(ctx context.Context, w *firestorepb.Write, emit func(FailedWrite)) {
+	for _, failed := range f.w.Add(ctx, w) {
+		emit(failed)
+	}
+}
+
+func (f *writeWithFailuresFn) FinishBundle(ctx context.Context, emit func(FailedWrite)) {
+	for _, failed := range f.w.Flush(ctx) {
+		emit(failed)
+	}
+}
+
+func (f *writeWithFailuresFn) Teardown() error {
+	if f.conn == nil {
+		return nil
+	}
+	return f.conn.Close()
+}
+
+// batchWriter applies writes to a database in batches.
+type batchWriter struct {
+	client firestorepb.FirestoreClient
+	db     string
+	opts   writeOption
+
+	batch []*firestorepb.Write
+	size  int
+	// docs is the names of the documents of the batch, as a batch can't
+	// have several writes of a document.
+	docs map[string]bool
+}
+
+func newBatchWriter(client firestorepb.FirestoreClient, db string, opts writeOption) *batchWriter {
+	return &batchWriter{client: client, db: db, opts: opts, docs: make(map[string]bool)}
+}
+
+// Add adds the write to the batch, and applies the batch first if the write
+// doesn't fit in it. It returns the writes that failed, if any.
+func (w *batchWriter) Add(ctx context.Context, write *firestorepb.Write) []FailedWrite {
+	size := proto.Size(write)
+	name := writeName(write)
+	var failed []FailedWrite
+	if len(w.batch) > 0 && (len(w.batch) >= w.opts.BatchSize || w.size+size > w.opts.BatchSizeBytes || w.docs[name]) {
+		failed = w.Flush(ctx)
+	}
+	w.batch = append(w.batch, write)
+	w.size += size
+	w.docs[name] = true
+	return failed
+}
+
+// Flush applies the batch, retrying the writes that fail with a retryable
+// error, and returns the writes that failed, if any.
+func (w *batchWriter) Flush(ctx context.Context) []FailedWrite {
+	pending := w.batch
+	w.batch, w.size, w.docs = nil, 0, make(map[string]bool)
+
+	var failed []FailedWrite
+	backoff := w.opts.InitialBackoff
+	for attempt := 0; len(pending) > 0; attempt++ {
+		errs := w.write(ctx, pending)
+		var retry []*firestorepb.Write
+		var retryErr error
+		for i, err := range errs {
+			switch {
+			case err == nil:
+			case attempt < w.opts.MaxRetries && isRetryable(err):
+				retry = append(retry, pending[i])
+				retryErr = err
+			default:
+				failed = append(failed, FailedWrite{Write: pending[i], Error: err.Error()})
+			}
+		}
+		if len(retry) == 0 {
+			break
+		}
+		log.Warnf(ctx, "Retrying %v writes to %v in %v: %v", len(retry), w.db, backoff, retryErr)
+		time.Sleep(backoff)
+		if backoff *= 2; backoff > w.opts.MaxBackoff {
+			backoff = w.opts.MaxBackoff
+		}
+		pending = retry
+	}
+	return failed
+}
+
+// write applies the writes in a batch, and returns the error of each write,
+// which is nil if it succeeded.
+func (w *batchWriter) write(ctx context.Context, writes []*firestorepb.Write) []error {
+	errs := make([]error, len(writes))
+	resp, err := w.client.BatchWrite(withDatabase(ctx, w.db), &firestorepb.BatchWriteRequest{Database: w.db, Writes: writes})
+	if err != nil {
+		for i := range errs {
+			errs[i] = err
+		}
+		return errs
+	}
+	statuses := resp.GetStatus()
+	for i := range errs {
+		switch {
+		case i >= len(statuses):
+			errs[i] = status.Error(codes.Unknown, "no status of write")
+		case statuses[i].GetCode() != int32(codes.OK):
+			errs[i] = status.ErrorProto(statuses[i])
+		}
+	}
+	return errs
+}
@@ -0,0 +1,240 @@
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements.  See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License.  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package firestoreio
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/apache/beam/sdks/v2/go/pkg/beam"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/testing/passert"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/testing/ptest"
+	firestorepb "google.golang.org/genproto/googleapis/firestore/v1"
+	statuspb "google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/protobuf/proto"
+)
+
+func init() {
+	beam.RegisterFunction(failedName)
+}
+
+// BatchWrite applies the writes, except those of documents of the
+// collection "bad", which fail with NotFound.
+func (f *fakeServer) BatchWrite(ctx context.Context, req *firestorepb.BatchWriteRequest) (*firestorepb.BatchWriteResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.addPrefix(ctx)
+	f.batches = append(f.batches, req.GetWrites())
+	resp := &firestorepb.BatchWriteResponse{}
+	for _, w := range req.GetWrites() {
+		var st *statuspb.Status
+		switch {
+		case f.unavailable > 0:
+			st = &statuspb.Status{Code: int32(codes.Unavailable), Message: "unavailable"}
+		case strings.Contains(writeName(w), "/documents/bad/"):
+			st = &statuspb.Status{Code: int32(codes.NotFound), Message: "collection bad not found"}
+		default:
+			st = &statuspb.Status{}
+			f.written = append(f.written, w)
+		}
+		resp.Status = append(resp.Status, st)
+		resp.WriteResults = append(resp.WriteResults, &firestorepb.WriteResult{})
+	}
+	if f.unavailable > 0 {
+		f.unavailable--
+	}
+	return resp, nil
+}
+
+func TestWrite(t *testing.T) {
+	fake := startFakeServer(t)
+
+	p, s := beam.NewPipelineWithRoot()
+	writes := beam.CreateList(s, []*firestorepb.Write{
+		Set(userName("u1"), user{ID: 1}),
+		Update(userName("u2"), map[string]interface{}{"email": "b@example.com"}),
+		Delete(userName("u3")),
+	})
+	Write(s, testDB, writes)
+	ptest.RunAndValidate(t, p)
+
+	var names []string
+	for _, w := range fake.written {
+		names = append(names, writeName(w))
+	}
+	want := []string{userName("u1"), userName("u2"), userName("u3")}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("Write() wrote %v, want %v", names, want)
+	}
+	if len(fake.batches) != 1 {
+		t.Errorf("Write() made %v batch requests, want 1", len(fake.batches))
+	}
+}
+
+func TestWrite_Failure(t *testing.T) {
+	startFakeServer(t)
+
+	p, s := beam.NewPipelineWithRoot()
+	writes := beam.Create(s, Delete(testDB+"/documents/bad/b1"))
+	Write(s, testDB, writes)
+	if err := ptest.Run(p); err == nil || !strings.Contains(err.Error(), "collection bad not found") {
+		t.Errorf("Write() of a failing write = %v, want error", err)
+	}
+}
+
+func failedName(f FailedWrite) string {
+	return writeName(f.Write) + ": " + f.Error
+}
+
+func TestWriteWithFailures(t *testing.T) {
+	fake := startFakeServer(t)
+
+	p, s := beam.NewPipelineWithRoot()
+	writes := beam.CreateList(s, []*firestorepb.Write{
+		Set(userName("u1"), user{ID: 1}),
+		Set(testDB+"/documents/bad/b1", user{ID: 2}),
+	})
+	failed := WriteWithFailures(s, testDB, writes, WriteBackoff(time.Millisecond, time.Millisecond))
+	passert.Equals(s, beam.ParDo(s, failedName, failed),
+		testDB+"/documents/bad/b1: rpc error: code = NotFound desc = collection bad not found")
+	ptest.RunAndValidate(t, p)
+
+	if len(fake.written) != 1 || writeName(fake.written[0]) != userName("u1") {
+		t.Errorf("WriteWithFailures() wrote %v, want %v", fake.written, userName("u1"))
+	}
+}
+
+func TestBatchWriter(t *testing.T) {
+	fake := startFakeServer(t)
+	fake.unavailable = 2
+	conn, err := dial(context.Background())
+	if err != nil {
+		t.Fatalf("dial() failed: %v", err)
+	}
+	defer conn.Close()
+
+	opts := newWriteOption([]WriteOptionFn{WriteBatchSize(2), WriteMaxRetries(2), WriteBackoff(time.Millisecond, time.Millisecond)})
+	w := newBatchWriter(firestorepb.NewFirestoreClient(conn), testDB, opts)
+	ctx := context.Background()
+	var failed []FailedWrite
+	for _, write := range []*firestorepb.Write{
+		Set(userName("u1"), user{ID: 1}),
+		// A batch can't have two writes of a document.
+		Set(userName("u1"), user{ID: 2}),
+		Set(userName("u2"), user{ID: 3}),
+		Set(userName("u3"), user{ID: 4}),
+	} {
+		failed = append(failed, w.Add(ctx, write)...)
+	}
+	failed = append(failed, w.Flush(ctx)...)
+	if len(failed) != 0 {
+		t.Errorf("batchWriter failed writes %v, want none", failed)
+	}
+
+	var sizes []int
+	for _, b := range fake.batches {
+		sizes = append(sizes, len(b))
+	}
+	// The first batch is retried twice.
+	if want := []int{1, 1, 1, 2, 1}; !reflect.DeepEqual(sizes, want) {
+		t.Errorf("batchWriter wrote batches of %v writes, want %v", sizes, want)
+	}
+	if len(fake.written) != 4 {
+		t.Errorf("batchWriter wrote %v writes, want 4", len(fake.written))
+	}
+}
+
+func TestBatchWriter_RetriesExhausted(t *testing.T) {
+	fake := startFakeServer(t)
+	fake.unavailable = 3
+	conn, err := dial(context.Background())
+	if err != nil {
+		t.Fatalf("dial() failed: %v", err)
+	}
+	defer conn.Close()
+
+	opts := newWriteOption([]WriteOptionFn{WriteMaxRetries(2), WriteBackoff(time.Millisecond, time.Millisecond)})
+	w := newBatchWriter(firestorepb.NewFirestoreClient(conn), testDB, opts)
+	ctx := context.Background()
+	w.Add(ctx, Delete(userName("u1")))
+	failed := w.Flush(ctx)
+	if len(failed) != 1 || !strings.Contains(failed[0].Error, "Unavailable") {
+		t.Errorf("batchWriter failed writes %v, want 1 unavailable", failed)
+	}
+	if len(fake.batches) != 3 {
+		t.Errorf("batchWriter made %v batch requests, want 3", len(fake.batches))
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	w := Update(userName("u1"), map[string]interface{}{"email": "a", "last name": "b"})
+	if got, want := w.GetUpdateMask().GetFieldPaths(), []string{"`last name`", "email"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Update() mask = %v, want %v", got, want)
+	}
+	if !w.GetCurrentDocument().GetExists() {
+		t.Errorf("Update() precondition = %v, want exists", w.GetCurrentDocument())
+	}
+
+	w = Update(userName("u1"), user{Email: "a"}, "email")
+	if got, want := w.GetUpdateMask().GetFieldPaths(), []string{"email"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Update() mask = %v, want %v", got, want)
+	}
+	if _, ok := w.GetUpdate().GetFields()[nameField]; ok {
+		t.Errorf("Update() wrote the document name as a field: %v", w.GetUpdate())
+	}
+}
+
+func TestSet_Invalid(t *testing.T) {
+	tests := []struct {
+		name string
+		v    interface{}
+	}{
+		{testDB + "/documents/users", user{}},
+		{"users/u1", user{}},
+		{userName("u1"), 1},
+		{userName("u1"), map[int]string{}},
+		{userName("u1"), (*user)(nil)},
+	}
+	for _, test := range tests {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("Set(%v, %v) succeeded, want panic", test.name, test.v)
+				}
+			}()
+			Set(test.name, test.v)
+		}()
+	}
+}
+
+func TestFailedWriteCoder(t *testing.T) {
+	in := FailedWrite{Write: Set(userName("u1"), user{ID: 1}), Error: "failed"}
+	data, err := encodeFailedWrite(in)
+	if err != nil {
+		t.Fatalf("encodeFailedWrite(%v) failed: %v", in, err)
+	}
+	out, err := decodeFailedWrite(data)
+	if err != nil {
+		t.Fatalf("decodeFailedWrite() failed: %v", err)
+	}
+	if out.Error != in.Error || !proto.Equal(out.Write, in.Write) {
+		t.Errorf("decodeFailedWrite(encodeFailedWrite(%v)) = %v", in, out)
+	}
+}
@@ -0,0 +1,59 @@
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements.  See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License.  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package gcpx contains helpers shared by the IOs that connect to Google Cloud
+// services over gRPC.
+package gcpx
+
+import (
+	"context"
+	"os"
+
+	"google.golang.org/api/option"
+	gtransport "google.golang.org/api/transport/grpc"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+// Dial connects to the endpoint of a Google Cloud service with the default
+// credentials for the given OAuth scopes. If the environment variable
+// emulatorHostEnv is set, it instead connects without credentials to the
+// emulator at the address it holds.
+func Dial(ctx context.Context, emulatorHostEnv, endpoint string, scopes ...string) (*grpc.ClientConn, error) {
+	if addr := os.Getenv(emulatorHostEnv); addr != "" {
+		return grpc.DialContext(ctx, addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	}
+	return gtransport.Dial(ctx, option.WithEndpoint(endpoint), option.WithScopes(scopes...))
+}
+
+// IsRetryable returns whether the request that failed with the error can be
+// retried. Requests that failed with the transient codes Unavailable, Aborted
+// and DeadlineExceeded can always be retried, and some services also allow
+// retrying the given additional codes.
+func IsRetryable(err error, additional ...codes.Code) bool {
+	code := status.Code(err)
+	switch code {
+	case codes.Unavailable, codes.Aborted, codes.DeadlineExceeded:
+		return true
+	}
+	for _, c := range additional {
+		if code == c {
+			return true
+		}
+	}
+	return false
+}
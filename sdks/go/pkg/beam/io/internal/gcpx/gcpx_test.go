@@ -0,0 +1,60 @@
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements.  See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License.  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcpx
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestDial_Emulator(t *testing.T) {
+	const env = "GCPX_TEST_EMULATOR_HOST"
+	t.Setenv(env, "localhost:8085")
+
+	conn, err := Dial(context.Background(), env, "example.googleapis.com:443", "https://www.googleapis.com/auth/cloud-platform")
+	if err != nil {
+		t.Fatalf("Dial() failed: %v", err)
+	}
+	defer conn.Close()
+	if got, want := conn.Target(), "localhost:8085"; got != want {
+		t.Errorf("Dial() connected to %v, want %v", got, want)
+	}
+}
+
+func TestIsRetryable(t *testing.T) {
+	tests := []struct {
+		err        error
+		additional []codes.Code
+		want       bool
+	}{
+		{status.Error(codes.Unavailable, "unavailable"), nil, true},
+		{status.Error(codes.Aborted, "aborted"), nil, true},
+		{status.Error(codes.DeadlineExceeded, "deadline exceeded"), nil, true},
+		{status.Error(codes.Internal, "internal"), nil, false},
+		{status.Error(codes.Internal, "internal"), []codes.Code{codes.ResourceExhausted, codes.Internal}, true},
+		{status.Error(codes.InvalidArgument, "invalid"), []codes.Code{codes.ResourceExhausted, codes.Internal}, false},
+		{errors.New("not a status"), nil, false},
+	}
+	for _, test := range tests {
+		if got := IsRetryable(test.err, test.additional...); got != test.want {
+			t.Errorf("IsRetryable(%v, %v) = %v, want %v", test.err, test.additional, got, test.want)
+		}
+	}
+}
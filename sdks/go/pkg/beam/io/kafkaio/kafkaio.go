@@ -0,0 +1,79 @@
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements.  See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License.  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package kafkaio contains native Go transforms to read from and write to
+// Apache Kafka, with the Kafka protocol implementation of
+// github.com/Shopify/sarama. Unlike the cross-language transforms of
+// io/xlang/kafkaio, they don't require a Java expansion service.
+//
+// Reading is implemented with a splittable DoFn, so it runs on portable
+// runners that support unbounded splittable DoFns and bundle finalization.
+// Experimental.
+package kafkaio
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/Shopify/sarama"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam"
+)
+
+// defaultVersion is the Kafka version the transforms use, unless another is
+// set with ReadKafkaVersion or WriteKafkaVersion.
+const defaultVersion = "1.0.0"
+
+func init() {
+	beam.RegisterType(reflect.TypeOf((*Record)(nil)).Elem())
+	beam.RegisterType(reflect.TypeOf((*Header)(nil)).Elem())
+}
+
+// Record is a record of a Kafka topic, as read by Read. Write writes the
+// key, value, headers, and timestamp of records.
+type Record struct {
+	Topic     string
+	Partition int32
+	Offset    int64
+	Key       []byte
+	Value     []byte
+	Headers   []Header
+	// Timestamp is the time of the record, in milliseconds since the epoch,
+	// which is its create time or its log append time, depending on the
+	// configuration of the topic. It's 0 if unset.
+	Timestamp int64
+}
+
+// Header is a header of a Record.
+type Header struct {
+	Key   string
+	Value []byte
+}
+
+// mustParseVersion panics if the Kafka version is invalid.
+func mustParseVersion(name, version string) sarama.KafkaVersion {
+	v, err := sarama.ParseKafkaVersion(version)
+	if err != nil {
+		panic(fmt.Sprintf("kafkaio.%v invalid Kafka version %q: %v", name, version, err))
+	}
+	return v
+}
+
+// newConfig returns the client configuration of the Kafka version.
+func newConfig(version string) *sarama.Config {
+	cfg := sarama.NewConfig()
+	cfg.ClientID = "apache-beam"
+	cfg.Version = mustParseVersion("newConfig", version)
+	return cfg
+}
@@ -0,0 +1,327 @@
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements.  See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License.  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kafkaio
+
+import (
+	"context"
+	"math"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/core/graph/mtime"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/io/rtrackers/offsetrange"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/io/rtrackers/unbounded/unboundedtest"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/testing/ptest"
+)
+
+const (
+	testTopic = "t"
+	testGroup = "g"
+)
+
+func TestMain(m *testing.M) {
+	ptest.Main(m)
+}
+
+// testTime is the timestamp of the first test record.
+var testTime = time.UnixMilli(1651399200123)
+
+// startBroker starts a mock broker that leads the partitions of the test
+// topic, whose oldest offset is 1 and latest offset is 3, and coordinates
+// the test group, with the offsets committed to it.
+func startBroker(t *testing.T, partitions int32, committed map[int32]int64) *sarama.MockBroker {
+	b := sarama.NewMockBroker(t, 1)
+	t.Cleanup(b.Close)
+
+	metadata := sarama.NewMockMetadataResponse(t).SetBroker(b.Addr(), b.BrokerID())
+	offsets := sarama.NewMockOffsetResponse(t)
+	fetch := &sarama.FetchResponse{Version: 4}
+	fetched := sarama.NewMockFetchResponse(t, 1)
+	for p := int32(0); p < partitions; p++ {
+		metadata.SetLeader(testTopic, p, b.BrokerID())
+		offsets.SetOffset(testTopic, p, sarama.OffsetOldest, 1)
+		offsets.SetOffset(testTopic, p, sarama.OffsetNewest, 3)
+		fetched.SetHighWaterMark(testTopic, p, 3)
+	}
+	// Partition 0 has records at offsets 0 to 2, the second with a header.
+	for i, v := range []string{"a", "b", "c"} {
+		fetch.AddRecordWithTimestamp(testTopic, 0, sarama.StringEncoder("k"+v), sarama.StringEncoder(v), int64(i), testTime.Add(time.Duration(i)*time.Second))
+	}
+	fetch.SetLastOffsetDelta(testTopic, 0, 2)
+	block := fetch.GetBlock(testTopic, 0)
+	block.HighWaterMarkOffset = 3
+	block.RecordsSet[0].RecordBatch.Records[1].Headers = []*sarama.RecordHeader{{Key: []byte("h"), Value: []byte("v")}}
+
+	fetchOffsets := sarama.NewMockOffsetFetchResponse(t)
+	for p, offset := range committed {
+		fetchOffsets.SetOffset(testGroup, testTopic, p, offset, "", sarama.ErrNoError)
+	}
+	b.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest":        metadata,
+		"OffsetRequest":          offsets,
+		"FetchRequest":           sarama.NewMockSequence(fetch, fetched),
+		"FindCoordinatorRequest": sarama.NewMockFindCoordinatorResponse(t).SetCoordinator(sarama.CoordinatorGroup, testGroup, b),
+		"OffsetFetchRequest":     fetchOffsets,
+		"OffsetCommitRequest":    sarama.NewMockOffsetCommitResponse(t),
+	})
+	return b
+}
+
+// committedOffsets returns the offsets committed to the broker, by
+// partition.
+func committedOffsets(b *sarama.MockBroker) map[int32]int64 {
+	offsets := make(map[int32]int64)
+	for _, rr := range b.History() {
+		req, ok := rr.Request.(*sarama.OffsetCommitRequest)
+		if !ok {
+			continue
+		}
+		for p := int32(0); p < 2; p++ {
+			if offset, _, err := req.Offset(testTopic, p); err == nil {
+				offsets[p] = offset
+			}
+		}
+	}
+	return offsets
+}
+
+func TestPartitionFn(t *testing.T) {
+	tests := []struct {
+		name       string
+		group      string
+		fromLatest bool
+		want       []partition
+	}{
+		{
+			name: "oldest",
+			want: []partition{{testTopic, 0, 1}, {testTopic, 1, 1}, {testTopic, 2, 1}},
+		},
+		{
+			name:       "latest",
+			fromLatest: true,
+			want:       []partition{{testTopic, 0, 3}, {testTopic, 1, 3}, {testTopic, 2, 3}},
+		},
+		{
+			// Committed offsets before the oldest offset are read from the
+			// oldest offset.
+			name:  "committed",
+			group: testGroup,
+			want:  []partition{{testTopic, 0, 2}, {testTopic, 1, 1}, {testTopic, 2, 1}},
+		},
+		{
+			name:       "committed or latest",
+			group:      testGroup,
+			fromLatest: true,
+			want:       []partition{{testTopic, 0, 2}, {testTopic, 1, 1}, {testTopic, 2, 3}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			b := startBroker(t, 3, map[int32]int64{0: 2, 1: 0})
+			fn := &partitionFn{
+				Servers:    []string{b.Addr()},
+				Topics:     []string{testTopic},
+				Group:      test.group,
+				FromLatest: test.fromLatest,
+				Version:    defaultVersion,
+			}
+			ctx := context.Background()
+			if err := fn.Setup(ctx); err != nil {
+				t.Fatalf("Setup() failed: %v", err)
+			}
+			defer fn.Teardown()
+			var got []partition
+			if err := fn.ProcessElement(ctx, nil, func(p partition) { got = append(got, p) }); err != nil {
+				t.Fatalf("ProcessElement() failed: %v", err)
+			}
+			sort.Slice(got, func(i, j int) bool { return got[i].Partition < got[j].Partition })
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("ProcessElement() output %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestReadFn(t *testing.T) {
+	b := startBroker(t, 1, nil)
+
+	fn := &readFn{
+		Servers:            []string{b.Addr()},
+		Group:              testGroup,
+		Policy:             createTime,
+		MaxDelay:           time.Minute,
+		CheckpointInterval: 200 * time.Millisecond,
+		Version:            defaultVersion,
+	}
+	ctx := context.Background()
+	if err := fn.Setup(ctx); err != nil {
+		t.Fatalf("Setup() failed: %v", err)
+	}
+	defer fn.Teardown()
+
+	p := partition{Topic: testTopic, Partition: 0, Offset: 1}
+	rest := fn.CreateInitialRestriction(p)
+	if want := (offsetrange.Restriction{Start: 1, End: math.MaxInt64}); rest != want {
+		t.Fatalf("CreateInitialRestriction(%v) = %v, want %v", p, rest, want)
+	}
+	rt := fn.CreateTracker(rest)
+	we := fn.CreateWatermarkEstimator(fn.InitialWatermarkEstimatorState(mtime.MinTimestamp, rest, p))
+	var bf unboundedtest.Finalization
+	var got []Record
+	start := time.Now()
+	pc, err := fn.ProcessElement(ctx, we, &bf, rt, p, func(et beam.EventTime, r Record) {
+		if want := mtime.FromMilliseconds(r.Timestamp); et != want {
+			t.Errorf("ProcessElement() output %v at %v, want its create time %v", r, et, want)
+		}
+		got = append(got, r)
+	})
+	if err != nil {
+		t.Fatalf("ProcessElement() failed: %v", err)
+	}
+	if !pc.ShouldResume() {
+		t.Errorf("ProcessElement() = %v, want it to resume", pc)
+	}
+	want := []Record{
+		{Topic: testTopic, Partition: 0, Offset: 1, Key: []byte("kb"), Value: []byte("b"), Headers: []Header{{Key: "h", Value: []byte("v")}}, Timestamp: testTime.Add(time.Second).UnixMilli()},
+		{Topic: testTopic, Partition: 0, Offset: 2, Key: []byte("kc"), Value: []byte("c"), Timestamp: testTime.Add(2 * time.Second).UnixMilli()},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ProcessElement() output %v, want %v", got, want)
+	}
+	// The partition is caught up, so the watermark is at least the time its
+	// latest offset was fetched, minus the maximum delay.
+	if wm, min := we.CurrentWatermark(), start.Add(-time.Minute).Truncate(time.Millisecond); wm.Before(min) {
+		t.Errorf("ProcessElement() watermark = %v, want at least %v", wm, min)
+	}
+	if _, residual, err := rt.TrySplit(0); err != nil || residual != (offsetrange.Restriction{Start: 3, End: math.MaxInt64}) {
+		t.Errorf("TrySplit(0) = %v, %v, want the offsets from 3", residual, err)
+	}
+
+	// The offset is committed once the bundle is finalized.
+	if got := committedOffsets(b); len(got) != 0 {
+		t.Errorf("offsets %v committed before finalization", got)
+	}
+	if len(bf.Callbacks) != 1 {
+		t.Fatalf("ProcessElement() registered %v finalization callbacks, want 1", len(bf.Callbacks))
+	}
+	if err := bf.Callbacks[0](); err != nil {
+		t.Fatalf("finalization callback failed: %v", err)
+	}
+	if got, want := committedOffsets(b), map[int32]int64{0: 3}; !reflect.DeepEqual(got, want) {
+		t.Errorf("committed offsets = %v, want %v", got, want)
+	}
+}
+
+func TestReadFn_ProcessingTime(t *testing.T) {
+	b := startBroker(t, 1, nil)
+
+	fn := &readFn{
+		Servers:            []string{b.Addr()},
+		CheckpointInterval: 200 * time.Millisecond,
+		Version:            defaultVersion,
+	}
+	ctx := context.Background()
+	if err := fn.Setup(ctx); err != nil {
+		t.Fatalf("Setup() failed: %v", err)
+	}
+	defer fn.Teardown()
+
+	p := partition{Topic: testTopic, Partition: 0, Offset: 1}
+	rest := fn.CreateInitialRestriction(p)
+	rt := fn.CreateTracker(rest)
+	we := fn.CreateWatermarkEstimator(fn.InitialWatermarkEstimatorState(mtime.MinTimestamp, rest, p))
+	var bf unboundedtest.Finalization
+	var got []Record
+	start := time.Now()
+	if _, err := fn.ProcessElement(ctx, we, &bf, rt, p, func(et beam.EventTime, r Record) {
+		// Records are timestamped at the time they're read by default.
+		if et.ToTime().Before(start.Truncate(time.Millisecond)) {
+			t.Errorf("ProcessElement() output %v at %v, want at least %v", r, et.ToTime(), start)
+		}
+		got = append(got, r)
+	}); err != nil {
+		t.Fatalf("ProcessElement() failed: %v", err)
+	}
+	if len(got) != 2 {
+		t.Errorf("ProcessElement() output %v records, want 2", len(got))
+	}
+	if wm := we.CurrentWatermark(); wm.Before(start.Truncate(time.Millisecond)) {
+		t.Errorf("ProcessElement() watermark = %v, want at least %v", wm, start)
+	}
+	// Without a group, no offsets are committed.
+	if len(bf.Callbacks) != 0 {
+		t.Errorf("ProcessElement() registered %v finalization callbacks, want none", len(bf.Callbacks))
+	}
+}
+
+func TestCreateTracker(t *testing.T) {
+	rt := (&readFn{}).CreateTracker(offsetrange.Restriction{Start: 3, End: 100})
+	if rt.IsBounded() {
+		t.Error("CreateTracker() is bounded, want unbounded so that draining stops reads")
+	}
+	rt.TryClaim(int64(5))
+	if _, residual, err := rt.TrySplit(0.5); err != nil || residual != nil {
+		t.Errorf("TrySplit(0.5) = %v, %v, want no residual", residual, err)
+	}
+	if _, residual, err := rt.TrySplit(0); err != nil || residual != (offsetrange.Restriction{Start: 6, End: 100}) {
+		t.Errorf("TrySplit(0) = %v, %v, want {6 100}", residual, err)
+	}
+}
+
+func TestIsRetryable(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{sarama.ErrNotLeaderForPartition, true},
+		{sarama.ErrOutOfBrokers, true},
+		{sarama.ErrOffsetOutOfRange, false},
+		{sarama.ErrTopicAuthorizationFailed, false},
+	}
+	for _, test := range tests {
+		if got := isRetryable(test.err); got != test.want {
+			t.Errorf("isRetryable(%v) = %v, want %v", test.err, got, test.want)
+		}
+	}
+}
+
+func TestRead_Options(t *testing.T) {
+	tests := []struct {
+		name string
+		fn   func()
+	}{
+		{"no topics", func() { Read(beam.NewPipeline().Root(), "localhost:9092", nil) }},
+		{"no servers", func() { Read(beam.NewPipeline().Root(), " , ", []string{testTopic}) }},
+		{"empty group", func() { ReadGroup("") }},
+		{"negative delay", func() { ReadCreateTime(-time.Second) }},
+		{"zero interval", func() { ReadCheckpointInterval(0) }},
+		{"invalid version", func() { ReadKafkaVersion("x") }},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%v succeeded, want panic", test.name)
+				}
+			}()
+			test.fn()
+		})
+	}
+}
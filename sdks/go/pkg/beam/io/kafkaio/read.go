@@ -0,0 +1,531 @@
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements.  See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License.  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kafkaio
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/core/graph/mtime"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/core/sdf"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/internal/errors"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/io/rtrackers/offsetrange"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/io/rtrackers/unbounded"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/log"
+)
+
+const (
+	// defaultCheckpointInterval is how long a partition is read before
+	// checkpointing, unless another is set with ReadCheckpointInterval.
+	defaultCheckpointInterval = 10 * time.Second
+
+	// retryInterval is how long reading a partition waits after a retryable
+	// error.
+	retryInterval = time.Second
+	// finalizationTimeout is how long the offset of a bundle waits for the
+	// bundle to be finalized before it's dropped.
+	finalizationTimeout = 10 * time.Minute
+)
+
+func init() {
+	beam.RegisterType(reflect.TypeOf((*partition)(nil)).Elem())
+	beam.RegisterType(reflect.TypeOf((*partitionFn)(nil)).Elem())
+	beam.RegisterType(reflect.TypeOf((*readFn)(nil)).Elem())
+}
+
+// timestampPolicy is how the records read are timestamped, and how the
+// watermark of a partition advances.
+type timestampPolicy int
+
+const (
+	processingTime timestampPolicy = iota
+	logAppendTime
+	createTime
+)
+
+// readOption holds the options of Read.
+type readOption struct {
+	Group              string
+	FromLatest         bool
+	Policy             timestampPolicy
+	MaxDelay           time.Duration
+	CheckpointInterval time.Duration
+	Version            string
+}
+
+// ReadOptionFn is an option for Read.
+type ReadOptionFn func(*readOption)
+
+// ReadGroup sets the consumer group whose committed offsets partitions are
+// read from. The offset of each partition is committed to the group once the
+// bundle of its records is finalized. By default, no offsets are committed.
+func ReadGroup(group string) ReadOptionFn {
+	if group == "" {
+		panic("kafkaio.ReadGroup group must not be empty")
+	}
+	return func(o *readOption) {
+		o.Group = group
+	}
+}
+
+// ReadFromLatest sets partitions without a committed offset to be read from
+// their latest offset, rather than from their oldest offset.
+func ReadFromLatest() ReadOptionFn {
+	return func(o *readOption) {
+		o.FromLatest = true
+	}
+}
+
+// ReadLogAppendTime sets records to be timestamped at the time they were
+// appended to the log, which requires topics with
+// message.timestamp.type=LogAppendTime. The watermark of a partition is the
+// timestamp of its last record.
+func ReadLogAppendTime() ReadOptionFn {
+	return func(o *readOption) {
+		o.Policy = logAppendTime
+	}
+}
+
+// ReadCreateTime sets records to be timestamped at the time they were
+// created by their producers, which may be out of order by up to maxDelay.
+// The watermark of a partition is the latest timestamp of its records minus
+// maxDelay, and records later than that are dropped by windowing as late
+// data.
+func ReadCreateTime(maxDelay time.Duration) ReadOptionFn {
+	if maxDelay < 0 {
+		panic(fmt.Sprintf("kafkaio.ReadCreateTime maxDelay must not be negative. Got: %v", maxDelay))
+	}
+	return func(o *readOption) {
+		o.Policy = createTime
+		o.MaxDelay = maxDelay
+	}
+}
+
+// ReadCheckpointInterval sets how long a partition is read before
+// checkpointing, after which the offset of the partition is committed. It
+// bounds the latency of commits and of the watermark of caught up
+// partitions. The default is 10s.
+func ReadCheckpointInterval(d time.Duration) ReadOptionFn {
+	if d <= 0 {
+		panic(fmt.Sprintf("kafkaio.ReadCheckpointInterval interval must be positive. Got: %v", d))
+	}
+	return func(o *readOption) {
+		o.CheckpointInterval = d
+	}
+}
+
+// ReadKafkaVersion sets the Kafka version of the brokers, such as "2.8.0",
+// which determines the protocol versions used. The default is "1.0.0".
+func ReadKafkaVersion(version string) ReadOptionFn {
+	mustParseVersion("ReadKafkaVersion", version)
+	return func(o *readOption) {
+		o.Version = version
+	}
+}
+
+// Read reads the records of the given topics from the brokers, given as a
+// comma separated list of bootstrap servers, and returns an unbounded
+// PCollection<Record>. For example:
+//
+//	records := kafkaio.Read(s, "broker-1:9092,broker-2:9092", []string{"t"},
+//		kafkaio.ReadGroup("g"), kafkaio.ReadCreateTime(time.Minute))
+//
+// Each partition of the topics is read from the committed offset of the
+// consumer group set with ReadGroup, or from its oldest offset if it has
+// none, and read in order. Partitions added after the pipeline starts aren't
+// read.
+//
+// By default, records are timestamped at the time they're read, and the
+// watermark is the current time. ReadLogAppendTime and ReadCreateTime
+// timestamp records at their Kafka timestamps instead, in which case the
+// watermark of a partition advances to the time its latest offset was
+// fetched, at each checkpoint, once all records before it have been read, so
+// that the watermark of idle partitions advances. Records without a
+// timestamp, from brokers before Kafka 0.10, are timestamped at the time
+// they're read.
+func Read(s beam.Scope, servers string, topics []string, opts ...ReadOptionFn) beam.PCollection {
+	s = s.Scope("kafkaio.Read")
+	if len(topics) == 0 {
+		panic("kafkaio.Read requires at least one topic")
+	}
+
+	o := readOption{
+		CheckpointInterval: defaultCheckpointInterval,
+		Version:            defaultVersion,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	addrs := splitServers(servers)
+	parts := beam.ParDo(s, &partitionFn{
+		Servers:    addrs,
+		Topics:     topics,
+		Group:      o.Group,
+		FromLatest: o.FromLatest,
+		Version:    o.Version,
+	}, beam.Impulse(s))
+	return beam.ParDo(s, &readFn{
+		Servers:            addrs,
+		Group:              o.Group,
+		Policy:             o.Policy,
+		MaxDelay:           o.MaxDelay,
+		CheckpointInterval: o.CheckpointInterval,
+		Version:            o.Version,
+	}, beam.Reshuffle(s, parts))
+}
+
+// splitServers splits the comma separated list of bootstrap servers.
+func splitServers(servers string) []string {
+	var addrs []string
+	for _, addr := range strings.Split(servers, ",") {
+		if addr = strings.TrimSpace(addr); addr != "" {
+			addrs = append(addrs, addr)
+		}
+	}
+	if len(addrs) == 0 {
+		panic(fmt.Sprintf("kafkaio: no bootstrap servers in %q", servers))
+	}
+	return addrs
+}
+
+// partition is a partition of a topic, to be read from the offset.
+type partition struct {
+	Topic     string
+	Partition int32
+	Offset    int64
+}
+
+// partitionFn outputs the partitions of topics, with the offsets to read
+// them from.
+type partitionFn struct {
+	// Servers are the bootstrap servers.
+	Servers []string `json:"servers"`
+	// Topics are the names of the topics.
+	Topics []string `json:"topics"`
+	// Group is the consumer group whose committed offsets are read from, if
+	// set.
+	Group string `json:"group"`
+	// FromLatest is whether partitions without a committed offset are read
+	// from their latest offset.
+	FromLatest bool `json:"from_latest"`
+	// Version is the Kafka version of the brokers.
+	Version string `json:"version"`
+
+	client sarama.Client
+}
+
+func (f *partitionFn) Setup(ctx context.Context) error {
+	client, err := sarama.NewClient(f.Servers, newConfig(f.Version))
+	if err != nil {
+		return errors.Wrapf(err, "connecting to %v", f.Servers)
+	}
+	f.client = client
+	return nil
+}
+
+func (f *partitionFn) Teardown() error {
+	if f.client == nil {
+		return nil
+	}
+	return f.client.Close()
+}
+
+func (f *partitionFn) ProcessElement(ctx context.Context, _ []byte, emit func(partition)) error {
+	var parts []partition
+	for _, topic := range f.Topics {
+		ids, err := f.client.Partitions(topic)
+		if err != nil {
+			return errors.Wrapf(err, "getting partitions of %v", topic)
+		}
+		for _, id := range ids {
+			parts = append(parts, partition{Topic: topic, Partition: id, Offset: -1})
+		}
+	}
+	if f.Group != "" {
+		if err := f.committed(parts); err != nil {
+			return err
+		}
+	}
+
+	for _, p := range parts {
+		// Committed offsets older than the oldest offset have been deleted
+		// by retention, so the partition is read from its oldest offset.
+		oldest, err := f.client.GetOffset(p.Topic, p.Partition, sarama.OffsetOldest)
+		if err != nil {
+			return errors.Wrapf(err, "getting oldest offset of partition %v of %v", p.Partition, p.Topic)
+		}
+		switch {
+		case p.Offset < 0 && f.FromLatest:
+			latest, err := f.client.GetOffset(p.Topic, p.Partition, sarama.OffsetNewest)
+			if err != nil {
+				return errors.Wrapf(err, "getting latest offset of partition %v of %v", p.Partition, p.Topic)
+			}
+			p.Offset = latest
+		case p.Offset < oldest:
+			p.Offset = oldest
+		}
+		emit(p)
+	}
+	return nil
+}
+
+// committed sets the offsets of the partitions to the committed offsets of
+// the consumer group, or -1 if they have none.
+func (f *partitionFn) committed(parts []partition) error {
+	broker, err := f.client.Coordinator(f.Group)
+	if err != nil {
+		return errors.Wrapf(err, "getting coordinator of group %v", f.Group)
+	}
+	req := &sarama.OffsetFetchRequest{Version: 1, ConsumerGroup: f.Group}
+	for _, p := range parts {
+		req.AddPartition(p.Topic, p.Partition)
+	}
+	resp, err := broker.FetchOffset(req)
+	if err != nil {
+		return errors.Wrapf(err, "fetching offsets of group %v", f.Group)
+	}
+	for i, p := range parts {
+		block := resp.GetBlock(p.Topic, p.Partition)
+		if block == nil {
+			continue
+		}
+		if block.Err != sarama.ErrNoError {
+			return errors.Wrapf(block.Err, "fetching offset of partition %v of %v for group %v", p.Partition, p.Topic, f.Group)
+		}
+		parts[i].Offset = block.Offset
+	}
+	return nil
+}
+
+// readFn reads a partition of a topic. Its restriction is the range of
+// offsets left to read, which it claims as it outputs their records.
+type readFn struct {
+	// Servers are the bootstrap servers.
+	Servers []string `json:"servers"`
+	// Group is the consumer group offsets are committed to, if set.
+	Group string `json:"group"`
+	// Policy is how records are timestamped.
+	Policy timestampPolicy `json:"policy"`
+	// MaxDelay is how out of order create times may be.
+	MaxDelay time.Duration `json:"max_delay"`
+	// CheckpointInterval is how long to read before checkpointing.
+	CheckpointInterval time.Duration `json:"checkpoint_interval"`
+	// Version is the Kafka version of the brokers.
+	Version string `json:"version"`
+
+	client   sarama.Client
+	consumer sarama.Consumer
+}
+
+func (f *readFn) Setup(ctx context.Context) error {
+	cfg := newConfig(f.Version)
+	cfg.Consumer.Return.Errors = true
+	client, err := sarama.NewClient(f.Servers, cfg)
+	if err != nil {
+		return errors.Wrapf(err, "connecting to %v", f.Servers)
+	}
+	f.client = client
+	consumer, err := sarama.NewConsumerFromClient(client)
+	if err != nil {
+		return errors.Wrap(err, "creating consumer")
+	}
+	f.consumer = consumer
+	return nil
+}
+
+func (f *readFn) Teardown() error {
+	if f.consumer != nil {
+		f.consumer.Close()
+	}
+	if f.client == nil {
+		return nil
+	}
+	return f.client.Close()
+}
+
+func (f *readFn) CreateInitialRestriction(p partition) offsetrange.Restriction {
+	return offsetrange.Restriction{Start: p.Offset, End: math.MaxInt64}
+}
+
+func (f *readFn) SplitRestriction(_ partition, rest offsetrange.Restriction) []offsetrange.Restriction {
+	return []offsetrange.Restriction{rest}
+}
+
+func (f *readFn) RestrictionSize(_ partition, rest offsetrange.Restriction) float64 {
+	return rest.Size()
+}
+
+func (f *readFn) CreateTracker(rest offsetrange.Restriction) *sdf.LockRTracker {
+	return sdf.NewLockRTracker(unbounded.NewOffsetTracker(rest))
+}
+
+func (f *readFn) InitialWatermarkEstimatorState(et beam.EventTime, _ offsetrange.Restriction, _ partition) int64 {
+	return int64(et)
+}
+
+func (f *readFn) CreateWatermarkEstimator(state int64) *sdf.ManualWatermarkEstimator {
+	return &sdf.ManualWatermarkEstimator{State: mtime.Time(state).ToTime()}
+}
+
+func (f *readFn) WatermarkEstimatorState(e *sdf.ManualWatermarkEstimator) int64 {
+	return int64(mtime.FromTime(e.State))
+}
+
+func (f *readFn) ProcessElement(ctx context.Context, we *sdf.ManualWatermarkEstimator, bf beam.BundleFinalization, rt *sdf.LockRTracker, p partition, emit func(beam.EventTime, Record)) (sdf.ProcessContinuation, error) {
+	rest := rt.GetRestriction().(offsetrange.Restriction)
+
+	// The offset is committed once the runner has committed the bundle. It
+	// is the offset of the next record to read.
+	next := rest.Start
+	defer func() {
+		if f.Group == "" || next == rest.Start {
+			return
+		}
+		offset := next
+		bf.RegisterCallback(finalizationTimeout, func() error {
+			return f.commit(p, offset)
+		})
+	}()
+
+	// Once the latest offset is reached, all records appended before it was
+	// fetched have been read.
+	now := time.Now()
+	latest, err := f.client.GetOffset(p.Topic, p.Partition, sarama.OffsetNewest)
+	if err != nil {
+		return f.retry(ctx, p, err)
+	}
+	caughtUp := func() {
+		switch {
+		case f.Policy == processingTime:
+			unbounded.AdvanceWatermark(we, time.Now())
+		case next >= latest:
+			unbounded.AdvanceWatermark(we, now.Add(-f.MaxDelay))
+		}
+	}
+	caughtUp()
+
+	pc, err := f.consumer.ConsumePartition(p.Topic, p.Partition, rest.Start)
+	if err != nil {
+		return f.retry(ctx, p, err)
+	}
+	// Close waits for the partition to be released, so that it can be consumed
+	// again when processing resumes.
+	defer pc.Close()
+
+	checkpoint := time.NewTimer(f.CheckpointInterval)
+	defer checkpoint.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return sdf.StopProcessing(), ctx.Err()
+		case <-checkpoint.C:
+			caughtUp()
+			return sdf.ResumeProcessingIn(0), nil
+		case err := <-pc.Errors():
+			return f.retry(ctx, p, err.Err)
+		case m := <-pc.Messages():
+			if !rt.TryClaim(m.Offset) {
+				return sdf.StopProcessing(), nil
+			}
+			next = m.Offset + 1
+			ts := m.Timestamp
+			if f.Policy == processingTime || ts.IsZero() {
+				ts = time.Now()
+			}
+			emit(mtime.FromTime(ts), newRecord(m))
+			unbounded.AdvanceWatermark(we, ts.Add(-f.MaxDelay))
+			caughtUp()
+		}
+	}
+}
+
+// newRecord returns the record of the consumed message.
+func newRecord(m *sarama.ConsumerMessage) Record {
+	r := Record{
+		Topic:     m.Topic,
+		Partition: m.Partition,
+		Offset:    m.Offset,
+		Key:       m.Key,
+		Value:     m.Value,
+	}
+	for _, h := range m.Headers {
+		r.Headers = append(r.Headers, Header{Key: string(h.Key), Value: h.Value})
+	}
+	if !m.Timestamp.IsZero() {
+		r.Timestamp = m.Timestamp.UnixMilli()
+	}
+	return r
+}
+
+// retry resumes reading the partition after the error, if it's retryable,
+// or else fails.
+func (f *readFn) retry(ctx context.Context, p partition, err error) (sdf.ProcessContinuation, error) {
+	if isRetryable(err) {
+		log.Warnf(ctx, "Retrying partition %v of %v: %v", p.Partition, p.Topic, err)
+		return sdf.ResumeProcessingIn(retryInterval), nil
+	}
+	return sdf.StopProcessing(), errors.Wrapf(err, "reading partition %v of %v", p.Partition, p.Topic)
+}
+
+// commit commits the offset of the partition to the consumer group.
+func (f *readFn) commit(p partition, offset int64) error {
+	broker, err := f.client.Coordinator(f.Group)
+	if err != nil {
+		return errors.Wrapf(err, "getting coordinator of group %v", f.Group)
+	}
+	// The offset is committed outside of the group's generations, as the
+	// partitions aren't assigned by the group.
+	req := &sarama.OffsetCommitRequest{
+		Version:                 2,
+		ConsumerGroup:           f.Group,
+		ConsumerGroupGeneration: sarama.GroupGenerationUndefined,
+		RetentionTime:           -1,
+	}
+	req.AddBlock(p.Topic, p.Partition, offset, 0, "")
+	resp, err := broker.CommitOffset(req)
+	if err == nil {
+		if kerr := resp.Errors[p.Topic][p.Partition]; kerr != sarama.ErrNoError {
+			err = kerr
+		}
+	}
+	if err != nil {
+		return errors.Wrapf(err, "committing offset %v of partition %v of %v for group %v", offset, p.Partition, p.Topic, f.Group)
+	}
+	return nil
+}
+
+// isRetryable returns whether the error is transient, such as a broker
+// being unavailable or the leader of a partition changing.
+func isRetryable(err error) bool {
+	switch err {
+	case sarama.ErrOutOfBrokers, sarama.ErrNotConnected, sarama.ErrShuttingDown,
+		sarama.ErrLeaderNotAvailable, sarama.ErrNotLeaderForPartition,
+		sarama.ErrRequestTimedOut, sarama.ErrNetworkException,
+		sarama.ErrBrokerNotAvailable, sarama.ErrReplicaNotAvailable,
+		sarama.ErrUnknownTopicOrPartition, sarama.ErrNotCoordinatorForConsumer,
+		sarama.ErrConsumerCoordinatorNotAvailable, sarama.ErrOffsetsLoadInProgress:
+		return true
+	}
+	_, ok := err.(net.Error)
+	return ok
+}
@@ -0,0 +1,211 @@
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements.  See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License.  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kafkaio
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/core/typex"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/core/util/reflectx"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/internal/errors"
+)
+
+// defaultWriteBatchSize is the maximum number of records sent at a time,
+// unless another is set with WriteBatchSize.
+const defaultWriteBatchSize = 500
+
+func init() {
+	beam.RegisterType(reflect.TypeOf((*writeFn)(nil)).Elem())
+	beam.RegisterFunction(kvRecordFn)
+}
+
+// writeOption holds the options of Write.
+type writeOption struct {
+	BatchSize  int
+	Idempotent bool
+	Version    string
+}
+
+// WriteOptionFn is an option for Write.
+type WriteOptionFn func(*writeOption)
+
+// WriteBatchSize sets the maximum number of records sent at a time. The
+// default is 500.
+func WriteBatchSize(n int) WriteOptionFn {
+	if n < 1 {
+		panic(fmt.Sprintf("kafkaio.WriteBatchSize size must be positive. Got: %v", n))
+	}
+	return func(o *writeOption) {
+		o.BatchSize = n
+	}
+}
+
+// WriteIdempotent enables the idempotent producer, so that records retried
+// by the producer aren't duplicated in the topic. It requires Kafka 0.11 or
+// later, and permission to write idempotently to the cluster.
+func WriteIdempotent() WriteOptionFn {
+	return func(o *writeOption) {
+		o.Idempotent = true
+	}
+}
+
+// WriteKafkaVersion sets the Kafka version of the brokers, such as "2.8.0",
+// which determines the protocol versions used. The default is "1.0.0".
+func WriteKafkaVersion(version string) WriteOptionFn {
+	mustParseVersion("WriteKafkaVersion", version)
+	return func(o *writeOption) {
+		o.Version = version
+	}
+}
+
+// Write writes the records of the given PCollection<Record>, or
+// PCollection<KV<[]byte,[]byte>> of keys and values, to the given topic of
+// the brokers, given as a comma separated list of bootstrap servers. For
+// example:
+//
+//	kafkaio.Write(s, "broker-1:9092,broker-2:9092", "t", records)
+//
+// The topic, partition, and offset of records are ignored; records are
+// partitioned by the hash of their key, or at random if they have none.
+// Records are written with acknowledgement from all in-sync replicas, and a
+// bundle fails unless all of its records are acknowledged, so each record is
+// written at least once. WriteIdempotent avoids duplicates from retries
+// within the producer, but not from retried bundles.
+func Write(s beam.Scope, servers, topic string, col beam.PCollection, opts ...WriteOptionFn) {
+	s = s.Scope("kafkaio.Write")
+	if topic == "" {
+		panic("kafkaio.Write requires a topic")
+	}
+
+	o := writeOption{
+		BatchSize: defaultWriteBatchSize,
+		Version:   defaultVersion,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.Idempotent && !mustParseVersion("Write", o.Version).IsAtLeast(sarama.V0_11_0_0) {
+		panic(fmt.Sprintf("kafkaio.WriteIdempotent requires Kafka 0.11 or later. Got: %v", o.Version))
+	}
+
+	records := col
+	if t := col.Type(); typex.IsKV(t) {
+		if k, v := t.Components()[0].Type(), t.Components()[1].Type(); k != reflectx.ByteSlice || v != reflectx.ByteSlice {
+			panic(fmt.Sprintf("kafkaio.Write requires KV<[]byte,[]byte>. Got: %v", t))
+		}
+		records = beam.ParDo(s, kvRecordFn, col)
+	}
+	beam.ParDo0(s, &writeFn{
+		Servers:    splitServers(servers),
+		Topic:      topic,
+		BatchSize:  o.BatchSize,
+		Idempotent: o.Idempotent,
+		Version:    o.Version,
+	}, records)
+}
+
+func kvRecordFn(key, value []byte) Record {
+	return Record{Key: key, Value: value}
+}
+
+// writeFn sends records to a topic with a synchronous producer.
+type writeFn struct {
+	// Servers are the bootstrap servers.
+	Servers []string `json:"servers"`
+	// Topic is the name of the topic.
+	Topic string `json:"topic"`
+	// BatchSize is the maximum number of records sent at a time.
+	BatchSize int `json:"batch_size"`
+	// Idempotent is whether the producer is idempotent.
+	Idempotent bool `json:"idempotent"`
+	// Version is the Kafka version of the brokers.
+	Version string `json:"version"`
+
+	producer sarama.SyncProducer
+	pending  []*sarama.ProducerMessage
+}
+
+func (f *writeFn) Setup(ctx context.Context) error {
+	cfg := newConfig(f.Version)
+	cfg.Producer.RequiredAcks = sarama.WaitForAll
+	cfg.Producer.Return.Successes = true
+	if f.Idempotent {
+		cfg.Producer.Idempotent = true
+		cfg.Net.MaxOpenRequests = 1
+	}
+	producer, err := sarama.NewSyncProducer(f.Servers, cfg)
+	if err != nil {
+		return errors.Wrapf(err, "creating producer for %v", f.Servers)
+	}
+	f.producer = producer
+	return nil
+}
+
+func (f *writeFn) ProcessElement(ctx context.Context, r Record) error {
+	f.pending = append(f.pending, newMessage(f.Topic, r))
+	if len(f.pending) >= f.BatchSize {
+		return f.flush()
+	}
+	return nil
+}
+
+func (f *writeFn) FinishBundle(ctx context.Context) error {
+	return f.flush()
+}
+
+func (f *writeFn) Teardown() error {
+	if f.producer == nil {
+		return nil
+	}
+	return f.producer.Close()
+}
+
+// newMessage returns the message of the record, to be sent to the topic.
+func newMessage(topic string, r Record) *sarama.ProducerMessage {
+	m := &sarama.ProducerMessage{Topic: topic, Value: sarama.ByteEncoder(r.Value)}
+	if r.Key != nil {
+		m.Key = sarama.ByteEncoder(r.Key)
+	}
+	for _, h := range r.Headers {
+		m.Headers = append(m.Headers, sarama.RecordHeader{Key: []byte(h.Key), Value: h.Value})
+	}
+	if r.Timestamp != 0 {
+		m.Timestamp = time.UnixMilli(r.Timestamp)
+	}
+	return m
+}
+
+// flush sends the pending records, and waits for them to be acknowledged.
+func (f *writeFn) flush() error {
+	if len(f.pending) == 0 {
+		return nil
+	}
+	n := len(f.pending)
+	err := f.producer.SendMessages(f.pending)
+	f.pending = nil
+	if err != nil {
+		if errs, ok := err.(sarama.ProducerErrors); ok && len(errs) > 0 {
+			return errors.Wrapf(errs[0].Err, "writing %v of %v records to %v", len(errs), n, f.Topic)
+		}
+		return errors.Wrapf(err, "writing %v records to %v", n, f.Topic)
+	}
+	return nil
+}
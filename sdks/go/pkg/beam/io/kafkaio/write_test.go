@@ -0,0 +1,173 @@
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements.  See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License.  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kafkaio
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/Shopify/sarama"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/testing/ptest"
+)
+
+func init() {
+	beam.RegisterFunction(keyFn)
+	beam.RegisterFunction(stringKeyFn)
+}
+
+// startProducerBroker starts a mock broker that leads the single partition
+// of the test topic, and responds to produce requests with the error.
+func startProducerBroker(t *testing.T, kerr sarama.KError) *sarama.MockBroker {
+	b := sarama.NewMockBroker(t, 1)
+	t.Cleanup(b.Close)
+	b.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest": sarama.NewMockMetadataResponse(t).
+			SetBroker(b.Addr(), b.BrokerID()).
+			SetLeader(testTopic, 0, b.BrokerID()),
+		"ProduceRequest": sarama.NewMockProduceResponse(t).SetVersion(3).SetError(testTopic, 0, kerr),
+	})
+	return b
+}
+
+// produceRequests returns the number of produce requests the broker
+// received.
+func produceRequests(b *sarama.MockBroker) int {
+	n := 0
+	for _, rr := range b.History() {
+		if _, ok := rr.Request.(*sarama.ProduceRequest); ok {
+			n++
+		}
+	}
+	return n
+}
+
+func TestWrite(t *testing.T) {
+	b := startProducerBroker(t, sarama.ErrNoError)
+
+	p, s := beam.NewPipelineWithRoot()
+	records := beam.Create(s,
+		Record{Key: []byte("k"), Value: []byte("a")},
+		Record{Value: []byte("b")},
+	)
+	Write(s, b.Addr(), testTopic, records)
+	ptest.RunAndValidate(t, p)
+
+	if produceRequests(b) == 0 {
+		t.Error("Write() sent no produce requests")
+	}
+}
+
+func TestWrite_KV(t *testing.T) {
+	b := startProducerBroker(t, sarama.ErrNoError)
+
+	p, s := beam.NewPipelineWithRoot()
+	kvs := beam.ParDo(s, keyFn, beam.Create(s, []byte("a"), []byte("b")))
+	Write(s, b.Addr(), testTopic, kvs)
+	ptest.RunAndValidate(t, p)
+
+	if produceRequests(b) == 0 {
+		t.Error("Write() sent no produce requests")
+	}
+}
+
+func keyFn(v []byte) ([]byte, []byte) {
+	return []byte("k"), v
+}
+
+func stringKeyFn(v []byte) (string, []byte) {
+	return "", v
+}
+
+func TestWriteFn_Error(t *testing.T) {
+	b := startProducerBroker(t, sarama.ErrMessageSizeTooLarge)
+
+	fn := &writeFn{Servers: []string{b.Addr()}, Topic: testTopic, BatchSize: 2, Version: defaultVersion}
+	ctx := context.Background()
+	if err := fn.Setup(ctx); err != nil {
+		t.Fatalf("Setup() failed: %v", err)
+	}
+	defer fn.Teardown()
+	if err := fn.ProcessElement(ctx, Record{Value: []byte("a")}); err != nil {
+		t.Fatalf("ProcessElement() failed before the batch was full: %v", err)
+	}
+	// The bundle fails if its records aren't acknowledged.
+	if err := fn.ProcessElement(ctx, Record{Value: []byte("b")}); err == nil {
+		t.Error("ProcessElement() of a full batch succeeded, want error")
+	}
+	if err := fn.FinishBundle(ctx); err != nil {
+		t.Errorf("FinishBundle() with no pending records failed: %v", err)
+	}
+}
+
+func TestNewMessage(t *testing.T) {
+	r := Record{
+		Topic:     "other",
+		Key:       []byte("k"),
+		Value:     []byte("v"),
+		Headers:   []Header{{Key: "h", Value: []byte("hv")}},
+		Timestamp: testTime.UnixMilli(),
+	}
+	m := newMessage(testTopic, r)
+	want := &sarama.ProducerMessage{
+		Topic:     testTopic,
+		Key:       sarama.ByteEncoder("k"),
+		Value:     sarama.ByteEncoder("v"),
+		Headers:   []sarama.RecordHeader{{Key: []byte("h"), Value: []byte("hv")}},
+		Timestamp: testTime,
+	}
+	if !reflect.DeepEqual(m, want) {
+		t.Errorf("newMessage(%v) = %+v, want %+v", r, m, want)
+	}
+
+	// Records without a key are partitioned at random.
+	if m := newMessage(testTopic, Record{Value: []byte("v")}); m.Key != nil {
+		t.Errorf("newMessage() of a record without a key has key %v, want nil", m.Key)
+	}
+}
+
+func TestWrite_Options(t *testing.T) {
+	tests := []struct {
+		name string
+		fn   func()
+	}{
+		{"no topic", func() {
+			s := beam.NewPipeline().Root()
+			Write(s, "localhost:9092", "", beam.Create(s, Record{}))
+		}},
+		{"invalid KV", func() {
+			s := beam.NewPipeline().Root()
+			Write(s, "localhost:9092", testTopic, beam.ParDo(s, stringKeyFn, beam.Create(s, []byte("a"))))
+		}},
+		{"idempotent before 0.11", func() {
+			s := beam.NewPipeline().Root()
+			Write(s, "localhost:9092", testTopic, beam.Create(s, Record{}), WriteIdempotent(), WriteKafkaVersion("0.10.2.0"))
+		}},
+		{"zero batch size", func() { WriteBatchSize(0) }},
+		{"invalid version", func() { WriteKafkaVersion("x") }},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%v succeeded, want panic", test.name)
+				}
+			}()
+			test.fn()
+		})
+	}
+}
@@ -0,0 +1,141 @@
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements.  See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License.  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package kinesisio contains native Go transforms to read from and write to
+// Amazon Kinesis Data Streams (https://aws.amazon.com/kinesis/data-streams).
+// Unlike the cross-language transforms of the Java SDK, they don't require
+// an expansion service.
+//
+// Credentials and the region are loaded from the default sources of the AWS
+// SDK for Go v2, such as the environment, the shared configuration files and
+// the instance role, unless the region is set with an option. Reading is
+// implemented with a splittable DoFn, so it runs on portable runners that
+// support unbounded splittable DoFns. Experimental.
+package kinesisio
+
+import (
+	"context"
+	stderrors "errors"
+	"reflect"
+
+	"github.com/apache/beam/sdks/v2/go/pkg/beam"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/internal/errors"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis/types"
+	"github.com/aws/smithy-go"
+)
+
+func init() {
+	beam.RegisterType(reflect.TypeOf((*Record)(nil)).Elem())
+}
+
+// Record is a record of a Kinesis data stream, as read by Read. Write writes
+// the partition key, explicit hash key, and data of records.
+type Record struct {
+	ShardID        string
+	SequenceNumber string
+	PartitionKey   string
+	// ExplicitHashKey is the hash key that determines the shard the record
+	// is written to, instead of the hash of its partition key, if it's set.
+	// It's empty for records read.
+	ExplicitHashKey string
+	Data            []byte
+	// ApproximateArrivalTimestamp is the time the record was added to the
+	// stream, in milliseconds since the epoch.
+	ApproximateArrivalTimestamp int64
+}
+
+// client is the part of a *kinesis.Client used by the transforms, so that it
+// can be faked in tests.
+type client interface {
+	ListShards(ctx context.Context, in *kinesis.ListShardsInput, opts ...func(*kinesis.Options)) (*kinesis.ListShardsOutput, error)
+	GetShardIterator(ctx context.Context, in *kinesis.GetShardIteratorInput, opts ...func(*kinesis.Options)) (*kinesis.GetShardIteratorOutput, error)
+	GetRecords(ctx context.Context, in *kinesis.GetRecordsInput, opts ...func(*kinesis.Options)) (*kinesis.GetRecordsOutput, error)
+	DescribeStreamSummary(ctx context.Context, in *kinesis.DescribeStreamSummaryInput, opts ...func(*kinesis.Options)) (*kinesis.DescribeStreamSummaryOutput, error)
+	RegisterStreamConsumer(ctx context.Context, in *kinesis.RegisterStreamConsumerInput, opts ...func(*kinesis.Options)) (*kinesis.RegisterStreamConsumerOutput, error)
+	DescribeStreamConsumer(ctx context.Context, in *kinesis.DescribeStreamConsumerInput, opts ...func(*kinesis.Options)) (*kinesis.DescribeStreamConsumerOutput, error)
+	PutRecords(ctx context.Context, in *kinesis.PutRecordsInput, opts ...func(*kinesis.Options)) (*kinesis.PutRecordsOutput, error)
+	// Subscribe subscribes to a shard with SubscribeToShard, and returns the
+	// event stream of the subscription.
+	Subscribe(ctx context.Context, in *kinesis.SubscribeToShardInput) (eventStream, error)
+}
+
+// eventStream is the event stream of a SubscribeToShard subscription, which
+// is a *kinesis.SubscribeToShardEventStream, so that it can be faked in
+// tests.
+type eventStream interface {
+	Events() <-chan types.SubscribeToShardEventStream
+	Close() error
+	Err() error
+}
+
+// kinesisClient is a client with the default implementation of Subscribe.
+type kinesisClient struct {
+	*kinesis.Client
+}
+
+func (c kinesisClient) Subscribe(ctx context.Context, in *kinesis.SubscribeToShardInput) (eventStream, error) {
+	out, err := c.SubscribeToShard(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+	return out.GetStream(), nil
+}
+
+// newClientFunc returns a client of the region, or of the default region if
+// it's empty, which sends requests to the endpoint, if it's set.
+type newClientFunc func(ctx context.Context, region, endpoint string) (client, error)
+
+// newClient returns a Kinesis client, with the default credentials.
+func newClient(ctx context.Context, region, endpoint string) (client, error) {
+	var opts []func(*config.LoadOptions) error
+	if region != "" {
+		opts = append(opts, config.WithRegion(region))
+	}
+	cfg, err := config.LoadDefaultConfig(ctx, opts...)
+	if err != nil {
+		return nil, errors.Wrap(err, "loading AWS configuration")
+	}
+	return kinesisClient{kinesis.NewFromConfig(cfg, func(o *kinesis.Options) {
+		if endpoint != "" {
+			o.EndpointResolver = kinesis.EndpointResolverFromURL(endpoint)
+		}
+	})}, nil
+}
+
+// errorCode returns the error code of the Kinesis error, or "" if the
+// request failed before a response, such as for a lost connection.
+func errorCode(err error) string {
+	var ae smithy.APIError
+	if !stderrors.As(err, &ae) {
+		return ""
+	}
+	return ae.ErrorCode()
+}
+
+// isRetryable returns whether the read that failed with the error can be
+// retried, because it failed before a response, was throttled, failed within
+// Kinesis, or its shard iterator or subscription can be renewed.
+func isRetryable(err error) bool {
+	switch errorCode(err) {
+	case "", "ProvisionedThroughputExceededException", "LimitExceededException",
+		"KMSThrottlingException", "InternalFailure", "ServiceUnavailable",
+		"ExpiredIteratorException", "ResourceInUseException":
+		return true
+	default:
+		return false
+	}
+}
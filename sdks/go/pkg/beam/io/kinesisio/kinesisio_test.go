@@ -0,0 +1,303 @@
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements.  See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License.  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kinesisio
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/testing/ptest"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis/types"
+	"github.com/aws/smithy-go"
+)
+
+func TestMain(m *testing.M) {
+	ptest.Main(m)
+}
+
+// epoch is the arrival time of the first record of the fake shards.
+var epoch = time.Date(2022, 9, 1, 0, 0, 0, 0, time.UTC)
+
+// fakeShard is a shard of a fakeClient.
+type fakeShard struct {
+	shard   types.Shard
+	records []types.Record
+	// closed is whether the shard was closed by resharding, into the
+	// children.
+	closed   bool
+	children []types.ChildShard
+}
+
+// fakeClient is a client of a stream of shards, which returns pages of two
+// shards or records.
+type fakeClient struct {
+	shards []*fakeShard
+	// err is the error of GetRecords requests, if it's set.
+	err error
+	// failures is the number of PutRecords requests that fail to write their
+	// first record.
+	failures int
+
+	mu         sync.Mutex
+	iterators  []*kinesis.GetShardIteratorInput
+	subscribes []*kinesis.SubscribeToShardInput
+	puts       [][]types.PutRecordsRequestEntry
+	registered bool
+}
+
+func (c *fakeClient) newClient(context.Context, string, string) (client, error) {
+	return c, nil
+}
+
+// addShard adds a shard of n records with the parents to the stream, and
+// returns it.
+func (c *fakeClient) addShard(id string, n int, parents ...string) *fakeShard {
+	s := &fakeShard{shard: types.Shard{ShardId: aws.String(id)}}
+	if len(parents) > 0 {
+		s.shard.ParentShardId = aws.String(parents[0])
+	}
+	if len(parents) > 1 {
+		s.shard.AdjacentParentShardId = aws.String(parents[1])
+	}
+	for i := 0; i < n; i++ {
+		s.records = append(s.records, types.Record{
+			SequenceNumber:              aws.String(fmt.Sprintf("%v-%03d", id, i)),
+			PartitionKey:                aws.String(id),
+			Data:                        []byte(strconv.Itoa(i)),
+			ApproximateArrivalTimestamp: aws.Time(epoch.Add(time.Duration(i) * time.Second)),
+		})
+	}
+	c.shards = append(c.shards, s)
+	return s
+}
+
+// closeShard closes the shard, into the shards added with it as a parent.
+func (c *fakeClient) closeShard(id string) {
+	s, _ := c.shard(id)
+	s.closed = true
+	for _, cs := range c.shards {
+		parents := []string{aws.ToString(cs.shard.ParentShardId)}
+		if cs.shard.AdjacentParentShardId != nil {
+			parents = append(parents, aws.ToString(cs.shard.AdjacentParentShardId))
+		}
+		for _, p := range parents {
+			if p == id {
+				s.children = append(s.children, types.ChildShard{ShardId: cs.shard.ShardId, ParentShards: parents})
+			}
+		}
+	}
+}
+
+func (c *fakeClient) shard(id string) (*fakeShard, error) {
+	for _, s := range c.shards {
+		if aws.ToString(s.shard.ShardId) == id {
+			return s, nil
+		}
+	}
+	return nil, &smithy.GenericAPIError{Code: "ResourceNotFoundException", Message: "shard " + id}
+}
+
+func (c *fakeClient) ListShards(_ context.Context, in *kinesis.ListShardsInput, _ ...func(*kinesis.Options)) (*kinesis.ListShardsOutput, error) {
+	if (in.StreamName == nil) == (in.NextToken == nil) {
+		return nil, &smithy.GenericAPIError{Code: "InvalidArgumentException"}
+	}
+	start := 0
+	if in.NextToken != nil {
+		start, _ = strconv.Atoi(*in.NextToken)
+	}
+	out := &kinesis.ListShardsOutput{}
+	for i := start; i < len(c.shards) && i < start+2; i++ {
+		out.Shards = append(out.Shards, c.shards[i].shard)
+	}
+	if start+2 < len(c.shards) {
+		out.NextToken = aws.String(strconv.Itoa(start + 2))
+	}
+	return out, nil
+}
+
+// start returns the index of the first record of the shard read from the
+// position.
+func (s *fakeShard) start(typ types.ShardIteratorType, seq *string, ts *time.Time) int {
+	for i, r := range s.records {
+		switch typ {
+		case types.ShardIteratorTypeAfterSequenceNumber:
+			if aws.ToString(r.SequenceNumber) > aws.ToString(seq) {
+				return i
+			}
+		case types.ShardIteratorTypeAtTimestamp:
+			if !r.ApproximateArrivalTimestamp.Before(*ts) {
+				return i
+			}
+		default:
+			return i
+		}
+	}
+	return len(s.records)
+}
+
+// The shard iterators are the shard IDs and the indices of their next
+// records.
+func (c *fakeClient) GetShardIterator(_ context.Context, in *kinesis.GetShardIteratorInput, _ ...func(*kinesis.Options)) (*kinesis.GetShardIteratorOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.iterators = append(c.iterators, in)
+	s, err := c.shard(aws.ToString(in.ShardId))
+	if err != nil {
+		return nil, err
+	}
+	i := s.start(in.ShardIteratorType, in.StartingSequenceNumber, in.Timestamp)
+	return &kinesis.GetShardIteratorOutput{ShardIterator: aws.String(fmt.Sprintf("%v/%v", aws.ToString(in.ShardId), i))}, nil
+}
+
+func (c *fakeClient) GetRecords(_ context.Context, in *kinesis.GetRecordsInput, _ ...func(*kinesis.Options)) (*kinesis.GetRecordsOutput, error) {
+	if c.err != nil {
+		return nil, c.err
+	}
+	parts := strings.SplitN(aws.ToString(in.ShardIterator), "/", 2)
+	s, err := c.shard(parts[0])
+	if err != nil {
+		return nil, err
+	}
+	i, _ := strconv.Atoi(parts[1])
+	end := i + 2
+	if end > len(s.records) {
+		end = len(s.records)
+	}
+	out := &kinesis.GetRecordsOutput{
+		Records:            s.records[i:end],
+		MillisBehindLatest: aws.Int64(int64(len(s.records)-end) * 1000),
+		NextShardIterator:  aws.String(fmt.Sprintf("%v/%v", parts[0], end)),
+	}
+	if s.closed && end == len(s.records) {
+		out.NextShardIterator = nil
+		out.ChildShards = s.children
+	}
+	return out, nil
+}
+
+func (c *fakeClient) DescribeStreamSummary(_ context.Context, in *kinesis.DescribeStreamSummaryInput, _ ...func(*kinesis.Options)) (*kinesis.DescribeStreamSummaryOutput, error) {
+	return &kinesis.DescribeStreamSummaryOutput{StreamDescriptionSummary: &types.StreamDescriptionSummary{
+		StreamARN: aws.String("arn:aws:kinesis:eu-west-1:0:stream/" + aws.ToString(in.StreamName)),
+	}}, nil
+}
+
+func (c *fakeClient) RegisterStreamConsumer(_ context.Context, in *kinesis.RegisterStreamConsumerInput, _ ...func(*kinesis.Options)) (*kinesis.RegisterStreamConsumerOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.registered {
+		return nil, &smithy.GenericAPIError{Code: "ResourceInUseException"}
+	}
+	c.registered = true
+	return &kinesis.RegisterStreamConsumerOutput{}, nil
+}
+
+func (c *fakeClient) DescribeStreamConsumer(_ context.Context, in *kinesis.DescribeStreamConsumerInput, _ ...func(*kinesis.Options)) (*kinesis.DescribeStreamConsumerOutput, error) {
+	return &kinesis.DescribeStreamConsumerOutput{ConsumerDescription: &types.ConsumerDescription{
+		ConsumerARN:    aws.String(aws.ToString(in.StreamARN) + "/consumer/" + aws.ToString(in.ConsumerName)),
+		ConsumerStatus: types.ConsumerStatusActive,
+	}}, nil
+}
+
+// Subscribe returns an event stream of an event per two records of the shard
+// from the position, which ends once they've been read.
+func (c *fakeClient) Subscribe(_ context.Context, in *kinesis.SubscribeToShardInput) (eventStream, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.subscribes = append(c.subscribes, in)
+	s, err := c.shard(aws.ToString(in.ShardId))
+	if err != nil {
+		return nil, err
+	}
+	pos := in.StartingPosition
+	events := make(chan types.SubscribeToShardEventStream, len(s.records)/2+1)
+	for i := s.start(pos.Type, pos.SequenceNumber, pos.Timestamp); ; i += 2 {
+		end := i + 2
+		if end > len(s.records) {
+			end = len(s.records)
+		}
+		ev := types.SubscribeToShardEvent{
+			Records:                    s.records[i:end],
+			MillisBehindLatest:         aws.Int64(int64(len(s.records)-end) * 1000),
+			ContinuationSequenceNumber: aws.String("continuation"),
+		}
+		if s.closed && end == len(s.records) {
+			ev.ContinuationSequenceNumber = nil
+			ev.ChildShards = s.children
+		}
+		events <- &types.SubscribeToShardEventStreamMemberSubscribeToShardEvent{Value: ev}
+		if end == len(s.records) {
+			break
+		}
+	}
+	close(events)
+	return fakeEventStream(events), nil
+}
+
+// fakeEventStream is an event stream of the events of a channel.
+type fakeEventStream chan types.SubscribeToShardEventStream
+
+func (s fakeEventStream) Events() <-chan types.SubscribeToShardEventStream { return s }
+func (s fakeEventStream) Close() error                                     { return nil }
+func (s fakeEventStream) Err() error                                       { return nil }
+
+// PutRecords writes the records, except for the first record of the
+// requests that fail.
+func (c *fakeClient) PutRecords(_ context.Context, in *kinesis.PutRecordsInput, _ ...func(*kinesis.Options)) (*kinesis.PutRecordsOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	out := &kinesis.PutRecordsOutput{FailedRecordCount: aws.Int32(0)}
+	var written []types.PutRecordsRequestEntry
+	for i, r := range in.Records {
+		if i == 0 && c.failures > 0 {
+			c.failures--
+			out.FailedRecordCount = aws.Int32(1)
+			out.Records = append(out.Records, types.PutRecordsResultEntry{
+				ErrorCode:    aws.String("ProvisionedThroughputExceededException"),
+				ErrorMessage: aws.String("rate exceeded"),
+			})
+			continue
+		}
+		written = append(written, r)
+		out.Records = append(out.Records, types.PutRecordsResultEntry{SequenceNumber: aws.String(strconv.Itoa(i))})
+	}
+	c.puts = append(c.puts, written)
+	return out, nil
+}
+
+func TestIsRetryable(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{fmt.Errorf("connection reset"), true},
+		{&smithy.GenericAPIError{Code: "ProvisionedThroughputExceededException"}, true},
+		{&smithy.GenericAPIError{Code: "ExpiredIteratorException"}, true},
+		{&smithy.GenericAPIError{Code: "ResourceNotFoundException"}, false},
+		{&smithy.GenericAPIError{Code: "AccessDeniedException"}, false},
+	}
+	for _, test := range tests {
+		if got := isRetryable(test.err); got != test.want {
+			t.Errorf("isRetryable(%v) = %v, want %v", test.err, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,750 @@
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements.  See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License.  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kinesisio
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/apache/beam/sdks/v2/go/pkg/beam"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/core/graph/mtime"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/core/sdf"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/internal/errors"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/io/rtrackers/unbounded"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/log"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis/types"
+)
+
+const (
+	// checkpointInterval is how long shards are read before checkpointing.
+	checkpointInterval = 10 * time.Second
+	// pollInterval is how long reading a shard waits after a GetRecords
+	// request without records.
+	pollInterval = time.Second
+	// getRecordsInterval is the minimum time between GetRecords requests of
+	// a shard, which Kinesis limits to five per second.
+	getRecordsInterval = 200 * time.Millisecond
+	// consumerInterval is how often the status of an enhanced fan-out
+	// consumer is checked while it's being registered.
+	consumerInterval = time.Second
+	// readRetries is how many times reading a shard is retried after
+	// retryable errors, with backoff starting at a second.
+	readRetries = 5
+)
+
+func init() {
+	beam.RegisterType(reflect.TypeOf((*shard)(nil)).Elem())
+	beam.RegisterType(reflect.TypeOf((*shardFn)(nil)).Elem())
+	beam.RegisterType(reflect.TypeOf((*readFn)(nil)).Elem())
+	beam.RegisterType(reflect.TypeOf((*shardRestriction)(nil)).Elem())
+	beam.RegisterType(reflect.TypeOf((*shardTracker)(nil)))
+}
+
+// readOption holds the options of Read.
+type readOption struct {
+	FromLatest bool
+	// From is the time the stream is read from, in milliseconds since the
+	// epoch, or 0.
+	From     int64
+	Consumer string
+	Region   string
+	Endpoint string
+}
+
+// ReadOptionFn is an option for Read.
+type ReadOptionFn func(*readOption)
+
+// ReadFromLatest sets the stream to be read from the time the pipeline
+// starts, rather than from its oldest records.
+func ReadFromLatest() ReadOptionFn {
+	return func(o *readOption) {
+		o.FromLatest = true
+		o.From = 0
+	}
+}
+
+// ReadFromTimestamp sets the stream to be read from the records added at or
+// after the time, rather than from its oldest records.
+func ReadFromTimestamp(t time.Time) ReadOptionFn {
+	if t.IsZero() {
+		panic("kinesisio.ReadFromTimestamp requires a time")
+	}
+	return func(o *readOption) {
+		o.FromLatest = false
+		o.From = t.UnixMilli()
+	}
+}
+
+// ReadEnhancedFanOut sets shards to be read with enhanced fan-out, as the
+// given consumer of the stream, which is registered if it doesn't exist.
+// Enhanced fan-out pushes records to each consumer with its own throughput
+// of 2 MB/s per shard, rather than sharing it with the other readers of the
+// stream, at an additional cost.
+func ReadEnhancedFanOut(consumer string) ReadOptionFn {
+	if consumer == "" {
+		panic("kinesisio.ReadEnhancedFanOut requires a consumer name")
+	}
+	return func(o *readOption) {
+		o.Consumer = consumer
+	}
+}
+
+// ReadRegion sets the AWS region of the stream.
+func ReadRegion(region string) ReadOptionFn {
+	return func(o *readOption) {
+		o.Region = region
+	}
+}
+
+// ReadEndpoint sets the URL of the endpoint requests are sent to, such as
+// "http://localhost:4566" for LocalStack.
+func ReadEndpoint(url string) ReadOptionFn {
+	return func(o *readOption) {
+		o.Endpoint = url
+	}
+}
+
+// Read reads the records of the given stream, and returns an unbounded
+// PCollection<Record>. For example:
+//
+//	records := kinesisio.Read(s, "orders", kinesisio.ReadFromLatest())
+//
+// The shards of the stream are listed when the pipeline starts, and each
+// shard without a parent in the list is read in parallel, from its oldest
+// record, unless set otherwise with ReadFromLatest or ReadFromTimestamp. When
+// a shard is closed by resharding, once it has been read to its end, the
+// shards that follow it are read by the same reader; a shard that two shards
+// were merged into is read after its parent with the lowest shard ID. So the
+// records of a partition key are read in order, except that records of the
+// other parent of a merged shard may be read after those of the merged
+// shard.
+//
+// Records are output timestamped at their approximate arrival time. The
+// watermark of a shard is the arrival time of the last record read, or the
+// time of the last request once the shard is caught up, and the output
+// watermark is the earliest watermark of the shards being read. Shards are
+// read with GetRecords requests, or with SubscribeToShard subscriptions when
+// enhanced fan-out is set with ReadEnhancedFanOut. Reading is checkpointed
+// at the last record output, so records aren't output twice unless a bundle
+// fails. Records aggregated by the Kinesis Producer Library aren't
+// deaggregated.
+func Read(s beam.Scope, stream string, opts ...ReadOptionFn) beam.PCollection {
+	s = s.Scope("kinesisio.Read")
+	if stream == "" {
+		panic("kinesisio.Read requires a stream")
+	}
+
+	var o readOption
+	for _, opt := range opts {
+		opt(&o)
+	}
+	shards := beam.ParDo(s, &shardFn{Stream: stream, Options: o}, beam.Impulse(s))
+	return beam.ParDo(s, &readFn{Stream: stream, Options: o}, beam.Reshuffle(s, shards))
+}
+
+// shard is a shard of a stream to read, with the shards that follow it.
+type shard struct {
+	ShardID string
+	// Timestamp is the time the shard is read from, in milliseconds since
+	// the epoch, or 0 to read it from its oldest record.
+	Timestamp int64
+	// ConsumerARN is the ARN of the enhanced fan-out consumer the shard is
+	// read as, if any.
+	ConsumerARN string
+}
+
+// shardFn outputs the shards of a stream that are read first, which are
+// those without a parent shard, and registers the enhanced fan-out consumer
+// the shards are read as, if any.
+type shardFn struct {
+	// Stream is the name of the stream.
+	Stream string `json:"stream"`
+	// Options specifies the start, consumer and region.
+	Options readOption `json:"options"`
+
+	newClient newClientFunc
+	client    client
+}
+
+func (f *shardFn) Setup(ctx context.Context) error {
+	if f.newClient == nil {
+		f.newClient = newClient
+	}
+	c, err := f.newClient(ctx, f.Options.Region, f.Options.Endpoint)
+	if err != nil {
+		return err
+	}
+	f.client = c
+	return nil
+}
+
+func (f *shardFn) ProcessElement(ctx context.Context, _ []byte, emit func(shard)) error {
+	from := f.Options.From
+	if f.Options.FromLatest {
+		from = time.Now().UnixMilli()
+	}
+	var arn string
+	if f.Options.Consumer != "" {
+		var err error
+		if arn, err = f.register(ctx); err != nil {
+			return err
+		}
+	}
+
+	shards, err := f.list(ctx)
+	if err != nil {
+		return err
+	}
+	listed := make(map[string]bool, len(shards))
+	for _, s := range shards {
+		listed[aws.ToString(s.ShardId)] = true
+	}
+	for _, s := range shards {
+		// Shards with a parent are read once their parent is closed, by its
+		// reader.
+		if listed[aws.ToString(s.ParentShardId)] || listed[aws.ToString(s.AdjacentParentShardId)] {
+			continue
+		}
+		emit(shard{ShardID: aws.ToString(s.ShardId), Timestamp: from, ConsumerARN: arn})
+	}
+	return nil
+}
+
+// list returns the shards of the stream, including the closed shards whose
+// records haven't expired.
+func (f *shardFn) list(ctx context.Context) ([]types.Shard, error) {
+	var shards []types.Shard
+	in := &kinesis.ListShardsInput{StreamName: aws.String(f.Stream)}
+	for {
+		out, err := f.client.ListShards(ctx, in)
+		if err != nil {
+			return nil, errors.Wrapf(err, "listing shards of stream %v", f.Stream)
+		}
+		shards = append(shards, out.Shards...)
+		if out.NextToken == nil {
+			return shards, nil
+		}
+		// The stream name can't be set with a token.
+		in = &kinesis.ListShardsInput{NextToken: out.NextToken}
+	}
+}
+
+// register registers the enhanced fan-out consumer of the stream, unless it
+// exists, and waits for it to be active. It returns the ARN of the consumer.
+func (f *shardFn) register(ctx context.Context) (string, error) {
+	summary, err := f.client.DescribeStreamSummary(ctx, &kinesis.DescribeStreamSummaryInput{StreamName: aws.String(f.Stream)})
+	if err != nil {
+		return "", errors.Wrapf(err, "describing stream %v", f.Stream)
+	}
+	streamARN := summary.StreamDescriptionSummary.StreamARN
+	_, err = f.client.RegisterStreamConsumer(ctx, &kinesis.RegisterStreamConsumerInput{
+		StreamARN:    streamARN,
+		ConsumerName: aws.String(f.Options.Consumer),
+	})
+	if err != nil && errorCode(err) != "ResourceInUseException" {
+		return "", errors.Wrapf(err, "registering consumer %v of stream %v", f.Options.Consumer, f.Stream)
+	}
+	for {
+		out, err := f.client.DescribeStreamConsumer(ctx, &kinesis.DescribeStreamConsumerInput{
+			StreamARN:    streamARN,
+			ConsumerName: aws.String(f.Options.Consumer),
+		})
+		if err != nil {
+			return "", errors.Wrapf(err, "describing consumer %v of stream %v", f.Options.Consumer, f.Stream)
+		}
+		if d := out.ConsumerDescription; d.ConsumerStatus == types.ConsumerStatusActive {
+			return aws.ToString(d.ConsumerARN), nil
+		}
+		select {
+		case <-time.After(consumerInterval):
+		case <-ctx.Done():
+			return "", ctx.Err()
+		}
+	}
+}
+
+// shardRestriction is the restriction of a readFn, which is the positions of
+// the shards left to read: the shard of its element, and then the shards
+// that follow it once it's closed.
+type shardRestriction struct {
+	Shards []shardPosition
+}
+
+// shardPosition is the position a shard is read from.
+type shardPosition struct {
+	ShardID string
+	// Sequence is the sequence number of the last record read, if any.
+	Sequence string
+	// Timestamp is the time the shard is read from if no record has been
+	// read, in milliseconds since the epoch, or 0 to read it from its oldest
+	// record.
+	Timestamp int64
+	// Watermark is the watermark of the shard, in milliseconds since the
+	// epoch.
+	Watermark int64
+}
+
+// watermark returns the earliest watermark of the shards.
+func (r shardRestriction) watermark() time.Time {
+	var wm int64
+	for i, p := range r.Shards {
+		if i == 0 || p.Watermark < wm {
+			wm = p.Watermark
+		}
+	}
+	return time.UnixMilli(wm)
+}
+
+// shardProgress is the position claimed by a readFn for each batch of
+// records of a shard.
+type shardProgress struct {
+	ShardID string
+	// Sequence is the sequence number of the last record of the batch, or ""
+	// if it has none.
+	Sequence  string
+	Watermark int64
+	// Closed is whether the shard has been read to its end, in which case
+	// Children are the shards that follow it which are read next.
+	Closed   bool
+	Children []string
+}
+
+// shardTracker tracks a shardRestriction. Each claim is the progress of a
+// shard of the restriction. The tracker can only be split by checkpointing,
+// since shards are read in order.
+type shardTracker struct {
+	rest    shardRestriction
+	claims  int
+	split   bool // Tracks whether the restriction was checkpointed.
+	stopped bool // Tracks whether TryClaim has indicated to stop processing.
+	err     error
+}
+
+// newShardTracker is a constructor for a shardTracker given a restriction.
+func newShardTracker(rest shardRestriction) *shardTracker {
+	return &shardTracker{rest: rest}
+}
+
+// TryClaim accepts a shardProgress and claims it if its shard is in the
+// restriction, updating the position of the shard, or replacing it with its
+// children if it's closed.
+func (t *shardTracker) TryClaim(rawPos interface{}) bool {
+	if t.stopped {
+		t.err = errors.New("cannot claim work after restriction tracker returns false")
+		return false
+	}
+	if t.split {
+		t.stopped = true
+		return false
+	}
+	pos := rawPos.(shardProgress)
+	i := sort.Search(len(t.rest.Shards), func(i int) bool { return t.rest.Shards[i].ShardID >= pos.ShardID })
+	if i == len(t.rest.Shards) || t.rest.Shards[i].ShardID != pos.ShardID {
+		t.stopped = true
+		t.err = errors.Errorf("cannot claim progress of shard %v, which isn't in the restriction", pos.ShardID)
+		return false
+	}
+
+	// The shards are copied, since restrictions returned by GetRestriction
+	// share them.
+	p := t.rest.Shards[i]
+	shards := make([]shardPosition, 0, len(t.rest.Shards)+len(pos.Children))
+	shards = append(shards, t.rest.Shards[:i]...)
+	shards = append(shards, t.rest.Shards[i+1:]...)
+	if pos.Closed {
+		for _, c := range pos.Children {
+			shards = append(shards, shardPosition{ShardID: c, Timestamp: p.Timestamp, Watermark: pos.Watermark})
+		}
+	} else {
+		if pos.Sequence != "" {
+			p.Sequence = pos.Sequence
+		}
+		p.Watermark = pos.Watermark
+		shards = append(shards, p)
+	}
+	sort.Slice(shards, func(i, j int) bool { return shards[i].ShardID < shards[j].ShardID })
+	t.rest.Shards = shards
+	t.claims++
+	return true
+}
+
+// GetError returns the error that caused the tracker to stop, if there is one.
+func (t *shardTracker) GetError() error {
+	return t.err
+}
+
+// TrySplit only splits when checkpointing, with a fraction of zero. The
+// primary then has no shards left to read, and the residual holds the
+// positions of the shards.
+func (t *shardTracker) TrySplit(fraction float64) (primary, residual interface{}, err error) {
+	if t.stopped || t.IsDone() || fraction > 0 {
+		return t.rest, nil, nil
+	}
+	residual = t.rest
+	t.rest = shardRestriction{}
+	t.split = true
+	return t.rest, residual, nil
+}
+
+// GetProgress reports the number of claims as the work done. Since the
+// number of records left to read is unknown, the shards left to read count
+// as one unit of remaining work.
+func (t *shardTracker) GetProgress() (done, remaining float64) {
+	done = float64(t.claims)
+	if !t.IsDone() {
+		remaining = 1
+	}
+	return done, remaining
+}
+
+// IsDone returns true if no shards are left to read.
+func (t *shardTracker) IsDone() bool {
+	return t.err == nil && (t.stopped || len(t.rest.Shards) == 0)
+}
+
+// GetRestriction returns a copy of the tracker's underlying shardRestriction.
+func (t *shardTracker) GetRestriction() interface{} {
+	return t.rest
+}
+
+// IsBounded returns false, since shards are read until they're closed.
+func (t *shardTracker) IsBounded() bool {
+	return false
+}
+
+// readFn reads shards of a stream, and the shards that follow them. Its
+// restriction is the positions of the shards left to read, which it claims
+// as it outputs their records.
+type readFn struct {
+	// Stream is the name of the stream.
+	Stream string `json:"stream"`
+	// Options specifies the region.
+	Options readOption `json:"options"`
+
+	newClient newClientFunc
+	client    client
+}
+
+func (f *readFn) Setup(ctx context.Context) error {
+	if f.newClient == nil {
+		f.newClient = newClient
+	}
+	c, err := f.newClient(ctx, f.Options.Region, f.Options.Endpoint)
+	if err != nil {
+		return err
+	}
+	f.client = c
+	return nil
+}
+
+func (f *readFn) CreateInitialRestriction(s shard) shardRestriction {
+	return shardRestriction{Shards: []shardPosition{{ShardID: s.ShardID, Timestamp: s.Timestamp}}}
+}
+
+func (f *readFn) SplitRestriction(_ shard, rest shardRestriction) []shardRestriction {
+	return []shardRestriction{rest}
+}
+
+func (f *readFn) RestrictionSize(_ shard, rest shardRestriction) float64 {
+	return float64(len(rest.Shards))
+}
+
+func (f *readFn) CreateTracker(rest shardRestriction) *sdf.LockRTracker {
+	return sdf.NewLockRTracker(newShardTracker(rest))
+}
+
+func (f *readFn) InitialWatermarkEstimatorState(et beam.EventTime, _ shardRestriction, _ shard) int64 {
+	return int64(et)
+}
+
+func (f *readFn) CreateWatermarkEstimator(state int64) *sdf.ManualWatermarkEstimator {
+	return &sdf.ManualWatermarkEstimator{State: mtime.Time(state).ToTime()}
+}
+
+func (f *readFn) WatermarkEstimatorState(e *sdf.ManualWatermarkEstimator) int64 {
+	return int64(mtime.FromTime(e.State))
+}
+
+// shardBatch is the result of reading a shard, which is either a batch of
+// its records, or its failure.
+type shardBatch struct {
+	shardID   string
+	records   []types.Record
+	watermark int64
+	closed    bool
+	children  []string
+	err       error
+}
+
+func (f *readFn) ProcessElement(ctx context.Context, we *sdf.ManualWatermarkEstimator, rt *sdf.LockRTracker, s shard, emit func(beam.EventTime, Record)) (sdf.ProcessContinuation, error) {
+	// Shards are read concurrently, and send their batches to this goroutine
+	// to be output.
+	ctx, cancel := context.WithCancel(ctx)
+	var wg sync.WaitGroup
+	defer func() {
+		cancel()
+		wg.Wait()
+	}()
+	batches := make(chan shardBatch)
+	reading := make(map[string]bool)
+	readNew := func(rest shardRestriction) {
+		for _, p := range rest.Shards {
+			if reading[p.ShardID] {
+				continue
+			}
+			reading[p.ShardID] = true
+			wg.Add(1)
+			go func(p shardPosition) {
+				defer wg.Done()
+				f.readShard(ctx, s.ConsumerARN, p, batches)
+			}(p)
+		}
+	}
+	rest := rt.GetRestriction().(shardRestriction)
+	readNew(rest)
+
+	checkpoint := time.NewTimer(checkpointInterval)
+	defer checkpoint.Stop()
+	for len(rest.Shards) > 0 {
+		var b shardBatch
+		select {
+		case b = <-batches:
+		case <-checkpoint.C:
+			return sdf.ResumeProcessingIn(0), nil
+		}
+		if b.err != nil {
+			return sdf.StopProcessing(), errors.Wrapf(b.err, "reading shard %v of stream %v", b.shardID, f.Stream)
+		}
+
+		progress := shardProgress{ShardID: b.shardID, Watermark: b.watermark, Closed: b.closed, Children: b.children}
+		if n := len(b.records); n > 0 {
+			progress.Sequence = aws.ToString(b.records[n-1].SequenceNumber)
+		}
+		if !rt.TryClaim(progress) {
+			return sdf.StopProcessing(), nil
+		}
+		for _, r := range b.records {
+			emit(mtime.FromTime(aws.ToTime(r.ApproximateArrivalTimestamp)), newRecord(b.shardID, r))
+		}
+		rest = rt.GetRestriction().(shardRestriction)
+		if len(rest.Shards) > 0 {
+			unbounded.AdvanceWatermark(we, rest.watermark())
+		}
+		readNew(rest)
+	}
+	// All shards have been closed, and have no children left to read.
+	return sdf.StopProcessing(), nil
+}
+
+// newRecord returns the record of the shard.
+func newRecord(shardID string, r types.Record) Record {
+	return Record{
+		ShardID:                     shardID,
+		SequenceNumber:              aws.ToString(r.SequenceNumber),
+		PartitionKey:                aws.ToString(r.PartitionKey),
+		Data:                        r.Data,
+		ApproximateArrivalTimestamp: aws.ToTime(r.ApproximateArrivalTimestamp).UnixMilli(),
+	}
+}
+
+// readShard reads the shard from the position, and sends its batches to the
+// batches channel, until it's closed or the context is done. Reading is
+// retried after retryable errors from the last batch sent.
+func (f *readFn) readShard(ctx context.Context, consumerARN string, p shardPosition, batches chan<- shardBatch) {
+	send := func(b shardBatch) bool {
+		b.shardID = p.ShardID
+		select {
+		case batches <- b:
+		case <-ctx.Done():
+			return false
+		}
+		if n := len(b.records); n > 0 {
+			p.Sequence = aws.ToString(b.records[n-1].SequenceNumber)
+		}
+		p.Watermark = b.watermark
+		return true
+	}
+	backoff := time.Second
+	for attempt := 0; ; attempt++ {
+		var err error
+		if consumerARN != "" {
+			err = f.subscribeShard(ctx, consumerARN, &p, send)
+		} else {
+			err = f.pollShard(ctx, &p, send)
+		}
+		switch {
+		case ctx.Err() != nil || err == nil:
+			return
+		case attempt >= readRetries || !isRetryable(err):
+			select {
+			case batches <- shardBatch{shardID: p.ShardID, err: err}:
+			case <-ctx.Done():
+			}
+			return
+		}
+		log.Warnf(ctx, "Retrying read of shard %v of stream %v in %v: %v", p.ShardID, f.Stream, backoff, err)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return
+		}
+		backoff *= 2
+	}
+}
+
+// pollShard reads the shard from the position with GetRecords requests,
+// until it's closed, or send returns false.
+func (f *readFn) pollShard(ctx context.Context, p *shardPosition, send func(shardBatch) bool) error {
+	in := &kinesis.GetShardIteratorInput{StreamName: aws.String(f.Stream), ShardId: aws.String(p.ShardID)}
+	switch {
+	case p.Sequence != "":
+		in.ShardIteratorType = types.ShardIteratorTypeAfterSequenceNumber
+		in.StartingSequenceNumber = aws.String(p.Sequence)
+	case p.Timestamp != 0:
+		in.ShardIteratorType = types.ShardIteratorTypeAtTimestamp
+		in.Timestamp = aws.Time(time.UnixMilli(p.Timestamp))
+	default:
+		in.ShardIteratorType = types.ShardIteratorTypeTrimHorizon
+	}
+	it, err := f.client.GetShardIterator(ctx, in)
+	if err != nil {
+		return err
+	}
+	iterator := it.ShardIterator
+	for {
+		start := time.Now()
+		out, err := f.client.GetRecords(ctx, &kinesis.GetRecordsInput{ShardIterator: iterator})
+		if err != nil {
+			return err
+		}
+		b := newBatch(p, out.Records, out.MillisBehindLatest, start)
+		if out.NextShardIterator == nil {
+			b.closed = true
+			b.children = children(p.ShardID, out.ChildShards)
+		}
+		if !send(b) || b.closed {
+			return nil
+		}
+		iterator = out.NextShardIterator
+
+		wait := getRecordsInterval
+		if len(out.Records) == 0 {
+			wait = pollInterval
+		}
+		select {
+		case <-time.After(time.Until(start.Add(wait))):
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// subscribeShard reads the shard from the position with SubscribeToShard
+// subscriptions of the consumer, until it's closed, or send returns false.
+// Subscriptions expire after five minutes, after which the shard is
+// subscribed to again.
+func (f *readFn) subscribeShard(ctx context.Context, consumerARN string, p *shardPosition, send func(shardBatch) bool) error {
+	for {
+		pos := &types.StartingPosition{}
+		switch {
+		case p.Sequence != "":
+			pos.Type = types.ShardIteratorTypeAfterSequenceNumber
+			pos.SequenceNumber = aws.String(p.Sequence)
+		case p.Timestamp != 0:
+			pos.Type = types.ShardIteratorTypeAtTimestamp
+			pos.Timestamp = aws.Time(time.UnixMilli(p.Timestamp))
+		default:
+			pos.Type = types.ShardIteratorTypeTrimHorizon
+		}
+		stream, err := f.client.Subscribe(ctx, &kinesis.SubscribeToShardInput{
+			ConsumerARN:      aws.String(consumerARN),
+			ShardId:          aws.String(p.ShardID),
+			StartingPosition: pos,
+		})
+		if err != nil {
+			return err
+		}
+		closed, err := f.readEvents(stream, p, send)
+		if err != nil || closed {
+			return err
+		}
+	}
+}
+
+// readEvents sends a batch per event of the subscription, until the shard is
+// closed, the subscription ends, or send returns false. It returns whether
+// reading is done.
+func (f *readFn) readEvents(stream eventStream, p *shardPosition, send func(shardBatch) bool) (bool, error) {
+	defer stream.Close()
+	for ev := range stream.Events() {
+		e, ok := ev.(*types.SubscribeToShardEventStreamMemberSubscribeToShardEvent)
+		if !ok {
+			continue
+		}
+		// Events are pushed as records arrive, so a shard is caught up at
+		// the time of an event that isn't behind.
+		b := newBatch(p, e.Value.Records, e.Value.MillisBehindLatest, time.Now())
+		if e.Value.ContinuationSequenceNumber == nil {
+			b.closed = true
+			b.children = children(p.ShardID, e.Value.ChildShards)
+		}
+		if !send(b) || b.closed {
+			return true, nil
+		}
+	}
+	return false, stream.Err()
+}
+
+// newBatch returns the batch of the records of the shard, with its
+// watermark, given how far behind the latest record of the shard they were,
+// at the time they were requested.
+func newBatch(p *shardPosition, records []types.Record, millisBehind *int64, requested time.Time) shardBatch {
+	b := shardBatch{records: records, watermark: p.Watermark}
+	if n := len(records); n > 0 {
+		b.watermark = aws.ToTime(records[n-1].ApproximateArrivalTimestamp).UnixMilli()
+	}
+	if millisBehind != nil && *millisBehind == 0 {
+		b.watermark = requested.UnixMilli()
+	}
+	if b.watermark < p.Watermark {
+		b.watermark = p.Watermark
+	}
+	return b
+}
+
+// children returns the IDs of the child shards of the closed shard that are
+// read after it. A shard that two shards were merged into is read after its
+// parent with the lowest ID.
+func children(shardID string, shards []types.ChildShard) []string {
+	var ids []string
+	for _, c := range shards {
+		first := shardID
+		for _, parent := range c.ParentShards {
+			if parent < first {
+				first = parent
+			}
+		}
+		if first == shardID {
+			ids = append(ids, aws.ToString(c.ShardId))
+		}
+	}
+	return ids
+}
@@ -0,0 +1,329 @@
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements.  See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License.  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kinesisio
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/apache/beam/sdks/v2/go/pkg/beam"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/core/graph/mtime"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/core/sdf"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis/types"
+	"github.com/aws/smithy-go"
+)
+
+// reshardedStream returns a client of a stream whose shard s0 was split into
+// s2 and s3, then s3 was merged with s1 into s4, and s2 was followed by s5.
+func reshardedStream() *fakeClient {
+	c := &fakeClient{}
+	c.addShard("s0", 3, "expired")
+	c.addShard("s1", 1)
+	c.addShard("s2", 2, "s0")
+	c.addShard("s3", 1, "s0")
+	c.addShard("s4", 2, "s3", "s1")
+	c.addShard("s5", 1, "s2")
+	for _, id := range []string{"s0", "s1", "s2", "s3", "s4", "s5"} {
+		c.closeShard(id)
+	}
+	return c
+}
+
+// shards returns the shards output by a shardFn of the client.
+func shards(t *testing.T, c *fakeClient, opts ...ReadOptionFn) []shard {
+	t.Helper()
+	var o readOption
+	for _, opt := range opts {
+		opt(&o)
+	}
+	fn := &shardFn{Stream: "orders", Options: o, newClient: c.newClient}
+	if err := fn.Setup(context.Background()); err != nil {
+		t.Fatalf("Setup() failed: %v", err)
+	}
+	var got []shard
+	if err := fn.ProcessElement(context.Background(), nil, func(s shard) { got = append(got, s) }); err != nil {
+		t.Fatalf("ProcessElement() failed: %v", err)
+	}
+	return got
+}
+
+func TestShardFn(t *testing.T) {
+	c := reshardedStream()
+	// The shards without a listed parent are read first.
+	if got, want := shards(t, c), []shard{{ShardID: "s0"}, {ShardID: "s1"}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ProcessElement() = %v, want %v", got, want)
+	}
+
+	from := time.Date(2022, 9, 1, 12, 0, 0, 0, time.UTC)
+	got := shards(t, c, ReadFromTimestamp(from))
+	if got[0].Timestamp != from.UnixMilli() {
+		t.Errorf("ProcessElement() = %v, want shards read from %v", got, from.UnixMilli())
+	}
+	before := time.Now().UnixMilli()
+	got = shards(t, c, ReadFromLatest())
+	if got[0].Timestamp < before {
+		t.Errorf("ProcessElement() = %v, want shards read from after %v", got, before)
+	}
+}
+
+func TestShardFn_EnhancedFanOut(t *testing.T) {
+	c := reshardedStream()
+	want := "arn:aws:kinesis:eu-west-1:0:stream/orders/consumer/beam"
+	// The consumer is registered, or used if it already exists.
+	for i := 0; i < 2; i++ {
+		got := shards(t, c, ReadEnhancedFanOut("beam"))
+		if got[0].ConsumerARN != want {
+			t.Errorf("ProcessElement() = %v, want consumer %v", got, want)
+		}
+	}
+}
+
+func TestChildren(t *testing.T) {
+	shards := []types.ChildShard{
+		{ShardId: aws.String("split-1"), ParentShards: []string{"s1"}},
+		{ShardId: aws.String("split-2"), ParentShards: []string{"s1"}},
+		{ShardId: aws.String("merged"), ParentShards: []string{"s2", "s1"}},
+	}
+	if got, want := children("s1", shards), []string{"split-1", "split-2", "merged"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("children(s1) = %v, want %v", got, want)
+	}
+	// A merged shard is read after its parent with the lowest ID.
+	if got := children("s2", shards); got != nil {
+		t.Errorf("children(s2) = %v, want none", got)
+	}
+}
+
+func TestShardTracker(t *testing.T) {
+	tracker := newShardTracker(shardRestriction{Shards: []shardPosition{{ShardID: "s0", Timestamp: 5}, {ShardID: "s1"}}})
+	if !tracker.TryClaim(shardProgress{ShardID: "s1", Sequence: "1", Watermark: 10}) {
+		t.Fatalf("TryClaim(s1) failed: %v", tracker.GetError())
+	}
+	// Batches without records keep the sequence number.
+	if !tracker.TryClaim(shardProgress{ShardID: "s1", Watermark: 20}) {
+		t.Fatalf("TryClaim(s1) failed: %v", tracker.GetError())
+	}
+	// Closed shards are replaced by their children, which are read from the
+	// same time.
+	if !tracker.TryClaim(shardProgress{ShardID: "s0", Sequence: "2", Watermark: 30, Closed: true, Children: []string{"s3", "s2"}}) {
+		t.Fatalf("TryClaim(s0) failed: %v", tracker.GetError())
+	}
+	want := shardRestriction{Shards: []shardPosition{
+		{ShardID: "s1", Sequence: "1", Watermark: 20},
+		{ShardID: "s2", Timestamp: 5, Watermark: 30},
+		{ShardID: "s3", Timestamp: 5, Watermark: 30},
+	}}
+	if got := tracker.GetRestriction(); !reflect.DeepEqual(got, want) {
+		t.Errorf("GetRestriction() = %v, want %v", got, want)
+	}
+	if got, want := want.watermark(), time.UnixMilli(20); !got.Equal(want) {
+		t.Errorf("watermark() = %v, want %v", got, want)
+	}
+
+	// Only checkpoints split the restriction.
+	if _, residual, _ := tracker.TrySplit(0.5); residual != nil {
+		t.Errorf("TrySplit(0.5) = %v, want no residual", residual)
+	}
+	primary, residual, err := tracker.TrySplit(0)
+	if err != nil {
+		t.Fatalf("TrySplit(0) failed: %v", err)
+	}
+	if !reflect.DeepEqual(residual, want) || len(primary.(shardRestriction).Shards) != 0 {
+		t.Errorf("TrySplit(0) = %v, %v, want no shards and %v", primary, residual, want)
+	}
+	if tracker.TryClaim(shardProgress{ShardID: "s1"}) || tracker.GetError() != nil {
+		t.Errorf("TryClaim() after checkpoint succeeded, want to stop: %v", tracker.GetError())
+	}
+	if !tracker.IsDone() {
+		t.Error("IsDone() after checkpoint = false, want true")
+	}
+
+	tracker = newShardTracker(shardRestriction{Shards: []shardPosition{{ShardID: "s0"}}})
+	if tracker.TryClaim(shardProgress{ShardID: "s9"}) || tracker.GetError() == nil {
+		t.Error("TryClaim() of a shard not in the restriction succeeded, want error")
+	}
+}
+
+// read reads the shard with a reader of the client, and returns the records
+// read, and the restriction and watermark after reading.
+func read(t *testing.T, c *fakeClient, s shard, rest shardRestriction) ([]Record, shardRestriction, time.Time) {
+	t.Helper()
+	fn := &readFn{Stream: "orders", newClient: c.newClient}
+	if err := fn.Setup(context.Background()); err != nil {
+		t.Fatalf("Setup() failed: %v", err)
+	}
+	rt := fn.CreateTracker(rest)
+	we := fn.CreateWatermarkEstimator(int64(mtime.MinTimestamp))
+	var records []Record
+	pc, err := fn.ProcessElement(context.Background(), we, rt, s, func(et beam.EventTime, r Record) {
+		if want := mtime.FromMilliseconds(r.ApproximateArrivalTimestamp); et != want {
+			t.Errorf("ProcessElement() output %v at %v, want %v", r, et, want)
+		}
+		records = append(records, r)
+	})
+	if err != nil {
+		t.Fatalf("ProcessElement() failed: %v", err)
+	}
+	if pc.ShouldResume() {
+		t.Errorf("ProcessElement() = %v, want to stop", pc)
+	}
+	return records, rt.GetRestriction().(shardRestriction), we.CurrentWatermark()
+}
+
+// byShard returns the sequence numbers of the records of each shard.
+func byShard(records []Record) map[string][]string {
+	seqs := make(map[string][]string)
+	for _, r := range records {
+		seqs[r.ShardID] = append(seqs[r.ShardID], r.SequenceNumber)
+	}
+	return seqs
+}
+
+func TestReadFn(t *testing.T) {
+	c := reshardedStream()
+	s := shard{ShardID: "s0"}
+	fn := &readFn{}
+	records, rest, wm := read(t, c, s, fn.CreateInitialRestriction(s))
+	// s0 is followed by s2 and s3, and s2 by s5, but s3 isn't followed by
+	// s4, whose other parent s1 has a lower ID.
+	want := map[string][]string{
+		"s0": {"s0-000", "s0-001", "s0-002"},
+		"s2": {"s2-000", "s2-001"},
+		"s3": {"s3-000"},
+		"s5": {"s5-000"},
+	}
+	if got := byShard(records); !reflect.DeepEqual(got, want) {
+		t.Errorf("ProcessElement() read %v, want %v", got, want)
+	}
+	if len(rest.Shards) != 0 {
+		t.Errorf("ProcessElement() left %v to read, want none", rest)
+	}
+	if wm.Before(epoch) {
+		t.Errorf("ProcessElement() watermark = %v, want at least %v", wm, epoch)
+	}
+}
+
+func TestReadFn_Resume(t *testing.T) {
+	c := reshardedStream()
+	rest := shardRestriction{Shards: []shardPosition{{ShardID: "s0", Sequence: "s0-000"}, {ShardID: "s1", Timestamp: epoch.Add(time.Second).UnixMilli()}}}
+	records, _, _ := read(t, c, shard{ShardID: "s0"}, rest)
+	// s0 is read after its last record, and s1 and its child s4 from the
+	// timestamp.
+	got := byShard(records)
+	if want := []string{"s0-001", "s0-002"}; !reflect.DeepEqual(got["s0"], want) {
+		t.Errorf("ProcessElement() read %v of s0, want %v", got["s0"], want)
+	}
+	if want := []string{"s4-001"}; got["s1"] != nil || !reflect.DeepEqual(got["s4"], want) {
+		t.Errorf("ProcessElement() read %v of s1 and %v of s4, want none and %v", got["s1"], got["s4"], want)
+	}
+	for _, in := range c.iterators {
+		if aws.ToString(in.ShardId) == "s1" && in.ShardIteratorType != types.ShardIteratorTypeAtTimestamp {
+			t.Errorf("ProcessElement() read s1 from %v, want %v", in.ShardIteratorType, types.ShardIteratorTypeAtTimestamp)
+		}
+	}
+}
+
+func TestReadFn_EnhancedFanOut(t *testing.T) {
+	c := reshardedStream()
+	s := shard{ShardID: "s1", ConsumerARN: "arn"}
+	records, _, _ := read(t, c, s, shardRestriction{Shards: []shardPosition{{ShardID: "s1"}}})
+	// s1 is followed by s4, since it has the lowest ID of its parents.
+	want := map[string][]string{"s1": {"s1-000"}, "s4": {"s4-000", "s4-001"}}
+	if got := byShard(records); !reflect.DeepEqual(got, want) {
+		t.Errorf("ProcessElement() read %v, want %v", got, want)
+	}
+	if len(c.subscribes) != 2 || aws.ToString(c.subscribes[0].ConsumerARN) != "arn" {
+		t.Errorf("ProcessElement() subscribed with %v, want a subscription of the consumer", c.subscribes)
+	}
+	if len(c.iterators) != 0 {
+		t.Errorf("ProcessElement() got %v shard iterators, want none", len(c.iterators))
+	}
+}
+
+func TestReadFn_Error(t *testing.T) {
+	c := reshardedStream()
+	c.err = &smithy.GenericAPIError{Code: "AccessDeniedException"}
+	fn := &readFn{Stream: "orders", newClient: c.newClient}
+	if err := fn.Setup(context.Background()); err != nil {
+		t.Fatalf("Setup() failed: %v", err)
+	}
+	s := shard{ShardID: "s0"}
+	rt := fn.CreateTracker(fn.CreateInitialRestriction(s))
+	_, err := fn.ProcessElement(context.Background(), &sdf.ManualWatermarkEstimator{}, rt, s, func(beam.EventTime, Record) {})
+	if err == nil {
+		t.Error("ProcessElement() succeeded, want error")
+	}
+}
+
+func TestNewBatch(t *testing.T) {
+	records := reshardedStream().shards[0].records
+	p := &shardPosition{Watermark: epoch.UnixMilli()}
+	now := epoch.Add(time.Hour)
+	tests := []struct {
+		name         string
+		records      []types.Record
+		millisBehind *int64
+		want         time.Time
+	}{
+		{"behind", records[:2], aws.Int64(1000), epoch.Add(time.Second)},
+		{"caught up", records, aws.Int64(0), now},
+		{"empty", nil, aws.Int64(1000), epoch},
+		{"earlier", records[:1], nil, epoch},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := newBatch(p, test.records, test.millisBehind, now).watermark; got != test.want.UnixMilli() {
+				t.Errorf("newBatch() watermark = %v, want %v", time.UnixMilli(got).UTC(), test.want)
+			}
+		})
+	}
+}
+
+func TestRead(t *testing.T) {
+	// The reader can't be given a fake client through the pipeline, so the
+	// pipeline is only built.
+	p, s := beam.NewPipelineWithRoot()
+	col := Read(s, "orders", ReadFromLatest(), ReadEnhancedFanOut("beam"))
+	if got, want := col.Type().Type(), reflect.TypeOf(Record{}); got != want {
+		t.Errorf("Read() = PCollection<%v>, want PCollection<%v>", got, want)
+	}
+	if _, _, err := p.Build(); err != nil {
+		t.Errorf("Build() failed: %v", err)
+	}
+}
+
+func TestRead_Options(t *testing.T) {
+	tests := []struct {
+		name string
+		fn   func()
+	}{
+		{"no stream", func() { Read(beam.NewPipeline().Root(), "") }},
+		{"zero timestamp", func() { ReadFromTimestamp(time.Time{}) }},
+		{"no consumer", func() { ReadEnhancedFanOut("") }},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%v succeeded, want panic", test.name)
+				}
+			}()
+			test.fn()
+		})
+	}
+}
@@ -0,0 +1,237 @@
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements.  See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License.  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kinesisio
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/apache/beam/sdks/v2/go/pkg/beam"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/internal/errors"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/log"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis/types"
+)
+
+const (
+	// maxBatchSize and maxBatchBytes are the maximum number of records and
+	// bytes of a PutRecords request.
+	maxBatchSize          = 500
+	maxBatchBytes         = 5 << 20
+	defaultMaxRetries     = 5
+	defaultInitialBackoff = 100 * time.Millisecond
+	defaultMaxBackoff     = 10 * time.Second
+)
+
+func init() {
+	beam.RegisterType(reflect.TypeOf((*writeFn)(nil)).Elem())
+}
+
+// writeOption holds the options of Write.
+type writeOption struct {
+	BatchSize      int
+	MaxRetries     int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	Region         string
+	Endpoint       string
+}
+
+// WriteOptionFn is an option for Write.
+type WriteOptionFn func(*writeOption)
+
+func newWriteOption(opts []WriteOptionFn) writeOption {
+	o := writeOption{
+		BatchSize:      maxBatchSize,
+		MaxRetries:     defaultMaxRetries,
+		InitialBackoff: defaultInitialBackoff,
+		MaxBackoff:     defaultMaxBackoff,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// WriteBatchSize sets the maximum number of records of each PutRecords
+// request, which is at most 500. The default is 500.
+func WriteBatchSize(n int) WriteOptionFn {
+	if n < 1 || n > maxBatchSize {
+		panic(fmt.Sprintf("kinesisio.WriteBatchSize size must be between 1 and %v. Got: %v", maxBatchSize, n))
+	}
+	return func(o *writeOption) {
+		o.BatchSize = n
+	}
+}
+
+// WriteMaxRetries sets how many times records that Kinesis fails to write,
+// such as when the stream is throttled, are retried. The default is 5.
+func WriteMaxRetries(n int) WriteOptionFn {
+	if n < 0 {
+		panic(fmt.Sprintf("kinesisio.WriteMaxRetries number must not be negative. Got: %v", n))
+	}
+	return func(o *writeOption) {
+		o.MaxRetries = n
+	}
+}
+
+// WriteBackoff sets the initial and maximum backoff between retries, which
+// doubles after each retry. The defaults are 100ms and 10s.
+func WriteBackoff(initial, max time.Duration) WriteOptionFn {
+	if initial <= 0 || max < initial {
+		panic(fmt.Sprintf("kinesisio.WriteBackoff backoffs must be positive, and initial at most max. Got: %v, %v", initial, max))
+	}
+	return func(o *writeOption) {
+		o.InitialBackoff = initial
+		o.MaxBackoff = max
+	}
+}
+
+// WriteRegion sets the AWS region of the stream.
+func WriteRegion(region string) WriteOptionFn {
+	return func(o *writeOption) {
+		o.Region = region
+	}
+}
+
+// WriteEndpoint sets the URL of the endpoint requests are sent to, such as
+// "http://localhost:4566" for LocalStack.
+func WriteEndpoint(url string) WriteOptionFn {
+	return func(o *writeOption) {
+		o.Endpoint = url
+	}
+}
+
+// Write writes the records of the given PCollection<Record> to the given
+// stream. For example:
+//
+//	kinesisio.Write(s, "orders", records, kinesisio.WriteRegion("eu-west-1"))
+//
+// The partition key, explicit hash key, and data of records are written;
+// records are written to the shard of their explicit hash key, if set, or
+// of the hash of their partition key. Records are written with PutRecords
+// requests of the batch size, of at most 5 MiB. Records that Kinesis fails
+// to write, such as when the stream is throttled, are retried with backoff,
+// and fail the bundle if they still fail once their retries are exhausted.
+// Retries may reorder the records of a partition key, and records of
+// retried bundles are written again.
+func Write(s beam.Scope, stream string, col beam.PCollection, opts ...WriteOptionFn) {
+	s = s.Scope("kinesisio.Write")
+	if stream == "" {
+		panic("kinesisio.Write requires a stream")
+	}
+
+	o := newWriteOption(opts)
+	beam.ParDo0(s, &writeFn{Stream: stream, Options: o}, col)
+}
+
+// writeFn writes records with PutRecords requests.
+type writeFn struct {
+	// Stream is the name of the stream.
+	Stream string `json:"stream"`
+	// Options specifies the batch size and retries.
+	Options writeOption `json:"options"`
+
+	newClient newClientFunc
+	client    client
+	batch     []types.PutRecordsRequestEntry
+	bytes     int
+}
+
+func (f *writeFn) Setup(ctx context.Context) error {
+	if f.newClient == nil {
+		f.newClient = newClient
+	}
+	c, err := f.newClient(ctx, f.Options.Region, f.Options.Endpoint)
+	if err != nil {
+		return err
+	}
+	f.client = c
+	return nil
+}
+
+func (f *writeFn) ProcessElement(ctx context.Context, r Record) error {
+	if r.PartitionKey == "" {
+		return errors.Errorf("record for stream %v has no partition key", f.Stream)
+	}
+	// The size of a record counts its partition key and data.
+	size := len(r.PartitionKey) + len(r.Data)
+	if f.bytes+size > maxBatchBytes {
+		if err := f.flush(ctx); err != nil {
+			return err
+		}
+	}
+	entry := types.PutRecordsRequestEntry{PartitionKey: aws.String(r.PartitionKey), Data: r.Data}
+	if r.ExplicitHashKey != "" {
+		entry.ExplicitHashKey = aws.String(r.ExplicitHashKey)
+	}
+	f.batch = append(f.batch, entry)
+	f.bytes += size
+	if len(f.batch) >= f.Options.BatchSize {
+		return f.flush(ctx)
+	}
+	return nil
+}
+
+func (f *writeFn) FinishBundle(ctx context.Context) error {
+	return f.flush(ctx)
+}
+
+// flush writes the batch with PutRecords requests, retrying the records that
+// Kinesis fails to write with backoff.
+func (f *writeFn) flush(ctx context.Context) error {
+	if len(f.batch) == 0 {
+		return nil
+	}
+	batch := f.batch
+	f.batch, f.bytes = nil, 0
+
+	backoff := f.Options.InitialBackoff
+	for attempt := 0; ; attempt++ {
+		out, err := f.client.PutRecords(ctx, &kinesis.PutRecordsInput{StreamName: aws.String(f.Stream), Records: batch})
+		if err != nil {
+			return errors.Wrapf(err, "writing %v records to %v", len(batch), f.Stream)
+		}
+		if aws.ToInt32(out.FailedRecordCount) == 0 {
+			return nil
+		}
+		// The results of the records are in the order of the records.
+		var failed []types.PutRecordsRequestEntry
+		var code, msg string
+		for i, r := range out.Records {
+			if r.ErrorCode != nil {
+				failed = append(failed, batch[i])
+				code, msg = aws.ToString(r.ErrorCode), aws.ToString(r.ErrorMessage)
+			}
+		}
+		batch = failed
+		if attempt >= f.Options.MaxRetries {
+			return errors.Errorf("%v records of %v failed after %v retries, such as with %v: %v", len(batch), f.Stream, attempt, code, msg)
+		}
+		log.Warnf(ctx, "Retrying %v failed records of %v in %v: %v", len(batch), f.Stream, backoff, code)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		if backoff *= 2; backoff > f.Options.MaxBackoff {
+			backoff = f.Options.MaxBackoff
+		}
+	}
+}
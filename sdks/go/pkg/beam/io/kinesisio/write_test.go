@@ -0,0 +1,141 @@
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements.  See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License.  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kinesisio
+
+import (
+	"context"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/apache/beam/sdks/v2/go/pkg/beam"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis/types"
+)
+
+// write writes the records with a writer of the client in one bundle, and
+// returns the error of finishing it.
+func write(t *testing.T, c *fakeClient, opts []WriteOptionFn, records ...Record) error {
+	t.Helper()
+	opts = append([]WriteOptionFn{WriteBackoff(time.Millisecond, time.Millisecond)}, opts...)
+	fn := &writeFn{Stream: "orders", Options: newWriteOption(opts), newClient: c.newClient}
+	ctx := context.Background()
+	if err := fn.Setup(ctx); err != nil {
+		t.Fatalf("Setup() failed: %v", err)
+	}
+	for _, r := range records {
+		if err := fn.ProcessElement(ctx, r); err != nil {
+			return err
+		}
+	}
+	return fn.FinishBundle(ctx)
+}
+
+// records returns n records of n bytes of data.
+func records(n int) []Record {
+	var records []Record
+	for i := 0; i < n; i++ {
+		records = append(records, Record{PartitionKey: strconv.Itoa(i), Data: make([]byte, n)})
+	}
+	return records
+}
+
+// sizes returns the number of records of each PutRecords request.
+func sizes(puts [][]types.PutRecordsRequestEntry) []int {
+	var sizes []int
+	for _, p := range puts {
+		sizes = append(sizes, len(p))
+	}
+	return sizes
+}
+
+func TestWriteFn(t *testing.T) {
+	c := &fakeClient{}
+	rs := records(3)
+	rs[2].ExplicitHashKey = "42"
+	if err := write(t, c, []WriteOptionFn{WriteBatchSize(2)}, rs...); err != nil {
+		t.Fatalf("Write() failed: %v", err)
+	}
+	if got, want := sizes(c.puts), []int{2, 1}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("Write() wrote batches of %v, want %v", got, want)
+	}
+	want := types.PutRecordsRequestEntry{PartitionKey: aws.String("2"), ExplicitHashKey: aws.String("42"), Data: make([]byte, 3)}
+	if got := c.puts[1][0]; !reflect.DeepEqual(got, want) {
+		t.Errorf("Write() wrote %+v, want %+v", got, want)
+	}
+}
+
+func TestWriteFn_Bytes(t *testing.T) {
+	c := &fakeClient{}
+	big := make([]byte, 2<<20)
+	rs := []Record{{PartitionKey: "a", Data: big}, {PartitionKey: "b", Data: big}, {PartitionKey: "c", Data: big}}
+	if err := write(t, c, nil, rs...); err != nil {
+		t.Fatalf("Write() failed: %v", err)
+	}
+	// Requests are at most 5 MiB.
+	if got, want := sizes(c.puts), []int{2, 1}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Write() wrote batches of %v, want %v", got, want)
+	}
+}
+
+func TestWriteFn_Retries(t *testing.T) {
+	c := &fakeClient{failures: 2}
+	if err := write(t, c, nil, records(3)...); err != nil {
+		t.Fatalf("Write() failed: %v", err)
+	}
+	// The failed record is retried until it's written.
+	if got, want := sizes(c.puts), []int{2, 0, 1}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Write() wrote batches of %v, want %v", got, want)
+	}
+
+	c = &fakeClient{failures: 3}
+	if err := write(t, c, []WriteOptionFn{WriteMaxRetries(2)}, records(3)...); err == nil {
+		t.Error("Write() with failed records after the retries succeeded, want error")
+	}
+}
+
+func TestWriteFn_NoPartitionKey(t *testing.T) {
+	if err := write(t, &fakeClient{}, nil, Record{Data: []byte("a")}); err == nil {
+		t.Error("Write() of a record without a partition key succeeded, want error")
+	}
+}
+
+func TestWrite_Options(t *testing.T) {
+	tests := []struct {
+		name string
+		fn   func()
+	}{
+		{"no stream", func() {
+			s := beam.NewPipeline().Root()
+			Write(s, "", beam.Create(s, Record{PartitionKey: "a"}))
+		}},
+		{"zero batch size", func() { WriteBatchSize(0) }},
+		{"batch size above 500", func() { WriteBatchSize(501) }},
+		{"negative max retries", func() { WriteMaxRetries(-1) }},
+		{"zero backoff", func() { WriteBackoff(0, time.Second) }},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%v succeeded, want panic", test.name)
+				}
+			}()
+			test.fn()
+		})
+	}
+}
@@ -0,0 +1,78 @@
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements.  See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License.  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package mongodbio contains transforms to read from and write to MongoDB
+// (https://www.mongodb.com) collections.
+//
+// Documents are decoded into and encoded from Go types with the BSON
+// package of the MongoDB Go driver, so types can be annotated with bson
+// struct tags. Experimental.
+package mongodbio
+
+import (
+	"context"
+
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/internal/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+)
+
+// collection is the part of a *mongo.Collection used by the transforms, so
+// that it can be faked in tests.
+type collection interface {
+	Aggregate(ctx context.Context, pipeline interface{}, opts ...*options.AggregateOptions) (*mongo.Cursor, error)
+	BulkWrite(ctx context.Context, models []mongo.WriteModel, opts ...*options.BulkWriteOptions) (*mongo.BulkWriteResult, error)
+}
+
+// connectFunc connects to a collection of a database of the deployment at
+// the URI, and returns the collection and a function that disconnects from
+// it.
+type connectFunc func(ctx context.Context, uri, database, collection string) (collection, func(context.Context) error, error)
+
+// connect connects to the collection with the MongoDB driver.
+func connect(ctx context.Context, uri, database, coll string) (collection, func(context.Context) error, error) {
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "connecting to MongoDB")
+	}
+	if err := client.Ping(ctx, readpref.Primary()); err != nil {
+		client.Disconnect(ctx)
+		return nil, nil, errors.Wrap(err, "connecting to MongoDB")
+	}
+	return client.Database(database).Collection(coll), client.Disconnect, nil
+}
+
+// marshalPipeline returns the pipeline as a BSON document, so that it can be
+// serialized with the DoFns that run it, keeping the types of its values.
+func marshalPipeline(pipeline mongo.Pipeline) ([]byte, error) {
+	return bson.Marshal(bson.D{{Key: "pipeline", Value: pipeline}})
+}
+
+// unmarshalPipeline returns the pipeline of a BSON document returned by
+// marshalPipeline, or nil if there is none.
+func unmarshalPipeline(data []byte) (mongo.Pipeline, error) {
+	if len(data) == 0 {
+		return nil, nil
+	}
+	var doc struct {
+		Pipeline mongo.Pipeline `bson:"pipeline"`
+	}
+	if err := bson.Unmarshal(data, &doc); err != nil {
+		return nil, errors.Wrap(err, "decoding pipeline")
+	}
+	return doc.Pipeline, nil
+}
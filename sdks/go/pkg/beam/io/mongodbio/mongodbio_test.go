@@ -0,0 +1,93 @@
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements.  See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License.  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mongodbio
+
+import (
+	"context"
+	"testing"
+
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/testing/ptest"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func TestMain(m *testing.M) {
+	ptest.Main(m)
+}
+
+// fakeCollection is a collection that records the pipelines run on it, and
+// outputs the documents of the stage they start or end with, or the
+// documents of the collection. It records its bulk writes, and fails them
+// with writeErr.
+type fakeCollection struct {
+	stats     bson.M
+	buckets   []bson.M
+	docs      []bson.M
+	pipelines []mongo.Pipeline
+
+	writeErr error
+	writes   [][]mongo.WriteModel
+	ordered  []bool
+}
+
+// connect returns a connectFunc that connects to the collection.
+func (c *fakeCollection) connect(context.Context, string, string, string) (collection, func(context.Context) error, error) {
+	return c, func(context.Context) error { return nil }, nil
+}
+
+func (c *fakeCollection) Aggregate(ctx context.Context, pipeline interface{}, _ ...*options.AggregateOptions) (*mongo.Cursor, error) {
+	p := pipeline.(mongo.Pipeline)
+	c.pipelines = append(c.pipelines, p)
+	var docs []bson.M
+	switch {
+	case p[0][0].Key == "$collStats":
+		docs = []bson.M{c.stats}
+	case p[len(p)-1][0].Key == "$bucketAuto":
+		docs = c.buckets
+	default:
+		docs = c.docs
+	}
+	return cursor(docs)
+}
+
+func (c *fakeCollection) BulkWrite(_ context.Context, models []mongo.WriteModel, opts ...*options.BulkWriteOptions) (*mongo.BulkWriteResult, error) {
+	c.writes = append(c.writes, models)
+	c.ordered = append(c.ordered, *options.MergeBulkWriteOptions(opts...).Ordered)
+	if c.writeErr != nil {
+		return nil, c.writeErr
+	}
+	return &mongo.BulkWriteResult{}, nil
+}
+
+// cursor returns a cursor of the documents.
+func cursor(docs []bson.M) (*mongo.Cursor, error) {
+	values := make([]interface{}, len(docs))
+	for i, doc := range docs {
+		values[i] = doc
+	}
+	return mongo.NewCursorFromDocuments(values, nil, nil)
+}
+
+// marshal returns the extended JSON of the document, to compare documents.
+func marshal(t *testing.T, doc interface{}) string {
+	t.Helper()
+	data, err := bson.MarshalExtJSON(doc, true, false)
+	if err != nil {
+		t.Fatalf("Failed to encode %v: %v", doc, err)
+	}
+	return string(data)
+}
@@ -0,0 +1,383 @@
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements.  See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License.  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mongodbio
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+
+	"github.com/apache/beam/sdks/v2/go/pkg/beam"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/internal/errors"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/log"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// defaultBundleSize is the approximate size of the documents of each split
+// of a read, unless another is set with ReadBundleSize.
+const defaultBundleSize = 64 << 20
+
+func init() {
+	beam.RegisterType(reflect.TypeOf((*idRange)(nil)).Elem())
+	beam.RegisterType(reflect.TypeOf((*splitFn)(nil)).Elem())
+	beam.RegisterType(reflect.TypeOf((*readFn)(nil)).Elem())
+}
+
+// documentStages are the aggregation stages that transform each document
+// independently of the others, so that pipelines of only these stages can
+// be run on splits of a collection.
+var documentStages = map[string]bool{
+	"$addFields":   true,
+	"$graphLookup": true,
+	"$lookup":      true,
+	"$match":       true,
+	"$project":     true,
+	"$redact":      true,
+	"$replaceRoot": true,
+	"$replaceWith": true,
+	"$set":         true,
+	"$unset":       true,
+	"$unwind":      true,
+}
+
+// readOption holds the options of Read.
+type readOption struct {
+	// Filter is the filter of the documents read, as a BSON document.
+	Filter []byte
+	// Pipeline is the aggregation pipeline run on the documents read, as a
+	// BSON document.
+	Pipeline   []byte
+	BundleSize int64
+}
+
+// ReadOptionFn is an option for Read.
+type ReadOptionFn func(*readOption)
+
+// ReadFilter sets the query filter of the documents read. By default, all
+// documents of the collection are read.
+func ReadFilter(filter bson.M) ReadOptionFn {
+	data, err := bson.Marshal(filter)
+	if err != nil {
+		panic(fmt.Sprintf("mongodbio.ReadFilter invalid filter %v: %v", filter, err))
+	}
+	return func(o *readOption) {
+		o.Filter = data
+	}
+}
+
+// ReadPipeline sets an aggregation pipeline that is run on the documents
+// read, after they're filtered, so that they can be transformed by MongoDB.
+// The output of the pipeline is decoded into the type read. For example:
+//
+//	pipeline := mongo.Pipeline{
+//		{{Key: "$match", Value: bson.D{{Key: "status", Value: "shipped"}}}},
+//		{{Key: "$project", Value: bson.D{{Key: "total", Value: 1}, {Key: "items", Value: 1}}}},
+//	}
+//	orders := mongodbio.Read(s, uri, "shop", "orders", reflect.TypeOf(Order{}), mongodbio.ReadPipeline(pipeline))
+//
+// The collection is only split if all stages of the pipeline transform each
+// document independently of the others, such as $match, $project,
+// $addFields, $set, $unset, $unwind, $replaceRoot, $replaceWith, $lookup,
+// $graphLookup and $redact, since each split runs the pipeline on its
+// documents. Pipelines with other stages, such as $group or $sort, are run
+// on the whole collection by a single worker.
+func ReadPipeline(pipeline mongo.Pipeline) ReadOptionFn {
+	if len(pipeline) == 0 {
+		panic("mongodbio.ReadPipeline pipeline must not be empty")
+	}
+	data, err := marshalPipeline(pipeline)
+	if err != nil {
+		panic(fmt.Sprintf("mongodbio.ReadPipeline invalid pipeline %v: %v", pipeline, err))
+	}
+	return func(o *readOption) {
+		o.Pipeline = data
+	}
+}
+
+// ReadBundleSize sets the approximate size in bytes of the documents of each
+// split of the collection. The default is 64 MiB.
+func ReadBundleSize(n int64) ReadOptionFn {
+	if n < 1 {
+		panic(fmt.Sprintf("mongodbio.ReadBundleSize size must be positive. Got: %v", n))
+	}
+	return func(o *readOption) {
+		o.BundleSize = n
+	}
+}
+
+// Read reads the documents of the given collection of a database of the
+// MongoDB deployment at the URI, decoded into the given type, t, and returns
+// a PCollection<t>. For example:
+//
+//	orders := mongodbio.Read(s, "mongodb://localhost:27017", "shop", "orders", reflect.TypeOf(Order{}))
+//
+// The collection is split into ranges of _id values with the $bucketAuto
+// aggregation stage, which are read in parallel, so the _id values of the
+// documents read must be of the same type.
+func Read(s beam.Scope, uri, database, collection string, t reflect.Type, opts ...ReadOptionFn) beam.PCollection {
+	s = s.Scope("mongodbio.Read")
+
+	o := readOption{
+		BundleSize: defaultBundleSize,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	imp := beam.Impulse(s)
+	ranges := beam.ParDo(s, &splitFn{URI: uri, Database: database, Collection: collection, Options: o}, imp)
+	ranges = beam.Reshuffle(s, ranges)
+	return beam.ParDo(s, &readFn{URI: uri, Database: database, Collection: collection, Options: o, Type: beam.EncodedType{T: t}}, ranges, beam.TypeDefinition{Var: beam.XType, T: t})
+}
+
+// idRange is a range of _id values of documents of a collection.
+type idRange struct {
+	// Filter is the filter of the _id values of the range, as a BSON
+	// document, or empty if the range is the whole collection.
+	Filter []byte
+}
+
+// bucket is a bucket of _id values output by $bucketAuto.
+type bucket struct {
+	ID struct {
+		Min bson.RawValue `bson:"min"`
+		Max bson.RawValue `bson:"max"`
+	} `bson:"_id"`
+}
+
+// splitFn splits a collection into ranges of _id values of about the bundle
+// size each.
+type splitFn struct {
+	// URI is the connection string of the deployment.
+	URI string `json:"uri"`
+	// Database is the name of the database.
+	Database string `json:"database"`
+	// Collection is the name of the collection.
+	Collection string `json:"collection"`
+	// Options specifies the filter, pipeline and bundle size.
+	Options readOption `json:"options"`
+
+	connect connectFunc
+}
+
+func (f *splitFn) ProcessElement(ctx context.Context, _ []byte, emit func(idRange)) error {
+	pipeline, err := unmarshalPipeline(f.Options.Pipeline)
+	if err != nil {
+		return err
+	}
+	if !splittable(pipeline) {
+		log.Infof(ctx, "Reading %v.%v without splitting, since its pipeline combines documents", f.Database, f.Collection)
+		emit(idRange{})
+		return nil
+	}
+
+	if f.connect == nil {
+		f.connect = connect
+	}
+	coll, disconnect, err := f.connect(ctx, f.URI, f.Database, f.Collection)
+	if err != nil {
+		return err
+	}
+	defer disconnect(ctx)
+
+	size, err := collectionSize(ctx, coll)
+	if err != nil {
+		return err
+	}
+	n := (size + f.Options.BundleSize - 1) / f.Options.BundleSize
+	if n <= 1 {
+		emit(idRange{})
+		return nil
+	}
+	buckets, err := f.buckets(ctx, coll, n)
+	if err != nil {
+		return err
+	}
+	ranges, err := bucketRanges(buckets)
+	if err != nil {
+		return err
+	}
+	log.Infof(ctx, "Reading %v.%v in %v splits", f.Database, f.Collection, len(ranges))
+	for _, r := range ranges {
+		emit(r)
+	}
+	return nil
+}
+
+// buckets returns about n buckets of the _id values of the filtered
+// documents.
+func (f *splitFn) buckets(ctx context.Context, coll collection, n int64) ([]bucket, error) {
+	var pipeline mongo.Pipeline
+	if len(f.Options.Filter) > 0 {
+		pipeline = append(pipeline, bson.D{{Key: "$match", Value: bson.Raw(f.Options.Filter)}})
+	}
+	pipeline = append(pipeline, bson.D{{Key: "$bucketAuto", Value: bson.D{
+		{Key: "groupBy", Value: "$_id"},
+		{Key: "buckets", Value: n},
+	}}})
+	cursor, err := coll.Aggregate(ctx, pipeline, options.Aggregate().SetAllowDiskUse(true))
+	if err != nil {
+		return nil, errors.Wrap(err, "splitting collection")
+	}
+	var buckets []bucket
+	if err := cursor.All(ctx, &buckets); err != nil {
+		return nil, errors.Wrap(err, "splitting collection")
+	}
+	return buckets, nil
+}
+
+// splittable returns whether the pipeline can be run on splits of a
+// collection.
+func splittable(pipeline mongo.Pipeline) bool {
+	for _, stage := range pipeline {
+		if len(stage) != 1 || !documentStages[stage[0].Key] {
+			return false
+		}
+	}
+	return true
+}
+
+// collectionSize returns the size in bytes of the documents of the
+// collection.
+func collectionSize(ctx context.Context, coll collection) (int64, error) {
+	pipeline := mongo.Pipeline{{{Key: "$collStats", Value: bson.D{{Key: "storageStats", Value: bson.D{}}}}}}
+	cursor, err := coll.Aggregate(ctx, pipeline)
+	if err != nil {
+		return 0, errors.Wrap(err, "getting collection size")
+	}
+	defer cursor.Close(ctx)
+	var stats struct {
+		StorageStats struct {
+			Size int64 `bson:"size"`
+		} `bson:"storageStats"`
+	}
+	if !cursor.Next(ctx) {
+		return 0, errors.Wrap(cursor.Err(), "getting collection size")
+	}
+	if err := cursor.Decode(&stats); err != nil {
+		return 0, errors.Wrap(err, "getting collection size")
+	}
+	return stats.StorageStats.Size, nil
+}
+
+// bucketRanges returns the ranges of the buckets, which are unbounded
+// before the first bucket and after the last, so that documents inserted
+// while reading may be read.
+func bucketRanges(buckets []bucket) ([]idRange, error) {
+	if len(buckets) < 2 {
+		return []idRange{{}}, nil
+	}
+	ranges := make([]idRange, len(buckets))
+	for i, b := range buckets {
+		var bounds bson.D
+		if i > 0 {
+			bounds = append(bounds, bson.E{Key: "$gte", Value: b.ID.Min})
+		}
+		if i < len(buckets)-1 {
+			bounds = append(bounds, bson.E{Key: "$lt", Value: b.ID.Max})
+		}
+		filter, err := bson.Marshal(bson.D{{Key: "_id", Value: bounds}})
+		if err != nil {
+			return nil, errors.Wrap(err, "encoding split")
+		}
+		ranges[i] = idRange{Filter: filter}
+	}
+	return ranges, nil
+}
+
+// readFn reads the documents of ranges of a collection, running the
+// pipeline on them.
+type readFn struct {
+	// URI is the connection string of the deployment.
+	URI string `json:"uri"`
+	// Database is the name of the database.
+	Database string `json:"database"`
+	// Collection is the name of the collection.
+	Collection string `json:"collection"`
+	// Options specifies the filter and pipeline.
+	Options readOption `json:"options"`
+	// Type is the type documents are decoded into.
+	Type beam.EncodedType `json:"type"`
+
+	connect    connectFunc
+	coll       collection
+	disconnect func(context.Context) error
+}
+
+func (f *readFn) Setup(ctx context.Context) error {
+	if f.connect == nil {
+		f.connect = connect
+	}
+	coll, disconnect, err := f.connect(ctx, f.URI, f.Database, f.Collection)
+	if err != nil {
+		return err
+	}
+	f.coll, f.disconnect = coll, disconnect
+	return nil
+}
+
+func (f *readFn) Teardown(ctx context.Context) error {
+	if f.disconnect == nil {
+		return nil
+	}
+	return f.disconnect(ctx)
+}
+
+func (f *readFn) ProcessElement(ctx context.Context, r idRange, emit func(beam.X)) error {
+	pipeline, err := f.pipeline(r)
+	if err != nil {
+		return err
+	}
+	cursor, err := f.coll.Aggregate(ctx, pipeline, options.Aggregate().SetAllowDiskUse(true))
+	if err != nil {
+		return errors.Wrapf(err, "reading %v.%v", f.Database, f.Collection)
+	}
+	defer cursor.Close(ctx)
+	for cursor.Next(ctx) {
+		doc := reflect.New(f.Type.T)
+		if err := cursor.Decode(doc.Interface()); err != nil {
+			return errors.Wrapf(err, "decoding document of %v.%v into %v", f.Database, f.Collection, f.Type.T)
+		}
+		emit(doc.Elem().Interface())
+	}
+	return errors.Wrapf(cursor.Err(), "reading %v.%v", f.Database, f.Collection)
+}
+
+// pipeline returns the pipeline that reads the range, which matches the
+// filtered documents of the range before running the pipeline on them.
+func (f *readFn) pipeline(r idRange) (mongo.Pipeline, error) {
+	var filters bson.A
+	if len(r.Filter) > 0 {
+		filters = append(filters, bson.Raw(r.Filter))
+	}
+	if len(f.Options.Filter) > 0 {
+		filters = append(filters, bson.Raw(f.Options.Filter))
+	}
+	var pipeline mongo.Pipeline
+	switch len(filters) {
+	case 0:
+	case 1:
+		pipeline = append(pipeline, bson.D{{Key: "$match", Value: filters[0]}})
+	default:
+		pipeline = append(pipeline, bson.D{{Key: "$match", Value: bson.D{{Key: "$and", Value: filters}}}})
+	}
+	stages, err := unmarshalPipeline(f.Options.Pipeline)
+	if err != nil {
+		return nil, err
+	}
+	return append(pipeline, stages...), nil
+}
@@ -0,0 +1,189 @@
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements.  See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License.  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mongodbio
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/apache/beam/sdks/v2/go/pkg/beam"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type order struct {
+	ID     int    `bson:"_id"`
+	Status string `bson:"status"`
+}
+
+// readOptions returns the read options with the defaults of Read.
+func readOptions(opts ...ReadOptionFn) readOption {
+	o := readOption{BundleSize: defaultBundleSize}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// split returns the ranges output by the splitter.
+func split(t *testing.T, fn *splitFn) []idRange {
+	t.Helper()
+	var got []idRange
+	if err := fn.ProcessElement(context.Background(), nil, func(r idRange) { got = append(got, r) }); err != nil {
+		t.Fatalf("ProcessElement() failed: %v", err)
+	}
+	return got
+}
+
+func TestSplitFn(t *testing.T) {
+	c := &fakeCollection{
+		stats: bson.M{"storageStats": bson.M{"size": 250}},
+		buckets: []bson.M{
+			{"_id": bson.M{"min": 1, "max": 10}},
+			{"_id": bson.M{"min": 10, "max": 20}},
+			{"_id": bson.M{"min": 20, "max": 30}},
+		},
+	}
+	fn := &splitFn{Options: readOptions(ReadFilter(bson.M{"status": "shipped"}), ReadBundleSize(100)), connect: c.connect}
+	got := split(t, fn)
+
+	// The filtered documents are split into a bucket per bundle size, and the
+	// first and last ranges are unbounded.
+	buckets := mongo.Pipeline{
+		{{Key: "$match", Value: bson.D{{Key: "status", Value: "shipped"}}}},
+		{{Key: "$bucketAuto", Value: bson.D{{Key: "groupBy", Value: "$_id"}, {Key: "buckets", Value: int64(3)}}}},
+	}
+	if got, want := marshal(t, bson.D{{Key: "pipeline", Value: c.pipelines[1]}}), marshal(t, bson.D{{Key: "pipeline", Value: buckets}}); got != want {
+		t.Errorf("ProcessElement() ran %v, want %v", got, want)
+	}
+	want := []bson.D{
+		{{Key: "_id", Value: bson.D{{Key: "$lt", Value: 10}}}},
+		{{Key: "_id", Value: bson.D{{Key: "$gte", Value: 10}, {Key: "$lt", Value: 20}}}},
+		{{Key: "_id", Value: bson.D{{Key: "$gte", Value: 20}}}},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("ProcessElement() = %v ranges, want %v", len(got), len(want))
+	}
+	for i, r := range got {
+		if got, want := marshal(t, bson.Raw(r.Filter)), marshal(t, want[i]); got != want {
+			t.Errorf("ProcessElement() range %v = %v, want %v", i, got, want)
+		}
+	}
+}
+
+func TestSplitFn_Unsplit(t *testing.T) {
+	tests := []struct {
+		name string
+		opts []ReadOptionFn
+		runs int
+	}{
+		{"small collection", nil, 1},
+		{"pipeline combining documents", []ReadOptionFn{ReadPipeline(mongo.Pipeline{
+			{{Key: "$match", Value: bson.D{{Key: "status", Value: "shipped"}}}},
+			{{Key: "$group", Value: bson.D{{Key: "_id", Value: "$customer"}}}},
+		})}, 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := &fakeCollection{stats: bson.M{"storageStats": bson.M{"size": 10}}}
+			fn := &splitFn{Options: readOptions(test.opts...), connect: c.connect}
+			// The whole collection is read as one range.
+			if got := split(t, fn); len(got) != 1 || len(got[0].Filter) != 0 {
+				t.Errorf("ProcessElement() = %v, want the whole collection", got)
+			}
+			if len(c.pipelines) != test.runs {
+				t.Errorf("ProcessElement() ran %v pipelines, want %v", len(c.pipelines), test.runs)
+			}
+		})
+	}
+}
+
+func TestSplittable(t *testing.T) {
+	tests := []struct {
+		pipeline mongo.Pipeline
+		want     bool
+	}{
+		{nil, true},
+		{mongo.Pipeline{{{Key: "$match", Value: bson.D{}}}, {{Key: "$unwind", Value: "$items"}}, {{Key: "$project", Value: bson.D{}}}}, true},
+		{mongo.Pipeline{{{Key: "$match", Value: bson.D{}}}, {{Key: "$sort", Value: bson.D{{Key: "total", Value: -1}}}}}, false},
+		{mongo.Pipeline{{{Key: "$limit", Value: 10}}}, false},
+	}
+	for _, test := range tests {
+		if got := splittable(test.pipeline); got != test.want {
+			t.Errorf("splittable(%v) = %v, want %v", test.pipeline, got, test.want)
+		}
+	}
+}
+
+func TestReadFn(t *testing.T) {
+	c := &fakeCollection{docs: []bson.M{{"_id": 1, "status": "shipped"}, {"_id": 2, "status": "shipped"}}}
+	project := bson.D{{Key: "$project", Value: bson.D{{Key: "status", Value: 1}}}}
+	fn := &readFn{
+		Options: readOptions(ReadFilter(bson.M{"status": "shipped"}), ReadPipeline(mongo.Pipeline{project})),
+		Type:    beam.EncodedType{T: reflect.TypeOf(order{})},
+		connect: c.connect,
+	}
+	if err := fn.Setup(context.Background()); err != nil {
+		t.Fatalf("Setup() failed: %v", err)
+	}
+	defer fn.Teardown(context.Background())
+
+	r, err := bson.Marshal(bson.D{{Key: "_id", Value: bson.D{{Key: "$gte", Value: 1}}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []order
+	if err := fn.ProcessElement(context.Background(), idRange{Filter: r}, func(x beam.X) { got = append(got, x.(order)) }); err != nil {
+		t.Fatalf("ProcessElement() failed: %v", err)
+	}
+	if want := []order{{1, "shipped"}, {2, "shipped"}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ProcessElement() = %v, want %v", got, want)
+	}
+
+	// The documents of the range are filtered before the pipeline runs.
+	want := mongo.Pipeline{
+		{{Key: "$match", Value: bson.D{{Key: "$and", Value: bson.A{
+			bson.D{{Key: "_id", Value: bson.D{{Key: "$gte", Value: 1}}}},
+			bson.D{{Key: "status", Value: "shipped"}},
+		}}}}},
+		project,
+	}
+	if got, want := marshal(t, bson.D{{Key: "pipeline", Value: c.pipelines[0]}}), marshal(t, bson.D{{Key: "pipeline", Value: want}}); got != want {
+		t.Errorf("ProcessElement() ran %v, want %v", got, want)
+	}
+}
+
+func TestRead_Options(t *testing.T) {
+	tests := []struct {
+		name string
+		fn   func()
+	}{
+		{"empty pipeline", func() { ReadPipeline(nil) }},
+		{"zero bundle size", func() { ReadBundleSize(0) }},
+		{"invalid filter", func() { ReadFilter(bson.M{"a": make(chan int)}) }},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%v succeeded, want panic", test.name)
+				}
+			}()
+			test.fn()
+		})
+	}
+}
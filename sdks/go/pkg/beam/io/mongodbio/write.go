@@ -0,0 +1,291 @@
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements.  See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License.  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mongodbio
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/apache/beam/sdks/v2/go/pkg/beam"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/internal/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// defaultWriteBatchSize is the maximum number of writes of each bulk write,
+// unless another is set with WriteBatchSize.
+const defaultWriteBatchSize = 1000
+
+func init() {
+	beam.RegisterType(reflect.TypeOf((*writeFn)(nil)).Elem())
+}
+
+// writeMode is how documents are written.
+type writeMode int
+
+const (
+	insertMode writeMode = iota
+	replaceMode
+	updateMode
+	deleteMode
+)
+
+// writeOption holds the options of Write.
+type writeOption struct {
+	Mode writeMode
+	// Fields are the fields of documents that match the documents they
+	// replace, update or delete.
+	Fields    []string
+	Ordered   bool
+	BatchSize int
+}
+
+// WriteOptionFn is an option for Write.
+type WriteOptionFn func(*writeOption)
+
+// WriteReplace sets each document to replace the document with the same
+// values of the given fields, or to be inserted if there is none, with
+// replaceOne writes that upsert. The replaced documents must have the same
+// _id as the documents replacing them, or the documents replacing them must
+// have none.
+func WriteReplace(fields ...string) WriteOptionFn {
+	return replaceMode.option("WriteReplace", fields)
+}
+
+// WriteUpdate sets each document to update the document with the same values
+// of the given fields, or to be inserted if there is none, with updateOne
+// writes that upsert. The other fields of the document are set on the
+// updated document, except its _id, which is only set on insert.
+func WriteUpdate(fields ...string) WriteOptionFn {
+	return updateMode.option("WriteUpdate", fields)
+}
+
+// WriteDelete sets each document to delete the document with the same values
+// of the given fields, with deleteOne writes.
+func WriteDelete(fields ...string) WriteOptionFn {
+	return deleteMode.option("WriteDelete", fields)
+}
+
+// option returns an option that sets the mode, keyed by the fields.
+func (m writeMode) option(name string, fields []string) WriteOptionFn {
+	if len(fields) == 0 {
+		panic(fmt.Sprintf("mongodbio.%v fields must not be empty", name))
+	}
+	return func(o *writeOption) {
+		o.Mode = m
+		o.Fields = fields
+	}
+}
+
+// WriteUnordered sets the writes of each bulk write to be unordered, so that
+// the server may apply them in parallel, and continues to apply them after
+// one fails. By default, writes are applied in order, and stop at the first
+// that fails.
+func WriteUnordered() WriteOptionFn {
+	return func(o *writeOption) {
+		o.Ordered = false
+	}
+}
+
+// WriteBatchSize sets the maximum number of writes of each bulk write. The
+// default is 1000.
+func WriteBatchSize(n int) WriteOptionFn {
+	if n < 1 {
+		panic(fmt.Sprintf("mongodbio.WriteBatchSize size must be positive. Got: %v", n))
+	}
+	return func(o *writeOption) {
+		o.BatchSize = n
+	}
+}
+
+// Write writes the elements of the given PCollection<T> as documents of the
+// given collection of a database of the MongoDB deployment at the URI, where
+// T is encoded with the BSON package of the MongoDB Go driver. By default,
+// the documents are inserted. For example:
+//
+//	mongodbio.Write(s, "mongodb://localhost:27017", "shop", "orders", orders)
+//
+// With WriteReplace, WriteUpdate or WriteDelete, documents replace, update or
+// delete the documents with the same values of the given fields, which
+// should be indexed. These fields may be nested, as in "customer.id". With
+// replaces and updates, writes are idempotent, so that they can be retried
+// or rerun. For example:
+//
+//	mongodbio.Write(s, uri, "shop", "orders", orders, mongodbio.WriteUpdate("order_id"))
+//
+// Documents are written in bulk writes of WriteBatchSize writes.
+func Write(s beam.Scope, uri, database, collection string, col beam.PCollection, opts ...WriteOptionFn) {
+	s = s.Scope("mongodbio.Write")
+
+	o := writeOption{
+		Ordered:   true,
+		BatchSize: defaultWriteBatchSize,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	beam.ParDo0(s, &writeFn{URI: uri, Database: database, Collection: collection, Options: o}, col)
+}
+
+// writeFn writes documents in bulk writes.
+type writeFn struct {
+	// URI is the connection string of the deployment.
+	URI string `json:"uri"`
+	// Database is the name of the database.
+	Database string `json:"database"`
+	// Collection is the name of the collection.
+	Collection string `json:"collection"`
+	// Options specifies the mode, order and batch size of writes.
+	Options writeOption `json:"options"`
+
+	connect    connectFunc
+	coll       collection
+	disconnect func(context.Context) error
+	models     []mongo.WriteModel
+}
+
+func (f *writeFn) Setup(ctx context.Context) error {
+	if f.connect == nil {
+		f.connect = connect
+	}
+	coll, disconnect, err := f.connect(ctx, f.URI, f.Database, f.Collection)
+	if err != nil {
+		return err
+	}
+	f.coll, f.disconnect = coll, disconnect
+	return nil
+}
+
+func (f *writeFn) Teardown(ctx context.Context) error {
+	if f.disconnect == nil {
+		return nil
+	}
+	return f.disconnect(ctx)
+}
+
+func (f *writeFn) ProcessElement(ctx context.Context, elem beam.X) error {
+	model, err := f.model(elem)
+	if err != nil {
+		return err
+	}
+	f.models = append(f.models, model)
+	if len(f.models) >= f.Options.BatchSize {
+		return f.flush(ctx)
+	}
+	return nil
+}
+
+func (f *writeFn) FinishBundle(ctx context.Context) error {
+	return f.flush(ctx)
+}
+
+// flush writes the pending writes in a bulk write.
+func (f *writeFn) flush(ctx context.Context) error {
+	if len(f.models) == 0 {
+		return nil
+	}
+	opts := options.BulkWrite().SetOrdered(f.Options.Ordered)
+	if _, err := f.coll.BulkWrite(ctx, f.models, opts); err != nil {
+		return errors.Wrapf(err, "writing %v documents to %v.%v", len(f.models), f.Database, f.Collection)
+	}
+	f.models = nil
+	return nil
+}
+
+// model returns the write of the element in the mode of the writer.
+func (f *writeFn) model(elem interface{}) (mongo.WriteModel, error) {
+	doc, err := bson.Marshal(elem)
+	if err != nil {
+		return nil, errors.Wrapf(err, "encoding %T", elem)
+	}
+	if f.Options.Mode == insertMode {
+		return mongo.NewInsertOneModel().SetDocument(bson.Raw(doc)), nil
+	}
+	filter, err := f.filter(doc)
+	if err != nil {
+		return nil, err
+	}
+	switch f.Options.Mode {
+	case replaceMode:
+		return mongo.NewReplaceOneModel().SetFilter(filter).SetReplacement(bson.Raw(doc)).SetUpsert(true), nil
+	case updateMode:
+		update, err := f.update(doc, filter)
+		if err != nil {
+			return nil, err
+		}
+		return mongo.NewUpdateOneModel().SetFilter(filter).SetUpdate(update).SetUpsert(true), nil
+	default:
+		return mongo.NewDeleteOneModel().SetFilter(filter), nil
+	}
+}
+
+// filter returns the filter that matches the values of the fields of the
+// document.
+func (f *writeFn) filter(doc bson.Raw) (bson.D, error) {
+	filter := make(bson.D, len(f.Options.Fields))
+	for i, field := range f.Options.Fields {
+		value, err := doc.LookupErr(strings.Split(field, ".")...)
+		if err != nil {
+			return nil, errors.Wrapf(err, "document %v has no field %v", doc, field)
+		}
+		filter[i] = bson.E{Key: field, Value: value}
+	}
+	return filter, nil
+}
+
+// update returns the update that sets the fields of the document other than
+// the filter fields, and sets its _id if it's inserted, since the _id of
+// documents can't be updated.
+func (f *writeFn) update(doc bson.Raw, filter bson.D) (bson.D, error) {
+	elems, err := doc.Elements()
+	if err != nil {
+		return nil, errors.Wrapf(err, "decoding document %v", doc)
+	}
+	var set, setOnInsert bson.D
+	for _, elem := range elems {
+		key := elem.Key()
+		switch {
+		case containsField(f.Options.Fields, key):
+		case key == "_id":
+			setOnInsert = append(setOnInsert, bson.E{Key: key, Value: elem.Value()})
+		default:
+			set = append(set, bson.E{Key: key, Value: elem.Value()})
+		}
+	}
+	// Updates must set a field, so documents of only filter fields set them
+	// to their values.
+	if len(set) == 0 {
+		set = filter
+	}
+	update := bson.D{{Key: "$set", Value: set}}
+	if len(setOnInsert) > 0 {
+		update = append(update, bson.E{Key: "$setOnInsert", Value: setOnInsert})
+	}
+	return update, nil
+}
+
+// containsField returns whether the field is one of the fields.
+func containsField(fields []string, field string) bool {
+	for _, f := range fields {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
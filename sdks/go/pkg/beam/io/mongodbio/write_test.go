@@ -0,0 +1,220 @@
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements.  See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License.  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mongodbio
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type customer struct {
+	ID   int    `bson:"id"`
+	Name string `bson:"name"`
+}
+
+type shipment struct {
+	ID       string   `bson:"_id,omitempty"`
+	OrderID  int      `bson:"order_id"`
+	Customer customer `bson:"customer"`
+	Status   string   `bson:"status"`
+}
+
+// write writes the documents with the writer in one bundle, and returns the
+// error of finishing it.
+func write(t *testing.T, fn *writeFn, docs ...interface{}) error {
+	t.Helper()
+	ctx := context.Background()
+	if err := fn.Setup(ctx); err != nil {
+		t.Fatalf("Setup() failed: %v", err)
+	}
+	defer fn.Teardown(ctx)
+	for _, doc := range docs {
+		if err := fn.ProcessElement(ctx, doc); err != nil {
+			return err
+		}
+	}
+	return fn.FinishBundle(ctx)
+}
+
+// newWriteFn returns a writer to the collection.
+func newWriteFn(c *fakeCollection, opts ...WriteOptionFn) *writeFn {
+	o := writeOption{Ordered: true, BatchSize: defaultWriteBatchSize}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return &writeFn{Options: o, connect: c.connect}
+}
+
+func TestWriteFn_Insert(t *testing.T) {
+	c := &fakeCollection{}
+	docs := []interface{}{shipment{OrderID: 1}, shipment{OrderID: 2}, shipment{OrderID: 3}}
+	if err := write(t, newWriteFn(c, WriteBatchSize(2)), docs...); err != nil {
+		t.Fatalf("Write() failed: %v", err)
+	}
+	// The documents are inserted in batches, in order.
+	var sizes []int
+	for _, models := range c.writes {
+		sizes = append(sizes, len(models))
+	}
+	if want := []int{2, 1}; !reflect.DeepEqual(sizes, want) {
+		t.Fatalf("Write() wrote batches of %v, want %v", sizes, want)
+	}
+	if want := []bool{true, true}; !reflect.DeepEqual(c.ordered, want) {
+		t.Errorf("Write() wrote ordered %v, want %v", c.ordered, want)
+	}
+	insert := c.writes[1][0].(*mongo.InsertOneModel)
+	if got, want := marshal(t, insert.Document), marshal(t, docs[2]); got != want {
+		t.Errorf("Write() inserted %v, want %v", got, want)
+	}
+}
+
+func TestWriteFn_Modes(t *testing.T) {
+	doc := shipment{ID: "s1", OrderID: 1, Customer: customer{ID: 7, Name: "a"}, Status: "shipped"}
+	tests := []struct {
+		name string
+		opt  WriteOptionFn
+		// get returns the filter, the replacement or update, and the upsert
+		// of the write.
+		get func(m mongo.WriteModel) (interface{}, interface{}, bool)
+		// filter, update and upsert are the expected filter, replacement or
+		// update, and upsert.
+		filter bson.D
+		update interface{}
+		upsert bool
+	}{
+		{
+			name: "replace",
+			opt:  WriteReplace("order_id"),
+			get: func(m mongo.WriteModel) (interface{}, interface{}, bool) {
+				r := m.(*mongo.ReplaceOneModel)
+				return r.Filter, r.Replacement, *r.Upsert
+			},
+			filter: bson.D{{Key: "order_id", Value: 1}},
+			update: doc,
+			upsert: true,
+		},
+		{
+			name: "update",
+			opt:  WriteUpdate("order_id", "customer.id"),
+			get: func(m mongo.WriteModel) (interface{}, interface{}, bool) {
+				u := m.(*mongo.UpdateOneModel)
+				return u.Filter, u.Update, *u.Upsert
+			},
+			filter: bson.D{{Key: "order_id", Value: 1}, {Key: "customer.id", Value: 7}},
+			update: bson.D{
+				{Key: "$set", Value: bson.D{{Key: "customer", Value: doc.Customer}, {Key: "status", Value: "shipped"}}},
+				{Key: "$setOnInsert", Value: bson.D{{Key: "_id", Value: "s1"}}},
+			},
+			upsert: true,
+		},
+		{
+			name: "delete",
+			opt:  WriteDelete("_id"),
+			get: func(m mongo.WriteModel) (interface{}, interface{}, bool) {
+				return m.(*mongo.DeleteOneModel).Filter, nil, false
+			},
+			filter: bson.D{{Key: "_id", Value: "s1"}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := &fakeCollection{}
+			if err := write(t, newWriteFn(c, test.opt), doc); err != nil {
+				t.Fatalf("Write() failed: %v", err)
+			}
+			filter, update, upsert := test.get(c.writes[0][0])
+			if got, want := marshal(t, filter), marshal(t, test.filter); got != want {
+				t.Errorf("Write() filtered %v, want %v", got, want)
+			}
+			if test.update != nil {
+				if got, want := marshal(t, update), marshal(t, test.update); got != want {
+					t.Errorf("Write() wrote %v, want %v", got, want)
+				}
+			}
+			if upsert != test.upsert {
+				t.Errorf("Write() upsert = %v, want %v", upsert, test.upsert)
+			}
+		})
+	}
+}
+
+func TestWriteFn_UpdateFilterFields(t *testing.T) {
+	c := &fakeCollection{}
+	if err := write(t, newWriteFn(c, WriteUpdate("_id")), bson.M{"_id": "s1"}); err != nil {
+		t.Fatalf("Write() failed: %v", err)
+	}
+	// A document of only filter fields sets them, since an update must set a
+	// field.
+	want := bson.D{{Key: "$set", Value: bson.D{{Key: "_id", Value: "s1"}}}}
+	if got, want := marshal(t, c.writes[0][0].(*mongo.UpdateOneModel).Update), marshal(t, want); got != want {
+		t.Errorf("Write() wrote %v, want %v", got, want)
+	}
+}
+
+func TestWriteFn_Unordered(t *testing.T) {
+	c := &fakeCollection{}
+	if err := write(t, newWriteFn(c, WriteUnordered()), shipment{}); err != nil {
+		t.Fatalf("Write() failed: %v", err)
+	}
+	if want := []bool{false}; !reflect.DeepEqual(c.ordered, want) {
+		t.Errorf("Write() wrote ordered %v, want %v", c.ordered, want)
+	}
+}
+
+func TestWriteFn_Errors(t *testing.T) {
+	tests := []struct {
+		name string
+		c    *fakeCollection
+		opts []WriteOptionFn
+	}{
+		{"failed write", &fakeCollection{writeErr: errors.New("duplicate key")}, nil},
+		{"missing field", &fakeCollection{}, []WriteOptionFn{WriteReplace("customer.email")}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := write(t, newWriteFn(test.c, test.opts...), shipment{}); err == nil {
+				t.Errorf("Write() with %v succeeded, want error", test.name)
+			}
+		})
+	}
+}
+
+func TestWrite_Options(t *testing.T) {
+	tests := []struct {
+		name string
+		fn   func()
+	}{
+		{"replace without fields", func() { WriteReplace() }},
+		{"update without fields", func() { WriteUpdate() }},
+		{"delete without fields", func() { WriteDelete() }},
+		{"zero batch size", func() { WriteBatchSize(0) }},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%v succeeded, want panic", test.name)
+				}
+			}()
+			test.fn()
+		})
+	}
+}
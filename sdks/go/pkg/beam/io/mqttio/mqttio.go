@@ -0,0 +1,104 @@
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements.  See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License.  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package mqttio contains transforms to subscribe to and publish to topics
+// of MQTT 3.1.1 brokers, similar to Java's MqttIO.
+//
+// Reading is implemented with a splittable DoFn, so it runs on portable
+// runners that support unbounded splittable DoFns and bundle finalization.
+// Experimental.
+package mqttio
+
+import (
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/apache/beam/sdks/v2/go/pkg/beam"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/internal/errors"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// connectTimeout is how long connecting and subscribing wait for the broker.
+const connectTimeout = 30 * time.Second
+
+func init() {
+	beam.RegisterType(reflect.TypeOf((*Message)(nil)).Elem())
+}
+
+// Message is an MQTT message, as read by Read from a subscription, or
+// written by Write to a topic.
+type Message struct {
+	// Topic is the topic the message was published to. Write publishes to
+	// it, if set.
+	Topic   string
+	Payload []byte
+	// QoS is the quality of service the message was delivered with, which is
+	// at most that of the subscription. Write ignores it.
+	QoS uint8
+	// Retained is whether the message was retained by the broker, and
+	// delivered because the subscription was made after it was published.
+	// Write publishes the message as retained if it's set.
+	Retained bool
+	// Duplicate is whether the message may have been delivered before.
+	// Write ignores it.
+	Duplicate bool
+}
+
+// newClientFunc returns a client with the options.
+type newClientFunc func(o *mqtt.ClientOptions) mqtt.Client
+
+// credentials are the credentials of a client.
+type credentials struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// clientOptions returns the options of a client of the broker.
+func clientOptions(broker, clientID string, c credentials) *mqtt.ClientOptions {
+	return mqtt.NewClientOptions().
+		AddBroker(broker).
+		SetClientID(clientID).
+		SetUsername(c.Username).
+		SetPassword(c.Password).
+		SetConnectTimeout(connectTimeout)
+}
+
+// wait waits for the token of the operation to complete, and returns its
+// error.
+func wait(t mqtt.Token, op string) error {
+	if !t.WaitTimeout(connectTimeout) {
+		return errors.Errorf("%v timed out after %v", op, connectTimeout)
+	}
+	return errors.Wrap(t.Error(), op)
+}
+
+// validateQoS panics if the quality of service isn't 0, 1 or 2.
+func validateQoS(name string, qos uint8) {
+	if qos > 2 {
+		panic(fmt.Sprintf("mqttio.%v QoS must be 0, 1 or 2. Got: %v", name, qos))
+	}
+}
+
+// newMessage returns the message of the received message.
+func newMessage(m mqtt.Message) Message {
+	return Message{
+		Topic:     m.Topic(),
+		Payload:   m.Payload(),
+		QoS:       m.Qos(),
+		Retained:  m.Retained(),
+		Duplicate: m.Duplicate(),
+	}
+}
@@ -0,0 +1,313 @@
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements.  See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License.  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mqttio
+
+import (
+	"context"
+	"errors"
+	"math"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/apache/beam/sdks/v2/go/pkg/beam"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/core/graph/mtime"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/core/sdf"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/io/rtrackers/offsetrange"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/io/rtrackers/unbounded/unboundedtest"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/testing/ptest"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+const testBroker = "tcp://fake:1883"
+
+func TestMain(m *testing.M) {
+	ptest.Main(m)
+}
+
+// fakeToken is a completed token with the error.
+type fakeToken struct {
+	err error
+}
+
+func (t fakeToken) Wait() bool                     { return true }
+func (t fakeToken) WaitTimeout(time.Duration) bool { return true }
+func (t fakeToken) Error() error                   { return t.err }
+
+func (t fakeToken) Done() <-chan struct{} {
+	done := make(chan struct{})
+	close(done)
+	return done
+}
+
+// fakeClient is a client that records the subscriptions, publishes and
+// acknowledgements made with it, and fails publishes with publishErr.
+type fakeClient struct {
+	connectErr error
+	publishErr error
+
+	mu        sync.Mutex
+	opts      *mqtt.ClientOptions
+	connects  int
+	connected bool
+	filters   map[string]byte
+	published []Message
+	acked     []uint16
+}
+
+// newClient returns the fake client, recording its options.
+func (c *fakeClient) newClient(o *mqtt.ClientOptions) mqtt.Client {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.opts = o
+	return c
+}
+
+// deliver delivers a message with the ID to the default handler.
+func (c *fakeClient) deliver(id uint16, topic, payload string, qos uint8) {
+	c.opts.DefaultPublishHandler(c, &fakeMessage{c: c, id: id, topic: topic, payload: []byte(payload), qos: qos})
+}
+
+// disconnect simulates a lost connection.
+func (c *fakeClient) disconnect() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.connected = false
+}
+
+func (c *fakeClient) IsConnected() bool {
+	return c.IsConnectionOpen()
+}
+
+func (c *fakeClient) IsConnectionOpen() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.connected
+}
+
+func (c *fakeClient) Connect() mqtt.Token {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.connectErr != nil {
+		return fakeToken{c.connectErr}
+	}
+	c.connects++
+	c.connected = true
+	return fakeToken{}
+}
+
+func (c *fakeClient) Disconnect(uint) {
+	c.disconnect()
+}
+
+func (c *fakeClient) Publish(topic string, qos byte, retained bool, payload interface{}) mqtt.Token {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.published = append(c.published, Message{Topic: topic, Payload: payload.([]byte), QoS: qos, Retained: retained})
+	return fakeToken{c.publishErr}
+}
+
+func (c *fakeClient) Subscribe(topic string, qos byte, callback mqtt.MessageHandler) mqtt.Token {
+	return c.SubscribeMultiple(map[string]byte{topic: qos}, callback)
+}
+
+func (c *fakeClient) SubscribeMultiple(filters map[string]byte, _ mqtt.MessageHandler) mqtt.Token {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.filters = filters
+	return fakeToken{}
+}
+
+func (c *fakeClient) Unsubscribe(...string) mqtt.Token {
+	return fakeToken{}
+}
+
+func (c *fakeClient) AddRoute(string, mqtt.MessageHandler) {}
+
+func (c *fakeClient) OptionsReader() mqtt.ClientOptionsReader {
+	return mqtt.ClientOptionsReader{}
+}
+
+// fakeMessage is a message that records its acknowledgement by its client.
+type fakeMessage struct {
+	c       *fakeClient
+	id      uint16
+	topic   string
+	payload []byte
+	qos     uint8
+}
+
+func (m *fakeMessage) Duplicate() bool   { return false }
+func (m *fakeMessage) Qos() byte         { return m.qos }
+func (m *fakeMessage) Retained() bool    { return false }
+func (m *fakeMessage) Topic() string     { return m.topic }
+func (m *fakeMessage) MessageID() uint16 { return m.id }
+func (m *fakeMessage) Payload() []byte   { return m.payload }
+
+func (m *fakeMessage) Ack() {
+	m.c.mu.Lock()
+	defer m.c.mu.Unlock()
+	m.c.acked = append(m.c.acked, m.id)
+}
+
+// process processes a restriction with the reader, returning the messages
+// output.
+func process(t *testing.T, fn *readFn, we *sdf.ManualWatermarkEstimator, bf *unboundedtest.Finalization) []Message {
+	t.Helper()
+	rt := fn.CreateTracker(fn.CreateInitialRestriction(nil))
+	var got []Message
+	pc, err := fn.ProcessElement(context.Background(), we, bf, rt, nil, func(et beam.EventTime, m Message) {
+		got = append(got, m)
+	})
+	if err != nil {
+		t.Fatalf("ProcessElement() failed: %v", err)
+	}
+	if !pc.ShouldResume() {
+		t.Errorf("ProcessElement() = %v, want it to resume", pc)
+	}
+	if _, residual, _ := rt.TrySplit(0); residual == nil {
+		t.Error("ProcessElement() left no residual to resume")
+	}
+	return got
+}
+
+// newReadFn returns a reader of the topic with the fake client.
+func newReadFn(c *fakeClient) (*readFn, *sdf.ManualWatermarkEstimator) {
+	fn := &readFn{Broker: testBroker, Topics: []string{"a/#"}, QoS: 1, ClientID: "id", newClient: c.newClient}
+	fn.Setup()
+	rest := fn.CreateInitialRestriction(nil)
+	return fn, fn.CreateWatermarkEstimator(fn.InitialWatermarkEstimatorState(mtime.MinTimestamp, rest, nil))
+}
+
+func TestReadFn(t *testing.T) {
+	c := &fakeClient{}
+	fn, we := newReadFn(c)
+	defer fn.Teardown()
+
+	// The reader connects on the first call, so nothing is read yet.
+	var bf unboundedtest.Finalization
+	start := time.Now()
+	if got := process(t, fn, we, &bf); len(got) != 0 {
+		t.Errorf("ProcessElement() output %v, want none", got)
+	}
+	if c.opts.ClientID != "id" || c.opts.CleanSession || !c.opts.AutoAckDisabled {
+		t.Errorf("ProcessElement() connected with client ID %q, clean session %v and automatic acknowledgement %v, want id, a persistent session and manual acknowledgement",
+			c.opts.ClientID, c.opts.CleanSession, !c.opts.AutoAckDisabled)
+	}
+	if want := map[string]byte{"a/#": 1}; !reflect.DeepEqual(c.filters, want) {
+		t.Errorf("ProcessElement() subscribed to %v, want %v", c.filters, want)
+	}
+	// There are no messages, so the watermark is the current time.
+	if wm := we.CurrentWatermark(); wm.Before(start.Truncate(time.Millisecond)) {
+		t.Errorf("ProcessElement() watermark = %v, want at least %v", wm, start)
+	}
+	if len(bf.Callbacks) != 0 {
+		t.Errorf("ProcessElement() without messages registered %v finalization callbacks, want none", len(bf.Callbacks))
+	}
+
+	c.deliver(1, "a/b", "x", 1)
+	c.deliver(0, "a/c", "y", 0)
+	got := process(t, fn, we, &bf)
+	want := []Message{{Topic: "a/b", Payload: []byte("x"), QoS: 1}, {Topic: "a/c", Payload: []byte("y")}}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("ProcessElement() output %+v, want %+v", got, want)
+	}
+
+	// The messages are acknowledged once the bundle is finalized.
+	if len(c.acked) != 0 {
+		t.Errorf("ProcessElement() acknowledged %v before finalization, want none", c.acked)
+	}
+	if len(bf.Callbacks) != 1 {
+		t.Fatalf("ProcessElement() registered %v finalization callbacks, want 1", len(bf.Callbacks))
+	}
+	if err := bf.Callbacks[0](); err != nil {
+		t.Fatalf("finalization callback failed: %v", err)
+	}
+	if want := []uint16{1, 0}; !reflect.DeepEqual(c.acked, want) {
+		t.Errorf("finalization acknowledged %v, want %v", c.acked, want)
+	}
+}
+
+func TestReadFn_Reconnect(t *testing.T) {
+	c := &fakeClient{}
+	fn, we := newReadFn(c)
+	defer fn.Teardown()
+
+	var bf unboundedtest.Finalization
+	process(t, fn, we, &bf)
+	c.deliver(1, "a/b", "x", 1)
+	if got := process(t, fn, we, &bf); len(got) != 1 {
+		t.Fatalf("ProcessElement() output %v, want 1 message", got)
+	}
+
+	// A reader that lost its connection reconnects, and doesn't acknowledge
+	// the messages of the lost connection, which the broker redelivers.
+	c.disconnect()
+	process(t, fn, we, &bf)
+	if c.connects != 2 {
+		t.Errorf("ProcessElement() after losing its connection connected %v times, want 2", c.connects)
+	}
+	if err := bf.Callbacks[0](); err != nil {
+		t.Fatalf("finalization callback failed: %v", err)
+	}
+	if len(c.acked) != 0 {
+		t.Errorf("finalization acknowledged %v of a lost connection, want none", c.acked)
+	}
+}
+
+func TestReadFn_ConnectFailure(t *testing.T) {
+	c := &fakeClient{connectErr: errors.New("refused")}
+	fn, we := newReadFn(c)
+	defer fn.Teardown()
+
+	// The reader retries connecting to the broker.
+	var bf unboundedtest.Finalization
+	if got := process(t, fn, we, &bf); len(got) != 0 {
+		t.Errorf("ProcessElement() without a connection output %v, want none", got)
+	}
+	if fn.sub != nil {
+		t.Error("ProcessElement() without a connection kept a subscription, want none")
+	}
+}
+
+func TestCreateTracker(t *testing.T) {
+	rt := (&readFn{}).CreateTracker(offsetrange.Restriction{Start: 0, End: math.MaxInt64})
+	if rt.IsBounded() {
+		t.Error("CreateTracker() is bounded, want unbounded so that draining stops reads")
+	}
+}
+
+func TestRead_Options(t *testing.T) {
+	tests := []struct {
+		name string
+		fn   func()
+	}{
+		{"no topics", func() { Read(beam.NewPipeline().Root(), testBroker, nil) }},
+		{"read QoS 3", func() { ReadQoS(3) }},
+		{"write QoS 3", func() { WriteQoS(3) }},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%v succeeded, want panic", test.name)
+				}
+			}()
+			test.fn()
+		})
+	}
+}
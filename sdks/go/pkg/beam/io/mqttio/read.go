@@ -0,0 +1,349 @@
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements.  See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License.  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mqttio
+
+import (
+	"context"
+	"math"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/apache/beam/sdks/v2/go/pkg/beam"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/core/graph/mtime"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/core/sdf"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/internal/errors"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/io/rtrackers/offsetrange"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/io/rtrackers/unbounded"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/log"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/google/uuid"
+)
+
+const (
+	// defaultQoS is the quality of service of subscriptions and publishes,
+	// unless another is set with ReadQoS or WriteQoS.
+	defaultQoS = 1
+	// messageBuffer is the number of received messages buffered until
+	// they're read.
+	messageBuffer = 1000
+	// receiveTimeout is how long reading waits for a message before
+	// checkpointing.
+	receiveTimeout = time.Second
+	// checkpointInterval is how long messages are read before
+	// checkpointing, so that they can be committed and acknowledged.
+	checkpointInterval = 10 * time.Second
+	// retryInterval is how long reading waits to reconnect after its
+	// connection is lost.
+	retryInterval = time.Second
+	// finalizationTimeout is how long messages wait for their bundle to be
+	// finalized before they're left unacknowledged, to be redelivered on the
+	// next connection.
+	finalizationTimeout = 10 * time.Minute
+	// disconnectQuiesce is how many milliseconds disconnecting waits for
+	// pending work to complete.
+	disconnectQuiesce = 250
+)
+
+func init() {
+	beam.RegisterType(reflect.TypeOf((*readFn)(nil)).Elem())
+}
+
+// readOption holds the options of Read.
+type readOption struct {
+	QoS         uint8
+	ClientID    string
+	Credentials credentials
+}
+
+// ReadOptionFn is an option for Read.
+type ReadOptionFn func(*readOption)
+
+// ReadQoS sets the quality of service of the subscriptions, which is 0 for
+// at most once delivery, or 1 or 2 for at least once delivery of messages
+// published with the same or a higher quality of service. The default is 1.
+func ReadQoS(qos uint8) ReadOptionFn {
+	validateQoS("ReadQoS", qos)
+	return func(o *readOption) {
+		o.QoS = qos
+	}
+}
+
+// ReadClientID sets the client ID of the reader, which identifies its
+// session on the broker, so that the broker keeps its subscriptions and
+// unacknowledged messages across connections. Other clients must not use
+// the same client ID. The default is a unique ID generated by Read.
+func ReadClientID(id string) ReadOptionFn {
+	return func(o *readOption) {
+		o.ClientID = id
+	}
+}
+
+// ReadCredentials sets the username and password to connect with.
+func ReadCredentials(username, password string) ReadOptionFn {
+	return func(o *readOption) {
+		o.Credentials = credentials{Username: username, Password: password}
+	}
+}
+
+// Read subscribes to the given topic filters of the broker at the URL, such
+// as "tcp://localhost:1883", and returns an unbounded PCollection<Message>.
+// For example:
+//
+//	msgs := mqttio.Read(s, "tcp://localhost:1883", []string{"sensors/+/temperature"})
+//
+// Messages are output timestamped at the time they're read, since MQTT 3.1.1
+// messages have no timestamps, and the output watermark is the current time.
+//
+// Messages delivered with a quality of service of 1 or 2 are acknowledged
+// once their bundle is finalized. The reader's session is kept by the broker
+// across connections, so messages that aren't acknowledged, such as those of
+// bundles that failed, are redelivered when the reader reconnects after
+// being torn down or losing its connection.
+func Read(s beam.Scope, broker string, topics []string, opts ...ReadOptionFn) beam.PCollection {
+	s = s.Scope("mqttio.Read")
+	if len(topics) == 0 {
+		panic("mqttio.Read requires at least one topic")
+	}
+
+	o := readOption{
+		QoS:      defaultQoS,
+		ClientID: "beam-" + uuid.NewString(),
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return beam.ParDo(s, &readFn{
+		Broker:      broker,
+		Topics:      topics,
+		QoS:         o.QoS,
+		ClientID:    o.ClientID,
+		Credentials: o.Credentials,
+	}, beam.Impulse(s))
+}
+
+// readFn subscribes to topics. Its restriction is a range of sequence
+// numbers, of which a number is claimed per message. The restriction is
+// never split, so that only one client of the session reads at a time.
+type readFn struct {
+	// Broker is the URL of the broker.
+	Broker string `json:"broker"`
+	// Topics are the topic filters to subscribe to.
+	Topics []string `json:"topics"`
+	// QoS is the quality of service of the subscriptions.
+	QoS uint8 `json:"qos"`
+	// ClientID is the client ID of the session.
+	ClientID string `json:"client_id"`
+	// Credentials are the credentials to connect with.
+	Credentials credentials `json:"credentials"`
+
+	newClient newClientFunc
+	sub       *subscription
+}
+
+func (f *readFn) Setup() {
+	if f.newClient == nil {
+		f.newClient = mqtt.NewClient
+	}
+}
+
+func (f *readFn) Teardown() {
+	if f.sub != nil {
+		f.sub.close()
+		f.sub = nil
+	}
+}
+
+// subscription is a connection of the session, and the messages received
+// on it.
+type subscription struct {
+	client   mqtt.Client
+	messages chan mqtt.Message
+	done     chan struct{}
+
+	mu     sync.Mutex
+	closed bool
+}
+
+// receive buffers the message, unless the subscription is closed.
+func (s *subscription) receive(_ mqtt.Client, m mqtt.Message) {
+	select {
+	case s.messages <- m:
+	case <-s.done:
+	}
+}
+
+// ack acknowledges the messages, unless the subscription is closed, in
+// which case the broker redelivers them on the next connection.
+func (s *subscription) ack(msgs []mqtt.Message) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed || !s.client.IsConnectionOpen() {
+		return
+	}
+	// Acknowledging a message after its connection is lost panics, and the
+	// message is redelivered anyway.
+	defer func() {
+		recover()
+	}()
+	for _, m := range msgs {
+		m.Ack()
+	}
+}
+
+func (s *subscription) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return
+	}
+	s.closed = true
+	close(s.done)
+	s.client.Disconnect(disconnectQuiesce)
+}
+
+// connect connects to the broker with the session of the reader. Messages
+// are acknowledged manually, and the broker redelivers those that aren't
+// on the next connection.
+func (f *readFn) connect() (*subscription, error) {
+	s := &subscription{
+		messages: make(chan mqtt.Message, messageBuffer),
+		done:     make(chan struct{}),
+	}
+	// Messages the broker redelivers before the subscriptions are made are
+	// received by the default handler. The reader reconnects itself, so that
+	// a lost connection doesn't receive messages that are then read again.
+	o := clientOptions(f.Broker, f.ClientID, f.Credentials).
+		SetCleanSession(false).
+		SetAutoReconnect(false).
+		SetOrderMatters(false).
+		SetAutoAckDisabled(true).
+		SetDefaultPublishHandler(s.receive)
+	s.client = f.newClient(o)
+	if err := wait(s.client.Connect(), "connecting to "+f.Broker); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// subscribe subscribes the connection to the topics.
+func (f *readFn) subscribe(s *subscription) error {
+	filters := make(map[string]byte, len(f.Topics))
+	for _, t := range f.Topics {
+		filters[t] = f.QoS
+	}
+	t := s.client.SubscribeMultiple(filters, s.receive)
+	if err := wait(t, "subscribing"); err != nil {
+		return err
+	}
+	if st, ok := t.(*mqtt.SubscribeToken); ok {
+		for topic, code := range st.Result() {
+			// 0x80 is the return code of a failed subscription.
+			if code == 0x80 {
+				return errors.Errorf("broker refused subscription to %v", topic)
+			}
+		}
+	}
+	return nil
+}
+
+func (f *readFn) CreateInitialRestriction(_ []byte) offsetrange.Restriction {
+	return offsetrange.Restriction{Start: 0, End: math.MaxInt64}
+}
+
+func (f *readFn) RestrictionSize(_ []byte, rest offsetrange.Restriction) float64 {
+	return rest.Size()
+}
+
+func (f *readFn) CreateTracker(rest offsetrange.Restriction) *sdf.LockRTracker {
+	return sdf.NewLockRTracker(unbounded.NewTracker(rest))
+}
+
+func (f *readFn) InitialWatermarkEstimatorState(et beam.EventTime, _ offsetrange.Restriction, _ []byte) int64 {
+	return int64(et)
+}
+
+func (f *readFn) CreateWatermarkEstimator(state int64) *sdf.ManualWatermarkEstimator {
+	return &sdf.ManualWatermarkEstimator{State: mtime.Time(state).ToTime()}
+}
+
+func (f *readFn) WatermarkEstimatorState(e *sdf.ManualWatermarkEstimator) int64 {
+	return int64(mtime.FromTime(e.State))
+}
+
+func (f *readFn) ProcessElement(ctx context.Context, we *sdf.ManualWatermarkEstimator, bf beam.BundleFinalization, rt *sdf.LockRTracker, _ []byte, emit func(beam.EventTime, Message)) (sdf.ProcessContinuation, error) {
+	if f.sub != nil && !f.sub.client.IsConnectionOpen() {
+		log.Warnf(ctx, "Connection to %v lost, reconnecting", f.Broker)
+		f.sub.close()
+		f.sub = nil
+	}
+	rest := rt.GetRestriction().(offsetrange.Restriction)
+	if f.sub == nil {
+		s, err := f.connect()
+		if err != nil {
+			// The attempt is claimed, so that the reader checkpoints to retry.
+			if !rt.TryClaim(rest.Start) {
+				return sdf.StopProcessing(), nil
+			}
+			log.Warnf(ctx, "Retrying connection to %v: %v", f.Broker, err)
+			return sdf.ResumeProcessingIn(retryInterval), nil
+		}
+		if err := f.subscribe(s); err != nil {
+			s.close()
+			return sdf.StopProcessing(), err
+		}
+		f.sub = s
+	}
+
+	// The messages are acknowledged once the runner has committed their
+	// bundle. They're timestamped at the time they're read, so the
+	// watermark is the current time.
+	var received []mqtt.Message
+	defer func() {
+		unbounded.AdvanceWatermark(we, time.Now())
+		if len(received) == 0 {
+			return
+		}
+		s, msgs := f.sub, received
+		bf.RegisterCallback(finalizationTimeout, func() error {
+			s.ack(msgs)
+			return nil
+		})
+	}()
+
+	checkpoint := time.NewTimer(checkpointInterval)
+	defer checkpoint.Stop()
+	for n := rest.Start; ; n++ {
+		if !rt.TryClaim(n) {
+			return sdf.StopProcessing(), nil
+		}
+		idle := time.NewTimer(receiveTimeout)
+		select {
+		case <-ctx.Done():
+			idle.Stop()
+			return sdf.StopProcessing(), ctx.Err()
+		case <-checkpoint.C:
+			idle.Stop()
+			return sdf.ResumeProcessingIn(0), nil
+		case <-idle.C:
+			return sdf.ResumeProcessingIn(0), nil
+		case m := <-f.sub.messages:
+			idle.Stop()
+			received = append(received, m)
+			emit(mtime.Now(), newMessage(m))
+		}
+	}
+}
@@ -0,0 +1,186 @@
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements.  See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License.  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mqttio
+
+import (
+	"context"
+	"reflect"
+	"time"
+
+	"github.com/apache/beam/sdks/v2/go/pkg/beam"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/core/util/reflectx"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/internal/errors"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/google/uuid"
+)
+
+const (
+	// maxPendingPublishes is the maximum number of publishes awaiting
+	// completion, after which publishing waits for them.
+	maxPendingPublishes = 1000
+	// publishTimeout is how long the pending publishes wait to complete.
+	publishTimeout = time.Minute
+)
+
+func init() {
+	beam.RegisterType(reflect.TypeOf((*writeFn)(nil)).Elem())
+	beam.RegisterFunction(payloadMessageFn)
+}
+
+// writeOption holds the options of Write.
+type writeOption struct {
+	QoS         uint8
+	Retained    bool
+	Credentials credentials
+}
+
+// WriteOptionFn is an option for Write.
+type WriteOptionFn func(*writeOption)
+
+// WriteQoS sets the quality of service of the publishes, which is 0 for at
+// most once delivery to the broker, or 1 or 2 for at least once delivery.
+// The default is 1.
+func WriteQoS(qos uint8) WriteOptionFn {
+	validateQoS("WriteQoS", qos)
+	return func(o *writeOption) {
+		o.QoS = qos
+	}
+}
+
+// WriteRetained sets all messages to be published as retained, so that the
+// broker delivers the last message of each topic to new subscriptions.
+func WriteRetained() WriteOptionFn {
+	return func(o *writeOption) {
+		o.Retained = true
+	}
+}
+
+// WriteCredentials sets the username and password to connect with.
+func WriteCredentials(username, password string) WriteOptionFn {
+	return func(o *writeOption) {
+		o.Credentials = credentials{Username: username, Password: password}
+	}
+}
+
+// Write publishes the messages of the given PCollection<Message>, or the
+// payloads of PCollection<[]byte>, to the given topic of the broker at the
+// URL. Messages with a topic are published to it instead. For example:
+//
+//	mqttio.Write(s, "tcp://localhost:1883", "devices/commands", msgs, mqttio.WriteQoS(2))
+//
+// A bundle fails unless all of its publishes complete, which for a quality
+// of service of 1 or 2 is when the broker has acknowledged them, so each
+// message is published at least once.
+func Write(s beam.Scope, broker, topic string, col beam.PCollection, opts ...WriteOptionFn) {
+	s = s.Scope("mqttio.Write")
+
+	o := writeOption{QoS: defaultQoS}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	msgs := col
+	if col.Type().Type() == reflectx.ByteSlice {
+		msgs = beam.ParDo(s, payloadMessageFn, col)
+	}
+	beam.ParDo0(s, &writeFn{
+		Broker:      broker,
+		Topic:       topic,
+		QoS:         o.QoS,
+		Retained:    o.Retained,
+		Credentials: o.Credentials,
+	}, msgs)
+}
+
+func payloadMessageFn(payload []byte) Message {
+	return Message{Payload: payload}
+}
+
+// writeFn publishes messages, and waits for the publishes to complete at the
+// end of each bundle.
+type writeFn struct {
+	// Broker is the URL of the broker.
+	Broker string `json:"broker"`
+	// Topic is the topic of messages without one.
+	Topic string `json:"topic"`
+	// QoS is the quality of service of the publishes.
+	QoS uint8 `json:"qos"`
+	// Retained is whether all messages are published as retained.
+	Retained bool `json:"retained"`
+	// Credentials are the credentials to connect with.
+	Credentials credentials `json:"credentials"`
+
+	newClient newClientFunc
+	client    mqtt.Client
+	pending   []mqtt.Token
+}
+
+func (f *writeFn) Setup() error {
+	if f.newClient == nil {
+		f.newClient = mqtt.NewClient
+	}
+	// Each writer connects with a clean session of its own, since clients
+	// with the same ID disconnect each other.
+	o := clientOptions(f.Broker, "beam-"+uuid.NewString(), f.Credentials).
+		SetAutoReconnect(true)
+	f.client = f.newClient(o)
+	return wait(f.client.Connect(), "connecting to "+f.Broker)
+}
+
+func (f *writeFn) ProcessElement(ctx context.Context, m Message) error {
+	topic := m.Topic
+	if topic == "" {
+		topic = f.Topic
+	}
+	if topic == "" {
+		return errors.Errorf("message %q has no topic to publish to", m.Payload)
+	}
+	f.pending = append(f.pending, f.client.Publish(topic, f.QoS, f.Retained || m.Retained, m.Payload))
+	if len(f.pending) >= maxPendingPublishes {
+		return f.wait(ctx)
+	}
+	return nil
+}
+
+func (f *writeFn) FinishBundle(ctx context.Context) error {
+	return f.wait(ctx)
+}
+
+func (f *writeFn) Teardown() {
+	if f.client != nil {
+		f.client.Disconnect(disconnectQuiesce)
+	}
+}
+
+// wait waits for the pending publishes to complete.
+func (f *writeFn) wait(ctx context.Context) error {
+	pending := f.pending
+	f.pending = nil
+	timeout := time.NewTimer(publishTimeout)
+	defer timeout.Stop()
+	for _, t := range pending {
+		select {
+		case <-t.Done():
+			if err := t.Error(); err != nil {
+				return errors.Wrapf(err, "publishing %v messages", len(pending))
+			}
+		case <-timeout.C:
+			return errors.Errorf("publishing %v messages timed out after %v", len(pending), publishTimeout)
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements.  See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License.  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mqttio
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// write publishes the messages with the writer in one bundle, and returns
+// the error of finishing it.
+func write(t *testing.T, fn *writeFn, msgs ...Message) error {
+	t.Helper()
+	ctx := context.Background()
+	if err := fn.Setup(); err != nil {
+		t.Fatalf("Setup() failed: %v", err)
+	}
+	for _, m := range msgs {
+		if err := fn.ProcessElement(ctx, m); err != nil {
+			return err
+		}
+	}
+	return fn.FinishBundle(ctx)
+}
+
+func TestWriteFn(t *testing.T) {
+	c := &fakeClient{}
+	fn := &writeFn{Broker: testBroker, Topic: "t", QoS: 2, newClient: c.newClient}
+	defer fn.Teardown()
+
+	err := write(t, fn,
+		Message{Topic: "other", Payload: []byte("a"), QoS: 0, Duplicate: true},
+		Message{Payload: []byte("b"), Retained: true},
+	)
+	if err != nil {
+		t.Fatalf("FinishBundle() failed: %v", err)
+	}
+	// Messages are published with the writer's quality of service, to the
+	// default topic unless they have one.
+	want := []Message{
+		{Topic: "other", Payload: []byte("a"), QoS: 2},
+		{Topic: "t", Payload: []byte("b"), QoS: 2, Retained: true},
+	}
+	if !reflect.DeepEqual(c.published, want) {
+		t.Errorf("Write() published %+v, want %+v", c.published, want)
+	}
+	if !c.opts.CleanSession || c.opts.ClientID == "" {
+		t.Errorf("Setup() connected with client ID %q and clean session %v, want a generated ID and a clean session", c.opts.ClientID, c.opts.CleanSession)
+	}
+}
+
+func TestWriteFn_Retained(t *testing.T) {
+	c := &fakeClient{}
+	fn := &writeFn{Broker: testBroker, Topic: "t", Retained: true, newClient: c.newClient}
+	defer fn.Teardown()
+
+	if err := write(t, fn, Message{Payload: []byte("a")}); err != nil {
+		t.Fatalf("FinishBundle() failed: %v", err)
+	}
+	if len(c.published) != 1 || !c.published[0].Retained {
+		t.Errorf("Write() published %+v, want a retained message", c.published)
+	}
+}
+
+func TestWriteFn_Errors(t *testing.T) {
+	tests := []struct {
+		name string
+		c    *fakeClient
+		fn   *writeFn
+	}{
+		{"failed publish", &fakeClient{publishErr: errors.New("failed")}, &writeFn{Topic: "t"}},
+		{"no topic", &fakeClient{}, &writeFn{}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.fn.newClient = test.c.newClient
+			defer test.fn.Teardown()
+			if err := write(t, test.fn, Message{Payload: []byte("a")}); err == nil {
+				t.Errorf("Write() with %v succeeded, want error", test.name)
+			}
+		})
+	}
+}
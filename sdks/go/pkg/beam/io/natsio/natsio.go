@@ -0,0 +1,77 @@
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements.  See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License.  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package natsio contains transforms to read from and write to NATS
+// JetStream (https://docs.nats.io/nats-concepts/jetstream).
+//
+// Reading is implemented with a splittable DoFn, so it runs on portable
+// runners that support unbounded splittable DoFns and bundle finalization.
+// Experimental.
+package natsio
+
+import (
+	"reflect"
+
+	"github.com/apache/beam/sdks/v2/go/pkg/beam"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/internal/errors"
+	"github.com/nats-io/nats.go"
+)
+
+func init() {
+	beam.RegisterType(reflect.TypeOf((*Message)(nil)).Elem())
+}
+
+// Message is a message of a JetStream stream, as read by Read. Write
+// publishes the data and headers of messages.
+type Message struct {
+	Subject string
+	Data    []byte
+	Headers map[string][]string
+	// Sequence is the sequence number of the message in its stream.
+	Sequence int64
+	// Timestamp is the time the message was stored in its stream, in
+	// milliseconds since the epoch.
+	Timestamp int64
+}
+
+// connect connects to the NATS servers of the URL, which may be a comma
+// separated list of URLs.
+func connect(url string) (*nats.Conn, error) {
+	nc, err := nats.Connect(url, nats.Name("apache-beam"), nats.MaxReconnects(-1))
+	if err != nil {
+		return nil, errors.Wrapf(err, "connecting to %v", url)
+	}
+	return nc, nil
+}
+
+// isRetryable returns whether the request that failed with the error can be
+// retried, such as while reconnecting or while a stream elects a leader.
+func isRetryable(err error) bool {
+	switch err {
+	case nats.ErrTimeout, nats.ErrConnectionReconnecting, nats.ErrNoServers,
+		nats.ErrNoResponders, nats.ErrStaleConnection:
+		return true
+	default:
+		return false
+	}
+}
+
+// headers returns the headers of the message, if any.
+func headers(h nats.Header) map[string][]string {
+	if len(h) == 0 {
+		return nil
+	}
+	return map[string][]string(h)
+}
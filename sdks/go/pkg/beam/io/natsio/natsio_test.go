@@ -0,0 +1,251 @@
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements.  See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License.  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package natsio
+
+import (
+	"context"
+	"math"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/apache/beam/sdks/v2/go/pkg/beam"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/core/graph/mtime"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/core/sdf"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/io/rtrackers/offsetrange"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/io/rtrackers/unbounded/unboundedtest"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/testing/ptest"
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+)
+
+const (
+	testStream   = "S"
+	testSubject  = "s.a"
+	testConsumer = "c"
+)
+
+func TestMain(m *testing.M) {
+	ptest.Main(m)
+}
+
+// startServer starts a NATS server with JetStream, with the test stream and
+// its durable pull consumer, and returns its URL and a JetStream context.
+func startServer(t *testing.T) (string, nats.JetStreamContext) {
+	srv, err := server.NewServer(&server.Options{Host: "127.0.0.1", Port: -1, JetStream: true, StoreDir: t.TempDir()})
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+	srv.Start()
+	t.Cleanup(srv.Shutdown)
+	if !srv.ReadyForConnections(10 * time.Second) {
+		t.Fatal("server not ready for connections")
+	}
+
+	nc, err := nats.Connect(srv.ClientURL())
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	t.Cleanup(nc.Close)
+	js, err := nc.JetStream()
+	if err != nil {
+		t.Fatalf("failed to get JetStream context: %v", err)
+	}
+	if _, err := js.AddStream(&nats.StreamConfig{Name: testStream, Subjects: []string{"s.>"}}); err != nil {
+		t.Fatalf("failed to create stream: %v", err)
+	}
+	if _, err := js.AddConsumer(testStream, &nats.ConsumerConfig{Durable: testConsumer, AckPolicy: nats.AckExplicitPolicy}); err != nil {
+		t.Fatalf("failed to create consumer: %v", err)
+	}
+	return srv.ClientURL(), js
+}
+
+// publish publishes messages with the data to the test subject.
+func publish(t *testing.T, js nats.JetStreamContext, data ...string) {
+	t.Helper()
+	for _, d := range data {
+		msg := nats.NewMsg(testSubject)
+		msg.Data = []byte(d)
+		msg.Header.Set("h", d)
+		if _, err := js.PublishMsg(msg); err != nil {
+			t.Fatalf("failed to publish %q: %v", d, err)
+		}
+	}
+}
+
+// process sets up a reader of the test consumer, and processes a restriction
+// with it, returning the messages output. The reader is torn down by the
+// returned function.
+func process(t *testing.T, url string, bf *unboundedtest.Finalization) ([]Message, *sdf.ManualWatermarkEstimator, func()) {
+	t.Helper()
+	fn := &readFn{URL: url, Stream: testStream, Consumer: testConsumer, Readers: 1, FetchSize: 2}
+	ctx := context.Background()
+	if err := fn.Setup(ctx); err != nil {
+		t.Fatalf("Setup() failed: %v", err)
+	}
+	rest := fn.CreateInitialRestriction(nil)
+	rt := fn.CreateTracker(rest)
+	we := fn.CreateWatermarkEstimator(fn.InitialWatermarkEstimatorState(mtime.MinTimestamp, rest, nil))
+	var got []Message
+	pc, err := fn.ProcessElement(ctx, we, bf, rt, nil, func(et beam.EventTime, m Message) {
+		if want := mtime.FromMilliseconds(m.Timestamp); et != want {
+			t.Errorf("ProcessElement() output %v at %v, want its stored time %v", m, et, want)
+		}
+		got = append(got, m)
+	})
+	if err != nil {
+		t.Fatalf("ProcessElement() failed: %v", err)
+	}
+	if !pc.ShouldResume() {
+		t.Errorf("ProcessElement() = %v, want it to resume", pc)
+	}
+	return got, we, func() {
+		if err := fn.Teardown(); err != nil {
+			t.Errorf("Teardown() failed: %v", err)
+		}
+	}
+}
+
+// data returns the data of the messages.
+func data(msgs []Message) []string {
+	var data []string
+	for _, m := range msgs {
+		data = append(data, string(m.Data))
+	}
+	return data
+}
+
+func TestReadFn(t *testing.T) {
+	url, js := startServer(t)
+	publish(t, js, "a", "b", "c")
+
+	var bf unboundedtest.Finalization
+	start := time.Now()
+	got, we, teardown := process(t, url, &bf)
+	defer teardown()
+
+	// The messages are fetched in two batches.
+	if want := []string{"a", "b", "c"}; !reflect.DeepEqual(data(got), want) {
+		t.Fatalf("ProcessElement() output %v, want %v", data(got), want)
+	}
+	for i, m := range got {
+		if m.Subject != testSubject || m.Sequence != int64(i+1) || !reflect.DeepEqual(m.Headers["h"], []string{string(m.Data)}) {
+			t.Errorf("ProcessElement() output %+v, want subject %v, sequence %v, and header", m, testSubject, i+1)
+		}
+	}
+	// There were no more messages, so the watermark is the current time.
+	if wm := we.CurrentWatermark(); wm.Before(start.Truncate(time.Millisecond)) {
+		t.Errorf("ProcessElement() watermark = %v, want at least %v", wm, start)
+	}
+
+	// The messages are acknowledged once the bundle is finalized.
+	info, err := js.ConsumerInfo(testStream, testConsumer)
+	if err != nil {
+		t.Fatalf("ConsumerInfo() failed: %v", err)
+	}
+	if info.NumAckPending != 3 {
+		t.Errorf("consumer has %v messages pending acknowledgement before finalization, want 3", info.NumAckPending)
+	}
+	if len(bf.Callbacks) != 1 {
+		t.Fatalf("ProcessElement() registered %v finalization callbacks, want 1", len(bf.Callbacks))
+	}
+	if err := bf.Callbacks[0](); err != nil {
+		t.Fatalf("finalization callback failed: %v", err)
+	}
+	if info, err = js.ConsumerInfo(testStream, testConsumer); err != nil {
+		t.Fatalf("ConsumerInfo() failed: %v", err)
+	}
+	if info.NumAckPending != 0 || info.AckFloor.Stream != 3 {
+		t.Errorf("consumer has %v messages pending acknowledgement and ack floor %v after finalization, want 0 and 3", info.NumAckPending, info.AckFloor.Stream)
+	}
+}
+
+func TestReadFn_Redelivery(t *testing.T) {
+	url, js := startServer(t)
+	publish(t, js, "a", "b")
+
+	// The messages of a bundle that isn't finalized are redelivered once
+	// the reader is torn down.
+	var bf unboundedtest.Finalization
+	got, _, teardown := process(t, url, &bf)
+	if want := []string{"a", "b"}; !reflect.DeepEqual(data(got), want) {
+		t.Fatalf("ProcessElement() output %v, want %v", data(got), want)
+	}
+	teardown()
+
+	got, _, teardown = process(t, url, &bf)
+	defer teardown()
+	redelivered := data(got)
+	sort.Strings(redelivered)
+	if want := []string{"a", "b"}; !reflect.DeepEqual(redelivered, want) {
+		t.Errorf("ProcessElement() after teardown output %v, want %v redelivered", redelivered, want)
+	}
+}
+
+func TestReadFn_PushConsumer(t *testing.T) {
+	url, js := startServer(t)
+	if _, err := js.AddConsumer(testStream, &nats.ConsumerConfig{Durable: "push", DeliverSubject: "d", AckPolicy: nats.AckExplicitPolicy}); err != nil {
+		t.Fatalf("failed to create consumer: %v", err)
+	}
+
+	fn := &readFn{URL: url, Stream: testStream, Consumer: "push", Readers: 1, FetchSize: 1}
+	defer fn.Teardown()
+	if err := fn.Setup(context.Background()); err == nil {
+		t.Error("Setup() of a push consumer succeeded, want error")
+	}
+}
+
+func TestReadFn_SplitRestriction(t *testing.T) {
+	fn := &readFn{Readers: 3}
+	splits := fn.SplitRestriction(nil, fn.CreateInitialRestriction(nil))
+	if len(splits) != 3 || splits[0].Start != 0 || splits[2].End != math.MaxInt64 {
+		t.Fatalf("SplitRestriction() = %v, want 3 restrictions covering the range", splits)
+	}
+	for i := 1; i < len(splits); i++ {
+		if splits[i].Start != splits[i-1].End {
+			t.Errorf("SplitRestriction() = %v, want contiguous restrictions", splits)
+		}
+	}
+}
+
+func TestCreateTracker(t *testing.T) {
+	rt := (&readFn{}).CreateTracker(offsetrange.Restriction{Start: 0, End: math.MaxInt64})
+	if rt.IsBounded() {
+		t.Error("CreateTracker() is bounded, want unbounded so that draining stops reads")
+	}
+}
+
+func TestRead_Options(t *testing.T) {
+	tests := []struct {
+		name string
+		fn   func()
+	}{
+		{"no consumer", func() { Read(beam.NewPipeline().Root(), nats.DefaultURL, testStream, "") }},
+		{"zero readers", func() { ReadNumReaders(0) }},
+		{"zero fetch size", func() { ReadFetchSize(0) }},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%v succeeded, want panic", test.name)
+				}
+			}()
+			test.fn()
+		})
+	}
+}
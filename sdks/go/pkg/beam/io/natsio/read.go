@@ -0,0 +1,398 @@
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements.  See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License.  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package natsio
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/apache/beam/sdks/v2/go/pkg/beam"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/core/graph/mtime"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/core/sdf"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/internal/errors"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/io/rtrackers/offsetrange"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/io/rtrackers/unbounded"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/log"
+	"github.com/nats-io/nats.go"
+)
+
+const (
+	// defaultFetchSize is the maximum number of messages of each fetch,
+	// unless another is set with ReadFetchSize.
+	defaultFetchSize = 100
+	// fetchTimeout bounds how long a fetch waits for messages.
+	fetchTimeout = 5 * time.Second
+	// pollInterval is how long reading waits after a fetch without messages.
+	pollInterval = time.Second
+	// checkpointInterval is how long messages are fetched before
+	// checkpointing, so that they can be committed and acknowledged.
+	checkpointInterval = 10 * time.Second
+	// finalizationTimeout is how long fetched messages are leased, waiting
+	// for their bundle to be finalized, after which they are redelivered.
+	finalizationTimeout = 10 * time.Minute
+	// defaultAckWait is the ack wait of consumers that don't report one,
+	// which is the server default.
+	defaultAckWait = 30 * time.Second
+)
+
+func init() {
+	beam.RegisterType(reflect.TypeOf((*readFn)(nil)).Elem())
+}
+
+// readOption holds the options of Read.
+type readOption struct {
+	Readers   int
+	FetchSize int
+}
+
+// ReadOptionFn is an option for Read.
+type ReadOptionFn func(*readOption)
+
+// ReadNumReaders sets the number of concurrent readers of the consumer. The
+// default is 1.
+func ReadNumReaders(n int) ReadOptionFn {
+	if n < 1 {
+		panic(fmt.Sprintf("natsio.ReadNumReaders number must be positive. Got: %v", n))
+	}
+	return func(o *readOption) {
+		o.Readers = n
+	}
+}
+
+// ReadFetchSize sets the maximum number of messages each reader fetches at a
+// time. The default is 100.
+func ReadFetchSize(n int) ReadOptionFn {
+	if n < 1 {
+		panic(fmt.Sprintf("natsio.ReadFetchSize size must be positive. Got: %v", n))
+	}
+	return func(o *readOption) {
+		o.FetchSize = n
+	}
+}
+
+// Read reads the messages of the given durable pull consumer of a JetStream
+// stream from the NATS servers at the URL, and returns an unbounded
+// PCollection<Message>. For example:
+//
+//	msgs := natsio.Read(s, "nats://localhost:4222", "ORDERS", "beam")
+//
+// The consumer must have the explicit ack policy. Messages are output
+// timestamped at the time they were stored in the stream. The output
+// watermark is the earliest timestamp of the last messages fetched, or the
+// current time when there are none, so redelivered messages may be late.
+//
+// Messages are acknowledged once their bundle is finalized, and are kept in
+// progress until then, so that the consumer doesn't redeliver them when its
+// ack wait expires. Messages of bundles that aren't finalized before a
+// timeout, such as bundles that failed, and messages still leased when the
+// reader is torn down, are negatively acknowledged, so that they're
+// redelivered right away. The consumer's MaxDeliver limits how many times
+// messages are redelivered.
+func Read(s beam.Scope, url, stream, consumer string, opts ...ReadOptionFn) beam.PCollection {
+	s = s.Scope("natsio.Read")
+	if stream == "" || consumer == "" {
+		panic("natsio.Read requires a stream and a consumer")
+	}
+
+	o := readOption{
+		Readers:   1,
+		FetchSize: defaultFetchSize,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return beam.ParDo(s, &readFn{
+		URL:       url,
+		Stream:    stream,
+		Consumer:  consumer,
+		Readers:   o.Readers,
+		FetchSize: o.FetchSize,
+	}, beam.Impulse(s))
+}
+
+// readFn fetches messages of a consumer. Its restriction is a range of
+// fetch sequence numbers, which is split into a range per reader. Each
+// reader claims a number per fetch.
+type readFn struct {
+	// URL is the URL of the NATS servers.
+	URL string `json:"url"`
+	// Stream is the name of the stream.
+	Stream string `json:"stream"`
+	// Consumer is the name of the durable pull consumer of the stream.
+	Consumer string `json:"consumer"`
+	// Readers is the number of concurrent readers.
+	Readers int `json:"readers"`
+	// FetchSize is the maximum number of messages of each fetch.
+	FetchSize int `json:"fetch_size"`
+
+	nc     *nats.Conn
+	sub    *nats.Subscription
+	leases *leaser
+}
+
+func (f *readFn) Setup(ctx context.Context) error {
+	nc, err := connect(f.URL)
+	if err != nil {
+		return err
+	}
+	f.nc = nc
+	js, err := nc.JetStream()
+	if err != nil {
+		return errors.Wrap(err, "getting JetStream context")
+	}
+
+	info, err := js.ConsumerInfo(f.Stream, f.Consumer)
+	if err != nil {
+		return errors.Wrapf(err, "getting consumer %v of stream %v", f.Consumer, f.Stream)
+	}
+	if info.Config.Durable == "" || info.Config.DeliverSubject != "" {
+		return errors.Errorf("consumer %v of stream %v isn't a durable pull consumer", f.Consumer, f.Stream)
+	}
+	if info.Config.AckPolicy != nats.AckExplicitPolicy {
+		return errors.Errorf("consumer %v of stream %v has ack policy %v, not explicit", f.Consumer, f.Stream, info.Config.AckPolicy)
+	}
+	sub, err := js.PullSubscribe("", f.Consumer, nats.Bind(f.Stream, f.Consumer))
+	if err != nil {
+		return errors.Wrapf(err, "subscribing to consumer %v of stream %v", f.Consumer, f.Stream)
+	}
+	f.sub = sub
+	f.leases = newLeaser(f.Consumer, info.Config.AckWait)
+	return nil
+}
+
+func (f *readFn) Teardown() error {
+	if f.leases != nil {
+		f.leases.Stop()
+	}
+	if f.nc == nil {
+		return nil
+	}
+	f.nc.Close()
+	return nil
+}
+
+func (f *readFn) CreateInitialRestriction(_ []byte) offsetrange.Restriction {
+	return offsetrange.Restriction{Start: 0, End: math.MaxInt64}
+}
+
+// SplitRestriction splits the restriction into a restriction per reader.
+// Restriction.EvenSplits would overflow on the unbounded range.
+func (f *readFn) SplitRestriction(_ []byte, rest offsetrange.Restriction) []offsetrange.Restriction {
+	n := int64(f.Readers)
+	size := (rest.End - rest.Start) / n
+	splits := make([]offsetrange.Restriction, n)
+	for i := range splits {
+		splits[i] = offsetrange.Restriction{Start: rest.Start + int64(i)*size, End: rest.Start + int64(i+1)*size}
+	}
+	splits[n-1].End = rest.End
+	return splits
+}
+
+func (f *readFn) RestrictionSize(_ []byte, rest offsetrange.Restriction) float64 {
+	return rest.Size()
+}
+
+func (f *readFn) CreateTracker(rest offsetrange.Restriction) *sdf.LockRTracker {
+	return sdf.NewLockRTracker(unbounded.NewTracker(rest))
+}
+
+func (f *readFn) InitialWatermarkEstimatorState(et beam.EventTime, _ offsetrange.Restriction, _ []byte) int64 {
+	return int64(et)
+}
+
+func (f *readFn) CreateWatermarkEstimator(state int64) *sdf.ManualWatermarkEstimator {
+	return &sdf.ManualWatermarkEstimator{State: mtime.Time(state).ToTime()}
+}
+
+func (f *readFn) WatermarkEstimatorState(e *sdf.ManualWatermarkEstimator) int64 {
+	return int64(mtime.FromTime(e.State))
+}
+
+func (f *readFn) ProcessElement(ctx context.Context, we *sdf.ManualWatermarkEstimator, bf beam.BundleFinalization, rt *sdf.LockRTracker, _ []byte, emit func(beam.EventTime, Message)) (sdf.ProcessContinuation, error) {
+	// The messages are acknowledged once the runner has committed their
+	// bundle.
+	var fetched []*nats.Msg
+	defer func() {
+		if len(fetched) == 0 {
+			return
+		}
+		msgs := fetched
+		bf.RegisterCallback(finalizationTimeout, func() error {
+			return f.leases.Ack(msgs)
+		})
+	}()
+
+	rest := rt.GetRestriction().(offsetrange.Restriction)
+	checkpoint := time.Now().Add(checkpointInterval)
+	for n := rest.Start; ; n++ {
+		if !rt.TryClaim(n) {
+			return sdf.StopProcessing(), nil
+		}
+		msgs, err := f.sub.Fetch(f.FetchSize, nats.MaxWait(fetchTimeout))
+		if err == nats.ErrTimeout {
+			unbounded.AdvanceWatermark(we, time.Now())
+			return sdf.ResumeProcessingIn(pollInterval), nil
+		}
+		if err != nil {
+			if isRetryable(err) {
+				log.Warnf(ctx, "Retrying fetch of messages of consumer %v: %v", f.Consumer, err)
+				return sdf.ResumeProcessingIn(pollInterval), nil
+			}
+			return sdf.StopProcessing(), errors.Wrapf(err, "fetching messages of consumer %v of stream %v", f.Consumer, f.Stream)
+		}
+		f.leases.Add(msgs)
+		fetched = append(fetched, msgs...)
+
+		var earliest time.Time
+		for _, m := range msgs {
+			meta, err := m.Metadata()
+			if err != nil {
+				return sdf.StopProcessing(), errors.Wrapf(err, "reading metadata of message of consumer %v", f.Consumer)
+			}
+			if earliest.IsZero() || meta.Timestamp.Before(earliest) {
+				earliest = meta.Timestamp
+			}
+			emit(mtime.FromTime(meta.Timestamp), Message{
+				Subject:   m.Subject,
+				Data:      m.Data,
+				Headers:   headers(m.Header),
+				Sequence:  int64(meta.Sequence.Stream),
+				Timestamp: meta.Timestamp.UnixMilli(),
+			})
+		}
+		unbounded.AdvanceWatermark(we, earliest)
+
+		if time.Now().After(checkpoint) {
+			return sdf.ResumeProcessingIn(0), nil
+		}
+	}
+}
+
+// leaser keeps fetched messages in progress until they are acknowledged, or
+// their lease expires, when they are negatively acknowledged.
+type leaser struct {
+	consumer string
+	ackWait  time.Duration
+
+	mu     sync.Mutex
+	expiry map[*nats.Msg]time.Time
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+func newLeaser(consumer string, ackWait time.Duration) *leaser {
+	if ackWait <= 0 {
+		ackWait = defaultAckWait
+	}
+	l := &leaser{
+		consumer: consumer,
+		ackWait:  ackWait,
+		expiry:   make(map[*nats.Msg]time.Time),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go l.run()
+	return l
+}
+
+// Add leases the messages.
+func (l *leaser) Add(msgs []*nats.Msg) {
+	expiry := time.Now().Add(finalizationTimeout)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, m := range msgs {
+		l.expiry[m] = expiry
+	}
+}
+
+// Ack acknowledges the messages, and stops leasing them.
+func (l *leaser) Ack(msgs []*nats.Msg) error {
+	l.mu.Lock()
+	for _, m := range msgs {
+		delete(l.expiry, m)
+	}
+	l.mu.Unlock()
+	for _, m := range msgs {
+		if err := m.AckSync(); err != nil {
+			return errors.Wrapf(err, "acknowledging %v messages of consumer %v", len(msgs), l.consumer)
+		}
+	}
+	return nil
+}
+
+// Stop stops keeping messages in progress, and negatively acknowledges the
+// leased messages.
+func (l *leaser) Stop() {
+	close(l.stop)
+	<-l.done
+
+	l.mu.Lock()
+	msgs := make([]*nats.Msg, 0, len(l.expiry))
+	for m := range l.expiry {
+		msgs = append(msgs, m)
+	}
+	l.expiry = make(map[*nats.Msg]time.Time)
+	l.mu.Unlock()
+	l.nak(msgs)
+}
+
+// run keeps the leased messages in progress every half of the ack wait, and
+// negatively acknowledges the messages of expired leases.
+func (l *leaser) run() {
+	defer close(l.done)
+	ticker := time.NewTicker(l.ackWait / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-l.stop:
+			return
+		case now := <-ticker.C:
+			var leased, expired []*nats.Msg
+			l.mu.Lock()
+			for m, expiry := range l.expiry {
+				if now.After(expiry) {
+					delete(l.expiry, m)
+					expired = append(expired, m)
+					continue
+				}
+				leased = append(leased, m)
+			}
+			l.mu.Unlock()
+			l.nak(expired)
+			for _, m := range leased {
+				if err := m.InProgress(); err != nil {
+					log.Warnf(context.Background(), "Failed to keep %v messages of consumer %v in progress: %v", len(leased), l.consumer, err)
+					break
+				}
+			}
+		}
+	}
+}
+
+// nak negatively acknowledges the messages, so that they're redelivered.
+func (l *leaser) nak(msgs []*nats.Msg) {
+	for _, m := range msgs {
+		if err := m.Nak(); err != nil {
+			log.Warnf(context.Background(), "Failed to nack %v messages of consumer %v: %v", len(msgs), l.consumer, err)
+			return
+		}
+	}
+}
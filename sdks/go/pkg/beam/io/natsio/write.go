@@ -0,0 +1,136 @@
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements.  See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License.  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package natsio
+
+import (
+	"context"
+	"reflect"
+	"time"
+
+	"github.com/apache/beam/sdks/v2/go/pkg/beam"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/core/util/reflectx"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/internal/errors"
+	"github.com/nats-io/nats.go"
+)
+
+const (
+	// maxPendingPublishes is the maximum number of publishes awaiting
+	// acknowledgement, after which publishing blocks.
+	maxPendingPublishes = 1000
+	// publishTimeout is how long the publishes of a bundle wait to be
+	// acknowledged.
+	publishTimeout = time.Minute
+)
+
+func init() {
+	beam.RegisterType(reflect.TypeOf((*writeFn)(nil)).Elem())
+	beam.RegisterFunction(dataMessageFn)
+}
+
+// Write publishes the messages of the given PCollection<Message>, or
+// PCollection<[]byte>, to the given subject of a JetStream stream, on the
+// NATS servers at the URL. For example:
+//
+//	natsio.Write(s, "nats://localhost:4222", "orders.created", msgs)
+//
+// The data and headers of messages are published, and their other fields are
+// ignored. A bundle fails unless all of its messages are acknowledged by the
+// stream, so each message is published at least once. Messages with a
+// Nats-Msg-Id header are deduplicated by the stream within its duplicate
+// window, so that retried bundles don't publish them again.
+func Write(s beam.Scope, url, subject string, col beam.PCollection) {
+	s = s.Scope("natsio.Write")
+	if subject == "" {
+		panic("natsio.Write requires a subject")
+	}
+
+	msgs := col
+	if col.Type().Type() == reflectx.ByteSlice {
+		msgs = beam.ParDo(s, dataMessageFn, col)
+	}
+	beam.ParDo0(s, &writeFn{URL: url, Subject: subject}, msgs)
+}
+
+func dataMessageFn(data []byte) Message {
+	return Message{Data: data}
+}
+
+// writeFn publishes messages to a subject asynchronously, and waits for them
+// to be acknowledged at the end of each bundle.
+type writeFn struct {
+	// URL is the URL of the NATS servers.
+	URL string `json:"url"`
+	// Subject is the subject to publish to.
+	Subject string `json:"subject"`
+
+	nc      *nats.Conn
+	js      nats.JetStreamContext
+	pending []nats.PubAckFuture
+}
+
+func (f *writeFn) Setup(ctx context.Context) error {
+	nc, err := connect(f.URL)
+	if err != nil {
+		return err
+	}
+	f.nc = nc
+	js, err := nc.JetStream(nats.PublishAsyncMaxPending(maxPendingPublishes))
+	if err != nil {
+		return errors.Wrap(err, "getting JetStream context")
+	}
+	f.js = js
+	return nil
+}
+
+func (f *writeFn) ProcessElement(ctx context.Context, m Message) error {
+	msg := &nats.Msg{Subject: f.Subject, Data: m.Data}
+	if len(m.Headers) > 0 {
+		msg.Header = nats.Header(m.Headers)
+	}
+	future, err := f.js.PublishMsgAsync(msg)
+	if err != nil {
+		return errors.Wrapf(err, "publishing to %v", f.Subject)
+	}
+	f.pending = append(f.pending, future)
+	return nil
+}
+
+func (f *writeFn) FinishBundle(ctx context.Context) error {
+	pending := f.pending
+	f.pending = nil
+	timeout := time.NewTimer(publishTimeout)
+	defer timeout.Stop()
+	for _, future := range pending {
+		select {
+		case <-future.Ok():
+		case err := <-future.Err():
+			return errors.Wrapf(err, "publishing %v messages to %v", len(pending), f.Subject)
+		case <-timeout.C:
+			return errors.Errorf("publishing %v messages to %v timed out after %v", len(pending), f.Subject, publishTimeout)
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+func (f *writeFn) Teardown() error {
+	if f.nc == nil {
+		return nil
+	}
+	f.nc.Close()
+	return nil
+}
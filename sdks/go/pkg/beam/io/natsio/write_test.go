@@ -0,0 +1,133 @@
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements.  See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License.  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package natsio
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/apache/beam/sdks/v2/go/pkg/beam"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/testing/ptest"
+	"github.com/nats-io/nats.go"
+)
+
+// stored returns the messages stored in the test stream.
+func stored(t *testing.T, js nats.JetStreamContext) []*nats.RawStreamMsg {
+	t.Helper()
+	info, err := js.StreamInfo(testStream)
+	if err != nil {
+		t.Fatalf("StreamInfo() failed: %v", err)
+	}
+	var msgs []*nats.RawStreamMsg
+	for seq := info.State.FirstSeq; seq > 0 && seq <= info.State.LastSeq; seq++ {
+		m, err := js.GetMsg(testStream, seq)
+		if err != nil {
+			t.Fatalf("GetMsg(%v) failed: %v", seq, err)
+		}
+		msgs = append(msgs, m)
+	}
+	return msgs
+}
+
+func TestWrite(t *testing.T) {
+	url, js := startServer(t)
+
+	p, s := beam.NewPipelineWithRoot()
+	msgs := beam.Create(s,
+		Message{Subject: "ignored", Data: []byte("a"), Headers: map[string][]string{"H": {"v"}}},
+		Message{Data: []byte("b")},
+	)
+	Write(s, url, testSubject, msgs)
+	ptest.RunAndValidate(t, p)
+
+	got := stored(t, js)
+	sort.Slice(got, func(i, j int) bool { return string(got[i].Data) < string(got[j].Data) })
+	if len(got) != 2 {
+		t.Fatalf("Write() stored %v messages, want 2", len(got))
+	}
+	for _, m := range got {
+		if m.Subject != testSubject {
+			t.Errorf("Write() published %q to %v, want %v", m.Data, m.Subject, testSubject)
+		}
+	}
+	if h := got[0].Header.Values("H"); !reflect.DeepEqual(h, []string{"v"}) {
+		t.Errorf("Write() published header %v, want [v]", h)
+	}
+}
+
+func TestWrite_Bytes(t *testing.T) {
+	url, js := startServer(t)
+
+	p, s := beam.NewPipelineWithRoot()
+	Write(s, url, testSubject, beam.Create(s, []byte("a"), []byte("b")))
+	ptest.RunAndValidate(t, p)
+
+	var got []string
+	for _, m := range stored(t, js) {
+		got = append(got, string(m.Data))
+	}
+	sort.Strings(got)
+	if want := []string{"a", "b"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Write() published %v, want %v", got, want)
+	}
+}
+
+func TestWriteFn_Deduplication(t *testing.T) {
+	url, js := startServer(t)
+
+	// A retried bundle publishes messages again, which the stream
+	// deduplicates by their IDs.
+	fn := &writeFn{URL: url, Subject: testSubject}
+	ctx := context.Background()
+	if err := fn.Setup(ctx); err != nil {
+		t.Fatalf("Setup() failed: %v", err)
+	}
+	defer fn.Teardown()
+	for attempt := 0; attempt < 2; attempt++ {
+		for _, id := range []string{"1", "2"} {
+			m := Message{Data: []byte(id), Headers: map[string][]string{nats.MsgIdHdr: {id}}}
+			if err := fn.ProcessElement(ctx, m); err != nil {
+				t.Fatalf("ProcessElement() failed: %v", err)
+			}
+		}
+		if err := fn.FinishBundle(ctx); err != nil {
+			t.Fatalf("FinishBundle() failed: %v", err)
+		}
+	}
+	if got := len(stored(t, js)); got != 2 {
+		t.Errorf("Write() stored %v messages, want 2", got)
+	}
+}
+
+func TestWriteFn_NoStream(t *testing.T) {
+	url, _ := startServer(t)
+
+	fn := &writeFn{URL: url, Subject: "other"}
+	ctx := context.Background()
+	if err := fn.Setup(ctx); err != nil {
+		t.Fatalf("Setup() failed: %v", err)
+	}
+	defer fn.Teardown()
+	if err := fn.ProcessElement(ctx, Message{Data: []byte("a")}); err != nil {
+		t.Fatalf("ProcessElement() failed: %v", err)
+	}
+	// The bundle fails if its messages aren't acknowledged by a stream.
+	if err := fn.FinishBundle(ctx); err == nil {
+		t.Error("FinishBundle() of messages to a subject without a stream succeeded, want error")
+	}
+}
@@ -0,0 +1,229 @@
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements.  See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License.  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package parquetio
+
+import (
+	"encoding/binary"
+	"math"
+	"reflect"
+	"strings"
+
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/internal/errors"
+	"github.com/xitongsys/parquet-go/parquet"
+)
+
+// valueKind is the kind of a value, for comparisons.
+type valueKind int
+
+const (
+	kindInvalid valueKind = iota
+	kindInt
+	kindFloat
+	kindString
+)
+
+// value is a value of a column, normalized so that values from filters,
+// column statistics and rows can be compared.
+type value struct {
+	Kind   valueKind
+	Int    int64
+	Float  float64
+	String string
+}
+
+// newValue normalizes v, which must be of an integer, floating point or
+// string type, or a pointer to one. Nil pointers are invalid values.
+func newValue(v reflect.Value) value {
+	for v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return value{}
+		}
+		v = v.Elem()
+	}
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return value{Kind: kindInt, Int: v.Int()}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return value{Kind: kindInt, Int: int64(v.Uint())}
+	case reflect.Float32, reflect.Float64:
+		return value{Kind: kindFloat, Float: v.Float()}
+	case reflect.String:
+		return value{Kind: kindString, String: v.String()}
+	default:
+		return value{}
+	}
+}
+
+// compare returns -1, 0 or 1 if v is less than, equal to or greater than o.
+// Integers and floating point numbers are compared as floating point
+// numbers. It returns false if the values can't be compared.
+func (v value) compare(o value) (int, bool) {
+	if v.Kind == kindInvalid || o.Kind == kindInvalid {
+		return 0, false
+	}
+	switch {
+	case v.Kind == kindInt && o.Kind == kindInt:
+		return compareInts(v.Int, o.Int), true
+	case v.Kind == kindString && o.Kind == kindString:
+		return strings.Compare(v.String, o.String), true
+	case v.Kind != kindString && o.Kind != kindString:
+		return compareFloats(v.float(), o.float()), true
+	default:
+		return 0, false
+	}
+}
+
+func (v value) float() float64 {
+	if v.Kind == kindInt {
+		return float64(v.Int)
+	}
+	return v.Float
+}
+
+func compareInts(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	default:
+		return 0
+	}
+}
+
+func compareFloats(a, b float64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// filter keeps the rows whose value of a top-level column lies between Min
+// and Max, inclusive.
+type filter struct {
+	Column   string
+	Min, Max value
+}
+
+// newFilter returns a filter of column between min and max. It fails if they
+// aren't of integer, floating point or string types, or can't be compared.
+func newFilter(column string, min, max interface{}) (filter, error) {
+	f := filter{Column: column, Min: newValue(reflect.ValueOf(min)), Max: newValue(reflect.ValueOf(max))}
+	if _, ok := f.Min.compare(f.Max); !ok {
+		return filter{}, errors.Errorf("can't filter column %v between %v and %v, as they must both be numbers or both be strings", column, min, max)
+	}
+	return f, nil
+}
+
+// matches reports whether v is between the bounds of the filter. Invalid
+// values, such as nulls, never match.
+func (f filter) matches(v value) bool {
+	lo, ok := v.compare(f.Min)
+	if !ok {
+		return false
+	}
+	hi, _ := v.compare(f.Max)
+	return lo >= 0 && hi <= 0
+}
+
+// mayMatch reports whether a row group of which the column has the given
+// statistics may contain a row that matches the filter.
+func (f filter) mayMatch(meta *parquet.ColumnMetaData) bool {
+	min, max, ok := statistics(meta)
+	if !ok {
+		return true
+	}
+	if c, ok := max.compare(f.Min); ok && c < 0 {
+		return false
+	}
+	if c, ok := min.compare(f.Max); ok && c > 0 {
+		return false
+	}
+	return true
+}
+
+// statistics returns the minimum and maximum values of a column chunk, if
+// they are recorded and of a comparable type.
+func statistics(meta *parquet.ColumnMetaData) (min, max value, ok bool) {
+	stats := meta.GetStatistics()
+	if stats == nil {
+		return value{}, value{}, false
+	}
+	minBytes, maxBytes := stats.MinValue, stats.MaxValue
+	if minBytes == nil || maxBytes == nil {
+		minBytes, maxBytes = stats.Min, stats.Max
+	}
+	if minBytes == nil || maxBytes == nil {
+		return value{}, value{}, false
+	}
+	min, max = plainValue(meta.Type, minBytes), plainValue(meta.Type, maxBytes)
+	return min, max, min.Kind != kindInvalid && max.Kind != kindInvalid
+}
+
+// plainValue decodes a plain encoded statistic of a column of type t.
+func plainValue(t parquet.Type, b []byte) value {
+	switch {
+	case t == parquet.Type_INT32 && len(b) == 4:
+		return value{Kind: kindInt, Int: int64(int32(binary.LittleEndian.Uint32(b)))}
+	case t == parquet.Type_INT64 && len(b) == 8:
+		return value{Kind: kindInt, Int: int64(binary.LittleEndian.Uint64(b))}
+	case t == parquet.Type_FLOAT && len(b) == 4:
+		return value{Kind: kindFloat, Float: float64(math.Float32frombits(binary.LittleEndian.Uint32(b)))}
+	case t == parquet.Type_DOUBLE && len(b) == 8:
+		return value{Kind: kindFloat, Float: math.Float64frombits(binary.LittleEndian.Uint64(b))}
+	case t == parquet.Type_BYTE_ARRAY:
+		return value{Kind: kindString, String: string(b)}
+	default:
+		return value{}
+	}
+}
+
+// pruneRowGroups removes the row groups of the file that can't contain rows
+// matching all the filters, according to their column statistics.
+func pruneRowGroups(footer *parquet.FileMetaData, filters []filter) {
+	if len(filters) == 0 {
+		return
+	}
+	var kept []*parquet.RowGroup
+	var numRows int64
+	for _, rg := range footer.RowGroups {
+		if rowGroupMayMatch(rg, filters) {
+			kept = append(kept, rg)
+			numRows += rg.NumRows
+		}
+	}
+	footer.RowGroups = kept
+	footer.NumRows = numRows
+}
+
+func rowGroupMayMatch(rg *parquet.RowGroup, filters []filter) bool {
+	for _, f := range filters {
+		for _, chunk := range rg.Columns {
+			meta := chunk.GetMetaData()
+			if meta == nil || len(meta.PathInSchema) != 1 || meta.PathInSchema[0] != f.Column {
+				continue
+			}
+			if !f.mayMatch(meta) {
+				return false
+			}
+		}
+	}
+	return true
+}
@@ -18,14 +18,17 @@ package parquetio
 
 import (
 	"context"
-	"io/ioutil"
+	"fmt"
 	"reflect"
 	"strings"
 
 	"github.com/apache/beam/sdks/v2/go/pkg/beam"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/internal/errors"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/io/fileio"
 	"github.com/apache/beam/sdks/v2/go/pkg/beam/io/filesystem"
-	"github.com/xitongsys/parquet-go-source/buffer"
 	"github.com/xitongsys/parquet-go/reader"
+	"github.com/xitongsys/parquet-go/schema"
+	"github.com/xitongsys/parquet-go/source"
 	"github.com/xitongsys/parquet-go/writer"
 )
 
@@ -35,6 +38,41 @@ func init() {
 	beam.RegisterType(reflect.TypeOf((*parquetWriteFn)(nil)).Elem())
 }
 
+// readOption holds the options of Read.
+type readOption struct {
+	Columns []string
+	Filters []filter
+}
+
+// ReadOptionFn is an option for Read.
+type ReadOptionFn func(*readOption)
+
+// ReadColumns reads only the given columns of the files, named as in the
+// parquet tags of the type's fields. The other fields are left with their
+// zero values. As parquet files store each column separately, the other
+// columns aren't read at all.
+func ReadColumns(columns ...string) ReadOptionFn {
+	return func(o *readOption) {
+		o.Columns = append(o.Columns, columns...)
+	}
+}
+
+// ReadFilterRange reads only the rows whose value of column lies between min
+// and max, inclusive. Row groups whose statistics show that none of their
+// values lie in that range are skipped without being read. The column must
+// be a top-level field of the type of a number or string type, and min and
+// max must both be numbers or both be strings. Rows whose value of the
+// column is null are not read. Panics if the bounds can't be compared.
+func ReadFilterRange(column string, min, max interface{}) ReadOptionFn {
+	f, err := newFilter(column, min, max)
+	if err != nil {
+		panic(fmt.Sprintf("parquetio.ReadFilterRange: %v", err))
+	}
+	return func(o *readOption) {
+		o.Filters = append(o.Filters, f)
+	}
+}
+
 // Read reads a set of files and returns lines as a PCollection<elem>
 // based on type of a parquetStruct (struct with parquet tags).
 // For example:
@@ -47,16 +85,26 @@ func init() {
 //   Day     int32   `parquet:"name=day, type=INT32, convertedtype=DATE"`
 //   Ignored int32   //without parquet tag and won't write
 // }
-func Read(s beam.Scope, glob string, t reflect.Type) beam.PCollection {
+// Options such as ReadColumns and ReadFilterRange limit the columns and rows
+// that are read.
+func Read(s beam.Scope, glob string, t reflect.Type, opts ...ReadOptionFn) beam.PCollection {
 	s = s.Scope("parquetio.Read")
 	filesystem.ValidateScheme(glob)
-	return read(s, t, beam.Create(s, glob))
+	var o readOption
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return read(s, t, beam.Create(s, glob), o)
 }
 
-func read(s beam.Scope, t reflect.Type, col beam.PCollection) beam.PCollection {
+func read(s beam.Scope, t reflect.Type, col beam.PCollection, o readOption) beam.PCollection {
+	fn := &parquetReadFn{Type: beam.EncodedType{T: t}, Columns: o.Columns, Filters: o.Filters}
+	if err := fn.Setup(); err != nil {
+		panic(fmt.Sprintf("parquetio.Read: %v", err))
+	}
 	files := beam.ParDo(s, expandFn, col)
 	return beam.ParDo(s,
-		&parquetReadFn{Type: beam.EncodedType{T: t}},
+		fn,
 		files,
 		beam.TypeDefinition{Var: beam.XType, T: t},
 	)
@@ -84,7 +132,32 @@ func expandFn(ctx context.Context, glob string, emit func(string)) error {
 }
 
 type parquetReadFn struct {
-	Type beam.EncodedType
+	Type    beam.EncodedType
+	Columns []string
+	Filters []filter
+
+	// proj is the type read from the files, which has the fields of Type for
+	// the read columns, at the indices fields of Type.
+	proj   reflect.Type
+	fields []int
+	// filterFields are the indices in proj of the fields of Filters.
+	filterFields []int
+}
+
+func (a *parquetReadFn) Setup() error {
+	var err error
+	if a.proj, a.fields, err = projection(a.Type.T, a.Columns, a.Filters); err != nil {
+		return err
+	}
+	a.filterFields = nil
+	for _, f := range a.Filters {
+		i, ok := fieldIndex(a.proj, f.Column)
+		if !ok {
+			return errors.Errorf("filtered column %v is not a field of %v", f.Column, a.Type.T)
+		}
+		a.filterFields = append(a.filterFields, i)
+	}
+	return nil
 }
 
 func (a *parquetReadFn) ProcessElement(ctx context.Context, filename string, emit func(beam.X)) error {
@@ -94,32 +167,141 @@ func (a *parquetReadFn) ProcessElement(ctx context.Context, filename string, emi
 	}
 	defer fs.Close()
 
-	fd, err := fs.OpenRead(ctx, filename)
+	size, err := fs.Size(ctx, filename)
 	if err != nil {
 		return err
 	}
-	defer fd.Close()
-
-	data, err := ioutil.ReadAll(fd)
+	pf, err := openParquetFile(ctx, fileio.ReadableFile{Metadata: fileio.FileMetadata{Path: filename, Size: size}})
 	if err != nil {
 		return err
 	}
+	defer pf.Close()
 
-	bufferReader := buffer.NewBufferFileFromBytes(data)
-	parquetReader, err := reader.NewParquetReader(bufferReader, reflect.New(a.Type.T).Interface(), 4)
+	parquetReader, err := a.newReader(pf)
 	if err != nil {
 		return err
 	}
+	defer parquetReader.ReadStop()
 
-	vals, err := parquetReader.ReadByNumber(int(parquetReader.GetNumRows()))
-	if err != nil {
-		return err
+	// Rows are read a row group at a time, to hold only one in memory.
+	for _, rg := range parquetReader.Footer.RowGroups {
+		vals, err := parquetReader.ReadByNumber(int(rg.NumRows))
+		if err != nil {
+			return err
+		}
+		for _, v := range vals {
+			if row := reflect.ValueOf(v); a.matches(row) {
+				emit(a.convert(row))
+			}
+		}
+	}
+	return nil
+}
+
+// newReader returns a reader of the projected columns of the file, skipping
+// row groups that can't match the filters. It is reader.NewParquetReader,
+// with the row groups pruned before the columns are opened.
+func (a *parquetReadFn) newReader(pf source.ParquetFile) (*reader.ParquetReader, error) {
+	pr := &reader.ParquetReader{
+		NP:            4,
+		PFile:         pf,
+		ColumnBuffers: make(map[string]*reader.ColumnBufferType),
+		ObjType:       a.proj,
 	}
-	for _, v := range vals {
-		emit(v)
+	if err := pr.ReadFooter(); err != nil {
+		return nil, err
 	}
+	pruneRowGroups(pr.Footer, a.Filters)
 
-	return nil
+	var err error
+	if pr.SchemaHandler, err = schema.NewSchemaHandlerFromStruct(reflect.New(a.proj).Interface()); err != nil {
+		return nil, err
+	}
+	pr.RenameSchema()
+	for i, element := range pr.SchemaHandler.SchemaElements {
+		if element.GetNumChildren() != 0 {
+			continue
+		}
+		path := pr.SchemaHandler.IndexMap[int32(i)]
+		if pr.ColumnBuffers[path], err = reader.NewColumnBuffer(pf, pr.Footer, pr.SchemaHandler, path); err != nil {
+			return nil, err
+		}
+	}
+	return pr, nil
+}
+
+// matches reports whether a row of the projected type matches the filters.
+func (a *parquetReadFn) matches(row reflect.Value) bool {
+	for i, f := range a.Filters {
+		if !f.matches(newValue(row.Field(a.filterFields[i]))) {
+			return false
+		}
+	}
+	return true
+}
+
+// convert converts a row of the projected type to Type.
+func (a *parquetReadFn) convert(row reflect.Value) interface{} {
+	if a.fields == nil {
+		return row.Interface()
+	}
+	out := reflect.New(a.Type.T).Elem()
+	for i, field := range a.fields {
+		out.Field(field).Set(row.Field(i))
+	}
+	return out.Interface()
+}
+
+// projection returns the struct type with the fields of t for the given
+// columns and the columns of the filters, and the indices of those fields in
+// t. If no columns are given, all columns are read, so it returns t itself
+// and nil indices.
+func projection(t reflect.Type, columns []string, filters []filter) (reflect.Type, []int, error) {
+	if len(columns) == 0 {
+		return t, nil, nil
+	}
+	selected := make(map[string]bool)
+	for _, c := range columns {
+		if _, ok := fieldIndex(t, c); !ok {
+			return nil, nil, errors.Errorf("column %v is not a field of %v", c, t)
+		}
+		selected[c] = true
+	}
+	for _, f := range filters {
+		selected[f.Column] = true
+	}
+
+	var fields []reflect.StructField
+	var indices []int
+	for i := 0; i < t.NumField(); i++ {
+		if f := t.Field(i); selected[columnName(f)] {
+			fields = append(fields, f)
+			indices = append(indices, i)
+		}
+	}
+	return reflect.StructOf(fields), indices, nil
+}
+
+// fieldIndex returns the index of the field of the struct type t for column.
+func fieldIndex(t reflect.Type, column string) (int, bool) {
+	for i := 0; i < t.NumField(); i++ {
+		if columnName(t.Field(i)) == column {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// columnName returns the column name in the parquet tag of a field, or the
+// empty string if it has none.
+func columnName(f reflect.StructField) string {
+	for _, kv := range strings.Split(f.Tag.Get("parquet"), ",") {
+		kv = strings.TrimSpace(kv)
+		if i := strings.Index(kv, "="); i > 0 && strings.EqualFold(strings.TrimSpace(kv[:i]), "name") {
+			return strings.TrimSpace(kv[i+1:])
+		}
+	}
+	return ""
 }
 
 // Write writes a PCollection<parquetStruct> to .parquet file.
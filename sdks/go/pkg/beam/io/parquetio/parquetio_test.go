@@ -18,15 +18,20 @@ package parquetio
 import (
 	"errors"
 	"os"
+	"path/filepath"
 	"reflect"
 	"testing"
+	"time"
 
 	"github.com/apache/beam/sdks/v2/go/pkg/beam"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/core/graph/mtime"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/core/graph/window"
 	_ "github.com/apache/beam/sdks/v2/go/pkg/beam/io/filesystem/local"
 	"github.com/apache/beam/sdks/v2/go/pkg/beam/testing/passert"
 	"github.com/apache/beam/sdks/v2/go/pkg/beam/testing/ptest"
 	"github.com/xitongsys/parquet-go-source/local"
 	"github.com/xitongsys/parquet-go/reader"
+	"github.com/xitongsys/parquet-go/writer"
 )
 
 type Student struct {
@@ -120,3 +125,209 @@ func TestWrite(t *testing.T) {
 		t.Fatalf("students differs from studentList. got %+v, expected %+v", students, studentList)
 	}
 }
+
+func TestRead_Columns(t *testing.T) {
+	parquetFile := "../../../../data/student.parquet"
+	p, s := beam.NewPipelineWithRoot()
+	students := Read(s, parquetFile, reflect.TypeOf(Student{}), ReadColumns("name", "age"))
+	passert.Equals(s, students,
+		Student{Name: "StudentName", Age: 20},
+		Student{Name: "StudentName", Age: 21})
+
+	ptest.RunAndValidate(t, p)
+}
+
+// writeStudents writes a parquet file with a row group per student, and
+// returns its name.
+func writeStudents(t *testing.T, students ...Student) string {
+	t.Helper()
+	parquetFile := filepath.Join(t.TempDir(), "students.parquet")
+	fw, err := local.NewLocalFileWriter(parquetFile)
+	if err != nil {
+		t.Fatalf("Failed to create file %v. err: %v", parquetFile, err)
+	}
+	defer fw.Close()
+	pw, err := writer.NewParquetWriter(fw, new(Student), 1)
+	if err != nil {
+		t.Fatalf("Failed to create parquet writer. err: %v", err)
+	}
+	for _, student := range students {
+		if err := pw.Write(student); err != nil {
+			t.Fatalf("Failed to write student. err: %v", err)
+		}
+		if err := pw.Flush(true); err != nil {
+			t.Fatalf("Failed to flush row group. err: %v", err)
+		}
+	}
+	if err := pw.WriteStop(); err != nil {
+		t.Fatalf("Failed to write parquet file. err: %v", err)
+	}
+	return parquetFile
+}
+
+func TestRead_FilterRange(t *testing.T) {
+	parquetFile := writeStudents(t,
+		Student{Name: "a", Id: 1},
+		Student{Name: "b", Id: 2},
+		Student{Name: "c", Id: 3},
+		Student{Name: "d", Id: 4})
+
+	p, s := beam.NewPipelineWithRoot()
+	students := Read(s, parquetFile, reflect.TypeOf(Student{}),
+		ReadColumns("name"), ReadFilterRange("id", 2, 3))
+	passert.Equals(s, students,
+		Student{Name: "b", Id: 2},
+		Student{Name: "c", Id: 3})
+
+	ptest.RunAndValidate(t, p)
+}
+
+func TestPruneRowGroups(t *testing.T) {
+	parquetFile := writeStudents(t,
+		Student{Name: "a", Id: 1},
+		Student{Name: "b", Id: 2},
+		Student{Name: "c", Id: 3})
+	tests := []struct {
+		name   string
+		filter filter
+		want   int64
+	}{
+		{name: "Int", filter: mustFilter(t, "id", 2, 10), want: 2},
+		{name: "Float", filter: mustFilter(t, "id", 0.5, 1.5), want: 1},
+		{name: "String", filter: mustFilter(t, "name", "c", "z"), want: 1},
+		{name: "None", filter: mustFilter(t, "id", 5, 6), want: 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			pf, err := local.NewLocalFileReader(parquetFile)
+			if err != nil {
+				t.Fatalf("Failed to read file %v. err: %v", parquetFile, err)
+			}
+			defer pf.Close()
+			pr := &reader.ParquetReader{PFile: pf}
+			if err := pr.ReadFooter(); err != nil {
+				t.Fatalf("Failed to read parquet footer. err: %v", err)
+			}
+
+			pruneRowGroups(pr.Footer, []filter{test.filter})
+			if got := pr.Footer.NumRows; got != test.want {
+				t.Errorf("pruneRowGroups(%+v) kept %v rows, want %v", test.filter, got, test.want)
+			}
+			if got := int64(len(pr.Footer.RowGroups)); got != test.want {
+				t.Errorf("pruneRowGroups(%+v) kept %v row groups, want %v", test.filter, got, test.want)
+			}
+		})
+	}
+}
+
+func mustFilter(t *testing.T, column string, min, max interface{}) filter {
+	f, err := newFilter(column, min, max)
+	if err != nil {
+		t.Fatalf("newFilter(%v, %v, %v) failed: %v", column, min, max, err)
+	}
+	return f
+}
+
+// StudentData is Student without the partition columns of
+// TestWritePartitioned.
+type StudentData struct {
+	Name   string  `parquet:"name=name, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	Id     int64   `parquet:"name=id, type=INT64"`
+	Weight float32 `parquet:"name=weight, type=FLOAT"`
+	Day    int32   `parquet:"name=day, type=INT32, convertedtype=DATE"`
+}
+
+func TestWritePartitioned(t *testing.T) {
+	dir := t.TempDir()
+	p, s := beam.NewPipelineWithRoot()
+	students := beam.Create(s,
+		Student{Name: "a", Age: 20, Id: 0, Sex: true},
+		Student{Name: "b", Age: 20, Id: 1, Sex: true},
+		Student{Name: "c", Age: 20, Id: 2, Sex: true},
+		Student{Name: "d", Age: 21, Id: 3, Sex: false})
+	WritePartitioned(s, dir, reflect.TypeOf(Student{}), students, []string{"age", "sex"}, WriteMaxRowsPerFile(2))
+
+	ptest.RunAndValidate(t, p)
+
+	want := map[string][]int64{
+		"age=20/sex=true/part-00000.parquet":  {0, 1},
+		"age=20/sex=true/part-00001.parquet":  {2},
+		"age=21/sex=false/part-00000.parquet": {3},
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "*", "*", "*"))
+	if err != nil {
+		t.Fatalf("Failed to list files: %v", err)
+	}
+	if len(files) != len(want) {
+		t.Fatalf("WritePartitioned() wrote %v, want %v files", files, len(want))
+	}
+	for name, ids := range want {
+		filename := filepath.Join(dir, name)
+		pf, err := local.NewLocalFileReader(filename)
+		if err != nil {
+			t.Fatalf("Failed to read file %v. err: %v", filename, err)
+		}
+		pr, err := reader.NewParquetReader(pf, new(StudentData), 1)
+		if err != nil {
+			t.Fatalf("Failed to create parquet reader %v. err: %v", filename, err)
+		}
+		rows, err := pr.ReadByNumber(int(pr.GetNumRows()))
+		pr.ReadStop()
+		pf.Close()
+		if err != nil {
+			t.Fatalf("Failed to parse parquet file %v. err: %v", filename, err)
+		}
+		var got []int64
+		for _, row := range rows {
+			got = append(got, row.(StudentData).Id)
+		}
+		if len(got) != len(ids) {
+			t.Errorf("file %v has ids %v, want %v", name, got, ids)
+		}
+	}
+}
+
+func TestEscapePartitionPath(t *testing.T) {
+	if got, want := escapePartitionPath("a/b=c:d%"), "a%2Fb%3Dc%3Ad%25"; got != want {
+		t.Errorf("escapePartitionPath() = %v, want %v", got, want)
+	}
+}
+
+func studentTimestampFn(s Student) (beam.EventTime, Student) {
+	return mtime.FromMilliseconds(s.Id * 60 * 1000), s
+}
+
+func init() {
+	beam.RegisterFunction(studentTimestampFn)
+}
+
+func TestWriteWindowed(t *testing.T) {
+	dir := t.TempDir()
+	p, s := beam.NewPipelineWithRoot()
+	students := beam.Create(s,
+		Student{Name: "a", Id: 0},
+		Student{Name: "b", Id: 0},
+		Student{Name: "c", Id: 1})
+	timestamped := beam.ParDo(s, studentTimestampFn, students)
+	windowed := beam.WindowInto(s, window.NewFixedWindows(time.Minute), timestamped)
+	WriteWindowed(s, filepath.Join(dir, "students"), reflect.TypeOf(Student{}), windowed, WriteMaxRowsPerFile(1))
+
+	ptest.RunAndValidate(t, p)
+
+	files, err := filepath.Glob(filepath.Join(dir, "*"))
+	if err != nil {
+		t.Fatalf("Failed to list files: %v", err)
+	}
+	var got []string
+	for _, f := range files {
+		got = append(got, filepath.Base(f))
+	}
+	want := []string{
+		"students-19700101T000000Z-19700101T000100Z-pane-0-00000.parquet",
+		"students-19700101T000000Z-19700101T000100Z-pane-0-00001.parquet",
+		"students-19700101T000100Z-19700101T000200Z-pane-0-00000.parquet",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("WriteWindowed() wrote %v, want %v", got, want)
+	}
+}
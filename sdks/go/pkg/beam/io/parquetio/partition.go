@@ -0,0 +1,192 @@
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements.  See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License.  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package parquetio
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/apache/beam/sdks/v2/go/pkg/beam"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/internal/errors"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/io/filesystem"
+)
+
+func init() {
+	beam.RegisterType(reflect.TypeOf((*partitionFn)(nil)).Elem())
+	beam.RegisterType(reflect.TypeOf((*writePartitionFn)(nil)).Elem())
+}
+
+// hiveDefaultPartition is the partition value Hive uses for null values.
+const hiveDefaultPartition = "__HIVE_DEFAULT_PARTITION__"
+
+// WritePartitioned writes a PCollection<parquetStruct> to .parquet files in
+// Hive-style partition directories under dir, as read by Spark, Hive and
+// BigQuery external tables. The partitions are named by the values of the
+// given columns of the type, in order, as in dir/year=2022/month=5. The
+// partition columns are not written to the files, as their values are in
+// the directory names. Null values are written to the partition
+// __HIVE_DEFAULT_PARTITION__.
+//
+// The files of a partition are named part-00000.parquet, part-00001.parquet
+// and so on. Each partition is written to a single file, unless files are
+// limited with WriteMaxRowsPerFile or WriteMaxFileSize.
+func WritePartitioned(s beam.Scope, dir string, t reflect.Type, col beam.PCollection, columns []string, opts ...WriteOptionFn) {
+	s = s.Scope("parquetio.WritePartitioned")
+	filesystem.ValidateScheme(dir)
+	if len(columns) == 0 {
+		panic("parquetio.WritePartitioned requires at least one partition column")
+	}
+	var o writeOption
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	partFn := &partitionFn{Type: beam.EncodedType{T: t}, Columns: columns}
+	if err := partFn.Setup(); err != nil {
+		panic(fmt.Sprintf("parquetio.WritePartitioned: %v", err))
+	}
+	keyed := beam.ParDo(s, partFn, col)
+	grouped := beam.GroupByKey(s, keyed)
+	beam.ParDo0(s, &writePartitionFn{
+		Dir:            strings.TrimSuffix(dir, "/"),
+		Type:           beam.EncodedType{T: t},
+		Columns:        columns,
+		MaxRowsPerFile: o.MaxRowsPerFile,
+		MaxFileSize:    o.MaxFileSize,
+	}, grouped)
+}
+
+// partitionFn keys each element by the path of its partition.
+type partitionFn struct {
+	Type    beam.EncodedType
+	Columns []string
+
+	fields []int
+}
+
+func (f *partitionFn) Setup() error {
+	f.fields = nil
+	for _, c := range f.Columns {
+		i, ok := fieldIndex(f.Type.T, c)
+		if !ok {
+			return errors.Errorf("partition column %v is not a field of %v", c, f.Type.T)
+		}
+		f.fields = append(f.fields, i)
+	}
+	return nil
+}
+
+func (f *partitionFn) ProcessElement(elem beam.X, emit func(string, beam.X)) {
+	v := reflect.ValueOf(elem)
+	parts := make([]string, len(f.Columns))
+	for i, c := range f.Columns {
+		parts[i] = escapePartitionPath(c) + "=" + partitionValue(v.Field(f.fields[i]))
+	}
+	emit(strings.Join(parts, "/"), elem)
+}
+
+// partitionValue returns the directory name of a partition column's value.
+func partitionValue(v reflect.Value) string {
+	for v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return hiveDefaultPartition
+		}
+		v = v.Elem()
+	}
+	s := fmt.Sprint(v.Interface())
+	if s == "" {
+		return hiveDefaultPartition
+	}
+	return escapePartitionPath(s)
+}
+
+// escapePartitionPath escapes the characters of a partition column or value
+// that Hive escapes in partition paths, as %XX.
+func escapePartitionPath(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c < 0x20 || c == 0x7f || strings.IndexByte("\"#%'*/:=?\\{[]^", c) >= 0 {
+			fmt.Fprintf(&b, "%%%02X", c)
+			continue
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
+
+// writePartitionFn writes the elements of a partition to its files, without
+// the partition columns.
+type writePartitionFn struct {
+	Dir            string `json:"dir"`
+	Type           beam.EncodedType
+	Columns        []string `json:"columns"`
+	MaxRowsPerFile int      `json:"max_rows_per_file"`
+	MaxFileSize    int64    `json:"max_file_size"`
+
+	// data is the type of the rows written to the files, which has the
+	// fields of Type other than the partition columns, at the indices fields
+	// of Type.
+	data   reflect.Type
+	fields []int
+}
+
+func (w *writePartitionFn) Setup() {
+	excluded := make(map[string]bool)
+	for _, c := range w.Columns {
+		excluded[c] = true
+	}
+	var fields []reflect.StructField
+	w.fields = nil
+	for i := 0; i < w.Type.T.NumField(); i++ {
+		if f := w.Type.T.Field(i); !excluded[columnName(f)] {
+			fields = append(fields, f)
+			w.fields = append(w.fields, i)
+		}
+	}
+	w.data = reflect.StructOf(fields)
+}
+
+func (w *writePartitionFn) ProcessElement(ctx context.Context, partition string, iter func(*interface{}) bool) error {
+	fs, err := filesystem.New(ctx, w.Dir)
+	if err != nil {
+		return err
+	}
+	defer fs.Close()
+
+	rw := newRollingWriter(ctx, fs, w.data, writeOption{MaxRowsPerFile: w.MaxRowsPerFile, MaxFileSize: w.MaxFileSize}, func(file int) string {
+		return fmt.Sprintf("%v/%v/part-%05d.parquet", w.Dir, partition, file)
+	})
+	var val interface{}
+	for iter(&val) {
+		if err := rw.Write(w.convert(val)); err != nil {
+			return err
+		}
+	}
+	return rw.Close()
+}
+
+// convert converts an element to the type of the rows of the files.
+func (w *writePartitionFn) convert(elem interface{}) interface{} {
+	v := reflect.ValueOf(elem)
+	out := reflect.New(w.data).Elem()
+	for i, field := range w.fields {
+		out.Field(i).Set(v.Field(field))
+	}
+	return out.Interface()
+}
@@ -0,0 +1,179 @@
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements.  See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License.  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package parquetio
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/internal/errors"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/io/filesystem"
+	"github.com/google/uuid"
+	"github.com/xitongsys/parquet-go/writer"
+)
+
+// writeOption holds the options of WritePartitioned and WriteWindowed.
+type writeOption struct {
+	MaxRowsPerFile int
+	MaxFileSize    int64
+}
+
+// WriteOptionFn is an option for WritePartitioned and WriteWindowed.
+type WriteOptionFn func(*writeOption)
+
+// WriteMaxRowsPerFile limits the number of rows in each written file. Rows
+// beyond the limit are written to further files.
+func WriteMaxRowsPerFile(n int) WriteOptionFn {
+	if n < 1 {
+		panic(fmt.Sprintf("parquetio.WriteMaxRowsPerFile max rows must be positive. Got: %v", n))
+	}
+	return func(o *writeOption) {
+		o.MaxRowsPerFile = n
+	}
+}
+
+// WriteMaxFileSize limits the size in bytes of each written file. Once a
+// file reaches the limit, further rows are written to another file. As rows
+// are buffered and compressed in row groups, the size is estimated, so files
+// may be somewhat larger or smaller than the limit.
+func WriteMaxFileSize(bytes int64) WriteOptionFn {
+	if bytes < 1 {
+		panic(fmt.Sprintf("parquetio.WriteMaxFileSize max size must be positive. Got: %v", bytes))
+	}
+	return func(o *writeOption) {
+		o.MaxFileSize = bytes
+	}
+}
+
+// rollingWriter writes rows to a sequence of parquet files, starting another
+// file when the current one reaches the maximum rows or size of the options.
+type rollingWriter struct {
+	ctx  context.Context
+	fs   filesystem.Interface
+	t    reflect.Type
+	opt  writeOption
+	name func(file int) string
+
+	cur   *fileWriter
+	rows  int
+	files int
+}
+
+func newRollingWriter(ctx context.Context, fs filesystem.Interface, t reflect.Type, opt writeOption, name func(file int) string) *rollingWriter {
+	return &rollingWriter{ctx: ctx, fs: fs, t: t, opt: opt, name: name}
+}
+
+// Write writes a row of type t.
+func (w *rollingWriter) Write(row interface{}) error {
+	if w.cur == nil {
+		var err error
+		if w.cur, err = newFileWriter(w.ctx, w.fs, w.name(w.files), w.t); err != nil {
+			return err
+		}
+		w.files++
+	}
+	if err := w.cur.Write(row); err != nil {
+		w.cur.Abort()
+		w.cur = nil
+		return err
+	}
+	w.rows++
+	if (w.opt.MaxRowsPerFile > 0 && w.rows >= w.opt.MaxRowsPerFile) ||
+		(w.opt.MaxFileSize > 0 && w.cur.Size() >= w.opt.MaxFileSize) {
+		return w.Close()
+	}
+	return nil
+}
+
+// Close finishes the current file, if any.
+func (w *rollingWriter) Close() error {
+	if w.cur == nil {
+		return nil
+	}
+	cur := w.cur
+	w.cur, w.rows = nil, 0
+	return cur.Close()
+}
+
+// fileWriter writes a parquet file. The file is written to a temporary file
+// next to it, which is renamed to the file once complete, so readers never
+// see partial files. The temporary file's name starts with a dot, so that it
+// is ignored by tools such as Spark and Hive.
+type fileWriter struct {
+	ctx      context.Context
+	fs       filesystem.Interface
+	filename string
+	tmp      string
+	fd       io.WriteCloser
+	pw       *writer.ParquetWriter
+}
+
+func newFileWriter(ctx context.Context, fs filesystem.Interface, filename string, t reflect.Type) (*fileWriter, error) {
+	dir, base := "", filename
+	if i := strings.LastIndex(filename, "/"); i >= 0 {
+		dir, base = filename[:i+1], filename[i+1:]
+	}
+	tmp := fmt.Sprintf("%v.tmp-%v-%v", dir, uuid.New(), base)
+
+	fd, err := fs.OpenWrite(ctx, tmp)
+	if err != nil {
+		return nil, err
+	}
+	pw, err := writer.NewParquetWriterFromWriter(fd, reflect.New(t).Interface(), 4)
+	if err != nil {
+		fd.Close()
+		return nil, err
+	}
+	return &fileWriter{ctx: ctx, fs: fs, filename: filename, tmp: tmp, fd: fd, pw: pw}, nil
+}
+
+// Write writes a row to the file.
+func (f *fileWriter) Write(row interface{}) error {
+	return f.pw.Write(row)
+}
+
+// Size estimates the size the file would have if it were closed now.
+func (f *fileWriter) Size() int64 {
+	return f.pw.Offset + f.pw.Size + f.pw.ObjsSize
+}
+
+// Close finishes writing the file, and renames it to its final name.
+func (f *fileWriter) Close() error {
+	if err := f.pw.WriteStop(); err != nil {
+		f.Abort()
+		return errors.WithContextf(err, "writing parquet file %v", f.filename)
+	}
+	if err := f.fd.Close(); err != nil {
+		f.Abort()
+		return errors.WithContextf(err, "writing parquet file %v", f.filename)
+	}
+	if err := filesystem.Rename(f.ctx, f.fs, f.tmp, f.filename); err != nil {
+		return errors.WithContextf(err, "finalizing parquet file %v", f.filename)
+	}
+	return nil
+}
+
+// Abort closes the temporary file and removes it, if the file system
+// supports it.
+func (f *fileWriter) Abort() {
+	f.fd.Close()
+	if rm, ok := f.fs.(filesystem.Remover); ok {
+		rm.Remove(f.ctx, f.tmp)
+	}
+}
@@ -0,0 +1,63 @@
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements.  See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License.  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package parquetio
+
+import (
+	"context"
+	"io"
+
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/internal/errors"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/io/fileio"
+	"github.com/xitongsys/parquet-go/source"
+)
+
+// parquetFile is a read-only source.ParquetFile over a file of a Beam file
+// system. The reader opens the file once per column read, and seeks to the
+// column chunks it needs, so columns and row groups that aren't read are
+// never fetched.
+type parquetFile struct {
+	ctx  context.Context
+	file fileio.ReadableFile
+	io.ReadSeekCloser
+}
+
+// openParquetFile opens the file for reading by a parquet reader.
+func openParquetFile(ctx context.Context, file fileio.ReadableFile) (*parquetFile, error) {
+	rsc, err := file.OpenSeeker(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &parquetFile{ctx: ctx, file: file, ReadSeekCloser: rsc}, nil
+}
+
+// Open opens the file again, with an independent position. Only the file
+// itself can be opened, as name must be empty.
+func (f *parquetFile) Open(name string) (source.ParquetFile, error) {
+	if name != "" && name != f.file.Metadata.Path {
+		return nil, errors.Errorf("parquet file %v refers to other file %v, which is unsupported", f.file.Metadata.Path, name)
+	}
+	return openParquetFile(f.ctx, f.file)
+}
+
+// Write fails, as the file is read-only.
+func (f *parquetFile) Write([]byte) (int, error) {
+	return 0, errors.Errorf("parquet file %v is read-only", f.file.Metadata.Path)
+}
+
+// Create fails, as the file is read-only.
+func (f *parquetFile) Create(string) (source.ParquetFile, error) {
+	return nil, errors.Errorf("parquet file %v is read-only", f.file.Metadata.Path)
+}
@@ -0,0 +1,105 @@
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements.  See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License.  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package parquetio
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+
+	"github.com/apache/beam/sdks/v2/go/pkg/beam"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/core/graph/mtime"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/core/graph/window"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/io/filesystem"
+)
+
+func init() {
+	beam.RegisterType(reflect.TypeOf((*writeWindowFn)(nil)).Elem())
+}
+
+// windowTimeFormat is the format of the window bounds in file names.
+const windowTimeFormat = "20060102T150405Z"
+
+// WriteWindowed writes a windowed, possibly unbounded, PCollection<parquetStruct>
+// to .parquet files, with separate files for each window and pane. The files
+// are named after prefix, the window's bounds, the pane's index and their
+// number, as in prefix-20220501T100000Z-20220501T100500Z-pane-0-00000.parquet.
+// Files of the global window are named prefix-global-pane-0-00000.parquet.
+//
+// Each pane is written to a single file, unless files are limited with
+// WriteMaxRowsPerFile or WriteMaxFileSize. Files are written under a
+// temporary name and renamed once complete, so readers never observe
+// partial files.
+func WriteWindowed(s beam.Scope, prefix string, t reflect.Type, col beam.PCollection, opts ...WriteOptionFn) {
+	s = s.Scope("parquetio.WriteWindowed")
+	filesystem.ValidateScheme(prefix)
+	var o writeOption
+	for _, opt := range opts {
+		opt(&o)
+	}
+	pre := beam.AddFixedKey(s, col)
+	post := beam.GroupByKey(s, pre)
+	beam.ParDo0(s, &writeWindowFn{
+		Prefix:         prefix,
+		Type:           beam.EncodedType{T: t},
+		MaxRowsPerFile: o.MaxRowsPerFile,
+		MaxFileSize:    o.MaxFileSize,
+	}, post)
+}
+
+// writeWindowFn writes the elements of a window's pane to its files.
+type writeWindowFn struct {
+	Prefix         string `json:"prefix"`
+	Type           beam.EncodedType
+	MaxRowsPerFile int   `json:"max_rows_per_file"`
+	MaxFileSize    int64 `json:"max_file_size"`
+}
+
+func (w *writeWindowFn) ProcessElement(ctx context.Context, pane beam.PaneInfo, win beam.Window, _ int, iter func(*interface{}) bool) error {
+	fs, err := filesystem.New(ctx, w.Prefix)
+	if err != nil {
+		return err
+	}
+	defer fs.Close()
+
+	name := windowName(win)
+	rw := newRollingWriter(ctx, fs, w.Type.T, writeOption{MaxRowsPerFile: w.MaxRowsPerFile, MaxFileSize: w.MaxFileSize}, func(file int) string {
+		return fmt.Sprintf("%v-%v-pane-%d-%05d.parquet", w.Prefix, name, pane.Index, file)
+	})
+	var val interface{}
+	for iter(&val) {
+		if err := rw.Write(val); err != nil {
+			return err
+		}
+	}
+	return rw.Close()
+}
+
+// windowName returns the name of a window in file names.
+func windowName(w beam.Window) string {
+	switch w := w.(type) {
+	case window.GlobalWindow:
+		return "global"
+	case window.IntervalWindow:
+		return formatWindowTime(w.Start) + "-" + formatWindowTime(w.End)
+	default:
+		return formatWindowTime(w.MaxTimestamp())
+	}
+}
+
+func formatWindowTime(t mtime.Time) string {
+	return t.ToTime().UTC().Format(windowTimeFormat)
+}
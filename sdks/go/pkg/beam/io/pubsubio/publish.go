@@ -0,0 +1,188 @@
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements.  See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License.  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pubsubio
+
+import (
+	"context"
+	"reflect"
+	"strconv"
+	"time"
+
+	"github.com/apache/beam/sdks/v2/go/pkg/beam"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/core/util/reflectx"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/internal/errors"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/log"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/util/pubsubx"
+	pb "google.golang.org/genproto/googleapis/pubsub/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	// maxPublishMessages and maxPublishBytes limit the messages of each
+	// publish request. Pub/Sub limits requests to 1000 messages and 10 MB.
+	maxPublishMessages = 1000
+	maxPublishBytes    = 9 << 20
+	// publishRetries is the number of times publishing is retried after a
+	// retryable error, with backoff from initialPublishBackoff up to
+	// maxPublishBackoff.
+	publishRetries        = 5
+	initialPublishBackoff = 100 * time.Millisecond
+	maxPublishBackoff     = 10 * time.Second
+)
+
+func init() {
+	beam.RegisterType(reflect.TypeOf((*publishFn)(nil)).Elem())
+	beam.RegisterFunction(dataMessageFn)
+}
+
+// PublishOptions represents options for publishing to PubSub.
+type PublishOptions struct {
+	// TimestampAttribute, if set, is the attribute the event times of the
+	// messages are published in, in milliseconds since the epoch.
+	TimestampAttribute string
+}
+
+// Publish publishes the messages of the given PCollection<*PubSubMessage>,
+// or PCollection<[]byte>, to the given pubsub topic with the Pub/Sub API, so
+// that it runs on any portable runner, rather than only on Dataflow like
+// Write. The attributes and ordering keys of the messages are published.
+// The messages of a bundle are published in order, so that messages with an
+// ordering key are delivered in order to subscriptions with message ordering
+// enabled. If the PUBSUB_EMULATOR_HOST environment variable is set, it
+// connects to the emulator at that address.
+func Publish(s beam.Scope, project, topic string, col beam.PCollection, opts *PublishOptions) {
+	s = s.Scope("pubsubio.Publish")
+
+	fn := &publishFn{Topic: pubsubx.MakeQualifiedTopicName(project, topic)}
+	if opts != nil {
+		fn.TimestampAttribute = opts.TimestampAttribute
+	}
+	msgs := col
+	if col.Type().Type() == reflectx.ByteSlice {
+		msgs = beam.ParDo(s, dataMessageFn, col)
+	}
+	beam.ParDo0(s, fn, msgs)
+}
+
+func dataMessageFn(data []byte) *pb.PubsubMessage {
+	return &pb.PubsubMessage{Data: data}
+}
+
+// publishFn publishes messages in batches, in order.
+type publishFn struct {
+	// Topic is the qualified name of the topic.
+	Topic string `json:"topic"`
+	// TimestampAttribute is the attribute to publish event times in, if any.
+	TimestampAttribute string `json:"timestamp_attribute"`
+
+	conn *grpc.ClientConn
+	pub  *publisher
+}
+
+func (f *publishFn) Setup(ctx context.Context) error {
+	conn, err := dial(ctx)
+	if err != nil {
+		return err
+	}
+	f.conn = conn
+	f.pub = newPublisher(pb.NewPublisherClient(conn), f.Topic)
+	return nil
+}
+
+func (f *publishFn) StartBundle(ctx context.Context) {
+	f.pub.Reset()
+}
+
+func (f *publishFn) ProcessElement(ctx context.Context, et beam.EventTime, m *pb.PubsubMessage) error {
+	if f.TimestampAttribute != "" {
+		m = proto.Clone(m).(*pb.PubsubMessage)
+		if m.Attributes == nil {
+			m.Attributes = make(map[string]string)
+		}
+		m.Attributes[f.TimestampAttribute] = strconv.FormatInt(et.Milliseconds(), 10)
+	}
+	return f.pub.Add(ctx, m)
+}
+
+func (f *publishFn) FinishBundle(ctx context.Context) error {
+	return f.pub.Flush(ctx)
+}
+
+func (f *publishFn) Teardown() error {
+	if f.conn == nil {
+		return nil
+	}
+	return f.conn.Close()
+}
+
+// publisher publishes messages to a topic in batches, in order.
+type publisher struct {
+	client pb.PublisherClient
+	topic  string
+	batch  []*pb.PubsubMessage
+	size   int
+}
+
+func newPublisher(client pb.PublisherClient, topic string) *publisher {
+	return &publisher{client: client, topic: topic}
+}
+
+// Add adds the message to the batch, publishing the batch first if the
+// message doesn't fit in it.
+func (p *publisher) Add(ctx context.Context, m *pb.PubsubMessage) error {
+	size := proto.Size(m)
+	if len(p.batch) > 0 && (len(p.batch) >= maxPublishMessages || p.size+size > maxPublishBytes) {
+		if err := p.Flush(ctx); err != nil {
+			return err
+		}
+	}
+	p.batch = append(p.batch, m)
+	p.size += size
+	return nil
+}
+
+// Reset drops the batch.
+func (p *publisher) Reset() {
+	p.batch, p.size = nil, 0
+}
+
+// Flush publishes the batch, retrying with backoff if it fails with a
+// retryable error. Batches are published one at a time, to keep the order of
+// messages with ordering keys.
+func (p *publisher) Flush(ctx context.Context) error {
+	if len(p.batch) == 0 {
+		return nil
+	}
+	batch := p.batch
+	p.Reset()
+
+	backoff := initialPublishBackoff
+	for attempt := 0; ; attempt++ {
+		_, err := p.client.Publish(ctx, &pb.PublishRequest{Topic: p.topic, Messages: batch})
+		if err == nil {
+			return nil
+		}
+		if attempt >= publishRetries || !isRetryable(err) {
+			return errors.Wrapf(err, "publishing %v messages to %v", len(batch), p.topic)
+		}
+		log.Warnf(ctx, "Retrying publish of %v messages to %v in %v: %v", len(batch), p.topic, backoff, err)
+		time.Sleep(backoff)
+		if backoff *= 2; backoff > maxPublishBackoff {
+			backoff = maxPublishBackoff
+		}
+	}
+}
@@ -0,0 +1,90 @@
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements.  See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License.  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pubsubio
+
+import (
+	"reflect"
+	"sort"
+	"strconv"
+	"testing"
+
+	"github.com/apache/beam/sdks/v2/go/pkg/beam"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/core/graph/mtime"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/testing/ptest"
+	pb "google.golang.org/genproto/googleapis/pubsub/v1"
+)
+
+func init() {
+	beam.RegisterFunction(atTestTime)
+}
+
+func TestMain(m *testing.M) {
+	ptest.Main(m)
+}
+
+// testTime is the event time of the published messages.
+var testTime = mtime.FromMilliseconds(1651399200123)
+
+func atTestTime(m *pb.PubsubMessage) (beam.EventTime, *pb.PubsubMessage) {
+	return testTime, m
+}
+
+func TestPublish(t *testing.T) {
+	srv := startFakeServer(t)
+
+	p, s := beam.NewPipelineWithRoot()
+	msgs := beam.CreateList(s, []*pb.PubsubMessage{
+		{Data: []byte("a"), Attributes: map[string]string{"k": "v"}, OrderingKey: "key"},
+		{Data: []byte("b"), OrderingKey: "key"},
+		{Data: []byte("c")},
+	})
+	Publish(s, "p", "t", beam.ParDo(s, atTestTime, msgs), &PublishOptions{TimestampAttribute: "ts"})
+	ptest.RunAndValidate(t, p)
+
+	var got []string
+	ts := strconv.FormatInt(testTime.Milliseconds(), 10)
+	for _, m := range srv.Messages() {
+		got = append(got, string(m.Data)+" "+m.OrderingKey+" "+m.Attributes["k"])
+		if m.Attributes["ts"] != ts {
+			t.Errorf("Publish() published %v with timestamp attribute %v, want %v", m.ID, m.Attributes["ts"], ts)
+		}
+	}
+	// Messages are published in order.
+	want := []string{"a key v", "b key ", "c  "}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Publish() published %q, want %q", got, want)
+	}
+}
+
+func TestPublish_Bytes(t *testing.T) {
+	srv := startFakeServer(t)
+
+	p, s := beam.NewPipelineWithRoot()
+	Publish(s, "p", "t", beam.Create(s, []byte("a"), []byte("b")), nil)
+	ptest.RunAndValidate(t, p)
+
+	var got []string
+	for _, m := range srv.Messages() {
+		got = append(got, string(m.Data))
+		if len(m.Attributes) != 0 {
+			t.Errorf("Publish() published %v with attributes %v, want none", m.ID, m.Attributes)
+		}
+	}
+	sort.Strings(got)
+	if want := []string{"a", "b"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Publish() published %q, want %q", got, want)
+	}
+}
@@ -13,7 +13,8 @@
 // See the License for the specific language governing permissions and
 // limitations under the License.
 
-// Package pubsubio provides access to PubSub on Dataflow streaming.
+// Package pubsubio provides access to PubSub on Dataflow streaming, and on
+// other portable runners with ReadSdf.
 // Experimental.
 package pubsubio
 
@@ -46,6 +47,17 @@ type ReadOptions struct {
 	IDAttribute        string
 	TimestampAttribute string
 	WithAttributes     bool
+	// NumReaders is the number of concurrent readers of ReadSdf. The
+	// default is 1.
+	NumReaders int
+	// MaxDeliveryAttempts, if set, is the number of delivery attempts after
+	// which ReadSdf routes a message to the dead-letter topic, instead of
+	// outputting it. The subscription must have a dead-letter policy, since
+	// Pub/Sub only counts delivery attempts of such subscriptions.
+	MaxDeliveryAttempts int
+	// DeadLetterTopic is the topic ReadSdf publishes dead-lettered messages
+	// to. The default is the dead-letter topic of the subscription.
+	DeadLetterTopic string
 }
 
 // Read reads an unbounded number of PubSubMessages from the given
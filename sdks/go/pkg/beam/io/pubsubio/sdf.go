@@ -0,0 +1,488 @@
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements.  See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License.  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pubsubio
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/apache/beam/sdks/v2/go/pkg/beam"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/core/graph/mtime"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/core/sdf"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/internal/errors"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/io/internal/gcpx"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/io/rtrackers/offsetrange"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/io/rtrackers/unbounded"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/log"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/util/pubsubx"
+	pb "google.golang.org/genproto/googleapis/pubsub/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// emulatorHostEnv is the environment variable with the address of the
+	// Pub/Sub emulator, if any.
+	emulatorHostEnv = "PUBSUB_EMULATOR_HOST"
+	endpoint        = "pubsub.googleapis.com:443"
+	pubsubScope     = "https://www.googleapis.com/auth/pubsub"
+
+	// maxPullMessages is the maximum number of messages of each pull.
+	maxPullMessages = 1000
+	// pullTimeout bounds how long a pull waits for messages.
+	pullTimeout = 10 * time.Second
+	// pollInterval is how long reading waits after a pull without messages.
+	pollInterval = time.Second
+	// checkpointInterval is how long messages are pulled before
+	// checkpointing, so that they can be committed and acknowledged.
+	checkpointInterval = 10 * time.Second
+	// ackDeadline is the ack deadline of pulled messages, which is extended
+	// every half of it until they are acknowledged.
+	ackDeadline = time.Minute
+	// finalizationTimeout is how long pulled messages are leased, waiting
+	// for their bundle to be finalized, after which they are redelivered.
+	finalizationTimeout = 10 * time.Minute
+	// maxAckIDs is the maximum number of ack IDs of each request.
+	maxAckIDs = 1000
+)
+
+func init() {
+	beam.RegisterType(reflect.TypeOf((*readSdfFn)(nil)).Elem())
+	beam.RegisterFunction(messageDataFn)
+}
+
+// ReadSdf is a variation of Read implemented via SplittableDoFn, which pulls
+// messages with the Pub/Sub API, so that it runs on any portable runner that
+// supports unbounded splittable DoFns and bundle finalization, rather than
+// only on Dataflow. It requires the Subscription option, which must be a
+// subscription of the topic. IDAttribute isn't supported. If the
+// PUBSUB_EMULATOR_HOST environment variable is set, it connects to the
+// emulator at that address. If WithAttributes is set, it returns the
+// messages, with their attributes and ordering keys, as an unbounded
+// PCollection<*PubSubMessage>, or else their data as an unbounded
+// PCollection<[]byte>.
+//
+// Messages are output timestamped at the time of their TimestampAttribute,
+// if set, which is in milliseconds since the epoch or in RFC 3339 format,
+// as published by Publish. Messages without a valid timestamp attribute, or
+// all messages if it isn't set, are timestamped at their publish time. The
+// output watermark is the earliest timestamp of the last messages pulled, or
+// the current time when there are none, so messages timestamped long before
+// they are delivered, such as redelivered messages, may be late. Messages
+// are acknowledged once their bundle is finalized, and their ack deadlines
+// are extended until then. Messages of bundles that aren't finalized before
+// a timeout, such as bundles that failed, and messages still leased when the
+// reader is torn down, are negatively acknowledged, so that they're
+// redelivered right away rather than once their ack deadlines expire.
+//
+// If MaxDeliveryAttempts is set, messages that have been delivered that many
+// times are published to the DeadLetterTopic, or the dead-letter topic of
+// the subscription, and acknowledged, instead of being output, so that
+// messages that fail permanently aren't redelivered forever.
+func ReadSdf(s beam.Scope, project, topic string, opts *ReadOptions) beam.PCollection {
+	s = s.Scope("pubsubio.ReadSdf")
+
+	if opts == nil || opts.Subscription == "" {
+		panic("pubsubio.ReadSdf requires a subscription")
+	}
+	if opts.IDAttribute != "" {
+		panic("pubsubio.ReadSdf doesn't support IDAttribute")
+	}
+	if opts.MaxDeliveryAttempts < 0 {
+		panic(fmt.Sprintf("pubsubio.ReadSdf MaxDeliveryAttempts must be positive. Got: %v", opts.MaxDeliveryAttempts))
+	}
+	if opts.DeadLetterTopic != "" && opts.MaxDeliveryAttempts == 0 {
+		panic("pubsubio.ReadSdf DeadLetterTopic requires MaxDeliveryAttempts")
+	}
+	readers := opts.NumReaders
+	if readers < 1 {
+		readers = 1
+	}
+	fn := &readSdfFn{
+		Subscription:        pubsubx.MakeQualifiedSubscriptionName(project, opts.Subscription),
+		Topic:               pubsubx.MakeQualifiedTopicName(project, topic),
+		TimestampAttribute:  opts.TimestampAttribute,
+		Readers:             readers,
+		MaxDeliveryAttempts: opts.MaxDeliveryAttempts,
+	}
+	if opts.DeadLetterTopic != "" {
+		fn.DeadLetterTopic = pubsubx.MakeQualifiedTopicName(project, opts.DeadLetterTopic)
+	}
+	msgs := beam.ParDo(s, fn, beam.Impulse(s))
+	if opts.WithAttributes {
+		return msgs
+	}
+	return beam.ParDo(s, messageDataFn, msgs)
+}
+
+func messageDataFn(m *pb.PubsubMessage) []byte {
+	return m.GetData()
+}
+
+// readSdfFn pulls messages of a subscription. Its restriction is a range of
+// pull sequence numbers, which is split into a range per reader. Each
+// reader claims a number per pull.
+type readSdfFn struct {
+	// Subscription is the qualified name of the subscription.
+	Subscription string `json:"subscription"`
+	// Topic is the qualified name of the topic of the subscription.
+	Topic string `json:"topic"`
+	// TimestampAttribute is the attribute with the event times of the
+	// messages, if any.
+	TimestampAttribute string `json:"timestamp_attribute"`
+	// Readers is the number of concurrent readers.
+	Readers int `json:"readers"`
+	// MaxDeliveryAttempts is the number of delivery attempts after which
+	// messages are dead-lettered, if any.
+	MaxDeliveryAttempts int `json:"max_delivery_attempts"`
+	// DeadLetterTopic is the qualified name of the dead-letter topic, if it
+	// isn't the one of the subscription.
+	DeadLetterTopic string `json:"dead_letter_topic"`
+
+	conn        *grpc.ClientConn
+	client      pb.SubscriberClient
+	leases      *leaser
+	deadLetters *publisher
+}
+
+func (f *readSdfFn) Setup(ctx context.Context) error {
+	conn, err := dial(ctx)
+	if err != nil {
+		return err
+	}
+	f.conn = conn
+	f.client = pb.NewSubscriberClient(conn)
+
+	sub, err := f.client.GetSubscription(ctx, &pb.GetSubscriptionRequest{Subscription: f.Subscription})
+	if err != nil {
+		return errors.Wrapf(err, "getting subscription %v", f.Subscription)
+	}
+	if sub.GetTopic() != f.Topic {
+		return errors.Errorf("subscription %v is of topic %v, not %v", f.Subscription, sub.GetTopic(), f.Topic)
+	}
+	if f.MaxDeliveryAttempts > 0 {
+		policy := sub.GetDeadLetterPolicy()
+		if policy == nil {
+			return errors.Errorf("subscription %v has no dead-letter policy, so its delivery attempts aren't counted", f.Subscription)
+		}
+		topic := f.DeadLetterTopic
+		if topic == "" {
+			topic = policy.GetDeadLetterTopic()
+		}
+		f.deadLetters = newPublisher(pb.NewPublisherClient(conn), topic)
+	}
+	f.leases = newLeaser(f.client, f.Subscription)
+	return nil
+}
+
+func (f *readSdfFn) Teardown() error {
+	if f.leases != nil {
+		f.leases.Stop()
+	}
+	if f.conn == nil {
+		return nil
+	}
+	return f.conn.Close()
+}
+
+func (f *readSdfFn) CreateInitialRestriction(_ []byte) offsetrange.Restriction {
+	return offsetrange.Restriction{Start: 0, End: math.MaxInt64}
+}
+
+// SplitRestriction splits the restriction into a restriction per reader.
+// Restriction.EvenSplits would overflow on the unbounded range.
+func (f *readSdfFn) SplitRestriction(_ []byte, rest offsetrange.Restriction) []offsetrange.Restriction {
+	n := int64(f.Readers)
+	size := (rest.End - rest.Start) / n
+	splits := make([]offsetrange.Restriction, n)
+	for i := range splits {
+		splits[i] = offsetrange.Restriction{Start: rest.Start + int64(i)*size, End: rest.Start + int64(i+1)*size}
+	}
+	splits[n-1].End = rest.End
+	return splits
+}
+
+func (f *readSdfFn) RestrictionSize(_ []byte, rest offsetrange.Restriction) float64 {
+	return rest.Size()
+}
+
+func (f *readSdfFn) CreateTracker(rest offsetrange.Restriction) *sdf.LockRTracker {
+	return sdf.NewLockRTracker(unbounded.NewTracker(rest))
+}
+
+func (f *readSdfFn) InitialWatermarkEstimatorState(et beam.EventTime, _ offsetrange.Restriction, _ []byte) int64 {
+	return int64(et)
+}
+
+func (f *readSdfFn) CreateWatermarkEstimator(state int64) *sdf.ManualWatermarkEstimator {
+	return &sdf.ManualWatermarkEstimator{State: mtime.Time(state).ToTime()}
+}
+
+func (f *readSdfFn) WatermarkEstimatorState(e *sdf.ManualWatermarkEstimator) int64 {
+	return int64(mtime.FromTime(e.State))
+}
+
+func (f *readSdfFn) ProcessElement(ctx context.Context, we *sdf.ManualWatermarkEstimator, bf beam.BundleFinalization, rt *sdf.LockRTracker, _ []byte, emit func(beam.EventTime, *pb.PubsubMessage)) (sdf.ProcessContinuation, error) {
+	// The messages are acknowledged once the runner has committed their
+	// bundle.
+	var ackIDs []string
+	defer func() {
+		if len(ackIDs) == 0 {
+			return
+		}
+		ids := ackIDs
+		bf.RegisterCallback(finalizationTimeout, func() error {
+			return f.leases.Ack(context.Background(), ids)
+		})
+	}()
+
+	rest := rt.GetRestriction().(offsetrange.Restriction)
+	checkpoint := time.Now().Add(checkpointInterval)
+	for n := rest.Start; ; n++ {
+		if !rt.TryClaim(n) {
+			return sdf.StopProcessing(), nil
+		}
+		msgs, err := f.pull(ctx)
+		if err != nil {
+			if isRetryable(err) {
+				log.Warnf(ctx, "Retrying pull of messages of %v: %v", f.Subscription, err)
+				return sdf.ResumeProcessingIn(pollInterval), nil
+			}
+			return sdf.StopProcessing(), errors.Wrapf(err, "pulling messages of %v", f.Subscription)
+		}
+		if len(msgs) == 0 {
+			unbounded.AdvanceWatermark(we, time.Now())
+			return sdf.ResumeProcessingIn(pollInterval), nil
+		}
+
+		ids := make([]string, len(msgs))
+		for i, m := range msgs {
+			ids[i] = m.GetAckId()
+		}
+		f.leases.Add(ctx, ids)
+		ackIDs = append(ackIDs, ids...)
+
+		var earliest time.Time
+		for _, m := range msgs {
+			if f.isDeadLetter(m) {
+				if err := f.deadLetters.Add(ctx, m.GetMessage()); err != nil {
+					return sdf.StopProcessing(), errors.Wrap(err, "dead-lettering messages")
+				}
+				continue
+			}
+			ts := f.eventTime(m.GetMessage())
+			if earliest.IsZero() || ts.Before(earliest) {
+				earliest = ts
+			}
+			emit(mtime.FromTime(ts), m.GetMessage())
+		}
+		if f.deadLetters != nil {
+			if err := f.deadLetters.Flush(ctx); err != nil {
+				return sdf.StopProcessing(), errors.Wrap(err, "dead-lettering messages")
+			}
+		}
+		if !earliest.IsZero() {
+			unbounded.AdvanceWatermark(we, earliest)
+		}
+
+		if time.Now().After(checkpoint) {
+			return sdf.ResumeProcessingIn(0), nil
+		}
+	}
+}
+
+// isDeadLetter returns whether the message has been delivered the maximum
+// number of times, and is to be dead-lettered.
+func (f *readSdfFn) isDeadLetter(m *pb.ReceivedMessage) bool {
+	return f.deadLetters != nil && int(m.GetDeliveryAttempt()) >= f.MaxDeliveryAttempts
+}
+
+// eventTime returns the time of the timestamp attribute of the message, if
+// it's set and valid, or else its publish time.
+func (f *readSdfFn) eventTime(m *pb.PubsubMessage) time.Time {
+	if f.TimestampAttribute != "" {
+		if t, ok := parseTimestamp(m.GetAttributes()[f.TimestampAttribute]); ok {
+			return t
+		}
+	}
+	return m.GetPublishTime().AsTime()
+}
+
+// parseTimestamp parses a timestamp attribute, in milliseconds since the
+// epoch or in RFC 3339 format.
+func parseTimestamp(s string) (time.Time, bool) {
+	if ms, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.UnixMilli(ms), true
+	}
+	if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		return t, true
+	}
+	return time.Time{}, false
+}
+
+// pull pulls messages, and returns none if there are none before the pull
+// timeout.
+func (f *readSdfFn) pull(ctx context.Context) ([]*pb.ReceivedMessage, error) {
+	pctx, cancel := context.WithTimeout(ctx, pullTimeout)
+	defer cancel()
+	resp, err := f.client.Pull(pctx, &pb.PullRequest{Subscription: f.Subscription, MaxMessages: maxPullMessages})
+	if err != nil {
+		if status.Code(err) == codes.DeadlineExceeded && ctx.Err() == nil {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return resp.GetReceivedMessages(), nil
+}
+
+// leaser extends the ack deadlines of pulled messages until they are
+// acknowledged, or their lease expires, when they are negatively
+// acknowledged.
+type leaser struct {
+	client pb.SubscriberClient
+	sub    string
+
+	mu     sync.Mutex
+	expiry map[string]time.Time // By ack ID.
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+func newLeaser(client pb.SubscriberClient, sub string) *leaser {
+	ctx, cancel := context.WithCancel(context.Background())
+	l := &leaser{client: client, sub: sub, expiry: make(map[string]time.Time), cancel: cancel, done: make(chan struct{})}
+	go l.run(ctx)
+	return l
+}
+
+// Add leases the messages, and extends their ack deadlines.
+func (l *leaser) Add(ctx context.Context, ids []string) {
+	expiry := time.Now().Add(finalizationTimeout)
+	l.mu.Lock()
+	for _, id := range ids {
+		l.expiry[id] = expiry
+	}
+	l.mu.Unlock()
+	if err := l.modify(ctx, ids, ackDeadline); err != nil {
+		log.Warnf(ctx, "Failed to extend ack deadlines of %v messages of %v: %v", len(ids), l.sub, err)
+	}
+}
+
+// Ack acknowledges the messages, and stops leasing them.
+func (l *leaser) Ack(ctx context.Context, ids []string) error {
+	l.mu.Lock()
+	for _, id := range ids {
+		delete(l.expiry, id)
+	}
+	l.mu.Unlock()
+	for len(ids) > 0 {
+		n := len(ids)
+		if n > maxAckIDs {
+			n = maxAckIDs
+		}
+		if _, err := l.client.Acknowledge(ctx, &pb.AcknowledgeRequest{Subscription: l.sub, AckIds: ids[:n]}); err != nil {
+			return errors.Wrapf(err, "acknowledging %v messages of %v", len(ids), l.sub)
+		}
+		ids = ids[n:]
+	}
+	return nil
+}
+
+// Stop stops extending ack deadlines, and negatively acknowledges the leased
+// messages.
+func (l *leaser) Stop() {
+	l.cancel()
+	<-l.done
+
+	l.mu.Lock()
+	ids := make([]string, 0, len(l.expiry))
+	for id := range l.expiry {
+		ids = append(ids, id)
+	}
+	l.expiry = make(map[string]time.Time)
+	l.mu.Unlock()
+	if err := l.modify(context.Background(), ids, 0); err != nil {
+		log.Warnf(context.Background(), "Failed to nack %v messages of %v: %v", len(ids), l.sub, err)
+	}
+}
+
+// run extends the ack deadlines of the leased messages every half of the
+// ack deadline, and negatively acknowledges the messages of expired leases.
+func (l *leaser) run(ctx context.Context) {
+	defer close(l.done)
+	ticker := time.NewTicker(ackDeadline / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			var ids, expired []string
+			l.mu.Lock()
+			for id, expiry := range l.expiry {
+				if now.After(expiry) {
+					delete(l.expiry, id)
+					expired = append(expired, id)
+					continue
+				}
+				ids = append(ids, id)
+			}
+			l.mu.Unlock()
+			if err := l.modify(ctx, expired, 0); err != nil && ctx.Err() == nil {
+				log.Warnf(ctx, "Failed to nack %v messages of %v: %v", len(expired), l.sub, err)
+			}
+			if err := l.modify(ctx, ids, ackDeadline); err != nil && ctx.Err() == nil {
+				log.Warnf(ctx, "Failed to extend ack deadlines of %v messages of %v: %v", len(ids), l.sub, err)
+			}
+		}
+	}
+}
+
+// modify sets the ack deadlines of the messages, which negatively
+// acknowledges them if the deadline is zero.
+func (l *leaser) modify(ctx context.Context, ids []string, deadline time.Duration) error {
+	for len(ids) > 0 {
+		n := len(ids)
+		if n > maxAckIDs {
+			n = maxAckIDs
+		}
+		req := &pb.ModifyAckDeadlineRequest{Subscription: l.sub, AckIds: ids[:n], AckDeadlineSeconds: int32(deadline / time.Second)}
+		if _, err := l.client.ModifyAckDeadline(ctx, req); err != nil {
+			return err
+		}
+		ids = ids[n:]
+	}
+	return nil
+}
+
+// dial connects to Pub/Sub, or to the emulator if PUBSUB_EMULATOR_HOST is
+// set.
+func dial(ctx context.Context) (*grpc.ClientConn, error) {
+	return gcpx.Dial(ctx, emulatorHostEnv, endpoint, pubsubScope)
+}
+
+// isRetryable returns whether the request that failed with the error can be
+// retried.
+func isRetryable(err error) bool {
+	return gcpx.IsRetryable(err, codes.ResourceExhausted, codes.Internal)
+}
@@ -0,0 +1,273 @@
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements.  See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License.  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pubsubio
+
+import (
+	"context"
+	"math"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/pubsub/pstest"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/core/graph/mtime"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/core/util/reflectx"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/io/rtrackers/offsetrange"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/io/rtrackers/unbounded/unboundedtest"
+	pb "google.golang.org/genproto/googleapis/pubsub/v1"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const (
+	testTopic        = "projects/p/topics/t"
+	testSubscription = "projects/p/subscriptions/s"
+)
+
+// startFakeServer starts a fake Pub/Sub server with the test topic and
+// subscription, and points the transforms at it.
+func startFakeServer(t *testing.T) *pstest.Server {
+	t.Helper()
+	srv := pstest.NewServer()
+	t.Cleanup(func() { srv.Close() })
+	t.Setenv(emulatorHostEnv, srv.Addr)
+
+	ctx := context.Background()
+	if _, err := srv.GServer.CreateTopic(ctx, &pb.Topic{Name: testTopic}); err != nil {
+		t.Fatalf("Failed to create topic: %v", err)
+	}
+	sub := &pb.Subscription{Name: testSubscription, Topic: testTopic, AckDeadlineSeconds: 10}
+	if _, err := srv.GServer.CreateSubscription(ctx, sub); err != nil {
+		t.Fatalf("Failed to create subscription: %v", err)
+	}
+	return srv
+}
+
+func TestReadSdfFn(t *testing.T) {
+	srv := startFakeServer(t)
+	for _, data := range []string{"a", "b", "c"} {
+		srv.Publish(testTopic, []byte(data), nil)
+	}
+
+	fn := &readSdfFn{Subscription: testSubscription, Topic: testTopic, Readers: 2}
+	ctx := context.Background()
+	if err := fn.Setup(ctx); err != nil {
+		t.Fatalf("Setup() failed: %v", err)
+	}
+	defer fn.Teardown()
+	rests := fn.SplitRestriction(nil, fn.CreateInitialRestriction(nil))
+	if len(rests) != 2 {
+		t.Fatalf("SplitRestriction() = %v, want a restriction per reader", rests)
+	}
+	rt := fn.CreateTracker(rests[0])
+	we := fn.CreateWatermarkEstimator(fn.InitialWatermarkEstimatorState(mtime.MinTimestamp, rests[0], nil))
+	var bf unboundedtest.Finalization
+	var got []string
+	var latest time.Time
+	pc, err := fn.ProcessElement(ctx, we, &bf, rt, nil, func(et beam.EventTime, m *pb.PubsubMessage) {
+		if ts := m.GetPublishTime().AsTime(); !et.ToTime().Equal(ts.Truncate(time.Millisecond)) {
+			t.Errorf("ProcessElement() output %v at %v, want its publish time", m, et.ToTime())
+		} else if ts.After(latest) {
+			latest = ts
+		}
+		got = append(got, string(m.GetData()))
+	})
+	if err != nil {
+		t.Fatalf("ProcessElement() failed: %v", err)
+	}
+	if !pc.ShouldResume() {
+		t.Errorf("ProcessElement() = %v, want it to resume", pc)
+	}
+	sort.Strings(got)
+	if want := []string{"a", "b", "c"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ProcessElement() output %v, want %v", got, want)
+	}
+	if wm := we.CurrentWatermark(); wm.Before(latest) {
+		t.Errorf("ProcessElement() watermark = %v, want at least %v", wm, latest)
+	}
+
+	// The messages are leased until the bundle is finalized.
+	for _, m := range srv.Messages() {
+		if m.Acks != 0 || len(m.Modacks) == 0 {
+			t.Errorf("message %v has %v acks and modacks %v before finalization, want none and some", m.ID, m.Acks, m.Modacks)
+		}
+	}
+	if len(bf.Callbacks) != 1 {
+		t.Fatalf("ProcessElement() registered %v finalization callbacks, want 1", len(bf.Callbacks))
+	}
+	if err := bf.Callbacks[0](); err != nil {
+		t.Fatalf("finalization callback failed: %v", err)
+	}
+	for _, m := range srv.Messages() {
+		if m.Acks != 1 {
+			t.Errorf("message %v has %v acks after finalization, want 1", m.ID, m.Acks)
+		}
+	}
+}
+
+func TestReadSdfFn_WrongTopic(t *testing.T) {
+	startFakeServer(t)
+	fn := &readSdfFn{Subscription: testSubscription, Topic: "projects/p/topics/other", Readers: 1}
+	if err := fn.Setup(context.Background()); err == nil {
+		t.Error("Setup() with a subscription of another topic succeeded, want error")
+	}
+	fn.Teardown()
+}
+
+func TestCreateTracker(t *testing.T) {
+	rt := (&readSdfFn{}).CreateTracker(offsetrange.Restriction{Start: 0, End: math.MaxInt64})
+	if rt.IsBounded() {
+		t.Error("CreateTracker() is bounded, want unbounded so that draining stops reads")
+	}
+}
+
+func TestReadSdf(t *testing.T) {
+	_, s := beam.NewPipelineWithRoot()
+	if got := ReadSdf(s, "p", "t", &ReadOptions{Subscription: "s"}).Type().Type(); got != reflectx.ByteSlice {
+		t.Errorf("ReadSdf() output type = %v, want %v", got, reflectx.ByteSlice)
+	}
+	msgType := reflect.TypeOf((*pb.PubsubMessage)(nil))
+	if got := ReadSdf(s, "p", "t", &ReadOptions{Subscription: "s", WithAttributes: true}).Type().Type(); got != msgType {
+		t.Errorf("ReadSdf() with attributes output type = %v, want %v", got, msgType)
+	}
+
+	for _, opts := range []*ReadOptions{
+		nil,
+		{},
+		{Subscription: "s", IDAttribute: "id"},
+		{Subscription: "s", MaxDeliveryAttempts: -1},
+		{Subscription: "s", DeadLetterTopic: "dl"},
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("ReadSdf() with options %+v succeeded, want panic", opts)
+				}
+			}()
+			ReadSdf(s, "p", "t", opts)
+		}()
+	}
+}
+
+func TestReadSdfFn_EventTime(t *testing.T) {
+	publishTime := time.Date(2022, 5, 1, 10, 0, 0, 0, time.UTC)
+	tests := []struct {
+		attr string
+		want time.Time
+	}{
+		{"1651399200123", time.Date(2022, 5, 1, 10, 0, 0, 123e6, time.UTC)},
+		{"2022-05-01T09:00:00.5Z", time.Date(2022, 5, 1, 9, 0, 0, 5e8, time.UTC)},
+		{"yesterday", publishTime},
+		{"", publishTime},
+	}
+	fn := &readSdfFn{TimestampAttribute: "ts"}
+	for _, test := range tests {
+		m := &pb.PubsubMessage{PublishTime: timestamppb.New(publishTime)}
+		if test.attr != "" {
+			m.Attributes = map[string]string{"ts": test.attr}
+		}
+		if got := fn.eventTime(m); !got.Equal(test.want) {
+			t.Errorf("eventTime() with timestamp attribute %q = %v, want %v", test.attr, got, test.want)
+		}
+	}
+}
+
+func TestReadSdfFn_DeadLetter(t *testing.T) {
+	srv := startFakeServer(t)
+	ctx := context.Background()
+	const deadLetterTopic = "projects/p/topics/dl"
+	if _, err := srv.GServer.CreateTopic(ctx, &pb.Topic{Name: deadLetterTopic}); err != nil {
+		t.Fatalf("Failed to create topic: %v", err)
+	}
+	sub := &pb.Subscription{
+		Name:               "projects/p/subscriptions/dls",
+		Topic:              testTopic,
+		AckDeadlineSeconds: 10,
+		DeadLetterPolicy:   &pb.DeadLetterPolicy{DeadLetterTopic: deadLetterTopic, MaxDeliveryAttempts: 5},
+	}
+	if _, err := srv.GServer.CreateSubscription(ctx, sub); err != nil {
+		t.Fatalf("Failed to create subscription: %v", err)
+	}
+	srv.Publish(testTopic, []byte("a"), nil)
+
+	// process reads a bundle, finalizes it if finalize is set, and returns
+	// the output messages.
+	process := func(finalize bool) []string {
+		fn := &readSdfFn{Subscription: sub.Name, Topic: testTopic, Readers: 1, MaxDeliveryAttempts: 2}
+		if err := fn.Setup(ctx); err != nil {
+			t.Fatalf("Setup() failed: %v", err)
+		}
+		// Teardown negatively acknowledges the messages that weren't
+		// acknowledged, so that they're redelivered right away.
+		defer fn.Teardown()
+		rest := fn.CreateInitialRestriction(nil)
+		we := fn.CreateWatermarkEstimator(fn.InitialWatermarkEstimatorState(mtime.MinTimestamp, rest, nil))
+		var bf unboundedtest.Finalization
+		var got []string
+		_, err := fn.ProcessElement(ctx, we, &bf, fn.CreateTracker(rest), nil, func(_ beam.EventTime, m *pb.PubsubMessage) {
+			got = append(got, string(m.GetData()))
+		})
+		if err != nil {
+			t.Fatalf("ProcessElement() failed: %v", err)
+		}
+		if finalize {
+			for _, cb := range bf.Callbacks {
+				if err := cb(); err != nil {
+					t.Fatalf("finalization callback failed: %v", err)
+				}
+			}
+		}
+		return got
+	}
+
+	// pstest counts delivery attempts from 0, rather than 1 like Pub/Sub, so
+	// the first two deliveries are output, and their bundles aren't
+	// finalized.
+	for i := 0; i < 2; i++ {
+		if got := process(false); !reflect.DeepEqual(got, []string{"a"}) {
+			t.Fatalf("ProcessElement() output %v on delivery %v, want [a]", got, i)
+		}
+	}
+	// The next delivery is dead-lettered, and acknowledged once its bundle
+	// is finalized.
+	if got := process(true); len(got) != 0 {
+		t.Errorf("ProcessElement() output %v once dead-lettered, want none", got)
+	}
+
+	var dead int
+	for _, m := range srv.Messages() {
+		if string(m.Data) != "a" {
+			t.Errorf("message %v has data %q, want a", m.ID, m.Data)
+		}
+		if m.Acks == 1 {
+			continue
+		}
+		dead++
+	}
+	if n := len(srv.Messages()); n != 2 || dead != 1 {
+		t.Errorf("got %v messages, %v unacknowledged, want the message acknowledged and dead-lettered", n, dead)
+	}
+}
+
+func TestReadSdfFn_NoDeadLetterPolicy(t *testing.T) {
+	startFakeServer(t)
+	fn := &readSdfFn{Subscription: testSubscription, Topic: testTopic, Readers: 1, MaxDeliveryAttempts: 2}
+	if err := fn.Setup(context.Background()); err == nil {
+		t.Error("Setup() with MaxDeliveryAttempts of a subscription without a dead-letter policy succeeded, want error")
+	}
+	fn.Teardown()
+}
@@ -0,0 +1,102 @@
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements.  See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License.  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package pubsubliteio provides transformations to read from and write to
+// Google Cloud Pub/Sub Lite. See also: https://cloud.google.com/pubsub/lite/docs.
+//
+// Topics and subscriptions are named as
+// "projects/<project>/locations/<location>/topics/<topic>" and
+// "projects/<project>/locations/<location>/subscriptions/<subscription>",
+// where the location is a zone or a region. The transforms use the Pub/Sub
+// Lite API directly, at the regional endpoint of the location. If the
+// PUBSUBLITE_EMULATOR_HOST environment variable is set, they connect to the
+// server at that address instead, such as a fake server in tests.
+// Experimental.
+package pubsubliteio
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"github.com/apache/beam/sdks/v2/go/pkg/beam"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/internal/errors"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/io/internal/gcpx"
+	pb "google.golang.org/genproto/googleapis/cloud/pubsublite/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+)
+
+const (
+	// emulatorHostEnv is the environment variable with the address of the
+	// Pub/Sub Lite server to connect to, if any.
+	emulatorHostEnv = "PUBSUBLITE_EMULATOR_HOST"
+	// endpointFormat is the format of the endpoint of a region.
+	endpointFormat = "%v-pubsublite.googleapis.com:443"
+	cloudScope     = "https://www.googleapis.com/auth/cloud-platform"
+)
+
+var (
+	topicRegexp        = regexp.MustCompile(`^projects/[^/]+/locations/([^/]+)/topics/[^/]+$`)
+	subscriptionRegexp = regexp.MustCompile(`^projects/[^/]+/locations/([^/]+)/subscriptions/[^/]+$`)
+)
+
+func init() {
+	beam.RegisterType(reflect.TypeOf((*pb.PubSubMessage)(nil)).Elem())
+	beam.RegisterType(reflect.TypeOf((*pb.SequencedMessage)(nil)).Elem())
+}
+
+// mustParseTopic panics if the topic name is invalid, and returns its
+// location.
+func mustParseTopic(topic string) string {
+	m := topicRegexp.FindStringSubmatch(topic)
+	if m == nil {
+		panic(errors.Errorf("invalid topic %q, want projects/<project>/locations/<location>/topics/<topic>", topic))
+	}
+	return m[1]
+}
+
+// mustParseSubscription panics if the subscription name is invalid, and
+// returns its location.
+func mustParseSubscription(sub string) string {
+	m := subscriptionRegexp.FindStringSubmatch(sub)
+	if m == nil {
+		panic(errors.Errorf("invalid subscription %q, want projects/<project>/locations/<location>/subscriptions/<subscription>", sub))
+	}
+	return m[1]
+}
+
+// region returns the region of the location, which is either a zone, such
+// as "us-central1-a", or a region, such as "us-central1".
+func region(location string) string {
+	if parts := strings.Split(location, "-"); len(parts) == 3 {
+		return parts[0] + "-" + parts[1]
+	}
+	return location
+}
+
+// dial connects to the Pub/Sub Lite endpoint of the region of the location,
+// or to the server at PUBSUBLITE_EMULATOR_HOST if it's set.
+func dial(ctx context.Context, location string) (*grpc.ClientConn, error) {
+	return gcpx.Dial(ctx, emulatorHostEnv, fmt.Sprintf(endpointFormat, region(location)), cloudScope)
+}
+
+// isRetryable returns whether the request that failed with the error can be
+// retried.
+func isRetryable(err error) bool {
+	return gcpx.IsRetryable(err, codes.ResourceExhausted, codes.Internal)
+}
@@ -0,0 +1,383 @@
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements.  See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License.  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pubsubliteio
+
+import (
+	"context"
+	"math"
+	"net"
+	"reflect"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/apache/beam/sdks/v2/go/pkg/beam"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/core/graph/mtime"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/io/rtrackers/offsetrange"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/io/rtrackers/unbounded/unboundedtest"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/testing/ptest"
+	pb "google.golang.org/genproto/googleapis/cloud/pubsublite/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func TestMain(m *testing.M) {
+	ptest.Main(m)
+}
+
+const (
+	testTopic        = "projects/p/locations/us-central1-a/topics/t"
+	testSubscription = "projects/p/locations/us-central1-a/subscriptions/s"
+)
+
+// testPublishTime is the publish time of the first message of each
+// partition, and each next message is published a second later.
+var testPublishTime = time.Date(2022, 5, 1, 10, 0, 0, 0, time.UTC)
+
+// fakeServer is an in-memory Pub/Sub Lite API server, with the test topic
+// and subscription.
+type fakeServer struct {
+	pb.UnimplementedAdminServiceServer
+	pb.UnimplementedCursorServiceServer
+	pb.UnimplementedTopicStatsServiceServer
+	pb.UnimplementedSubscriberServiceServer
+	pb.UnimplementedPublisherServiceServer
+
+	mu sync.Mutex
+	// partitions is the messages of each partition, and cursors the
+	// committed cursors by partition.
+	partitions [][]*pb.SequencedMessage
+	cursors    map[int64]int64
+	// subscribes is the initial requests of the subscribe streams.
+	subscribes []*pb.InitialSubscribeRequest
+	// unavailable is the number of publish streams to fail with Unavailable
+	// after their first batch.
+	unavailable int
+}
+
+// startFakeServer starts a fake server with the partitions, and points the
+// transforms at it.
+func startFakeServer(t *testing.T, partitions int) *fakeServer {
+	t.Helper()
+	lis, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	fake := &fakeServer{partitions: make([][]*pb.SequencedMessage, partitions), cursors: make(map[int64]int64)}
+	srv := grpc.NewServer()
+	pb.RegisterAdminServiceServer(srv, fake)
+	pb.RegisterCursorServiceServer(srv, fake)
+	pb.RegisterTopicStatsServiceServer(srv, fake)
+	pb.RegisterSubscriberServiceServer(srv, fake)
+	pb.RegisterPublisherServiceServer(srv, fake)
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+	t.Setenv(emulatorHostEnv, lis.Addr().String())
+	return fake
+}
+
+// add appends messages with the data to the partition.
+func (f *fakeServer) add(partition int64, data ...string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, d := range data {
+		f.append(partition, &pb.PubSubMessage{Data: []byte(d)})
+	}
+}
+
+// append appends the message to the partition. It must be called with the
+// lock held.
+func (f *fakeServer) append(partition int64, m *pb.PubSubMessage) {
+	offset := int64(len(f.partitions[partition]))
+	f.partitions[partition] = append(f.partitions[partition], &pb.SequencedMessage{
+		Cursor:      &pb.Cursor{Offset: offset},
+		PublishTime: timestamppb.New(testPublishTime.Add(time.Duration(offset) * time.Second)),
+		Message:     m,
+		SizeBytes:   int64(len(m.GetData())),
+	})
+}
+
+func (f *fakeServer) GetSubscription(ctx context.Context, req *pb.GetSubscriptionRequest) (*pb.Subscription, error) {
+	if req.GetName() != testSubscription {
+		return nil, status.Errorf(codes.NotFound, "subscription %v not found", req.GetName())
+	}
+	return &pb.Subscription{Name: testSubscription, Topic: testTopic}, nil
+}
+
+func (f *fakeServer) GetTopicPartitions(ctx context.Context, req *pb.GetTopicPartitionsRequest) (*pb.TopicPartitions, error) {
+	if req.GetName() != testTopic {
+		return nil, status.Errorf(codes.NotFound, "topic %v not found", req.GetName())
+	}
+	return &pb.TopicPartitions{PartitionCount: int64(len(f.partitions))}, nil
+}
+
+// ListPartitionCursors returns a page per partition.
+func (f *fakeServer) ListPartitionCursors(ctx context.Context, req *pb.ListPartitionCursorsRequest) (*pb.ListPartitionCursorsResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var p int64
+	if req.GetPageToken() != "" {
+		p, _ = strconv.ParseInt(req.GetPageToken(), 10, 64)
+	}
+	resp := &pb.ListPartitionCursorsResponse{}
+	if offset, ok := f.cursors[p]; ok {
+		resp.PartitionCursors = []*pb.PartitionCursor{{Partition: p, Cursor: &pb.Cursor{Offset: offset}}}
+	}
+	if p+1 < int64(len(f.partitions)) {
+		resp.NextPageToken = strconv.FormatInt(p+1, 10)
+	}
+	return resp, nil
+}
+
+func (f *fakeServer) CommitCursor(ctx context.Context, req *pb.CommitCursorRequest) (*pb.CommitCursorResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.cursors[req.GetPartition()] = req.GetCursor().GetOffset()
+	return &pb.CommitCursorResponse{}, nil
+}
+
+func (f *fakeServer) ComputeHeadCursor(ctx context.Context, req *pb.ComputeHeadCursorRequest) (*pb.ComputeHeadCursorResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return &pb.ComputeHeadCursorResponse{HeadCursor: &pb.Cursor{Offset: int64(len(f.partitions[req.GetPartition()]))}}, nil
+}
+
+// Subscribe sends the messages of the partition from the initial cursor, as
+// flow control allows.
+func (f *fakeServer) Subscribe(stream pb.SubscriberService_SubscribeServer) error {
+	req, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	initial := req.GetInitial()
+	f.mu.Lock()
+	f.subscribes = append(f.subscribes, initial)
+	f.mu.Unlock()
+	offset := initial.GetInitialLocation().GetCursor().GetOffset()
+	if err := stream.Send(&pb.SubscribeResponse{Response: &pb.SubscribeResponse_Initial{
+		Initial: &pb.InitialSubscribeResponse{Cursor: &pb.Cursor{Offset: offset}},
+	}}); err != nil {
+		return err
+	}
+
+	var tokens int64
+	for {
+		req, err := stream.Recv()
+		if err != nil {
+			return nil
+		}
+		tokens += req.GetFlowControl().GetAllowedMessages()
+		f.mu.Lock()
+		msgs := f.partitions[initial.GetPartition()][offset:]
+		f.mu.Unlock()
+		if int64(len(msgs)) > tokens {
+			msgs = msgs[:tokens]
+		}
+		if len(msgs) == 0 {
+			continue
+		}
+		if err := stream.Send(&pb.SubscribeResponse{Response: &pb.SubscribeResponse_Messages{
+			Messages: &pb.MessageResponse{Messages: msgs},
+		}}); err != nil {
+			return err
+		}
+		offset += int64(len(msgs))
+		tokens -= int64(len(msgs))
+	}
+}
+
+func TestPartitionFn(t *testing.T) {
+	fake := startFakeServer(t, 3)
+	fake.cursors[1] = 2
+
+	fn := &partitionFn{Subscription: testSubscription}
+	ctx := context.Background()
+	if err := fn.Setup(ctx); err != nil {
+		t.Fatalf("Setup() failed: %v", err)
+	}
+	defer fn.Teardown()
+	var got []partition
+	if err := fn.ProcessElement(ctx, nil, func(p partition) { got = append(got, p) }); err != nil {
+		t.Fatalf("ProcessElement() failed: %v", err)
+	}
+	want := []partition{{Partition: 0, Offset: 0}, {Partition: 1, Offset: 2}, {Partition: 2, Offset: 0}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ProcessElement() output %v, want %v", got, want)
+	}
+}
+
+func TestReadFn(t *testing.T) {
+	fake := startFakeServer(t, 2)
+	fake.add(0, "a", "b", "c", "d")
+	fake.add(1, "x")
+
+	fn := &readFn{
+		Subscription:           testSubscription,
+		CheckpointInterval:     200 * time.Millisecond,
+		MaxOutstandingMessages: 2,
+		MaxOutstandingBytes:    minOutstandingBytes,
+	}
+	ctx := context.Background()
+	if err := fn.Setup(ctx); err != nil {
+		t.Fatalf("Setup() failed: %v", err)
+	}
+	defer fn.Teardown()
+
+	p := partition{Partition: 0, Offset: 1}
+	rest := fn.CreateInitialRestriction(p)
+	if want := (offsetrange.Restriction{Start: 1, End: math.MaxInt64}); rest != want {
+		t.Fatalf("CreateInitialRestriction(%v) = %v, want %v", p, rest, want)
+	}
+	rt := fn.CreateTracker(rest)
+	we := fn.CreateWatermarkEstimator(fn.InitialWatermarkEstimatorState(mtime.MinTimestamp, rest, p))
+	var bf unboundedtest.Finalization
+	var got []string
+	start := time.Now()
+	pc, err := fn.ProcessElement(ctx, we, &bf, rt, p, func(et beam.EventTime, m *pb.SequencedMessage) {
+		if want := m.GetPublishTime().AsTime(); !et.ToTime().Equal(want) {
+			t.Errorf("ProcessElement() output %v at %v, want its publish time %v", m, et.ToTime(), want)
+		}
+		got = append(got, string(m.GetMessage().GetData()))
+	})
+	if err != nil {
+		t.Fatalf("ProcessElement() failed: %v", err)
+	}
+	if !pc.ShouldResume() {
+		t.Errorf("ProcessElement() = %v, want it to resume", pc)
+	}
+	// The messages are read in two batches, with flow control.
+	if want := []string{"b", "c", "d"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ProcessElement() output %v, want %v", got, want)
+	}
+	// The partition is caught up, so the watermark is at least the time the
+	// head cursor was computed.
+	if wm := we.CurrentWatermark(); wm.Before(start.Truncate(time.Millisecond)) {
+		t.Errorf("ProcessElement() watermark = %v, want at least %v", wm, start)
+	}
+	if _, residual, err := rt.TrySplit(0); err != nil || residual != (offsetrange.Restriction{Start: 4, End: math.MaxInt64}) {
+		t.Errorf("TrySplit(0) = %v, %v, want the offsets from 4", residual, err)
+	}
+
+	// The cursor is committed once the bundle is finalized.
+	if _, ok := fake.cursors[0]; ok {
+		t.Errorf("cursor of partition 0 committed before finalization: %v", fake.cursors[0])
+	}
+	if len(bf.Callbacks) != 1 {
+		t.Fatalf("ProcessElement() registered %v finalization callbacks, want 1", len(bf.Callbacks))
+	}
+	if err := bf.Callbacks[0](); err != nil {
+		t.Fatalf("finalization callback failed: %v", err)
+	}
+	if got := fake.cursors[0]; got != 4 {
+		t.Errorf("committed cursor of partition 0 = %v, want 4", got)
+	}
+}
+
+func TestReadFn_Idle(t *testing.T) {
+	fake := startFakeServer(t, 1)
+	fake.add(0, "a")
+
+	fn := &readFn{
+		Subscription:           testSubscription,
+		CheckpointInterval:     100 * time.Millisecond,
+		MaxOutstandingMessages: defaultMaxOutstandingMessages,
+		MaxOutstandingBytes:    defaultMaxOutstandingBytes,
+	}
+	ctx := context.Background()
+	if err := fn.Setup(ctx); err != nil {
+		t.Fatalf("Setup() failed: %v", err)
+	}
+	defer fn.Teardown()
+
+	p := partition{Partition: 0, Offset: 1}
+	rest := fn.CreateInitialRestriction(p)
+	we := fn.CreateWatermarkEstimator(fn.InitialWatermarkEstimatorState(mtime.MinTimestamp, rest, p))
+	var bf unboundedtest.Finalization
+	start := time.Now()
+	pc, err := fn.ProcessElement(ctx, we, &bf, fn.CreateTracker(rest), p, func(_ beam.EventTime, m *pb.SequencedMessage) {
+		t.Errorf("ProcessElement() output %v, want none", m)
+	})
+	if err != nil {
+		t.Fatalf("ProcessElement() failed: %v", err)
+	}
+	if !pc.ShouldResume() {
+		t.Errorf("ProcessElement() = %v, want it to resume", pc)
+	}
+	if wm := we.CurrentWatermark(); wm.Before(start.Truncate(time.Millisecond)) {
+		t.Errorf("ProcessElement() watermark = %v, want at least %v", wm, start)
+	}
+	if len(bf.Callbacks) != 0 {
+		t.Errorf("ProcessElement() registered %v finalization callbacks, want none", len(bf.Callbacks))
+	}
+}
+
+func TestCreateTracker(t *testing.T) {
+	rt := (&readFn{}).CreateTracker(offsetrange.Restriction{Start: 3, End: 100})
+	if rt.IsBounded() {
+		t.Error("CreateTracker() is bounded, want unbounded so that draining stops reads")
+	}
+	if _, residual, err := rt.TrySplit(0); err != nil || residual != (offsetrange.Restriction{Start: 3, End: 100}) {
+		t.Errorf("TrySplit(0) before claiming = %v, %v, want {3 100}", residual, err)
+	}
+
+	rt = (&readFn{}).CreateTracker(offsetrange.Restriction{Start: 3, End: 100})
+	rt.TryClaim(int64(5))
+	if _, residual, err := rt.TrySplit(0.5); err != nil || residual != nil {
+		t.Errorf("TrySplit(0.5) = %v, %v, want no residual", residual, err)
+	}
+	if _, residual, err := rt.TrySplit(0); err != nil || residual != (offsetrange.Restriction{Start: 6, End: 100}) {
+		t.Errorf("TrySplit(0) = %v, %v, want {6 100}", residual, err)
+	}
+}
+
+func TestRead(t *testing.T) {
+	_, s := beam.NewPipelineWithRoot()
+	msgType := reflect.TypeOf((*pb.SequencedMessage)(nil))
+	if got := Read(s, testSubscription).Type().Type(); got != msgType {
+		t.Errorf("Read() output type = %v, want %v", got, msgType)
+	}
+
+	for _, f := range []func(){
+		func() { Read(s, "projects/p/subscriptions/s") },
+		func() { ReadCheckpointInterval(0) },
+		func() { ReadFlowControl(0, defaultMaxOutstandingBytes) },
+		func() { ReadFlowControl(1, 1024) },
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Error("invalid Read succeeded, want panic")
+				}
+			}()
+			f()
+		}()
+	}
+}
+
+func TestRegion(t *testing.T) {
+	tests := map[string]string{
+		"us-central1-a": "us-central1",
+		"us-central1":   "us-central1",
+	}
+	for location, want := range tests {
+		if got := region(location); got != want {
+			t.Errorf("region(%q) = %q, want %q", location, got, want)
+		}
+	}
+}
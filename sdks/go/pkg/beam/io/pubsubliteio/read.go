@@ -0,0 +1,387 @@
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements.  See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License.  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pubsubliteio
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"time"
+
+	"github.com/apache/beam/sdks/v2/go/pkg/beam"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/core/graph/mtime"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/core/sdf"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/internal/errors"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/io/rtrackers/offsetrange"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/io/rtrackers/unbounded"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/log"
+	pb "google.golang.org/genproto/googleapis/cloud/pubsublite/v1"
+	"google.golang.org/grpc"
+)
+
+const (
+	// defaultCheckpointInterval is how long a partition is read before
+	// checkpointing, unless another is set with ReadCheckpointInterval.
+	defaultCheckpointInterval = 10 * time.Second
+	// defaultMaxOutstandingMessages and defaultMaxOutstandingBytes are the
+	// flow control limits of a partition, unless others are set with
+	// ReadFlowControl.
+	defaultMaxOutstandingMessages = 1000
+	defaultMaxOutstandingBytes    = 10 << 20
+	// minOutstandingBytes is the minimum flow control limit of bytes, which
+	// is the maximum size of a message.
+	minOutstandingBytes = 3500000
+
+	// retryInterval is how long reading a partition waits after a retryable
+	// error.
+	retryInterval = time.Second
+	// finalizationTimeout is how long the cursor of a bundle waits for the
+	// bundle to be finalized before it's dropped.
+	finalizationTimeout = 10 * time.Minute
+)
+
+func init() {
+	beam.RegisterType(reflect.TypeOf((*partition)(nil)).Elem())
+	beam.RegisterType(reflect.TypeOf((*partitionFn)(nil)).Elem())
+	beam.RegisterType(reflect.TypeOf((*readFn)(nil)).Elem())
+}
+
+// readOption holds the options of Read.
+type readOption struct {
+	CheckpointInterval     time.Duration
+	MaxOutstandingMessages int64
+	MaxOutstandingBytes    int64
+}
+
+// ReadOptionFn is an option for Read.
+type ReadOptionFn func(*readOption)
+
+// ReadCheckpointInterval sets how long a partition is read before
+// checkpointing, after which the cursor of the partition is committed. It
+// bounds the latency of commits and of the watermark of caught up
+// partitions. The default is 10s.
+func ReadCheckpointInterval(d time.Duration) ReadOptionFn {
+	if d <= 0 {
+		panic(fmt.Sprintf("pubsubliteio.ReadCheckpointInterval interval must be positive. Got: %v", d))
+	}
+	return func(o *readOption) {
+		o.CheckpointInterval = d
+	}
+}
+
+// ReadFlowControl sets the maximum number of messages, and bytes, that are
+// outstanding for a partition being read. The bytes must be at least the
+// maximum size of a message, 3.5 MB. The defaults are 1000 messages and
+// 10 MiB.
+func ReadFlowControl(messages, bytes int64) ReadOptionFn {
+	if messages < 1 {
+		panic(fmt.Sprintf("pubsubliteio.ReadFlowControl messages must be positive. Got: %v", messages))
+	}
+	if bytes < minOutstandingBytes {
+		panic(fmt.Sprintf("pubsubliteio.ReadFlowControl bytes must be at least %v. Got: %v", minOutstandingBytes, bytes))
+	}
+	return func(o *readOption) {
+		o.MaxOutstandingMessages = messages
+		o.MaxOutstandingBytes = bytes
+	}
+}
+
+// Read reads the messages of the given subscription, and returns an
+// unbounded PCollection<*pb.SequencedMessage>, with each message
+// timestamped at its publish time. For example:
+//
+//	msgs := pubsubliteio.Read(s, "projects/p/locations/us-central1-a/subscriptions/s")
+//
+// Each partition of the topic of the subscription is read from its
+// committed cursor, or from its start if it has none, and its cursor is
+// committed once the bundle of its messages is finalized. Partitions added
+// to the topic after the pipeline starts aren't read.
+//
+// The watermark of a partition is the publish time of its last message, or
+// the time its head cursor was computed, at each checkpoint, once all
+// messages published before then have been read, so that the watermark of
+// idle partitions advances.
+func Read(s beam.Scope, subscription string, opts ...ReadOptionFn) beam.PCollection {
+	s = s.Scope("pubsubliteio.Read")
+	mustParseSubscription(subscription)
+
+	o := readOption{
+		CheckpointInterval:     defaultCheckpointInterval,
+		MaxOutstandingMessages: defaultMaxOutstandingMessages,
+		MaxOutstandingBytes:    defaultMaxOutstandingBytes,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	parts := beam.ParDo(s, &partitionFn{Subscription: subscription}, beam.Impulse(s))
+	return beam.ParDo(s, &readFn{
+		Subscription:           subscription,
+		CheckpointInterval:     o.CheckpointInterval,
+		MaxOutstandingMessages: o.MaxOutstandingMessages,
+		MaxOutstandingBytes:    o.MaxOutstandingBytes,
+	}, beam.Reshuffle(s, parts))
+}
+
+// partition is a partition of the topic of a subscription, to be read from
+// the offset.
+type partition struct {
+	Partition int64
+	Offset    int64
+}
+
+// partitionFn outputs the partitions of the topic of a subscription, with
+// the committed cursors of the subscription.
+type partitionFn struct {
+	// Subscription is the name of the subscription.
+	Subscription string `json:"subscription"`
+
+	conn *grpc.ClientConn
+}
+
+func (f *partitionFn) Setup(ctx context.Context) error {
+	conn, err := dial(ctx, mustParseSubscription(f.Subscription))
+	if err != nil {
+		return err
+	}
+	f.conn = conn
+	return nil
+}
+
+func (f *partitionFn) Teardown() error {
+	if f.conn == nil {
+		return nil
+	}
+	return f.conn.Close()
+}
+
+func (f *partitionFn) ProcessElement(ctx context.Context, _ []byte, emit func(partition)) error {
+	admin := pb.NewAdminServiceClient(f.conn)
+	sub, err := admin.GetSubscription(ctx, &pb.GetSubscriptionRequest{Name: f.Subscription})
+	if err != nil {
+		return errors.Wrapf(err, "getting subscription %v", f.Subscription)
+	}
+	parts, err := admin.GetTopicPartitions(ctx, &pb.GetTopicPartitionsRequest{Name: sub.GetTopic()})
+	if err != nil {
+		return errors.Wrapf(err, "getting partitions of %v", sub.GetTopic())
+	}
+
+	offsets := make(map[int64]int64)
+	cursors := pb.NewCursorServiceClient(f.conn)
+	req := &pb.ListPartitionCursorsRequest{Parent: f.Subscription}
+	for {
+		resp, err := cursors.ListPartitionCursors(ctx, req)
+		if err != nil {
+			return errors.Wrapf(err, "listing cursors of %v", f.Subscription)
+		}
+		for _, c := range resp.GetPartitionCursors() {
+			offsets[c.GetPartition()] = c.GetCursor().GetOffset()
+		}
+		if resp.GetNextPageToken() == "" {
+			break
+		}
+		req.PageToken = resp.GetNextPageToken()
+	}
+
+	for p := int64(0); p < parts.GetPartitionCount(); p++ {
+		emit(partition{Partition: p, Offset: offsets[p]})
+	}
+	return nil
+}
+
+// readFn reads a partition of a subscription. Its restriction is the range
+// of offsets left to read, which it claims as it outputs their messages.
+type readFn struct {
+	// Subscription is the name of the subscription.
+	Subscription string `json:"subscription"`
+	// CheckpointInterval is how long to read before checkpointing.
+	CheckpointInterval time.Duration `json:"checkpoint_interval"`
+	// MaxOutstandingMessages and MaxOutstandingBytes are the flow control
+	// limits.
+	MaxOutstandingMessages int64 `json:"max_outstanding_messages"`
+	MaxOutstandingBytes    int64 `json:"max_outstanding_bytes"`
+
+	conn  *grpc.ClientConn
+	topic string
+}
+
+func (f *readFn) Setup(ctx context.Context) error {
+	conn, err := dial(ctx, mustParseSubscription(f.Subscription))
+	if err != nil {
+		return err
+	}
+	f.conn = conn
+
+	sub, err := pb.NewAdminServiceClient(conn).GetSubscription(ctx, &pb.GetSubscriptionRequest{Name: f.Subscription})
+	if err != nil {
+		return errors.Wrapf(err, "getting subscription %v", f.Subscription)
+	}
+	f.topic = sub.GetTopic()
+	return nil
+}
+
+func (f *readFn) Teardown() error {
+	if f.conn == nil {
+		return nil
+	}
+	return f.conn.Close()
+}
+
+func (f *readFn) CreateInitialRestriction(p partition) offsetrange.Restriction {
+	return offsetrange.Restriction{Start: p.Offset, End: math.MaxInt64}
+}
+
+func (f *readFn) SplitRestriction(_ partition, rest offsetrange.Restriction) []offsetrange.Restriction {
+	return []offsetrange.Restriction{rest}
+}
+
+func (f *readFn) RestrictionSize(_ partition, rest offsetrange.Restriction) float64 {
+	return rest.Size()
+}
+
+func (f *readFn) CreateTracker(rest offsetrange.Restriction) *sdf.LockRTracker {
+	return sdf.NewLockRTracker(unbounded.NewOffsetTracker(rest))
+}
+
+func (f *readFn) InitialWatermarkEstimatorState(et beam.EventTime, _ offsetrange.Restriction, _ partition) int64 {
+	return int64(et)
+}
+
+func (f *readFn) CreateWatermarkEstimator(state int64) *sdf.ManualWatermarkEstimator {
+	return &sdf.ManualWatermarkEstimator{State: mtime.Time(state).ToTime()}
+}
+
+func (f *readFn) WatermarkEstimatorState(e *sdf.ManualWatermarkEstimator) int64 {
+	return int64(mtime.FromTime(e.State))
+}
+
+func (f *readFn) ProcessElement(ctx context.Context, we *sdf.ManualWatermarkEstimator, bf beam.BundleFinalization, rt *sdf.LockRTracker, p partition, emit func(beam.EventTime, *pb.SequencedMessage)) (sdf.ProcessContinuation, error) {
+	rest := rt.GetRestriction().(offsetrange.Restriction)
+
+	// The cursor is committed once the runner has committed the bundle. It
+	// is the offset of the next message to read.
+	next := rest.Start
+	defer func() {
+		if next == rest.Start {
+			return
+		}
+		cursor := next
+		bf.RegisterCallback(finalizationTimeout, func() error {
+			return f.commit(context.Background(), p.Partition, cursor)
+		})
+	}()
+
+	// Once the head offset is reached, all messages published before it was
+	// computed have been read.
+	now := time.Now()
+	head, err := pb.NewTopicStatsServiceClient(f.conn).ComputeHeadCursor(ctx, &pb.ComputeHeadCursorRequest{Topic: f.topic, Partition: p.Partition})
+	if err != nil {
+		return f.retry(ctx, p, err)
+	}
+	caughtUp := func() {
+		if next >= head.GetHeadCursor().GetOffset() {
+			unbounded.AdvanceWatermark(we, now)
+		}
+	}
+	caughtUp()
+
+	sctx, cancel := context.WithTimeout(ctx, f.CheckpointInterval)
+	defer cancel()
+	stream, err := f.subscribe(sctx, p.Partition, rest.Start)
+	if err != nil {
+		if sctx.Err() != nil && ctx.Err() == nil {
+			return sdf.ResumeProcessingIn(0), nil
+		}
+		return f.retry(ctx, p, err)
+	}
+	for {
+		resp, err := stream.Recv()
+		if err != nil {
+			if sctx.Err() != nil && ctx.Err() == nil {
+				return sdf.ResumeProcessingIn(0), nil
+			}
+			return f.retry(ctx, p, err)
+		}
+		msgs := resp.GetMessages().GetMessages()
+		var size int64
+		for _, m := range msgs {
+			offset := m.GetCursor().GetOffset()
+			if !rt.TryClaim(offset) {
+				return sdf.StopProcessing(), nil
+			}
+			next = offset + 1
+			size += m.GetSizeBytes()
+			ts := m.GetPublishTime().AsTime()
+			emit(mtime.FromTime(ts), m)
+			unbounded.AdvanceWatermark(we, ts)
+		}
+		caughtUp()
+
+		// The flow control tokens of the received messages are returned.
+		if len(msgs) > 0 {
+			fc := &pb.FlowControlRequest{AllowedMessages: int64(len(msgs)), AllowedBytes: size}
+			if err := stream.Send(&pb.SubscribeRequest{Request: &pb.SubscribeRequest_FlowControl{FlowControl: fc}}); err != nil && err != io.EOF {
+				return f.retry(ctx, p, err)
+			}
+		}
+	}
+}
+
+// retry resumes reading the partition after the error, if it's retryable,
+// or else fails.
+func (f *readFn) retry(ctx context.Context, p partition, err error) (sdf.ProcessContinuation, error) {
+	if isRetryable(err) {
+		log.Warnf(ctx, "Retrying partition %v of %v: %v", p.Partition, f.Subscription, err)
+		return sdf.ResumeProcessingIn(retryInterval), nil
+	}
+	return sdf.StopProcessing(), errors.Wrapf(err, "reading partition %v of %v", p.Partition, f.Subscription)
+}
+
+// subscribe opens a stream of the messages of the partition from the
+// offset. Its errors are returned unwrapped, so that they can be retried.
+func (f *readFn) subscribe(ctx context.Context, partition, offset int64) (pb.SubscriberService_SubscribeClient, error) {
+	stream, err := pb.NewSubscriberServiceClient(f.conn).Subscribe(ctx)
+	if err != nil {
+		return nil, err
+	}
+	// Send returns io.EOF if the stream failed, whose error Recv returns.
+	initial := &pb.InitialSubscribeRequest{
+		Subscription:    f.Subscription,
+		Partition:       partition,
+		InitialLocation: &pb.SeekRequest{Target: &pb.SeekRequest_Cursor{Cursor: &pb.Cursor{Offset: offset}}},
+	}
+	if err := stream.Send(&pb.SubscribeRequest{Request: &pb.SubscribeRequest_Initial{Initial: initial}}); err != nil && err != io.EOF {
+		return nil, err
+	}
+	if _, err := stream.Recv(); err != nil {
+		return nil, err
+	}
+	fc := &pb.FlowControlRequest{AllowedMessages: f.MaxOutstandingMessages, AllowedBytes: f.MaxOutstandingBytes}
+	if err := stream.Send(&pb.SubscribeRequest{Request: &pb.SubscribeRequest_FlowControl{FlowControl: fc}}); err != nil && err != io.EOF {
+		return nil, err
+	}
+	return stream, nil
+}
+
+// commit commits the cursor of the partition.
+func (f *readFn) commit(ctx context.Context, partition, offset int64) error {
+	req := &pb.CommitCursorRequest{Subscription: f.Subscription, Partition: partition, Cursor: &pb.Cursor{Offset: offset}}
+	if _, err := pb.NewCursorServiceClient(f.conn).CommitCursor(ctx, req); err != nil {
+		return errors.Wrapf(err, "committing cursor %v of partition %v of %v", offset, partition, f.Subscription)
+	}
+	return nil
+}
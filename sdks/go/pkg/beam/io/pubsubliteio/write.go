@@ -0,0 +1,217 @@
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements.  See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License.  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pubsubliteio
+
+import (
+	"context"
+	"crypto/sha256"
+	"io"
+	"math/big"
+	"math/rand"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/apache/beam/sdks/v2/go/pkg/beam"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/core/util/reflectx"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/internal/errors"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/log"
+	pb "google.golang.org/genproto/googleapis/cloud/pubsublite/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	// maxPublishMessages and maxPublishBytes limit the messages of each
+	// publish request.
+	maxPublishMessages = 1000
+	maxPublishBytes    = 3500000
+	// publishRetries is the number of times publishing to a partition is
+	// retried after a retryable error, with backoff from
+	// initialPublishBackoff up to maxPublishBackoff.
+	publishRetries        = 5
+	initialPublishBackoff = 100 * time.Millisecond
+	maxPublishBackoff     = 10 * time.Second
+)
+
+func init() {
+	beam.RegisterType(reflect.TypeOf((*writeFn)(nil)).Elem())
+	beam.RegisterFunction(dataMessageFn)
+}
+
+// Write publishes the messages of the given PCollection<*pb.PubSubMessage>,
+// or PCollection<[]byte>, to the given topic. For example:
+//
+//	pubsubliteio.Write(s, "projects/p/locations/us-central1-a/topics/t", msgs)
+//
+// Messages with a key are published to the partition of the key, which is
+// the SHA-256 hash of the key modulo the number of partitions, as the Pub/Sub
+// Lite client libraries do, so that messages with the same key are read in
+// order. Messages without a key are distributed round robin. The messages of
+// a bundle are published in order.
+func Write(s beam.Scope, topic string, col beam.PCollection) {
+	s = s.Scope("pubsubliteio.Write")
+	mustParseTopic(topic)
+
+	msgs := col
+	if col.Type().Type() == reflectx.ByteSlice {
+		msgs = beam.ParDo(s, dataMessageFn, col)
+	}
+	beam.ParDo0(s, &writeFn{Topic: topic}, msgs)
+}
+
+func dataMessageFn(data []byte) *pb.PubSubMessage {
+	return &pb.PubSubMessage{Data: data}
+}
+
+// writeFn publishes messages to the partitions of a topic, in order.
+type writeFn struct {
+	// Topic is the name of the topic.
+	Topic string `json:"topic"`
+
+	conn       *grpc.ClientConn
+	client     pb.PublisherServiceClient
+	partitions int64
+	next       int64                         // Partition of the next message without a key.
+	pending    map[int64][]*pb.PubSubMessage // By partition.
+}
+
+func (f *writeFn) Setup(ctx context.Context) error {
+	conn, err := dial(ctx, mustParseTopic(f.Topic))
+	if err != nil {
+		return err
+	}
+	f.conn = conn
+	f.client = pb.NewPublisherServiceClient(conn)
+
+	parts, err := pb.NewAdminServiceClient(conn).GetTopicPartitions(ctx, &pb.GetTopicPartitionsRequest{Name: f.Topic})
+	if err != nil {
+		return errors.Wrapf(err, "getting partitions of %v", f.Topic)
+	}
+	if parts.GetPartitionCount() < 1 {
+		return errors.Errorf("topic %v has no partitions", f.Topic)
+	}
+	f.partitions = parts.GetPartitionCount()
+	f.next = rand.Int63n(f.partitions)
+	return nil
+}
+
+func (f *writeFn) StartBundle(ctx context.Context) {
+	f.pending = make(map[int64][]*pb.PubSubMessage)
+}
+
+func (f *writeFn) ProcessElement(ctx context.Context, m *pb.PubSubMessage) {
+	var p int64
+	if len(m.GetKey()) > 0 {
+		p = partitionOf(m.GetKey(), f.partitions)
+	} else {
+		p = f.next
+		f.next = (f.next + 1) % f.partitions
+	}
+	f.pending[p] = append(f.pending[p], m)
+}
+
+func (f *writeFn) FinishBundle(ctx context.Context) error {
+	parts := make([]int64, 0, len(f.pending))
+	for p := range f.pending {
+		parts = append(parts, p)
+	}
+	sort.Slice(parts, func(i, j int) bool { return parts[i] < parts[j] })
+	for _, p := range parts {
+		if err := f.publish(ctx, p, f.pending[p]); err != nil {
+			return err
+		}
+	}
+	f.pending = nil
+	return nil
+}
+
+func (f *writeFn) Teardown() error {
+	if f.conn == nil {
+		return nil
+	}
+	return f.conn.Close()
+}
+
+// partitionOf returns the partition of the key.
+func partitionOf(key []byte, partitions int64) int64 {
+	h := sha256.Sum256(key)
+	n := new(big.Int).SetBytes(h[:])
+	return n.Mod(n, big.NewInt(partitions)).Int64()
+}
+
+// publish publishes the messages to the partition, in batches. If
+// publishing fails with a retryable error, it's retried with backoff from
+// the first batch that wasn't acknowledged.
+func (f *writeFn) publish(ctx context.Context, partition int64, msgs []*pb.PubSubMessage) error {
+	backoff := initialPublishBackoff
+	for attempt := 0; ; attempt++ {
+		n, err := f.publishBatches(ctx, partition, msgs)
+		msgs = msgs[n:]
+		if err == nil {
+			return nil
+		}
+		if attempt >= publishRetries || !isRetryable(err) {
+			return errors.Wrapf(err, "publishing %v messages to partition %v of %v", len(msgs), partition, f.Topic)
+		}
+		log.Warnf(ctx, "Retrying publish of %v messages to partition %v of %v in %v: %v", len(msgs), partition, f.Topic, backoff, err)
+		time.Sleep(backoff)
+		if backoff *= 2; backoff > maxPublishBackoff {
+			backoff = maxPublishBackoff
+		}
+	}
+}
+
+// publishBatches publishes the messages to the partition on a stream, a
+// batch at a time, and returns the number of messages published.
+func (f *writeFn) publishBatches(ctx context.Context, partition int64, msgs []*pb.PubSubMessage) (int, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stream, err := f.client.Publish(ctx)
+	if err != nil {
+		return 0, err
+	}
+	// Send returns io.EOF if the stream failed, whose error Recv returns.
+	initial := &pb.InitialPublishRequest{Topic: f.Topic, Partition: partition}
+	if err := stream.Send(&pb.PublishRequest{RequestType: &pb.PublishRequest_InitialRequest{InitialRequest: initial}}); err != nil && err != io.EOF {
+		return 0, err
+	}
+	if _, err := stream.Recv(); err != nil {
+		return 0, err
+	}
+
+	published := 0
+	for published < len(msgs) {
+		n, size := 0, 0
+		for _, m := range msgs[published:] {
+			s := proto.Size(m)
+			if n > 0 && (n >= maxPublishMessages || size+s > maxPublishBytes) {
+				break
+			}
+			n++
+			size += s
+		}
+		batch := &pb.MessagePublishRequest{Messages: msgs[published : published+n]}
+		if err := stream.Send(&pb.PublishRequest{RequestType: &pb.PublishRequest_MessagePublishRequest{MessagePublishRequest: batch}}); err != nil && err != io.EOF {
+			return published, err
+		}
+		if _, err := stream.Recv(); err != nil {
+			return published, err
+		}
+		published += n
+	}
+	return published, stream.CloseSend()
+}
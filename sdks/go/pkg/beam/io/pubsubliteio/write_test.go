@@ -0,0 +1,190 @@
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements.  See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License.  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pubsubliteio
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"strconv"
+	"testing"
+
+	"github.com/apache/beam/sdks/v2/go/pkg/beam"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/testing/ptest"
+	pb "google.golang.org/genproto/googleapis/cloud/pubsublite/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Publish appends the published messages to the partition, and fails
+// streams with Unavailable after their first batch while unavailable is
+// positive.
+func (f *fakeServer) Publish(stream pb.PublisherService_PublishServer) error {
+	req, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	partition := req.GetInitialRequest().GetPartition()
+	if err := stream.Send(&pb.PublishResponse{ResponseType: &pb.PublishResponse_InitialResponse{
+		InitialResponse: &pb.InitialPublishResponse{},
+	}}); err != nil {
+		return err
+	}
+	for batches := 0; ; batches++ {
+		req, err := stream.Recv()
+		if err != nil {
+			return nil
+		}
+		f.mu.Lock()
+		if batches > 0 && f.unavailable > 0 {
+			f.unavailable--
+			f.mu.Unlock()
+			return status.Error(codes.Unavailable, "unavailable")
+		}
+		offset := int64(len(f.partitions[partition]))
+		for _, m := range req.GetMessagePublishRequest().GetMessages() {
+			f.append(partition, m)
+		}
+		f.mu.Unlock()
+		if err := stream.Send(&pb.PublishResponse{ResponseType: &pb.PublishResponse_MessageResponse{
+			MessageResponse: &pb.MessagePublishResponse{StartCursor: &pb.Cursor{Offset: offset}},
+		}}); err != nil {
+			return err
+		}
+	}
+}
+
+// data returns the data of the messages of the partition.
+func (f *fakeServer) data(partition int64) []string {
+	var data []string
+	for _, m := range f.partitions[partition] {
+		data = append(data, string(m.GetMessage().GetData()))
+	}
+	return data
+}
+
+func TestWrite(t *testing.T) {
+	fake := startFakeServer(t, 3)
+
+	p, s := beam.NewPipelineWithRoot()
+	msgs := beam.CreateList(s, []*pb.PubSubMessage{
+		{Key: []byte("k1"), Data: []byte("k1-1")},
+		{Key: []byte("k2"), Data: []byte("k2-1")},
+		{Key: []byte("k1"), Data: []byte("k1-2")},
+		{Key: []byte("k2"), Data: []byte("k2-2")},
+		{Data: []byte("a")},
+		{Data: []byte("b")},
+		{Data: []byte("c")},
+	})
+	Write(s, testTopic, msgs)
+	ptest.RunAndValidate(t, p)
+
+	// Messages with a key are published in order to its partition.
+	keyed := map[int64][]string{}
+	for _, k := range []string{"k1", "k2"} {
+		p := partitionOf([]byte(k), 3)
+		keyed[p] = append(keyed[p], k+"-1", k+"-2")
+	}
+	var all []string
+	for p := int64(0); p < 3; p++ {
+		var got []string
+		for _, d := range fake.data(p) {
+			all = append(all, d)
+			if len(d) > 1 {
+				got = append(got, d)
+			}
+		}
+		sort.Strings(got)
+		if want := keyed[p]; !reflect.DeepEqual(got, want) {
+			t.Errorf("Write() published %v with keys to partition %v, want %v", got, p, want)
+		}
+		// Messages without a key are distributed round robin.
+		if n := len(fake.data(p)) - len(got); n != 1 {
+			t.Errorf("Write() published %v messages without a key to partition %v, want 1", n, p)
+		}
+	}
+	if len(all) != 7 {
+		t.Errorf("Write() published %v messages, want 7", len(all))
+	}
+}
+
+func TestWrite_Bytes(t *testing.T) {
+	fake := startFakeServer(t, 1)
+
+	p, s := beam.NewPipelineWithRoot()
+	Write(s, testTopic, beam.Create(s, []byte("a"), []byte("b")))
+	ptest.RunAndValidate(t, p)
+
+	got := fake.data(0)
+	sort.Strings(got)
+	if want := []string{"a", "b"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Write() published %v, want %v", got, want)
+	}
+}
+
+func TestWriteFn_Retry(t *testing.T) {
+	fake := startFakeServer(t, 1)
+	fake.unavailable = 1
+
+	fn := &writeFn{Topic: testTopic}
+	ctx := context.Background()
+	if err := fn.Setup(ctx); err != nil {
+		t.Fatalf("Setup() failed: %v", err)
+	}
+	defer fn.Teardown()
+	var msgs []*pb.PubSubMessage
+	var want []string
+	for i := 0; i < maxPublishMessages+500; i++ {
+		d := strconv.Itoa(i)
+		msgs = append(msgs, &pb.PubSubMessage{Data: []byte(d)})
+		want = append(want, d)
+	}
+	if err := fn.publish(ctx, 0, msgs); err != nil {
+		t.Fatalf("publish() failed: %v", err)
+	}
+	// The first batch is published once, and the second retried.
+	if got := fake.data(0); !reflect.DeepEqual(got, want) {
+		t.Errorf("publish() published %v messages, want %v in order", len(got), len(want))
+	}
+}
+
+func TestPartitionOf(t *testing.T) {
+	seen := make(map[int64]bool)
+	for i := 0; i < 100; i++ {
+		key := []byte(strconv.Itoa(i))
+		p := partitionOf(key, 4)
+		if p < 0 || p >= 4 {
+			t.Fatalf("partitionOf(%q, 4) = %v, want a partition", key, p)
+		}
+		if again := partitionOf(key, 4); again != p {
+			t.Errorf("partitionOf(%q, 4) = %v, then %v", key, p, again)
+		}
+		seen[p] = true
+	}
+	if len(seen) != 4 {
+		t.Errorf("partitionOf() of 100 keys returned partitions %v, want all 4", seen)
+	}
+}
+
+func TestWrite_InvalidTopic(t *testing.T) {
+	_, s := beam.NewPipelineWithRoot()
+	defer func() {
+		if recover() == nil {
+			t.Error("Write() to an invalid topic succeeded, want panic")
+		}
+	}()
+	Write(s, "projects/p/topics/t", beam.Create(s, []byte("a")))
+}
@@ -0,0 +1,288 @@
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements.  See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License.  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package redisio
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+
+	"github.com/apache/beam/sdks/v2/go/pkg/beam"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/internal/errors"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/log"
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	// defaultScanCount is the number of keys each scan request hints at,
+	// unless another is set with ReadScanCount.
+	defaultScanCount = 1000
+	// defaultReadBatchSize is the number of keys each reader reads at a
+	// time, unless another is set with ReadBatchSize.
+	defaultReadBatchSize = 100
+)
+
+func init() {
+	beam.RegisterType(reflect.TypeOf((*scanFn)(nil)).Elem())
+	beam.RegisterType(reflect.TypeOf((*readStringsFn)(nil)).Elem())
+	beam.RegisterType(reflect.TypeOf((*readHashesFn)(nil)).Elem())
+	beam.RegisterType(reflect.TypeOf((*readSetsFn)(nil)).Elem())
+}
+
+// readOption holds the options of Read, ReadHashes and ReadSets.
+type readOption struct {
+	ScanCount int
+	BatchSize int
+}
+
+// ReadOptionFn is an option for Read, ReadHashes and ReadSets.
+type ReadOptionFn func(*readOption)
+
+// ReadScanCount sets the number of keys each SCAN request hints at. The
+// default is 1000.
+func ReadScanCount(n int) ReadOptionFn {
+	if n < 1 {
+		panic(fmt.Sprintf("redisio.ReadScanCount count must be positive. Got: %v", n))
+	}
+	return func(o *readOption) {
+		o.ScanCount = n
+	}
+}
+
+// ReadBatchSize sets the number of keys whose values are read at a time, in
+// parallel with other batches. The default is 100.
+func ReadBatchSize(n int) ReadOptionFn {
+	if n < 1 {
+		panic(fmt.Sprintf("redisio.ReadBatchSize size must be positive. Got: %v", n))
+	}
+	return func(o *readOption) {
+		o.BatchSize = n
+	}
+}
+
+// Read reads the string keys matching the given glob-style pattern, and
+// their values, from the Redis server at the URL, and returns a
+// PCollection<KeyValue>. For example:
+//
+//	kvs := redisio.Read(s, "redis://localhost:6379", "session:*")
+//
+// Keys are scanned with SCAN, in batches that are read in parallel with
+// MGET. Keys that are deleted while they're read are skipped.
+func Read(s beam.Scope, url, pattern string, opts ...ReadOptionFn) beam.PCollection {
+	s = s.Scope("redisio.Read")
+	keys := scan(s, url, pattern, "string", opts)
+	return beam.ParDo(s, &readStringsFn{URL: url}, keys)
+}
+
+// ReadHashes reads the hash keys matching the given glob-style pattern, and
+// their fields, from the Redis server at the URL, and returns a
+// PCollection<Hash>. Keys are scanned like Read, and their fields are read
+// with HGETALL.
+func ReadHashes(s beam.Scope, url, pattern string, opts ...ReadOptionFn) beam.PCollection {
+	s = s.Scope("redisio.ReadHashes")
+	keys := scan(s, url, pattern, "hash", opts)
+	return beam.ParDo(s, &readHashesFn{URL: url}, keys)
+}
+
+// ReadSets reads the set keys matching the given glob-style pattern, and
+// their members, from the Redis server at the URL, and returns a
+// PCollection<Set>. Keys are scanned like Read, and their members are read
+// with SMEMBERS.
+func ReadSets(s beam.Scope, url, pattern string, opts ...ReadOptionFn) beam.PCollection {
+	s = s.Scope("redisio.ReadSets")
+	keys := scan(s, url, pattern, "set", opts)
+	return beam.ParDo(s, &readSetsFn{URL: url}, keys)
+}
+
+// scan returns the batches of keys of the type matching the pattern, as a
+// PCollection<[]string>, shuffled so that they're read in parallel.
+func scan(s beam.Scope, url, pattern, keyType string, opts []ReadOptionFn) beam.PCollection {
+	if pattern == "" {
+		panic("redisio: reads require a key pattern")
+	}
+	o := readOption{
+		ScanCount: defaultScanCount,
+		BatchSize: defaultReadBatchSize,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	imp := beam.Impulse(s)
+	keys := beam.ParDo(s, &scanFn{URL: url, Pattern: pattern, Type: keyType, Options: o}, imp)
+	return beam.Reshuffle(s, keys)
+}
+
+// scanFn scans the keys of a type matching a pattern, and outputs them in
+// batches.
+type scanFn struct {
+	// URL is the URL of the Redis server.
+	URL string `json:"url"`
+	// Pattern is the glob-style pattern of the keys.
+	Pattern string `json:"pattern"`
+	// Type is the type of the keys, such as "hash".
+	Type string `json:"type"`
+	// Options specifies the scan count and batch size.
+	Options readOption `json:"options"`
+}
+
+func (f *scanFn) ProcessElement(ctx context.Context, _ []byte, emit func([]string)) error {
+	client, err := connect(ctx, f.URL)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	// SCAN may return a key more than once, so keys are deduplicated.
+	seen := make(map[string]bool)
+	var batch []string
+	var cursor uint64
+	for {
+		keys, next, err := client.ScanType(ctx, cursor, f.Pattern, int64(f.Options.ScanCount), f.Type).Result()
+		if err != nil {
+			return errors.Wrapf(err, "scanning keys matching %v", f.Pattern)
+		}
+		for _, key := range keys {
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			if batch = append(batch, key); len(batch) == f.Options.BatchSize {
+				emit(batch)
+				batch = nil
+			}
+		}
+		if cursor = next; cursor == 0 {
+			break
+		}
+	}
+	if len(batch) > 0 {
+		emit(batch)
+	}
+	log.Infof(ctx, "Scanned %v keys matching %v", len(seen), f.Pattern)
+	return nil
+}
+
+// readStringsFn reads the values of batches of string keys.
+type readStringsFn struct {
+	// URL is the URL of the Redis server.
+	URL string `json:"url"`
+
+	client *redis.Client
+}
+
+func (f *readStringsFn) Setup(ctx context.Context) (err error) {
+	f.client, err = connect(ctx, f.URL)
+	return err
+}
+
+func (f *readStringsFn) Teardown() error {
+	if f.client == nil {
+		return nil
+	}
+	return f.client.Close()
+}
+
+func (f *readStringsFn) ProcessElement(ctx context.Context, keys []string, emit func(KeyValue)) error {
+	values, err := f.client.MGet(ctx, keys...).Result()
+	if err != nil {
+		return errors.Wrapf(err, "reading %v keys", len(keys))
+	}
+	for i, v := range values {
+		// Deleted keys have no value.
+		if v, ok := v.(string); ok {
+			emit(KeyValue{Key: keys[i], Value: v})
+		}
+	}
+	return nil
+}
+
+// readHashesFn reads the fields of batches of hash keys.
+type readHashesFn struct {
+	// URL is the URL of the Redis server.
+	URL string `json:"url"`
+
+	client *redis.Client
+}
+
+func (f *readHashesFn) Setup(ctx context.Context) (err error) {
+	f.client, err = connect(ctx, f.URL)
+	return err
+}
+
+func (f *readHashesFn) Teardown() error {
+	if f.client == nil {
+		return nil
+	}
+	return f.client.Close()
+}
+
+func (f *readHashesFn) ProcessElement(ctx context.Context, keys []string, emit func(Hash)) error {
+	cmds := make([]*redis.StringStringMapCmd, len(keys))
+	if _, err := f.client.Pipelined(ctx, func(p redis.Pipeliner) error {
+		for i, key := range keys {
+			cmds[i] = p.HGetAll(ctx, key)
+		}
+		return nil
+	}); err != nil {
+		return errors.Wrapf(err, "reading %v hashes", len(keys))
+	}
+	for i, cmd := range cmds {
+		// Deleted keys have no fields.
+		if fields := cmd.Val(); len(fields) > 0 {
+			emit(Hash{Key: keys[i], Fields: fields})
+		}
+	}
+	return nil
+}
+
+// readSetsFn reads the members of batches of set keys.
+type readSetsFn struct {
+	// URL is the URL of the Redis server.
+	URL string `json:"url"`
+
+	client *redis.Client
+}
+
+func (f *readSetsFn) Setup(ctx context.Context) (err error) {
+	f.client, err = connect(ctx, f.URL)
+	return err
+}
+
+func (f *readSetsFn) Teardown() error {
+	if f.client == nil {
+		return nil
+	}
+	return f.client.Close()
+}
+
+func (f *readSetsFn) ProcessElement(ctx context.Context, keys []string, emit func(Set)) error {
+	cmds := make([]*redis.StringSliceCmd, len(keys))
+	if _, err := f.client.Pipelined(ctx, func(p redis.Pipeliner) error {
+		for i, key := range keys {
+			cmds[i] = p.SMembers(ctx, key)
+		}
+		return nil
+	}); err != nil {
+		return errors.Wrapf(err, "reading %v sets", len(keys))
+	}
+	for i, cmd := range cmds {
+		// Deleted keys have no members.
+		if members := cmd.Val(); len(members) > 0 {
+			emit(Set{Key: keys[i], Members: members})
+		}
+	}
+	return nil
+}
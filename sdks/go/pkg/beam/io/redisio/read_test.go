@@ -0,0 +1,113 @@
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements.  See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License.  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package redisio
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/apache/beam/sdks/v2/go/pkg/beam"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/testing/passert"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/testing/ptest"
+)
+
+func TestScanFn(t *testing.T) {
+	srv, url := startServer(t)
+	for _, key := range []string{"session:1", "session:2", "session:3", "user:1"} {
+		srv.Set(key, "v")
+	}
+	srv.HSet("session:h", "f", "v")
+
+	fn := &scanFn{URL: url, Pattern: "session:*", Type: "string", Options: readOption{ScanCount: 1, BatchSize: 2}}
+	var got [][]string
+	if err := fn.ProcessElement(context.Background(), nil, func(keys []string) { got = append(got, keys) }); err != nil {
+		t.Fatalf("ProcessElement() failed: %v", err)
+	}
+	// The matching keys of the type are output in batches of the batch size.
+	var sizes []int
+	var keys []string
+	for _, batch := range got {
+		sizes = append(sizes, len(batch))
+		keys = append(keys, batch...)
+	}
+	sort.Strings(keys)
+	if want := []int{2, 1}; !reflect.DeepEqual(sizes, want) {
+		t.Errorf("ProcessElement() output batches of %v, want %v", sizes, want)
+	}
+	if want := []string{"session:1", "session:2", "session:3"}; !reflect.DeepEqual(keys, want) {
+		t.Errorf("ProcessElement() output %v, want %v", keys, want)
+	}
+}
+
+func TestReadStringsFn(t *testing.T) {
+	srv, url := startServer(t)
+	srv.Set("a", "1")
+	srv.Set("b", "2")
+
+	fn := &readStringsFn{URL: url}
+	if err := fn.Setup(context.Background()); err != nil {
+		t.Fatalf("Setup() failed: %v", err)
+	}
+	defer fn.Teardown()
+	var got []KeyValue
+	// Keys that were deleted are skipped.
+	if err := fn.ProcessElement(context.Background(), []string{"a", "deleted", "b"}, func(kv KeyValue) { got = append(got, kv) }); err != nil {
+		t.Fatalf("ProcessElement() failed: %v", err)
+	}
+	if want := []KeyValue{{"a", "1"}, {"b", "2"}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ProcessElement() output %v, want %v", got, want)
+	}
+}
+
+func TestRead(t *testing.T) {
+	srv, url := startServer(t)
+	srv.Set("k:1", "a")
+	srv.Set("k:2", "b")
+	srv.HSet("h:1", "name", "a")
+	srv.HSet("h:1", "city", "b")
+	srv.SAdd("s:1", "x", "y")
+
+	p, s := beam.NewPipelineWithRoot()
+	passert.Equals(s, Read(s, url, "k:*", ReadBatchSize(1)), KeyValue{"k:1", "a"}, KeyValue{"k:2", "b"})
+	hashes := ReadHashes(s, url, "*")
+	passert.Equals(s, beam.ParDo(s, func(h Hash) string { return h.Key + "=" + h.Fields["name"] + "," + h.Fields["city"] }, hashes), "h:1=a,b")
+	sets := ReadSets(s, url, "*")
+	passert.Equals(s, beam.ParDo(s, func(set Set) int { return len(set.Members) }, sets), 2)
+	ptest.RunAndValidate(t, p)
+}
+
+func TestRead_Options(t *testing.T) {
+	tests := []struct {
+		name string
+		fn   func()
+	}{
+		{"no pattern", func() { Read(beam.NewPipeline().Root(), "redis://localhost:6379", "") }},
+		{"zero scan count", func() { ReadScanCount(0) }},
+		{"zero batch size", func() { ReadBatchSize(0) }},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%v succeeded, want panic", test.name)
+				}
+			}()
+			test.fn()
+		})
+	}
+}
@@ -0,0 +1,94 @@
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements.  See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License.  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package redisio contains transforms to read from and write to Redis
+// (https://redis.io).
+//
+// The transforms are given the URL of a Redis server, such as
+// "redis://:password@localhost:6379/0", or "rediss://" for TLS. Reading
+// streams is implemented with a splittable DoFn, so it runs on portable
+// runners that support unbounded splittable DoFns and bundle finalization.
+// Experimental.
+package redisio
+
+import (
+	"context"
+	"reflect"
+
+	"github.com/apache/beam/sdks/v2/go/pkg/beam"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/internal/errors"
+	"github.com/go-redis/redis/v8"
+)
+
+func init() {
+	beam.RegisterType(reflect.TypeOf((*KeyValue)(nil)).Elem())
+	beam.RegisterType(reflect.TypeOf((*Hash)(nil)).Elem())
+	beam.RegisterType(reflect.TypeOf((*Set)(nil)).Elem())
+	beam.RegisterType(reflect.TypeOf((*SortedSetMember)(nil)).Elem())
+	beam.RegisterType(reflect.TypeOf((*StreamMessage)(nil)).Elem())
+}
+
+// KeyValue is a string key and its value, as read by Read and written with
+// SET by Write.
+type KeyValue struct {
+	Key   string
+	Value string
+}
+
+// Hash is a hash key and its fields, as read by ReadHashes and written with
+// HSET by Write.
+type Hash struct {
+	Key    string
+	Fields map[string]string
+}
+
+// Set is a set key and its members, as read by ReadSets and written with
+// SADD by Write.
+type Set struct {
+	Key     string
+	Members []string
+}
+
+// SortedSetMember is a member of a sorted set key, as written with ZADD by
+// Write.
+type SortedSetMember struct {
+	Key    string
+	Member string
+	Score  float64
+}
+
+// StreamMessage is a message of a stream, as read by ReadStream.
+type StreamMessage struct {
+	Stream string
+	// ID is the ID of the message, such as "1526919030474-0", which starts
+	// with the time it was added to the stream, in milliseconds since the
+	// epoch.
+	ID     string
+	Values map[string]string
+}
+
+// connect connects to the Redis server at the URL.
+func connect(ctx context.Context, url string) (*redis.Client, error) {
+	opts, err := redis.ParseURL(url)
+	if err != nil {
+		return nil, errors.Wrap(err, "parsing Redis URL")
+	}
+	client := redis.NewClient(opts)
+	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
+		return nil, errors.Wrapf(err, "connecting to Redis at %v", opts.Addr)
+	}
+	return client, nil
+}
@@ -0,0 +1,49 @@
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements.  See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License.  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package redisio
+
+import (
+	"context"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/testing/ptest"
+)
+
+func TestMain(m *testing.M) {
+	ptest.Main(m)
+}
+
+// startServer starts an in-memory Redis server, and returns it and its URL.
+func startServer(t *testing.T) (*miniredis.Miniredis, string) {
+	srv := miniredis.RunT(t)
+	return srv, "redis://" + srv.Addr()
+}
+
+func TestConnect(t *testing.T) {
+	_, url := startServer(t)
+	client, err := connect(context.Background(), url)
+	if err != nil {
+		t.Fatalf("connect() failed: %v", err)
+	}
+	client.Close()
+
+	for _, url := range []string{"localhost:6379", "redis://127.0.0.1:1"} {
+		if _, err := connect(context.Background(), url); err == nil {
+			t.Errorf("connect(%q) succeeded, want error", url)
+		}
+	}
+}
@@ -0,0 +1,342 @@
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements.  See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License.  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package redisio
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/apache/beam/sdks/v2/go/pkg/beam"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/core/graph/mtime"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/core/sdf"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/internal/errors"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/io/rtrackers/offsetrange"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/io/rtrackers/unbounded"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/log"
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+)
+
+const (
+	// defaultFetchSize is the maximum number of messages of each fetch,
+	// unless another is set with ReadStreamFetchSize.
+	defaultFetchSize = 100
+	// fetchTimeout bounds how long a fetch blocks waiting for messages.
+	fetchTimeout = 2 * time.Second
+	// pollInterval is how long reading waits after a fetch without messages.
+	pollInterval = time.Second
+	// checkpointInterval is how long messages are fetched before
+	// checkpointing, so that they can be committed and acknowledged.
+	checkpointInterval = 10 * time.Second
+	// finalizationTimeout is how long fetched messages wait for their bundle
+	// to be finalized before they're left unacknowledged.
+	finalizationTimeout = 10 * time.Minute
+	// claimIdle is how long messages are pending without being acknowledged
+	// before they're claimed by another reader, such as messages of bundles
+	// that failed, or of readers that stopped. It's longer than the
+	// finalization timeout, so that messages awaiting finalization aren't
+	// claimed.
+	claimIdle = 15 * time.Minute
+)
+
+func init() {
+	beam.RegisterType(reflect.TypeOf((*readStreamFn)(nil)).Elem())
+}
+
+// readStreamOption holds the options of ReadStream.
+type readStreamOption struct {
+	Readers   int
+	FetchSize int
+}
+
+// ReadStreamOptionFn is an option for ReadStream.
+type ReadStreamOptionFn func(*readStreamOption)
+
+// ReadStreamNumReaders sets the number of concurrent readers of the stream.
+// The default is 1.
+func ReadStreamNumReaders(n int) ReadStreamOptionFn {
+	if n < 1 {
+		panic(fmt.Sprintf("redisio.ReadStreamNumReaders number must be positive. Got: %v", n))
+	}
+	return func(o *readStreamOption) {
+		o.Readers = n
+	}
+}
+
+// ReadStreamFetchSize sets the maximum number of messages each reader
+// fetches at a time. The default is 100.
+func ReadStreamFetchSize(n int) ReadStreamOptionFn {
+	if n < 1 {
+		panic(fmt.Sprintf("redisio.ReadStreamFetchSize size must be positive. Got: %v", n))
+	}
+	return func(o *readStreamOption) {
+		o.FetchSize = n
+	}
+}
+
+// ReadStream reads the messages of the given stream, as a member of the
+// given consumer group, from the Redis server at the URL, and returns an
+// unbounded PCollection<StreamMessage>. For example:
+//
+//	msgs := redisio.ReadStream(s, "redis://localhost:6379", "orders", "beam")
+//
+// The consumer group is created if it doesn't exist, to read the stream from
+// its first message. Each reader reads as a consumer of the group, named
+// after a random ID. Messages are output timestamped at the time of their ID.
+// The output watermark is the earliest timestamp of the last messages
+// fetched, or the current time when there are none, so redelivered messages
+// may be late.
+//
+// Messages are acknowledged with XACK once their bundle is finalized.
+// Messages that are pending unacknowledged for 15 minutes, such as messages
+// of bundles that failed, or of readers that stopped, are claimed by other
+// readers with XAUTOCLAIM, and redelivered. ReadStream requires Redis 6.2 or
+// later.
+func ReadStream(s beam.Scope, url, stream, group string, opts ...ReadStreamOptionFn) beam.PCollection {
+	s = s.Scope("redisio.ReadStream")
+	if stream == "" || group == "" {
+		panic("redisio.ReadStream requires a stream and a consumer group")
+	}
+
+	o := readStreamOption{
+		Readers:   1,
+		FetchSize: defaultFetchSize,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return beam.ParDo(s, &readStreamFn{
+		URL:       url,
+		Stream:    stream,
+		Group:     group,
+		Readers:   o.Readers,
+		FetchSize: o.FetchSize,
+	}, beam.Impulse(s))
+}
+
+// readStreamFn fetches messages of a stream as a consumer of a group. Its
+// restriction is a range of fetch sequence numbers, which is split into a
+// range per reader. Each reader claims a number per fetch.
+type readStreamFn struct {
+	// URL is the URL of the Redis server.
+	URL string `json:"url"`
+	// Stream is the key of the stream.
+	Stream string `json:"stream"`
+	// Group is the name of the consumer group.
+	Group string `json:"group"`
+	// Readers is the number of concurrent readers.
+	Readers int `json:"readers"`
+	// FetchSize is the maximum number of messages of each fetch.
+	FetchSize int `json:"fetch_size"`
+
+	client   *redis.Client
+	consumer string
+	// claimStart is the ID pending messages are next claimed from.
+	claimStart string
+}
+
+func (f *readStreamFn) Setup(ctx context.Context) error {
+	client, err := connect(ctx, f.URL)
+	if err != nil {
+		return err
+	}
+	f.client = client
+	err = client.XGroupCreateMkStream(ctx, f.Stream, f.Group, "0").Err()
+	if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		return errors.Wrapf(err, "creating consumer group %v of stream %v", f.Group, f.Stream)
+	}
+	f.consumer = "beam-" + uuid.NewString()
+	f.claimStart = "0-0"
+	return nil
+}
+
+func (f *readStreamFn) Teardown() error {
+	if f.client == nil {
+		return nil
+	}
+	return f.client.Close()
+}
+
+func (f *readStreamFn) CreateInitialRestriction(_ []byte) offsetrange.Restriction {
+	return offsetrange.Restriction{Start: 0, End: math.MaxInt64}
+}
+
+// SplitRestriction splits the restriction into a restriction per reader.
+// Restriction.EvenSplits would overflow on the unbounded range.
+func (f *readStreamFn) SplitRestriction(_ []byte, rest offsetrange.Restriction) []offsetrange.Restriction {
+	n := int64(f.Readers)
+	size := (rest.End - rest.Start) / n
+	splits := make([]offsetrange.Restriction, n)
+	for i := range splits {
+		splits[i] = offsetrange.Restriction{Start: rest.Start + int64(i)*size, End: rest.Start + int64(i+1)*size}
+	}
+	splits[n-1].End = rest.End
+	return splits
+}
+
+func (f *readStreamFn) RestrictionSize(_ []byte, rest offsetrange.Restriction) float64 {
+	return rest.Size()
+}
+
+func (f *readStreamFn) CreateTracker(rest offsetrange.Restriction) *sdf.LockRTracker {
+	return sdf.NewLockRTracker(unbounded.NewTracker(rest))
+}
+
+func (f *readStreamFn) InitialWatermarkEstimatorState(et beam.EventTime, _ offsetrange.Restriction, _ []byte) int64 {
+	return int64(et)
+}
+
+func (f *readStreamFn) CreateWatermarkEstimator(state int64) *sdf.ManualWatermarkEstimator {
+	return &sdf.ManualWatermarkEstimator{State: mtime.Time(state).ToTime()}
+}
+
+func (f *readStreamFn) WatermarkEstimatorState(e *sdf.ManualWatermarkEstimator) int64 {
+	return int64(mtime.FromTime(e.State))
+}
+
+func (f *readStreamFn) ProcessElement(ctx context.Context, we *sdf.ManualWatermarkEstimator, bf beam.BundleFinalization, rt *sdf.LockRTracker, _ []byte, emit func(beam.EventTime, StreamMessage)) (sdf.ProcessContinuation, error) {
+	// The messages are acknowledged once the runner has committed their
+	// bundle.
+	var fetched []string
+	defer func() {
+		if len(fetched) == 0 {
+			return
+		}
+		ids := fetched
+		bf.RegisterCallback(finalizationTimeout, func() error {
+			return f.ack(context.Background(), ids)
+		})
+	}()
+
+	rest := rt.GetRestriction().(offsetrange.Restriction)
+	checkpoint := time.Now().Add(checkpointInterval)
+	for n := rest.Start; ; n++ {
+		if !rt.TryClaim(n) {
+			return sdf.StopProcessing(), nil
+		}
+		msgs, err := f.fetch(ctx)
+		if err != nil {
+			if isRetryable(err) {
+				log.Warnf(ctx, "Retrying fetch of messages of stream %v: %v", f.Stream, err)
+				return sdf.ResumeProcessingIn(pollInterval), nil
+			}
+			return sdf.StopProcessing(), errors.Wrapf(err, "fetching messages of stream %v as group %v", f.Stream, f.Group)
+		}
+		if len(msgs) == 0 {
+			unbounded.AdvanceWatermark(we, time.Now())
+			return sdf.ResumeProcessingIn(pollInterval), nil
+		}
+
+		var earliest time.Time
+		for _, m := range msgs {
+			fetched = append(fetched, m.ID)
+			t, err := idTime(m.ID)
+			if err != nil {
+				return sdf.StopProcessing(), err
+			}
+			if earliest.IsZero() || t.Before(earliest) {
+				earliest = t
+			}
+			emit(mtime.FromTime(t), StreamMessage{Stream: f.Stream, ID: m.ID, Values: values(m.Values)})
+		}
+		unbounded.AdvanceWatermark(we, earliest)
+
+		if time.Now().After(checkpoint) {
+			return sdf.ResumeProcessingIn(0), nil
+		}
+	}
+}
+
+// fetch claims messages that have been pending for too long, or reads new
+// messages if there are none, waiting for them up to the fetch timeout.
+func (f *readStreamFn) fetch(ctx context.Context) ([]redis.XMessage, error) {
+	claimed, next, err := f.client.XAutoClaim(ctx, &redis.XAutoClaimArgs{
+		Stream:   f.Stream,
+		Group:    f.Group,
+		Consumer: f.consumer,
+		MinIdle:  claimIdle,
+		Start:    f.claimStart,
+		Count:    int64(f.FetchSize),
+	}).Result()
+	if err != nil {
+		return nil, err
+	}
+	f.claimStart = next
+	if len(claimed) > 0 {
+		return claimed, nil
+	}
+
+	streams, err := f.client.XReadGroup(ctx, &redis.XReadGroupArgs{
+		Group:    f.Group,
+		Consumer: f.consumer,
+		Streams:  []string{f.Stream, ">"},
+		Count:    int64(f.FetchSize),
+		Block:    fetchTimeout,
+	}).Result()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var msgs []redis.XMessage
+	for _, s := range streams {
+		msgs = append(msgs, s.Messages...)
+	}
+	return msgs, nil
+}
+
+// ack acknowledges the messages with the IDs.
+func (f *readStreamFn) ack(ctx context.Context, ids []string) error {
+	if err := f.client.XAck(ctx, f.Stream, f.Group, ids...).Err(); err != nil {
+		return errors.Wrapf(err, "acknowledging %v messages of stream %v", len(ids), f.Stream)
+	}
+	return nil
+}
+
+// isRetryable returns whether the fetch that failed with the error can be
+// retried, such as while the server is unreachable or loading its data.
+func isRetryable(err error) bool {
+	if _, ok := err.(redis.Error); ok {
+		return strings.HasPrefix(err.Error(), "LOADING") || strings.HasPrefix(err.Error(), "TRYAGAIN")
+	}
+	// The request failed before a reply, such as for a lost connection.
+	return true
+}
+
+// idTime returns the time of a stream message ID, which starts with the
+// time the message was added, in milliseconds since the epoch.
+func idTime(id string) (time.Time, error) {
+	ms, err := strconv.ParseInt(strings.SplitN(id, "-", 2)[0], 10, 64)
+	if err != nil {
+		return time.Time{}, errors.Wrapf(err, "invalid stream message ID %v", id)
+	}
+	return time.UnixMilli(ms), nil
+}
+
+// values returns the values of a stream message, which are strings.
+func values(v map[string]interface{}) map[string]string {
+	vs := make(map[string]string, len(v))
+	for k, v := range v {
+		vs[k] = fmt.Sprint(v)
+	}
+	return vs
+}
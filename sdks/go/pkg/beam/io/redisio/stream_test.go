@@ -0,0 +1,207 @@
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements.  See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License.  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package redisio
+
+import (
+	"context"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/core/graph/mtime"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/core/sdf"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/io/rtrackers/offsetrange"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/io/rtrackers/unbounded/unboundedtest"
+)
+
+const (
+	testStream = "orders"
+	testGroup  = "beam"
+)
+
+// add adds messages with the values of the "v" field to the test stream.
+func add(t *testing.T, srv *miniredis.Miniredis, values ...string) {
+	t.Helper()
+	for _, v := range values {
+		if _, err := srv.XAdd(testStream, "*", []string{"v", v}); err != nil {
+			t.Fatalf("XAdd() failed: %v", err)
+		}
+	}
+}
+
+// process sets up a reader of the test stream, and processes a restriction
+// with it, returning the messages output.
+func process(t *testing.T, url string, bf *unboundedtest.Finalization) ([]StreamMessage, *sdf.ManualWatermarkEstimator) {
+	t.Helper()
+	fn := &readStreamFn{URL: url, Stream: testStream, Group: testGroup, Readers: 1, FetchSize: 2}
+	ctx := context.Background()
+	if err := fn.Setup(ctx); err != nil {
+		t.Fatalf("Setup() failed: %v", err)
+	}
+	t.Cleanup(func() { fn.Teardown() })
+	rest := fn.CreateInitialRestriction(nil)
+	rt := fn.CreateTracker(rest)
+	we := fn.CreateWatermarkEstimator(fn.InitialWatermarkEstimatorState(mtime.MinTimestamp, rest, nil))
+	var got []StreamMessage
+	pc, err := fn.ProcessElement(ctx, we, bf, rt, nil, func(et beam.EventTime, m StreamMessage) {
+		if want, _ := idTime(m.ID); et != mtime.FromTime(want) {
+			t.Errorf("ProcessElement() output %v at %v, want the time of its ID %v", m, et, want)
+		}
+		got = append(got, m)
+	})
+	if err != nil {
+		t.Fatalf("ProcessElement() failed: %v", err)
+	}
+	if !pc.ShouldResume() {
+		t.Errorf("ProcessElement() = %v, want it to resume", pc)
+	}
+	return got, we
+}
+
+// fieldValues returns the values of the "v" field of the messages.
+func fieldValues(msgs []StreamMessage) []string {
+	var vs []string
+	for _, m := range msgs {
+		vs = append(vs, m.Values["v"])
+	}
+	return vs
+}
+
+func TestReadStreamFn(t *testing.T) {
+	srv, url := startServer(t)
+	add(t, srv, "a", "b", "c")
+
+	var bf unboundedtest.Finalization
+	start := time.Now()
+	got, we := process(t, url, &bf)
+
+	// The messages are fetched in two batches.
+	if want := []string{"a", "b", "c"}; !reflect.DeepEqual(fieldValues(got), want) {
+		t.Fatalf("ProcessElement() output %v, want %v", fieldValues(got), want)
+	}
+	// There were no more messages, so the watermark is the current time.
+	if wm := we.CurrentWatermark(); wm.Before(start.Truncate(time.Millisecond)) {
+		t.Errorf("ProcessElement() watermark = %v, want at least %v", wm, start)
+	}
+
+	// The messages are acknowledged once the bundle is finalized.
+	if got := pending(t, srv); got != 3 {
+		t.Errorf("group has %v messages pending before finalization, want 3", got)
+	}
+	if len(bf.Callbacks) != 1 {
+		t.Fatalf("ProcessElement() registered %v finalization callbacks, want 1", len(bf.Callbacks))
+	}
+	if err := bf.Callbacks[0](); err != nil {
+		t.Fatalf("finalization callback failed: %v", err)
+	}
+	if got := pending(t, srv); got != 0 {
+		t.Errorf("group has %v messages pending after finalization, want 0", got)
+	}
+}
+
+// pending returns the number of pending messages of the test group.
+func pending(t *testing.T, srv *miniredis.Miniredis) int {
+	t.Helper()
+	client, err := connect(context.Background(), "redis://"+srv.Addr())
+	if err != nil {
+		t.Fatalf("connect() failed: %v", err)
+	}
+	defer client.Close()
+	p, err := client.XPending(context.Background(), testStream, testGroup).Result()
+	if err != nil {
+		t.Fatalf("XPending() failed: %v", err)
+	}
+	return int(p.Count)
+}
+
+func TestReadStreamFn_Claim(t *testing.T) {
+	srv, url := startServer(t)
+	now := time.Now()
+	srv.SetTime(now)
+	add(t, srv, "a", "b")
+
+	// The messages of a bundle that isn't finalized are claimed by another
+	// reader once they've been pending for too long.
+	var bf unboundedtest.Finalization
+	if got, _ := process(t, url, &bf); len(got) != 2 {
+		t.Fatalf("ProcessElement() output %v messages, want 2", len(got))
+	}
+	if got, _ := process(t, url, &bf); len(got) != 0 {
+		t.Errorf("ProcessElement() output %v, want no messages before they're idle", fieldValues(got))
+	}
+	srv.SetTime(now.Add(claimIdle + time.Minute))
+	got, _ := process(t, url, &bf)
+	if want := []string{"a", "b"}; !reflect.DeepEqual(fieldValues(got), want) {
+		t.Errorf("ProcessElement() output %v, want %v claimed", fieldValues(got), want)
+	}
+}
+
+func TestReadStreamFn_SplitRestriction(t *testing.T) {
+	fn := &readStreamFn{Readers: 3}
+	splits := fn.SplitRestriction(nil, fn.CreateInitialRestriction(nil))
+	if len(splits) != 3 || splits[0].Start != 0 || splits[2].End != math.MaxInt64 {
+		t.Fatalf("SplitRestriction() = %v, want 3 restrictions covering the range", splits)
+	}
+	for i := 1; i < len(splits); i++ {
+		if splits[i].Start != splits[i-1].End {
+			t.Errorf("SplitRestriction() = %v, want contiguous restrictions", splits)
+		}
+	}
+}
+
+func TestCreateTracker(t *testing.T) {
+	rt := (&readStreamFn{}).CreateTracker(offsetrange.Restriction{Start: 0, End: math.MaxInt64})
+	if rt.IsBounded() {
+		t.Error("CreateTracker() is bounded, want unbounded so that draining stops reads")
+	}
+}
+
+func TestIDTime(t *testing.T) {
+	got, err := idTime("1526919030474-55")
+	if err != nil {
+		t.Fatalf("idTime() failed: %v", err)
+	}
+	if want := time.UnixMilli(1526919030474); !got.Equal(want) {
+		t.Errorf("idTime() = %v, want %v", got, want)
+	}
+	if _, err := idTime("invalid"); err == nil {
+		t.Error("idTime(invalid) succeeded, want error")
+	}
+}
+
+func TestReadStream_Options(t *testing.T) {
+	tests := []struct {
+		name string
+		fn   func()
+	}{
+		{"no group", func() { ReadStream(beam.NewPipeline().Root(), "redis://localhost:6379", testStream, "") }},
+		{"zero readers", func() { ReadStreamNumReaders(0) }},
+		{"zero fetch size", func() { ReadStreamFetchSize(0) }},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%v succeeded, want panic", test.name)
+				}
+			}()
+			test.fn()
+		})
+	}
+}
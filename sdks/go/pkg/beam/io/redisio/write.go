@@ -0,0 +1,178 @@
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements.  See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License.  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package redisio
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/apache/beam/sdks/v2/go/pkg/beam"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/internal/errors"
+	"github.com/go-redis/redis/v8"
+)
+
+// defaultWriteBatchSize is the number of elements of each pipeline of
+// commands, unless another is set with WriteBatchSize.
+const defaultWriteBatchSize = 1000
+
+func init() {
+	beam.RegisterType(reflect.TypeOf((*writeFn)(nil)).Elem())
+}
+
+// writeOption holds the options of Write.
+type writeOption struct {
+	TTL       time.Duration
+	BatchSize int
+}
+
+// WriteOptionFn is an option for Write.
+type WriteOptionFn func(*writeOption)
+
+// WriteTTL sets the time to live of the written keys, after which Redis
+// deletes them. By default, keys don't expire.
+func WriteTTL(ttl time.Duration) WriteOptionFn {
+	if ttl <= 0 {
+		panic(fmt.Sprintf("redisio.WriteTTL ttl must be positive. Got: %v", ttl))
+	}
+	return func(o *writeOption) {
+		o.TTL = ttl
+	}
+}
+
+// WriteBatchSize sets the number of elements written with each pipeline of
+// commands. The default is 1000.
+func WriteBatchSize(n int) WriteOptionFn {
+	if n < 1 {
+		panic(fmt.Sprintf("redisio.WriteBatchSize size must be positive. Got: %v", n))
+	}
+	return func(o *writeOption) {
+		o.BatchSize = n
+	}
+}
+
+// Write writes the elements of the given PCollection to the Redis server at
+// the URL. The command depends on the type of the elements:
+//
+//   - KeyValue elements are written with SET, replacing the value of their
+//     key.
+//   - Hash elements are written with HSET, setting their fields.
+//   - Set elements are written with SADD, adding their members.
+//   - SortedSetMember elements are written with ZADD, adding their member,
+//     or updating its score.
+//
+// For example:
+//
+//	redisio.Write(s, "redis://localhost:6379", kvs, redisio.WriteTTL(time.Hour))
+//
+// Commands are sent in pipelines, which are sent when they hold the batch
+// size of elements, and at the end of each bundle. With WriteTTL, the
+// expiration of each key is set with the command that writes it.
+func Write(s beam.Scope, url string, col beam.PCollection, opts ...WriteOptionFn) {
+	s = s.Scope("redisio.Write")
+	switch t := col.Type().Type(); t {
+	case reflect.TypeOf(KeyValue{}), reflect.TypeOf(Hash{}), reflect.TypeOf(Set{}), reflect.TypeOf(SortedSetMember{}):
+	default:
+		panic(fmt.Sprintf("redisio.Write requires KeyValue, Hash, Set or SortedSetMember elements. Got: %v", t))
+	}
+
+	o := writeOption{
+		BatchSize: defaultWriteBatchSize,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	beam.ParDo0(s, &writeFn{URL: url, Options: o}, col)
+}
+
+// writeFn writes elements with pipelines of commands.
+type writeFn struct {
+	// URL is the URL of the Redis server.
+	URL string `json:"url"`
+	// Options specifies the TTL and batch size.
+	Options writeOption `json:"options"`
+
+	client *redis.Client
+	pipe   redis.Pipeliner
+	n      int
+}
+
+func (f *writeFn) Setup(ctx context.Context) (err error) {
+	f.client, err = connect(ctx, f.URL)
+	return err
+}
+
+func (f *writeFn) Teardown() error {
+	if f.client == nil {
+		return nil
+	}
+	return f.client.Close()
+}
+
+func (f *writeFn) StartBundle(ctx context.Context) {
+	f.pipe = f.client.Pipeline()
+	f.n = 0
+}
+
+func (f *writeFn) ProcessElement(ctx context.Context, elem beam.X) error {
+	var key string
+	switch e := elem.(type) {
+	case KeyValue:
+		key = e.Key
+		f.pipe.Set(ctx, key, e.Value, f.Options.TTL)
+	case Hash:
+		key = e.Key
+		f.pipe.HSet(ctx, key, e.Fields)
+	case Set:
+		key = e.Key
+		members := make([]interface{}, len(e.Members))
+		for i, m := range e.Members {
+			members[i] = m
+		}
+		f.pipe.SAdd(ctx, key, members...)
+	case SortedSetMember:
+		key = e.Key
+		f.pipe.ZAdd(ctx, key, &redis.Z{Score: e.Score, Member: e.Member})
+	default:
+		return errors.Errorf("unsupported element type %T", elem)
+	}
+	// SET sets the expiration itself.
+	if _, ok := elem.(KeyValue); !ok && f.Options.TTL > 0 {
+		f.pipe.Expire(ctx, key, f.Options.TTL)
+	}
+	if f.n++; f.n >= f.Options.BatchSize {
+		return f.flush(ctx)
+	}
+	return nil
+}
+
+func (f *writeFn) FinishBundle(ctx context.Context) error {
+	return f.flush(ctx)
+}
+
+// flush sends the pipelined commands.
+func (f *writeFn) flush(ctx context.Context) error {
+	if f.n == 0 {
+		return nil
+	}
+	n := f.n
+	f.n = 0
+	if _, err := f.pipe.Exec(ctx); err != nil {
+		return errors.Wrapf(err, "writing %v elements", n)
+	}
+	return nil
+}
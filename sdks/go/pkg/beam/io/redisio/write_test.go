@@ -0,0 +1,129 @@
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements.  See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License.  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package redisio
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/apache/beam/sdks/v2/go/pkg/beam"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/testing/ptest"
+)
+
+// write writes the elements with a writer in one bundle, and returns the
+// error of finishing it.
+func write(t *testing.T, url string, o writeOption, elems ...interface{}) error {
+	t.Helper()
+	fn := &writeFn{URL: url, Options: o}
+	ctx := context.Background()
+	if err := fn.Setup(ctx); err != nil {
+		t.Fatalf("Setup() failed: %v", err)
+	}
+	defer fn.Teardown()
+	fn.StartBundle(ctx)
+	for _, e := range elems {
+		if err := fn.ProcessElement(ctx, e); err != nil {
+			return err
+		}
+	}
+	return fn.FinishBundle(ctx)
+}
+
+func TestWriteFn(t *testing.T) {
+	srv, url := startServer(t)
+	elems := []interface{}{
+		KeyValue{Key: "k", Value: "v"},
+		Hash{Key: "h", Fields: map[string]string{"a": "1", "b": "2"}},
+		Set{Key: "s", Members: []string{"x", "y"}},
+		SortedSetMember{Key: "z", Member: "m", Score: 1.5},
+	}
+	if err := write(t, url, writeOption{TTL: time.Hour, BatchSize: 3}, elems...); err != nil {
+		t.Fatalf("Write() failed: %v", err)
+	}
+
+	if got, _ := srv.Get("k"); got != "v" {
+		t.Errorf("Write() set k to %q, want %q", got, "v")
+	}
+	if got := srv.HGet("h", "b"); got != "2" {
+		t.Errorf("Write() set field b of h to %q, want %q", got, "2")
+	}
+	if got, _ := srv.Members("s"); !reflect.DeepEqual(got, []string{"x", "y"}) {
+		t.Errorf("Write() added members %v to s, want %v", got, []string{"x", "y"})
+	}
+	if got, _ := srv.ZScore("z", "m"); got != 1.5 {
+		t.Errorf("Write() added m to z with score %v, want %v", got, 1.5)
+	}
+	// Every key expires after the TTL.
+	for _, key := range []string{"k", "h", "s", "z"} {
+		if got := srv.TTL(key); got != time.Hour {
+			t.Errorf("Write() set the TTL of %v to %v, want %v", key, got, time.Hour)
+		}
+	}
+}
+
+func TestWriteFn_NoTTL(t *testing.T) {
+	srv, url := startServer(t)
+	if err := write(t, url, writeOption{BatchSize: 1}, Hash{Key: "h", Fields: map[string]string{"a": "1"}}); err != nil {
+		t.Fatalf("Write() failed: %v", err)
+	}
+	if got := srv.TTL("h"); got != 0 {
+		t.Errorf("Write() set the TTL of h to %v, want none", got)
+	}
+}
+
+func TestWriteFn_WrongType(t *testing.T) {
+	srv, url := startServer(t)
+	srv.Set("h", "v")
+	if err := write(t, url, writeOption{BatchSize: 1}, Hash{Key: "h", Fields: map[string]string{"a": "1"}}); err == nil {
+		t.Error("Write() of a hash to a string key succeeded, want error")
+	}
+}
+
+func TestWrite_Options(t *testing.T) {
+	tests := []struct {
+		name string
+		fn   func()
+	}{
+		{"unsupported type", func() {
+			s := beam.NewPipeline().Root()
+			Write(s, "redis://localhost:6379", beam.Create(s, "a"))
+		}},
+		{"zero TTL", func() { WriteTTL(0) }},
+		{"zero batch size", func() { WriteBatchSize(0) }},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%v succeeded, want panic", test.name)
+				}
+			}()
+			test.fn()
+		})
+	}
+}
+
+func TestWrite(t *testing.T) {
+	srv, url := startServer(t)
+	p, s := beam.NewPipelineWithRoot()
+	Write(s, url, beam.Create(s, SortedSetMember{"z", "a", 1}, SortedSetMember{"z", "b", 2}))
+	ptest.RunAndValidate(t, p)
+	if got, _ := srv.ZMembers("z"); !reflect.DeepEqual(got, []string{"a", "b"}) {
+		t.Errorf("Write() added %v, want %v", got, []string{"a", "b"})
+	}
+}
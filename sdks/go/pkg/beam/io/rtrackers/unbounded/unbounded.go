@@ -0,0 +1,84 @@
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements.  See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License.  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package unbounded defines a restriction tracker for unbounded sources that
+// read their offset ranges in order, such as message queues and partitioned
+// logs. Their restrictions can't be divided, so the tracker only splits to
+// checkpoint, and it reports them as unbounded, so that draining truncates
+// them. Such sources also estimate their watermarks manually, with
+// AdvanceWatermark.
+package unbounded
+
+import (
+	"time"
+
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/core/sdf"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/io/rtrackers/offsetrange"
+)
+
+// Tracker is an offset range tracker that only splits to checkpoint, and is
+// unbounded.
+type Tracker struct {
+	*offsetrange.Tracker
+	// skipClaimed is whether residuals start after the last claimed offset.
+	skipClaimed bool
+}
+
+// NewTracker returns a tracker of the restriction, whose offsets count the
+// work claimed, such as pulls of a subscription. The residual of a checkpoint
+// starts at the last claimed offset, and there is none if nothing has been
+// claimed, so sources must claim an offset before they checkpoint.
+func NewTracker(rest offsetrange.Restriction) *Tracker {
+	return &Tracker{Tracker: offsetrange.NewTracker(rest)}
+}
+
+// NewOffsetTracker returns a tracker of the restriction, whose offsets are
+// the positions of the records read, such as the offsets of a partition. The
+// residual of a checkpoint starts after the last claimed offset, since its
+// record has already been output.
+func NewOffsetTracker(rest offsetrange.Restriction) *Tracker {
+	return &Tracker{Tracker: offsetrange.NewTracker(rest), skipClaimed: true}
+}
+
+// TrySplit splits at the current position if the fraction is 0, to
+// checkpoint, and doesn't split otherwise.
+func (t *Tracker) TrySplit(fraction float64) (primary, residual interface{}, err error) {
+	if fraction > 0 {
+		return t.GetRestriction(), nil, nil
+	}
+	if done, _ := t.GetProgress(); done == 0 && !t.skipClaimed {
+		return t.GetRestriction(), nil, nil
+	}
+	primary, residual, err = t.Tracker.TrySplit(0)
+	if r, ok := residual.(offsetrange.Restriction); ok && t.skipClaimed {
+		r.Start++
+		residual = r
+	}
+	return primary, residual, err
+}
+
+// IsBounded returns false, since the restriction is read until the source is
+// drained.
+func (t *Tracker) IsBounded() bool {
+	return false
+}
+
+// AdvanceWatermark sets the watermark to the time, unless that is before
+// the current watermark.
+func AdvanceWatermark(we *sdf.ManualWatermarkEstimator, t time.Time) {
+	if t.After(we.CurrentWatermark()) {
+		we.UpdateWatermark(t)
+	}
+}
@@ -0,0 +1,72 @@
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements.  See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License.  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package unbounded
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/core/sdf"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/io/rtrackers/offsetrange"
+)
+
+func TestTracker_TrySplit(t *testing.T) {
+	tests := []struct {
+		name    string
+		tracker func(rest offsetrange.Restriction) *Tracker
+		claim   bool
+		want    interface{}
+	}{
+		{"counter before claiming", NewTracker, false, nil},
+		{"counter", NewTracker, true, offsetrange.Restriction{Start: 5, End: 100}},
+		{"offset before claiming", NewOffsetTracker, false, offsetrange.Restriction{Start: 3, End: 100}},
+		{"offset", NewOffsetTracker, true, offsetrange.Restriction{Start: 6, End: 100}},
+	}
+	for _, test := range tests {
+		rt := test.tracker(offsetrange.Restriction{Start: 3, End: 100})
+		if test.claim && !rt.TryClaim(int64(5)) {
+			t.Fatalf("%v: TryClaim(5) failed", test.name)
+		}
+		if _, residual, err := rt.TrySplit(0.5); err != nil || residual != nil {
+			t.Errorf("%v: TrySplit(0.5) = %v, %v, want no residual", test.name, residual, err)
+		}
+		if _, residual, err := rt.TrySplit(0); err != nil || residual != test.want {
+			t.Errorf("%v: TrySplit(0) = %v, %v, want %v", test.name, residual, err, test.want)
+		}
+	}
+}
+
+func TestTracker_IsBounded(t *testing.T) {
+	rt := sdf.NewLockRTracker(NewTracker(offsetrange.Restriction{Start: 0, End: math.MaxInt64}))
+	if rt.IsBounded() {
+		t.Error("IsBounded() = true, want false")
+	}
+}
+
+func TestAdvanceWatermark(t *testing.T) {
+	start := time.Unix(100, 0)
+	we := &sdf.ManualWatermarkEstimator{State: start}
+	AdvanceWatermark(we, start.Add(-time.Second))
+	if got := we.CurrentWatermark(); !got.Equal(start) {
+		t.Errorf("AdvanceWatermark() to an earlier time set the watermark to %v, want %v", got, start)
+	}
+	later := start.Add(time.Second)
+	AdvanceWatermark(we, later)
+	if got := we.CurrentWatermark(); !got.Equal(later) {
+		t.Errorf("AdvanceWatermark() set the watermark to %v, want %v", got, later)
+	}
+}
@@ -0,0 +1,78 @@
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements.  See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License.  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package snsio contains a transform to publish to Amazon SNS topics
+// (https://aws.amazon.com/sns).
+//
+// Credentials and the region are loaded from the default sources of the AWS
+// SDK for Go v2, such as the environment, the shared configuration files and
+// the instance role, unless the region is set with an option. Experimental.
+package snsio
+
+import (
+	"context"
+	"reflect"
+
+	"github.com/apache/beam/sdks/v2/go/pkg/beam"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/internal/errors"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+)
+
+func init() {
+	beam.RegisterType(reflect.TypeOf((*Message)(nil)).Elem())
+}
+
+// Message is a message to publish to an SNS topic.
+type Message struct {
+	Body string
+	// Subject is the subject of the message when it's delivered as an email,
+	// if it's set.
+	Subject string
+	// Attributes are the message attributes of the message, which are
+	// published as strings.
+	Attributes map[string]string
+	// GroupID and DeduplicationID are the message group ID and message
+	// deduplication ID of messages of FIFO topics.
+	GroupID         string
+	DeduplicationID string
+}
+
+// client is the part of a *sns.Client used by Write, so that it can be faked
+// in tests.
+type client interface {
+	PublishBatch(ctx context.Context, in *sns.PublishBatchInput, opts ...func(*sns.Options)) (*sns.PublishBatchOutput, error)
+}
+
+// newClientFunc returns a client of the region, or of the default region if
+// it's empty, which sends requests to the endpoint, if it's set.
+type newClientFunc func(ctx context.Context, region, endpoint string) (client, error)
+
+// newClient returns an SNS client, with the default credentials.
+func newClient(ctx context.Context, region, endpoint string) (client, error) {
+	var opts []func(*config.LoadOptions) error
+	if region != "" {
+		opts = append(opts, config.WithRegion(region))
+	}
+	cfg, err := config.LoadDefaultConfig(ctx, opts...)
+	if err != nil {
+		return nil, errors.Wrap(err, "loading AWS configuration")
+	}
+	return sns.NewFromConfig(cfg, func(o *sns.Options) {
+		if endpoint != "" {
+			o.EndpointResolver = sns.EndpointResolverFromURL(endpoint)
+		}
+	}), nil
+}
@@ -0,0 +1,270 @@
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements.  See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License.  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package snsio
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strconv"
+	"time"
+
+	"github.com/apache/beam/sdks/v2/go/pkg/beam"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/core/util/reflectx"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/internal/errors"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/log"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+	"github.com/aws/aws-sdk-go-v2/service/sns/types"
+)
+
+const (
+	// maxBatchSize and maxBatchBytes are the maximum number of messages and
+	// bytes of a PublishBatch request.
+	maxBatchSize          = 10
+	maxBatchBytes         = 256 << 10
+	defaultMaxRetries     = 5
+	defaultInitialBackoff = 100 * time.Millisecond
+	defaultMaxBackoff     = 10 * time.Second
+)
+
+func init() {
+	beam.RegisterType(reflect.TypeOf((*writeFn)(nil)).Elem())
+	beam.RegisterFunction(bodyMessageFn)
+}
+
+// writeOption holds the options of Write.
+type writeOption struct {
+	BatchSize      int
+	MaxRetries     int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	Region         string
+	Endpoint       string
+}
+
+// WriteOptionFn is an option for Write.
+type WriteOptionFn func(*writeOption)
+
+func newWriteOption(opts []WriteOptionFn) writeOption {
+	o := writeOption{
+		BatchSize:      maxBatchSize,
+		MaxRetries:     defaultMaxRetries,
+		InitialBackoff: defaultInitialBackoff,
+		MaxBackoff:     defaultMaxBackoff,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// WriteBatchSize sets the maximum number of messages of each PublishBatch
+// request, which is at most 10. The default is 10.
+func WriteBatchSize(n int) WriteOptionFn {
+	if n < 1 || n > maxBatchSize {
+		panic(fmt.Sprintf("snsio.WriteBatchSize size must be between 1 and %v. Got: %v", maxBatchSize, n))
+	}
+	return func(o *writeOption) {
+		o.BatchSize = n
+	}
+}
+
+// WriteMaxRetries sets how many times messages that SNS fails to publish
+// because of a server error are retried. The default is 5.
+func WriteMaxRetries(n int) WriteOptionFn {
+	if n < 0 {
+		panic(fmt.Sprintf("snsio.WriteMaxRetries number must not be negative. Got: %v", n))
+	}
+	return func(o *writeOption) {
+		o.MaxRetries = n
+	}
+}
+
+// WriteBackoff sets the initial and maximum backoff between retries, which
+// doubles after each retry. The defaults are 100ms and 10s.
+func WriteBackoff(initial, max time.Duration) WriteOptionFn {
+	if initial <= 0 || max < initial {
+		panic(fmt.Sprintf("snsio.WriteBackoff backoffs must be positive, and initial at most max. Got: %v, %v", initial, max))
+	}
+	return func(o *writeOption) {
+		o.InitialBackoff = initial
+		o.MaxBackoff = max
+	}
+}
+
+// WriteRegion sets the AWS region of the topic.
+func WriteRegion(region string) WriteOptionFn {
+	return func(o *writeOption) {
+		o.Region = region
+	}
+}
+
+// WriteEndpoint sets the URL of the endpoint requests are sent to, such as
+// "http://localhost:4566" for LocalStack.
+func WriteEndpoint(url string) WriteOptionFn {
+	return func(o *writeOption) {
+		o.Endpoint = url
+	}
+}
+
+// Write publishes the messages of the given PCollection<Message>, or
+// PCollection<string> of bodies, to the SNS topic with the given ARN. For
+// example:
+//
+//	snsio.Write(s, "arn:aws:sns:eu-west-1:123456789012:orders", msgs)
+//
+// Messages are published with PublishBatch requests of the batch size, of
+// at most 256 KiB. Messages that SNS fails to publish because of a server
+// error are retried with backoff, and fail the bundle if they still fail
+// once their retries are exhausted; messages it rejects fail the bundle
+// right away. Messages of retried bundles are published again, unless the
+// topic is a FIFO topic that deduplicates them.
+func Write(s beam.Scope, topicARN string, col beam.PCollection, opts ...WriteOptionFn) {
+	s = s.Scope("snsio.Write")
+	if topicARN == "" {
+		panic("snsio.Write requires a topic ARN")
+	}
+
+	msgs := col
+	if col.Type().Type() == reflectx.String {
+		msgs = beam.ParDo(s, bodyMessageFn, col)
+	}
+	beam.ParDo0(s, &writeFn{Topic: topicARN, Options: newWriteOption(opts)}, msgs)
+}
+
+func bodyMessageFn(body string) Message {
+	return Message{Body: body}
+}
+
+// writeFn publishes messages with PublishBatch requests.
+type writeFn struct {
+	// Topic is the ARN of the topic.
+	Topic string `json:"topic"`
+	// Options specifies the batch size and retries.
+	Options writeOption `json:"options"`
+
+	newClient newClientFunc
+	client    client
+	batch     []types.PublishBatchRequestEntry
+	bytes     int
+}
+
+func (f *writeFn) Setup(ctx context.Context) error {
+	if f.newClient == nil {
+		f.newClient = newClient
+	}
+	c, err := f.newClient(ctx, f.Options.Region, f.Options.Endpoint)
+	if err != nil {
+		return err
+	}
+	f.client = c
+	return nil
+}
+
+func (f *writeFn) ProcessElement(ctx context.Context, m Message) error {
+	// The size of a message counts its body, and the names, types, and
+	// values of its attributes.
+	size := len(m.Body)
+	var attrs map[string]types.MessageAttributeValue
+	for k, v := range m.Attributes {
+		if attrs == nil {
+			attrs = make(map[string]types.MessageAttributeValue)
+		}
+		attrs[k] = types.MessageAttributeValue{DataType: aws.String("String"), StringValue: aws.String(v)}
+		size += len(k) + len("String") + len(v)
+	}
+	if size > maxBatchBytes {
+		return errors.Errorf("message of %v bytes for %v exceeds the maximum of %v bytes", size, f.Topic, maxBatchBytes)
+	}
+	if f.bytes+size > maxBatchBytes {
+		if err := f.flush(ctx); err != nil {
+			return err
+		}
+	}
+	entry := types.PublishBatchRequestEntry{
+		Id:                aws.String(strconv.Itoa(len(f.batch))),
+		Message:           aws.String(m.Body),
+		MessageAttributes: attrs,
+	}
+	if m.Subject != "" {
+		entry.Subject = aws.String(m.Subject)
+	}
+	if m.GroupID != "" {
+		entry.MessageGroupId = aws.String(m.GroupID)
+	}
+	if m.DeduplicationID != "" {
+		entry.MessageDeduplicationId = aws.String(m.DeduplicationID)
+	}
+	f.batch = append(f.batch, entry)
+	f.bytes += size
+	if len(f.batch) >= f.Options.BatchSize {
+		return f.flush(ctx)
+	}
+	return nil
+}
+
+func (f *writeFn) FinishBundle(ctx context.Context) error {
+	return f.flush(ctx)
+}
+
+// flush publishes the batch with PublishBatch requests, retrying the
+// messages that SNS fails to publish because of a server error with
+// backoff.
+func (f *writeFn) flush(ctx context.Context) error {
+	if len(f.batch) == 0 {
+		return nil
+	}
+	batch := f.batch
+	f.batch, f.bytes = nil, 0
+
+	backoff := f.Options.InitialBackoff
+	for attempt := 0; ; attempt++ {
+		out, err := f.client.PublishBatch(ctx, &sns.PublishBatchInput{TopicArn: aws.String(f.Topic), PublishBatchRequestEntries: batch})
+		if err != nil {
+			return errors.Wrapf(err, "publishing %v messages to %v", len(batch), f.Topic)
+		}
+		if len(out.Failed) == 0 {
+			return nil
+		}
+		// The failed entries are identified by the IDs of their messages.
+		entries := make(map[string]types.PublishBatchRequestEntry)
+		for _, e := range batch {
+			entries[aws.ToString(e.Id)] = e
+		}
+		var failed []types.PublishBatchRequestEntry
+		for _, e := range out.Failed {
+			if e.SenderFault {
+				return errors.Errorf("publishing message to %v failed with %v: %v", f.Topic, aws.ToString(e.Code), aws.ToString(e.Message))
+			}
+			failed = append(failed, entries[aws.ToString(e.Id)])
+		}
+		batch = failed
+		code, msg := aws.ToString(out.Failed[0].Code), aws.ToString(out.Failed[0].Message)
+		if attempt >= f.Options.MaxRetries {
+			return errors.Errorf("%v messages of %v failed after %v retries, such as with %v: %v", len(batch), f.Topic, attempt, code, msg)
+		}
+		log.Warnf(ctx, "Retrying %v failed messages of %v in %v: %v", len(batch), f.Topic, backoff, code)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		if backoff *= 2; backoff > f.Options.MaxBackoff {
+			backoff = f.Options.MaxBackoff
+		}
+	}
+}
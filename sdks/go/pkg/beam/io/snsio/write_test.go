@@ -0,0 +1,199 @@
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements.  See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License.  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package snsio
+
+import (
+	"context"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/apache/beam/sdks/v2/go/pkg/beam"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/testing/ptest"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+	"github.com/aws/aws-sdk-go-v2/service/sns/types"
+)
+
+const testTopic = "arn:aws:sns:eu-west-1:0:orders"
+
+func TestMain(m *testing.M) {
+	ptest.Main(m)
+}
+
+// fakeClient is a client of a topic.
+type fakeClient struct {
+	// failures is the number of PublishBatch requests that fail to publish
+	// their first message.
+	failures int
+
+	publishes [][]types.PublishBatchRequestEntry
+}
+
+func (c *fakeClient) newClient(context.Context, string, string) (client, error) {
+	return c, nil
+}
+
+// PublishBatch publishes the messages, except for the first message of the
+// requests that fail, and messages with the body "reject", which are
+// rejected.
+func (c *fakeClient) PublishBatch(_ context.Context, in *sns.PublishBatchInput, _ ...func(*sns.Options)) (*sns.PublishBatchOutput, error) {
+	out := &sns.PublishBatchOutput{}
+	var published []types.PublishBatchRequestEntry
+	for i, e := range in.PublishBatchRequestEntries {
+		switch {
+		case aws.ToString(e.Message) == "reject":
+			out.Failed = append(out.Failed, types.BatchResultErrorEntry{Id: e.Id, Code: aws.String("InvalidParameter"), SenderFault: true})
+		case i == 0 && c.failures > 0:
+			c.failures--
+			out.Failed = append(out.Failed, types.BatchResultErrorEntry{Id: e.Id, Code: aws.String("InternalError")})
+		default:
+			published = append(published, e)
+		}
+	}
+	c.publishes = append(c.publishes, published)
+	return out, nil
+}
+
+// write publishes the messages with a writer of the client in one bundle,
+// and returns the error of finishing it.
+func write(t *testing.T, c *fakeClient, opts []WriteOptionFn, msgs ...Message) error {
+	t.Helper()
+	opts = append([]WriteOptionFn{WriteBackoff(time.Millisecond, time.Millisecond)}, opts...)
+	fn := &writeFn{Topic: testTopic, Options: newWriteOption(opts), newClient: c.newClient}
+	ctx := context.Background()
+	if err := fn.Setup(ctx); err != nil {
+		t.Fatalf("Setup() failed: %v", err)
+	}
+	for _, m := range msgs {
+		if err := fn.ProcessElement(ctx, m); err != nil {
+			return err
+		}
+	}
+	return fn.FinishBundle(ctx)
+}
+
+// messages returns n messages with bodies of n bytes.
+func messages(n int) []Message {
+	var msgs []Message
+	for i := 0; i < n; i++ {
+		msgs = append(msgs, Message{Body: strings.Repeat(strconv.Itoa(i), n)})
+	}
+	return msgs
+}
+
+// sizes returns the number of messages of each PublishBatch request.
+func sizes(publishes [][]types.PublishBatchRequestEntry) []int {
+	var sizes []int
+	for _, p := range publishes {
+		sizes = append(sizes, len(p))
+	}
+	return sizes
+}
+
+func TestWriteFn(t *testing.T) {
+	c := &fakeClient{}
+	msgs := messages(3)
+	msgs[2] = Message{Body: "222", Subject: "s", Attributes: map[string]string{"k": "v"}, GroupID: "g", DeduplicationID: "d"}
+	if err := write(t, c, []WriteOptionFn{WriteBatchSize(2)}, msgs...); err != nil {
+		t.Fatalf("Write() failed: %v", err)
+	}
+	if got, want := sizes(c.publishes), []int{2, 1}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("Write() published batches of %v, want %v", got, want)
+	}
+	want := types.PublishBatchRequestEntry{
+		Id:                     aws.String("0"),
+		Message:                aws.String("222"),
+		Subject:                aws.String("s"),
+		MessageAttributes:      map[string]types.MessageAttributeValue{"k": {DataType: aws.String("String"), StringValue: aws.String("v")}},
+		MessageGroupId:         aws.String("g"),
+		MessageDeduplicationId: aws.String("d"),
+	}
+	if got := c.publishes[1][0]; !reflect.DeepEqual(got, want) {
+		t.Errorf("Write() published %+v, want %+v", got, want)
+	}
+}
+
+func TestWriteFn_Bytes(t *testing.T) {
+	c := &fakeClient{}
+	big := strings.Repeat("a", 100<<10)
+	if err := write(t, c, nil, Message{Body: big}, Message{Body: big}, Message{Body: big}); err != nil {
+		t.Fatalf("Write() failed: %v", err)
+	}
+	// Requests are at most 256 KiB.
+	if got, want := sizes(c.publishes), []int{2, 1}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Write() published batches of %v, want %v", got, want)
+	}
+
+	if err := write(t, c, nil, Message{Body: strings.Repeat("a", 257<<10)}); err == nil {
+		t.Error("Write() of a message above 256 KiB succeeded, want error")
+	}
+}
+
+func TestWriteFn_Retries(t *testing.T) {
+	c := &fakeClient{failures: 2}
+	if err := write(t, c, nil, messages(3)...); err != nil {
+		t.Fatalf("Write() failed: %v", err)
+	}
+	// The failed message is retried until it's published.
+	if got, want := sizes(c.publishes), []int{2, 0, 1}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Write() published batches of %v, want %v", got, want)
+	}
+
+	c = &fakeClient{failures: 3}
+	if err := write(t, c, []WriteOptionFn{WriteMaxRetries(2)}, messages(3)...); err == nil {
+		t.Error("Write() with failed messages after the retries succeeded, want error")
+	}
+}
+
+func TestWriteFn_Rejected(t *testing.T) {
+	c := &fakeClient{}
+	if err := write(t, c, nil, Message{Body: "a"}, Message{Body: "reject"}); err == nil {
+		t.Fatal("Write() of a rejected message succeeded, want error")
+	}
+	// Rejected messages aren't retried.
+	if got, want := len(c.publishes), 1; got != want {
+		t.Errorf("Write() published %v batches, want %v", got, want)
+	}
+}
+
+func TestWrite_Options(t *testing.T) {
+	tests := []struct {
+		name string
+		fn   func()
+	}{
+		{"no topic", func() {
+			s := beam.NewPipeline().Root()
+			Write(s, "", beam.Create(s, "a"))
+		}},
+		{"zero batch size", func() { WriteBatchSize(0) }},
+		{"batch size above 10", func() { WriteBatchSize(11) }},
+		{"negative max retries", func() { WriteMaxRetries(-1) }},
+		{"zero backoff", func() { WriteBackoff(0, time.Second) }},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%v succeeded, want panic", test.name)
+				}
+			}()
+			test.fn()
+		})
+	}
+}
@@ -0,0 +1,569 @@
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements.  See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License.  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package spannerio
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/apache/beam/sdks/v2/go/pkg/beam"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/core/graph/mtime"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/core/sdf"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/internal/errors"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/io/rtrackers/offsetrange"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/io/rtrackers/unbounded"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/log"
+	spannerpb "google.golang.org/genproto/googleapis/spanner/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+const (
+	// defaultHeartbeat is the interval of the heartbeats of idle partitions,
+	// unless another is set with ChangeStreamHeartbeat.
+	defaultHeartbeat = 10 * time.Second
+	// defaultCheckpointInterval is how long a change stream is read before
+	// checkpointing, unless another is set with ChangeStreamCheckpointInterval.
+	defaultCheckpointInterval = 5 * time.Minute
+
+	// changeStreamSQL is the query of the change records of a partition of a
+	// change stream. The null partition token queries the initial partitions.
+	changeStreamSQL = `SELECT ChangeRecord FROM READ_%s (
+		start_timestamp => @start_timestamp,
+		end_timestamp => @end_timestamp,
+		partition_token => @partition_token,
+		heartbeat_milliseconds => @heartbeat_milliseconds
+	)`
+)
+
+var (
+	// streamRegexp matches valid change stream names, which are interpolated
+	// into the query.
+	streamRegexp = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_]*$`)
+
+	changeStreamParamTypes = map[string]*spannerpb.Type{
+		"start_timestamp":        {Code: spannerpb.TypeCode_TIMESTAMP},
+		"end_timestamp":          {Code: spannerpb.TypeCode_TIMESTAMP},
+		"partition_token":        {Code: spannerpb.TypeCode_STRING},
+		"heartbeat_milliseconds": {Code: spannerpb.TypeCode_INT64},
+	}
+
+	changeRecordRowType = reflect.TypeOf(changeRecordRow{})
+)
+
+func init() {
+	beam.RegisterType(reflect.TypeOf((*DataChangeRecord)(nil)).Elem())
+	beam.RegisterType(reflect.TypeOf((*changeStreamFn)(nil)).Elem())
+}
+
+// DataChangeRecord is a change of the rows of a table in a transaction, as
+// output by ReadChangeStream. See
+// https://cloud.google.com/spanner/docs/change-streams/details#data-change-records
+// for the meaning of each field.
+type DataChangeRecord struct {
+	// PartitionToken is the token of the partition of the change stream the
+	// record was read from.
+	PartitionToken                       string       `spanner:"-"`
+	CommitTimestamp                      time.Time    `spanner:"commit_timestamp"`
+	RecordSequence                       string       `spanner:"record_sequence"`
+	ServerTransactionID                  string       `spanner:"server_transaction_id"`
+	IsLastRecordInTransactionInPartition bool         `spanner:"is_last_record_in_transaction_in_partition"`
+	TableName                            string       `spanner:"table_name"`
+	ColumnTypes                          []ColumnType `spanner:"column_types"`
+	Mods                                 []Mod        `spanner:"mods"`
+	ModType                              string       `spanner:"mod_type"`
+	ValueCaptureType                     string       `spanner:"value_capture_type"`
+	NumberOfRecordsInTransaction         int64        `spanner:"number_of_records_in_transaction"`
+	NumberOfPartitionsInTransaction      int64        `spanner:"number_of_partitions_in_transaction"`
+	TransactionTag                       string       `spanner:"transaction_tag"`
+	IsSystemTransaction                  bool         `spanner:"is_system_transaction"`
+}
+
+// ColumnType is a column of the table of a DataChangeRecord. The type is the
+// JSON representation of the column's Spanner type.
+type ColumnType struct {
+	Name            string `spanner:"name"`
+	Type            string `spanner:"type"`
+	IsPrimaryKey    bool   `spanner:"is_primary_key"`
+	OrdinalPosition int64  `spanner:"ordinal_position"`
+}
+
+// Mod is the change of a row in a DataChangeRecord. The keys and values are
+// JSON objects by column name.
+type Mod struct {
+	Keys      string `spanner:"keys"`
+	NewValues string `spanner:"new_values"`
+	OldValues string `spanner:"old_values"`
+}
+
+// changeRecordRow is a row of a change stream query.
+type changeRecordRow struct {
+	ChangeRecord []changeRecord `spanner:"ChangeRecord"`
+}
+
+// changeRecord holds the records of a row of a change stream query.
+type changeRecord struct {
+	DataChangeRecord      []DataChangeRecord      `spanner:"data_change_record"`
+	HeartbeatRecord       []heartbeatRecord       `spanner:"heartbeat_record"`
+	ChildPartitionsRecord []childPartitionsRecord `spanner:"child_partitions_record"`
+}
+
+// heartbeatRecord signals that all changes of a partition up to its
+// timestamp have been returned.
+type heartbeatRecord struct {
+	Timestamp time.Time `spanner:"timestamp"`
+}
+
+// childPartitionsRecord returns the partitions that follow a partition from
+// the start timestamp, due to a split or a merge.
+type childPartitionsRecord struct {
+	StartTimestamp  time.Time        `spanner:"start_timestamp"`
+	RecordSequence  string           `spanner:"record_sequence"`
+	ChildPartitions []childPartition `spanner:"child_partitions"`
+}
+
+type childPartition struct {
+	Token                 string   `spanner:"token"`
+	ParentPartitionTokens []string `spanner:"parent_partition_tokens"`
+}
+
+// changeStreamOption holds the options of ReadChangeStream.
+type changeStreamOption struct {
+	End                time.Time
+	Heartbeat          time.Duration
+	CheckpointInterval time.Duration
+}
+
+// ChangeStreamOptionFn is an option for ReadChangeStream.
+type ChangeStreamOptionFn func(*changeStreamOption)
+
+// ChangeStreamEnd stops reading the change stream after the changes committed
+// at the given time, which makes the output bounded. The change stream is
+// read indefinitely by default.
+func ChangeStreamEnd(end time.Time) ChangeStreamOptionFn {
+	return func(o *changeStreamOption) {
+		o.End = end
+	}
+}
+
+// ChangeStreamHeartbeat sets how often idle partitions report their
+// progress, which bounds how long the watermark is held back by them. It
+// must be between 1s and 5m. The default is 10s.
+func ChangeStreamHeartbeat(d time.Duration) ChangeStreamOptionFn {
+	if d < time.Second || d > 5*time.Minute {
+		panic(fmt.Sprintf("spannerio.ChangeStreamHeartbeat heartbeat must be between 1s and 5m. Got: %v", d))
+	}
+	return func(o *changeStreamOption) {
+		o.Heartbeat = d
+	}
+}
+
+// ChangeStreamCheckpointInterval sets how long the change stream is read
+// before checkpointing, after which reading resumes from the watermark. The
+// default is 5m.
+func ChangeStreamCheckpointInterval(d time.Duration) ChangeStreamOptionFn {
+	if d <= 0 {
+		panic(fmt.Sprintf("spannerio.ChangeStreamCheckpointInterval interval must be positive. Got: %v", d))
+	}
+	return func(o *changeStreamOption) {
+		o.CheckpointInterval = d
+	}
+}
+
+// ReadChangeStream reads the changes of the given change stream committed
+// from the start time, and returns a PCollection<DataChangeRecord>, with
+// each record timestamped at its commit timestamp. For example:
+//
+//	records := spannerio.ReadChangeStream(s, db, "UsersStream", time.Now())
+//
+// The partitions of the change stream are discovered and read as Spanner
+// splits and merges them, and the partitions following a partition are only
+// read once all their parents have been read, so that the changes of a key
+// are output in commit order. The output watermark is the earliest commit
+// timestamp up to which a partition still being read, or not yet read, has
+// returned its changes.
+//
+// Reading is checkpointed at the watermark, so records committed at the
+// watermark may be output again after a checkpoint or a failure; they can be
+// deduplicated by their server transaction ID and record sequence.
+func ReadChangeStream(s beam.Scope, db, stream string, start time.Time, opts ...ChangeStreamOptionFn) beam.PCollection {
+	s = s.Scope("spannerio.ReadChangeStream")
+	mustParseDatabase(db)
+	if !streamRegexp.MatchString(stream) {
+		panic(fmt.Sprintf("spannerio.ReadChangeStream invalid change stream name %q", stream))
+	}
+	o := changeStreamOption{Heartbeat: defaultHeartbeat, CheckpointInterval: defaultCheckpointInterval}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	fn := &changeStreamFn{
+		Database:           db,
+		Stream:             stream,
+		Start:              start.UnixMicro(),
+		Heartbeat:          o.Heartbeat,
+		CheckpointInterval: o.CheckpointInterval,
+	}
+	if !o.End.IsZero() {
+		if o.End.Before(start) {
+			panic(fmt.Sprintf("spannerio.ReadChangeStream end must not be before start. Got: %v before %v", o.End, start))
+		}
+		fn.End = o.End.UnixMicro()
+	}
+	return beam.ParDo(s, fn, beam.Impulse(s))
+}
+
+// changeStreamFn reads a change stream. Its restriction is the range of
+// commit timestamps, in microseconds, left to read, which it claims as its
+// watermark advances.
+type changeStreamFn struct {
+	// Database is the name of the database.
+	Database string `json:"database"`
+	// Stream is the name of the change stream.
+	Stream string `json:"stream"`
+	// Start and End are the first and last commit timestamps to read, in
+	// microseconds. End is 0 if the change stream is read indefinitely.
+	Start int64 `json:"start"`
+	End   int64 `json:"end"`
+	// Heartbeat is the heartbeat interval of the partitions.
+	Heartbeat time.Duration `json:"heartbeat"`
+	// CheckpointInterval is how long to read before checkpointing.
+	CheckpointInterval time.Duration `json:"checkpoint_interval"`
+
+	conn   *grpc.ClientConn
+	client spannerpb.SpannerClient
+}
+
+func (f *changeStreamFn) Setup(ctx context.Context) error {
+	conn, err := dial(ctx)
+	if err != nil {
+		return err
+	}
+	f.conn = conn
+	f.client = spannerpb.NewSpannerClient(conn)
+	return nil
+}
+
+func (f *changeStreamFn) Teardown() error {
+	if f.conn == nil {
+		return nil
+	}
+	return f.conn.Close()
+}
+
+func (f *changeStreamFn) CreateInitialRestriction(_ []byte) offsetrange.Restriction {
+	end := int64(math.MaxInt64)
+	if f.End != 0 {
+		end = f.End + 1
+	}
+	return offsetrange.Restriction{Start: f.Start, End: end}
+}
+
+func (f *changeStreamFn) SplitRestriction(_ []byte, rest offsetrange.Restriction) []offsetrange.Restriction {
+	return []offsetrange.Restriction{rest}
+}
+
+func (f *changeStreamFn) RestrictionSize(_ []byte, rest offsetrange.Restriction) float64 {
+	return rest.Size()
+}
+
+func (f *changeStreamFn) CreateTracker(rest offsetrange.Restriction) *sdf.LockRTracker {
+	return sdf.NewLockRTracker(unbounded.NewTracker(rest))
+}
+
+func (f *changeStreamFn) InitialWatermarkEstimatorState(_ beam.EventTime, rest offsetrange.Restriction, _ []byte) int64 {
+	return rest.Start
+}
+
+func (f *changeStreamFn) CreateWatermarkEstimator(state int64) *sdf.ManualWatermarkEstimator {
+	return &sdf.ManualWatermarkEstimator{State: time.UnixMicro(state)}
+}
+
+func (f *changeStreamFn) WatermarkEstimatorState(e *sdf.ManualWatermarkEstimator) int64 {
+	return e.State.UnixMicro()
+}
+
+// streamPartition is a partition of a change stream.
+type streamPartition struct {
+	token    string   // Partition token, or "" for the initial query.
+	start    int64    // Commit timestamp from which the partition is read.
+	parents  []string // Tokens of the parent partitions.
+	progress int64    // Commit timestamp up to which changes were output.
+}
+
+// partitionEvent is the result of reading a partition, which is either some
+// of its records, its end, or its failure.
+type partitionEvent struct {
+	token    string
+	records  []DataChangeRecord
+	progress int64
+	children []*streamPartition
+	done     bool
+	err      error
+}
+
+func (f *changeStreamFn) ProcessElement(ctx context.Context, we *sdf.ManualWatermarkEstimator, rt *sdf.LockRTracker, _ []byte, emit func(beam.EventTime, DataChangeRecord)) (sdf.ProcessContinuation, error) {
+	rest := rt.GetRestriction().(offsetrange.Restriction)
+	if !rt.TryClaim(rest.Start) {
+		return sdf.StopProcessing(), nil
+	}
+
+	// Partitions are read concurrently, and send their records to this
+	// goroutine to be output.
+	ctx, cancel := context.WithCancel(ctx)
+	var wg sync.WaitGroup
+	defer func() {
+		cancel()
+		wg.Wait()
+	}()
+	events := make(chan partitionEvent)
+	active := make(map[string]*streamPartition)
+	pending := make(map[string]*streamPartition)
+	seen := make(map[string]bool)
+	read := func(p *streamPartition) {
+		active[p.token] = p
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			f.readPartition(ctx, p.token, p.start, events)
+		}()
+	}
+	read(&streamPartition{start: rest.Start, progress: rest.Start})
+
+	checkpoint := time.NewTimer(f.CheckpointInterval)
+	defer checkpoint.Stop()
+	claimed := rest.Start
+	for len(active) > 0 {
+		var ev partitionEvent
+		select {
+		case ev = <-events:
+		case <-checkpoint.C:
+			log.Infof(ctx, "Checkpointing change stream %v at %v", f.Stream, time.UnixMicro(claimed).UTC())
+			return sdf.ResumeProcessingIn(0), nil
+		}
+		if ev.err != nil {
+			return sdf.StopProcessing(), errors.Wrapf(ev.err, "reading partition %q of change stream %v", ev.token, f.Stream)
+		}
+
+		p := active[ev.token]
+		for _, r := range ev.records {
+			emit(mtime.FromTime(r.CommitTimestamp), r)
+		}
+		if ev.progress > p.progress {
+			p.progress = ev.progress
+		}
+		for _, c := range ev.children {
+			if !seen[c.token] {
+				seen[c.token] = true
+				c.progress = c.start
+				pending[c.token] = c
+			}
+		}
+		if ev.done {
+			delete(active, ev.token)
+		}
+
+		// A partition is read once all its parents have been read.
+		for token, c := range pending {
+			if !hasParent(c, active, pending) {
+				delete(pending, token)
+				read(c)
+			}
+		}
+
+		wm := rest.End
+		for _, p := range active {
+			if p.progress < wm {
+				wm = p.progress
+			}
+		}
+		for _, p := range pending {
+			if p.start < wm {
+				wm = p.start
+			}
+		}
+		if wm > claimed {
+			if !rt.TryClaim(wm) {
+				return sdf.StopProcessing(), nil
+			}
+			claimed = wm
+			we.UpdateWatermark(time.UnixMicro(wm))
+		}
+	}
+	// All partitions have been read up to the end.
+	rt.TryClaim(rest.End)
+	return sdf.StopProcessing(), nil
+}
+
+// hasParent returns whether any parent of the partition is active or
+// pending.
+func hasParent(p *streamPartition, active, pending map[string]*streamPartition) bool {
+	for _, parent := range p.parents {
+		if active[parent] != nil || pending[parent] != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// readPartition reads the partition from the start timestamp, and sends its
+// records to the events channel, until it ends or the context is done.
+// Queries that fail with a retryable error are retried from the last
+// progress of the partition.
+func (f *changeStreamFn) readPartition(ctx context.Context, token string, start int64, events chan<- partitionEvent) {
+	send := func(ev partitionEvent) bool {
+		ev.token = token
+		select {
+		case events <- ev:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+	for attempt := 0; ; attempt++ {
+		err := f.queryPartition(ctx, token, start, func(ev partitionEvent) bool {
+			if ev.progress > start {
+				start = ev.progress
+			}
+			return send(ev)
+		})
+		switch {
+		case ctx.Err() != nil:
+			return
+		case err == nil:
+			send(partitionEvent{done: true})
+			return
+		case attempt >= readRetries || !isRetryable(err):
+			send(partitionEvent{err: err})
+			return
+		}
+		log.Warnf(ctx, "Retrying read of partition %q of change stream %v: %v", token, f.Stream, err)
+		time.Sleep(time.Duration(1<<attempt) * time.Second)
+	}
+}
+
+// queryPartition queries the change records of the partition from the start
+// timestamp, and sends an event for each row. It stops if send returns false.
+func (f *changeStreamFn) queryPartition(ctx context.Context, token string, start int64, send func(partitionEvent) bool) error {
+	// Each query needs its own session, as a session runs a single
+	// transaction at a time.
+	session, err := createSession(ctx, f.client, f.Database)
+	if err != nil {
+		return err
+	}
+	defer f.client.DeleteSession(context.Background(), &spannerpb.DeleteSessionRequest{Name: session.GetName()})
+
+	stream, err := f.client.ExecuteStreamingSql(ctx, &spannerpb.ExecuteSqlRequest{
+		Session: session.GetName(),
+		Transaction: &spannerpb.TransactionSelector{Selector: &spannerpb.TransactionSelector_SingleUse{SingleUse: &spannerpb.TransactionOptions{
+			Mode: &spannerpb.TransactionOptions_ReadOnly_{ReadOnly: &spannerpb.TransactionOptions_ReadOnly{
+				TimestampBound: &spannerpb.TransactionOptions_ReadOnly_Strong{Strong: true},
+			}},
+		}}},
+		Sql:        fmt.Sprintf(changeStreamSQL, f.Stream),
+		Params:     f.params(token, start),
+		ParamTypes: changeStreamParamTypes,
+	})
+	if err != nil {
+		return err
+	}
+
+	var r resultReader
+	for {
+		set, err := stream.Recv()
+		if err == io.EOF {
+			rows, err := r.done()
+			if err != nil {
+				return err
+			}
+			return f.send(token, r.cols, rows, send)
+		}
+		if err != nil {
+			return err
+		}
+		rows, err := r.add(set)
+		if err != nil {
+			return err
+		}
+		// Queries are resumed from the progress of the partition rather than
+		// from resume tokens, so rows are sent as soon as they are complete.
+		rows = append(rows, r.rows()...)
+		if err := f.send(token, r.cols, rows, send); err != nil {
+			return err
+		}
+	}
+}
+
+// params returns the parameters of the query of the partition.
+func (f *changeStreamFn) params(token string, start int64) *structpb.Struct {
+	end, partition := structpb.NewNullValue(), structpb.NewNullValue()
+	if f.End != 0 {
+		end = structpb.NewStringValue(formatMicros(f.End))
+	}
+	if token != "" {
+		partition = structpb.NewStringValue(token)
+	}
+	return &structpb.Struct{Fields: map[string]*structpb.Value{
+		"start_timestamp":        structpb.NewStringValue(formatMicros(start)),
+		"end_timestamp":          end,
+		"partition_token":        partition,
+		"heartbeat_milliseconds": structpb.NewStringValue(strconv.FormatInt(f.Heartbeat.Milliseconds(), 10)),
+	}}
+}
+
+// send decodes the rows of the partition, and sends an event per row.
+func (f *changeStreamFn) send(token string, cols []*spannerpb.StructType_Field, rows [][]*structpb.Value, send func(partitionEvent) bool) error {
+	fields := columnFields(changeRecordRowType)
+	for _, values := range rows {
+		row, err := decodeRow(cols, values, changeRecordRowType, fields)
+		if err != nil {
+			return err
+		}
+		var ev partitionEvent
+		for _, cr := range row.Interface().(changeRecordRow).ChangeRecord {
+			for _, r := range cr.DataChangeRecord {
+				r.PartitionToken = token
+				ev.records = append(ev.records, r)
+				ev.progress = r.CommitTimestamp.UnixMicro()
+			}
+			for _, r := range cr.HeartbeatRecord {
+				ev.progress = r.Timestamp.UnixMicro()
+			}
+			for _, r := range cr.ChildPartitionsRecord {
+				ts := r.StartTimestamp.UnixMicro()
+				for _, c := range r.ChildPartitions {
+					ev.children = append(ev.children, &streamPartition{token: c.Token, start: ts, parents: c.ParentPartitionTokens})
+				}
+				ev.progress = ts
+			}
+		}
+		if !send(ev) {
+			return context.Canceled
+		}
+	}
+	return nil
+}
+
+// formatMicros formats the timestamp in microseconds as a Spanner TIMESTAMP.
+func formatMicros(micros int64) string {
+	return time.UnixMicro(micros).UTC().Format(time.RFC3339Nano)
+}
@@ -0,0 +1,259 @@
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements.  See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License.  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package spannerio
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/apache/beam/sdks/v2/go/pkg/beam"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/core/graph/mtime"
+	spannerpb "google.golang.org/genproto/googleapis/spanner/v1"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// serveChanges sends the change records of the queried partition, a row per
+// record.
+func (f *fakeServer) serveChanges(req *spannerpb.ExecuteSqlRequest, send func(*spannerpb.PartialResultSet) error) error {
+	token := req.GetParams().GetFields()["partition_token"].GetStringValue()
+	f.mu.Lock()
+	f.changeQueries = append(f.changeQueries, req.GetParams())
+	f.changeLog = append(f.changeLog, "start:"+token)
+	records := f.changes[token]
+	f.mu.Unlock()
+
+	md := &spannerpb.ResultSetMetadata{RowType: &spannerpb.StructType{Fields: []*spannerpb.StructType_Field{
+		{Name: "ChangeRecord", Type: changeRecordType},
+	}}}
+	for i, r := range records {
+		set := &spannerpb.PartialResultSet{Values: []*structpb.Value{list(r)}, ResumeToken: []byte{byte(i + 1)}}
+		set.Metadata, md = md, nil
+		if err := send(set); err != nil {
+			return err
+		}
+	}
+
+	f.mu.Lock()
+	f.changeLog = append(f.changeLog, "end:"+token)
+	f.mu.Unlock()
+	return nil
+}
+
+func list(vs ...*structpb.Value) *structpb.Value {
+	return structpb.NewListValue(&structpb.ListValue{Values: vs})
+}
+
+func arrayType(elem *spannerpb.Type) *spannerpb.Type {
+	return &spannerpb.Type{Code: spannerpb.TypeCode_ARRAY, ArrayElementType: elem}
+}
+
+func structType(fields ...*spannerpb.StructType_Field) *spannerpb.Type {
+	return &spannerpb.Type{Code: spannerpb.TypeCode_STRUCT, StructType: &spannerpb.StructType{Fields: fields}}
+}
+
+func field(name string, typ *spannerpb.Type) *spannerpb.StructType_Field {
+	return &spannerpb.StructType_Field{Name: name, Type: typ}
+}
+
+var (
+	stringType    = &spannerpb.Type{Code: spannerpb.TypeCode_STRING}
+	jsonType      = &spannerpb.Type{Code: spannerpb.TypeCode_JSON}
+	timestampType = &spannerpb.Type{Code: spannerpb.TypeCode_TIMESTAMP}
+
+	// changeRecordType is the type of the ChangeRecord column, with a subset
+	// of the fields of data change records.
+	changeRecordType = arrayType(structType(
+		field("data_change_record", arrayType(structType(
+			field("commit_timestamp", timestampType),
+			field("record_sequence", stringType),
+			field("server_transaction_id", stringType),
+			field("table_name", stringType),
+			field("mod_type", stringType),
+			field("mods", arrayType(structType(
+				field("keys", jsonType),
+				field("new_values", jsonType),
+				field("old_values", jsonType)))),
+		))),
+		field("heartbeat_record", arrayType(structType(
+			field("timestamp", timestampType),
+		))),
+		field("child_partitions_record", arrayType(structType(
+			field("start_timestamp", timestampType),
+			field("record_sequence", stringType),
+			field("child_partitions", arrayType(structType(
+				field("token", stringType),
+				field("parent_partition_tokens", arrayType(stringType))))),
+		))),
+	))
+)
+
+// dataRecord returns a change record of an insert of the key.
+func dataRecord(ts, key string) *structpb.Value {
+	str := structpb.NewStringValue
+	mod := list(str(key), str(`{"name":"a"}`), str("{}"))
+	return list(list(list(str(ts), str("00000000"), str("txn"), str("users"), str("INSERT"), list(mod))), list(), list())
+}
+
+func heartbeat(ts string) *structpb.Value {
+	return list(list(), list(list(structpb.NewStringValue(ts))), list())
+}
+
+// childRecord returns a change record of a child partition of the parents.
+func childRecord(ts, token string, parents ...string) *structpb.Value {
+	str := structpb.NewStringValue
+	var ps []*structpb.Value
+	for _, p := range parents {
+		ps = append(ps, str(p))
+	}
+	child := list(str(token), list(ps...))
+	return list(list(), list(), list(list(str(ts), str("00000000"), list(child))))
+}
+
+func TestReadChangeStream(t *testing.T) {
+	fake := startFakeServer(t, nil)
+	// The initial partitions p1 and p2 merge into p3.
+	fake.changes = map[string][]*structpb.Value{
+		"": {
+			childRecord("2022-05-01T10:00:00Z", "p1"),
+			childRecord("2022-05-01T10:00:00Z", "p2"),
+		},
+		"p1": {
+			dataRecord("2022-05-01T10:00:01Z", `{"id":"1"}`),
+			childRecord("2022-05-01T10:00:03Z", "p3", "p1", "p2"),
+		},
+		"p2": {
+			dataRecord("2022-05-01T10:00:02Z", `{"id":"2"}`),
+			heartbeat("2022-05-01T10:00:03Z"),
+			childRecord("2022-05-01T10:00:03Z", "p3", "p1", "p2"),
+		},
+		"p3": {
+			dataRecord("2022-05-01T10:00:04Z", `{"id":"3"}`),
+		},
+	}
+	start := time.Date(2022, 5, 1, 10, 0, 0, 0, time.UTC)
+
+	fn := &changeStreamFn{
+		Database:           testDB,
+		Stream:             "UsersStream",
+		Start:              start.UnixMicro(),
+		End:                start.Add(10 * time.Second).UnixMicro(),
+		Heartbeat:          defaultHeartbeat,
+		CheckpointInterval: defaultCheckpointInterval,
+	}
+	ctx := context.Background()
+	if err := fn.Setup(ctx); err != nil {
+		t.Fatalf("Setup() failed: %v", err)
+	}
+	defer fn.Teardown()
+	rest := fn.CreateInitialRestriction(nil)
+	rt := fn.CreateTracker(rest)
+	we := fn.CreateWatermarkEstimator(fn.InitialWatermarkEstimatorState(mtime.ZeroTimestamp, rest, nil))
+	var keys []string
+	pc, err := fn.ProcessElement(ctx, we, rt, nil, func(et beam.EventTime, r DataChangeRecord) {
+		if !et.ToTime().Equal(r.CommitTimestamp) {
+			t.Errorf("ProcessElement() output %v at %v, want its commit timestamp", r, et.ToTime())
+		}
+		keys = append(keys, r.PartitionToken+" "+r.TableName+" "+r.ModType+" "+r.Mods[0].Keys)
+	})
+	if err != nil {
+		t.Fatalf("ProcessElement() failed: %v", err)
+	}
+	if pc.ShouldResume() || !rt.IsDone() {
+		t.Errorf("ProcessElement() = %v with restriction %v, want it done", pc, rt.GetRestriction())
+	}
+	sort.Strings(keys[:2])
+	want := []string{
+		`p1 users INSERT {"id":"1"}`,
+		`p2 users INSERT {"id":"2"}`,
+		`p3 users INSERT {"id":"3"}`,
+	}
+	if !reflect.DeepEqual(keys, want) {
+		t.Errorf("ProcessElement() output %v, want %v", keys, want)
+	}
+	if got, want := we.CurrentWatermark(), start.Add(4*time.Second); !got.Equal(want) {
+		t.Errorf("ProcessElement() watermark = %v, want %v", got, want)
+	}
+
+	index := func(event string) int {
+		for i, e := range fake.changeLog {
+			if e == event {
+				return i
+			}
+		}
+		t.Fatalf("change stream queries %v, want %v", fake.changeLog, event)
+		return -1
+	}
+	if len(fake.changeQueries) != 4 {
+		t.Errorf("ReadChangeStream() made %v queries, want 4: %v", len(fake.changeQueries), fake.changeLog)
+	}
+	if i := index("start:p3"); i < index("end:p1") || i < index("end:p2") {
+		t.Errorf("ReadChangeStream() read p3 before its parents: %v", fake.changeLog)
+	}
+	for _, q := range fake.changeQueries {
+		params := q.GetFields()
+		if params["partition_token"].GetStringValue() != "p3" {
+			continue
+		}
+		if got, want := params["start_timestamp"].GetStringValue(), "2022-05-01T10:00:03Z"; got != want {
+			t.Errorf("ReadChangeStream() read p3 from %v, want %v", got, want)
+		}
+		if got, want := params["end_timestamp"].GetStringValue(), "2022-05-01T10:00:10Z"; got != want {
+			t.Errorf("ReadChangeStream() read p3 until %v, want %v", got, want)
+		}
+	}
+}
+
+func TestChangeStreamFn_Params(t *testing.T) {
+	fn := &changeStreamFn{Stream: "UsersStream", Heartbeat: 2 * time.Second}
+	params := fn.params("", time.Date(2022, 5, 1, 10, 0, 0, 1000, time.UTC).UnixMicro()).GetFields()
+	if got, want := params["start_timestamp"].GetStringValue(), "2022-05-01T10:00:00.000001Z"; got != want {
+		t.Errorf("params() start_timestamp = %v, want %v", got, want)
+	}
+	for _, name := range []string{"end_timestamp", "partition_token"} {
+		if _, ok := params[name].GetKind().(*structpb.Value_NullValue); !ok {
+			t.Errorf("params() %v = %v, want NULL", name, params[name])
+		}
+	}
+	if got, want := params["heartbeat_milliseconds"].GetStringValue(), "2000"; got != want {
+		t.Errorf("params() heartbeat_milliseconds = %v, want %v", got, want)
+	}
+	for name := range params {
+		if !strings.Contains(changeStreamSQL, "@"+name) || changeStreamParamTypes[name] == nil {
+			t.Errorf("params() has parameter %v, which the query doesn't", name)
+		}
+	}
+}
+
+func TestChangeStreamFn_CreateTracker(t *testing.T) {
+	fn := &changeStreamFn{Start: 1}
+	if fn.CreateTracker(fn.CreateInitialRestriction(nil)).IsBounded() {
+		t.Error("CreateTracker() is bounded, want unbounded so that draining stops reads")
+	}
+}
+
+func TestReadChangeStream_InvalidStream(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("ReadChangeStream() with an invalid change stream name succeeded, want panic")
+		}
+	}()
+	_, s := beam.NewPipelineWithRoot()
+	ReadChangeStream(s, testDB, "Users; DROP TABLE Users", time.Now())
+}
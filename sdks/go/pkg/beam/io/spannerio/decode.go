@@ -0,0 +1,376 @@
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements.  See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License.  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package spannerio
+
+import (
+	"encoding/base64"
+	"math"
+	"math/big"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/civil"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/internal/errors"
+	spannerpb "google.golang.org/genproto/googleapis/spanner/v1"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+var (
+	timeType = reflect.TypeOf(time.Time{})
+	dateType = reflect.TypeOf(civil.Date{})
+	ratType  = reflect.TypeOf(big.Rat{})
+)
+
+// columnName returns the column of the struct field, which is named by its
+// spanner tag or its name, or "" if the field isn't a column.
+func columnName(f reflect.StructField) string {
+	if f.PkgPath != "" {
+		return "" // unexported
+	}
+	name := f.Tag.Get("spanner")
+	if name == "-" {
+		return ""
+	}
+	if name == "" {
+		name = f.Name
+	}
+	return name
+}
+
+// columns returns the columns of the fields of the struct type, in order.
+func columns(t reflect.Type) []string {
+	var cols []string
+	for i := 0; i < t.NumField(); i++ {
+		if name := columnName(t.Field(i)); name != "" {
+			cols = append(cols, name)
+		}
+	}
+	return cols
+}
+
+// columnFields returns the index of the field of each column of the struct
+// type, by lowercased column name, as Spanner column names are case
+// insensitive.
+func columnFields(t reflect.Type) map[string]int {
+	fields := make(map[string]int)
+	for i := 0; i < t.NumField(); i++ {
+		if name := columnName(t.Field(i)); name != "" {
+			fields[strings.ToLower(name)] = i
+		}
+	}
+	return fields
+}
+
+// decodeRow decodes the values of the columns into a new value of the struct
+// type. Columns without a field are ignored.
+func decodeRow(cols []*spannerpb.StructType_Field, values []*structpb.Value, t reflect.Type, fields map[string]int) (reflect.Value, error) {
+	row := reflect.New(t).Elem()
+	for i, col := range cols {
+		j, ok := fields[strings.ToLower(col.GetName())]
+		if !ok {
+			continue
+		}
+		if err := decodeValue(values[i], col.GetType(), row.Field(j)); err != nil {
+			return reflect.Value{}, errors.WithContextf(err, "decoding column %v", col.GetName())
+		}
+	}
+	return row, nil
+}
+
+// decodeValue decodes the value of the Spanner type into dst. NULL values
+// decode as the zero value, so nullable columns should have pointer fields.
+func decodeValue(v *structpb.Value, typ *spannerpb.Type, dst reflect.Value) error {
+	if _, ok := v.GetKind().(*structpb.Value_NullValue); ok {
+		dst.Set(reflect.Zero(dst.Type()))
+		return nil
+	}
+	if dst.Kind() == reflect.Ptr {
+		elem := reflect.New(dst.Type().Elem())
+		if err := decodeValue(v, typ, elem.Elem()); err != nil {
+			return err
+		}
+		dst.Set(elem)
+		return nil
+	}
+
+	switch typ.GetCode() {
+	case spannerpb.TypeCode_BOOL:
+		if dst.Kind() == reflect.Bool {
+			dst.SetBool(v.GetBoolValue())
+			return nil
+		}
+	case spannerpb.TypeCode_INT64:
+		switch dst.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			n, err := strconv.ParseInt(v.GetStringValue(), 10, 64)
+			if err != nil {
+				return err
+			}
+			if dst.OverflowInt(n) {
+				return errors.Errorf("value %v overflows %v", n, dst.Type())
+			}
+			dst.SetInt(n)
+			return nil
+		}
+	case spannerpb.TypeCode_FLOAT64:
+		switch dst.Kind() {
+		case reflect.Float32, reflect.Float64:
+			f, err := decodeFloat(v)
+			if err != nil {
+				return err
+			}
+			dst.SetFloat(f)
+			return nil
+		}
+	case spannerpb.TypeCode_STRING, spannerpb.TypeCode_JSON:
+		if dst.Kind() == reflect.String {
+			dst.SetString(v.GetStringValue())
+			return nil
+		}
+	case spannerpb.TypeCode_BYTES:
+		if dst.Kind() == reflect.Slice && dst.Type().Elem().Kind() == reflect.Uint8 {
+			b, err := base64.StdEncoding.DecodeString(v.GetStringValue())
+			if err != nil {
+				return err
+			}
+			dst.SetBytes(b)
+			return nil
+		}
+	case spannerpb.TypeCode_TIMESTAMP:
+		if dst.Type() == timeType {
+			ts, err := time.Parse(time.RFC3339Nano, v.GetStringValue())
+			if err != nil {
+				return err
+			}
+			dst.Set(reflect.ValueOf(ts.UTC()))
+			return nil
+		}
+	case spannerpb.TypeCode_DATE:
+		if dst.Type() == dateType {
+			d, err := civil.ParseDate(v.GetStringValue())
+			if err != nil {
+				return err
+			}
+			dst.Set(reflect.ValueOf(d))
+			return nil
+		}
+	case spannerpb.TypeCode_NUMERIC:
+		switch {
+		case dst.Type() == ratType:
+			r, ok := new(big.Rat).SetString(v.GetStringValue())
+			if !ok {
+				return errors.Errorf("invalid NUMERIC value %q", v.GetStringValue())
+			}
+			dst.Set(reflect.ValueOf(*r))
+			return nil
+		case dst.Kind() == reflect.String:
+			dst.SetString(v.GetStringValue())
+			return nil
+		}
+	case spannerpb.TypeCode_ARRAY:
+		if dst.Kind() == reflect.Slice {
+			elems := v.GetListValue().GetValues()
+			s := reflect.MakeSlice(dst.Type(), len(elems), len(elems))
+			for i, elem := range elems {
+				if err := decodeValue(elem, typ.GetArrayElementType(), s.Index(i)); err != nil {
+					return err
+				}
+			}
+			dst.Set(s)
+			return nil
+		}
+	case spannerpb.TypeCode_STRUCT:
+		if dst.Kind() == reflect.Struct {
+			row, err := decodeRow(typ.GetStructType().GetFields(), v.GetListValue().GetValues(), dst.Type(), columnFields(dst.Type()))
+			if err != nil {
+				return err
+			}
+			dst.Set(row)
+			return nil
+		}
+	}
+	return errors.Errorf("can't decode Spanner %v into %v", typ.GetCode(), dst.Type())
+}
+
+// decodeFloat decodes a FLOAT64 value, which is a number, or a string for
+// NaN and infinities.
+func decodeFloat(v *structpb.Value) (float64, error) {
+	switch k := v.GetKind().(type) {
+	case *structpb.Value_NumberValue:
+		return k.NumberValue, nil
+	case *structpb.Value_StringValue:
+		switch k.StringValue {
+		case "NaN":
+			return math.NaN(), nil
+		case "Infinity":
+			return math.Inf(1), nil
+		case "-Infinity":
+			return math.Inf(-1), nil
+		}
+	}
+	return 0, errors.Errorf("invalid FLOAT64 value %v", v)
+}
+
+// mergeChunk merges a value chunked across partial result sets with its
+// continuation in the next one, following the rules of PartialResultSet.
+// Strings are concatenated, and lists are concatenated, merging the last
+// element of the first with the first of the second if they are strings or
+// lists. Fields of structs are combined, merging the fields in both.
+func mergeChunk(a, b *structpb.Value) (*structpb.Value, error) {
+	switch ak := a.GetKind().(type) {
+	case *structpb.Value_StringValue:
+		if bk, ok := b.GetKind().(*structpb.Value_StringValue); ok {
+			return structpb.NewStringValue(ak.StringValue + bk.StringValue), nil
+		}
+	case *structpb.Value_ListValue:
+		bk, ok := b.GetKind().(*structpb.Value_ListValue)
+		if !ok {
+			break
+		}
+		as, bs := ak.ListValue.GetValues(), bk.ListValue.GetValues()
+		if len(as) == 0 || len(bs) == 0 {
+			return structpb.NewListValue(&structpb.ListValue{Values: append(append([]*structpb.Value{}, as...), bs...)}), nil
+		}
+		last, first := as[len(as)-1], bs[0]
+		merged := append([]*structpb.Value{}, as[:len(as)-1]...)
+		switch last.GetKind().(type) {
+		case *structpb.Value_StringValue, *structpb.Value_ListValue:
+			m, err := mergeChunk(last, first)
+			if err != nil {
+				return nil, err
+			}
+			merged = append(merged, m)
+		default:
+			merged = append(merged, last, first)
+		}
+		merged = append(merged, bs[1:]...)
+		return structpb.NewListValue(&structpb.ListValue{Values: merged}), nil
+	case *structpb.Value_StructValue:
+		bk, ok := b.GetKind().(*structpb.Value_StructValue)
+		if !ok {
+			break
+		}
+		fields := make(map[string]*structpb.Value)
+		for k, v := range ak.StructValue.GetFields() {
+			fields[k] = v
+		}
+		for k, v := range bk.StructValue.GetFields() {
+			if f, ok := fields[k]; ok {
+				m, err := mergeChunk(f, v)
+				if err != nil {
+					return nil, err
+				}
+				v = m
+			}
+			fields[k] = v
+		}
+		return structpb.NewStructValue(&structpb.Struct{Fields: fields}), nil
+	}
+	return nil, errors.Errorf("can't merge chunked value %v with %v", a, b)
+}
+
+// resultReader reads the rows of a stream of partial result sets. Rows are
+// returned once the server sends a resume token after them, so that reading
+// can be resumed from the last token without returning rows twice.
+type resultReader struct {
+	cols    []*spannerpb.StructType_Field
+	values  []*structpb.Value // Values of the rows not yet returned.
+	chunked bool              // Whether the last value is chunked.
+	token   []byte            // The last resume token.
+
+	// The number of values, the last value and whether it's chunked, as of
+	// the last resume token.
+	tokenValues  int
+	tokenLast    *structpb.Value
+	tokenChunked bool
+}
+
+// add adds the partial result set, and returns the rows it completes up to
+// its resume token, if any.
+func (r *resultReader) add(set *spannerpb.PartialResultSet) ([][]*structpb.Value, error) {
+	if md := set.GetMetadata(); md != nil {
+		r.cols = md.GetRowType().GetFields()
+	}
+	values := set.GetValues()
+	if r.chunked && len(values) > 0 {
+		merged, err := mergeChunk(r.values[len(r.values)-1], values[0])
+		if err != nil {
+			return nil, err
+		}
+		r.values[len(r.values)-1] = merged
+		values = values[1:]
+	}
+	if len(set.GetValues()) > 0 {
+		// A set without values, such as one with only stats, leaves the
+		// chunked value to the next one.
+		r.values = append(r.values, values...)
+		r.chunked = set.GetChunkedValue()
+	}
+
+	if len(set.GetResumeToken()) == 0 {
+		return nil, nil
+	}
+	r.token = set.GetResumeToken()
+	rows := r.rows()
+	r.tokenValues, r.tokenChunked = len(r.values), r.chunked
+	if r.chunked {
+		r.tokenLast = r.values[len(r.values)-1]
+	}
+	return rows, nil
+}
+
+// done returns the remaining rows at the end of the stream.
+func (r *resultReader) done() ([][]*structpb.Value, error) {
+	if r.chunked {
+		return nil, errors.New("stream ended with a chunked value")
+	}
+	if len(r.cols) > 0 && len(r.values)%len(r.cols) != 0 {
+		return nil, errors.Errorf("stream ended in the middle of a row")
+	}
+	return r.rows(), nil
+}
+
+// reset discards the values after the last resume token, to resume reading
+// from it.
+func (r *resultReader) reset() {
+	r.values = r.values[:r.tokenValues]
+	r.chunked = r.tokenChunked
+	if r.chunked {
+		// The chunked value may have been merged since.
+		r.values[len(r.values)-1] = r.tokenLast
+	}
+}
+
+// rows returns and removes the complete rows.
+func (r *resultReader) rows() [][]*structpb.Value {
+	n := len(r.cols)
+	if n == 0 {
+		return nil
+	}
+	end := len(r.values) / n * n
+	if r.chunked && end == len(r.values) {
+		// The last row isn't complete until its chunked value is.
+		end -= n
+	}
+	var rows [][]*structpb.Value
+	for i := 0; i < end; i += n {
+		rows = append(rows, r.values[i:i+n])
+	}
+	r.values = append([]*structpb.Value{}, r.values[end:]...)
+	return rows
+}
@@ -0,0 +1,262 @@
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements.  See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License.  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package spannerio
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"math/big"
+	"reflect"
+	"strconv"
+	"time"
+
+	"cloud.google.com/go/civil"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/internal/errors"
+	spannerpb "google.golang.org/genproto/googleapis/spanner/v1"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+var (
+	mutationGroupType       = reflect.TypeOf((*MutationGroup)(nil)).Elem()
+	failedMutationGroupType = reflect.TypeOf((*FailedMutationGroup)(nil)).Elem()
+)
+
+func init() {
+	beam.RegisterCoder(mutationGroupType, encodeMutationGroup, decodeMutationGroup)
+	beam.RegisterCoder(failedMutationGroupType, encodeFailedMutationGroup, decodeFailedMutationGroup)
+}
+
+// MutationGroup is a group of mutations that are applied atomically, in
+// the same transaction. Mutations are created with Insert, Update,
+// InsertOrUpdate, Replace and Delete.
+type MutationGroup struct {
+	Mutations []*spannerpb.Mutation
+}
+
+// NewMutationGroup returns a group of the mutations.
+func NewMutationGroup(ms ...*spannerpb.Mutation) MutationGroup {
+	return MutationGroup{Mutations: ms}
+}
+
+// FailedMutationGroup is a mutation group that couldn't be applied.
+type FailedMutationGroup struct {
+	// Group is the mutation group.
+	Group MutationGroup
+	// Error describes why the group couldn't be applied.
+	Error string
+}
+
+// encodeMutationGroup encodes the mutations as those of a commit request.
+func encodeMutationGroup(g MutationGroup) ([]byte, error) {
+	return proto.Marshal(&spannerpb.CommitRequest{Mutations: g.Mutations})
+}
+
+func decodeMutationGroup(data []byte) (MutationGroup, error) {
+	var req spannerpb.CommitRequest
+	if err := proto.Unmarshal(data, &req); err != nil {
+		return MutationGroup{}, err
+	}
+	return MutationGroup{Mutations: req.GetMutations()}, nil
+}
+
+// encodeFailedMutationGroup encodes the error, prefixed by its length,
+// followed by the group.
+func encodeFailedMutationGroup(f FailedMutationGroup) ([]byte, error) {
+	group, err := encodeMutationGroup(f.Group)
+	if err != nil {
+		return nil, err
+	}
+	data := make([]byte, binary.MaxVarintLen64, binary.MaxVarintLen64+len(f.Error)+len(group))
+	data = data[:binary.PutUvarint(data, uint64(len(f.Error)))]
+	data = append(data, f.Error...)
+	return append(data, group...), nil
+}
+
+func decodeFailedMutationGroup(data []byte) (FailedMutationGroup, error) {
+	n, k := binary.Uvarint(data)
+	if k <= 0 || uint64(len(data)-k) < n {
+		return FailedMutationGroup{}, errors.New("invalid failed mutation group")
+	}
+	group, err := decodeMutationGroup(data[k+int(n):])
+	if err != nil {
+		return FailedMutationGroup{}, err
+	}
+	return FailedMutationGroup{Group: group, Error: string(data[k : k+int(n)])}, nil
+}
+
+// Insert returns a mutation that inserts a row with the values of the
+// columns, which fails if the row exists. Values are Go values of the
+// column types, such as int64, string, []byte, time.Time, civil.Date,
+// big.Rat, slices of those for arrays, or nil for NULL. Panics if a value
+// has an unsupported type.
+func Insert(table string, cols []string, vals []interface{}) *spannerpb.Mutation {
+	return &spannerpb.Mutation{Operation: &spannerpb.Mutation_Insert{Insert: write("spannerio.Insert", table, cols, vals)}}
+}
+
+// Update returns a mutation that updates the columns of an existing row,
+// which fails if the row doesn't exist. The columns must include the key.
+// See Insert for the supported values.
+func Update(table string, cols []string, vals []interface{}) *spannerpb.Mutation {
+	return &spannerpb.Mutation{Operation: &spannerpb.Mutation_Update{Update: write("spannerio.Update", table, cols, vals)}}
+}
+
+// InsertOrUpdate returns a mutation that inserts a row, or updates the
+// columns of the row if it exists. See Insert for the supported values.
+func InsertOrUpdate(table string, cols []string, vals []interface{}) *spannerpb.Mutation {
+	return &spannerpb.Mutation{Operation: &spannerpb.Mutation_InsertOrUpdate{InsertOrUpdate: write("spannerio.InsertOrUpdate", table, cols, vals)}}
+}
+
+// Replace returns a mutation that inserts a row, or replaces the row if it
+// exists, so that the columns not given are NULL. See Insert for the
+// supported values.
+func Replace(table string, cols []string, vals []interface{}) *spannerpb.Mutation {
+	return &spannerpb.Mutation{Operation: &spannerpb.Mutation_Replace{Replace: write("spannerio.Replace", table, cols, vals)}}
+}
+
+// Delete returns a mutation that deletes the row with the key, which is
+// given as the values of its columns, in order. Deleting a row that doesn't
+// exist succeeds. See Insert for the supported values.
+func Delete(table string, key ...interface{}) *spannerpb.Mutation {
+	list, err := encodeList(key)
+	if err != nil {
+		panic(fmt.Sprintf("spannerio.Delete invalid key of %v: %v", table, err))
+	}
+	return &spannerpb.Mutation{Operation: &spannerpb.Mutation_Delete_{Delete: &spannerpb.Mutation_Delete{
+		Table:  table,
+		KeySet: &spannerpb.KeySet{Keys: []*structpb.ListValue{list}},
+	}}}
+}
+
+// write returns the write of a row with the values of the columns.
+func write(name, table string, cols []string, vals []interface{}) *spannerpb.Mutation_Write {
+	if len(cols) != len(vals) {
+		panic(fmt.Sprintf("%v got %v values for %v columns of %v", name, len(vals), len(cols), table))
+	}
+	list, err := encodeList(vals)
+	if err != nil {
+		panic(fmt.Sprintf("%v invalid values of %v: %v", name, table, err))
+	}
+	return &spannerpb.Mutation_Write{Table: table, Columns: cols, Values: []*structpb.ListValue{list}}
+}
+
+func encodeList(vals []interface{}) (*structpb.ListValue, error) {
+	list := &structpb.ListValue{Values: make([]*structpb.Value, len(vals))}
+	for i, v := range vals {
+		ev, err := encodeValue(reflect.ValueOf(v))
+		if err != nil {
+			return nil, err
+		}
+		list.Values[i] = ev
+	}
+	return list, nil
+}
+
+// encodeValue encodes the Go value as a Spanner value, as decoded by
+// decodeValue.
+func encodeValue(v reflect.Value) (*structpb.Value, error) {
+	if !v.IsValid() {
+		return structpb.NewNullValue(), nil
+	}
+	switch v.Type() {
+	case timeType:
+		return structpb.NewStringValue(v.Interface().(time.Time).UTC().Format(time.RFC3339Nano)), nil
+	case dateType:
+		return structpb.NewStringValue(v.Interface().(civil.Date).String()), nil
+	case ratType:
+		r := v.Interface().(big.Rat)
+		return structpb.NewStringValue(r.FloatString(9)), nil
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return structpb.NewNullValue(), nil
+		}
+		return encodeValue(v.Elem())
+	case reflect.Bool:
+		return structpb.NewBoolValue(v.Bool()), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return structpb.NewStringValue(strconv.FormatInt(v.Int(), 10)), nil
+	case reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return structpb.NewStringValue(strconv.FormatUint(v.Uint(), 10)), nil
+	case reflect.Float32, reflect.Float64:
+		f := v.Float()
+		switch {
+		case math.IsNaN(f):
+			return structpb.NewStringValue("NaN"), nil
+		case math.IsInf(f, 1):
+			return structpb.NewStringValue("Infinity"), nil
+		case math.IsInf(f, -1):
+			return structpb.NewStringValue("-Infinity"), nil
+		}
+		return structpb.NewNumberValue(f), nil
+	case reflect.String:
+		return structpb.NewStringValue(v.String()), nil
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return structpb.NewStringValue(base64.StdEncoding.EncodeToString(v.Bytes())), nil
+		}
+		if v.IsNil() {
+			return structpb.NewNullValue(), nil
+		}
+		list := &structpb.ListValue{Values: make([]*structpb.Value, v.Len())}
+		for i := 0; i < v.Len(); i++ {
+			ev, err := encodeValue(v.Index(i))
+			if err != nil {
+				return nil, err
+			}
+			list.Values[i] = ev
+		}
+		return structpb.NewListValue(list), nil
+	}
+	return nil, errors.Errorf("can't encode %v as a Spanner value", v.Type())
+}
+
+// mutationCount returns the number of cells the mutation writes, or the
+// number of keys it deletes, which Spanner limits per commit.
+func mutationCount(m *spannerpb.Mutation) int {
+	if d := m.GetDelete(); d != nil {
+		return len(d.GetKeySet().GetKeys()) + len(d.GetKeySet().GetRanges())
+	}
+	w := mutationWrite(m)
+	return len(w.GetColumns()) * len(w.GetValues())
+}
+
+// mutationRows returns the number of rows the mutation writes or deletes.
+func mutationRows(m *spannerpb.Mutation) int {
+	if d := m.GetDelete(); d != nil {
+		return len(d.GetKeySet().GetKeys()) + len(d.GetKeySet().GetRanges())
+	}
+	return len(mutationWrite(m).GetValues())
+}
+
+func mutationWrite(m *spannerpb.Mutation) *spannerpb.Mutation_Write {
+	switch op := m.GetOperation().(type) {
+	case *spannerpb.Mutation_Insert:
+		return op.Insert
+	case *spannerpb.Mutation_Update:
+		return op.Update
+	case *spannerpb.Mutation_InsertOrUpdate:
+		return op.InsertOrUpdate
+	case *spannerpb.Mutation_Replace:
+		return op.Replace
+	}
+	return nil
+}
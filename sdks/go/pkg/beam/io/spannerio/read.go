@@ -0,0 +1,339 @@
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements.  See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License.  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package spannerio
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"reflect"
+	"time"
+
+	"github.com/apache/beam/sdks/v2/go/pkg/beam"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/internal/errors"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/log"
+	spannerpb "google.golang.org/genproto/googleapis/spanner/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// readRetries is the number of times reading a partition is retried after
+// a retryable error, resuming from the last resume token.
+const readRetries = 3
+
+func init() {
+	beam.RegisterType(reflect.TypeOf((*partition)(nil)).Elem())
+	beam.RegisterType(reflect.TypeOf((*partitionFn)(nil)).Elem())
+	beam.RegisterType(reflect.TypeOf((*readPartitionFn)(nil)).Elem())
+}
+
+// readOption holds the options of Read and Query.
+type readOption struct {
+	Staleness     time.Duration
+	Timestamp     time.Time
+	MaxPartitions int64
+}
+
+// ReadOptionFn is an option for Read and Query.
+type ReadOptionFn func(*readOption)
+
+// ReadExactStaleness reads the data as of the given time in the past, which
+// avoids waiting for in-progress transactions. Reads are strong by default.
+func ReadExactStaleness(d time.Duration) ReadOptionFn {
+	if d < 0 {
+		panic(fmt.Sprintf("spannerio.ReadExactStaleness staleness must be non-negative. Got: %v", d))
+	}
+	return func(o *readOption) {
+		o.Staleness = d
+		o.Timestamp = time.Time{}
+	}
+}
+
+// ReadTimestamp reads the data as of the given timestamp, which must be
+// within the version retention period of the database. Reads are strong by
+// default.
+func ReadTimestamp(ts time.Time) ReadOptionFn {
+	return func(o *readOption) {
+		o.Timestamp = ts
+		o.Staleness = 0
+	}
+}
+
+// ReadMaxPartitions hints the maximum number of partitions the data is read
+// in. Spanner chooses the number of partitions by default.
+func ReadMaxPartitions(n int64) ReadOptionFn {
+	if n < 1 {
+		panic(fmt.Sprintf("spannerio.ReadMaxPartitions max partitions must be positive. Got: %v", n))
+	}
+	return func(o *readOption) {
+		o.MaxPartitions = n
+	}
+}
+
+// Read reads all rows of the given table, and returns a PCollection<t>. The
+// columns read are those of the fields of t, named by their spanner tag or
+// their name. The table is read in partitions generated by Spanner, which are
+// read in parallel, all at the same timestamp.
+func Read(s beam.Scope, db, table string, t reflect.Type, opts ...ReadOptionFn) beam.PCollection {
+	s = s.Scope("spannerio.Read")
+	mustParseDatabase(db)
+
+	return read(s, &partitionFn{Database: db, Table: table, Columns: columns(t)}, t, opts)
+}
+
+// Query executes a query, and returns a PCollection<t> of its rows. The
+// columns of the rows are decoded into the fields of t, named by their
+// spanner tag or their name. The query must be partitionable, which is the
+// case if the first operator of its plan is a distributed union; see
+// https://cloud.google.com/spanner/docs/reads#read_data_in_parallel. The
+// partitions of the query are read in parallel, all at the same timestamp.
+func Query(s beam.Scope, db, q string, t reflect.Type, opts ...ReadOptionFn) beam.PCollection {
+	s = s.Scope("spannerio.Query")
+	mustParseDatabase(db)
+
+	return read(s, &partitionFn{Database: db, SQL: q}, t, opts)
+}
+
+func read(s beam.Scope, fn *partitionFn, t reflect.Type, opts []ReadOptionFn) beam.PCollection {
+	var o readOption
+	for _, opt := range opts {
+		opt(&o)
+	}
+	fn.Options = o
+
+	imp := beam.Impulse(s)
+	partitions := beam.ParDo(s, fn, imp)
+	shuffled := beam.Reshuffle(s, partitions)
+	return beam.ParDo(s,
+		&readPartitionFn{Database: fn.Database, Table: fn.Table, Columns: fn.Columns, SQL: fn.SQL, Type: beam.EncodedType{T: t}},
+		shuffled,
+		beam.TypeDefinition{Var: beam.XType, T: t},
+	)
+}
+
+// partition is a partition of a read or query.
+type partition struct {
+	// Session is the session of the transaction.
+	Session string
+	// Transaction is the ID of the read-only transaction.
+	Transaction []byte
+	// Token is the partition token.
+	Token []byte
+}
+
+// partitionFn partitions a read or a query, in a new read-only transaction.
+type partitionFn struct {
+	// Database is the name of the database.
+	Database string `json:"database"`
+	// Table and Columns are the table and columns of a read.
+	Table   string   `json:"table"`
+	Columns []string `json:"columns"`
+	// SQL is the query, if any.
+	SQL string `json:"sql"`
+	// Options specifies the timestamp bound and partitioning.
+	Options readOption `json:"options"`
+}
+
+// timestampBound returns the timestamp bound of the options.
+func (f *partitionFn) timestampBound() *spannerpb.TransactionOptions_ReadOnly {
+	ro := &spannerpb.TransactionOptions_ReadOnly{ReturnReadTimestamp: true}
+	switch {
+	case !f.Options.Timestamp.IsZero():
+		ro.TimestampBound = &spannerpb.TransactionOptions_ReadOnly_ReadTimestamp{ReadTimestamp: timestamppb.New(f.Options.Timestamp)}
+	case f.Options.Staleness > 0:
+		ro.TimestampBound = &spannerpb.TransactionOptions_ReadOnly_ExactStaleness{ExactStaleness: durationpb.New(f.Options.Staleness)}
+	default:
+		ro.TimestampBound = &spannerpb.TransactionOptions_ReadOnly_Strong{Strong: true}
+	}
+	return ro
+}
+
+func (f *partitionFn) ProcessElement(ctx context.Context, _ []byte, emit func(partition)) error {
+	conn, err := dial(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	client := spannerpb.NewSpannerClient(conn)
+
+	// The session and transaction are shared by the readers of the
+	// partitions. Spanner deletes the session once it's idle for an hour.
+	session, err := createSession(ctx, client, f.Database)
+	if err != nil {
+		return err
+	}
+	txn, err := client.BeginTransaction(ctx, &spannerpb.BeginTransactionRequest{
+		Session: session.GetName(),
+		Options: &spannerpb.TransactionOptions{Mode: &spannerpb.TransactionOptions_ReadOnly_{ReadOnly: f.timestampBound()}},
+	})
+	if err != nil {
+		return errors.Wrapf(err, "beginning read-only transaction in %v", f.Database)
+	}
+	selector := &spannerpb.TransactionSelector{Selector: &spannerpb.TransactionSelector_Id{Id: txn.GetId()}}
+	var opts *spannerpb.PartitionOptions
+	if f.Options.MaxPartitions > 0 {
+		opts = &spannerpb.PartitionOptions{MaxPartitions: f.Options.MaxPartitions}
+	}
+
+	var resp *spannerpb.PartitionResponse
+	if f.SQL != "" {
+		resp, err = client.PartitionQuery(ctx, &spannerpb.PartitionQueryRequest{
+			Session:          session.GetName(),
+			Transaction:      selector,
+			Sql:              f.SQL,
+			PartitionOptions: opts,
+		})
+	} else {
+		resp, err = client.PartitionRead(ctx, &spannerpb.PartitionReadRequest{
+			Session:          session.GetName(),
+			Transaction:      selector,
+			Table:            f.Table,
+			Columns:          f.Columns,
+			KeySet:           &spannerpb.KeySet{All: true},
+			PartitionOptions: opts,
+		})
+	}
+	if err != nil {
+		return errors.Wrapf(err, "partitioning read of %v", f.Database)
+	}
+
+	log.Infof(ctx, "Reading %v in %v partitions at %v", f.Database, len(resp.GetPartitions()), txn.GetReadTimestamp().AsTime())
+	for _, p := range resp.GetPartitions() {
+		emit(partition{Session: session.GetName(), Transaction: txn.GetId(), Token: p.GetPartitionToken()})
+	}
+	return nil
+}
+
+// readPartitionFn reads the rows of partitions.
+type readPartitionFn struct {
+	// Database is the name of the database.
+	Database string `json:"database"`
+	// Table and Columns are the table and columns of a read.
+	Table   string   `json:"table"`
+	Columns []string `json:"columns"`
+	// SQL is the query, if any.
+	SQL string `json:"sql"`
+	// Type is the type of the rows.
+	Type beam.EncodedType `json:"type"`
+
+	conn   *grpc.ClientConn
+	client spannerpb.SpannerClient
+	fields map[string]int
+}
+
+func (f *readPartitionFn) Setup(ctx context.Context) error {
+	conn, err := dial(ctx)
+	if err != nil {
+		return err
+	}
+	f.conn = conn
+	f.client = spannerpb.NewSpannerClient(conn)
+	f.fields = columnFields(f.Type.T)
+	return nil
+}
+
+func (f *readPartitionFn) Teardown() error {
+	if f.conn == nil {
+		return nil
+	}
+	return f.conn.Close()
+}
+
+func (f *readPartitionFn) ProcessElement(ctx context.Context, p partition, emit func(beam.X)) error {
+	var r resultReader
+	for attempt := 0; ; attempt++ {
+		err := f.read(ctx, p, &r, emit)
+		if err == nil {
+			return nil
+		}
+		if attempt >= readRetries || !isRetryable(err) {
+			return errors.Wrapf(err, "reading partition of %v", f.Database)
+		}
+		log.Warnf(ctx, "Retrying read of partition of %v: %v", f.Database, err)
+		r.reset()
+		time.Sleep(time.Duration(1<<attempt) * time.Second)
+	}
+}
+
+// resultStream is a stream of partial result sets.
+type resultStream interface {
+	Recv() (*spannerpb.PartialResultSet, error)
+}
+
+// read outputs the rows of the partition, resuming from the last resume
+// token of the reader.
+func (f *readPartitionFn) read(ctx context.Context, p partition, r *resultReader, emit func(beam.X)) error {
+	selector := &spannerpb.TransactionSelector{Selector: &spannerpb.TransactionSelector_Id{Id: p.Transaction}}
+	var stream resultStream
+	var err error
+	if f.SQL != "" {
+		stream, err = f.client.ExecuteStreamingSql(ctx, &spannerpb.ExecuteSqlRequest{
+			Session:        p.Session,
+			Transaction:    selector,
+			Sql:            f.SQL,
+			PartitionToken: p.Token,
+			ResumeToken:    r.token,
+		})
+	} else {
+		stream, err = f.client.StreamingRead(ctx, &spannerpb.ReadRequest{
+			Session:        p.Session,
+			Transaction:    selector,
+			Table:          f.Table,
+			Columns:        f.Columns,
+			KeySet:         &spannerpb.KeySet{All: true},
+			PartitionToken: p.Token,
+			ResumeToken:    r.token,
+		})
+	}
+	if err != nil {
+		return err
+	}
+
+	for {
+		set, err := stream.Recv()
+		if err == io.EOF {
+			rows, err := r.done()
+			if err != nil {
+				return err
+			}
+			return f.emit(r, rows, emit)
+		}
+		if err != nil {
+			return err
+		}
+		rows, err := r.add(set)
+		if err != nil {
+			return err
+		}
+		if err := f.emit(r, rows, emit); err != nil {
+			return err
+		}
+	}
+}
+
+// emit decodes and outputs the rows.
+func (f *readPartitionFn) emit(r *resultReader, rows [][]*structpb.Value, emit func(beam.X)) error {
+	for _, row := range rows {
+		v, err := decodeRow(r.cols, row, f.Type.T, f.fields)
+		if err != nil {
+			return err
+		}
+		emit(v.Interface())
+	}
+	return nil
+}
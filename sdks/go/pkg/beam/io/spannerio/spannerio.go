@@ -0,0 +1,71 @@
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements.  See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License.  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package spannerio provides transformations and utilities to interact with
+// Google Cloud Spanner. See also: https://cloud.google.com/spanner/docs.
+//
+// Databases are named as "projects/<project>/instances/<instance>/databases/<database>".
+// The transforms use the Spanner API directly. If the SPANNER_EMULATOR_HOST
+// environment variable is set, they connect to the emulator at that address
+// instead.
+package spannerio
+
+import (
+	"context"
+	"regexp"
+
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/internal/errors"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/io/internal/gcpx"
+	spannerpb "google.golang.org/genproto/googleapis/spanner/v1"
+	"google.golang.org/grpc"
+)
+
+const (
+	// emulatorHostEnv is the environment variable with the address of the
+	// Spanner emulator, if any.
+	emulatorHostEnv = "SPANNER_EMULATOR_HOST"
+	endpoint        = "spanner.googleapis.com:443"
+	dataScope       = "https://www.googleapis.com/auth/spanner.data"
+)
+
+var databaseRegexp = regexp.MustCompile(`^projects/[^/]+/instances/[^/]+/databases/[^/]+$`)
+
+// mustParseDatabase panics if the database name is invalid.
+func mustParseDatabase(db string) {
+	if !databaseRegexp.MatchString(db) {
+		panic(errors.Errorf("invalid database %q, want projects/<project>/instances/<instance>/databases/<database>", db))
+	}
+}
+
+// dial connects to Spanner, or to the emulator if SPANNER_EMULATOR_HOST is
+// set.
+func dial(ctx context.Context) (*grpc.ClientConn, error) {
+	return gcpx.Dial(ctx, emulatorHostEnv, endpoint, dataScope)
+}
+
+// createSession returns a new session of the database.
+func createSession(ctx context.Context, client spannerpb.SpannerClient, db string) (*spannerpb.Session, error) {
+	session, err := client.CreateSession(ctx, &spannerpb.CreateSessionRequest{Database: db})
+	if err != nil {
+		return nil, errors.Wrapf(err, "creating session of %v", db)
+	}
+	return session, nil
+}
+
+// isRetryable returns whether the request that failed with the error can be
+// retried.
+func isRetryable(err error) bool {
+	return gcpx.IsRetryable(err)
+}
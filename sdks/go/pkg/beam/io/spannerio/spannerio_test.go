@@ -0,0 +1,481 @@
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements.  See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License.  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package spannerio
+
+import (
+	"context"
+	"math"
+	"math/big"
+	"net"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/civil"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/testing/passert"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/testing/ptest"
+	spannerpb "google.golang.org/genproto/googleapis/spanner/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func TestMain(m *testing.M) {
+	ptest.Main(m)
+}
+
+const testDB = "projects/p/instances/i/databases/d"
+
+// fakeServer is an in-memory Spanner API server, which serves the same table
+// for reads and queries, in two partitions.
+type fakeServer struct {
+	spannerpb.UnimplementedSpannerServer
+
+	mu   sync.Mutex
+	cols []*spannerpb.StructType_Field
+	rows [][]*structpb.Value
+	// readOnly is the read-only options of the last transaction.
+	readOnly *spannerpb.TransactionOptions_ReadOnly
+	// failures is the number of streams to fail with Unavailable after
+	// their first row.
+	failures int
+
+	// changes is the change records of each partition of a change stream,
+	// by partition token.
+	changes map[string][]*structpb.Value
+	// changeQueries is the parameters of the change stream queries, and
+	// changeLog the start and end of each query, in order.
+	changeQueries []*structpb.Struct
+	changeLog     []string
+
+	// committed is the mutations of each successful commit, commits the
+	// number of commit requests, and aborts the number of commits to abort.
+	// Commits with mutations of the table "bad" fail.
+	committed [][]*spannerpb.Mutation
+	commits   int
+	aborts    int
+}
+
+// startFakeServer starts a fake server with the rows, and points the
+// transforms at it.
+func startFakeServer(t *testing.T, cols []*spannerpb.StructType_Field, rows ...[]*structpb.Value) *fakeServer {
+	t.Helper()
+	lis, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	fake := &fakeServer{cols: cols, rows: rows}
+	srv := grpc.NewServer()
+	spannerpb.RegisterSpannerServer(srv, fake)
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+	t.Setenv(emulatorHostEnv, lis.Addr().String())
+	return fake
+}
+
+func (f *fakeServer) CreateSession(_ context.Context, req *spannerpb.CreateSessionRequest) (*spannerpb.Session, error) {
+	return &spannerpb.Session{Name: req.GetDatabase() + "/sessions/s"}, nil
+}
+
+func (f *fakeServer) BeginTransaction(_ context.Context, req *spannerpb.BeginTransactionRequest) (*spannerpb.Transaction, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.readOnly = req.GetOptions().GetReadOnly()
+	return &spannerpb.Transaction{Id: []byte("txn"), ReadTimestamp: timestamppb.Now()}, nil
+}
+
+func (f *fakeServer) partitions() *spannerpb.PartitionResponse {
+	return &spannerpb.PartitionResponse{Partitions: []*spannerpb.Partition{
+		{PartitionToken: []byte{0}},
+		{PartitionToken: []byte{1}},
+	}}
+}
+
+func (f *fakeServer) PartitionQuery(context.Context, *spannerpb.PartitionQueryRequest) (*spannerpb.PartitionResponse, error) {
+	return f.partitions(), nil
+}
+
+func (f *fakeServer) PartitionRead(context.Context, *spannerpb.PartitionReadRequest) (*spannerpb.PartitionResponse, error) {
+	return f.partitions(), nil
+}
+
+func (f *fakeServer) ExecuteStreamingSql(req *spannerpb.ExecuteSqlRequest, stream spannerpb.Spanner_ExecuteStreamingSqlServer) error {
+	if f.changes != nil {
+		return f.serveChanges(req, stream.Send)
+	}
+	return f.serve(req.GetPartitionToken(), req.GetResumeToken(), stream.Send)
+}
+
+func (f *fakeServer) StreamingRead(req *spannerpb.ReadRequest, stream spannerpb.Spanner_StreamingReadServer) error {
+	return f.serve(req.GetPartitionToken(), req.GetResumeToken(), stream.Send)
+}
+
+// serve sends the rows of the partition from the resume token, which is the
+// index of the next row. Each row is sent in a partial result set per value,
+// and strings are chunked in two.
+func (f *fakeServer) serve(token, resume []byte, send func(*spannerpb.PartialResultSet) error) error {
+	f.mu.Lock()
+	fail := f.failures > 0
+	if fail {
+		f.failures--
+	}
+	f.mu.Unlock()
+
+	start := 0
+	if len(resume) > 0 {
+		start = int(resume[0])
+	}
+	md := &spannerpb.ResultSetMetadata{RowType: &spannerpb.StructType{Fields: f.cols}}
+	sent := 0
+	for i := start; i < len(f.rows); i++ {
+		if i%2 != int(token[0]) {
+			continue
+		}
+		if fail && sent == 1 {
+			return status.Error(codes.Unavailable, "injected failure")
+		}
+		for j, v := range f.rows[i] {
+			sets := []*spannerpb.PartialResultSet{{Values: []*structpb.Value{v}}}
+			if s, ok := v.GetKind().(*structpb.Value_StringValue); ok && len(s.StringValue) > 1 {
+				half := len(s.StringValue) / 2
+				sets = []*spannerpb.PartialResultSet{
+					{Values: []*structpb.Value{structpb.NewStringValue(s.StringValue[:half])}, ChunkedValue: true},
+					{Values: []*structpb.Value{structpb.NewStringValue(s.StringValue[half:])}},
+				}
+			}
+			sets[0].Metadata, md = md, nil
+			if j == len(f.rows[i])-1 {
+				sets[len(sets)-1].ResumeToken = []byte{byte(i + 1)}
+			}
+			for _, set := range sets {
+				if err := send(set); err != nil {
+					return err
+				}
+			}
+		}
+		sent++
+	}
+	return nil
+}
+
+type testRow struct {
+	ID      int64 `spanner:"id"`
+	Name    string
+	Score   *float64
+	Tags    []string
+	Created time.Time
+}
+
+var testCols = []*spannerpb.StructType_Field{
+	{Name: "id", Type: &spannerpb.Type{Code: spannerpb.TypeCode_INT64}},
+	{Name: "name", Type: &spannerpb.Type{Code: spannerpb.TypeCode_STRING}},
+	{Name: "Score", Type: &spannerpb.Type{Code: spannerpb.TypeCode_FLOAT64}},
+	{Name: "Tags", Type: &spannerpb.Type{Code: spannerpb.TypeCode_ARRAY, ArrayElementType: &spannerpb.Type{Code: spannerpb.TypeCode_STRING}}},
+	{Name: "Created", Type: &spannerpb.Type{Code: spannerpb.TypeCode_TIMESTAMP}},
+	{Name: "Extra", Type: &spannerpb.Type{Code: spannerpb.TypeCode_STRING}},
+}
+
+func testValues(id int, name string, score *float64, tags ...string) []*structpb.Value {
+	s := structpb.NewNullValue()
+	if score != nil {
+		s = structpb.NewNumberValue(*score)
+	}
+	var list []*structpb.Value
+	for _, tag := range tags {
+		list = append(list, structpb.NewStringValue(tag))
+	}
+	return []*structpb.Value{
+		structpb.NewStringValue(big.NewInt(int64(id)).String()),
+		structpb.NewStringValue(name),
+		s,
+		structpb.NewListValue(&structpb.ListValue{Values: list}),
+		structpb.NewStringValue("2022-05-01T10:00:00.5Z"),
+		structpb.NewStringValue("extra"),
+	}
+}
+
+var testCreated = time.Date(2022, 5, 1, 10, 0, 0, 5e8, time.UTC)
+
+func TestRead(t *testing.T) {
+	score := 1.5
+	fake := startFakeServer(t, testCols,
+		testValues(1, "alice", &score, "a", "bc"),
+		testValues(2, "bob", nil),
+		testValues(3, "carol", nil, "d"))
+
+	p, s := beam.NewPipelineWithRoot()
+	rows := Read(s, testDB, "users", reflect.TypeOf(testRow{}), ReadExactStaleness(10*time.Second))
+	passert.Equals(s, rows,
+		testRow{ID: 1, Name: "alice", Score: &score, Tags: []string{"a", "bc"}, Created: testCreated},
+		testRow{ID: 2, Name: "bob", Tags: []string{}, Created: testCreated},
+		testRow{ID: 3, Name: "carol", Tags: []string{"d"}, Created: testCreated})
+
+	ptest.RunAndValidate(t, p)
+
+	if got := fake.readOnly.GetExactStaleness().AsDuration(); got != 10*time.Second {
+		t.Errorf("Read() used staleness %v, want 10s", got)
+	}
+}
+
+func TestQuery_Retry(t *testing.T) {
+	fake := startFakeServer(t, testCols,
+		testValues(1, "alice", nil),
+		testValues(2, "bob", nil),
+		testValues(3, "carol", nil),
+		testValues(4, "dave", nil))
+	fake.failures = 1
+
+	p, s := beam.NewPipelineWithRoot()
+	rows := Query(s, testDB, "SELECT * FROM users", reflect.TypeOf(testRow{}))
+	names := beam.ParDo(s, func(r testRow) string { return r.Name }, rows)
+	passert.Equals(s, names, "alice", "bob", "carol", "dave")
+
+	ptest.RunAndValidate(t, p)
+
+	if !fake.readOnly.GetStrong() {
+		t.Errorf("Query() used %v, want a strong read", fake.readOnly)
+	}
+}
+
+func TestDecodeValue(t *testing.T) {
+	typ := func(code spannerpb.TypeCode) *spannerpb.Type { return &spannerpb.Type{Code: code} }
+	var (
+		b    bool
+		f    float32
+		d    civil.Date
+		r    big.Rat
+		bs   []byte
+		n    *int64
+		st   struct{ A string }
+		json string
+	)
+	tests := []struct {
+		v    *structpb.Value
+		typ  *spannerpb.Type
+		dst  interface{}
+		want interface{}
+	}{
+		{structpb.NewBoolValue(true), typ(spannerpb.TypeCode_BOOL), &b, true},
+		{structpb.NewStringValue("-Infinity"), typ(spannerpb.TypeCode_FLOAT64), &f, float32(math.Inf(-1))},
+		{structpb.NewStringValue("2022-05-01"), typ(spannerpb.TypeCode_DATE), &d, civil.Date{Year: 2022, Month: 5, Day: 1}},
+		{structpb.NewStringValue("1.25"), typ(spannerpb.TypeCode_NUMERIC), &r, *big.NewRat(5, 4)},
+		{structpb.NewStringValue("AQI="), typ(spannerpb.TypeCode_BYTES), &bs, []byte{1, 2}},
+		{structpb.NewNullValue(), typ(spannerpb.TypeCode_INT64), &n, (*int64)(nil)},
+		{structpb.NewStringValue(`{"a":1}`), typ(spannerpb.TypeCode_JSON), &json, `{"a":1}`},
+		{
+			structpb.NewListValue(&structpb.ListValue{Values: []*structpb.Value{structpb.NewStringValue("x")}}),
+			&spannerpb.Type{Code: spannerpb.TypeCode_STRUCT, StructType: &spannerpb.StructType{Fields: []*spannerpb.StructType_Field{{Name: "a", Type: typ(spannerpb.TypeCode_STRING)}}}},
+			&st, struct{ A string }{A: "x"},
+		},
+	}
+	for _, test := range tests {
+		dst := reflect.ValueOf(test.dst).Elem()
+		if err := decodeValue(test.v, test.typ, dst); err != nil {
+			t.Errorf("decodeValue(%v, %v) failed: %v", test.v, test.typ, err)
+			continue
+		}
+		if got := dst.Interface(); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("decodeValue(%v, %v) = %v, want %v", test.v, test.typ, got, test.want)
+		}
+	}
+
+	var i int8
+	if err := decodeValue(structpb.NewStringValue("1000"), typ(spannerpb.TypeCode_INT64), reflect.ValueOf(&i).Elem()); err == nil {
+		t.Error("decodeValue() of an overflowing INT64 succeeded, want error")
+	}
+	if err := decodeValue(structpb.NewStringValue("a"), typ(spannerpb.TypeCode_STRING), reflect.ValueOf(&b).Elem()); err == nil {
+		t.Error("decodeValue() of a STRING into a bool succeeded, want error")
+	}
+}
+
+func TestMergeChunk(t *testing.T) {
+	list := func(vs ...*structpb.Value) *structpb.Value {
+		return structpb.NewListValue(&structpb.ListValue{Values: vs})
+	}
+	str := structpb.NewStringValue
+	num := structpb.NewNumberValue
+	tests := []struct {
+		a, b, want *structpb.Value
+	}{
+		{str("ab"), str("cd"), str("abcd")},
+		{list(num(1), num(2)), list(num(3)), list(num(1), num(2), num(3))},
+		{list(str("a"), str("b")), list(str("c"), str("d")), list(str("a"), str("bc"), str("d"))},
+		{list(list(str("a"))), list(list(str("b"), str("c"))), list(list(str("ab"), str("c")))},
+		// The examples of the PartialResultSet documentation.
+		{str("foo"), str("bar"), str("foobar")},
+		{list(num(2), num(3)), list(num(4)), list(num(2), num(3), num(4))},
+		{list(str("a"), list(str("b"), str("c"))), list(list(str("d")), str("e")), list(str("a"), list(str("b"), str("cd")), str("e"))},
+		{obj("a", str("1")), obj("b", str("2")), obj("a", str("1"), "b", str("2"))},
+		{obj("a", str("1")), obj("a", str("2")), obj("a", str("12"))},
+		{obj("a", list(str("1"))), obj("a", list(str("2"))), obj("a", list(str("12")))},
+	}
+	for _, test := range tests {
+		got, err := mergeChunk(test.a, test.b)
+		if err != nil {
+			t.Errorf("mergeChunk(%v, %v) failed: %v", test.a, test.b, err)
+			continue
+		}
+		if !proto.Equal(got, test.want) {
+			t.Errorf("mergeChunk(%v, %v) = %v, want %v", test.a, test.b, got, test.want)
+		}
+	}
+
+	// Numbers, bools and nulls can't be chunked.
+	invalid := []struct {
+		a, b *structpb.Value
+	}{
+		{num(1), num(2)},
+		{structpb.NewBoolValue(true), structpb.NewBoolValue(false)},
+		{structpb.NewNullValue(), str("a")},
+		{str("a"), list(str("b"))},
+		{list(str("a")), list(num(1))},
+	}
+	for _, test := range invalid {
+		if got, err := mergeChunk(test.a, test.b); err == nil {
+			t.Errorf("mergeChunk(%v, %v) = %v, want error", test.a, test.b, got)
+		}
+	}
+}
+
+// obj returns a struct value of the alternating field names and values.
+func obj(kvs ...interface{}) *structpb.Value {
+	fields := make(map[string]*structpb.Value)
+	for i := 0; i < len(kvs); i += 2 {
+		fields[kvs[i].(string)] = kvs[i+1].(*structpb.Value)
+	}
+	return structpb.NewStructValue(&structpb.Struct{Fields: fields})
+}
+
+func TestResultReader(t *testing.T) {
+	str := structpb.NewStringValue
+	md := &spannerpb.ResultSetMetadata{RowType: &spannerpb.StructType{Fields: []*spannerpb.StructType_Field{{Name: "s"}}}}
+	tests := []struct {
+		name    string
+		sets    []*spannerpb.PartialResultSet
+		want    []string
+		wantErr bool
+	}{
+		{
+			// The example of the PartialResultSet documentation.
+			name: "chunked across sets",
+			sets: []*spannerpb.PartialResultSet{
+				{Metadata: md, Values: []*structpb.Value{str("Hello"), str("W")}, ChunkedValue: true, ResumeToken: []byte("t1")},
+				{Values: []*structpb.Value{str("orl")}, ChunkedValue: true},
+				{Values: []*structpb.Value{str("d")}, ResumeToken: []byte("t2")},
+			},
+			want: []string{"Hello", "World"},
+		},
+		{
+			name: "set without values",
+			sets: []*spannerpb.PartialResultSet{
+				{Metadata: md, Values: []*structpb.Value{str("W")}, ChunkedValue: true},
+				{},
+				{Values: []*structpb.Value{str("orld")}},
+			},
+			want: []string{"World"},
+		},
+		{
+			name: "rows without resume tokens",
+			sets: []*spannerpb.PartialResultSet{
+				{Metadata: md, Values: []*structpb.Value{str("a"), str("b")}},
+				{Values: []*structpb.Value{str("c")}},
+			},
+			want: []string{"a", "b", "c"},
+		},
+		{
+			name: "ends with chunked value",
+			sets: []*spannerpb.PartialResultSet{
+				{Metadata: md, Values: []*structpb.Value{str("W")}, ChunkedValue: true},
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid chunk",
+			sets: []*spannerpb.PartialResultSet{
+				{Metadata: md, Values: []*structpb.Value{str("W")}, ChunkedValue: true},
+				{Values: []*structpb.Value{structpb.NewNumberValue(1)}},
+			},
+			wantErr: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var r resultReader
+			var rows [][]*structpb.Value
+			var err error
+			for _, set := range test.sets {
+				var rs [][]*structpb.Value
+				if rs, err = r.add(set); err != nil {
+					break
+				}
+				rows = append(rows, rs...)
+			}
+			if err == nil {
+				var rs [][]*structpb.Value
+				rs, err = r.done()
+				rows = append(rows, rs...)
+			}
+			if test.wantErr {
+				if err == nil {
+					t.Fatalf("read rows %v, want error", rows)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("reading failed: %v", err)
+			}
+			var got []string
+			for _, row := range rows {
+				got = append(got, row[0].GetStringValue())
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("read rows %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestResultReader_Reset(t *testing.T) {
+	cols := []*spannerpb.StructType_Field{{Name: "a"}, {Name: "b"}}
+	var r resultReader
+	sets := []*spannerpb.PartialResultSet{
+		{Metadata: &spannerpb.ResultSetMetadata{RowType: &spannerpb.StructType{Fields: cols}}, Values: []*structpb.Value{structpb.NewStringValue("1"), structpb.NewStringValue("x")}, ChunkedValue: true, ResumeToken: []byte("t1")},
+		{Values: []*structpb.Value{structpb.NewStringValue("y"), structpb.NewStringValue("2")}},
+	}
+	for _, set := range sets {
+		if _, err := r.add(set); err != nil {
+			t.Fatalf("add() failed: %v", err)
+		}
+	}
+	// Resuming from t1 sends the rest of the chunked value again.
+	r.reset()
+	rows, err := r.add(&spannerpb.PartialResultSet{Values: []*structpb.Value{structpb.NewStringValue("y")}, ResumeToken: []byte("t2")})
+	if err != nil {
+		t.Fatalf("add() failed: %v", err)
+	}
+	want := [][]*structpb.Value{{structpb.NewStringValue("1"), structpb.NewStringValue("xy")}}
+	if len(rows) != 1 || !proto.Equal(rows[0][0], want[0][0]) || !proto.Equal(rows[0][1], want[0][1]) {
+		t.Errorf("rows after reset = %v, want %v", rows, want)
+	}
+	if string(r.token) != "t2" {
+		t.Errorf("token after reset = %q, want t2", r.token)
+	}
+}
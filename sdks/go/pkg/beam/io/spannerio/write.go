@@ -0,0 +1,361 @@
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements.  See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License.  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package spannerio
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/apache/beam/sdks/v2/go/pkg/beam"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/internal/errors"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/log"
+	spannerpb "google.golang.org/genproto/googleapis/spanner/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	defaultBatchSizeBytes  = 1 << 20 // 1 MB
+	defaultMaxNumMutations = 5000
+	defaultMaxNumRows      = 500
+	defaultMaxRetries      = 5
+	defaultInitialBackoff  = 100 * time.Millisecond
+	defaultMaxBackoff      = 10 * time.Second
+)
+
+func init() {
+	beam.RegisterType(reflect.TypeOf((*writeFn)(nil)).Elem())
+	beam.RegisterType(reflect.TypeOf((*writeWithFailuresFn)(nil)).Elem())
+}
+
+// writeOption holds the options of Write and WriteWithFailures.
+type writeOption struct {
+	BatchSizeBytes  int
+	MaxNumMutations int
+	MaxNumRows      int
+	MaxRetries      int
+	InitialBackoff  time.Duration
+	MaxBackoff      time.Duration
+}
+
+// WriteOptionFn is an option for Write and WriteWithFailures.
+type WriteOptionFn func(*writeOption)
+
+func newWriteOption(opts []WriteOptionFn) writeOption {
+	o := writeOption{
+		BatchSizeBytes:  defaultBatchSizeBytes,
+		MaxNumMutations: defaultMaxNumMutations,
+		MaxNumRows:      defaultMaxNumRows,
+		MaxRetries:      defaultMaxRetries,
+		InitialBackoff:  defaultInitialBackoff,
+		MaxBackoff:      defaultMaxBackoff,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// WriteBatchSizeBytes limits the size in bytes of the mutations committed
+// in each transaction. The default is 1 MB.
+func WriteBatchSizeBytes(bytes int) WriteOptionFn {
+	if bytes < 1 {
+		panic(fmt.Sprintf("spannerio.WriteBatchSizeBytes batch size must be positive. Got: %v", bytes))
+	}
+	return func(o *writeOption) {
+		o.BatchSizeBytes = bytes
+	}
+}
+
+// WriteMaxNumMutations limits the number of cells written, or keys deleted,
+// in each transaction. Spanner limits a transaction to 80000 mutations. The
+// default is 5000.
+func WriteMaxNumMutations(n int) WriteOptionFn {
+	if n < 1 {
+		panic(fmt.Sprintf("spannerio.WriteMaxNumMutations max mutations must be positive. Got: %v", n))
+	}
+	return func(o *writeOption) {
+		o.MaxNumMutations = n
+	}
+}
+
+// WriteMaxNumRows limits the number of rows written or deleted in each
+// transaction. The default is 500.
+func WriteMaxNumRows(n int) WriteOptionFn {
+	if n < 1 {
+		panic(fmt.Sprintf("spannerio.WriteMaxNumRows max rows must be positive. Got: %v", n))
+	}
+	return func(o *writeOption) {
+		o.MaxNumRows = n
+	}
+}
+
+// WriteMaxRetries sets the number of times commits that fail with a
+// retryable error, such as ABORTED, are retried. The default is 5.
+func WriteMaxRetries(n int) WriteOptionFn {
+	if n < 0 {
+		panic(fmt.Sprintf("spannerio.WriteMaxRetries max retries must be non-negative. Got: %v", n))
+	}
+	return func(o *writeOption) {
+		o.MaxRetries = n
+	}
+}
+
+// WriteBackoff sets the backoff between retries, which starts at initial and
+// doubles after each retry, up to max. The default is 100ms, up to 10s.
+func WriteBackoff(initial, max time.Duration) WriteOptionFn {
+	if initial <= 0 || max < initial {
+		panic(fmt.Sprintf("spannerio.WriteBackoff invalid backoff. Got: %v, %v", initial, max))
+	}
+	return func(o *writeOption) {
+		o.InitialBackoff = initial
+		o.MaxBackoff = max
+	}
+}
+
+// Write applies the mutation groups of the given PCollection<MutationGroup>
+// to the database. Each group is applied atomically. Groups are batched
+// into transactions within the limits of the options, so several groups may
+// be applied in the same transaction; if a batch fails, its groups are
+// applied one transaction each. Commits are retried with backoff if they
+// fail with a retryable error. Groups that still fail fail the bundle.
+func Write(s beam.Scope, db string, col beam.PCollection, opts ...WriteOptionFn) {
+	s = s.Scope("spannerio.Write")
+	mustParseDatabase(db)
+
+	o := newWriteOption(opts)
+	beam.ParDo0(s, &writeFn{Database: db, Options: o}, col)
+}
+
+// WriteWithFailures applies the mutation groups of the given
+// PCollection<MutationGroup> to the database, like Write, but returns the
+// groups that couldn't be applied as a PCollection<FailedMutationGroup>
+// instead of failing the bundle, such as to write them to a dead-letter
+// table. Failed groups are output in the global window.
+func WriteWithFailures(s beam.Scope, db string, col beam.PCollection, opts ...WriteOptionFn) beam.PCollection {
+	s = s.Scope("spannerio.WriteWithFailures")
+	mustParseDatabase(db)
+
+	o := newWriteOption(opts)
+	return beam.ParDo(s, &writeWithFailuresFn{Database: db, Options: o}, col)
+}
+
+// writeFn applies mutation groups, and fails if any can't be applied.
+type writeFn struct {
+	// Database is the name of the database.
+	Database string `json:"database"`
+	// Options specifies the batching and retries.
+	Options writeOption `json:"options"`
+
+	conn *grpc.ClientConn
+	w    *batchWriter
+}
+
+func (f *writeFn) Setup(ctx context.Context) error {
+	conn, err := dial(ctx)
+	if err != nil {
+		return err
+	}
+	f.conn = conn
+	return nil
+}
+
+func (f *writeFn) StartBundle(ctx context.Context) {
+	f.w = newBatchWriter(spannerpb.NewSpannerClient(f.conn), f.Database, f.Options)
+}
+
+func (f *writeFn) ProcessElement(ctx context.Context, g MutationGroup) error {
+	return failure(f.w.Add(ctx, g))
+}
+
+func (f *writeFn) FinishBundle(ctx context.Context) error {
+	return failure(f.w.Close(ctx))
+}
+
+func (f *writeFn) Teardown() error {
+	if f.conn == nil {
+		return nil
+	}
+	return f.conn.Close()
+}
+
+// failure returns an error describing the failed groups, if any.
+func failure(failed []FailedMutationGroup) error {
+	if len(failed) == 0 {
+		return nil
+	}
+	return errors.Errorf("spanner write error: %v mutation groups failed, such as: %v", len(failed), failed[0].Error)
+}
+
+// writeWithFailuresFn applies mutation groups, and outputs those that can't
+// be applied.
+type writeWithFailuresFn struct {
+	// Database is the name of the database.
+	Database string `json:"database"`
+	// Options specifies the batching and retries.
+	Options writeOption `json:"options"`
+
+	conn *grpc.ClientConn
+	w    *batchWriter
+}
+
+func (f *writeWithFailuresFn) Setup(ctx context.Context) error {
+	conn, err := dial(ctx)
+	if err != nil {
+		return err
+	}
+	f.conn = conn
+	return nil
+}
+
+func (f *writeWithFailuresFn) StartBundle(ctx context.Context, _ func(FailedMutationGroup)) {
+	f.w = newBatchWriter(spannerpb.NewSpannerClient(f.conn), f.Database, f.Options)
+}
+
+func (f *writeWithFailuresFn) ProcessElement(ctx context.Context, g MutationGroup, emit func(FailedMutationGroup)) {
+	for _, failed := range f.w.Add(ctx, g) {
+		emit(failed)
+	}
+}
+
+func (f *writeWithFailuresFn) FinishBundle(ctx context.Context, emit func(FailedMutationGroup)) {
+	for _, failed := range f.w.Close(ctx) {
+		emit(failed)
+	}
+}
+
+func (f *writeWithFailuresFn) Teardown() error {
+	if f.conn == nil {
+		return nil
+	}
+	return f.conn.Close()
+}
+
+// batchWriter commits mutation groups to a database in batches.
+type batchWriter struct {
+	client spannerpb.SpannerClient
+	db     string
+	opts   writeOption
+
+	session string // Created on the first commit.
+
+	batch     []MutationGroup
+	size      int
+	mutations int
+	rows      int
+}
+
+func newBatchWriter(client spannerpb.SpannerClient, db string, opts writeOption) *batchWriter {
+	return &batchWriter{client: client, db: db, opts: opts}
+}
+
+// Add adds the group to the batch, and commits the batch first if the group
+// doesn't fit in it. It returns the groups that failed, if any.
+func (w *batchWriter) Add(ctx context.Context, g MutationGroup) []FailedMutationGroup {
+	var size, mutations, rows int
+	for _, m := range g.Mutations {
+		size += proto.Size(m)
+		mutations += mutationCount(m)
+		rows += mutationRows(m)
+	}
+	var failed []FailedMutationGroup
+	if len(w.batch) > 0 && (w.size+size > w.opts.BatchSizeBytes || w.mutations+mutations > w.opts.MaxNumMutations || w.rows+rows > w.opts.MaxNumRows) {
+		failed = w.flush(ctx)
+	}
+	w.batch = append(w.batch, g)
+	w.size += size
+	w.mutations += mutations
+	w.rows += rows
+	return failed
+}
+
+// Close commits the remaining batch, deletes the session, and returns the
+// groups that failed, if any.
+func (w *batchWriter) Close(ctx context.Context) []FailedMutationGroup {
+	failed := w.flush(ctx)
+	if w.session != "" {
+		if _, err := w.client.DeleteSession(ctx, &spannerpb.DeleteSessionRequest{Name: w.session}); err != nil {
+			log.Warnf(ctx, "Failed to delete session %v: %v", w.session, err)
+		}
+		w.session = ""
+	}
+	return failed
+}
+
+// flush commits the batch in a transaction. If that fails, each group of
+// the batch is committed in its own transaction, so that only the groups
+// that can't be applied fail.
+func (w *batchWriter) flush(ctx context.Context) []FailedMutationGroup {
+	if len(w.batch) == 0 {
+		return nil
+	}
+	batch := w.batch
+	w.batch, w.size, w.mutations, w.rows = nil, 0, 0, 0
+
+	var mutations []*spannerpb.Mutation
+	for _, g := range batch {
+		mutations = append(mutations, g.Mutations...)
+	}
+	err := w.commit(ctx, mutations)
+	if err == nil {
+		return nil
+	}
+	if len(batch) == 1 {
+		return []FailedMutationGroup{{Group: batch[0], Error: err.Error()}}
+	}
+
+	log.Warnf(ctx, "Committing %v mutation groups to %v one at a time after failure: %v", len(batch), w.db, err)
+	var failed []FailedMutationGroup
+	for _, g := range batch {
+		if err := w.commit(ctx, g.Mutations); err != nil {
+			failed = append(failed, FailedMutationGroup{Group: g, Error: err.Error()})
+		}
+	}
+	return failed
+}
+
+// commit applies the mutations in a single-use read-write transaction,
+// retrying with backoff if it fails with a retryable error.
+func (w *batchWriter) commit(ctx context.Context, mutations []*spannerpb.Mutation) error {
+	if w.session == "" {
+		session, err := createSession(ctx, w.client, w.db)
+		if err != nil {
+			return err
+		}
+		w.session = session.GetName()
+	}
+
+	backoff := w.opts.InitialBackoff
+	for attempt := 0; ; attempt++ {
+		_, err := w.client.Commit(ctx, &spannerpb.CommitRequest{
+			Session: w.session,
+			Transaction: &spannerpb.CommitRequest_SingleUseTransaction{SingleUseTransaction: &spannerpb.TransactionOptions{
+				Mode: &spannerpb.TransactionOptions_ReadWrite_{ReadWrite: &spannerpb.TransactionOptions_ReadWrite{}},
+			}},
+			Mutations: mutations,
+		})
+		if err == nil || attempt >= w.opts.MaxRetries || !isRetryable(err) {
+			return err
+		}
+		log.Warnf(ctx, "Retrying commit of %v mutations to %v in %v: %v", len(mutations), w.db, backoff, err)
+		time.Sleep(backoff)
+		if backoff *= 2; backoff > w.opts.MaxBackoff {
+			backoff = w.opts.MaxBackoff
+		}
+	}
+}
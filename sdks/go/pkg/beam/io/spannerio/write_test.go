@@ -0,0 +1,193 @@
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements.  See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License.  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package spannerio
+
+import (
+	"context"
+	"math"
+	"math/big"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/civil"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/testing/passert"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/testing/ptest"
+	spannerpb "google.golang.org/genproto/googleapis/spanner/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func (f *fakeServer) Commit(_ context.Context, req *spannerpb.CommitRequest) (*spannerpb.CommitResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.commits++
+	if req.GetSingleUseTransaction().GetReadWrite() == nil {
+		return nil, status.Error(codes.InvalidArgument, "want a single-use read-write transaction")
+	}
+	if f.aborts > 0 {
+		f.aborts--
+		return nil, status.Error(codes.Aborted, "injected abort")
+	}
+	for _, m := range req.GetMutations() {
+		if mutationWrite(m).GetTable() == "bad" {
+			return nil, status.Error(codes.NotFound, "table bad not found")
+		}
+	}
+	f.committed = append(f.committed, req.GetMutations())
+	return &spannerpb.CommitResponse{CommitTimestamp: timestamppb.Now()}, nil
+}
+
+func (f *fakeServer) DeleteSession(context.Context, *spannerpb.DeleteSessionRequest) (*emptypb.Empty, error) {
+	return &emptypb.Empty{}, nil
+}
+
+// testGroup returns a group inserting the rows of the table.
+func testGroup(table string, ids ...int64) MutationGroup {
+	var ms []*spannerpb.Mutation
+	for _, id := range ids {
+		ms = append(ms, Insert(table, []string{"id", "name"}, []interface{}{id, "name"}))
+	}
+	return NewMutationGroup(ms...)
+}
+
+func TestWrite(t *testing.T) {
+	fake := startFakeServer(t, nil)
+	fake.aborts = 1
+
+	p, s := beam.NewPipelineWithRoot()
+	groups := beam.Create(s, testGroup("users", 1, 2), testGroup("users", 3), testGroup("users", 4, 5))
+	Write(s, testDB, groups, WriteMaxNumRows(3), WriteBackoff(time.Millisecond, time.Millisecond))
+
+	ptest.RunAndValidate(t, p)
+
+	// The first two groups fit in a transaction, and the first commit is
+	// aborted and retried.
+	if len(fake.committed) != 2 || len(fake.committed[0]) != 3 || len(fake.committed[1]) != 2 {
+		t.Errorf("Write() committed %v, want batches of 3 and 2 mutations", fake.committed)
+	}
+	if fake.commits != 3 {
+		t.Errorf("Write() sent %v commits, want 3", fake.commits)
+	}
+}
+
+func TestWrite_Failure(t *testing.T) {
+	startFakeServer(t, nil)
+
+	p, s := beam.NewPipelineWithRoot()
+	groups := beam.Create(s, testGroup("users", 1), testGroup("bad", 2))
+	Write(s, testDB, groups)
+
+	if err := ptest.Run(p); err == nil {
+		t.Error("Write() of an invalid mutation group succeeded, want error")
+	}
+}
+
+func TestWriteWithFailures(t *testing.T) {
+	fake := startFakeServer(t, nil)
+
+	p, s := beam.NewPipelineWithRoot()
+	groups := beam.Create(s,
+		testGroup("users", 1),
+		NewMutationGroup(Insert("users", []string{"id"}, []interface{}{2}), Insert("bad", []string{"id"}, []interface{}{2})),
+		testGroup("users", 3))
+	failed := WriteWithFailures(s, testDB, groups)
+	tables := beam.ParDo(s, func(f FailedMutationGroup) string {
+		return mutationWrite(f.Group.Mutations[1]).GetTable() + ": " + f.Error
+	}, failed)
+	passert.Equals(s, tables, "bad: rpc error: code = NotFound desc = table bad not found")
+
+	ptest.RunAndValidate(t, p)
+
+	// The batch fails, so that each group is committed on its own, and only
+	// the valid groups are applied.
+	if len(fake.committed) != 2 {
+		t.Errorf("WriteWithFailures() committed %v, want the 2 valid groups", fake.committed)
+	}
+}
+
+func TestEncodeValue(t *testing.T) {
+	score := 1.5
+	var nilScore *float64
+	tests := []struct {
+		v    interface{}
+		want *structpb.Value
+	}{
+		{nil, structpb.NewNullValue()},
+		{true, structpb.NewBoolValue(true)},
+		{int64(-3), structpb.NewStringValue("-3")},
+		{&score, structpb.NewNumberValue(1.5)},
+		{nilScore, structpb.NewNullValue()},
+		{math.Inf(1), structpb.NewStringValue("Infinity")},
+		{[]byte{1, 2}, structpb.NewStringValue("AQI=")},
+		{time.Date(2022, 5, 1, 10, 0, 0, 5e8, time.UTC), structpb.NewStringValue("2022-05-01T10:00:00.5Z")},
+		{civil.Date{Year: 2022, Month: 5, Day: 1}, structpb.NewStringValue("2022-05-01")},
+		{*big.NewRat(5, 4), structpb.NewStringValue("1.250000000")},
+		{[]string{"a", "b"}, list(structpb.NewStringValue("a"), structpb.NewStringValue("b"))},
+	}
+	for _, test := range tests {
+		got, err := encodeList([]interface{}{test.v})
+		if err != nil {
+			t.Errorf("encodeValue(%v) failed: %v", test.v, err)
+			continue
+		}
+		if !proto.Equal(got.GetValues()[0], test.want) {
+			t.Errorf("encodeValue(%v) = %v, want %v", test.v, got.GetValues()[0], test.want)
+		}
+	}
+
+	if _, err := encodeList([]interface{}{map[string]int{}}); err == nil {
+		t.Error("encodeValue() of a map succeeded, want error")
+	}
+}
+
+func TestMutationGroupCoder(t *testing.T) {
+	want := FailedMutationGroup{
+		Group: NewMutationGroup(
+			Replace("users", []string{"id", "tags"}, []interface{}{1, []string{"a"}}),
+			Delete("users", 2)),
+		Error: "failed",
+	}
+	data, err := encodeFailedMutationGroup(want)
+	if err != nil {
+		t.Fatalf("encodeFailedMutationGroup() failed: %v", err)
+	}
+	got, err := decodeFailedMutationGroup(data)
+	if err != nil {
+		t.Fatalf("decodeFailedMutationGroup() failed: %v", err)
+	}
+	if got.Error != want.Error || len(got.Group.Mutations) != 2 ||
+		!proto.Equal(got.Group.Mutations[0], want.Group.Mutations[0]) || !proto.Equal(got.Group.Mutations[1], want.Group.Mutations[1]) {
+		t.Errorf("decodeFailedMutationGroup(encodeFailedMutationGroup(%v)) = %v", want, got)
+	}
+	if n, rows := mutationCount(want.Group.Mutations[0]), mutationRows(want.Group.Mutations[0]); n != 2 || rows != 1 {
+		t.Errorf("mutationCount(), mutationRows() = %v, %v, want 2, 1", n, rows)
+	}
+}
+
+func TestInsert_Invalid(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("Insert() with more values than columns succeeded, want panic")
+		}
+	}()
+	Insert("users", []string{"id"}, []interface{}{1, "a"})
+}
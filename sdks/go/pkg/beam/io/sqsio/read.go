@@ -0,0 +1,445 @@
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements.  See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License.  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sqsio
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/apache/beam/sdks/v2/go/pkg/beam"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/core/graph/mtime"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/core/sdf"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/internal/errors"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/io/rtrackers/offsetrange"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/io/rtrackers/unbounded"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/log"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+const (
+	// maxBatchSize is the maximum number of messages of a ReceiveMessage
+	// request, and of the entries of other batch requests.
+	maxBatchSize = 10
+	// waitTime is how long a receive waits for messages, with long polling.
+	waitTime = 5 * time.Second
+	// pollInterval is how long reading waits after a receive without
+	// messages.
+	pollInterval = time.Second
+	// checkpointInterval is how long messages are received before
+	// checkpointing, so that they can be committed and deleted.
+	checkpointInterval = 10 * time.Second
+	// finalizationTimeout is how long received messages are leased, waiting
+	// for their bundle to be finalized, after which they are made visible
+	// again.
+	finalizationTimeout = 10 * time.Minute
+	// defaultVisibilityTimeout is the visibility timeout of received
+	// messages, unless another is set with ReadVisibilityTimeout.
+	defaultVisibilityTimeout = 30 * time.Second
+	// minVisibilityTimeout and maxVisibilityTimeout bound the visibility
+	// timeout, which SQS sets in whole seconds up to 12 hours.
+	minVisibilityTimeout = 2 * time.Second
+	maxVisibilityTimeout = 12 * time.Hour
+)
+
+func init() {
+	beam.RegisterType(reflect.TypeOf((*readFn)(nil)).Elem())
+}
+
+// readOption holds the options of Read.
+type readOption struct {
+	Readers           int
+	VisibilityTimeout time.Duration
+	Region            string
+	Endpoint          string
+}
+
+// ReadOptionFn is an option for Read.
+type ReadOptionFn func(*readOption)
+
+// ReadNumReaders sets the number of concurrent readers of the queue. The
+// default is 1.
+func ReadNumReaders(n int) ReadOptionFn {
+	if n < 1 {
+		panic(fmt.Sprintf("sqsio.ReadNumReaders number must be positive. Got: %v", n))
+	}
+	return func(o *readOption) {
+		o.Readers = n
+	}
+}
+
+// ReadVisibilityTimeout sets the visibility timeout of received messages,
+// which is extended every half of it until they're deleted. It's rounded
+// down to whole seconds, and is between 2s and 12h. The default is 30s.
+func ReadVisibilityTimeout(d time.Duration) ReadOptionFn {
+	if d < minVisibilityTimeout || d > maxVisibilityTimeout {
+		panic(fmt.Sprintf("sqsio.ReadVisibilityTimeout timeout must be between %v and %v. Got: %v", minVisibilityTimeout, maxVisibilityTimeout, d))
+	}
+	return func(o *readOption) {
+		o.VisibilityTimeout = d.Truncate(time.Second)
+	}
+}
+
+// ReadRegion sets the AWS region of the queue.
+func ReadRegion(region string) ReadOptionFn {
+	return func(o *readOption) {
+		o.Region = region
+	}
+}
+
+// ReadEndpoint sets the URL of the endpoint requests are sent to, such as
+// "http://localhost:4566" for LocalStack.
+func ReadEndpoint(url string) ReadOptionFn {
+	return func(o *readOption) {
+		o.Endpoint = url
+	}
+}
+
+// Read reads the messages of the SQS queue at the given URL, and returns an
+// unbounded PCollection<Message>. For example:
+//
+//	msgs := sqsio.Read(s, "https://sqs.eu-west-1.amazonaws.com/123456789012/orders")
+//
+// Messages are received with long polling, and are output timestamped at
+// the time they were sent to the queue. The output watermark is the earliest
+// timestamp of the last messages received, or the current time when there
+// are none, so redelivered messages may be late.
+//
+// Messages are deleted from the queue once their bundle is finalized, and
+// their visibility timeout is extended until then, so that they aren't
+// redelivered. Messages of bundles that aren't finalized before a timeout,
+// such as bundles that failed, and messages still leased when the reader is
+// torn down, are made visible again, so that they're redelivered right
+// away. The queue's redrive policy limits how many times messages are
+// redelivered.
+func Read(s beam.Scope, queueURL string, opts ...ReadOptionFn) beam.PCollection {
+	s = s.Scope("sqsio.Read")
+	if queueURL == "" {
+		panic("sqsio.Read requires a queue URL")
+	}
+
+	o := readOption{
+		Readers:           1,
+		VisibilityTimeout: defaultVisibilityTimeout,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return beam.ParDo(s, &readFn{Queue: queueURL, Options: o}, beam.Impulse(s))
+}
+
+// readFn receives messages of a queue. Its restriction is a range of receive
+// sequence numbers, which is split into a range per reader. Each reader
+// claims a number per receive.
+type readFn struct {
+	// Queue is the URL of the queue.
+	Queue string `json:"queue"`
+	// Options specifies the readers and visibility timeout.
+	Options readOption `json:"options"`
+
+	newClient newClientFunc
+	client    client
+	leases    *leaser
+}
+
+func (f *readFn) Setup(ctx context.Context) error {
+	if f.newClient == nil {
+		f.newClient = newClient
+	}
+	c, err := f.newClient(ctx, f.Options.Region, f.Options.Endpoint)
+	if err != nil {
+		return err
+	}
+	f.client = c
+	f.leases = newLeaser(c, f.Queue, f.Options.VisibilityTimeout)
+	return nil
+}
+
+func (f *readFn) Teardown() error {
+	if f.leases != nil {
+		f.leases.Stop()
+	}
+	return nil
+}
+
+func (f *readFn) CreateInitialRestriction(_ []byte) offsetrange.Restriction {
+	return offsetrange.Restriction{Start: 0, End: math.MaxInt64}
+}
+
+// SplitRestriction splits the restriction into a restriction per reader.
+// Restriction.EvenSplits would overflow on the unbounded range.
+func (f *readFn) SplitRestriction(_ []byte, rest offsetrange.Restriction) []offsetrange.Restriction {
+	n := int64(f.Options.Readers)
+	size := (rest.End - rest.Start) / n
+	splits := make([]offsetrange.Restriction, n)
+	for i := range splits {
+		splits[i] = offsetrange.Restriction{Start: rest.Start + int64(i)*size, End: rest.Start + int64(i+1)*size}
+	}
+	splits[n-1].End = rest.End
+	return splits
+}
+
+func (f *readFn) RestrictionSize(_ []byte, rest offsetrange.Restriction) float64 {
+	return rest.Size()
+}
+
+func (f *readFn) CreateTracker(rest offsetrange.Restriction) *sdf.LockRTracker {
+	return sdf.NewLockRTracker(unbounded.NewTracker(rest))
+}
+
+func (f *readFn) InitialWatermarkEstimatorState(et beam.EventTime, _ offsetrange.Restriction, _ []byte) int64 {
+	return int64(et)
+}
+
+func (f *readFn) CreateWatermarkEstimator(state int64) *sdf.ManualWatermarkEstimator {
+	return &sdf.ManualWatermarkEstimator{State: mtime.Time(state).ToTime()}
+}
+
+func (f *readFn) WatermarkEstimatorState(e *sdf.ManualWatermarkEstimator) int64 {
+	return int64(mtime.FromTime(e.State))
+}
+
+func (f *readFn) ProcessElement(ctx context.Context, we *sdf.ManualWatermarkEstimator, bf beam.BundleFinalization, rt *sdf.LockRTracker, _ []byte, emit func(beam.EventTime, Message)) (sdf.ProcessContinuation, error) {
+	// The messages are deleted once the runner has committed their bundle.
+	var received []string
+	defer func() {
+		if len(received) == 0 {
+			return
+		}
+		handles := received
+		bf.RegisterCallback(finalizationTimeout, func() error {
+			return f.leases.Delete(context.Background(), handles)
+		})
+	}()
+
+	rest := rt.GetRestriction().(offsetrange.Restriction)
+	checkpoint := time.Now().Add(checkpointInterval)
+	for n := rest.Start; ; n++ {
+		if !rt.TryClaim(n) {
+			return sdf.StopProcessing(), nil
+		}
+		out, err := f.client.ReceiveMessage(ctx, &sqs.ReceiveMessageInput{
+			QueueUrl:              aws.String(f.Queue),
+			MaxNumberOfMessages:   maxBatchSize,
+			WaitTimeSeconds:       int32(waitTime / time.Second),
+			VisibilityTimeout:     int32(f.Options.VisibilityTimeout / time.Second),
+			AttributeNames:        []types.QueueAttributeName{types.QueueAttributeNameAll},
+			MessageAttributeNames: []string{"All"},
+		})
+		if err != nil {
+			if isRetryable(err) {
+				log.Warnf(ctx, "Retrying receive of messages of %v: %v", f.Queue, err)
+				return sdf.ResumeProcessingIn(pollInterval), nil
+			}
+			return sdf.StopProcessing(), errors.Wrapf(err, "receiving messages of %v", f.Queue)
+		}
+		if len(out.Messages) == 0 {
+			unbounded.AdvanceWatermark(we, time.Now())
+			return sdf.ResumeProcessingIn(pollInterval), nil
+		}
+
+		handles := make([]string, len(out.Messages))
+		for i, m := range out.Messages {
+			handles[i] = aws.ToString(m.ReceiptHandle)
+		}
+		f.leases.Add(handles)
+		received = append(received, handles...)
+
+		var earliest time.Time
+		for _, m := range out.Messages {
+			msg := message(m)
+			sent := time.UnixMilli(msg.SentTimestamp)
+			if earliest.IsZero() || sent.Before(earliest) {
+				earliest = sent
+			}
+			emit(mtime.FromTime(sent), msg)
+		}
+		unbounded.AdvanceWatermark(we, earliest)
+
+		if time.Now().After(checkpoint) {
+			return sdf.ResumeProcessingIn(0), nil
+		}
+	}
+}
+
+// message returns the message of a received message.
+func message(m types.Message) Message {
+	msg := Message{
+		ID:              aws.ToString(m.MessageId),
+		Body:            aws.ToString(m.Body),
+		GroupID:         m.Attributes[string(types.MessageSystemAttributeNameMessageGroupId)],
+		DeduplicationID: m.Attributes[string(types.MessageSystemAttributeNameMessageDeduplicationId)],
+	}
+	msg.SentTimestamp, _ = strconv.ParseInt(m.Attributes[string(types.MessageSystemAttributeNameSentTimestamp)], 10, 64)
+	msg.ReceiveCount, _ = strconv.ParseInt(m.Attributes[string(types.MessageSystemAttributeNameApproximateReceiveCount)], 10, 64)
+	for k, v := range m.MessageAttributes {
+		if v.StringValue == nil {
+			continue
+		}
+		if msg.Attributes == nil {
+			msg.Attributes = make(map[string]string)
+		}
+		msg.Attributes[k] = *v.StringValue
+	}
+	return msg
+}
+
+// leaser extends the visibility timeout of received messages until they are
+// deleted, or their lease expires, when they are made visible again. The
+// messages are identified by their receipt handles.
+type leaser struct {
+	client     client
+	queue      string
+	visibility time.Duration
+
+	mu     sync.Mutex
+	expiry map[string]time.Time
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+func newLeaser(c client, queue string, visibility time.Duration) *leaser {
+	l := &leaser{
+		client:     c,
+		queue:      queue,
+		visibility: visibility,
+		expiry:     make(map[string]time.Time),
+		stop:       make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+	go l.run()
+	return l
+}
+
+// Add leases the messages.
+func (l *leaser) Add(handles []string) {
+	expiry := time.Now().Add(finalizationTimeout)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, h := range handles {
+		l.expiry[h] = expiry
+	}
+}
+
+// Delete deletes the messages from the queue, and stops leasing them.
+func (l *leaser) Delete(ctx context.Context, handles []string) error {
+	l.mu.Lock()
+	for _, h := range handles {
+		delete(l.expiry, h)
+	}
+	l.mu.Unlock()
+	for _, batch := range batches(handles) {
+		entries := make([]types.DeleteMessageBatchRequestEntry, len(batch))
+		for i, h := range batch {
+			entries[i] = types.DeleteMessageBatchRequestEntry{Id: aws.String(strconv.Itoa(i)), ReceiptHandle: aws.String(h)}
+		}
+		out, err := l.client.DeleteMessageBatch(ctx, &sqs.DeleteMessageBatchInput{QueueUrl: aws.String(l.queue), Entries: entries})
+		if err != nil {
+			return errors.Wrapf(err, "deleting %v messages of %v", len(batch), l.queue)
+		}
+		if err := batchError(out.Failed, "deleting", l.queue); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Stop stops extending the visibility timeout of messages, and makes the
+// leased messages visible again.
+func (l *leaser) Stop() {
+	close(l.stop)
+	<-l.done
+
+	l.mu.Lock()
+	handles := make([]string, 0, len(l.expiry))
+	for h := range l.expiry {
+		handles = append(handles, h)
+	}
+	l.expiry = make(map[string]time.Time)
+	l.mu.Unlock()
+	l.changeVisibility(handles, 0)
+}
+
+// run extends the visibility timeout of the leased messages every half of
+// it, and makes the messages of expired leases visible again.
+func (l *leaser) run() {
+	defer close(l.done)
+	ticker := time.NewTicker(l.visibility / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-l.stop:
+			return
+		case now := <-ticker.C:
+			var leased, expired []string
+			l.mu.Lock()
+			for h, expiry := range l.expiry {
+				if now.After(expiry) {
+					delete(l.expiry, h)
+					expired = append(expired, h)
+					continue
+				}
+				leased = append(leased, h)
+			}
+			l.mu.Unlock()
+			l.changeVisibility(expired, 0)
+			l.changeVisibility(leased, l.visibility)
+		}
+	}
+}
+
+// changeVisibility sets the visibility timeout of the messages.
+func (l *leaser) changeVisibility(handles []string, timeout time.Duration) {
+	ctx := context.Background()
+	for _, batch := range batches(handles) {
+		entries := make([]types.ChangeMessageVisibilityBatchRequestEntry, len(batch))
+		for i, h := range batch {
+			entries[i] = types.ChangeMessageVisibilityBatchRequestEntry{
+				Id:                aws.String(strconv.Itoa(i)),
+				ReceiptHandle:     aws.String(h),
+				VisibilityTimeout: int32(timeout / time.Second),
+			}
+		}
+		out, err := l.client.ChangeMessageVisibilityBatch(ctx, &sqs.ChangeMessageVisibilityBatchInput{QueueUrl: aws.String(l.queue), Entries: entries})
+		if err == nil {
+			err = batchError(out.Failed, "changing visibility of", l.queue)
+		}
+		if err != nil {
+			log.Warnf(ctx, "Failed to set visibility timeout of %v messages of %v to %v: %v", len(batch), l.queue, timeout, err)
+		}
+	}
+}
+
+// batches divides the receipt handles into batches of at most 10, the
+// maximum number of entries of a batch request.
+func batches(handles []string) [][]string {
+	var batches [][]string
+	for len(handles) > maxBatchSize {
+		batches = append(batches, handles[:maxBatchSize])
+		handles = handles[maxBatchSize:]
+	}
+	if len(handles) > 0 {
+		batches = append(batches, handles)
+	}
+	return batches
+}
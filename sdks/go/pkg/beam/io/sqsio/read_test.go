@@ -0,0 +1,222 @@
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements.  See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License.  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sqsio
+
+import (
+	"context"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/apache/beam/sdks/v2/go/pkg/beam"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/core/graph/mtime"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/core/sdf"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/io/rtrackers/offsetrange"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/io/rtrackers/unbounded/unboundedtest"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+)
+
+// process sets up a reader of the client's queue, and processes a
+// restriction with it, returning the messages output. The reader is torn
+// down by the returned function.
+func process(t *testing.T, c *fakeClient, bf *unboundedtest.Finalization) ([]Message, *sdf.ManualWatermarkEstimator, func()) {
+	t.Helper()
+	fn := &readFn{Queue: testQueue, Options: readOption{Readers: 1, VisibilityTimeout: time.Minute}, newClient: c.newClient}
+	ctx := context.Background()
+	if err := fn.Setup(ctx); err != nil {
+		t.Fatalf("Setup() failed: %v", err)
+	}
+	rest := fn.CreateInitialRestriction(nil)
+	rt := fn.CreateTracker(rest)
+	we := fn.CreateWatermarkEstimator(fn.InitialWatermarkEstimatorState(mtime.MinTimestamp, rest, nil))
+	var got []Message
+	pc, err := fn.ProcessElement(ctx, we, bf, rt, nil, func(et beam.EventTime, m Message) {
+		if want := mtime.FromMilliseconds(m.SentTimestamp); et != want {
+			t.Errorf("ProcessElement() output %v at %v, want its sent time %v", m, et, want)
+		}
+		got = append(got, m)
+	})
+	if err != nil {
+		t.Fatalf("ProcessElement() failed: %v", err)
+	}
+	if !pc.ShouldResume() {
+		t.Errorf("ProcessElement() = %v, want it to resume", pc)
+	}
+	return got, we, func() {
+		if err := fn.Teardown(); err != nil {
+			t.Errorf("Teardown() failed: %v", err)
+		}
+	}
+}
+
+// bodies returns the bodies of the messages.
+func bodies(msgs []Message) []string {
+	var bodies []string
+	for _, m := range msgs {
+		bodies = append(bodies, m.Body)
+	}
+	return bodies
+}
+
+func TestReadFn(t *testing.T) {
+	c := &fakeClient{}
+	c.add("a", "b", "c")
+
+	var bf unboundedtest.Finalization
+	start := time.Now()
+	got, we, teardown := process(t, c, &bf)
+	defer teardown()
+
+	if want := []string{"a", "b", "c"}; !reflect.DeepEqual(bodies(got), want) {
+		t.Fatalf("ProcessElement() output %v, want %v", bodies(got), want)
+	}
+	want := Message{ID: "m1", Body: "b", Attributes: map[string]string{"a": "b"}, SentTimestamp: epoch.Add(time.Second).UnixMilli(), ReceiveCount: 1}
+	if !reflect.DeepEqual(got[1], want) {
+		t.Errorf("ProcessElement() output %+v, want %+v", got[1], want)
+	}
+	// There were no more messages, so the watermark is the current time.
+	if wm := we.CurrentWatermark(); wm.Before(start.Truncate(time.Millisecond)) {
+		t.Errorf("ProcessElement() watermark = %v, want at least %v", wm, start)
+	}
+
+	// The messages are deleted once the bundle is finalized.
+	if deleted := c.deleted(); len(deleted) != 0 {
+		t.Errorf("messages %v deleted before finalization, want none", deleted)
+	}
+	if len(bf.Callbacks) != 1 {
+		t.Fatalf("ProcessElement() registered %v finalization callbacks, want 1", len(bf.Callbacks))
+	}
+	if err := bf.Callbacks[0](); err != nil {
+		t.Fatalf("finalization callback failed: %v", err)
+	}
+	if got, want := c.deleted(), []string{"a", "b", "c"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("messages %v deleted after finalization, want %v", got, want)
+	}
+}
+
+func TestReadFn_Redelivery(t *testing.T) {
+	c := &fakeClient{}
+	c.add("a", "b")
+
+	// The messages of a bundle that isn't finalized are made visible again
+	// once the reader is torn down.
+	var bf unboundedtest.Finalization
+	got, _, teardown := process(t, c, &bf)
+	if want := []string{"a", "b"}; !reflect.DeepEqual(bodies(got), want) {
+		t.Fatalf("ProcessElement() output %v, want %v", bodies(got), want)
+	}
+	teardown()
+
+	got, _, teardown = process(t, c, &bf)
+	defer teardown()
+	if want := []string{"a", "b"}; !reflect.DeepEqual(bodies(got), want) {
+		t.Fatalf("ProcessElement() after teardown output %v, want %v redelivered", bodies(got), want)
+	}
+	if got[0].ReceiveCount != 2 {
+		t.Errorf("ProcessElement() after teardown output receive count %v, want 2", got[0].ReceiveCount)
+	}
+
+	// The receipt handles of the first receive are no longer valid.
+	if err := bf.Callbacks[0](); err == nil {
+		t.Error("finalization callback of redelivered messages succeeded, want error")
+	}
+}
+
+func TestLeaser(t *testing.T) {
+	c := &fakeClient{}
+	c.add("a")
+	ctx := context.Background()
+	out, err := c.ReceiveMessage(ctx, &sqs.ReceiveMessageInput{QueueUrl: aws.String(testQueue), MaxNumberOfMessages: 1, VisibilityTimeout: 2})
+	if err != nil {
+		t.Fatalf("ReceiveMessage() failed: %v", err)
+	}
+	handle := aws.ToString(out.Messages[0].ReceiptHandle)
+
+	// The visibility timeout of leased messages is extended every half of
+	// it.
+	l := newLeaser(c, testQueue, 2*time.Second)
+	defer l.Stop()
+	l.Add([]string{handle})
+	time.Sleep(1500 * time.Millisecond)
+	c.mu.Lock()
+	got := c.visibility[handle]
+	c.mu.Unlock()
+	if want := []int32{2}; !reflect.DeepEqual(got, want) {
+		t.Errorf("leaser set visibility timeouts %v, want %v", got, want)
+	}
+
+	if err := l.Delete(ctx, []string{handle}); err != nil {
+		t.Fatalf("Delete() failed: %v", err)
+	}
+	if got, want := c.deleted(), []string{"a"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Delete() deleted %v, want %v", got, want)
+	}
+}
+
+func TestReadFn_SplitRestriction(t *testing.T) {
+	fn := &readFn{Options: readOption{Readers: 3}}
+	splits := fn.SplitRestriction(nil, fn.CreateInitialRestriction(nil))
+	if len(splits) != 3 || splits[0].Start != 0 || splits[2].End != math.MaxInt64 {
+		t.Fatalf("SplitRestriction() = %v, want 3 restrictions covering the range", splits)
+	}
+	for i := 1; i < len(splits); i++ {
+		if splits[i].Start != splits[i-1].End {
+			t.Errorf("SplitRestriction() = %v, want contiguous restrictions", splits)
+		}
+	}
+}
+
+func TestCreateTracker(t *testing.T) {
+	rt := (&readFn{}).CreateTracker(offsetrange.Restriction{Start: 0, End: math.MaxInt64})
+	if rt.IsBounded() {
+		t.Error("CreateTracker() is bounded, want unbounded so that draining stops reads")
+	}
+}
+
+func TestBatches(t *testing.T) {
+	handles := make([]string, 25)
+	var got []int
+	for _, b := range batches(handles) {
+		got = append(got, len(b))
+	}
+	if want := []int{10, 10, 5}; !reflect.DeepEqual(got, want) {
+		t.Errorf("batches() of 25 handles = batches of %v, want %v", got, want)
+	}
+}
+
+func TestRead_Options(t *testing.T) {
+	tests := []struct {
+		name string
+		fn   func()
+	}{
+		{"no queue", func() { Read(beam.NewPipeline().Root(), "") }},
+		{"zero readers", func() { ReadNumReaders(0) }},
+		{"visibility timeout below 2s", func() { ReadVisibilityTimeout(time.Second) }},
+		{"visibility timeout above 12h", func() { ReadVisibilityTimeout(13 * time.Hour) }},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%v succeeded, want panic", test.name)
+				}
+			}()
+			test.fn()
+		})
+	}
+}
@@ -0,0 +1,120 @@
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements.  See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License.  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package sqsio contains transforms to read from and send to Amazon SQS
+// queues (https://aws.amazon.com/sqs).
+//
+// Credentials and the region are loaded from the default sources of the AWS
+// SDK for Go v2, such as the environment, the shared configuration files and
+// the instance role, unless the region is set with an option. Reading is
+// implemented with a splittable DoFn, so it runs on portable runners that
+// support unbounded splittable DoFns and bundle finalization. Experimental.
+package sqsio
+
+import (
+	"context"
+	stderrors "errors"
+	"reflect"
+
+	"github.com/apache/beam/sdks/v2/go/pkg/beam"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/internal/errors"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/aws/smithy-go"
+)
+
+func init() {
+	beam.RegisterType(reflect.TypeOf((*Message)(nil)).Elem())
+}
+
+// Message is a message of an SQS queue, as read by Read. Write sends the
+// body, attributes, group ID, and deduplication ID of messages.
+type Message struct {
+	ID   string
+	Body string
+	// Attributes are the message attributes of the message with string or
+	// number values. Binary attributes aren't read, and Write sends
+	// attributes as strings.
+	Attributes map[string]string
+	// GroupID and DeduplicationID are the message group ID and message
+	// deduplication ID of messages of FIFO queues.
+	GroupID         string
+	DeduplicationID string
+	// SentTimestamp is the time the message was sent to the queue, in
+	// milliseconds since the epoch.
+	SentTimestamp int64
+	// ReceiveCount is the number of times the message has been received,
+	// including by this read.
+	ReceiveCount int64
+}
+
+// client is the part of a *sqs.Client used by the transforms, so that it can
+// be faked in tests.
+type client interface {
+	ReceiveMessage(ctx context.Context, in *sqs.ReceiveMessageInput, opts ...func(*sqs.Options)) (*sqs.ReceiveMessageOutput, error)
+	ChangeMessageVisibilityBatch(ctx context.Context, in *sqs.ChangeMessageVisibilityBatchInput, opts ...func(*sqs.Options)) (*sqs.ChangeMessageVisibilityBatchOutput, error)
+	DeleteMessageBatch(ctx context.Context, in *sqs.DeleteMessageBatchInput, opts ...func(*sqs.Options)) (*sqs.DeleteMessageBatchOutput, error)
+	SendMessageBatch(ctx context.Context, in *sqs.SendMessageBatchInput, opts ...func(*sqs.Options)) (*sqs.SendMessageBatchOutput, error)
+}
+
+// newClientFunc returns a client of the region, or of the default region if
+// it's empty, which sends requests to the endpoint, if it's set.
+type newClientFunc func(ctx context.Context, region, endpoint string) (client, error)
+
+// newClient returns an SQS client, with the default credentials.
+func newClient(ctx context.Context, region, endpoint string) (client, error) {
+	var opts []func(*config.LoadOptions) error
+	if region != "" {
+		opts = append(opts, config.WithRegion(region))
+	}
+	cfg, err := config.LoadDefaultConfig(ctx, opts...)
+	if err != nil {
+		return nil, errors.Wrap(err, "loading AWS configuration")
+	}
+	return sqs.NewFromConfig(cfg, func(o *sqs.Options) {
+		if endpoint != "" {
+			o.EndpointResolver = sqs.EndpointResolverFromURL(endpoint)
+		}
+	}), nil
+}
+
+// isRetryable returns whether the request that failed with the error can be
+// retried, because it failed before a response, was throttled, or failed
+// within SQS.
+func isRetryable(err error) bool {
+	var ae smithy.APIError
+	if !stderrors.As(err, &ae) {
+		return true
+	}
+	switch ae.ErrorCode() {
+	case "RequestThrottled", "ThrottlingException", "OverLimit",
+		"ServiceUnavailable", "InternalError", "InternalFailure":
+		return true
+	default:
+		return false
+	}
+}
+
+// batchError returns an error of the entries of a batch request that failed,
+// or nil if there are none.
+func batchError(failed []types.BatchResultErrorEntry, op, queue string) error {
+	if len(failed) == 0 {
+		return nil
+	}
+	e := failed[0]
+	return errors.Errorf("%v of %v messages of %v failed, such as with %v: %v", op, len(failed), queue, aws.ToString(e.Code), aws.ToString(e.Message))
+}
@@ -0,0 +1,222 @@
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements.  See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License.  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sqsio
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/testing/ptest"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/aws/smithy-go"
+)
+
+const testQueue = "https://sqs.eu-west-1.amazonaws.com/0/orders"
+
+func TestMain(m *testing.M) {
+	ptest.Main(m)
+}
+
+// epoch is the time the first message of the fake queue was sent.
+var epoch = time.Date(2022, 9, 1, 0, 0, 0, 0, time.UTC)
+
+// fakeMessage is a message of a fakeClient.
+type fakeMessage struct {
+	id       string
+	body     string
+	attrs    map[string]string
+	sent     time.Time
+	receives int
+	// handle is the receipt handle of the last receive of the message.
+	handle    string
+	visibleAt time.Time
+	deleted   bool
+}
+
+// fakeClient is a client of a queue of messages.
+type fakeClient struct {
+	messages []*fakeMessage
+	// failures is the number of SendMessageBatch requests that fail to send
+	// their first message.
+	failures int
+
+	mu         sync.Mutex
+	visibility map[string][]int32
+	sends      [][]types.SendMessageBatchRequestEntry
+}
+
+func (c *fakeClient) newClient(context.Context, string, string) (client, error) {
+	return c, nil
+}
+
+// add adds messages with the bodies to the queue, sent a second apart.
+func (c *fakeClient) add(bodies ...string) {
+	for _, b := range bodies {
+		n := len(c.messages)
+		c.messages = append(c.messages, &fakeMessage{
+			id:    fmt.Sprintf("m%v", n),
+			body:  b,
+			attrs: map[string]string{"a": b},
+			sent:  epoch.Add(time.Duration(n) * time.Second),
+		})
+	}
+}
+
+// message returns the message of the receipt handle, unless it's been
+// deleted or received again.
+func (c *fakeClient) message(handle string) *fakeMessage {
+	for _, m := range c.messages {
+		if m.handle == handle && !m.deleted {
+			return m
+		}
+	}
+	return nil
+}
+
+func (c *fakeClient) ReceiveMessage(_ context.Context, in *sqs.ReceiveMessageInput, _ ...func(*sqs.Options)) (*sqs.ReceiveMessageOutput, error) {
+	if aws.ToString(in.QueueUrl) != testQueue {
+		return nil, &smithy.GenericAPIError{Code: "AWS.SimpleQueueService.NonExistentQueue"}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	out := &sqs.ReceiveMessageOutput{}
+	for _, m := range c.messages {
+		if len(out.Messages) == int(in.MaxNumberOfMessages) {
+			break
+		}
+		if m.deleted || m.visibleAt.After(now) {
+			continue
+		}
+		m.receives++
+		m.handle = fmt.Sprintf("%v-%v", m.id, m.receives)
+		m.visibleAt = now.Add(time.Duration(in.VisibilityTimeout) * time.Second)
+		msg := types.Message{
+			MessageId:     aws.String(m.id),
+			ReceiptHandle: aws.String(m.handle),
+			Body:          aws.String(m.body),
+			Attributes: map[string]string{
+				"SentTimestamp":           strconv.FormatInt(m.sent.UnixMilli(), 10),
+				"ApproximateReceiveCount": strconv.Itoa(m.receives),
+			},
+			MessageAttributes: make(map[string]types.MessageAttributeValue),
+		}
+		for k, v := range m.attrs {
+			msg.MessageAttributes[k] = types.MessageAttributeValue{DataType: aws.String("String"), StringValue: aws.String(v)}
+		}
+		out.Messages = append(out.Messages, msg)
+	}
+	return out, nil
+}
+
+// invalidHandle is the result of an entry with an invalid receipt handle.
+func invalidHandle(id *string) types.BatchResultErrorEntry {
+	return types.BatchResultErrorEntry{Id: id, Code: aws.String("ReceiptHandleIsInvalid"), SenderFault: true}
+}
+
+func (c *fakeClient) ChangeMessageVisibilityBatch(_ context.Context, in *sqs.ChangeMessageVisibilityBatchInput, _ ...func(*sqs.Options)) (*sqs.ChangeMessageVisibilityBatchOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.visibility == nil {
+		c.visibility = make(map[string][]int32)
+	}
+	out := &sqs.ChangeMessageVisibilityBatchOutput{}
+	for _, e := range in.Entries {
+		m := c.message(aws.ToString(e.ReceiptHandle))
+		if m == nil {
+			out.Failed = append(out.Failed, invalidHandle(e.Id))
+			continue
+		}
+		m.visibleAt = time.Now().Add(time.Duration(e.VisibilityTimeout) * time.Second)
+		c.visibility[m.handle] = append(c.visibility[m.handle], e.VisibilityTimeout)
+	}
+	return out, nil
+}
+
+func (c *fakeClient) DeleteMessageBatch(_ context.Context, in *sqs.DeleteMessageBatchInput, _ ...func(*sqs.Options)) (*sqs.DeleteMessageBatchOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	out := &sqs.DeleteMessageBatchOutput{}
+	for _, e := range in.Entries {
+		m := c.message(aws.ToString(e.ReceiptHandle))
+		if m == nil {
+			out.Failed = append(out.Failed, invalidHandle(e.Id))
+			continue
+		}
+		m.deleted = true
+	}
+	return out, nil
+}
+
+// SendMessageBatch sends the messages, except for the first message of the
+// requests that fail, and messages with the body "reject", which are
+// rejected.
+func (c *fakeClient) SendMessageBatch(_ context.Context, in *sqs.SendMessageBatchInput, _ ...func(*sqs.Options)) (*sqs.SendMessageBatchOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	out := &sqs.SendMessageBatchOutput{}
+	var sent []types.SendMessageBatchRequestEntry
+	for i, e := range in.Entries {
+		switch {
+		case aws.ToString(e.MessageBody) == "reject":
+			out.Failed = append(out.Failed, types.BatchResultErrorEntry{Id: e.Id, Code: aws.String("InvalidMessageContents"), SenderFault: true})
+		case i == 0 && c.failures > 0:
+			c.failures--
+			out.Failed = append(out.Failed, types.BatchResultErrorEntry{Id: e.Id, Code: aws.String("InternalError")})
+		default:
+			sent = append(sent, e)
+		}
+	}
+	c.sends = append(c.sends, sent)
+	return out, nil
+}
+
+// deleted returns the bodies of the deleted messages.
+func (c *fakeClient) deleted() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var bodies []string
+	for _, m := range c.messages {
+		if m.deleted {
+			bodies = append(bodies, m.body)
+		}
+	}
+	return bodies
+}
+
+func TestIsRetryable(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{fmt.Errorf("connection reset"), true},
+		{&smithy.GenericAPIError{Code: "RequestThrottled"}, true},
+		{&smithy.GenericAPIError{Code: "ServiceUnavailable"}, true},
+		{&smithy.GenericAPIError{Code: "AWS.SimpleQueueService.NonExistentQueue"}, false},
+		{&smithy.GenericAPIError{Code: "AccessDenied"}, false},
+	}
+	for _, test := range tests {
+		if got := isRetryable(test.err); got != test.want {
+			t.Errorf("isRetryable(%v) = %v, want %v", test.err, got, test.want)
+		}
+	}
+}
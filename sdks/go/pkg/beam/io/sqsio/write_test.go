@@ -0,0 +1,156 @@
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements.  See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License.  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sqsio
+
+import (
+	"context"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/apache/beam/sdks/v2/go/pkg/beam"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+// write sends the messages with a writer of the client in one bundle, and
+// returns the error of finishing it.
+func write(t *testing.T, c *fakeClient, opts []WriteOptionFn, msgs ...Message) error {
+	t.Helper()
+	opts = append([]WriteOptionFn{WriteBackoff(time.Millisecond, time.Millisecond)}, opts...)
+	fn := &writeFn{Queue: testQueue, Options: newWriteOption(opts), newClient: c.newClient}
+	ctx := context.Background()
+	if err := fn.Setup(ctx); err != nil {
+		t.Fatalf("Setup() failed: %v", err)
+	}
+	for _, m := range msgs {
+		if err := fn.ProcessElement(ctx, m); err != nil {
+			return err
+		}
+	}
+	return fn.FinishBundle(ctx)
+}
+
+// messages returns n messages with bodies of n bytes.
+func messages(n int) []Message {
+	var msgs []Message
+	for i := 0; i < n; i++ {
+		msgs = append(msgs, Message{Body: strings.Repeat(strconv.Itoa(i), n)})
+	}
+	return msgs
+}
+
+// sizes returns the number of messages of each SendMessageBatch request.
+func sizes(sends [][]types.SendMessageBatchRequestEntry) []int {
+	var sizes []int
+	for _, s := range sends {
+		sizes = append(sizes, len(s))
+	}
+	return sizes
+}
+
+func TestWriteFn(t *testing.T) {
+	c := &fakeClient{}
+	msgs := messages(3)
+	msgs[2] = Message{Body: "222", Attributes: map[string]string{"k": "v"}, GroupID: "g", DeduplicationID: "d"}
+	if err := write(t, c, []WriteOptionFn{WriteBatchSize(2)}, msgs...); err != nil {
+		t.Fatalf("Write() failed: %v", err)
+	}
+	if got, want := sizes(c.sends), []int{2, 1}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("Write() sent batches of %v, want %v", got, want)
+	}
+	want := types.SendMessageBatchRequestEntry{
+		Id:                     aws.String("0"),
+		MessageBody:            aws.String("222"),
+		MessageAttributes:      map[string]types.MessageAttributeValue{"k": {DataType: aws.String("String"), StringValue: aws.String("v")}},
+		MessageGroupId:         aws.String("g"),
+		MessageDeduplicationId: aws.String("d"),
+	}
+	if got := c.sends[1][0]; !reflect.DeepEqual(got, want) {
+		t.Errorf("Write() sent %+v, want %+v", got, want)
+	}
+}
+
+func TestWriteFn_Bytes(t *testing.T) {
+	c := &fakeClient{}
+	big := strings.Repeat("a", 100<<10)
+	if err := write(t, c, nil, Message{Body: big}, Message{Body: big}, Message{Body: big}); err != nil {
+		t.Fatalf("Write() failed: %v", err)
+	}
+	// Requests are at most 256 KiB.
+	if got, want := sizes(c.sends), []int{2, 1}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Write() sent batches of %v, want %v", got, want)
+	}
+
+	if err := write(t, c, nil, Message{Body: strings.Repeat("a", 257<<10)}); err == nil {
+		t.Error("Write() of a message above 256 KiB succeeded, want error")
+	}
+}
+
+func TestWriteFn_Retries(t *testing.T) {
+	c := &fakeClient{failures: 2}
+	if err := write(t, c, nil, messages(3)...); err != nil {
+		t.Fatalf("Write() failed: %v", err)
+	}
+	// The failed message is retried until it's sent.
+	if got, want := sizes(c.sends), []int{2, 0, 1}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Write() sent batches of %v, want %v", got, want)
+	}
+
+	c = &fakeClient{failures: 3}
+	if err := write(t, c, []WriteOptionFn{WriteMaxRetries(2)}, messages(3)...); err == nil {
+		t.Error("Write() with failed messages after the retries succeeded, want error")
+	}
+}
+
+func TestWriteFn_Rejected(t *testing.T) {
+	c := &fakeClient{}
+	if err := write(t, c, nil, Message{Body: "a"}, Message{Body: "reject"}); err == nil {
+		t.Fatal("Write() of a rejected message succeeded, want error")
+	}
+	// Rejected messages aren't retried.
+	if got, want := len(c.sends), 1; got != want {
+		t.Errorf("Write() sent %v batches, want %v", got, want)
+	}
+}
+
+func TestWrite_Options(t *testing.T) {
+	tests := []struct {
+		name string
+		fn   func()
+	}{
+		{"no queue", func() {
+			s := beam.NewPipeline().Root()
+			Write(s, "", beam.Create(s, "a"))
+		}},
+		{"zero batch size", func() { WriteBatchSize(0) }},
+		{"batch size above 10", func() { WriteBatchSize(11) }},
+		{"negative max retries", func() { WriteMaxRetries(-1) }},
+		{"zero backoff", func() { WriteBackoff(0, time.Second) }},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%v succeeded, want panic", test.name)
+				}
+			}()
+			test.fn()
+		})
+	}
+}
@@ -0,0 +1,79 @@
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements.  See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License.  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package synthetic
+
+import (
+	"context"
+	"reflect"
+
+	"github.com/apache/beam/sdks/v2/go/pkg/beam"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/core/metrics"
+)
+
+func init() {
+	beam.RegisterType(reflect.TypeOf((*countFn)(nil)).Elem())
+}
+
+// countNamespace is the metrics namespace of the counters reported by Count.
+const countNamespace = "synthetic.Count"
+
+var (
+	countedElements = beam.NewCounter(countNamespace, "elements")
+	countedBytes    = beam.NewCounter(countNamespace, "bytes")
+)
+
+// Count creates a transform that consumes KV<[]byte, []byte> elements, such as
+// those emitted by a synthetic source, and counts the number of elements and
+// the total size of their keys and values in bytes. The counts are reported as
+// Beam counters, so they are safe to accumulate across bundles and workers, and
+// can be retrieved after the pipeline has run with CountResults.
+//
+// Usage example:
+//
+//    src := synthetic.SourceSingle(s, synthetic.DefaultSourceConfig().NumElements(1000).Build())
+//    synthetic.Count(s, src)
+//    res, err := beamx.RunWithMetrics(ctx, p)
+//    elements, bytes := synthetic.CountResults(res.Metrics())
+func Count(s beam.Scope, col beam.PCollection) {
+	s = s.Scope("synthetic.Count")
+	beam.ParDo0(s, &countFn{}, col)
+}
+
+// countFn is a DoFn implementing behavior for synthetic.Count.
+type countFn struct{}
+
+// ProcessElement adds an input to the element and byte counters.
+func (fn *countFn) ProcessElement(ctx context.Context, key, val []byte) {
+	countedElements.Inc(ctx, 1)
+	countedBytes.Inc(ctx, int64(len(key)+len(val)))
+}
+
+// CountResults returns the total number of elements and bytes counted by all
+// Count transforms in a pipeline, from the pipeline's metric results.
+func CountResults(res metrics.Results) (elements, bytes int64) {
+	counters := res.Query(func(r metrics.SingleResult) bool {
+		return r.Namespace() == countNamespace
+	}).Counters()
+	for _, c := range counters {
+		switch c.Name() {
+		case "elements":
+			elements += c.Result()
+		case "bytes":
+			bytes += c.Result()
+		}
+	}
+	return elements, bytes
+}
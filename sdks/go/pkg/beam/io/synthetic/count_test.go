@@ -0,0 +1,45 @@
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements.  See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License.  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package synthetic
+
+import (
+	"testing"
+
+	"github.com/apache/beam/sdks/v2/go/pkg/beam"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/testing/ptest"
+)
+
+// TestCount tests that Count reports the number of elements and bytes emitted
+// by a synthetic source.
+func TestCount(t *testing.T) {
+	const elms, keySize, valSize = 100, 4, 12
+
+	p, s := beam.NewPipelineWithRoot()
+	cfg := DefaultSourceConfig().NumElements(elms).InitialSplits(4).KeySize(keySize).ValueSize(valSize).Build()
+	Count(s, SourceSingle(s, cfg))
+
+	res, err := ptest.RunWithMetrics(p)
+	if err != nil {
+		t.Fatalf("Failed to execute pipeline: %v", err)
+	}
+	elements, bytes := CountResults(res.Metrics())
+	if elements != elms {
+		t.Errorf("Count reported wrong number of elements: got: %v, want: %v", elements, elms)
+	}
+	if want := int64(elms * (keySize + valSize)); bytes != want {
+		t.Errorf("Count reported wrong number of bytes: got: %v, want: %v", bytes, want)
+	}
+}
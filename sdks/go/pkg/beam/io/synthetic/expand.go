@@ -0,0 +1,99 @@
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements.  See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License.  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package synthetic
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+)
+
+// WeightedVariation is a variation of a base SourceConfig, used with
+// ExpandConfigs to produce a distribution of SourceConfigs.
+type WeightedVariation struct {
+	// Weight is the relative likelihood of this variation being chosen. Weights
+	// are normalized across all variations, so they do not need to sum to 1.
+	Weight float64
+	// Vary overrides fields of the base config by calling setters on a
+	// SourceConfigBuilder initialized with the base config. A nil Vary leaves
+	// the base config unchanged.
+	Vary func(b *SourceConfigBuilder)
+}
+
+// ExpandConfigs produces total SourceConfigs by repeatedly sampling one of the
+// given variations according to their weights, and applying it to the base
+// config. The result can be passed directly to beam.Create and used with
+// Source. Sampling uses a fixed seed, so the result is reproducible. To use a
+// different seed, see ExpandConfigsWithSeed.
+//
+// Usage example:
+//
+//    base := synthetic.DefaultSourceConfig().Build()
+//    cfgs := synthetic.ExpandConfigs(base, []synthetic.WeightedVariation{
+//        {Weight: 0.9, Vary: func(b *synthetic.SourceConfigBuilder) { b.NumElements(10) }},
+//        {Weight: 0.1, Vary: func(b *synthetic.SourceConfigBuilder) { b.NumElements(10000) }},
+//    }, 100)
+//    src := synthetic.Source(s, beam.CreateList(s, cfgs))
+func ExpandConfigs(base SourceConfig, variations []WeightedVariation, total int) []SourceConfig {
+	return ExpandConfigsWithSeed(base, variations, total, 0)
+}
+
+// ExpandConfigsWithSeed is ExpandConfigs with the given seed used for
+// sampling the variations. The same seed always produces the same configs.
+//
+// Panics if total is negative, if there are no variations, if any weight is
+// negative, if all weights are 0, or if any variation results in an invalid
+// SourceConfig.
+func ExpandConfigsWithSeed(base SourceConfig, variations []WeightedVariation, total int, seed int64) []SourceConfig {
+	if total < 0 {
+		panic(fmt.Sprintf("ExpandConfigs total cannot be negative. Got: %v", total))
+	}
+	if len(variations) == 0 {
+		panic("ExpandConfigs requires at least one variation")
+	}
+
+	// Build each variation once, and compute the cumulative normalized weights.
+	cfgs := make([]SourceConfig, len(variations))
+	cumulative := make([]float64, len(variations))
+	var sum float64
+	for i, v := range variations {
+		if v.Weight < 0 {
+			panic(fmt.Sprintf("ExpandConfigs variation weights cannot be negative. Got: %v", v.Weight))
+		}
+		b := &SourceConfigBuilder{cfg: base}
+		if v.Vary != nil {
+			v.Vary(b)
+		}
+		cfgs[i] = b.Build()
+		sum += v.Weight
+		cumulative[i] = sum
+	}
+	if sum == 0 {
+		panic("ExpandConfigs requires at least one variation with a positive weight")
+	}
+
+	rng := rand.New(rand.NewSource(seed))
+	out := make([]SourceConfig, total)
+	for i := range out {
+		sample := rng.Float64() * sum
+		idx := sort.Search(len(cumulative), func(j int) bool { return sample < cumulative[j] })
+		if idx == len(cumulative) { // Guard against floating point error at the top of the range.
+			idx--
+		}
+		out[i] = cfgs[idx]
+	}
+	return out
+}
@@ -0,0 +1,91 @@
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements.  See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License.  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package synthetic
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+// TestExpandConfigs tests that the proportions of the expanded configs match
+// the normalized weights of the variations.
+func TestExpandConfigs(t *testing.T) {
+	tests := []struct {
+		weights []float64
+	}{
+		{weights: []float64{1}},
+		{weights: []float64{0.9, 0.1}},
+		{weights: []float64{9, 1}},
+		{weights: []float64{1, 2, 3, 0}},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(fmt.Sprintf("(weights = %v)", test.weights), func(t *testing.T) {
+			const total = 10000
+			var variations []WeightedVariation
+			var sum float64
+			for i, w := range test.weights {
+				elms := i + 1
+				variations = append(variations, WeightedVariation{
+					Weight: w,
+					Vary:   func(b *SourceConfigBuilder) { b.NumElements(elms) },
+				})
+				sum += w
+			}
+
+			cfgs := ExpandConfigs(DefaultSourceConfig().KeySize(4).Build(), variations, total)
+			if got := len(cfgs); got != total {
+				t.Fatalf("ExpandConfigs produced wrong number of configs: got: %v, want: %v", got, total)
+			}
+			counts := make(map[int64]int)
+			for _, cfg := range cfgs {
+				if cfg.KeySize != 4 {
+					t.Fatalf("ExpandConfigs didn't keep base config fields: got KeySize %v, want 4", cfg.KeySize)
+				}
+				counts[cfg.NumElements]++
+			}
+			const tolerance = 0.02
+			for i, w := range test.weights {
+				want := w / sum
+				if got := float64(counts[int64(i+1)]) / total; got < want-tolerance || got > want+tolerance {
+					t.Errorf("ExpandConfigs produced wrong proportion of variation %v: got: %v, want: %v +/- %v",
+						i, got, want, tolerance)
+				}
+			}
+		})
+	}
+}
+
+// TestExpandConfigsWithSeed tests that expanding configs is reproducible with
+// the same seed.
+func TestExpandConfigsWithSeed(t *testing.T) {
+	variations := []WeightedVariation{
+		{Weight: 1, Vary: func(b *SourceConfigBuilder) { b.NumElements(1) }},
+		{Weight: 1, Vary: func(b *SourceConfigBuilder) { b.NumElements(2) }},
+	}
+	base := DefaultSourceConfig().Build()
+
+	a := ExpandConfigsWithSeed(base, variations, 100, 42)
+	b := ExpandConfigsWithSeed(base, variations, 100, 42)
+	if !reflect.DeepEqual(a, b) {
+		t.Errorf("ExpandConfigsWithSeed produced different configs with the same seed")
+	}
+	c := ExpandConfigsWithSeed(base, variations, 100, 43)
+	if reflect.DeepEqual(a, c) {
+		t.Errorf("ExpandConfigsWithSeed produced identical configs with different seeds")
+	}
+}
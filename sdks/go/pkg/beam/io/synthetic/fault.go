@@ -0,0 +1,115 @@
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements.  See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License.  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package synthetic
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+)
+
+// failureAttempts tracks how many times each injected failure has been
+// triggered in this process, keyed by the config and the failing element's
+// index, so that transient failures succeed once they have failed FailTimes
+// times.
+var failureAttempts = struct {
+	sync.Mutex
+	m map[failureKey]int64
+}{m: make(map[failureKey]int64)}
+
+type failureKey struct {
+	config SourceConfig
+	idx    int64
+}
+
+// injectFailure returns an error if the config's injected failure should fail
+// this attempt at processing the element at index idx, or nil otherwise. It
+// must only be called when idx is the config's FailAtElement.
+//
+// Attempts are counted per process, so transient failures only recover if the
+// retried bundle runs in the same process, as on the direct runner. On other
+// runners, a transient failure may fail more than FailTimes times.
+func injectFailure(config SourceConfig, idx int64) error {
+	if config.FailTimes == 0 {
+		return nil
+	}
+	failureAttempts.Lock()
+	defer failureAttempts.Unlock()
+
+	key := failureKey{config: config, idx: idx}
+	failureAttempts.m[key]++
+	attempt := failureAttempts.m[key]
+	if config.FailTimes > 0 && attempt > config.FailTimes {
+		return nil
+	}
+	return fmt.Errorf("synthetic source injected failure at element %v (attempt %v)", idx, attempt)
+}
+
+// FailureScope is an enum of the units of work that a synthetic source's
+// ErrorRate and PanicRate apply to.
+type FailureScope string
+
+const (
+	// FailureScopeElement draws an injected failure independently for every
+	// element the source emits.
+	FailureScopeElement FailureScope = "element"
+	// FailureScopeBundle draws an injected failure once for each restriction
+	// the source processes. A failing restriction fails at a random element,
+	// after emitting the elements before it.
+	FailureScopeBundle FailureScope = "bundle"
+)
+
+// injectedFailure is the outcome of drawing a random failure.
+type injectedFailure int
+
+const (
+	noFailure injectedFailure = iota
+	errorFailure
+	panicFailure
+)
+
+// drawFailure randomly chooses whether a unit of work fails, returning an
+// error with probability ErrorRate, or panicking with probability PanicRate.
+//
+// Unlike the rest of the source's data, draws come from the process-wide
+// random source instead of the config's Seed, so that retries of a failed
+// bundle can succeed.
+func drawFailure(config SourceConfig) injectedFailure {
+	if config.ErrorRate == 0 && config.PanicRate == 0 {
+		return noFailure
+	}
+	switch r := rand.Float64(); {
+	case r < config.ErrorRate:
+		return errorFailure
+	case r < config.ErrorRate+config.PanicRate:
+		return panicFailure
+	default:
+		return noFailure
+	}
+}
+
+// trigger performs the failure at the element at index idx, returning an
+// error for errorFailure and panicking for panicFailure.
+func (f injectedFailure) trigger(idx int64) error {
+	switch f {
+	case errorFailure:
+		return fmt.Errorf("synthetic source injected random error at element %v", idx)
+	case panicFailure:
+		panic(fmt.Sprintf("synthetic source injected random panic at element %v", idx))
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,158 @@
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements.  See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License.  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package synthetic
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/apache/beam/sdks/v2/go/pkg/beam"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/core/graph/mtime"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/testing/passert"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/testing/ptest"
+)
+
+// TestSourceConfig_FailAtElement_Transient tests that a transient injected
+// failure fails the pipeline until it has failed FailTimes times, after which
+// a retry of the pipeline emits every element. The direct runner doesn't retry
+// bundles, so retries are simulated by re-running the pipeline.
+func TestSourceConfig_FailAtElement_Transient(t *testing.T) {
+	const elms, failTimes = 50, 2
+	cfg := DefaultSourceConfig().NumElements(elms).InitialSplits(3).FailAtElement(37, failTimes).Build()
+
+	for attempt := 1; attempt <= failTimes+1; attempt++ {
+		p, s := beam.NewPipelineWithRoot()
+		passert.Count(s, SourceSingle(s, cfg), "out", elms)
+
+		err := ptest.Run(p)
+		if attempt <= failTimes && err == nil {
+			t.Fatalf("Pipeline succeeded on attempt %v, want injected failure", attempt)
+		}
+		if attempt > failTimes && err != nil {
+			t.Fatalf("Pipeline failed on attempt %v after the injected failure should have recovered: %v", attempt, err)
+		}
+	}
+}
+
+// TestSourceConfig_FailAtElement_Permanent tests that a permanent injected
+// failure fails every attempt.
+func TestSourceConfig_FailAtElement_Permanent(t *testing.T) {
+	cfg := DefaultSourceConfig().NumElements(20).FailAtElement(5, -1).Build()
+	for attempt := 1; attempt <= 3; attempt++ {
+		p, s := beam.NewPipelineWithRoot()
+		SourceSingle(s, cfg)
+		if err := ptest.Run(p); err == nil {
+			t.Fatalf("Pipeline succeeded on attempt %v, want injected failure", attempt)
+		}
+	}
+}
+
+// TestSourceConfig_FailAtElementValidation tests that Build rejects injected
+// failures at elements the source never emits.
+func TestSourceConfig_FailAtElementValidation(t *testing.T) {
+	defer func() {
+		if r := recover(); r == nil {
+			t.Errorf("Build did not panic for FailAtElement outside of NumElements")
+		}
+	}()
+	DefaultSourceConfig().NumElements(10).FailAtElement(10, 1).Build()
+}
+
+// TestSourceConfig_ErrorRate tests that random errors are returned from
+// ProcessElement at the configured rate for each failure scope.
+func TestSourceConfig_ErrorRate(t *testing.T) {
+	tests := []struct {
+		scope   FailureScope
+		rate    float64
+		elms    int
+		wantErr bool
+	}{
+		{scope: FailureScopeElement, rate: 0, elms: 100, wantErr: false},
+		{scope: FailureScopeElement, rate: 1, elms: 1, wantErr: true},
+		{scope: FailureScopeBundle, rate: 0, elms: 100, wantErr: false},
+		{scope: FailureScopeBundle, rate: 1, elms: 100, wantErr: true},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(fmt.Sprintf("(scope = %v, rate = %v)", test.scope, test.rate), func(t *testing.T) {
+			cfg := DefaultSourceConfig().NumElements(test.elms).ErrorRate(test.rate).FailureScope(test.scope).Build()
+			dfn := sourceFn{}
+			rest := dfn.CreateInitialRestriction(cfg)
+
+			var emitted int
+			emitFn := func(beam.EventTime, []byte, []byte) { emitted++ }
+			err := dfn.ProcessElement(context.Background(), mtime.ZeroTimestamp, dfn.CreateTracker(rest), cfg, emitFn)
+			if gotErr := err != nil; gotErr != test.wantErr {
+				t.Fatalf("sourceFn returned wrong error: got: %v, want error: %v", err, test.wantErr)
+			}
+			if !test.wantErr && emitted != test.elms {
+				t.Errorf("sourceFn emitted wrong number of elements: got: %v, want: %v", emitted, test.elms)
+			}
+			if test.wantErr && emitted >= test.elms {
+				t.Errorf("sourceFn emitted every element despite failing: got: %v, want less than: %v", emitted, test.elms)
+			}
+		})
+	}
+}
+
+// TestSourceConfig_PanicRate tests that random panics are raised from
+// ProcessElement, and that runners surface them as pipeline failures.
+func TestSourceConfig_PanicRate(t *testing.T) {
+	cfg := DefaultSourceConfig().NumElements(10).PanicRate(1).FailureScope(FailureScopeBundle).Build()
+
+	func() {
+		defer func() {
+			if r := recover(); r == nil {
+				t.Errorf("sourceFn did not panic with a PanicRate of 1")
+			}
+		}()
+		dfn := sourceFn{}
+		rest := dfn.CreateInitialRestriction(cfg)
+		dfn.ProcessElement(context.Background(), mtime.ZeroTimestamp, dfn.CreateTracker(rest), cfg, func(beam.EventTime, []byte, []byte) {})
+	}()
+
+	p, s := beam.NewPipelineWithRoot()
+	SourceSingle(s, cfg)
+	if err := ptest.Run(p); err == nil {
+		t.Errorf("Pipeline succeeded, want injected panic")
+	}
+}
+
+// TestSourceConfig_FailureRateValidation tests that Build rejects invalid
+// random failure configurations.
+func TestSourceConfig_FailureRateValidation(t *testing.T) {
+	tests := []struct {
+		name string
+		b    *SourceConfigBuilder
+	}{
+		{name: "NegativeErrorRate", b: DefaultSourceConfig().ErrorRate(-0.1)},
+		{name: "PanicRateAboveOne", b: DefaultSourceConfig().PanicRate(1.1)},
+		{name: "SumAboveOne", b: DefaultSourceConfig().ErrorRate(0.6).PanicRate(0.6)},
+		{name: "UnknownScope", b: DefaultSourceConfig().ErrorRate(0.1).FailureScope("worker")},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			defer func() {
+				if r := recover(); r == nil {
+					t.Errorf("Build did not panic for invalid config: %#v", test.b.cfg)
+				}
+			}()
+			test.b.Build()
+		})
+	}
+}
@@ -0,0 +1,75 @@
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements.  See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License.  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package synthetic
+
+import (
+	"flag"
+)
+
+// defaultFlagConfig holds the defaults used for the source config flags, so
+// that the flags share their defaults with DefaultSourceConfig.
+var defaultFlagConfig = DefaultSourceConfig().cfg
+
+var (
+	numRecords = flag.Int64("synthetic_num_records", defaultFlagConfig.NumElements,
+		"Number of elements for the synthetic source to emit.")
+	initialSplits = flag.Int64("synthetic_initial_splits", defaultFlagConfig.InitialSplits,
+		"Number of initial splits of the synthetic source.")
+	keySize = flag.Int64("synthetic_key_size", defaultFlagConfig.KeySize,
+		"Size in bytes of the keys emitted by the synthetic source.")
+	valueSize = flag.Int64("synthetic_value_size", defaultFlagConfig.ValueSize,
+		"Size in bytes of the values emitted by the synthetic source.")
+	numHotKeys = flag.Int64("synthetic_num_hot_keys", defaultFlagConfig.NumHotKeys,
+		"Number of distinct hot keys emitted by the synthetic source.")
+	hotKeyFraction = flag.Float64("synthetic_hot_key_fraction", defaultFlagConfig.HotKeyFraction,
+		"Fraction of elements emitted by the synthetic source with a hot key.")
+	keyMode = flag.String("synthetic_key_mode", string(defaultFlagConfig.KeyMode),
+		"Key generation mode of the synthetic source, either \"random\" or \"sequential\".")
+	outputFormat = flag.String("synthetic_output_format", string(defaultFlagConfig.OutputFormat),
+		"Output format of the synthetic source, one of \"kv\", \"bytes\", \"batch\" or \"row\".")
+	bundleFinalization = flag.Bool("synthetic_bundle_finalization", defaultFlagConfig.BundleFinalization,
+		"Whether the synthetic source registers a bundle finalization callback per bundle.")
+	initialDelay = flag.Duration("synthetic_initial_delay", defaultFlagConfig.InitialDelay,
+		"Delay before the synthetic source emits elements in each bundle.")
+)
+
+// SourceConfigFromFlags constructs a SourceConfig from the synthetic source
+// command-line flags, such as --synthetic_num_records and --synthetic_key_size.
+// The flags are prefixed with "synthetic_" so that they don't collide with the
+// flags of pipelines that import this package. Flags that are not set keep the
+// defaults of DefaultSourceConfig. The config is validated the same way as
+// SourceConfigBuilder.Build, and this function panics if any flag has been set
+// to an invalid value.
+//
+// Flags must be parsed, such as by beam.Init, before calling this function.
+//
+// Usage example:
+//
+//    src := synthetic.SourceSingle(s, synthetic.SourceConfigFromFlags())
+func SourceConfigFromFlags() SourceConfig {
+	return DefaultSourceConfig().
+		NumElements(int(*numRecords)).
+		InitialSplits(int(*initialSplits)).
+		KeySize(int(*keySize)).
+		ValueSize(int(*valueSize)).
+		NumHotKeys(int(*numHotKeys)).
+		HotKeyFraction(*hotKeyFraction).
+		KeyMode(KeyMode(*keyMode)).
+		InitialDelay(*initialDelay).
+		OutputFormat(OutputFormat(*outputFormat)).
+		BundleFinalization(*bundleFinalization).
+		Build()
+}
@@ -0,0 +1,61 @@
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements.  See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License.  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package synthetic
+
+import (
+	"flag"
+	"testing"
+	"time"
+)
+
+// TestSourceConfigFromFlags tests that a SourceConfig built from flags matches
+// the equivalent config built by the SourceConfigBuilder.
+func TestSourceConfigFromFlags(t *testing.T) {
+	if got, want := SourceConfigFromFlags(), DefaultSourceConfig().Build(); got != want {
+		t.Errorf("Invalid default SourceConfig: got: %#v, want: %#v", got, want)
+	}
+
+	flags := map[string]string{
+		"synthetic_num_records":      "100",
+		"synthetic_initial_splits":   "4",
+		"synthetic_key_size":         "16",
+		"synthetic_value_size":       "32",
+		"synthetic_num_hot_keys":     "3",
+		"synthetic_hot_key_fraction": "0.5",
+		"synthetic_initial_delay":    "10ms",
+	}
+	for name, val := range flags {
+		f := flag.Lookup(name)
+		old := f.Value.String()
+		if err := flag.Set(name, val); err != nil {
+			t.Fatalf("Failed to set flag %v: %v", name, err)
+		}
+		defer flag.Set(name, old)
+	}
+
+	want := DefaultSourceConfig().
+		NumElements(100).
+		InitialSplits(4).
+		KeySize(16).
+		ValueSize(32).
+		NumHotKeys(3).
+		HotKeyFraction(0.5).
+		InitialDelay(10 * time.Millisecond).
+		Build()
+	if got := SourceConfigFromFlags(); got != want {
+		t.Errorf("Invalid SourceConfig from flags: got: %#v, want: %#v", got, want)
+	}
+}
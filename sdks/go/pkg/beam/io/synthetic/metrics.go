@@ -0,0 +1,93 @@
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements.  See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License.  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package synthetic
+
+import (
+	"github.com/apache/beam/sdks/v2/go/pkg/beam"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/core/metrics"
+)
+
+// sourceNamespace is the metrics namespace of the metrics reported by
+// synthetic sources.
+const sourceNamespace = "synthetic.Source"
+
+var (
+	elementsEmitted = beam.NewCounter(sourceNamespace, "elements_emitted")
+	bytesEmitted    = beam.NewCounter(sourceNamespace, "bytes_emitted")
+	emitLatency     = beam.NewDistribution(sourceNamespace, "emit_latency_nanos")
+)
+
+// SourceMetrics are the metrics reported by synthetic sources, aggregated for
+// all synthetic sources in a pipeline.
+type SourceMetrics struct {
+	// Elements is the number of elements emitted.
+	Elements int64
+	// Bytes is the total size of the keys and values of the emitted elements.
+	Bytes int64
+	// EmitLatency is the distribution of the time in nanoseconds spent
+	// emitting each element, which includes the time spent in fused
+	// downstream transforms.
+	EmitLatency metrics.DistributionValue
+}
+
+// SourceResults returns the metrics reported by all synthetic sources in a
+// pipeline, from the pipeline's metric results. This lets load tests assert
+// on the throughput of sources without instrumenting them separately.
+//
+// Usage example:
+//
+//    src := synthetic.SourceSingle(s, synthetic.DefaultSourceConfig().NumElements(1000).Build())
+//    res, err := beamx.RunWithMetrics(ctx, p)
+//    m := synthetic.SourceResults(res.Metrics())
+func SourceResults(res metrics.Results) SourceMetrics {
+	var m SourceMetrics
+	qr := res.Query(func(r metrics.SingleResult) bool {
+		return r.Namespace() == sourceNamespace
+	})
+	for _, c := range qr.Counters() {
+		switch c.Name() {
+		case "elements_emitted":
+			m.Elements += c.Result()
+		case "bytes_emitted":
+			m.Bytes += c.Result()
+		}
+	}
+	for _, d := range qr.Distributions() {
+		if d.Name() != "emit_latency_nanos" {
+			continue
+		}
+		m.EmitLatency = mergeDistributions(m.EmitLatency, d.Result())
+	}
+	return m
+}
+
+// mergeDistributions combines the values of two distributions.
+func mergeDistributions(a, b metrics.DistributionValue) metrics.DistributionValue {
+	if a.Count == 0 {
+		return b
+	}
+	if b.Count == 0 {
+		return a
+	}
+	m := metrics.DistributionValue{Count: a.Count + b.Count, Sum: a.Sum + b.Sum, Min: a.Min, Max: a.Max}
+	if b.Min < m.Min {
+		m.Min = b.Min
+	}
+	if b.Max > m.Max {
+		m.Max = b.Max
+	}
+	return m
+}
@@ -0,0 +1,77 @@
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements.  See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License.  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package synthetic
+
+import (
+	"testing"
+
+	"github.com/apache/beam/sdks/v2/go/pkg/beam"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/core/metrics"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/testing/ptest"
+)
+
+// TestSourceResults tests that synthetic sources report the elements and
+// bytes they emit, and the latency of each emit, across SourceConfigs and
+// restrictions.
+func TestSourceResults(t *testing.T) {
+	const keySize, valSize = 3, 5
+	p, s := beam.NewPipelineWithRoot()
+	cfgs := beam.Create(s,
+		DefaultSourceConfig().NumElements(100).InitialSplits(4).KeySize(keySize).ValueSize(valSize).Build(),
+		DefaultSourceConfig().NumElements(50).InitialSplits(3).KeySize(keySize).ValueSize(valSize).Build())
+	Count(s, Source(s, cfgs))
+
+	res, err := ptest.RunWithMetrics(p)
+	if err != nil {
+		t.Fatalf("Failed to execute pipeline: %v", err)
+	}
+	got := SourceResults(res.Metrics())
+	const wantElms = 150
+	if got.Elements != wantElms {
+		t.Errorf("SourceResults returned wrong number of elements: got: %v, want: %v", got.Elements, wantElms)
+	}
+	if want := int64(wantElms * (keySize + valSize)); got.Bytes != want {
+		t.Errorf("SourceResults returned wrong number of bytes: got: %v, want: %v", got.Bytes, want)
+	}
+	if got.EmitLatency.Count != wantElms {
+		t.Errorf("SourceResults returned wrong number of emit latencies: got: %v, want: %v", got.EmitLatency.Count, wantElms)
+	}
+	if lat := got.EmitLatency; lat.Min < 0 || lat.Min > lat.Max || lat.Sum < lat.Max {
+		t.Errorf("SourceResults returned inconsistent emit latencies: %+v", lat)
+	}
+
+	// The emitted elements should match those counted downstream.
+	if elms, bytes := CountResults(res.Metrics()); elms != got.Elements || bytes != got.Bytes {
+		t.Errorf("SourceResults doesn't match CountResults: got: (%v, %v), want: (%v, %v)", got.Elements, got.Bytes, elms, bytes)
+	}
+}
+
+// TestMergeDistributions tests that merging distributions combines their
+// counts, sums and extremes, and ignores empty distributions.
+func TestMergeDistributions(t *testing.T) {
+	a := metrics.DistributionValue{Count: 2, Sum: 10, Min: 3, Max: 7}
+	b := metrics.DistributionValue{Count: 3, Sum: 9, Min: 1, Max: 5}
+	if got, want := mergeDistributions(a, b), (metrics.DistributionValue{Count: 5, Sum: 19, Min: 1, Max: 7}); got != want {
+		t.Errorf("mergeDistributions(%v, %v) = %v, want: %v", a, b, got, want)
+	}
+	empty := metrics.DistributionValue{}
+	if got := mergeDistributions(empty, b); got != b {
+		t.Errorf("mergeDistributions(%v, %v) = %v, want: %v", empty, b, got, b)
+	}
+	if got := mergeDistributions(a, empty); got != a {
+		t.Errorf("mergeDistributions(%v, %v) = %v, want: %v", a, empty, got, a)
+	}
+}
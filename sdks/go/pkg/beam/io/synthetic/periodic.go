@@ -0,0 +1,163 @@
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements.  See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License.  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package synthetic
+
+import (
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/apache/beam/sdks/v2/go/pkg/beam"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/core/graph/mtime"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/core/sdf"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/io/rtrackers/offsetrange"
+)
+
+func init() {
+	beam.RegisterType(reflect.TypeOf((*periodicTickFn)(nil)).Elem())
+}
+
+// PeriodicSource creates a synthetic source transform that emits a batch of
+// randomly generated KV<[]byte, []byte> elements on a schedule, in order to
+// produce windowed streaming load. Starting at start, the source ticks every
+// interval until end, and on each tick emits the elements of cfg.
+//
+// The elements of each tick have event times aligned to the tick. With
+// TimestampModeNone, every element of a tick has the tick's time as its event
+// time, and with other modes the tick's time is used as the StartTimestamp of
+// the batch. Each tick's batch uses a different Seed, derived from cfg's Seed
+// and the tick's index, so batches are distinct but reproducible.
+//
+// Ticks in the past are emitted immediately, and the source waits for ticks in
+// the future by self-checkpointing, so its output is unbounded. Runners that
+// can't resume checkpoints, such as the direct runner, only emit the ticks
+// that are in the past when the pipeline starts.
+//
+// Usage example:
+//
+//    cfg := synthetic.DefaultSourceConfig().NumElements(1000).Build()
+//    now := time.Now()
+//    src := synthetic.PeriodicSource(s, cfg, now, now.Add(time.Hour), time.Minute)
+//
+// PeriodicSource accepts additional parameters as sourceOptions, such as
+// WithReshuffle.
+func PeriodicSource(s beam.Scope, cfg SourceConfig, start, end time.Time, interval time.Duration, opts ...sourceOption) beam.PCollection {
+	if interval <= 0 {
+		panic(fmt.Sprintf("synthetic.PeriodicSource interval must be positive. Got: %v", interval))
+	}
+	if end.Before(start) {
+		panic(fmt.Sprintf("synthetic.PeriodicSource end must not be before start. Got: %v before %v", end, start))
+	}
+	s = s.Scope("synthetic.PeriodicSource")
+
+	ticks := beam.ParDo(s, &periodicTickFn{
+		Config:   cfg,
+		Start:    mtime.FromTime(start).Milliseconds(),
+		End:      mtime.FromTime(end).Milliseconds(),
+		Interval: interval,
+	}, beam.Impulse(s))
+	return Source(s, ticks, opts...)
+}
+
+// periodicTickFn is a splittable DoFn that emits a SourceConfig for every tick
+// of a PeriodicSource, with the tick's time as its event time. Its restriction
+// is the range of tick indices, and a tick is only claimed once its time has
+// passed.
+type periodicTickFn struct {
+	Config   SourceConfig
+	Start    int64 // Time of the first tick, in milliseconds.
+	End      int64 // Time before which the last tick occurs, in milliseconds.
+	Interval time.Duration
+}
+
+// tickTime returns the time of the tick at index idx.
+func (fn *periodicTickFn) tickTime(idx int64) mtime.Time {
+	return mtime.FromMilliseconds(fn.Start).Add(time.Duration(idx) * fn.Interval)
+}
+
+// tickConfig returns the SourceConfig of the batch emitted at the tick with
+// index idx.
+func (fn *periodicTickFn) tickConfig(idx int64) SourceConfig {
+	cfg := fn.Config
+	cfg.Seed += idx
+	if cfg.TimestampMode != TimestampModeNone {
+		cfg.StartTimestamp = fn.tickTime(idx).Milliseconds()
+	}
+	return cfg
+}
+
+// CreateInitialRestriction creates an offset range restriction representing
+// the ticks from Start until End.
+func (fn *periodicTickFn) CreateInitialRestriction(_ []byte) offsetrange.Restriction {
+	span := time.Duration(fn.End-fn.Start) * time.Millisecond
+	return offsetrange.Restriction{
+		Start: 0,
+		End:   int64((span + fn.Interval - 1) / fn.Interval),
+	}
+}
+
+// SplitRestriction does not split the restriction, since the ticks must be
+// emitted in order.
+func (fn *periodicTickFn) SplitRestriction(_ []byte, rest offsetrange.Restriction) []offsetrange.Restriction {
+	return []offsetrange.Restriction{rest}
+}
+
+// RestrictionSize outputs the size of the restriction as the number of ticks
+// in it.
+func (fn *periodicTickFn) RestrictionSize(_ []byte, rest offsetrange.Restriction) float64 {
+	return rest.Size()
+}
+
+// CreateTracker creates an offset range restriction tracker for the
+// restriction.
+func (fn *periodicTickFn) CreateTracker(rest offsetrange.Restriction) *sdf.LockRTracker {
+	return sdf.NewLockRTracker(offsetrange.NewTracker(rest))
+}
+
+// InitialWatermarkEstimatorState starts the watermark at the first tick.
+func (fn *periodicTickFn) InitialWatermarkEstimatorState(_ beam.EventTime, _ offsetrange.Restriction, _ []byte) lagWatermarkState {
+	return lagWatermarkState{MaxTimestamp: fn.Start}
+}
+
+// CreateWatermarkEstimator creates a watermark estimator that advances the
+// watermark to the latest emitted tick.
+func (fn *periodicTickFn) CreateWatermarkEstimator(state lagWatermarkState) *lagWatermarkEstimator {
+	return &lagWatermarkEstimator{state: state}
+}
+
+// WatermarkEstimatorState returns the state of the watermark estimator.
+func (fn *periodicTickFn) WatermarkEstimatorState(e *lagWatermarkEstimator) lagWatermarkState {
+	return e.state
+}
+
+// ProcessElement emits the SourceConfig of every tick whose time has passed,
+// and once it reaches a tick in the future, checkpoints and asks the runner to
+// resume processing at the tick's time.
+func (fn *periodicTickFn) ProcessElement(rt *sdf.LockRTracker, _ []byte, emit func(beam.EventTime, SourceConfig)) sdf.ProcessContinuation {
+	for i := rt.GetRestriction().(offsetrange.Restriction).Start; ; i++ {
+		if i >= rt.GetRestriction().(offsetrange.Restriction).End {
+			return sdf.StopProcessing()
+		}
+		tick := fn.tickTime(i)
+		if wait := time.Until(tick.ToTime()); wait > 0 {
+			return sdf.ResumeProcessingIn(wait)
+		}
+		if !rt.TryClaim(i) {
+			return sdf.StopProcessing()
+		}
+		emit(tick, fn.tickConfig(i))
+	}
+}
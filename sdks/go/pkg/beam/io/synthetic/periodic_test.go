@@ -0,0 +1,83 @@
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements.  See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License.  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package synthetic
+
+import (
+	"testing"
+	"time"
+
+	"github.com/apache/beam/sdks/v2/go/pkg/beam"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/core/graph/mtime"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/testing/passert"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/testing/ptest"
+)
+
+// TestPeriodicSource tests that a periodic source emits a batch of elements
+// for every tick in the past.
+func TestPeriodicSource(t *testing.T) {
+	p, s := beam.NewPipelineWithRoot()
+	start := time.Now().Add(-time.Hour)
+	cfg := DefaultSourceConfig().NumElements(3).Build()
+	src := PeriodicSource(s, cfg, start, start.Add(5*time.Minute), time.Minute)
+	passert.Count(s, src, "out", 15)
+
+	ptest.RunAndValidate(t, p)
+}
+
+// TestPeriodicTickFn tests that ticks are emitted with event times and configs
+// aligned to the tick, and that the tick function waits for ticks in the
+// future.
+func TestPeriodicTickFn(t *testing.T) {
+	start := time.Now().Add(-90 * time.Second).Truncate(time.Millisecond)
+	fn := &periodicTickFn{
+		Config:   DefaultSourceConfig().Seed(7).TimestampMode(TimestampModeMonotonic).Build(),
+		Start:    mtime.FromTime(start).Milliseconds(),
+		End:      mtime.FromTime(start.Add(time.Hour)).Milliseconds(),
+		Interval: time.Minute,
+	}
+	rest := fn.CreateInitialRestriction(nil)
+	if got, want := rest.End-rest.Start, int64(60); got != want {
+		t.Fatalf("periodicTickFn created wrong number of ticks: got: %v, want: %v", got, want)
+	}
+
+	var times []beam.EventTime
+	var cfgs []SourceConfig
+	cont := fn.ProcessElement(fn.CreateTracker(rest), nil, func(et beam.EventTime, cfg SourceConfig) {
+		times = append(times, et)
+		cfgs = append(cfgs, cfg)
+	})
+	if got := len(times); got != 2 {
+		t.Fatalf("periodicTickFn emitted wrong number of past ticks: got: %v, want: 2", got)
+	}
+	for i, et := range times {
+		want := mtime.FromTime(start.Add(time.Duration(i) * time.Minute))
+		if et != want {
+			t.Errorf("periodicTickFn emitted tick %v at wrong time: got: %v, want: %v", i, et, want)
+		}
+		if got := cfgs[i].StartTimestamp; got != want.Milliseconds() {
+			t.Errorf("periodicTickFn emitted tick %v with wrong StartTimestamp: got: %v, want: %v", i, got, want.Milliseconds())
+		}
+		if got, want := cfgs[i].Seed, int64(7+i); got != want {
+			t.Errorf("periodicTickFn emitted tick %v with wrong Seed: got: %v, want: %v", i, got, want)
+		}
+	}
+	if !cont.ShouldResume() {
+		t.Fatalf("periodicTickFn did not resume for ticks in the future")
+	}
+	if got := cont.ResumeDelay(); got <= 0 || got > 30*time.Second {
+		t.Errorf("periodicTickFn returned wrong resume delay: got: %v, want: in (0, 30s]", got)
+	}
+}
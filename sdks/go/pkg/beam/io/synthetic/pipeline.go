@@ -0,0 +1,297 @@
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements.  See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License.  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package synthetic
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/apache/beam/sdks/v2/go/pkg/beam"
+)
+
+func init() {
+	beam.RegisterType(reflect.TypeOf((*reiterateFn)(nil)).Elem())
+	beam.RegisterType(reflect.TypeOf((*reiterateCoGBKFn)(nil)).Elem())
+	beam.RegisterType(reflect.TypeOf((*sideInputAccessFn)(nil)).Elem())
+}
+
+// Shape is an enum of the standard load test topologies that Pipeline can
+// assemble. The shapes mirror the load test pipelines of the Java and Python
+// SDKs, so that results can be compared across SDKs.
+type Shape string
+
+const (
+	// ShapeFanout reads a source and groups it by key in Fanout parallel
+	// branches, each of which reiterates over the grouped values.
+	ShapeFanout Shape = "fanout"
+	// ShapeGBKSequence reads a source and groups it by key Stages times in a
+	// row, reiterating over the grouped values after each GroupByKey.
+	ShapeGBKSequence Shape = "gbk_sequence"
+	// ShapeCoGBK reads two sources, Source and CoSource, and joins them with a
+	// CoGroupByKey, reiterating over the grouped values of both inputs.
+	ShapeCoGBK Shape = "cogbk"
+	// ShapeSideInput reads two sources, and processes every element of Source
+	// with the elements of CoSource as a side input, of which each element
+	// accesses the first SideInputAccessFraction.
+	ShapeSideInput Shape = "side_input"
+)
+
+// Pipeline adds the synthetic load test topology described by the
+// PipelineConfig to the scope, and returns the PCollections that it outputs.
+// Each shape returns a single PCollection, except for ShapeFanout which
+// returns one per branch. The outputs can be consumed by a Sink, or by
+// transforms collecting runtime metrics.
+//
+// The recommended way to create PipelineConfigs is via the
+// PipelineConfigBuilder. Usage example:
+//
+//    src := synthetic.DefaultSourceConfig().NumElements(1000).Build()
+//    cfg := synthetic.DefaultPipelineConfig(synthetic.ShapeFanout).Source(src).Fanout(4).Build()
+//    for _, out := range synthetic.Pipeline(s, cfg) {
+//        synthetic.Sink(s, synthetic.DefaultSinkConfig().Build(), out)
+//    }
+func Pipeline(s beam.Scope, cfg PipelineConfig) []beam.PCollection {
+	s = s.Scope(fmt.Sprintf("synthetic.Pipeline(%v)", cfg.Shape))
+
+	src := SourceSingle(s, cfg.Source)
+	switch cfg.Shape {
+	case ShapeFanout:
+		outs := make([]beam.PCollection, cfg.Fanout)
+		for i := range outs {
+			outs[i] = beam.ParDo(s, &reiterateFn{Iterations: cfg.Iterations}, beam.GroupByKey(s, src))
+		}
+		return outs
+	case ShapeGBKSequence:
+		col := src
+		for i := 0; i < cfg.Stages; i++ {
+			col = beam.ParDo(s, &reiterateFn{Iterations: cfg.Iterations}, beam.GroupByKey(s, col))
+		}
+		return []beam.PCollection{col}
+	case ShapeCoGBK:
+		co := SourceSingle(s, cfg.CoSource)
+		joined := beam.CoGroupByKey(s, src, co)
+		return []beam.PCollection{beam.ParDo(s, &reiterateCoGBKFn{Iterations: cfg.Iterations}, joined)}
+	case ShapeSideInput:
+		side := SourceSingle(s, cfg.CoSource)
+		access := int64(float64(cfg.CoSource.NumElements) * cfg.SideInputAccessFraction)
+		out := beam.ParDo(s, &sideInputAccessFn{ElementsToAccess: access}, src, beam.SideInput{Input: side})
+		return []beam.PCollection{out}
+	}
+	panic(fmt.Sprintf("synthetic.Pipeline received unknown Shape %q", cfg.Shape))
+}
+
+// reiterateFn iterates Iterations times over the values grouped for each key,
+// and emits the key with the last value, so that the output has one element
+// per key. See iterate.
+type reiterateFn struct {
+	Iterations int
+}
+
+func (fn *reiterateFn) ProcessElement(key []byte, values func(*[]byte) bool, emit func([]byte, []byte)) {
+	var last []byte
+	iterate(values, fn.Iterations, func(value []byte, _ int) { last = value })
+	emit(key, last)
+}
+
+// reiterateCoGBKFn iterates Iterations times over the values of both inputs
+// grouped for each key, and emits every value once, on the last iteration. See
+// iterate.
+type reiterateCoGBKFn struct {
+	Iterations int
+}
+
+func (fn *reiterateCoGBKFn) ProcessElement(key []byte, values1, values2 func(*[]byte) bool, emit func([]byte, []byte)) {
+	for _, values := range []func(*[]byte) bool{values1, values2} {
+		iterate(values, fn.Iterations, func(value []byte, i int) {
+			if i == fn.Iterations-1 {
+				emit(key, value)
+			}
+		})
+	}
+}
+
+// iterate calls fn with each value of a GroupByKey result and the index of the
+// current iteration, iterations times. GroupByKey results can only be iterated
+// once in the Go SDK, so when reiterating, the values are buffered in memory
+// on the first iteration, and later iterations read the buffer. This still
+// measures the cost of reiteration, but unlike the Java and Python load tests,
+// it doesn't measure the runner's cost of re-reading the values.
+func iterate(values func(*[]byte) bool, iterations int, fn func(value []byte, i int)) {
+	var value []byte
+	var buf [][]byte
+	for values(&value) {
+		fn(value, 0)
+		if iterations > 1 {
+			buf = append(buf, value)
+		}
+	}
+	for i := 1; i < iterations; i++ {
+		for _, value := range buf {
+			fn(value, i)
+		}
+	}
+}
+
+// sideInputAccessFn reads the first ElementsToAccess elements of its side
+// input for every main input, and emits each main input unchanged.
+type sideInputAccessFn struct {
+	ElementsToAccess int64
+}
+
+func (fn *sideInputAccessFn) ProcessElement(key, value []byte, side func(*[]byte, *[]byte) bool, emit func([]byte, []byte)) {
+	var sideKey, sideValue []byte
+	for i := int64(0); i < fn.ElementsToAccess; i++ {
+		if !side(&sideKey, &sideValue) {
+			break
+		}
+	}
+	emit(key, value)
+}
+
+// PipelineConfigBuilder is used to initialize PipelineConfigs. See
+// PipelineConfigBuilder's methods for descriptions of the fields in a
+// PipelineConfig and how they can be set. The intended approach for using this
+// builder is to begin by calling the DefaultPipelineConfig function, followed
+// by calling setters, followed by calling Build.
+//
+// Usage example:
+//
+//    cfg := synthetic.DefaultPipelineConfig(synthetic.ShapeGBKSequence).Stages(3).Build()
+type PipelineConfigBuilder struct {
+	cfg PipelineConfig
+}
+
+// DefaultPipelineConfig creates a PipelineConfig of the given shape with
+// intended defaults for its other fields. This function is the intended
+// starting point for initializing a PipelineConfig and should always be used
+// to create PipelineConfigBuilders.
+//
+// To see descriptions of the various PipelineConfig fields and their defaults,
+// see the methods to PipelineConfigBuilder.
+func DefaultPipelineConfig(shape Shape) *PipelineConfigBuilder {
+	return &PipelineConfigBuilder{
+		cfg: PipelineConfig{
+			Shape:                   shape,
+			Source:                  DefaultSourceConfig().Build(),
+			CoSource:                DefaultSourceConfig().Build(),
+			Fanout:                  1,
+			Stages:                  1,
+			Iterations:              1,
+			SideInputAccessFraction: 1, // Defaults to accessing the whole side input.
+		},
+	}
+}
+
+// Source is the config of the synthetic source read by every shape. For the
+// shapes with two inputs, it is the main or first input.
+//
+// The default value is DefaultSourceConfig().Build(). Only the OutputFormatKV
+// output format is valid.
+func (b *PipelineConfigBuilder) Source(val SourceConfig) *PipelineConfigBuilder {
+	b.cfg.Source = val
+	return b
+}
+
+// CoSource is only applicable to ShapeCoGBK and ShapeSideInput, and is the
+// config of the synthetic source read as the second input of the CoGroupByKey,
+// or as the side input.
+//
+// The default value is DefaultSourceConfig().Build(). Only the OutputFormatKV
+// output format is valid.
+func (b *PipelineConfigBuilder) CoSource(val SourceConfig) *PipelineConfigBuilder {
+	b.cfg.CoSource = val
+	return b
+}
+
+// Fanout is only applicable to ShapeFanout, and is the number of parallel
+// GroupByKey branches that read the source.
+//
+// Valid values are in the range of [1, ...] and the default value is 1.
+func (b *PipelineConfigBuilder) Fanout(val int) *PipelineConfigBuilder {
+	b.cfg.Fanout = val
+	return b
+}
+
+// Stages is only applicable to ShapeGBKSequence, and is the number of
+// GroupByKeys performed in a row.
+//
+// Valid values are in the range of [1, ...] and the default value is 1.
+func (b *PipelineConfigBuilder) Stages(val int) *PipelineConfigBuilder {
+	b.cfg.Stages = val
+	return b
+}
+
+// Iterations is applicable to the shapes with GroupByKeys or CoGroupByKeys,
+// and is the number of times the grouped values of each key are iterated over,
+// in order to measure the cost of reiteration in runners.
+//
+// Valid values are in the range of [1, ...] and the default value is 1.
+func (b *PipelineConfigBuilder) Iterations(val int) *PipelineConfigBuilder {
+	b.cfg.Iterations = val
+	return b
+}
+
+// SideInputAccessFraction is only applicable to ShapeSideInput, and is the
+// fraction of the side input's elements that are read for each main input.
+//
+// Valid values are floating point numbers from 0 to 1, and the default value
+// is 1, meaning the whole side input is read.
+func (b *PipelineConfigBuilder) SideInputAccessFraction(val float64) *PipelineConfigBuilder {
+	b.cfg.SideInputAccessFraction = val
+	return b
+}
+
+// Build constructs the PipelineConfig initialized by this builder. It also
+// performs error checking on the fields, and panics if any have been set to
+// invalid values.
+func (b *PipelineConfigBuilder) Build() PipelineConfig {
+	switch b.cfg.Shape {
+	case ShapeFanout, ShapeGBKSequence, ShapeCoGBK, ShapeSideInput:
+	default:
+		panic(fmt.Sprintf("PipelineConfig.Shape must be one of %q, %q, %q, or %q. Got: %q",
+			ShapeFanout, ShapeGBKSequence, ShapeCoGBK, ShapeSideInput, b.cfg.Shape))
+	}
+	if b.cfg.Source.OutputFormat != OutputFormatKV || b.cfg.CoSource.OutputFormat != OutputFormatKV {
+		panic(fmt.Sprintf("PipelineConfig sources must use OutputFormat %q. Got: %q and %q",
+			OutputFormatKV, b.cfg.Source.OutputFormat, b.cfg.CoSource.OutputFormat))
+	}
+	if b.cfg.Fanout <= 0 {
+		panic(fmt.Sprintf("PipelineConfig.Fanout must be >= 1. Got: %v", b.cfg.Fanout))
+	}
+	if b.cfg.Stages <= 0 {
+		panic(fmt.Sprintf("PipelineConfig.Stages must be >= 1. Got: %v", b.cfg.Stages))
+	}
+	if b.cfg.Iterations <= 0 {
+		panic(fmt.Sprintf("PipelineConfig.Iterations must be >= 1. Got: %v", b.cfg.Iterations))
+	}
+	if b.cfg.SideInputAccessFraction < 0 || b.cfg.SideInputAccessFraction > 1 {
+		panic(fmt.Sprintf("PipelineConfig.SideInputAccessFraction must be a floating point number from 0 and 1. Got: %v", b.cfg.SideInputAccessFraction))
+	}
+	return b.cfg
+}
+
+// PipelineConfig is a struct containing all the configuration options for a
+// synthetic load test pipeline. It should be created via a
+// PipelineConfigBuilder, not by directly initializing it.
+type PipelineConfig struct {
+	Shape                   Shape
+	Source                  SourceConfig
+	CoSource                SourceConfig
+	Fanout                  int
+	Stages                  int
+	Iterations              int
+	SideInputAccessFraction float64
+}
@@ -0,0 +1,84 @@
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements.  See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License.  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package synthetic
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/apache/beam/sdks/v2/go/pkg/beam"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/testing/passert"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/testing/ptest"
+)
+
+// TestPipeline tests that each pipeline shape can be constructed and run, and
+// outputs the expected number of elements.
+func TestPipeline(t *testing.T) {
+	src := DefaultSourceConfig().NumElements(20).KeySize(2).KeyMode(KeyModeSequential).Build()
+	co := DefaultSourceConfig().NumElements(10).KeySize(2).KeyMode(KeyModeSequential).Build()
+	tests := []struct {
+		cfg  PipelineConfig
+		outs int
+		want int
+	}{
+		{cfg: DefaultPipelineConfig(ShapeFanout).Source(src).Fanout(3).Iterations(2).Build(), outs: 3, want: 20},
+		{cfg: DefaultPipelineConfig(ShapeGBKSequence).Source(src).Stages(3).Build(), outs: 1, want: 20},
+		{cfg: DefaultPipelineConfig(ShapeCoGBK).Source(src).CoSource(co).Iterations(2).Build(), outs: 1, want: 30},
+		{cfg: DefaultPipelineConfig(ShapeSideInput).Source(src).CoSource(co).SideInputAccessFraction(0.5).Build(), outs: 1, want: 20},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(string(test.cfg.Shape), func(t *testing.T) {
+			p, s := beam.NewPipelineWithRoot()
+			outs := Pipeline(s, test.cfg)
+			if got := len(outs); got != test.outs {
+				t.Fatalf("Pipeline returned wrong number of outputs: got: %v, want: %v", got, test.outs)
+			}
+			for i, out := range outs {
+				passert.Count(s, out, fmt.Sprintf("out%v", i), test.want)
+			}
+
+			ptest.RunAndValidate(t, p)
+		})
+	}
+}
+
+// TestPipelineConfig_Validation tests that Build panics for invalid
+// PipelineConfigs.
+func TestPipelineConfig_Validation(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  *PipelineConfigBuilder
+	}{
+		{"UnknownShape", DefaultPipelineConfig("ring")},
+		{"ZeroFanout", DefaultPipelineConfig(ShapeFanout).Fanout(0)},
+		{"ZeroStages", DefaultPipelineConfig(ShapeGBKSequence).Stages(0)},
+		{"ZeroIterations", DefaultPipelineConfig(ShapeCoGBK).Iterations(0)},
+		{"AccessFractionAboveOne", DefaultPipelineConfig(ShapeSideInput).SideInputAccessFraction(1.5)},
+		{"BytesSource", DefaultPipelineConfig(ShapeFanout).Source(DefaultSourceConfig().OutputFormat(OutputFormatBytes).Build())},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			defer func() {
+				if r := recover(); r == nil {
+					t.Errorf("Build did not panic for %+v", test.cfg.cfg)
+				}
+			}()
+			test.cfg.Build()
+		})
+	}
+}
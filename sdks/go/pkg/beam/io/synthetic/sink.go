@@ -0,0 +1,165 @@
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements.  See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License.  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package synthetic
+
+import (
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/apache/beam/sdks/v2/go/pkg/beam"
+)
+
+func init() {
+	beam.RegisterType(reflect.TypeOf((*sinkFn)(nil)).Elem())
+	beam.RegisterType(reflect.TypeOf((*SinkConfig)(nil)).Elem())
+}
+
+// Sink creates a synthetic sink transform that receives KV<[]byte, []byte>
+// elements from other synthetic transforms and discards them, optionally
+// simulating the cost of writing each element.
+//
+// This function accepts a SinkConfig to configure the behavior of the
+// synthetic sink, such as a delay or an amount of CPU work to perform for each
+// element consumed.
+//
+// The recommended way to create SinkConfigs is via the SinkConfigBuilder.
+// Usage example:
+//
+//    cfg := synthetic.DefaultSinkConfig().PerElementDelay(time.Millisecond).Build()
+//    synthetic.Sink(s, cfg, input)
+func Sink(s beam.Scope, cfg SinkConfig, col beam.PCollection) {
+	s = s.Scope("synthetic.Sink")
+	beam.ParDo0(s, &sinkFn{cfg: cfg}, col)
+}
+
+// sinkFn is a DoFn implementing behavior for synthetic sinks. For usage
+// information, see synthetic.Sink.
+//
+// The sinkFn is expected to be initialized with a cfg and will follow that
+// config to determine its behavior when consuming elements.
+type sinkFn struct {
+	cfg SinkConfig
+	sum uint64 // Result of the simulated CPU work, kept so it isn't optimized away.
+}
+
+// ProcessElement consumes an input, sleeping and performing CPU work on it as
+// specified by the SinkConfig, and then discards it.
+func (fn *sinkFn) ProcessElement(key, val []byte) {
+	if fn.cfg.PerElementDelay > 0 {
+		time.Sleep(fn.cfg.PerElementDelay)
+	}
+	for i := 0; i < fn.cfg.CPUWorkFactor; i++ {
+		fn.sum = burnCPU(fn.sum, key)
+		fn.sum = burnCPU(fn.sum, val)
+	}
+}
+
+// burnCPU performs a simple FNV-1a style hash of data, starting from the given
+// seed, in order to simulate CPU work proportional to the size of data.
+func burnCPU(seed uint64, data []byte) uint64 {
+	const prime = 1099511628211
+	h := seed ^ 14695981039346656037
+	for _, b := range data {
+		h ^= uint64(b)
+		h *= prime
+	}
+	return h
+}
+
+// spinCPU keeps the CPU busy for approximately d by repeatedly hashing a small
+// buffer, in order to simulate CPU-bound work rather than waiting. It returns
+// the result of the hashing, so callers can keep it from being optimized away.
+func spinCPU(d time.Duration) uint64 {
+	var sum uint64
+	var buf [64]byte
+	for start := time.Now(); time.Since(start) < d; {
+		sum = burnCPU(sum, buf[:])
+	}
+	return sum
+}
+
+// SinkConfigBuilder is used to initialize SinkConfigs. See SinkConfigBuilder's
+// methods for descriptions of the fields in a SinkConfig and how they can be
+// set. The intended approach for using this builder is to begin by calling the
+// DefaultSinkConfig function, followed by calling setters, followed by calling
+// Build.
+//
+// Usage example:
+//
+//    cfg := synthetic.DefaultSinkConfig().PerElementDelay(time.Millisecond).Build()
+type SinkConfigBuilder struct {
+	cfg SinkConfig
+}
+
+// DefaultSinkConfig creates a SinkConfigBuilder set with intended defaults for
+// the SinkConfig fields. This function is the intended starting point for
+// initializing a SinkConfig and should always be used to create
+// SinkConfigBuilders.
+//
+// To see descriptions of the various SinkConfig fields and their defaults, see
+// the methods to SinkConfigBuilder.
+func DefaultSinkConfig() *SinkConfigBuilder {
+	return &SinkConfigBuilder{
+		cfg: SinkConfig{
+			PerElementDelay: 0, // Defaults to discarding elements as fast as possible.
+			CPUWorkFactor:   0, // Defaults to no simulated CPU work.
+		},
+	}
+}
+
+// PerElementDelay is the amount of time the sink sleeps for each element it
+// consumes, in order to model the latency of writing to an external system.
+//
+// Valid values are in the range of [0, ...] and the default value is 0, meaning
+// no delay.
+func (b *SinkConfigBuilder) PerElementDelay(val time.Duration) *SinkConfigBuilder {
+	b.cfg.PerElementDelay = val
+	return b
+}
+
+// CPUWorkFactor is the number of times the sink hashes each element's key and
+// value before discarding it, in order to model the CPU cost of encoding or
+// writing elements. The work performed scales with both this factor and the
+// size of the elements.
+//
+// Valid values are in the range of [0, ...] and the default value is 0, meaning
+// no CPU work is performed.
+func (b *SinkConfigBuilder) CPUWorkFactor(val int) *SinkConfigBuilder {
+	b.cfg.CPUWorkFactor = val
+	return b
+}
+
+// Build constructs the SinkConfig initialized by this builder. It also performs
+// error checking on the fields, and panics if any have been set to invalid
+// values.
+func (b *SinkConfigBuilder) Build() SinkConfig {
+	if b.cfg.PerElementDelay < 0 {
+		panic(fmt.Sprintf("SinkConfig.PerElementDelay cannot be negative. Got: %v", b.cfg.PerElementDelay))
+	}
+	if b.cfg.CPUWorkFactor < 0 {
+		panic(fmt.Sprintf("SinkConfig.CPUWorkFactor cannot be negative. Got: %v", b.cfg.CPUWorkFactor))
+	}
+	return b.cfg
+}
+
+// SinkConfig is a struct containing all the configuration options for a
+// synthetic sink. It should be created via a SinkConfigBuilder, not by directly
+// initializing it (the fields are public to allow encoding).
+type SinkConfig struct {
+	PerElementDelay time.Duration
+	CPUWorkFactor   int
+}
@@ -0,0 +1,84 @@
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements.  See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License.  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package synthetic
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/apache/beam/sdks/v2/go/pkg/beam"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/testing/ptest"
+)
+
+// TestSink tests that a synthetic sink consumes every element of a synthetic
+// source in a pipeline.
+func TestSink(t *testing.T) {
+	p, s := beam.NewPipelineWithRoot()
+	src := SourceSingle(s, DefaultSourceConfig().NumElements(100).InitialSplits(4).Build())
+	Sink(s, DefaultSinkConfig().CPUWorkFactor(2).Build(), src)
+
+	if err := ptest.Run(p); err != nil {
+		t.Fatalf("Failed to execute pipeline with synthetic sink: %v", err)
+	}
+}
+
+// TestSinkConfig_PerElementDelay tests that the sink sleeps for the configured
+// delay on each element it consumes.
+func TestSinkConfig_PerElementDelay(t *testing.T) {
+	tests := []struct {
+		elms  int
+		delay time.Duration
+	}{
+		{elms: 1, delay: 10 * time.Millisecond},
+		{elms: 5, delay: 5 * time.Millisecond},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(fmt.Sprintf("(elms = %v, delay = %v)", test.elms, test.delay), func(t *testing.T) {
+			cfg := DefaultSinkConfig().PerElementDelay(test.delay).Build()
+			dfn := sinkFn{cfg: cfg}
+			elm := []byte{0, 0, 0, 0}
+
+			start := time.Now()
+			for i := 0; i < test.elms; i++ {
+				dfn.ProcessElement(elm, elm)
+			}
+			want := time.Duration(test.elms) * test.delay
+			if got := time.Since(start); got < want {
+				t.Errorf("sinkFn finished too quickly: got: %v, want at least: %v", got, want)
+			}
+		})
+	}
+}
+
+// TestSinkConfig_CPUWorkFactor tests that the sink performs CPU work only when
+// a positive work factor is configured.
+func TestSinkConfig_CPUWorkFactor(t *testing.T) {
+	elm := []byte{1, 2, 3, 4}
+
+	dfn := sinkFn{cfg: DefaultSinkConfig().Build()}
+	dfn.ProcessElement(elm, elm)
+	if dfn.sum != 0 {
+		t.Errorf("sinkFn performed CPU work with a work factor of 0: got sum %v, want 0", dfn.sum)
+	}
+
+	dfn = sinkFn{cfg: DefaultSinkConfig().CPUWorkFactor(3).Build()}
+	dfn.ProcessElement(elm, elm)
+	if dfn.sum == 0 {
+		t.Errorf("sinkFn performed no CPU work with a work factor of 3")
+	}
+}
@@ -24,19 +24,34 @@ package synthetic
 
 import (
 	"bytes"
+	"context"
+	"encoding/binary"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"math"
 	"math/rand"
 	"reflect"
+	"sync/atomic"
 	"time"
 
 	"github.com/apache/beam/sdks/v2/go/pkg/beam"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/core/graph/mtime"
 	"github.com/apache/beam/sdks/v2/go/pkg/beam/core/sdf"
 	"github.com/apache/beam/sdks/v2/go/pkg/beam/io/rtrackers/offsetrange"
 )
 
 func init() {
 	beam.RegisterType(reflect.TypeOf((*sourceFn)(nil)).Elem())
+	beam.RegisterType(reflect.TypeOf((*bytesSourceFn)(nil)).Elem())
+	beam.RegisterType(reflect.TypeOf((*sideOutputSourceFn)(nil)).Elem())
+	beam.RegisterType(reflect.TypeOf((*finalizingSourceFn)(nil)).Elem())
+	beam.RegisterType(reflect.TypeOf((*checkpointingSourceFn)(nil)).Elem())
+	beam.RegisterType(reflect.TypeOf((*batchSourceFn)(nil)).Elem())
+	beam.RegisterType(reflect.TypeOf((*Element)(nil)).Elem())
+	beam.RegisterType(reflect.TypeOf((*rowSourceFn)(nil)).Elem())
+	beam.RegisterType(reflect.TypeOf((*Row)(nil)).Elem())
 	beam.RegisterType(reflect.TypeOf((*SourceConfig)(nil)).Elem())
 }
 
@@ -50,6 +65,10 @@ func init() {
 // differently behaving sources, such as a file read that received small files
 // and large files.
 //
+// Since the output type must be known when constructing the pipeline, every
+// SourceConfig in the PCollection must use the OutputFormatKV output format.
+// To emit other output formats, use SourceSingle.
+//
 // The recommended way to create SourceConfigs is via the SourceConfigBuilder.
 // Usage example:
 //
@@ -57,14 +76,17 @@ func init() {
 //        synthetic.DefaultSourceConfig().NumElements(1000).Build(),
 //        synthetic.DefaultSourceConfig().NumElements(5000).InitialSplits(2).Build())
 //    src := synthetic.Source(s, cfgs)
-func Source(s beam.Scope, col beam.PCollection) beam.PCollection {
+//
+// Source accepts additional parameters as sourceOptions, such as WithReshuffle.
+func Source(s beam.Scope, col beam.PCollection, opts ...sourceOption) beam.PCollection {
 	s = s.Scope("synthetic.Source")
 
-	return beam.ParDo(s, &sourceFn{}, col)
+	return applySourceOptions(s, beam.ParDo(s, &sourceFn{}, col), opts)
 }
 
 // SourceSingle creates a synthetic source transform that emits randomly
-// generated KV<[]byte, []byte> elements.
+// generated elements in the output format of the SourceConfig, which by
+// default is KV<[]byte, []byte>.
 //
 // This transform is a version of Source for when only one SourceConfig is
 // needed. This transform accepts one SourceConfig which determines the
@@ -75,11 +97,78 @@ func Source(s beam.Scope, col beam.PCollection) beam.PCollection {
 //
 //    src := synthetic.SourceSingle(s,
 //        synthetic.DefaultSourceConfig().NumElements(5000).InitialSplits(2).Build())
-func SourceSingle(s beam.Scope, cfg SourceConfig) beam.PCollection {
+//
+// SourceSingle accepts additional parameters as sourceOptions, such as
+// WithReshuffle.
+func SourceSingle(s beam.Scope, cfg SourceConfig, opts ...sourceOption) beam.PCollection {
 	s = s.Scope("synthetic.Source")
 
 	col := beam.Create(s, cfg)
-	return beam.ParDo(s, &sourceFn{}, col)
+	switch {
+	case cfg.OutputFormat == OutputFormatBytes:
+		return applySourceOptions(s, beam.ParDo(s, &bytesSourceFn{}, col), opts)
+	case cfg.OutputFormat == OutputFormatBatch:
+		return applySourceOptions(s, beam.ParDo(s, &batchSourceFn{}, col), opts)
+	case cfg.OutputFormat == OutputFormatRow:
+		return applySourceOptions(s, beam.ParDo(s, &rowSourceFn{}, col), opts)
+	case cfg.BundleFinalization:
+		return applySourceOptions(s, beam.ParDo(s, &finalizingSourceFn{}, col), opts)
+	case checkpointing(cfg):
+		return applySourceOptions(s, beam.ParDo(s, &checkpointingSourceFn{}, col), opts)
+	}
+	return applySourceOptions(s, beam.ParDo(s, &sourceFn{}, col), opts)
+}
+
+// SourceWithSideOutput creates a synthetic source transform that emits randomly
+// generated KV<[]byte, []byte> elements to two outputs, a main output and a
+// side output, in order to simulate a source that produces a main stream of
+// data alongside a secondary stream, such as metadata.
+//
+// This transform accepts a PCollection of SourceConfig like Source, and the
+// SideOutputFraction of each SourceConfig determines the fraction of its
+// elements that are emitted to the side output instead of the main output.
+//
+// Usage example:
+//
+//	cfgs := beam.Create(s,
+//	    synthetic.DefaultSourceConfig().NumElements(1000).SideOutputFraction(0.1).Build())
+//	main, side := synthetic.SourceWithSideOutput(s, cfgs)
+//
+// SourceWithSideOutput accepts additional parameters as sourceOptions, which
+// are applied to both outputs.
+func SourceWithSideOutput(s beam.Scope, col beam.PCollection, opts ...sourceOption) (main, side beam.PCollection) {
+	s = s.Scope("synthetic.SourceWithSideOutput")
+
+	main, side = beam.ParDo2(s, &sideOutputSourceFn{}, col)
+	return applySourceOptions(s, main, opts), applySourceOptions(s, side, opts)
+}
+
+type sourceOptions struct {
+	reshuffle bool
+}
+
+type sourceOption func(*sourceOptions)
+
+// WithReshuffle is a Source and SourceSingle option that applies a Reshuffle
+// to the emitted elements, breaking fusion between the source and downstream
+// transforms so that the downstream work is redistributed across workers.
+func WithReshuffle() sourceOption {
+	return func(o *sourceOptions) {
+		o.reshuffle = true
+	}
+}
+
+// applySourceOptions applies the given options to the output of a synthetic
+// source.
+func applySourceOptions(s beam.Scope, out beam.PCollection, opts []sourceOption) beam.PCollection {
+	var o sourceOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.reshuffle {
+		out = beam.Reshuffle(s, out)
+	}
+	return out
 }
 
 // sourceFn is a splittable DoFn implementing behavior for synthetic sources.
@@ -88,9 +177,86 @@ func SourceSingle(s beam.Scope, cfg SourceConfig) beam.PCollection {
 // The sourceFn is expected to receive elements of type sourceConfig and follow
 // that config to determine its behavior when splitting and emitting elements.
 type sourceFn struct {
-	rng randWrapper
+	started  bool     // Whether the current bundle has processed a SourceConfig.
+	warm     bool     // Whether the DoFn instance has paid its startup delay.
+	retained [][]byte // Memory held until the end of the bundle.
+}
+
+// StartBundle resets the bundle's startup state, so that the InitialDelay is
+// paid again by the new bundle. Since the SourceConfig isn't known until the
+// first element of the bundle is processed, the delay itself is paid in
+// ProcessElement. See startBundle.
+func (fn *sourceFn) StartBundle(_ context.Context, _ func(beam.EventTime, []byte, []byte)) {
+	fn.started = false
+}
+
+// FinishBundle releases the memory retained by the bundle. See
+// RetainedBytesPerElement.
+func (fn *sourceFn) FinishBundle(_ func(beam.EventTime, []byte, []byte)) {
+	fn.retained = nil
+}
+
+// retain allocates n bytes of memory that are held until the end of the
+// bundle. One byte of every page is written, so that the memory is resident
+// instead of only reserved.
+func (fn *sourceFn) retain(n int64) {
+	buf := make([]byte, n)
+	for i := 0; i < len(buf); i += retainedPageSize {
+		buf[i] = 1
+	}
+	fn.retained = append(fn.retained, buf)
+}
+
+// retainedPageSize is the assumed size of a memory page, used to make
+// retained memory resident.
+const retainedPageSize = 4096
+
+// startBundle is called by every ProcessElement, and on the first call in
+// each bundle increments the "bundles_started" counter and sleeps for the
+// config's bundle start delay, before any element is claimed. The delay is the
+// InitialDelay, spread uniformly at random up to the MaxBundleStartDelay if
+// one is set. If the context is cancelled during the delay, the context's
+// error is returned. When a bundle processes several SourceConfigs, only the
+// first one's delay is used.
+//
+// On the first call to a DoFn instance, the config's StartupDelay is slept
+// before the bundle start delay, to simulate a cold start.
+func (fn *sourceFn) startBundle(ctx context.Context, config SourceConfig) error {
+	if fn.started {
+		return nil
+	}
+	if !fn.warm {
+		fn.warm = true
+		if config.StartupDelay > 0 {
+			select {
+			case <-time.After(config.StartupDelay):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+	}
+	fn.started = true
+	bundlesStarted.Inc(ctx, 1)
+	delay := config.InitialDelay
+	if spread := config.MaxBundleStartDelay - config.InitialDelay; spread > 0 {
+		delay += time.Duration(rand.Int63n(int64(spread) + 1))
+	}
+	if delay <= 0 {
+		return nil
+	}
+	select {
+	case <-time.After(delay):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 }
 
+// bundlesStarted counts the bundles that synthetic sources have started
+// processing. With bundle finalization enabled, it can be compared to the
+// "bundles_finalized" counter to check that every bundle is finalized.
+var bundlesStarted = beam.NewCounter(sourceNamespace, "bundles_started")
+
 // CreateInitialRestriction creates an offset range restriction representing
 // the number of elements to emit.
 func (fn *sourceFn) CreateInitialRestriction(config SourceConfig) offsetrange.Restriction {
@@ -104,205 +270,2128 @@ func (fn *sourceFn) CreateInitialRestriction(config SourceConfig) offsetrange.Re
 // initial splits specified in SourceConfig. Each restriction output by this
 // method will contain at least one element, so the number of splits will not
 // exceed the number of elements.
+//
+// When the number of elements N is not divisible by the number of splits S,
+// the remainder R = N % S is distributed one extra element each to the first R
+// splits. For example, 10 elements split 4 ways results in splits of sizes
+// 3, 3, 2, 2, in that order. See remainderFirstSplits.
+//
+// If a SplitSkew is configured, the splits are intentionally imbalanced
+// instead. See skewedSplits.
 func (fn *sourceFn) SplitRestriction(config SourceConfig, rest offsetrange.Restriction) (splits []offsetrange.Restriction) {
-	return rest.EvenSplits(int64(config.InitialSplits))
+	if config.SplitSkew > 0 {
+		return skewedSplits(rest, config.InitialSplits, config.SplitSkew)
+	}
+	return remainderFirstSplits(rest, config.InitialSplits)
+}
+
+// skewedSplits splits a restriction into num contiguous restrictions in
+// ascending order, where the first restriction holds the given fraction of the
+// elements, and the remaining elements are split evenly across the other
+// restrictions as in remainderFirstSplits. The first restriction is clamped so
+// that every restriction contains at least one element.
+func skewedSplits(rest offsetrange.Restriction, num int64, skew float64) (splits []offsetrange.Restriction) {
+	size := rest.End - rest.Start
+	if num > size {
+		num = size
+	}
+	if num <= 1 {
+		return append(splits, rest)
+	}
+
+	first := int64(math.Round(float64(size) * skew))
+	if first < 1 {
+		first = 1
+	}
+	if max := size - (num - 1); first > max {
+		first = max
+	}
+	splits = append(splits, offsetrange.Restriction{Start: rest.Start, End: rest.Start + first})
+	return append(splits, remainderFirstSplits(offsetrange.Restriction{Start: rest.Start + first, End: rest.End}, num-1)...)
+}
+
+// remainderFirstSplits splits a restriction into num contiguous restrictions in
+// ascending order, whose sizes differ by at most one, with the larger
+// restrictions first. If the restriction is smaller than num, it is split into
+// restrictions of size 1 instead, so no restriction is empty.
+func remainderFirstSplits(rest offsetrange.Restriction, num int64) (splits []offsetrange.Restriction) {
+	size := rest.End - rest.Start
+	if num > size {
+		num = size
+	}
+	if num <= 1 {
+		return append(splits, rest)
+	}
+
+	base, rem := size/num, size%num
+	start := rest.Start
+	for i := int64(0); i < num; i++ {
+		end := start + base
+		if i < rem {
+			end++
+		}
+		splits = append(splits, offsetrange.Restriction{Start: start, End: end})
+		start = end
+	}
+	return splits
+}
+
+// RestrictionSize outputs the size of the restriction as the estimated amount
+// of work needed to process it, which is the number of elements that
+// restriction will output weighted by the cost of each element. See
+// elementWeight for how elements are weighted.
+//
+// Progress reported by the restriction tracker is proportional to the number of
+// elements claimed, which is consistent with this size since every element in a
+// restriction has the same weight.
+func (fn *sourceFn) RestrictionSize(config SourceConfig, rest offsetrange.Restriction) float64 {
+	return rest.Size() * elementWeight(config)
 }
 
-// RestrictionSize outputs the size of the restriction as the number of elements
-// that restriction will output.
-func (fn *sourceFn) RestrictionSize(_ SourceConfig, rest offsetrange.Restriction) float64 {
-	return rest.Size()
+// elementWeight returns the estimated cost of emitting one element for the
+// given config, in units of the nominal cost of generating an element with no
+// simulated per-element work. The fixed InitialDelay is not included since it is
+// paid once per bundle rather than per element.
+//
+// With a MaxRate, each element takes at least 1/MaxRate seconds to emit, so
+// that interval is used instead when it exceeds the simulated work.
+func elementWeight(config SourceConfig) float64 {
+	work := config.SleepPerElement + config.CPUBurnPerElement
+	if config.MaxRate > 0 {
+		if interval := time.Duration(float64(time.Second) / config.MaxRate); interval > work {
+			work = interval
+		}
+	}
+	return 1 + float64(work)/float64(nominalElementCost)
 }
 
+// nominalElementCost is the approximate time taken to generate and emit an
+// element with no simulated per-element work, used to weigh simulated work
+// against it.
+const nominalElementCost = time.Microsecond
+
 // CreateTracker just creates an offset range restriction tracker for the
 // restriction.
 func (fn *sourceFn) CreateTracker(rest offsetrange.Restriction) *sdf.LockRTracker {
 	return sdf.NewLockRTracker(offsetrange.NewTracker(rest))
 }
 
-// Setup sets up the random number generator.
-func (fn *sourceFn) Setup() {
-	fn.rng = rand.New(rand.NewSource(time.Now().UnixNano()))
+// InitialWatermarkEstimatorState creates the initial state of the source's
+// watermark estimator, starting from the timestamp of the received SourceConfig
+// and lagging by the configured WatermarkLag.
+func (fn *sourceFn) InitialWatermarkEstimatorState(et beam.EventTime, _ offsetrange.Restriction, config SourceConfig) lagWatermarkState {
+	return lagWatermarkState{MaxTimestamp: et.Milliseconds(), Lag: config.WatermarkLag}
+}
+
+// CreateWatermarkEstimator creates a watermark estimator that tracks the
+// timestamps of emitted elements. See lagWatermarkEstimator for details.
+func (fn *sourceFn) CreateWatermarkEstimator(state lagWatermarkState) *lagWatermarkEstimator {
+	return &lagWatermarkEstimator{state: state}
+}
+
+// WatermarkEstimatorState returns the state of the watermark estimator, so
+// that watermark estimation can resume after a split or checkpoint.
+func (fn *sourceFn) WatermarkEstimatorState(e *lagWatermarkEstimator) lagWatermarkState {
+	return e.state
 }
 
 // ProcessElement creates a number of random elements based on the restriction
 // tracker received. Each element is a random byte slice key and value, in the
-// form of KV<[]byte, []byte>.
-func (fn *sourceFn) ProcessElement(rt *sdf.LockRTracker, config SourceConfig, emit func([]byte, []byte)) error {
-	generator := rand.New(rand.NewSource(0))
-	for i := rt.GetRestriction().(offsetrange.Restriction).Start; rt.TryClaim(i); i++ {
-		key := make([]byte, config.KeySize)
-		val := make([]byte, config.ValueSize)
-		generator.Seed(i)
-		randomSample := generator.Float64()
-		if randomSample < config.HotKeyFraction {
-			generator.Seed(i % int64(config.NumHotKeys))
-			if _, err := generator.Read(key); err != nil {
-				return err
-			}
-		} else {
-			if _, err := fn.rng.Read(key); err != nil {
-				return err
-			}
-		}
-		if _, err := fn.rng.Read(val); err != nil {
-			return err
-		}
-		emit(key, val)
+// form of KV<[]byte, []byte>. See generate for details on how elements are
+// generated.
+func (fn *sourceFn) ProcessElement(ctx context.Context, et beam.EventTime, rt *sdf.LockRTracker, config SourceConfig, emit func(beam.EventTime, []byte, []byte)) error {
+	if err := (sourceFnFeatures{format: OutputFormatKV}).check(config); err != nil {
+		return err
 	}
-	return nil
+	if err := fn.startBundle(ctx, config); err != nil {
+		return err
+	}
+	return fn.generate(ctx, et, rt, config, kvEmitter(emit))
 }
 
-// SourceConfigBuilder is used to initialize SourceConfigs. See
-// SourceConfigBuilder's methods for descriptions of the fields in a
-// SourceConfig and how they can be set. The intended approach for using this
-// builder is to begin by calling the DefaultSourceConfig function, followed by
-// calling setters, followed by calling Build.
-//
-// Usage example:
-//
-//    cfg := synthetic.DefaultSourceConfig().NumElements(5000).InitialSplits(2).Build()
-type SourceConfigBuilder struct {
-	cfg SourceConfig
+// finalizingSourceFn is a splittable DoFn implementing behavior for synthetic
+// sources with bundle finalization enabled. It only differs from sourceFn in
+// registering a bundle finalization callback, which is kept out of sourceFn
+// since accepting a bundle finalizer adds a requirement to the pipeline that
+// not every runner supports.
+type finalizingSourceFn struct {
+	sourceFn
+	registered bool // Whether a callback was registered in the current bundle.
 }
 
-// DefaultSourceConfig creates a SourceConfigBuilder set with intended defaults
-// for the SourceConfig fields. This function is the intended starting point for
-// initializing a SourceConfig and should always be used to create
-// SourceConfigBuilders.
-//
-// To see descriptions of the various SourceConfig fields and their defaults,
-// see the methods to SourceConfigBuilder.
-func DefaultSourceConfig() *SourceConfigBuilder {
-	return &SourceConfigBuilder{
-		cfg: SourceConfig{
-			NumElements:    1, // 0 is invalid (drops elements).
-			InitialSplits:  1, // 0 is invalid (drops elements).
-			KeySize:        8, // 0 is invalid (drops elements).
-			ValueSize:      8, // 0 is invalid (drops elements).
-			NumHotKeys:     0,
-			HotKeyFraction: 0,
-		},
+// StartBundle resets the bundle's startup state and finalization callback
+// registration. See sourceFn.StartBundle.
+func (fn *finalizingSourceFn) StartBundle(_ context.Context, _ func(beam.EventTime, []byte, []byte)) {
+	fn.started = false
+	fn.registered = false
+}
+
+// ProcessElement behaves like sourceFn.ProcessElement, and also registers a
+// callback per bundle that counts the bundle as finalized when the runner
+// finalizes it. See BundlesFinalized.
+func (fn *finalizingSourceFn) ProcessElement(ctx context.Context, et beam.EventTime, bf beam.BundleFinalization, rt *sdf.LockRTracker, config SourceConfig, emit func(beam.EventTime, []byte, []byte)) error {
+	if err := (sourceFnFeatures{format: OutputFormatKV, finalization: true}).check(config); err != nil {
+		return err
+	}
+	if !fn.registered {
+		bf.RegisterCallback(finalizationTimeout, func() error {
+			atomic.AddInt64(&finalizedBundles, 1)
+			bundlesFinalized.Inc(ctx, 1)
+			return nil
+		})
+		fn.registered = true
 	}
+	if err := fn.startBundle(ctx, config); err != nil {
+		return err
+	}
+	return fn.generate(ctx, et, rt, config, kvEmitter(emit))
 }
 
-// NumElements is the number of elements for the source to generate and emit.
+// finalizationTimeout is how long a bundle finalization callback registered
+// by a synthetic source remains valid.
+const finalizationTimeout = 10 * time.Minute
+
+// bundlesFinalized counts the bundles of synthetic sources that have been
+// finalized. Runners finalize bundles after their metrics are reported, so the
+// counter is only visible on runners that finalize bundles before reporting
+// the pipeline's metrics, such as the direct runner. See BundlesFinalized.
+var bundlesFinalized = beam.NewCounter(sourceNamespace, "bundles_finalized")
+
+// finalizedBundles counts the bundles of synthetic sources that have been
+// finalized in this process, outside of any bundle's metrics.
+var finalizedBundles int64
+
+// BundlesFinalized returns the number of bundles of synthetic sources with
+// BundleFinalization enabled that have been finalized in this process.
 //
-// Valid values are in the range of [1, ...] and the default value is 1. Values
-// of 0 (and below) are invalid as they result in sources that emit no elements.
-func (b *SourceConfigBuilder) NumElements(val int) *SourceConfigBuilder {
-	b.cfg.NumElements = int64(val)
-	return b
+// Unlike the "bundles_finalized" counter, it doesn't depend on the runner
+// reporting metrics after finalizing bundles, which portable runners don't do,
+// since the callbacks run after the bundle has completed. It is only
+// meaningful when the pipeline's DoFns run in the calling process, such as with
+// the direct runner or a portable runner using the LOOPBACK environment type.
+func BundlesFinalized() int64 {
+	return atomic.LoadInt64(&finalizedBundles)
 }
 
-// InitialSplits determines the number of initial splits to perform in the
-// source's SplitRestriction method. Restrictions in synthetic sources represent
-// the number of elements being emitted, and this split is performed evenly
-// across that number of elements.
-//
-// Each resulting restriction will have at least 1 element in it, and each
-// element being emitted will be contained in exactly one restriction. That
-// means that if the desired number of splits is greater than the number of
-// elements N, then N initial restrictions will be created, each containing 1
-// element.
-//
-// Valid values are in the range of [1, ...] and the default value is 1. Values
-// of 0 (and below) are invalid as they would result in dropping elements that
-// are expected to be emitted.
-func (b *SourceConfigBuilder) InitialSplits(val int) *SourceConfigBuilder {
-	b.cfg.InitialSplits = int64(val)
-	return b
+// checkpointingSourceFn is a splittable DoFn implementing behavior for
+// synthetic sources with self-checkpointing enabled. It only differs from
+// sourceFn in returning a ProcessContinuation, which is kept out of sourceFn
+// since it makes the source's output unbounded. Runners that can't resume
+// checkpointed restrictions, such as the direct runner, drop the elements
+// left unclaimed at each checkpoint.
+type checkpointingSourceFn struct {
+	sourceFn
 }
 
-// KeySize determines the size of the key of elements for the source to
-// generate.
-//
-// Valid values are in the range of [1, ...] and the default value is 8.
-func (b *SourceConfigBuilder) KeySize(val int) *SourceConfigBuilder {
-	b.cfg.KeySize = int64(val)
-	return b
+// StartBundle resets the bundle's startup state. See sourceFn.StartBundle.
+func (fn *checkpointingSourceFn) StartBundle(_ context.Context, _ func(beam.EventTime, []byte, []byte)) {
+	fn.started = false
 }
 
-// ValueSize determines the size of the value of elements for the source to
-// generate.
-//
-// Valid values are in the range of [1, ...] and the default value is 8.
-func (b *SourceConfigBuilder) ValueSize(val int) *SourceConfigBuilder {
-	b.cfg.ValueSize = int64(val)
-	return b
+// ProcessElement behaves like sourceFn.ProcessElement, except that it
+// checkpoints once CheckpointEvery elements have been claimed or
+// CheckpointInterval has elapsed, and asks the runner to resume the rest of
+// the restriction after ResumeDelay.
+func (fn *checkpointingSourceFn) ProcessElement(ctx context.Context, et beam.EventTime, rt *sdf.LockRTracker, config SourceConfig, emit func(beam.EventTime, []byte, []byte)) (sdf.ProcessContinuation, error) {
+	if err := (sourceFnFeatures{format: OutputFormatKV, checkpointing: true}).check(config); err != nil {
+		return sdf.StopProcessing(), err
+	}
+	if err := fn.startBundle(ctx, config); err != nil {
+		return sdf.StopProcessing(), err
+	}
+	checkpointed, err := fn.generateUntilCheckpoint(ctx, et, rt, config, kvEmitter(emit))
+	if err != nil || !checkpointed {
+		return sdf.StopProcessing(), err
+	}
+	return sdf.ResumeProcessingIn(config.ResumeDelay), nil
 }
 
-// NumHotKeys determines the number of keys with the same value among
-// generated keys.
-//
-// Valid values are in the range of [0, ...] and the default value is 0.
-func (b *SourceConfigBuilder) NumHotKeys(val int) *SourceConfigBuilder {
-	b.cfg.NumHotKeys = int64(val)
-	return b
+// sourceFnFeatures describes the SourceConfig options supported by one of the
+// synthetic source DoFns. Options that change the signature of a DoFn must be
+// known when constructing the pipeline, so each DoFn only supports some of
+// them.
+type sourceFnFeatures struct {
+	format        OutputFormat
+	sideOutput    bool
+	finalization  bool
+	checkpointing bool
 }
 
-// HotKeyFraction determines the value of hot key fraction.
-//
-// Valid values are floating point numbers from 0 to 1.
-func (b *SourceConfigBuilder) HotKeyFraction(val float64) *SourceConfigBuilder {
-	b.cfg.HotKeyFraction = val
-	return b
+// check returns an error if the config uses options that are not supported.
+func (f sourceFnFeatures) check(config SourceConfig) error {
+	if config.OutputFormat != f.format {
+		return fmt.Errorf("synthetic source emitting %q elements received SourceConfig with OutputFormat %q", f.format, config.OutputFormat)
+	}
+	if !f.sideOutput && config.SideOutputFraction > 0 {
+		return fmt.Errorf("synthetic source without a side output received SourceConfig with SideOutputFraction %v, use SourceWithSideOutput instead", config.SideOutputFraction)
+	}
+	if f.finalization != config.BundleFinalization {
+		return fmt.Errorf("synthetic source with bundle finalization %v received SourceConfig with BundleFinalization %v, use SourceSingle instead", f.finalization, config.BundleFinalization)
+	}
+	if f.checkpointing != checkpointing(config) {
+		return fmt.Errorf("synthetic source with self-checkpointing %v received SourceConfig with CheckpointEvery %v and CheckpointInterval %v, use SourceSingle instead", f.checkpointing, config.CheckpointEvery, config.CheckpointInterval)
+	}
+	return nil
 }
 
-// Build constructs the SourceConfig initialized by this builder. It also
-// performs error checking on the fields, and panics if any have been set to
-// invalid values.
-func (b *SourceConfigBuilder) Build() SourceConfig {
-	if b.cfg.InitialSplits <= 0 {
-		panic(fmt.Sprintf("SourceConfig.InitialSplits must be >= 1. Got: %v", b.cfg.InitialSplits))
+// bytesSourceFn is a splittable DoFn implementing behavior for synthetic
+// sources with the OutputFormatBytes output format. It splits restrictions the
+// same way as sourceFn, and only differs in the type of elements emitted.
+type bytesSourceFn struct {
+	sourceFn
+}
+
+// StartBundle resets the bundle's startup state. See sourceFn.StartBundle.
+func (fn *bytesSourceFn) StartBundle(_ context.Context, _ func(beam.EventTime, []byte)) {
+	fn.started = false
+}
+
+// FinishBundle releases the memory retained by the bundle. It only differs
+// from sourceFn.FinishBundle in the emitters it accepts.
+func (fn *bytesSourceFn) FinishBundle(_ func(beam.EventTime, []byte)) {
+	fn.retained = nil
+}
+
+// ProcessElement creates a number of random elements based on the restriction
+// tracker received. Each element is a single byte slice record, made of the
+// generated key followed by the generated value. See generate for details on
+// how elements are generated.
+func (fn *bytesSourceFn) ProcessElement(ctx context.Context, et beam.EventTime, rt *sdf.LockRTracker, config SourceConfig, emit func(beam.EventTime, []byte)) error {
+	if err := (sourceFnFeatures{format: OutputFormatBytes}).check(config); err != nil {
+		return err
 	}
-	if b.cfg.NumElements <= 0 {
-		panic(fmt.Sprintf("SourceConfig.NumElements must be >= 1. Got: %v", b.cfg.NumElements))
+	if err := fn.startBundle(ctx, config); err != nil {
+		return err
 	}
-	if b.cfg.KeySize <= 0 {
-		panic(fmt.Sprintf("SourceConfig.KeySize must be >= 1. Got: %v", b.cfg.KeySize))
+	return fn.generate(ctx, et, rt, config, bytesEmitter(emit))
+}
+
+// batchSourceFn is a splittable DoFn implementing behavior for synthetic
+// sources with the OutputFormatBatch output format. It splits restrictions the
+// same way as sourceFn, and only differs in the type of elements emitted.
+type batchSourceFn struct {
+	sourceFn
+}
+
+// StartBundle resets the bundle's startup state. See sourceFn.StartBundle.
+func (fn *batchSourceFn) StartBundle(_ context.Context, _ func(beam.EventTime, []Element)) {
+	fn.started = false
+}
+
+// FinishBundle releases the memory retained by the bundle. It only differs
+// from sourceFn.FinishBundle in the emitters it accepts.
+func (fn *batchSourceFn) FinishBundle(_ func(beam.EventTime, []Element)) {
+	fn.retained = nil
+}
+
+// ProcessElement creates a number of random elements based on the restriction
+// tracker received, and emits them in batches of BatchSize elements as
+// []Element. The last batch of each restriction may be smaller. See generate
+// for details on how elements are generated.
+func (fn *batchSourceFn) ProcessElement(ctx context.Context, et beam.EventTime, rt *sdf.LockRTracker, config SourceConfig, emit func(beam.EventTime, []Element)) error {
+	if err := (sourceFnFeatures{format: OutputFormatBatch}).check(config); err != nil {
+		return err
 	}
-	if b.cfg.ValueSize <= 0 {
-		panic(fmt.Sprintf("SourceConfig.ValueSize must be >= 1. Got: %v", b.cfg.ValueSize))
+	if err := fn.startBundle(ctx, config); err != nil {
+		return err
 	}
-	if b.cfg.NumHotKeys < 0 {
-		panic(fmt.Sprintf("SourceConfig.NumHotKeys must be >= 0. Got: %v", b.cfg.HotKeyFraction))
+	emitter := &batchEmitter{emit: emit, size: int(config.BatchSize)}
+	if err := fn.generate(ctx, et, rt, config, emitter); err != nil {
+		return err
 	}
-	if b.cfg.HotKeyFraction < 0 || b.cfg.HotKeyFraction > 1 {
-		panic(fmt.Sprintf("SourceConfig.HotKeyFraction must be a floating point number from 0 and 1. Got: %v", b.cfg.NumHotKeys))
+	emitter.flush()
+	return nil
+}
+
+// rowSourceFn is a splittable DoFn implementing behavior for synthetic sources
+// with the OutputFormatRow output format. It splits restrictions the same way
+// as sourceFn, and only differs in the type of elements emitted.
+type rowSourceFn struct {
+	sourceFn
+}
+
+// StartBundle resets the bundle's startup state. See sourceFn.StartBundle.
+func (fn *rowSourceFn) StartBundle(_ context.Context, _ func(beam.EventTime, Row)) {
+	fn.started = false
+}
+
+// FinishBundle releases the memory retained by the bundle. It only differs
+// from sourceFn.FinishBundle in the emitters it accepts.
+func (fn *rowSourceFn) FinishBundle(_ func(beam.EventTime, Row)) {
+	fn.retained = nil
+}
+
+// ProcessElement creates a number of random elements based on the restriction
+// tracker received, and emits each one as a Row holding the element's index,
+// event time, generated key and generated value. See generate for details on
+// how elements are generated.
+func (fn *rowSourceFn) ProcessElement(ctx context.Context, et beam.EventTime, rt *sdf.LockRTracker, config SourceConfig, emit func(beam.EventTime, Row)) error {
+	if err := (sourceFnFeatures{format: OutputFormatRow}).check(config); err != nil {
+		return err
 	}
-	return b.cfg
+	if err := fn.startBundle(ctx, config); err != nil {
+		return err
+	}
+	return fn.generate(ctx, et, rt, config, rowEmitter(emit))
 }
 
-// BuildFromJSON constructs the SourceConfig by populating it with the parsed
-// JSON. Panics if there is an error in the syntax of the JSON or if the input
-// contains unknown object keys.
-//
-// An example of valid JSON object:
-// {
-// 	 "num_records": 5,
-// 	 "key_size": 5,
-// 	 "value_size": 5,
-//	 "num_hot_keys": 5,
-// }
-func (b *SourceConfigBuilder) BuildFromJSON(jsonData []byte) SourceConfig {
-	decoder := json.NewDecoder(bytes.NewReader(jsonData))
-	decoder.DisallowUnknownFields()
+// sideOutputSourceFn is a splittable DoFn implementing behavior for synthetic
+// sources with a side output. For usage information, see
+// synthetic.SourceWithSideOutput.
+type sideOutputSourceFn struct {
+	sourceFn
+}
 
-	if err := decoder.Decode(&b.cfg); err != nil {
-		panic(fmt.Sprintf("Could not unmarshal SourceConfig: %v", err))
+// StartBundle resets the bundle's startup state. See sourceFn.StartBundle.
+func (fn *sideOutputSourceFn) StartBundle(_ context.Context, _, _ func(beam.EventTime, []byte, []byte)) {
+	fn.started = false
+}
+
+// FinishBundle releases the memory retained by the bundle. It only differs
+// from sourceFn.FinishBundle in the emitters it accepts.
+func (fn *sideOutputSourceFn) FinishBundle(_, _ func(beam.EventTime, []byte, []byte)) {
+	fn.retained = nil
+}
+
+// ProcessElement creates a number of random elements based on the restriction
+// tracker received, in the form of KV<[]byte, []byte>, and emits each element
+// to either the main or side output. Whether an element goes to the side output
+// is decided deterministically from its index, with the probability given by
+// SideOutputFraction. See generate for details on how elements are generated.
+func (fn *sideOutputSourceFn) ProcessElement(ctx context.Context, et beam.EventTime, rt *sdf.LockRTracker, config SourceConfig, emit, emitSide func(beam.EventTime, []byte, []byte)) error {
+	if err := (sourceFnFeatures{format: OutputFormatKV, sideOutput: true}).check(config); err != nil {
+		return err
 	}
-	return b.cfg
+	if err := fn.startBundle(ctx, config); err != nil {
+		return err
+	}
+	return fn.generate(ctx, et, rt, config, &sideOutputEmitter{
+		main:     emit,
+		side:     emitSide,
+		fraction: config.SideOutputFraction,
+	})
 }
 
-// SourceConfig is a struct containing all the configuration options for a
-// synthetic source. It should be created via a SourceConfigBuilder, not by
-// directly initializing it (the fields are public to allow encoding).
-type SourceConfig struct {
-	NumElements    int64   `json:"num_records" beam:"num_records"`
-	InitialSplits  int64   `json:"initial_splits" beam:"initial_splits"`
-	KeySize        int64   `json:"key_size" beam:"key_size"`
-	ValueSize      int64   `json:"value_size" beam:"value_size"`
-	NumHotKeys     int64   `json:"num_hot_keys" beam:"num_hot_keys"`
-	HotKeyFraction float64 `json:"hot_key_fraction" beam:"hot_key_fraction"`
+// elementEmitter constructs output elements of a specific OutputFormat from
+// the generated key and value of each synthetic element, and emits them with
+// the given event time. The index of each element is provided so that emitters
+// may decide where to emit an element deterministically.
+type elementEmitter interface {
+	emitElement(idx int64, ts beam.EventTime, key, val []byte)
+}
+
+// kvEmitter emits elements as KV<[]byte, []byte>.
+type kvEmitter func(beam.EventTime, []byte, []byte)
+
+func (e kvEmitter) emitElement(_ int64, ts beam.EventTime, key, val []byte) {
+	e(ts, key, val)
+}
+
+// bytesEmitter emits elements as a []byte record of the key followed by
+// the value.
+type bytesEmitter func(beam.EventTime, []byte)
+
+func (e bytesEmitter) emitElement(_ int64, ts beam.EventTime, key, val []byte) {
+	rec := make([]byte, 0, len(key)+len(val))
+	rec = append(rec, key...)
+	e(ts, append(rec, val...))
+}
+
+// batchEmitter accumulates elements and emits them as a []Element once size
+// elements have been accumulated. Any remaining elements are emitted by flush.
+// Each batch is emitted with the event time of its first element.
+type batchEmitter struct {
+	emit  func(beam.EventTime, []Element)
+	size  int
+	batch []Element
+	ts    beam.EventTime
+}
+
+func (e *batchEmitter) emitElement(_ int64, ts beam.EventTime, key, val []byte) {
+	if e.batch == nil {
+		e.batch = make([]Element, 0, e.size)
+		e.ts = ts
+	}
+	e.batch = append(e.batch, Element{Key: key, Value: val})
+	if len(e.batch) >= e.size {
+		e.flush()
+	}
+}
+
+// flush emits the accumulated elements, if there are any, as a single batch.
+func (e *batchEmitter) flush() {
+	if len(e.batch) == 0 {
+		return
+	}
+	e.emit(e.ts, e.batch)
+	e.batch = nil
+}
+
+// rowEmitter emits elements as Rows.
+type rowEmitter func(beam.EventTime, Row)
+
+func (e rowEmitter) emitElement(idx int64, ts beam.EventTime, key, val []byte) {
+	e(ts, Row{ID: idx, Timestamp: ts.Milliseconds(), Key: key, Payload: val})
+}
+
+// sideOutputEmitter emits elements as KV<[]byte, []byte> to either a main or a
+// side output, sending the given fraction of elements to the side output.
+type sideOutputEmitter struct {
+	main, side func(beam.EventTime, []byte, []byte)
+	fraction   float64
+}
+
+func (e *sideOutputEmitter) emitElement(idx int64, ts beam.EventTime, key, val []byte) {
+	if indexFraction(idx) < e.fraction {
+		e.side(ts, key, val)
+	} else {
+		e.main(ts, key, val)
+	}
+}
+
+// indexFraction deterministically maps an element index to a pseudo-random
+// value in the range [0, 1), using the SplitMix64 finalizer. This is much
+// cheaper than reseeding a random number generator for each element.
+func indexFraction(idx int64) float64 {
+	z := uint64(idx) + 0x9e3779b97f4a7c15
+	z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+	z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+	z ^= z >> 31
+	return float64(z>>11) / (1 << 53)
+}
+
+// generate creates a random key and value for every element claimed from the
+// restriction tracker, and outputs them with the given emitter.
+//
+// Each element's key and value are generated purely from the element's index
+// and the config's Seed, so the complete set of elements emitted for a
+// SourceConfig is identical no matter how its restriction is split, and
+// identical across runs.
+//
+// Any InitialDelay is slept by the calling ProcessElement before generate is
+// called, once per bundle. See startBundle.
+//
+// Each element is emitted with an event time determined by the config's
+// TimestampMode. See elementTimestamp.
+//
+// Failures configured by ErrorRate and PanicRate are injected after claiming
+// the failing element and before emitting it.
+//
+// Elements chosen by DuplicateFraction are emitted twice in a row, with the
+// same key, value and event time.
+//
+// Each emitted element is recorded in the source's metrics. See SourceResults.
+//
+// The context is checked before each element is emitted, and if it has been
+// cancelled the context's error is returned, so that cancelled bundles with
+// large restrictions abort promptly instead of emitting their remaining
+// elements.
+func (fn *sourceFn) generate(ctx context.Context, et beam.EventTime, rt *sdf.LockRTracker, config SourceConfig, emitter elementEmitter) error {
+	_, err := fn.generateUntilCheckpoint(ctx, et, rt, config, emitter)
+	return err
+}
+
+// generateUntilCheckpoint behaves like generate, except that if the config
+// enables self-checkpointing, it stops once a checkpoint is due and returns
+// true, leaving the rest of the restriction unclaimed. See checkpointDue.
+func (fn *sourceFn) generateUntilCheckpoint(ctx context.Context, et beam.EventTime, rt *sdf.LockRTracker, config SourceConfig, emitter elementEmitter) (checkpointed bool, err error) {
+	rest := rt.GetRestriction().(offsetrange.Restriction)
+	bundleFailure, failAt := noFailure, int64(-1)
+	if config.FailureScope == FailureScopeBundle && rest.End > rest.Start {
+		if bundleFailure = drawFailure(config); bundleFailure != noFailure {
+			failAt = rest.Start + rand.Int63n(rest.End-rest.Start)
+		}
+	}
+	var limiter *tokenBucket
+	if config.MaxRate > 0 {
+		limiter = newTokenBucket(config.MaxRate)
+	}
+	// Counters are updated once per restriction to keep their overhead low.
+	var emitted, emittedBytes int64
+	defer func() {
+		elementsEmitted.Inc(ctx, emitted)
+		bytesEmitted.Inc(ctx, emittedBytes)
+	}()
+	generator := rand.New(rand.NewSource(0))
+	// The Zipf distribution draws from the generator, which is reseeded for
+	// each element, so it only needs to be built once.
+	var zipf *rand.Zipf
+	if config.KeyDistribution == KeyDistributionZipf {
+		zipf = rand.NewZipf(generator, config.ZipfExponent, 1, uint64(config.NumKeys-1))
+	}
+	started := time.Now()
+	for i := rest.Start; ; i++ {
+		// At least one element is claimed before checkpointing, so that every
+		// call makes progress.
+		if i > rest.Start && checkpointDue(config, i-rest.Start, started) && i < rt.GetRestriction().(offsetrange.Restriction).End {
+			return true, nil
+		}
+		if !rt.TryClaim(i) {
+			break
+		}
+		if err := ctx.Err(); err != nil {
+			return false, err
+		}
+		if config.RetainedBytesPerElement > 0 {
+			fn.retain(config.RetainedBytesPerElement)
+		}
+		if limiter != nil {
+			if err := limiter.wait(ctx); err != nil {
+				return false, err
+			}
+		}
+		if sleep := elementSleep(config, i); sleep > 0 {
+			select {
+			case <-time.After(sleep):
+			case <-ctx.Done():
+				return false, ctx.Err()
+			}
+		}
+		if config.CPUBurnPerElement > 0 {
+			spinCPU(config.CPUBurnPerElement)
+		}
+		if i == config.FailAtElement {
+			if err := injectFailure(config, i); err != nil {
+				return false, err
+			}
+		}
+		if i == failAt {
+			if err := bundleFailure.trigger(i); err != nil {
+				return false, err
+			}
+		} else if config.FailureScope == FailureScopeElement {
+			if err := drawFailure(config).trigger(i); err != nil {
+				return false, err
+			}
+		}
+		generator.Seed(elementSeed(config.Seed, i))
+		randomSample := generator.Float64()
+		val := make([]byte, sampleValueSize(generator, config))
+		if _, err := generator.Read(val); err != nil {
+			return false, err
+		}
+		if config.ValueCompressibility > 0 {
+			makeCompressible(val, config.ValueCompressibility)
+		}
+		if config.ValueMode == ValueModeVerifiable {
+			putVerifiableValue(val, i)
+		}
+		ts := elementTimestamp(config, et, i)
+		var key []byte
+		switch {
+		case config.KeyMode == KeyModeSequential:
+			key = make([]byte, sampleKeySize(generator, config))
+			putIndex(key, i)
+		case randomSample < config.HotKeyFraction:
+			var err error
+			if key, err = indexedKey(generator, config, hotKeyIndex(config, ts, i)); err != nil {
+				return false, err
+			}
+		case config.KeyDistribution == KeyDistributionZipf || config.KeyDistribution == KeyDistributionNormal:
+			var err error
+			if key, err = indexedKey(generator, config, sampleKeyIndex(generator, zipf, config)); err != nil {
+				return false, err
+			}
+		default:
+			key = make([]byte, sampleKeySize(generator, config))
+			if _, err := generator.Read(key); err != nil {
+				return false, err
+			}
+		}
+		copies := 1
+		if config.DuplicateFraction > 0 && indexFraction(elementSeed(config.Seed, i)^duplicateSalt) < config.DuplicateFraction {
+			copies = 2
+		}
+		for c := 0; c < copies; c++ {
+			start := time.Now()
+			emitter.emitElement(i, ts, key, val)
+			emitLatency.Update(ctx, int64(time.Since(start)))
+			emitted++
+			emittedBytes += int64(len(key) + len(val))
+		}
+	}
+	return false, nil
+}
+
+// checkpointing reports whether the config enables self-checkpointing.
+func checkpointing(config SourceConfig) bool {
+	return config.CheckpointEvery > 0 || config.CheckpointInterval > 0
+}
+
+// checkpointDue reports whether a self-checkpointing source should checkpoint
+// before claiming another element, given the number of elements it claimed
+// so far in the current call and when that call started.
+func checkpointDue(config SourceConfig, claimed int64, started time.Time) bool {
+	if config.CheckpointEvery > 0 && claimed >= config.CheckpointEvery {
+		return true
+	}
+	return config.CheckpointInterval > 0 && time.Since(started) >= config.CheckpointInterval
+}
+
+// elementTimestamp returns the event time of the element at index idx, based on
+// the config's TimestampMode. With TimestampModeNone, elements keep the event
+// time et of the received SourceConfig. Timestamps are derived purely from the
+// element's index and the config's Seed, like the element's data.
+//
+// Elements chosen as late data by LateDataFraction are moved back in event
+// time by WatermarkLag plus a lateness drawn from [LatenessMin, LatenessMax],
+// so that they are behind the watermark when emitted.
+func elementTimestamp(config SourceConfig, et beam.EventTime, idx int64) beam.EventTime {
+	start := mtime.FromMilliseconds(config.StartTimestamp)
+	interval := config.TimestampInterval
+	// Pseudo-random fractions for this element, decorrelated from each other and
+	// from the fraction used to pick side outputs.
+	seed := elementSeed(config.Seed, idx)
+	var ts beam.EventTime
+	switch config.TimestampMode {
+	case TimestampModeMonotonic:
+		ts = start.Add(time.Duration(idx) * interval)
+	case TimestampModeJittered:
+		// Offset by a deterministic pseudo-random amount in [-jitter, jitter].
+		jitter := (2*indexFraction(^seed) - 1) * float64(config.TimestampJitter)
+		ts = start.Add(time.Duration(idx)*interval + time.Duration(jitter))
+	case TimestampModeUniform:
+		span := float64(time.Duration(config.NumElements) * interval)
+		ts = start.Add(time.Duration(indexFraction(^seed) * span))
+	default:
+		return et
+	}
+	if config.LateDataFraction > 0 && indexFraction(seed^lateDataSalt) < config.LateDataFraction {
+		span := float64(config.LatenessMax - config.LatenessMin)
+		lateness := config.LatenessMin + time.Duration(indexFraction(^seed^lateDataSalt)*span)
+		ts = ts.Subtract(config.WatermarkLag + lateness)
+	}
+	return ts
+}
+
+// lateDataSalt decorrelates the choice of late elements from other
+// pseudo-random choices made from an element's seed.
+const lateDataSalt = 0x2545f4914f6cdd1d
+
+// hotKeyIndex returns the index of the hot key for the hot element at index
+// idx with event time ts. Elements are assigned to the NumHotKeys hot keys in a
+// round robin. With a HotKeyRotationInterval, each interval of event time after
+// the StartTimestamp uses a new set of hot keys, and elements before the
+// StartTimestamp use the first set.
+func hotKeyIndex(config SourceConfig, ts beam.EventTime, idx int64) int64 {
+	hot := idx % config.NumHotKeys
+	if config.HotKeyRotationInterval <= 0 {
+		return hot
+	}
+	elapsed := ts.ToTime().Sub(mtime.FromMilliseconds(config.StartTimestamp).ToTime())
+	if elapsed < 0 {
+		return hot
+	}
+	return int64(elapsed/config.HotKeyRotationInterval)*config.NumHotKeys + hot
+}
+
+// elementSleep returns how long to sleep before emitting the element at index
+// idx, drawn from the config's SleepDistribution with a mean of
+// SleepPerElement. Like timestamps, sleeps are derived purely from the
+// element's index and the config's Seed.
+func elementSleep(config SourceConfig, idx int64) time.Duration {
+	mean := float64(config.SleepPerElement)
+	if mean <= 0 {
+		return 0
+	}
+	seed := elementSeed(config.Seed, idx) ^ sleepSalt
+	switch config.SleepDistribution {
+	case DelayDistributionUniform:
+		return time.Duration(2 * mean * indexFraction(seed))
+	case DelayDistributionExponential:
+		// Inverse transform sampling, using 1-u to avoid taking the log of zero.
+		return time.Duration(-mean * math.Log(1-indexFraction(seed)))
+	case DelayDistributionNormal:
+		// Box-Muller transform of two independent uniform samples.
+		u1, u2 := 1-indexFraction(seed), indexFraction(^seed)
+		z := math.Sqrt(-2*math.Log(u1)) * math.Cos(2*math.Pi*u2)
+		return time.Duration(math.Max(0, mean+z*float64(config.SleepStdDev)))
+	default:
+		return config.SleepPerElement
+	}
+}
+
+// duplicateSalt decorrelates the choice of duplicated elements from other
+// pseudo-random choices made from an element's seed.
+const duplicateSalt = 0x3c6ef372fe94f82b
+
+// sleepSalt decorrelates per-element sleeps from other pseudo-random choices
+// made from an element's seed.
+const sleepSalt = 0x61c8864680b583eb
+
+// elementSeed returns the seed for the random number generator used to generate
+// the data at index idx. A seed of 0 seeds the generator with the index itself.
+func elementSeed(seed, idx int64) int64 {
+	return seed*0x5851f42d4c957f2d + idx
+}
+
+// indexedKey returns the key at index idx of a fixed set of keys, such as the
+// hot keys or the keys of a KeyDistribution. Indexed keys are random bytes
+// seeded by their index, with the index itself written big-endian into the
+// trailing bytes of the key, so that each index produces a distinct key. The
+// length of an indexed key is also sampled from its index, so each key has the
+// same length every time it is emitted.
+func indexedKey(generator *rand.Rand, config SourceConfig, idx int64) ([]byte, error) {
+	generator.Seed(elementSeed(config.Seed, idx))
+	key := make([]byte, sampleKeySize(generator, config))
+	if _, err := generator.Read(key); err != nil {
+		return nil, err
+	}
+	putIndex(key, idx)
+	return key, nil
+}
+
+// sampleKeyIndex returns the index of the next key to generate from the
+// NumKeys keys of the config's KeyDistribution. The zipf distribution must be
+// set for KeyDistributionZipf.
+func sampleKeyIndex(generator *rand.Rand, zipf *rand.Zipf, config SourceConfig) int64 {
+	switch config.KeyDistribution {
+	case KeyDistributionZipf:
+		return int64(zipf.Uint64())
+	case KeyDistributionNormal:
+		mean := float64(config.NumKeys-1) / 2
+		idx := int64(math.Round(mean + generator.NormFloat64()*config.NormalStdDev*float64(config.NumKeys)))
+		if idx < 0 {
+			return 0
+		}
+		if idx >= config.NumKeys {
+			return config.NumKeys - 1
+		}
+		return idx
+	default:
+		return 0
+	}
+}
+
+// sampleKeySize returns the length of the next key to generate, sampled from the
+// config's KeySizeDistribution.
+func sampleKeySize(generator *rand.Rand, config SourceConfig) int64 {
+	return config.KeySizeDistribution.sample(generator, config.KeySize, config.MinKeySize, config.MaxKeySize, config.KeySizeStdDev)
+}
+
+// minKeySize returns the smallest key length a config can generate.
+func minKeySize(config SourceConfig) int64 {
+	if config.KeySizeDistribution == SizeDistributionFixed {
+		return config.KeySize
+	}
+	return config.MinKeySize
+}
+
+// sampleValueSize returns the length of the next value to generate, sampled
+// from the config's ValueSizeDistribution.
+func sampleValueSize(generator *rand.Rand, config SourceConfig) int64 {
+	return config.ValueSizeDistribution.sample(generator, config.ValueSize, config.MinValueSize, config.MaxValueSize, config.ValueSizeStdDev)
+}
+
+// minValueSize returns the smallest value length a config can generate.
+func minValueSize(config SourceConfig) int64 {
+	if config.ValueSizeDistribution == SizeDistributionFixed {
+		return config.ValueSize
+	}
+	return config.MinValueSize
+}
+
+// putIndex writes idx as a big-endian integer into the trailing bytes of key.
+// If key is longer than 8 bytes, the leading bytes are left untouched, and if
+// it is shorter, only the low-order bytes of idx are written.
+func putIndex(key []byte, idx int64) {
+	var idxBytes [8]byte
+	binary.BigEndian.PutUint64(idxBytes[:], uint64(idx))
+	if n := len(key); n >= len(idxBytes) {
+		copy(key[n-len(idxBytes):], idxBytes[:])
+	} else {
+		copy(key, idxBytes[len(idxBytes)-n:])
+	}
+}
+
+// SourceConfigBuilder is used to initialize SourceConfigs. See
+// SourceConfigBuilder's methods for descriptions of the fields in a
+// SourceConfig and how they can be set. The intended approach for using this
+// builder is to begin by calling the DefaultSourceConfig function, followed by
+// calling setters, followed by calling Build.
+//
+// Usage example:
+//
+//    cfg := synthetic.DefaultSourceConfig().NumElements(5000).InitialSplits(2).Build()
+type SourceConfigBuilder struct {
+	cfg SourceConfig
+}
+
+// DefaultSourceConfig creates a SourceConfigBuilder set with intended defaults
+// for the SourceConfig fields. This function is the intended starting point for
+// initializing a SourceConfig and should always be used to create
+// SourceConfigBuilders.
+//
+// To see descriptions of the various SourceConfig fields and their defaults,
+// see the methods to SourceConfigBuilder.
+func DefaultSourceConfig() *SourceConfigBuilder {
+	return &SourceConfigBuilder{
+		cfg: SourceConfig{
+			NumElements:             1, // 0 is invalid (drops elements).
+			Seed:                    0,
+			InitialSplits:           1, // 0 is invalid (drops elements).
+			SplitSkew:               0, // Defaults to even splits.
+			KeySize:                 8, // 0 is invalid (drops elements).
+			ValueSize:               8, // 0 is invalid (drops elements).
+			NumHotKeys:              0,
+			HotKeyFraction:          0,
+			HotKeyRotationInterval:  0, // Defaults to a fixed set of hot keys.
+			KeyMode:                 KeyModeRandom,
+			KeyDistribution:         KeyDistributionUniform,
+			NumKeys:                 0,   // Only used by non-uniform distributions.
+			ZipfExponent:            1.5, // Only used by KeyDistributionZipf.
+			NormalStdDev:            0.1, // Only used by KeyDistributionNormal.
+			ValueMode:               ValueModeRandom,
+			ValueCompressibility:    0, // Defaults to incompressible random values.
+			KeySizeDistribution:     SizeDistributionFixed,
+			MinKeySize:              0, // Only used by non-fixed distributions.
+			MaxKeySize:              0, // Only used by non-fixed distributions.
+			KeySizeStdDev:           0, // Only used by SizeDistributionNormal.
+			ValueSizeDistribution:   SizeDistributionFixed,
+			MinValueSize:            0, // Only used by non-fixed distributions.
+			MaxValueSize:            0, // Only used by non-fixed distributions.
+			ValueSizeStdDev:         0, // Only used by SizeDistributionNormal.
+			StartupDelay:            0,
+			InitialDelay:            0,
+			MaxBundleStartDelay:     0, // Defaults to a fixed InitialDelay.
+			SleepPerElement:         0, // Defaults to emitting elements as fast as possible.
+			SleepDistribution:       DelayDistributionConstant,
+			SleepStdDev:             0, // Only used by DelayDistributionNormal.
+			CPUBurnPerElement:       0, // Defaults to no simulated CPU work.
+			MaxRate:                 0, // Defaults to no rate limit.
+			RetainedBytesPerElement: 0, // Defaults to retaining no memory.
+			CheckpointEvery:         0, // Defaults to no self-checkpointing.
+			CheckpointInterval:      0,
+			ResumeDelay:             0,
+			OutputFormat:            OutputFormatKV,
+			BatchSize:               1,
+			SideOutputFraction:      0,
+			DuplicateFraction:       0, // Defaults to emitting every element exactly once.
+			TimestampMode:           TimestampModeNone,
+			StartTimestamp:          0, // The Unix epoch.
+			TimestampInterval:       time.Millisecond,
+			TimestampJitter:         0,
+			WatermarkLag:            0,
+			LateDataFraction:        0,
+			LatenessMin:             0,
+			LatenessMax:             0,
+			BundleFinalization:      false,
+			FailAtElement:           0,
+			FailTimes:               0, // Defaults to no injected failures.
+			ErrorRate:               0,
+			PanicRate:               0,
+			FailureScope:            FailureScopeElement,
+		},
+	}
+}
+
+// NumElements is the number of elements for the source to generate and emit.
+//
+// Valid values are in the range of [1, ...] and the default value is 1. Values
+// of 0 (and below) are invalid as they result in sources that emit no elements.
+func (b *SourceConfigBuilder) NumElements(val int) *SourceConfigBuilder {
+	b.cfg.NumElements = int64(val)
+	return b
+}
+
+// Seed determines the random data generated by the source. Keys and values are
+// fully deterministic for each element index and seed, so configs with the same
+// seed produce byte-for-byte identical data on every run, regardless of how the
+// source is split, while different seeds produce different data.
+//
+// Any value is valid and the default value is 0.
+func (b *SourceConfigBuilder) Seed(val int64) *SourceConfigBuilder {
+	b.cfg.Seed = val
+	return b
+}
+
+// InitialSplits determines the number of initial splits to perform in the
+// source's SplitRestriction method. Restrictions in synthetic sources represent
+// the number of elements being emitted, and this split is performed evenly
+// across that number of elements.
+//
+// Each resulting restriction will have at least 1 element in it, and each
+// element being emitted will be contained in exactly one restriction. That
+// means that if the desired number of splits is greater than the number of
+// elements N, then N initial restrictions will be created, each containing 1
+// element.
+//
+// Valid values are in the range of [1, ...] and the default value is 1. Values
+// of 0 (and below) are invalid as they would result in dropping elements that
+// are expected to be emitted.
+func (b *SourceConfigBuilder) InitialSplits(val int) *SourceConfigBuilder {
+	b.cfg.InitialSplits = int64(val)
+	return b
+}
+
+// SplitSkew determines how imbalanced the initial splits of the source are, in
+// order to test liquid sharding and work stealing in runners. When SplitSkew
+// is greater than 0, the first initial split holds that fraction of the
+// elements, and the remaining elements are split evenly across the other
+// InitialSplits - 1 splits. For example, a SplitSkew of 0.9 with 5 initial
+// splits puts 90% of the elements in one split, and 2.5% in each of the others.
+//
+// Valid values are in the range of [0, 1) and the default value is 0, meaning
+// the initial splits are even.
+func (b *SourceConfigBuilder) SplitSkew(val float64) *SourceConfigBuilder {
+	b.cfg.SplitSkew = val
+	return b
+}
+
+// KeySize determines the size of the key of elements for the source to
+// generate.
+//
+// Valid values are in the range of [1, ...] and the default value is 8.
+func (b *SourceConfigBuilder) KeySize(val int) *SourceConfigBuilder {
+	b.cfg.KeySize = int64(val)
+	return b
+}
+
+// KeyDistribution determines how often each key is emitted. See the
+// KeyDistribution constants for the available distributions. Non-uniform
+// distributions emit keys from a fixed set of NumKeys keys, in order to model
+// realistic skew, such as for benchmarking GroupByKey and combiner lifting.
+//
+// The default value is KeyDistributionUniform. Non-uniform distributions
+// require a NumKeys of at least 1, and cannot be combined with hot keys or
+// KeyModeSequential.
+func (b *SourceConfigBuilder) KeyDistribution(val KeyDistribution) *SourceConfigBuilder {
+	b.cfg.KeyDistribution = val
+	return b
+}
+
+// NumKeys is the number of distinct keys emitted by non-uniform key
+// distributions. It is ignored by KeyDistributionUniform.
+//
+// Valid values are in the range of [1, ...] when using a non-uniform
+// distribution, and the default value is 0.
+func (b *SourceConfigBuilder) NumKeys(val int) *SourceConfigBuilder {
+	b.cfg.NumKeys = int64(val)
+	return b
+}
+
+// ZipfExponent is the exponent s of KeyDistributionZipf, where the key of rank
+// k is emitted with probability proportional to 1/k^s. Larger exponents produce
+// more skew.
+//
+// Valid values are in the range of (1, ...] and the default value is 1.5.
+func (b *SourceConfigBuilder) ZipfExponent(val float64) *SourceConfigBuilder {
+	b.cfg.ZipfExponent = val
+	return b
+}
+
+// NormalStdDev is the standard deviation of KeyDistributionNormal, as a
+// fraction of NumKeys. Smaller values concentrate elements on fewer keys.
+//
+// Valid values are in the range of (0, ...] and the default value is 0.1.
+func (b *SourceConfigBuilder) NormalStdDev(val float64) *SourceConfigBuilder {
+	b.cfg.NormalStdDev = val
+	return b
+}
+
+// KeySizeDistribution determines how the size of each generated key is chosen.
+// With SizeDistributionFixed, every key is KeySize bytes long and min and max
+// are ignored. With the other distributions, each key's size is sampled from
+// [min, max] instead of using KeySize. Hot keys keep the same size each time
+// they are emitted. SizeDistributionNormal also requires a KeySizeStdDev.
+//
+// Valid min and max values for non-fixed distributions are in the range of
+// [1, ...] with min <= max. The default distribution is SizeDistributionFixed.
+func (b *SourceConfigBuilder) KeySizeDistribution(dist SizeDistribution, min, max int) *SourceConfigBuilder {
+	b.cfg.KeySizeDistribution = dist
+	b.cfg.MinKeySize = int64(min)
+	b.cfg.MaxKeySize = int64(max)
+	return b
+}
+
+// KeySizeStdDev is the standard deviation in bytes of key sizes sampled from
+// SizeDistributionNormal. It is ignored by other distributions.
+//
+// Valid values are in the range of (0, ...] when KeySizeDistribution is
+// SizeDistributionNormal, and the default value is 0.
+func (b *SourceConfigBuilder) KeySizeStdDev(val float64) *SourceConfigBuilder {
+	b.cfg.KeySizeStdDev = val
+	return b
+}
+
+// ValueSize determines the size of the value of elements for the source to
+// generate.
+//
+// Valid values are in the range of [1, ...] and the default value is 8.
+func (b *SourceConfigBuilder) ValueSize(val int) *SourceConfigBuilder {
+	b.cfg.ValueSize = int64(val)
+	return b
+}
+
+// ValueSizeDistribution determines how the size of each generated value is
+// chosen, in the same way as KeySizeDistribution does for keys. With
+// SizeDistributionFixed, every value is ValueSize bytes long and min and max are
+// ignored. SizeDistributionNormal also requires a ValueSizeStdDev.
+//
+// Valid min and max values for non-fixed distributions are in the range of
+// [1, ...] with min <= max. The default distribution is SizeDistributionFixed.
+func (b *SourceConfigBuilder) ValueSizeDistribution(dist SizeDistribution, min, max int) *SourceConfigBuilder {
+	b.cfg.ValueSizeDistribution = dist
+	b.cfg.MinValueSize = int64(min)
+	b.cfg.MaxValueSize = int64(max)
+	return b
+}
+
+// ValueSizeStdDev is the standard deviation in bytes of value sizes sampled
+// from SizeDistributionNormal. It is ignored by other distributions.
+//
+// Valid values are in the range of (0, ...] when ValueSizeDistribution is
+// SizeDistributionNormal, and the default value is 0.
+func (b *SourceConfigBuilder) ValueSizeStdDev(val float64) *SourceConfigBuilder {
+	b.cfg.ValueSizeStdDev = val
+	return b
+}
+
+// ValueMode determines how the source generates the contents of each value.
+// See the ValueMode constants for the available modes.
+//
+// The default value is ValueModeRandom. ValueModeVerifiable requires a
+// ValueSize of at least VerifiableValueOverhead.
+func (b *SourceConfigBuilder) ValueMode(val ValueMode) *SourceConfigBuilder {
+	b.cfg.ValueMode = val
+	return b
+}
+
+// ValueCompressibility is the fraction of each value's bytes that are
+// repetitive instead of random, so that values compress at roughly that ratio.
+// Random values defeat compression entirely, so this is useful to benchmark
+// compression in shuffles and data channels realistically. For example, a
+// ValueCompressibility of 0.8 generates values that compress to about 20% of
+// their size. With ValueModeVerifiable, the verifiable structure is kept
+// intact.
+//
+// Valid values are floating point numbers from 0 to 1, and the default value
+// is 0, meaning values are entirely random.
+func (b *SourceConfigBuilder) ValueCompressibility(val float64) *SourceConfigBuilder {
+	b.cfg.ValueCompressibility = val
+	return b
+}
+
+// NumHotKeys determines the number of distinct hot keys that elements selected
+// by HotKeyFraction are mapped to. Elements are assigned to hot keys in a round
+// robin based on their index, so with enough elements every hot key is used.
+//
+// Valid values are in the range of [0, ...] and the default value is 0. If
+// HotKeyFraction is greater than 0, then at least 1 hot key is required, and
+// the KeySize must be large enough to hold NumHotKeys distinct values.
+func (b *SourceConfigBuilder) NumHotKeys(val int) *SourceConfigBuilder {
+	b.cfg.NumHotKeys = int64(val)
+	return b
+}
+
+// HotKeyFraction determines the fraction of elements that are emitted with
+// one of the hot keys instead of a random key. Whether an element is hot is
+// decided deterministically from the element's index, so a given element is
+// either hot or not regardless of how the source is split.
+//
+// Valid values are floating point numbers from 0 to 1, and the default value
+// is 0, meaning no hot keys are emitted.
+func (b *SourceConfigBuilder) HotKeyFraction(val float64) *SourceConfigBuilder {
+	b.cfg.HotKeyFraction = val
+	return b
+}
+
+// HotKeyRotationInterval makes the set of hot keys change on a schedule, in
+// order to test how runners detect hot keys and rebalance key ranges under
+// shifting skew. Each interval of event time after the StartTimestamp uses a
+// different set of NumHotKeys hot keys, so a source emitting elements over an
+// hour with an interval of 5 minutes has 12 successive sets of hot keys. The
+// HotKeyFraction stays the same in every interval.
+//
+// Rotating hot keys requires a TimestampMode other than TimestampModeNone, and
+// the KeySize should be large enough to hold the hot keys of every interval,
+// or the sets will overlap.
+//
+// Valid values are in the range of [0, ...] and the default value is 0,
+// meaning the hot keys never change.
+func (b *SourceConfigBuilder) HotKeyRotationInterval(val time.Duration) *SourceConfigBuilder {
+	b.cfg.HotKeyRotationInterval = val
+	return b
+}
+
+// KeyMode determines how the source generates the keys of elements. See the
+// KeyMode constants for the available modes.
+//
+// The default value is KeyModeRandom. In KeyModeSequential, the KeySize must be
+// large enough to hold NumElements distinct values, and hot keys cannot be
+// used, since every key is unique. Keys must also have a fixed size, so that
+// they sort in the order of their indices when compared as bytes.
+func (b *SourceConfigBuilder) KeyMode(val KeyMode) *SourceConfigBuilder {
+	b.cfg.KeyMode = val
+	return b
+}
+
+// StartupDelay is the amount of time the source sleeps before claiming its
+// first offset, in order to simulate a slow-to-start source, such as one that
+// must load a large client library or warm up a connection pool. While the
+// source sleeps, it emits no elements and holds back its watermark, which is
+// useful for testing watermark holds and the detection of idle stages.
+//
+// Unlike InitialDelay, it is paid only once by each instance of the source's
+// DoFn, on the first bundle that instance processes. Since runners typically
+// reuse DoFn instances across bundles, this models a cold start per worker.
+//
+// Valid values are in the range of [0, ...] and the default value is 0, meaning
+// no delay.
+func (b *SourceConfigBuilder) StartupDelay(val time.Duration) *SourceConfigBuilder {
+	b.cfg.StartupDelay = val
+	return b
+}
+
+// InitialDelay is the amount of time the source sleeps once at the start of
+// processing each bundle, before emitting any elements. This models the
+// fixed startup cost of real sources, such as opening a file or establishing a
+// session, which is paid once per bundle regardless of the number of elements
+// or restrictions the bundle processes, so that finer splits into more bundles
+// take longer in total. See MaxBundleStartDelay to vary the delay between
+// bundles.
+//
+// Valid values are in the range of [0, ...] and the default value is 0, meaning
+// no delay.
+func (b *SourceConfigBuilder) InitialDelay(val time.Duration) *SourceConfigBuilder {
+	b.cfg.InitialDelay = val
+	return b
+}
+
+// MaxBundleStartDelay spreads the InitialDelay paid at the start of each
+// bundle, in order to model bundles whose startup cost varies, such as
+// sessions that are sometimes slow to establish. When it exceeds the
+// InitialDelay, each bundle sleeps for a delay chosen uniformly at random from
+// [InitialDelay, MaxBundleStartDelay], and otherwise for the fixed
+// InitialDelay.
+//
+// Each bundle also increments the "bundles_started" counter in the
+// "synthetic.Source" namespace, which can be compared to the
+// "bundles_finalized" counter of BundleFinalization.
+//
+// Valid values are in the range of [0, ...] and the default value is 0, meaning
+// the delay is fixed.
+func (b *SourceConfigBuilder) MaxBundleStartDelay(val time.Duration) *SourceConfigBuilder {
+	b.cfg.MaxBundleStartDelay = val
+	return b
+}
+
+// SleepPerElement is the amount of time the source sleeps before emitting each
+// element, in order to model a slow source, such as one reading from a
+// high-latency external system. Slow sources are useful for exercising dynamic
+// splitting and autoscaling, since a restriction takes time proportional to
+// its size to process. Restriction sizes are weighted by this delay.
+//
+// Valid values are in the range of [0, ...] and the default value is 0, meaning
+// no delay.
+func (b *SourceConfigBuilder) SleepPerElement(val time.Duration) *SourceConfigBuilder {
+	b.cfg.SleepPerElement = val
+	return b
+}
+
+// SleepDistribution determines how the sleep before each element is drawn,
+// with SleepPerElement as the mean sleep. Varying the sleeps gives the progress
+// reported to the runner a realistic shape, which is useful for testing dynamic
+// splitting. See the DelayDistribution constants for the available
+// distributions. Sleeps are derived from each element's index, so they are the
+// same regardless of how the source is split.
+//
+// The default value is DelayDistributionConstant. DelayDistributionNormal also
+// requires a SleepStdDev.
+func (b *SourceConfigBuilder) SleepDistribution(val DelayDistribution) *SourceConfigBuilder {
+	b.cfg.SleepDistribution = val
+	return b
+}
+
+// SleepStdDev is the standard deviation of per-element sleeps drawn from
+// DelayDistributionNormal. It is ignored by other distributions.
+//
+// Valid values are in the range of (0, ...] when SleepDistribution is
+// DelayDistributionNormal, and the default value is 0.
+func (b *SourceConfigBuilder) SleepStdDev(val time.Duration) *SourceConfigBuilder {
+	b.cfg.SleepStdDev = val
+	return b
+}
+
+// CPUBurnPerElement is the amount of time the source spends busy on the CPU
+// before emitting each element, in order to model a CPU-bound source. Unlike
+// SleepPerElement, this drives up the CPU utilization of workers, which is
+// useful for benchmarking autoscaling. Restriction sizes are weighted by this
+// amount of work.
+//
+// Valid values are in the range of [0, ...] and the default value is 0, meaning
+// no CPU work is performed.
+func (b *SourceConfigBuilder) CPUBurnPerElement(val time.Duration) *SourceConfigBuilder {
+	b.cfg.CPUBurnPerElement = val
+	return b
+}
+
+// MaxRate limits the rate at which the source emits elements, in elements per
+// second. The limit applies separately to each restriction being processed, so
+// the total rate of a source scales with the number of restrictions processed
+// in parallel. Emission is paced with a token bucket that allows short bursts
+// of up to 10ms worth of elements, to produce steady load for latency
+// benchmarks. Restriction sizes are weighted by the resulting time per element.
+//
+// Valid values are in the range of [0, ...] and the default value is 0, meaning
+// emission is not rate limited.
+func (b *SourceConfigBuilder) MaxRate(val float64) *SourceConfigBuilder {
+	b.cfg.MaxRate = val
+	return b
+}
+
+// RetainedBytesPerElement is the amount of memory that the source allocates
+// and holds for each element it emits, until the end of the bundle. This
+// drives up the memory usage of workers in proportion to the size of bundles,
+// in order to test how runners handle memory pressure, such as out of memory
+// failures and memory-based autoscaling.
+//
+// Valid values are in the range of [0, ...] and the default value is 0,
+// meaning no memory is retained.
+func (b *SourceConfigBuilder) RetainedBytesPerElement(val int) *SourceConfigBuilder {
+	b.cfg.RetainedBytesPerElement = int64(val)
+	return b
+}
+
+// CheckpointEvery makes the source self-checkpoint after claiming val
+// elements, by returning a ProcessContinuation that asks the runner to resume
+// the rest of its restriction after ResumeDelay. This is useful for testing
+// how runners handle SDF self-checkpointing and resumption.
+//
+// Self-checkpointing makes the source's output unbounded, and is only
+// supported by SourceSingle with the OutputFormatKV output format.
+//
+// Valid values are in the range of [0, ...] and the default value is 0,
+// meaning the source doesn't checkpoint based on the number of elements.
+func (b *SourceConfigBuilder) CheckpointEvery(val int) *SourceConfigBuilder {
+	b.cfg.CheckpointEvery = int64(val)
+	return b
+}
+
+// CheckpointInterval makes the source self-checkpoint once it has spent val
+// processing its restriction since it was started or last resumed. It can be
+// combined with CheckpointEvery, in which case the source checkpoints on
+// whichever comes first. See CheckpointEvery.
+//
+// Valid values are in the range of [0, ...] and the default value is 0,
+// meaning the source doesn't checkpoint based on elapsed time.
+func (b *SourceConfigBuilder) CheckpointInterval(val time.Duration) *SourceConfigBuilder {
+	b.cfg.CheckpointInterval = val
+	return b
+}
+
+// ResumeDelay is how long the source asks the runner to wait before resuming
+// a self-checkpointed restriction. See CheckpointEvery.
+//
+// Valid values are in the range of [0, ...] and the default value is 0,
+// meaning the restriction can be resumed immediately.
+func (b *SourceConfigBuilder) ResumeDelay(val time.Duration) *SourceConfigBuilder {
+	b.cfg.ResumeDelay = val
+	return b
+}
+
+// OutputFormat determines the type of elements emitted by the source. See the
+// OutputFormat constants for the available formats.
+//
+// The default value is OutputFormatKV. Formats other than OutputFormatKV are
+// only supported by SourceSingle.
+func (b *SourceConfigBuilder) OutputFormat(val OutputFormat) *SourceConfigBuilder {
+	b.cfg.OutputFormat = val
+	return b
+}
+
+// BatchSize is the number of elements emitted together in each []Element when
+// using OutputFormatBatch. The last batch produced for each restriction may
+// contain fewer elements. BatchSize is ignored by other output formats.
+//
+// Valid values are in the range of [1, ...] and the default value is 1.
+func (b *SourceConfigBuilder) BatchSize(val int) *SourceConfigBuilder {
+	b.cfg.BatchSize = int64(val)
+	return b
+}
+
+// SideOutputFraction determines the fraction of elements that are emitted to
+// the side output of SourceWithSideOutput instead of its main output. Which
+// elements are emitted to the side output is decided deterministically from
+// each element's index.
+//
+// Valid values are floating point numbers from 0 to 1, and the default value
+// is 0. Values above 0 are only supported by SourceWithSideOutput.
+func (b *SourceConfigBuilder) SideOutputFraction(val float64) *SourceConfigBuilder {
+	b.cfg.SideOutputFraction = val
+	return b
+}
+
+// DuplicateFraction determines the fraction of elements that are emitted twice,
+// with identical keys, values and event times, in order to test deduplication
+// and exactly-once logic downstream. Which elements are duplicated is decided
+// deterministically from each element's index and the Seed, so the duplicates
+// are the same regardless of how the source is split. Duplicates are emitted to
+// the same output as the original, and count toward the source's metrics.
+//
+// Valid values are floating point numbers from 0 to 1, and the default value
+// is 0, meaning no elements are duplicated.
+func (b *SourceConfigBuilder) DuplicateFraction(val float64) *SourceConfigBuilder {
+	b.cfg.DuplicateFraction = val
+	return b
+}
+
+// TimestampMode determines the event times assigned to generated elements. See
+// the TimestampMode constants for the available modes. Assigning event times
+// allows synthetic pipelines to exercise windowing and triggers, and the
+// source's watermark estimator tracks the emitted timestamps.
+//
+// The default value is TimestampModeNone, meaning elements keep the timestamp
+// of the SourceConfig element they were generated from.
+func (b *SourceConfigBuilder) TimestampMode(val TimestampMode) *SourceConfigBuilder {
+	b.cfg.TimestampMode = val
+	return b
+}
+
+// StartTimestamp is the event time of the first generated element, used by all
+// timestamp modes other than TimestampModeNone. It is stored with millisecond
+// precision.
+//
+// The default value is the Unix epoch.
+func (b *SourceConfigBuilder) StartTimestamp(val time.Time) *SourceConfigBuilder {
+	b.cfg.StartTimestamp = val.UnixNano() / int64(time.Millisecond)
+	return b
+}
+
+// TimestampInterval is the amount of event time between consecutive elements
+// for TimestampModeMonotonic and TimestampModeJittered. For
+// TimestampModeUniform, timestamps are spread over NumElements intervals.
+//
+// Valid values are in the range of [0, ...] and the default value is 1ms.
+func (b *SourceConfigBuilder) TimestampInterval(val time.Duration) *SourceConfigBuilder {
+	b.cfg.TimestampInterval = val
+	return b
+}
+
+// TimestampJitter is the maximum amount of event time each element's timestamp
+// is offset by, either earlier or later, in TimestampModeJittered. Jitter
+// larger than TimestampInterval produces out-of-order timestamps.
+//
+// Valid values are in the range of [0, ...] and the default value is 0.
+func (b *SourceConfigBuilder) TimestampJitter(val time.Duration) *SourceConfigBuilder {
+	b.cfg.TimestampJitter = val
+	return b
+}
+
+// WatermarkLag is the amount of time the source's output watermark trails the
+// maximum timestamp of the elements it has emitted. This simulates a source
+// with out-of-order data, where elements up to WatermarkLag older than the
+// newest element may still arrive without being late.
+//
+// Valid values are in the range of [0, ...] and the default value is 0, meaning
+// the watermark advances to the newest emitted timestamp.
+func (b *SourceConfigBuilder) WatermarkLag(val time.Duration) *SourceConfigBuilder {
+	b.cfg.WatermarkLag = val
+	return b
+}
+
+// LateDataFraction determines the fraction of elements that are emitted
+// behind the source's watermark, in order to test allowed lateness and the
+// handling of late panes. Late elements have their event time moved back by
+// WatermarkLag plus a lateness drawn uniformly from [LatenessMin, LatenessMax].
+// Which elements are late is decided deterministically from their index.
+//
+// Late data requires a TimestampMode other than TimestampModeNone. Elements
+// are only guaranteed to be behind the watermark if their lateness exceeds the
+// spacing between the timestamps of consecutive elements.
+//
+// Valid values are in the range of [0.0, 1.0] and the default value is 0,
+// meaning no elements are late.
+func (b *SourceConfigBuilder) LateDataFraction(val float64) *SourceConfigBuilder {
+	b.cfg.LateDataFraction = val
+	return b
+}
+
+// Lateness sets the range [min, max] that the lateness of late elements is
+// drawn uniformly from. Equal min and max give every late element the same
+// lateness. See LateDataFraction.
+//
+// Valid values are in the range of (0, ...] with min <= max, and both default
+// to 0, which is only valid when there is no late data.
+func (b *SourceConfigBuilder) Lateness(min, max time.Duration) *SourceConfigBuilder {
+	b.cfg.LatenessMin = min
+	b.cfg.LatenessMax = max
+	return b
+}
+
+// BundleFinalization determines whether the source registers a bundle
+// finalization callback for each bundle it processes. The callback increments
+// the "bundles_finalized" counter in the "synthetic.Source" namespace and the
+// count returned by BundlesFinalized, so that tests can observe whether
+// finalization callbacks run once per successfully committed bundle. Since
+// runners finalize bundles after reporting their metrics, the counter is only
+// visible on some runners, such as the direct runner, while BundlesFinalized
+// is visible whenever the pipeline runs in the same process.
+//
+// The default value is false. Bundle finalization is only supported by
+// SourceSingle with the OutputFormatKV output format.
+func (b *SourceConfigBuilder) BundleFinalization(val bool) *SourceConfigBuilder {
+	b.cfg.BundleFinalization = val
+	return b
+}
+
+// FailAtElement injects a failure into the source, in order to test how
+// runners retry failed bundles. When the source claims the element at index
+// idx, processing returns an error for the first times attempts, and succeeds
+// on later attempts. If times is negative, every attempt fails. Since the
+// failure is keyed to the element's index, it is deterministic regardless of
+// how the source is split.
+//
+// Attempts are counted within the process running the source, so transient
+// failures are only guaranteed to recover on in-process runners such as the
+// direct runner.
+//
+// Valid values of idx are in the range of [0, NumElements). The default value
+// of times is 0, meaning no failure is injected.
+func (b *SourceConfigBuilder) FailAtElement(idx int, times int) *SourceConfigBuilder {
+	b.cfg.FailAtElement = int64(idx)
+	b.cfg.FailTimes = int64(times)
+	return b
+}
+
+// ErrorRate is the probability that a unit of work, as chosen by FailureScope,
+// fails by returning an error from the source's ProcessElement. This is useful
+// for testing how runners retry and re-execute failed bundles. Failures are
+// drawn randomly on each attempt, so retried work may succeed or fail
+// independently of earlier attempts.
+//
+// Valid values are in the range [0, 1], and ErrorRate plus PanicRate cannot
+// exceed 1. The default value is 0, meaning no errors are injected.
+func (b *SourceConfigBuilder) ErrorRate(val float64) *SourceConfigBuilder {
+	b.cfg.ErrorRate = val
+	return b
+}
+
+// PanicRate is the probability that a unit of work, as chosen by FailureScope,
+// fails by panicking in the source's ProcessElement. It behaves like ErrorRate,
+// except that it exercises the runner's handling of panicking user code.
+//
+// Valid values are in the range [0, 1], and ErrorRate plus PanicRate cannot
+// exceed 1. The default value is 0, meaning no panics are injected.
+func (b *SourceConfigBuilder) PanicRate(val float64) *SourceConfigBuilder {
+	b.cfg.PanicRate = val
+	return b
+}
+
+// FailureScope determines whether ErrorRate and PanicRate apply to each
+// element or to each bundle the source processes. See the FailureScope
+// constants for the available scopes.
+//
+// The default value is FailureScopeElement.
+func (b *SourceConfigBuilder) FailureScope(val FailureScope) *SourceConfigBuilder {
+	b.cfg.FailureScope = val
+	return b
+}
+
+// Validate checks the SourceConfig being initialized by this builder for
+// settings that are valid but likely to be misconfigured, and returns a
+// human-readable warning for each one found. An empty result means no warnings.
+// Unlike Build, Validate does not panic, and it does not check for the invalid
+// values that Build rejects.
+func (b *SourceConfigBuilder) Validate() []string {
+	var warnings []string
+	cfg := b.cfg
+	if cfg.InitialSplits > cfg.NumElements {
+		warnings = append(warnings, fmt.Sprintf("InitialSplits (%v) exceeds NumElements (%v), so only %v splits will be created",
+			cfg.InitialSplits, cfg.NumElements, cfg.NumElements))
+	}
+	if cfg.NumHotKeys > cfg.NumElements {
+		warnings = append(warnings, fmt.Sprintf("NumHotKeys (%v) exceeds NumElements (%v), so some hot keys will never be emitted",
+			cfg.NumHotKeys, cfg.NumElements))
+	}
+	if cfg.NumHotKeys > 0 && cfg.HotKeyFraction == 0 {
+		warnings = append(warnings, fmt.Sprintf("NumHotKeys is %v but HotKeyFraction is 0, so no hot keys will be emitted",
+			cfg.NumHotKeys))
+	}
+	if size := minKeySize(cfg); cfg.KeyMode == KeyModeRandom && size > 0 && size < 8 && cfg.NumElements > 1<<(8*size) {
+		warnings = append(warnings, fmt.Sprintf("KeySize of %v bytes can hold fewer distinct values than NumElements (%v), so random keys will collide",
+			size, cfg.NumElements))
+	}
+	return warnings
+}
+
+// Build constructs the SourceConfig initialized by this builder. It also
+// performs error checking on the fields, and panics if any have been set to
+// invalid values.
+func (b *SourceConfigBuilder) Build() SourceConfig {
+	if err := b.validate(); err != nil {
+		panic(err.Error())
+	}
+	return b.cfg
+}
+
+// validate returns an error describing the first field of the SourceConfig
+// being initialized that has been set to an invalid value, or nil if all fields
+// are valid.
+func (b *SourceConfigBuilder) validate() error {
+	if b.cfg.InitialSplits <= 0 {
+		return fmt.Errorf("SourceConfig.InitialSplits must be >= 1. Got: %v", b.cfg.InitialSplits)
+	}
+	if b.cfg.NumElements <= 0 {
+		return fmt.Errorf("SourceConfig.NumElements must be >= 1. Got: %v", b.cfg.NumElements)
+	}
+	if b.cfg.SplitSkew < 0 || b.cfg.SplitSkew >= 1 {
+		return fmt.Errorf("SourceConfig.SplitSkew must be a floating point number from 0 up to but excluding 1. Got: %v", b.cfg.SplitSkew)
+	}
+	if b.cfg.KeySize <= 0 {
+		return fmt.Errorf("SourceConfig.KeySize must be >= 1. Got: %v", b.cfg.KeySize)
+	}
+	if b.cfg.ValueSize <= 0 {
+		return fmt.Errorf("SourceConfig.ValueSize must be >= 1. Got: %v", b.cfg.ValueSize)
+	}
+	if err := validateSizeDistribution("Key", b.cfg.KeySizeDistribution, b.cfg.MinKeySize, b.cfg.MaxKeySize, b.cfg.KeySizeStdDev); err != nil {
+		return err
+	}
+	if err := validateSizeDistribution("Value", b.cfg.ValueSizeDistribution, b.cfg.MinValueSize, b.cfg.MaxValueSize, b.cfg.ValueSizeStdDev); err != nil {
+		return err
+	}
+	if b.cfg.ValueCompressibility < 0 || b.cfg.ValueCompressibility > 1 {
+		return fmt.Errorf("SourceConfig.ValueCompressibility must be a floating point number from 0 and 1. Got: %v", b.cfg.ValueCompressibility)
+	}
+	switch b.cfg.ValueMode {
+	case ValueModeRandom:
+	case ValueModeVerifiable:
+		if size := minValueSize(b.cfg); size < int64(VerifiableValueOverhead) {
+			return fmt.Errorf("SourceConfig.ValueSize must be >= %v when ValueMode is %q. Got: %v", VerifiableValueOverhead, b.cfg.ValueMode, size)
+		}
+	default:
+		return fmt.Errorf("SourceConfig.ValueMode must be one of %q or %q. Got: %q", ValueModeRandom, ValueModeVerifiable, b.cfg.ValueMode)
+	}
+	if b.cfg.NumHotKeys < 0 {
+		return fmt.Errorf("SourceConfig.NumHotKeys must be >= 0. Got: %v", b.cfg.NumHotKeys)
+	}
+	if b.cfg.HotKeyFraction < 0 || b.cfg.HotKeyFraction > 1 {
+		return fmt.Errorf("SourceConfig.HotKeyFraction must be a floating point number from 0 and 1. Got: %v", b.cfg.HotKeyFraction)
+	}
+	if b.cfg.HotKeyFraction > 0 && b.cfg.NumHotKeys < 1 {
+		return fmt.Errorf("SourceConfig.NumHotKeys must be >= 1 when HotKeyFraction is greater than 0. Got: %v", b.cfg.NumHotKeys)
+	}
+	if size := minKeySize(b.cfg); size < 8 && b.cfg.NumHotKeys > 1<<(8*size) {
+		return fmt.Errorf("SourceConfig.KeySize of %v is too small to hold %v distinct hot keys", size, b.cfg.NumHotKeys)
+	}
+	if b.cfg.HotKeyRotationInterval < 0 {
+		return fmt.Errorf("SourceConfig.HotKeyRotationInterval cannot be negative. Got: %v", b.cfg.HotKeyRotationInterval)
+	}
+	if b.cfg.HotKeyRotationInterval > 0 && b.cfg.TimestampMode == TimestampModeNone {
+		return fmt.Errorf("SourceConfig.TimestampMode cannot be %q when HotKeyRotationInterval is greater than 0", b.cfg.TimestampMode)
+	}
+	if b.cfg.StartupDelay < 0 {
+		return fmt.Errorf("SourceConfig.StartupDelay cannot be negative. Got: %v", b.cfg.StartupDelay)
+	}
+	if b.cfg.InitialDelay < 0 {
+		return fmt.Errorf("SourceConfig.InitialDelay cannot be negative. Got: %v", b.cfg.InitialDelay)
+	}
+	if b.cfg.MaxBundleStartDelay < 0 {
+		return fmt.Errorf("SourceConfig.MaxBundleStartDelay cannot be negative. Got: %v", b.cfg.MaxBundleStartDelay)
+	}
+	switch b.cfg.OutputFormat {
+	case OutputFormatKV, OutputFormatBytes, OutputFormatBatch, OutputFormatRow:
+	default:
+		return fmt.Errorf("SourceConfig.OutputFormat must be one of %q, %q, %q, or %q. Got: %q",
+			OutputFormatKV, OutputFormatBytes, OutputFormatBatch, OutputFormatRow, b.cfg.OutputFormat)
+	}
+	if b.cfg.BatchSize <= 0 {
+		return fmt.Errorf("SourceConfig.BatchSize must be >= 1. Got: %v", b.cfg.BatchSize)
+	}
+	if b.cfg.SleepPerElement < 0 {
+		return fmt.Errorf("SourceConfig.SleepPerElement cannot be negative. Got: %v", b.cfg.SleepPerElement)
+	}
+	switch b.cfg.SleepDistribution {
+	case DelayDistributionConstant, DelayDistributionUniform, DelayDistributionExponential:
+	case DelayDistributionNormal:
+		if b.cfg.SleepStdDev <= 0 {
+			return fmt.Errorf("SourceConfig.SleepStdDev must be positive when SleepDistribution is %q. Got: %v", b.cfg.SleepDistribution, b.cfg.SleepStdDev)
+		}
+	default:
+		return fmt.Errorf("SourceConfig.SleepDistribution must be one of %q, %q, %q, or %q. Got: %q",
+			DelayDistributionConstant, DelayDistributionUniform, DelayDistributionExponential, DelayDistributionNormal, b.cfg.SleepDistribution)
+	}
+	if b.cfg.CPUBurnPerElement < 0 {
+		return fmt.Errorf("SourceConfig.CPUBurnPerElement cannot be negative. Got: %v", b.cfg.CPUBurnPerElement)
+	}
+	if b.cfg.MaxRate < 0 {
+		return fmt.Errorf("SourceConfig.MaxRate cannot be negative. Got: %v", b.cfg.MaxRate)
+	}
+	if b.cfg.RetainedBytesPerElement < 0 {
+		return fmt.Errorf("SourceConfig.RetainedBytesPerElement cannot be negative. Got: %v", b.cfg.RetainedBytesPerElement)
+	}
+	if b.cfg.CheckpointEvery < 0 {
+		return fmt.Errorf("SourceConfig.CheckpointEvery cannot be negative. Got: %v", b.cfg.CheckpointEvery)
+	}
+	if b.cfg.CheckpointInterval < 0 {
+		return fmt.Errorf("SourceConfig.CheckpointInterval cannot be negative. Got: %v", b.cfg.CheckpointInterval)
+	}
+	if b.cfg.ResumeDelay < 0 {
+		return fmt.Errorf("SourceConfig.ResumeDelay cannot be negative. Got: %v", b.cfg.ResumeDelay)
+	}
+	if checkpointing(b.cfg) && b.cfg.OutputFormat != OutputFormatKV {
+		return fmt.Errorf("SourceConfig self-checkpointing requires OutputFormat %q. Got: %q", OutputFormatKV, b.cfg.OutputFormat)
+	}
+	if checkpointing(b.cfg) && b.cfg.BundleFinalization {
+		return fmt.Errorf("SourceConfig self-checkpointing cannot be combined with BundleFinalization")
+	}
+	switch b.cfg.TimestampMode {
+	case TimestampModeNone, TimestampModeMonotonic, TimestampModeJittered, TimestampModeUniform:
+	default:
+		return fmt.Errorf("SourceConfig.TimestampMode must be one of %q, %q, %q, or %q. Got: %q",
+			TimestampModeNone, TimestampModeMonotonic, TimestampModeJittered, TimestampModeUniform, b.cfg.TimestampMode)
+	}
+	if b.cfg.TimestampInterval < 0 {
+		return fmt.Errorf("SourceConfig.TimestampInterval cannot be negative. Got: %v", b.cfg.TimestampInterval)
+	}
+	if b.cfg.TimestampJitter < 0 {
+		return fmt.Errorf("SourceConfig.TimestampJitter cannot be negative. Got: %v", b.cfg.TimestampJitter)
+	}
+	if b.cfg.LateDataFraction < 0 || b.cfg.LateDataFraction > 1 {
+		return fmt.Errorf("SourceConfig.LateDataFraction must be a floating point number from 0 and 1. Got: %v", b.cfg.LateDataFraction)
+	}
+	if b.cfg.LateDataFraction > 0 {
+		if b.cfg.TimestampMode == TimestampModeNone {
+			return fmt.Errorf("SourceConfig.TimestampMode cannot be %q when LateDataFraction is greater than 0", b.cfg.TimestampMode)
+		}
+		if b.cfg.LatenessMin <= 0 {
+			return fmt.Errorf("SourceConfig.LatenessMin must be positive when LateDataFraction is greater than 0. Got: %v", b.cfg.LatenessMin)
+		}
+		if b.cfg.LatenessMin > b.cfg.LatenessMax {
+			return fmt.Errorf("SourceConfig.LatenessMin must be <= LatenessMax. Got: %v > %v", b.cfg.LatenessMin, b.cfg.LatenessMax)
+		}
+	}
+	if b.cfg.WatermarkLag < 0 {
+		return fmt.Errorf("SourceConfig.WatermarkLag cannot be negative. Got: %v", b.cfg.WatermarkLag)
+	}
+	if b.cfg.BundleFinalization && b.cfg.OutputFormat != OutputFormatKV {
+		return fmt.Errorf("SourceConfig.BundleFinalization requires OutputFormat %q. Got: %q", OutputFormatKV, b.cfg.OutputFormat)
+	}
+	if b.cfg.FailTimes != 0 && (b.cfg.FailAtElement < 0 || b.cfg.FailAtElement >= b.cfg.NumElements) {
+		return fmt.Errorf("SourceConfig.FailAtElement must be in the range [0, %v). Got: %v", b.cfg.NumElements, b.cfg.FailAtElement)
+	}
+	if b.cfg.ErrorRate < 0 || b.cfg.ErrorRate > 1 {
+		return fmt.Errorf("SourceConfig.ErrorRate must be a floating point number from 0 and 1. Got: %v", b.cfg.ErrorRate)
+	}
+	if b.cfg.PanicRate < 0 || b.cfg.PanicRate > 1 {
+		return fmt.Errorf("SourceConfig.PanicRate must be a floating point number from 0 and 1. Got: %v", b.cfg.PanicRate)
+	}
+	if b.cfg.ErrorRate+b.cfg.PanicRate > 1 {
+		return fmt.Errorf("SourceConfig.ErrorRate plus PanicRate cannot exceed 1. Got: %v + %v", b.cfg.ErrorRate, b.cfg.PanicRate)
+	}
+	switch b.cfg.FailureScope {
+	case FailureScopeElement, FailureScopeBundle:
+	default:
+		return fmt.Errorf("SourceConfig.FailureScope must be %q or %q. Got: %q", FailureScopeElement, FailureScopeBundle, b.cfg.FailureScope)
+	}
+	if b.cfg.SideOutputFraction < 0 || b.cfg.SideOutputFraction > 1 {
+		return fmt.Errorf("SourceConfig.SideOutputFraction must be a floating point number from 0 and 1. Got: %v", b.cfg.SideOutputFraction)
+	}
+	if b.cfg.DuplicateFraction < 0 || b.cfg.DuplicateFraction > 1 {
+		return fmt.Errorf("SourceConfig.DuplicateFraction must be a floating point number from 0 and 1. Got: %v", b.cfg.DuplicateFraction)
+	}
+	switch b.cfg.KeyDistribution {
+	case KeyDistributionUniform:
+	case KeyDistributionZipf, KeyDistributionNormal:
+		if b.cfg.NumKeys < 1 {
+			return fmt.Errorf("SourceConfig.NumKeys must be >= 1 when KeyDistribution is %q. Got: %v", b.cfg.KeyDistribution, b.cfg.NumKeys)
+		}
+		if b.cfg.KeyMode != KeyModeRandom {
+			return fmt.Errorf("SourceConfig.KeyMode must be %q when KeyDistribution is %q. Got: %q", KeyModeRandom, b.cfg.KeyDistribution, b.cfg.KeyMode)
+		}
+		if b.cfg.HotKeyFraction > 0 {
+			return fmt.Errorf("SourceConfig.HotKeyFraction must be 0 when KeyDistribution is %q. Got: %v", b.cfg.KeyDistribution, b.cfg.HotKeyFraction)
+		}
+		if size := minKeySize(b.cfg); size < 8 && b.cfg.NumKeys > 1<<(8*size) {
+			return fmt.Errorf("SourceConfig.KeySize of %v is too small to hold %v distinct keys", size, b.cfg.NumKeys)
+		}
+		if b.cfg.KeyDistribution == KeyDistributionZipf && b.cfg.ZipfExponent <= 1 {
+			return fmt.Errorf("SourceConfig.ZipfExponent must be > 1. Got: %v", b.cfg.ZipfExponent)
+		}
+		if b.cfg.KeyDistribution == KeyDistributionNormal && b.cfg.NormalStdDev <= 0 {
+			return fmt.Errorf("SourceConfig.NormalStdDev must be > 0. Got: %v", b.cfg.NormalStdDev)
+		}
+	default:
+		return fmt.Errorf("SourceConfig.KeyDistribution must be one of %q, %q, or %q. Got: %q",
+			KeyDistributionUniform, KeyDistributionZipf, KeyDistributionNormal, b.cfg.KeyDistribution)
+	}
+	switch b.cfg.KeyMode {
+	case KeyModeRandom:
+	case KeyModeSequential:
+		if size := minKeySize(b.cfg); size < 8 && b.cfg.NumElements > 1<<(8*size) {
+			return fmt.Errorf("SourceConfig.KeySize of %v is too small to hold %v sequential keys", size, b.cfg.NumElements)
+		}
+		if b.cfg.HotKeyFraction > 0 {
+			return fmt.Errorf("SourceConfig.HotKeyFraction must be 0 when KeyMode is %q. Got: %v", b.cfg.KeyMode, b.cfg.HotKeyFraction)
+		}
+		if b.cfg.KeySizeDistribution != SizeDistributionFixed {
+			return fmt.Errorf("SourceConfig.KeySizeDistribution must be %q when KeyMode is %q. Got: %q", SizeDistributionFixed, b.cfg.KeyMode, b.cfg.KeySizeDistribution)
+		}
+	default:
+		return fmt.Errorf("SourceConfig.KeyMode must be one of %q or %q. Got: %q", KeyModeRandom, KeyModeSequential, b.cfg.KeyMode)
+	}
+	return nil
+}
+
+// BuildFromJSON constructs the SourceConfig by populating it with the parsed
+// JSON. Fields missing from the JSON keep their current values, and fields set
+// to zero values that are never valid are defaulted, as described in
+// BuildFromReader. Panics if there is an error in the syntax of the JSON, if
+// the input contains unknown object keys, or if the resulting config is
+// invalid. To receive an error instead, use BuildFromReader.
+//
+// An example of valid JSON object:
+// {
+// 	 "num_records": 5,
+// 	 "key_size": 5,
+// 	 "value_size": 5,
+//	 "num_hot_keys": 5,
+// }
+func (b *SourceConfigBuilder) BuildFromJSON(jsonData []byte) SourceConfig {
+	cfg, err := b.BuildFromReader(bytes.NewReader(jsonData))
+	if err != nil {
+		panic(fmt.Sprintf("Could not unmarshal SourceConfig: %v", err))
+	}
+	return cfg
+}
+
+// BuildFromReader constructs the SourceConfig by populating it with JSON
+// streamed from r, in the same format accepted by BuildFromJSON. Unlike
+// BuildFromJSON, it returns an error rather than panicking, and the resulting
+// config is validated in the same way as Build. If the JSON is malformed, the
+// error includes the byte offset at which decoding failed.
+//
+// Configs written for other SDKs often set fields to zero values to mean
+// "unset". Fields whose zero value is never valid, such as initial_splits,
+// key_size and the enum fields, are defaulted to their values in
+// DefaultSourceConfig when the JSON sets them to zero. Fields without a safe
+// default, such as num_records, are reported as errors instead.
+func (b *SourceConfigBuilder) BuildFromReader(r io.Reader) (SourceConfig, error) {
+	decoder := json.NewDecoder(r)
+	decoder.DisallowUnknownFields()
+
+	if err := decoder.Decode(&b.cfg); err != nil {
+		// InputOffset is the start of the value being decoded, and syntax errors
+		// report their offset relative to it.
+		offset := decoder.InputOffset()
+		var syntaxErr *json.SyntaxError
+		if errors.As(err, &syntaxErr) {
+			offset += syntaxErr.Offset
+		}
+		return SourceConfig{}, fmt.Errorf("could not decode SourceConfig at byte offset %v: %v", offset, err)
+	}
+	b.defaultZeroFields()
+	if err := b.validate(); err != nil {
+		return SourceConfig{}, err
+	}
+	return b.cfg, nil
+}
+
+// defaultZeroFields sets the fields of the SourceConfig being initialized that
+// have zero values that are never valid to their values in
+// DefaultSourceConfig. See BuildFromReader.
+func (b *SourceConfigBuilder) defaultZeroFields() {
+	def := DefaultSourceConfig().cfg
+	cfg := &b.cfg
+	if cfg.InitialSplits == 0 {
+		cfg.InitialSplits = def.InitialSplits
+	}
+	if cfg.KeySize == 0 {
+		cfg.KeySize = def.KeySize
+	}
+	if cfg.ValueSize == 0 {
+		cfg.ValueSize = def.ValueSize
+	}
+	if cfg.BatchSize == 0 {
+		cfg.BatchSize = def.BatchSize
+	}
+	if cfg.ZipfExponent == 0 {
+		cfg.ZipfExponent = def.ZipfExponent
+	}
+	if cfg.NormalStdDev == 0 {
+		cfg.NormalStdDev = def.NormalStdDev
+	}
+	if cfg.KeyMode == "" {
+		cfg.KeyMode = def.KeyMode
+	}
+	if cfg.KeyDistribution == "" {
+		cfg.KeyDistribution = def.KeyDistribution
+	}
+	if cfg.KeySizeDistribution == "" {
+		cfg.KeySizeDistribution = def.KeySizeDistribution
+	}
+	if cfg.ValueSizeDistribution == "" {
+		cfg.ValueSizeDistribution = def.ValueSizeDistribution
+	}
+	if cfg.ValueMode == "" {
+		cfg.ValueMode = def.ValueMode
+	}
+	if cfg.SleepDistribution == "" {
+		cfg.SleepDistribution = def.SleepDistribution
+	}
+	if cfg.OutputFormat == "" {
+		cfg.OutputFormat = def.OutputFormat
+	}
+	if cfg.TimestampMode == "" {
+		cfg.TimestampMode = def.TimestampMode
+	}
+	if cfg.FailureScope == "" {
+		cfg.FailureScope = def.FailureScope
+	}
+}
+
+// SourceConfig is a struct containing all the configuration options for a
+// synthetic source. It should be created via a SourceConfigBuilder, not by
+// directly initializing it (the fields are public to allow encoding).
+type SourceConfig struct {
+	NumElements             int64             `json:"num_records" beam:"num_records"`
+	Seed                    int64             `json:"seed" beam:"seed"`
+	InitialSplits           int64             `json:"initial_splits" beam:"initial_splits"`
+	SplitSkew               float64           `json:"split_skew" beam:"split_skew"`
+	KeySize                 int64             `json:"key_size" beam:"key_size"`
+	ValueSize               int64             `json:"value_size" beam:"value_size"`
+	NumHotKeys              int64             `json:"num_hot_keys" beam:"num_hot_keys"`
+	HotKeyFraction          float64           `json:"hot_key_fraction" beam:"hot_key_fraction"`
+	HotKeyRotationInterval  time.Duration     `json:"hot_key_rotation_interval" beam:"hot_key_rotation_interval"`
+	KeyMode                 KeyMode           `json:"key_mode" beam:"key_mode"`
+	KeyDistribution         KeyDistribution   `json:"key_distribution" beam:"key_distribution"`
+	NumKeys                 int64             `json:"num_keys" beam:"num_keys"`
+	ZipfExponent            float64           `json:"zipf_exponent" beam:"zipf_exponent"`
+	NormalStdDev            float64           `json:"normal_std_dev" beam:"normal_std_dev"`
+	KeySizeDistribution     SizeDistribution  `json:"key_size_distribution" beam:"key_size_distribution"`
+	MinKeySize              int64             `json:"min_key_size" beam:"min_key_size"`
+	MaxKeySize              int64             `json:"max_key_size" beam:"max_key_size"`
+	KeySizeStdDev           float64           `json:"key_size_std_dev" beam:"key_size_std_dev"`
+	ValueSizeDistribution   SizeDistribution  `json:"value_size_distribution" beam:"value_size_distribution"`
+	MinValueSize            int64             `json:"min_value_size" beam:"min_value_size"`
+	MaxValueSize            int64             `json:"max_value_size" beam:"max_value_size"`
+	ValueSizeStdDev         float64           `json:"value_size_std_dev" beam:"value_size_std_dev"`
+	ValueMode               ValueMode         `json:"value_mode" beam:"value_mode"`
+	ValueCompressibility    float64           `json:"value_compressibility" beam:"value_compressibility"`
+	StartupDelay            time.Duration     `json:"startup_delay" beam:"startup_delay"`
+	InitialDelay            time.Duration     `json:"initial_delay" beam:"initial_delay"`
+	MaxBundleStartDelay     time.Duration     `json:"max_bundle_start_delay" beam:"max_bundle_start_delay"`
+	SleepPerElement         time.Duration     `json:"sleep_per_element" beam:"sleep_per_element"`
+	SleepDistribution       DelayDistribution `json:"sleep_distribution" beam:"sleep_distribution"`
+	SleepStdDev             time.Duration     `json:"sleep_std_dev" beam:"sleep_std_dev"`
+	CPUBurnPerElement       time.Duration     `json:"cpu_burn_per_element" beam:"cpu_burn_per_element"`
+	MaxRate                 float64           `json:"max_rate" beam:"max_rate"`
+	RetainedBytesPerElement int64             `json:"retained_bytes_per_element" beam:"retained_bytes_per_element"`
+	CheckpointEvery         int64             `json:"checkpoint_every" beam:"checkpoint_every"`
+	CheckpointInterval      time.Duration     `json:"checkpoint_interval" beam:"checkpoint_interval"`
+	ResumeDelay             time.Duration     `json:"resume_delay" beam:"resume_delay"`
+	OutputFormat            OutputFormat      `json:"output_format" beam:"output_format"`
+	BatchSize               int64             `json:"batch_size" beam:"batch_size"`
+	SideOutputFraction      float64           `json:"side_output_fraction" beam:"side_output_fraction"`
+	DuplicateFraction       float64           `json:"duplicate_fraction" beam:"duplicate_fraction"`
+	TimestampMode           TimestampMode     `json:"timestamp_mode" beam:"timestamp_mode"`
+	StartTimestamp          int64             `json:"start_timestamp" beam:"start_timestamp"`
+	TimestampInterval       time.Duration     `json:"timestamp_interval" beam:"timestamp_interval"`
+	TimestampJitter         time.Duration     `json:"timestamp_jitter" beam:"timestamp_jitter"`
+	WatermarkLag            time.Duration     `json:"watermark_lag" beam:"watermark_lag"`
+	LateDataFraction        float64           `json:"late_data_fraction" beam:"late_data_fraction"`
+	LatenessMin             time.Duration     `json:"lateness_min" beam:"lateness_min"`
+	LatenessMax             time.Duration     `json:"lateness_max" beam:"lateness_max"`
+	BundleFinalization      bool              `json:"bundle_finalization" beam:"bundle_finalization"`
+	FailAtElement           int64             `json:"fail_at_element" beam:"fail_at_element"`
+	FailTimes               int64             `json:"fail_times" beam:"fail_times"`
+	ErrorRate               float64           `json:"error_rate" beam:"error_rate"`
+	PanicRate               float64           `json:"panic_rate" beam:"panic_rate"`
+	FailureScope            FailureScope      `json:"failure_scope" beam:"failure_scope"`
+}
+
+// OutputFormat is an enum of the types of elements a synthetic source can emit.
+type OutputFormat string
+
+const (
+	// OutputFormatKV emits each element as a KV<[]byte, []byte> of the
+	// generated key and value.
+	OutputFormatKV OutputFormat = "kv"
+	// OutputFormatBytes emits each element as a single []byte record of
+	// KeySize + ValueSize bytes, made of the generated key followed by the
+	// generated value.
+	OutputFormatBytes OutputFormat = "bytes"
+	// OutputFormatBatch emits elements in batches of BatchSize generated keys
+	// and values, as a []Element.
+	OutputFormatBatch OutputFormat = "batch"
+	// OutputFormatRow emits each element as a Row, which is encoded with a
+	// schema coder, in order to exercise schema-aware transforms.
+	OutputFormatRow OutputFormat = "row"
+)
+
+// Element is a single generated key and value, emitted in batches by synthetic
+// sources using OutputFormatBatch.
+type Element struct {
+	Key   []byte
+	Value []byte
+}
+
+// Row is a single generated element with a schema, emitted by synthetic sources
+// using OutputFormatRow.
+type Row struct {
+	// ID is the element's index within its SourceConfig.
+	ID int64 `beam:"id"`
+	// Timestamp is the element's event time, in milliseconds since the Unix
+	// epoch.
+	Timestamp int64 `beam:"timestamp"`
+	// Key is the generated key.
+	Key []byte `beam:"key"`
+	// Payload is the generated value.
+	Payload []byte `beam:"payload"`
+}
+
+// KeyMode is an enum of the ways a synthetic source can generate keys.
+type KeyMode string
+
+const (
+	// KeyModeRandom generates each key as random bytes, aside from hot keys.
+	KeyModeRandom KeyMode = "random"
+	// KeyModeSequential generates each key as the element's index within the
+	// SourceConfig, encoded as a big-endian integer of KeySize bytes. Keys are
+	// unique and sort in the same order as the elements were generated,
+	// regardless of how the source is split.
+	KeyModeSequential KeyMode = "sequential"
+)
+
+// TimestampMode is an enum of the ways a synthetic source can assign event
+// times to the elements it generates.
+type TimestampMode string
+
+const (
+	// TimestampModeNone keeps the event time of the SourceConfig element that
+	// generated each element.
+	TimestampModeNone TimestampMode = "none"
+	// TimestampModeMonotonic assigns element i the event time
+	// StartTimestamp + i * TimestampInterval.
+	TimestampModeMonotonic TimestampMode = "monotonic"
+	// TimestampModeJittered assigns event times as in TimestampModeMonotonic,
+	// each offset by a pseudo-random amount up to TimestampJitter in either
+	// direction.
+	TimestampModeJittered TimestampMode = "jittered"
+	// TimestampModeUniform assigns each element a pseudo-random event time drawn
+	// uniformly from NumElements * TimestampInterval after StartTimestamp.
+	TimestampModeUniform TimestampMode = "uniform"
+)
+
+// KeyDistribution is an enum of the distributions a synthetic source can use
+// to choose how often each key is emitted.
+type KeyDistribution string
+
+const (
+	// KeyDistributionUniform generates each key independently, so keys are
+	// effectively unique aside from hot keys.
+	KeyDistributionUniform KeyDistribution = "uniform"
+	// KeyDistributionZipf chooses each key from NumKeys keys following a Zipf
+	// distribution with exponent ZipfExponent, so a few keys are very common
+	// and most keys are rare.
+	KeyDistributionZipf KeyDistribution = "zipf"
+	// KeyDistributionNormal chooses each key from NumKeys keys following a
+	// normal distribution centered on the middle key, with a standard deviation
+	// of NormalStdDev * NumKeys keys.
+	KeyDistributionNormal KeyDistribution = "normal"
+)
+
+// DelayDistribution is an enum of the distributions a synthetic source can
+// draw per-element delays from.
+type DelayDistribution string
+
+const (
+	// DelayDistributionConstant uses the same delay for every element.
+	DelayDistributionConstant DelayDistribution = "constant"
+	// DelayDistributionUniform draws each delay uniformly from zero to twice
+	// the mean delay.
+	DelayDistributionUniform DelayDistribution = "uniform"
+	// DelayDistributionExponential draws each delay from an exponential
+	// distribution with the mean delay, so most delays are short and a few
+	// are much longer.
+	DelayDistributionExponential DelayDistribution = "exponential"
+	// DelayDistributionNormal draws each delay from a normal distribution
+	// around the mean delay with a configured standard deviation, clamped to
+	// zero.
+	DelayDistributionNormal DelayDistribution = "normal"
+)
+
+// SizeDistribution is an enum of the ways a synthetic source can choose the
+// sizes of the data it generates.
+type SizeDistribution string
+
+const (
+	// SizeDistributionFixed uses the same fixed size for every element.
+	SizeDistributionFixed SizeDistribution = "fixed"
+	// SizeDistributionUniform samples each size uniformly from [min, max].
+	SizeDistributionUniform SizeDistribution = "uniform"
+	// SizeDistributionBimodal samples each size as either min or max, with
+	// equal probability.
+	SizeDistributionBimodal SizeDistribution = "bimodal"
+	// SizeDistributionNormal samples each size from a normal distribution
+	// centered between min and max with a configured standard deviation,
+	// clamped to [min, max].
+	SizeDistributionNormal SizeDistribution = "normal"
+	// SizeDistributionPareto samples each size from a heavy-tailed Pareto
+	// distribution starting at min, clamped to max. Most sizes are close to
+	// min, while a few are much larger, approximating an 80/20 split of bytes.
+	SizeDistributionPareto SizeDistribution = "pareto"
+)
+
+// paretoShape is the shape parameter of SizeDistributionPareto, chosen so that
+// roughly 20% of the sizes account for 80% of the bytes.
+const paretoShape = 1.16
+
+// sample returns a size drawn from the distribution using generator. Fixed
+// distributions return fixed without consuming any randomness from generator.
+func (d SizeDistribution) sample(generator *rand.Rand, fixed, min, max int64, stdDev float64) int64 {
+	switch d {
+	case SizeDistributionUniform:
+		return min + generator.Int63n(max-min+1)
+	case SizeDistributionBimodal:
+		if generator.Int63n(2) == 0 {
+			return min
+		}
+		return max
+	case SizeDistributionNormal:
+		mean := float64(min+max) / 2
+		return clampSize(math.Round(mean+generator.NormFloat64()*stdDev), min, max)
+	case SizeDistributionPareto:
+		// Inverse transform sampling, using 1-u to avoid dividing by zero.
+		u := 1 - generator.Float64()
+		return clampSize(math.Floor(float64(min)*math.Pow(u, -1/paretoShape)), min, max)
+	default:
+		return fixed
+	}
+}
+
+// clampSize converts a sampled size to an integer in the range [min, max].
+func clampSize(size float64, min, max int64) int64 {
+	switch {
+	case size < float64(min):
+		return min
+	case size > float64(max):
+		return max
+	default:
+		return int64(size)
+	}
+}
+
+// validateSizeDistribution returns an error if a size distribution's
+// parameters are invalid. The field names in errors are prefixed with what,
+// such as "Key" for KeySizeDistribution.
+func validateSizeDistribution(what string, d SizeDistribution, min, max int64, stdDev float64) error {
+	switch d {
+	case SizeDistributionFixed:
+		return nil
+	case SizeDistributionUniform, SizeDistributionBimodal, SizeDistributionNormal, SizeDistributionPareto:
+	default:
+		return fmt.Errorf("SourceConfig.%vSizeDistribution must be one of %q, %q, %q, %q, or %q. Got: %q", what,
+			SizeDistributionFixed, SizeDistributionUniform, SizeDistributionBimodal, SizeDistributionNormal, SizeDistributionPareto, d)
+	}
+	if min <= 0 {
+		return fmt.Errorf("SourceConfig.Min%vSize must be >= 1. Got: %v", what, min)
+	}
+	if min > max {
+		return fmt.Errorf("SourceConfig.Min%vSize must be <= Max%vSize. Got: %v > %v", what, what, min, max)
+	}
+	if d == SizeDistributionNormal && !(stdDev > 0) {
+		return fmt.Errorf("SourceConfig.%vSizeStdDev must be > 0 when %vSizeDistribution is %q. Got: %v", what, what, d, stdDev)
+	}
+	return nil
 }
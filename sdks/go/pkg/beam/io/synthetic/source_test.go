@@ -16,9 +16,25 @@
 package synthetic
 
 import (
+	"bytes"
+	"context"
 	"encoding/hex"
+	"errors"
 	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/apache/beam/sdks/v2/go/pkg/beam"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/core/graph"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/core/graph/mtime"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/core/metrics"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/io/rtrackers/offsetrange"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/testing/passert"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/testing/ptest"
 )
 
 // TestSourceConfig_NumElements tests that setting the number of produced
@@ -140,6 +156,135 @@ func TestSourceConfig_InitialSplits(t *testing.T) {
 	})
 }
 
+// TestSourceFn_SplitRestrictionRemainder tests that when the number of
+// elements isn't divisible by the number of splits, the remainder is given to
+// the first splits, one extra element each.
+func TestSourceFn_SplitRestrictionRemainder(t *testing.T) {
+	tests := []struct {
+		elms   int
+		splits int
+		want   []int64
+	}{
+		{elms: 10, splits: 4, want: []int64{3, 3, 2, 2}},
+		{elms: 11, splits: 4, want: []int64{3, 3, 3, 2}},
+		{elms: 7, splits: 3, want: []int64{3, 2, 2}},
+		{elms: 9, splits: 3, want: []int64{3, 3, 3}},
+		{elms: 101, splits: 10, want: []int64{11, 10, 10, 10, 10, 10, 10, 10, 10, 10}},
+		{elms: 3, splits: 5, want: []int64{1, 1, 1}},
+		{elms: 5, splits: 1, want: []int64{5}},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(fmt.Sprintf("(elm = %v, splits = %v)", test.elms, test.splits), func(t *testing.T) {
+			dfn := sourceFn{}
+			cfg := DefaultSourceConfig().NumElements(test.elms).InitialSplits(test.splits).Build()
+
+			splits := dfn.SplitRestriction(cfg, dfn.CreateInitialRestriction(cfg))
+			var got []int64
+			start := int64(0)
+			for _, split := range splits {
+				if split.Start != start {
+					t.Fatalf("SplitRestriction output non-contiguous splits: %v", splits)
+				}
+				got = append(got, split.End-split.Start)
+				start = split.End
+			}
+			if start != int64(test.elms) {
+				t.Errorf("SplitRestriction splits don't cover all elements: %v", splits)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("SplitRestriction output wrong split sizes: got: %v, want: %v", got, test.want)
+			}
+		})
+	}
+}
+
+// TestSourceFn_SplitRestrictionSkew tests that a SplitSkew puts the configured
+// fraction of elements in the first split and splits the rest evenly.
+func TestSourceFn_SplitRestrictionSkew(t *testing.T) {
+	tests := []struct {
+		elms   int
+		splits int
+		skew   float64
+		want   []int64
+	}{
+		{elms: 100, splits: 5, skew: 0.9, want: []int64{90, 3, 3, 2, 2}},
+		{elms: 100, splits: 2, skew: 0.5, want: []int64{50, 50}},
+		{elms: 10, splits: 4, skew: 0.99, want: []int64{7, 1, 1, 1}},
+		{elms: 10, splits: 3, skew: 0.01, want: []int64{1, 5, 4}},
+		{elms: 10, splits: 1, skew: 0.9, want: []int64{10}},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(fmt.Sprintf("(elm = %v, splits = %v, skew = %v)", test.elms, test.splits, test.skew), func(t *testing.T) {
+			dfn := sourceFn{}
+			cfg := DefaultSourceConfig().NumElements(test.elms).InitialSplits(test.splits).SplitSkew(test.skew).Build()
+
+			splits := dfn.SplitRestriction(cfg, dfn.CreateInitialRestriction(cfg))
+			var got []int64
+			start := int64(0)
+			for _, split := range splits {
+				if split.Start != start {
+					t.Fatalf("SplitRestriction output non-contiguous splits: %v", splits)
+				}
+				got = append(got, split.End-split.Start)
+				start = split.End
+			}
+			if start != int64(test.elms) {
+				t.Errorf("SplitRestriction splits don't cover all elements: %v", splits)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("SplitRestriction output wrong split sizes: got: %v, want: %v", got, test.want)
+			}
+		})
+	}
+}
+
+// TestSourceConfigBuilder_Validate tests that Validate warns about each
+// suspicious but valid setting, and doesn't warn about a clean config.
+func TestSourceConfigBuilder_Validate(t *testing.T) {
+	tests := []struct {
+		name string
+		b    *SourceConfigBuilder
+		want []string
+	}{
+		{
+			name: "Clean",
+			b:    DefaultSourceConfig().NumElements(100).InitialSplits(10).NumHotKeys(5).HotKeyFraction(0.5),
+			want: nil,
+		},
+		{
+			name: "InitialSplitsExceedNumElements",
+			b:    DefaultSourceConfig().NumElements(5).InitialSplits(10),
+			want: []string{"InitialSplits (10) exceeds NumElements (5), so only 5 splits will be created"},
+		},
+		{
+			name: "NumHotKeysExceedNumElements",
+			b:    DefaultSourceConfig().NumElements(5).NumHotKeys(10).HotKeyFraction(0.5),
+			want: []string{"NumHotKeys (10) exceeds NumElements (5), so some hot keys will never be emitted"},
+		},
+		{
+			name: "UnusedHotKeys",
+			b:    DefaultSourceConfig().NumElements(5).NumHotKeys(2),
+			want: []string{"NumHotKeys is 2 but HotKeyFraction is 0, so no hot keys will be emitted"},
+		},
+		{
+			name: "KeySizeTooSmall",
+			b:    DefaultSourceConfig().NumElements(1000).KeySize(1),
+			want: []string{"KeySize of 1 bytes can hold fewer distinct values than NumElements (1000), so random keys will collide"},
+		},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			got := test.b.Validate()
+			if len(got) != len(test.want) || (len(got) > 0 && !reflect.DeepEqual(got, test.want)) {
+				t.Errorf("Validate returned wrong warnings: got: %q, want: %q", got, test.want)
+			}
+		})
+	}
+}
+
 // TestSourceConfig_BuildFromJSON tests correctness of building the
 // SourceConfig from JSON data.
 func TestSourceConfig_BuildFromJSON(t *testing.T) {
@@ -163,6 +308,59 @@ func TestSourceConfig_BuildFromJSON(t *testing.T) {
 	}
 }
 
+// TestSourceConfig_BuildFromReader tests building the SourceConfig from JSON
+// streamed from a reader, including the errors reported for bad input.
+func TestSourceConfig_BuildFromReader(t *testing.T) {
+	t.Run("Valid", func(t *testing.T) {
+		r := strings.NewReader("{\"num_records\": 5, \"key_size\": 2, \"value_size\": 3}")
+		got, err := DefaultSourceConfig().BuildFromReader(r)
+		if err != nil {
+			t.Fatalf("BuildFromReader failed: %v", err)
+		}
+		if want := DefaultSourceConfig().NumElements(5).KeySize(2).ValueSize(3).Build(); got != want {
+			t.Errorf("Invalid SourceConfig: got: %#v, want: %#v", got, want)
+		}
+	})
+	t.Run("Malformed", func(t *testing.T) {
+		r := strings.NewReader("{\"num_records\": 5, \"key_size\": }")
+		_, err := DefaultSourceConfig().BuildFromReader(r)
+		if err == nil {
+			t.Fatalf("BuildFromReader did not fail for malformed JSON")
+		}
+		if want := "byte offset 32"; !strings.Contains(err.Error(), want) {
+			t.Errorf("BuildFromReader error did not report the offset of the syntax error: got: %v, want it to contain %q", err, want)
+		}
+	})
+	t.Run("Truncated", func(t *testing.T) {
+		r := strings.NewReader("{\"num_records\": 5")
+		if _, err := DefaultSourceConfig().BuildFromReader(r); err == nil || !strings.Contains(err.Error(), "byte offset") {
+			t.Errorf("BuildFromReader did not report the offset for truncated JSON: got: %v", err)
+		}
+	})
+	t.Run("UnknownField", func(t *testing.T) {
+		r := strings.NewReader("{\"num_records\": 5, \"bogus\": 1}")
+		if _, err := DefaultSourceConfig().BuildFromReader(r); err == nil || !strings.Contains(err.Error(), "bogus") {
+			t.Errorf("BuildFromReader did not reject unknown field: got: %v", err)
+		}
+	})
+	t.Run("Invalid", func(t *testing.T) {
+		r := strings.NewReader("{\"num_records\": 0}")
+		if _, err := DefaultSourceConfig().BuildFromReader(r); err == nil || !strings.Contains(err.Error(), "NumElements") {
+			t.Errorf("BuildFromReader did not validate the config: got: %v", err)
+		}
+	})
+	t.Run("ZeroDefaults", func(t *testing.T) {
+		r := strings.NewReader("{\"num_records\": 5, \"initial_splits\": 0, \"key_size\": 0, \"key_mode\": \"\", \"output_format\": \"\"}")
+		got, err := (&SourceConfigBuilder{}).BuildFromReader(r)
+		if err != nil {
+			t.Fatalf("BuildFromReader failed: %v", err)
+		}
+		if want := DefaultSourceConfig().NumElements(5).TimestampInterval(0).Build(); got != want {
+			t.Errorf("BuildFromReader did not default zero fields: got: %#v, want: %#v", got, want)
+		}
+	})
+}
+
 // TestSourceConfig_NumHotKeys tests that setting the number of hot keys
 // for a synthetic source works correctly.
 func TestSourceConfigBuilder_NumHotKeys(t *testing.T) {
@@ -209,6 +407,1556 @@ func TestSourceConfigBuilder_NumHotKeys(t *testing.T) {
 	}
 }
 
+// TestSourceFn_Cancellation tests that cancelling the context partway through
+// processing causes ProcessElement to return the cancellation error without
+// emitting every element in the restriction.
+func TestSourceFn_Cancellation(t *testing.T) {
+	const elms, cancelAfter = 1000, 10
+	dfn := sourceFn{}
+	cfg := DefaultSourceConfig().NumElements(elms).Build()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var count int
+	emitFn := func(_ beam.EventTime, key []byte, val []byte) {
+		count++
+		if count == cancelAfter {
+			cancel()
+		}
+	}
+
+	rt := dfn.CreateTracker(dfn.CreateInitialRestriction(cfg))
+	err := dfn.ProcessElement(ctx, mtime.ZeroTimestamp, rt, cfg, emitFn)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("SourceFn returned wrong error after cancellation: got: %v, want: %v",
+			err, context.Canceled)
+	}
+	if count != cancelAfter {
+		t.Errorf("SourceFn emitted wrong number of outputs after cancellation: got: %v, want: %v",
+			count, cancelAfter)
+	}
+}
+
+// TestSourceConfig_HotKeyFraction tests that elements selected as hot are
+// mapped to exactly NumHotKeys distinct keys, and that the fraction of hot
+// elements matches HotKeyFraction.
+func TestSourceConfig_HotKeyFraction(t *testing.T) {
+	tests := []struct {
+		elms     int
+		hotKeys  int
+		fraction float64
+	}{
+		{elms: 10000, hotKeys: 1, fraction: 0.1},
+		{elms: 10000, hotKeys: 7, fraction: 0.3},
+		{elms: 10000, hotKeys: 100, fraction: 0.75},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(fmt.Sprintf("(hotKeys = %v, fraction = %v)", test.hotKeys, test.fraction), func(t *testing.T) {
+			dfn := sourceFn{}
+			cfg := DefaultSourceConfig().
+				NumElements(test.elms).
+				InitialSplits(3).
+				NumHotKeys(test.hotKeys).
+				HotKeyFraction(test.fraction).
+				Build()
+
+			keys, _, err := simulateSourceFn(t, &dfn, cfg)
+			if err != nil {
+				t.Errorf("Failure processing sourceFn: %v", err)
+			}
+
+			m := make(map[string]int)
+			for _, key := range keys {
+				m[hex.EncodeToString(key)]++
+			}
+			// Random keys are effectively unique, so any repeated key is hot.
+			distinctHot, numHot := 0, 0
+			for _, count := range m {
+				if count > 1 {
+					distinctHot++
+					numHot += count
+				}
+			}
+			if distinctHot != test.hotKeys {
+				t.Errorf("SourceFn emitted wrong number of distinct hot keys: got: %v, want: %v",
+					distinctHot, test.hotKeys)
+			}
+			const tolerance = 0.05
+			if got := float64(numHot) / float64(test.elms); got < test.fraction-tolerance || got > test.fraction+tolerance {
+				t.Errorf("SourceFn emitted wrong fraction of hot keys: got: %v, want: %v +/- %v",
+					got, test.fraction, tolerance)
+			}
+		})
+	}
+}
+
+// TestSourceConfig_KeySizeDistribution tests that the lengths of emitted keys
+// follow the configured key size distribution, and that hot keys still
+// collapse to NumHotKeys distinct keys when key sizes vary.
+func TestSourceConfig_KeySizeDistribution(t *testing.T) {
+	const elms = 5000
+	tests := []struct {
+		dist     SizeDistribution
+		min, max int
+		want     map[int]float64 // Expected fraction of keys of each length.
+	}{
+		{dist: SizeDistributionFixed, want: map[int]float64{8: 1}},
+		{dist: SizeDistributionUniform, min: 2, max: 5, want: map[int]float64{2: 0.25, 3: 0.25, 4: 0.25, 5: 0.25}},
+		{dist: SizeDistributionBimodal, min: 2, max: 10, want: map[int]float64{2: 0.5, 10: 0.5}},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(fmt.Sprintf("(dist = %v)", test.dist), func(t *testing.T) {
+			cfg := DefaultSourceConfig().
+				NumElements(elms).
+				InitialSplits(3).
+				KeySizeDistribution(test.dist, test.min, test.max).
+				Build()
+			keys, _, err := simulateSourceFn(t, &sourceFn{}, cfg)
+			if err != nil {
+				t.Fatalf("Failure processing sourceFn: %v", err)
+			}
+
+			counts := make(map[int]int)
+			for _, key := range keys {
+				counts[len(key)]++
+			}
+			for size := range counts {
+				if _, ok := test.want[size]; !ok {
+					t.Errorf("SourceFn emitted key of unexpected length %v, want lengths %v", size, test.want)
+				}
+			}
+			const tolerance = 0.05
+			for size, want := range test.want {
+				if got := float64(counts[size]) / elms; got < want-tolerance || got > want+tolerance {
+					t.Errorf("SourceFn emitted wrong fraction of keys of length %v: got: %v, want: %v +/- %v",
+						size, got, want, tolerance)
+				}
+			}
+		})
+	}
+
+	t.Run("HotKeys", func(t *testing.T) {
+		const hotKeys = 7
+		cfg := DefaultSourceConfig().
+			NumElements(elms).
+			NumHotKeys(hotKeys).
+			HotKeyFraction(1).
+			KeySizeDistribution(SizeDistributionUniform, 1, 16).
+			Build()
+		keys, _, err := simulateSourceFn(t, &sourceFn{}, cfg)
+		if err != nil {
+			t.Fatalf("Failure processing sourceFn: %v", err)
+		}
+		m := make(map[string]bool)
+		for _, key := range keys {
+			m[hex.EncodeToString(key)] = true
+		}
+		if got := len(m); got != hotKeys {
+			t.Errorf("SourceFn emitted wrong number of distinct hot keys: got: %v, want: %v", got, hotKeys)
+		}
+	})
+}
+
+// TestSourceConfig_KeySizeDistributionValidation tests that Build rejects
+// invalid key size distributions.
+func TestSourceConfig_KeySizeDistributionValidation(t *testing.T) {
+	tests := []struct {
+		name string
+		b    *SourceConfigBuilder
+	}{
+		{name: "UnknownDistribution", b: DefaultSourceConfig().KeySizeDistribution("zipf", 1, 2)},
+		{name: "NonPositiveMin", b: DefaultSourceConfig().KeySizeDistribution(SizeDistributionUniform, 0, 2)},
+		{name: "MinAboveMax", b: DefaultSourceConfig().KeySizeDistribution(SizeDistributionBimodal, 4, 2)},
+		{name: "MinTooSmallForHotKeys", b: DefaultSourceConfig().NumHotKeys(257).HotKeyFraction(0.5).KeySizeDistribution(SizeDistributionUniform, 1, 8)},
+		{name: "NormalWithoutStdDev", b: DefaultSourceConfig().KeySizeDistribution(SizeDistributionNormal, 1, 8)},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			defer func() {
+				if r := recover(); r == nil {
+					t.Errorf("Build did not panic for invalid config: %#v", test.b.cfg)
+				}
+			}()
+			test.b.Build()
+		})
+	}
+}
+
+// TestSourceConfig_ValueSizeDistribution tests that value sizes are sampled
+// from the configured distribution.
+func TestSourceConfig_ValueSizeDistribution(t *testing.T) {
+	const elms = 10000
+	simulate := func(t *testing.T, b *SourceConfigBuilder) []int {
+		t.Helper()
+		_, vals, err := simulateSourceFn(t, &sourceFn{}, b.NumElements(elms).InitialSplits(3).Build())
+		if err != nil {
+			t.Fatalf("Failure processing sourceFn: %v", err)
+		}
+		sizes := make([]int, len(vals))
+		for i, val := range vals {
+			sizes[i] = len(val)
+		}
+		sort.Ints(sizes)
+		return sizes
+	}
+
+	t.Run("Uniform", func(t *testing.T) {
+		sizes := simulate(t, DefaultSourceConfig().ValueSizeDistribution(SizeDistributionUniform, 5, 10))
+		if min, max := sizes[0], sizes[len(sizes)-1]; min != 5 || max != 10 {
+			t.Errorf("SourceFn emitted values with wrong size range: got: [%v, %v], want: [5, 10]", min, max)
+		}
+	})
+
+	t.Run("Normal", func(t *testing.T) {
+		const min, max, stdDev = 100, 300, 20.0
+		sizes := simulate(t, DefaultSourceConfig().ValueSizeDistribution(SizeDistributionNormal, min, max).ValueSizeStdDev(stdDev))
+		var sum, sumSq float64
+		for _, size := range sizes {
+			sum += float64(size)
+			sumSq += float64(size) * float64(size)
+		}
+		mean := sum / elms
+		gotStdDev := math.Sqrt(sumSq/elms - mean*mean)
+		if want := float64(min+max) / 2; math.Abs(mean-want) > 1 {
+			t.Errorf("SourceFn emitted values with wrong mean size: got: %v, want: %v +/- 1", mean, want)
+		}
+		if math.Abs(gotStdDev-stdDev) > 1 {
+			t.Errorf("SourceFn emitted values with wrong size standard deviation: got: %v, want: %v +/- 1", gotStdDev, stdDev)
+		}
+	})
+
+	t.Run("Pareto", func(t *testing.T) {
+		const min, max = 10, 10000
+		sizes := simulate(t, DefaultSourceConfig().ValueSizeDistribution(SizeDistributionPareto, min, max))
+		if got := sizes[0]; got != min {
+			t.Errorf("SourceFn emitted wrong smallest value size: got: %v, want: %v", got, min)
+		}
+		if got := sizes[len(sizes)-1]; got > max {
+			t.Errorf("SourceFn emitted value larger than max: got: %v, want at most: %v", got, max)
+		}
+		// Most values are small, with a long tail of much larger values.
+		if median := sizes[len(sizes)/2]; median > 3*min {
+			t.Errorf("SourceFn emitted values with too large a median size: got: %v, want at most: %v", median, 3*min)
+		}
+		if got := sizes[len(sizes)*995/1000]; got < 50*min {
+			t.Errorf("SourceFn emitted values with too short a tail: got 99.5th percentile: %v, want at least: %v", got, 50*min)
+		}
+	})
+
+	t.Run("Deterministic", func(t *testing.T) {
+		b := func() *SourceConfigBuilder {
+			return DefaultSourceConfig().ValueSizeDistribution(SizeDistributionPareto, 1, 100)
+		}
+		if got, want := simulate(t, b().InitialSplits(7)), simulate(t, b()); !reflect.DeepEqual(got, want) {
+			t.Errorf("SourceFn emitted different value sizes for the same config")
+		}
+	})
+}
+
+// TestSourceConfig_ValueSizeDistributionValidation tests that Build rejects
+// invalid value size distributions.
+func TestSourceConfig_ValueSizeDistributionValidation(t *testing.T) {
+	tests := []struct {
+		name string
+		b    *SourceConfigBuilder
+	}{
+		{name: "UnknownDistribution", b: DefaultSourceConfig().ValueSizeDistribution("zipf", 1, 2)},
+		{name: "NonPositiveMin", b: DefaultSourceConfig().ValueSizeDistribution(SizeDistributionPareto, 0, 2)},
+		{name: "MinAboveMax", b: DefaultSourceConfig().ValueSizeDistribution(SizeDistributionUniform, 4, 2)},
+		{name: "NormalWithoutStdDev", b: DefaultSourceConfig().ValueSizeDistribution(SizeDistributionNormal, 1, 8)},
+		{name: "MinTooSmallForVerifiable", b: DefaultSourceConfig().ValueMode(ValueModeVerifiable).ValueSize(64).ValueSizeDistribution(SizeDistributionUniform, 1, 64)},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			defer func() {
+				if r := recover(); r == nil {
+					t.Errorf("Build did not panic for invalid config: %#v", test.b.cfg)
+				}
+			}()
+			test.b.Build()
+		})
+	}
+}
+
+// TestSourceConfig_KeyDistribution tests that non-uniform key distributions
+// emit keys from NumKeys distinct keys with the expected skew.
+func TestSourceConfig_KeyDistribution(t *testing.T) {
+	const elms, numKeys = 10000, 100
+
+	// keyCounts returns the number of times each distinct key was emitted, from
+	// most to least common.
+	keyCounts := func(t *testing.T, cfg SourceConfig) []int {
+		t.Helper()
+		keys, _, err := simulateSourceFn(t, &sourceFn{}, cfg)
+		if err != nil {
+			t.Fatalf("Failure processing sourceFn: %v", err)
+		}
+		m := make(map[string]int)
+		for _, key := range keys {
+			m[hex.EncodeToString(key)]++
+		}
+		var counts []int
+		for _, count := range m {
+			counts = append(counts, count)
+		}
+		sort.Sort(sort.Reverse(sort.IntSlice(counts)))
+		if got := len(counts); got > numKeys {
+			t.Errorf("SourceFn emitted too many distinct keys: got: %v, want at most: %v", got, numKeys)
+		}
+		return counts
+	}
+
+	t.Run("Zipf", func(t *testing.T) {
+		cfg := DefaultSourceConfig().
+			NumElements(elms).
+			InitialSplits(3).
+			KeyDistribution(KeyDistributionZipf).
+			NumKeys(numKeys).
+			ZipfExponent(2).
+			Build()
+		counts := keyCounts(t, cfg)
+		// With an exponent of 2, the most common key is emitted 4 times as often
+		// as the second most common, and accounts for about 61% of elements.
+		const tolerance = 0.05
+		if got, want := float64(counts[0])/elms, 0.61; got < want-tolerance || got > want+tolerance {
+			t.Errorf("SourceFn emitted wrong fraction of the most common key: got: %v, want: %v +/- %v", got, want, tolerance)
+		}
+		if got := float64(counts[0]) / float64(counts[1]); got < 3 || got > 5 {
+			t.Errorf("SourceFn emitted wrong ratio of the two most common keys: got: %v, want: about 4", got)
+		}
+	})
+
+	t.Run("Normal", func(t *testing.T) {
+		cfg := DefaultSourceConfig().
+			NumElements(elms).
+			InitialSplits(3).
+			KeyDistribution(KeyDistributionNormal).
+			NumKeys(numKeys).
+			NormalStdDev(0.05).
+			Build()
+		counts := keyCounts(t, cfg)
+		// About 68% of elements fall within one standard deviation, which is the
+		// 10 most common keys.
+		var top int
+		for _, count := range counts[:10] {
+			top += count
+		}
+		const tolerance = 0.1
+		if got, want := float64(top)/elms, 0.68; got < want-tolerance || got > want+tolerance {
+			t.Errorf("SourceFn emitted wrong fraction of the 10 most common keys: got: %v, want: %v +/- %v", got, want, tolerance)
+		}
+	})
+}
+
+// TestSourceConfig_KeyDistributionValidation tests that Build rejects invalid
+// key distributions.
+func TestSourceConfig_KeyDistributionValidation(t *testing.T) {
+	tests := []struct {
+		name string
+		b    *SourceConfigBuilder
+	}{
+		{name: "UnknownDistribution", b: DefaultSourceConfig().KeyDistribution("pareto").NumKeys(10)},
+		{name: "NoKeys", b: DefaultSourceConfig().KeyDistribution(KeyDistributionZipf)},
+		{name: "ZipfExponentTooSmall", b: DefaultSourceConfig().KeyDistribution(KeyDistributionZipf).NumKeys(10).ZipfExponent(1)},
+		{name: "NonPositiveStdDev", b: DefaultSourceConfig().KeyDistribution(KeyDistributionNormal).NumKeys(10).NormalStdDev(0)},
+		{name: "HotKeys", b: DefaultSourceConfig().KeyDistribution(KeyDistributionZipf).NumKeys(10).NumHotKeys(1).HotKeyFraction(0.5)},
+		{name: "Sequential", b: DefaultSourceConfig().KeyDistribution(KeyDistributionNormal).NumKeys(10).KeyMode(KeyModeSequential)},
+		{name: "KeySizeTooSmall", b: DefaultSourceConfig().KeyDistribution(KeyDistributionZipf).NumKeys(257).KeySize(1)},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			defer func() {
+				if r := recover(); r == nil {
+					t.Errorf("Build did not panic for invalid config: %#v", test.b.cfg)
+				}
+			}()
+			test.b.Build()
+		})
+	}
+}
+
+// TestSourceConfig_HotKeyRotationInterval tests that each interval of event
+// time uses its own set of hot keys, regardless of how the source is split.
+func TestSourceConfig_HotKeyRotationInterval(t *testing.T) {
+	const elms, numHot = 100, 2
+	hotKeys := func(splits int) map[string]int64 {
+		cfg := DefaultSourceConfig().
+			NumElements(elms).
+			InitialSplits(splits).
+			NumHotKeys(numHot).
+			HotKeyFraction(1).
+			TimestampMode(TimestampModeMonotonic).
+			TimestampInterval(time.Millisecond).
+			HotKeyRotationInterval(10 * time.Millisecond).
+			Build()
+		dfn := sourceFn{}
+		intervals := make(map[string]int64)
+		rt := dfn.CreateTracker(dfn.CreateInitialRestriction(cfg))
+		for _, split := range dfn.SplitRestriction(cfg, rt.GetRestriction().(offsetrange.Restriction)) {
+			emit := func(ts beam.EventTime, key, _ []byte) {
+				interval := int64(ts) / 10
+				k := hex.EncodeToString(key)
+				if prev, ok := intervals[k]; ok && prev != interval {
+					t.Errorf("SourceFn emitted hot key %v in intervals %v and %v, want a single interval", k, prev, interval)
+				}
+				intervals[k] = interval
+			}
+			if err := dfn.ProcessElement(context.Background(), mtime.ZeroTimestamp, dfn.CreateTracker(split), cfg, emit); err != nil {
+				t.Fatalf("Failure processing sourceFn: %v", err)
+			}
+		}
+		return intervals
+	}
+
+	intervals := hotKeys(1)
+	if got, want := len(intervals), elms/10*numHot; got != want {
+		t.Errorf("SourceFn emitted wrong number of distinct hot keys: got: %v, want: %v", got, want)
+	}
+	if got := hotKeys(3); !reflect.DeepEqual(got, intervals) {
+		t.Errorf("SourceFn emitted different hot keys with 3 splits than with 1 split")
+	}
+}
+
+// TestSourceConfig_HotKeyValidation tests that Build rejects hot key
+// configurations that cannot produce the requested hot keys.
+func TestSourceConfig_HotKeyValidation(t *testing.T) {
+	tests := []struct {
+		name string
+		b    *SourceConfigBuilder
+	}{
+		{name: "NoHotKeys", b: DefaultSourceConfig().HotKeyFraction(0.5)},
+		{name: "KeySizeTooSmall", b: DefaultSourceConfig().KeySize(1).NumHotKeys(257).HotKeyFraction(0.5)},
+		{name: "NegativeRotationInterval", b: DefaultSourceConfig().TimestampMode(TimestampModeMonotonic).HotKeyRotationInterval(-time.Second)},
+		{name: "RotationWithoutTimestamps", b: DefaultSourceConfig().NumHotKeys(2).HotKeyFraction(0.5).HotKeyRotationInterval(time.Second)},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			defer func() {
+				if r := recover(); r == nil {
+					t.Errorf("Build did not panic for invalid config: %#v", test.b.cfg)
+				}
+			}()
+			test.b.Build()
+		})
+	}
+}
+
+// TestSourceConfig_KeyModeSequential tests that sequential keys decode back to
+// a contiguous increasing sequence, even across splits.
+func TestSourceConfig_KeyModeSequential(t *testing.T) {
+	tests := []struct {
+		elms    int
+		keySize int
+		splits  int
+	}{
+		{elms: 1, keySize: 1, splits: 1},
+		{elms: 256, keySize: 1, splits: 7},
+		{elms: 1000, keySize: 4, splits: 10},
+		{elms: 100, keySize: 12, splits: 3},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(fmt.Sprintf("(elm = %v, keySize = %v, splits = %v)", test.elms, test.keySize, test.splits), func(t *testing.T) {
+			dfn := sourceFn{}
+			cfg := DefaultSourceConfig().
+				NumElements(test.elms).
+				KeySize(test.keySize).
+				InitialSplits(test.splits).
+				KeyMode(KeyModeSequential).
+				Build()
+
+			keys, _, err := simulateSourceFn(t, &dfn, cfg)
+			if err != nil {
+				t.Errorf("Failure processing sourceFn: %v", err)
+			}
+			if got := len(keys); got != test.elms {
+				t.Fatalf("SourceFn emitted wrong number of outputs: got: %v, want: %v",
+					got, test.elms)
+			}
+			for i, key := range keys {
+				var got uint64
+				for _, b := range key {
+					got = got<<8 | uint64(b)
+				}
+				if got != uint64(i) {
+					t.Fatalf("SourceFn emitted wrong sequential key at position %v: got: %v, want: %v",
+						i, got, i)
+				}
+				if i > 0 && bytes.Compare(keys[i-1], key) >= 0 {
+					t.Fatalf("SourceFn emitted sequential keys out of order at position %v: %v >= %v", i, keys[i-1], key)
+				}
+			}
+		})
+	}
+
+	t.Run("Validation", func(t *testing.T) {
+		tests := []struct {
+			name string
+			cfg  *SourceConfigBuilder
+		}{
+			{"KeySizeTooSmall", DefaultSourceConfig().NumElements(257).KeySize(1).KeyMode(KeyModeSequential)},
+			{"VariableKeySize", DefaultSourceConfig().KeySizeDistribution(SizeDistributionUniform, 2, 8).KeyMode(KeyModeSequential)},
+		}
+		for _, test := range tests {
+			if err := test.cfg.validate(); err == nil {
+				t.Errorf("%v: validate() succeeded, want error", test.name)
+			}
+		}
+	})
+}
+
+// TestSourceConfig_StartupDelay tests that the startup delay is paid only once
+// by each DoFn instance, on its first bundle.
+func TestSourceConfig_StartupDelay(t *testing.T) {
+	const delay = 50 * time.Millisecond
+	dfn := sourceFn{}
+	cfg := DefaultSourceConfig().NumElements(10).InitialSplits(4).StartupDelay(delay).Build()
+
+	start := time.Now()
+	keys, _, err := simulateSourceFn(t, &dfn, cfg)
+	if err != nil {
+		t.Fatalf("Failure processing sourceFn: %v", err)
+	}
+	if got := len(keys); got != 10 {
+		t.Errorf("SourceFn emitted wrong number of outputs: got: %v, want: 10", got)
+	}
+	if got := time.Since(start); got < delay || got >= 4*delay {
+		t.Errorf("SourceFn paid wrong startup delay: got: %v, want: at least %v, and less than %v", got, delay, 4*delay)
+	}
+
+	// A later bundle of the same instance starts without delay.
+	dfn.StartBundle(context.Background(), nil)
+	start = time.Now()
+	if _, _, err := simulateSourceFn(t, &dfn, cfg); err != nil {
+		t.Fatalf("Failure processing sourceFn: %v", err)
+	}
+	if got := time.Since(start); got >= delay {
+		t.Errorf("SourceFn paid startup delay again in a later bundle: took %v", got)
+	}
+}
+
+// TestSourceConfig_InitialDelay tests that the initial delay is paid once for
+// each bundle, no matter how many restrictions the bundle processes.
+func TestSourceConfig_InitialDelay(t *testing.T) {
+	const delay = 20 * time.Millisecond
+	tests := []struct {
+		elms    int
+		splits  int
+		bundles int
+	}{
+		{elms: 1, splits: 1, bundles: 1},
+		{elms: 100, splits: 4, bundles: 4},
+		{elms: 100, splits: 4, bundles: 1},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(fmt.Sprintf("(elm = %v, splits = %v, bundles = %v)", test.elms, test.splits, test.bundles), func(t *testing.T) {
+			dfn := sourceFn{}
+			cfg := DefaultSourceConfig().
+				NumElements(test.elms).
+				InitialSplits(test.splits).
+				InitialDelay(delay).
+				Build()
+
+			var keys [][]byte
+			emitFn := func(_ beam.EventTime, key []byte, _ []byte) {
+				keys = append(keys, key)
+			}
+			splits := dfn.SplitRestriction(cfg, dfn.CreateInitialRestriction(cfg))
+			perBundle := len(splits) / test.bundles
+			start := time.Now()
+			for i, split := range splits {
+				if i%perBundle == 0 {
+					dfn.StartBundle(context.Background(), emitFn)
+				}
+				if err := dfn.ProcessElement(context.Background(), mtime.ZeroTimestamp, dfn.CreateTracker(split), cfg, emitFn); err != nil {
+					t.Fatalf("Failure processing sourceFn: %v", err)
+				}
+			}
+			got := time.Since(start)
+			if got := len(keys); got != test.elms {
+				t.Errorf("SourceFn emitted wrong number of outputs: got: %v, want: %v",
+					got, test.elms)
+			}
+			if want := time.Duration(test.bundles) * delay; got < want {
+				t.Errorf("SourceFn finished too quickly: got: %v, want at least: %v", got, want)
+			}
+			if test.bundles < test.splits && got >= time.Duration(test.splits)*delay {
+				t.Errorf("SourceFn paid initial delay for each restriction: took %v", got)
+			}
+		})
+	}
+}
+
+// TestSourceConfig_SleepPerElement tests that the source sleeps for the
+// configured delay before each element, that the delay is reflected in the
+// restriction size, and that cancellation interrupts the sleep.
+func TestSourceConfig_SleepPerElement(t *testing.T) {
+	const elms, delay = 10, 5 * time.Millisecond
+	dfn := sourceFn{}
+	cfg := DefaultSourceConfig().NumElements(elms).InitialSplits(2).SleepPerElement(delay).Build()
+
+	start := time.Now()
+	keys, _, err := simulateSourceFn(t, &dfn, cfg)
+	if err != nil {
+		t.Fatalf("Failure processing sourceFn: %v", err)
+	}
+	if got := len(keys); got != elms {
+		t.Errorf("SourceFn emitted wrong number of outputs: got: %v, want: %v", got, elms)
+	}
+	if got, want := time.Since(start), elms*delay; got < want {
+		t.Errorf("SourceFn finished too quickly: got: %v, want at least: %v", got, want)
+	}
+
+	base := DefaultSourceConfig().NumElements(elms).Build()
+	rest := dfn.CreateInitialRestriction(cfg)
+	if slow, fast := dfn.RestrictionSize(cfg, rest), dfn.RestrictionSize(base, rest); slow <= fast {
+		t.Errorf("RestrictionSize with SleepPerElement is not larger than without: got: %v, want > %v", slow, fast)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	slow := DefaultSourceConfig().NumElements(elms).SleepPerElement(time.Hour).Build()
+	emit := func(beam.EventTime, []byte, []byte) {}
+	if err := dfn.ProcessElement(ctx, mtime.ZeroTimestamp, dfn.CreateTracker(dfn.CreateInitialRestriction(slow)), slow, emit); !errors.Is(err, context.Canceled) {
+		t.Errorf("SourceFn did not abort a sleep on cancellation: got: %v, want: %v", err, context.Canceled)
+	}
+}
+
+
+// TestSourceConfig_SleepDistribution tests that per-element sleeps are drawn
+// from the configured distribution, with SleepPerElement as the mean.
+func TestSourceConfig_SleepDistribution(t *testing.T) {
+	const elms, mean = 20000, time.Millisecond
+	tests := []struct {
+		dist       DelayDistribution
+		stdDev     time.Duration
+		wantStdDev time.Duration
+	}{
+		{dist: DelayDistributionConstant, wantStdDev: 0},
+		{dist: DelayDistributionUniform, wantStdDev: time.Duration(2 * float64(mean) / math.Sqrt(12))},
+		{dist: DelayDistributionExponential, wantStdDev: mean},
+		{dist: DelayDistributionNormal, stdDev: mean / 4, wantStdDev: mean / 4},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(string(test.dist), func(t *testing.T) {
+			cfg := DefaultSourceConfig().
+				NumElements(elms).
+				SleepPerElement(mean).
+				SleepDistribution(test.dist).
+				SleepStdDev(test.stdDev).
+				Build()
+			var sum, sumSq float64
+			for i := int64(0); i < elms; i++ {
+				sleep := float64(elementSleep(cfg, i))
+				if sleep < 0 {
+					t.Fatalf("Negative sleep for element %v: %v", i, time.Duration(sleep))
+				}
+				sum += sleep
+				sumSq += sleep * sleep
+			}
+			gotMean := sum / elms
+			gotStdDev := math.Sqrt(math.Max(0, sumSq/elms-gotMean*gotMean))
+			const tolerance = 0.03
+			if math.Abs(gotMean-float64(mean)) > tolerance*float64(mean) {
+				t.Errorf("Sleeps have wrong mean: got: %v, want: %v", time.Duration(gotMean), mean)
+			}
+			if math.Abs(gotStdDev-float64(test.wantStdDev)) > tolerance*float64(mean) {
+				t.Errorf("Sleeps have wrong standard deviation: got: %v, want: %v", time.Duration(gotStdDev), test.wantStdDev)
+			}
+		})
+	}
+
+	t.Run("Validation", func(t *testing.T) {
+		for _, b := range []*SourceConfigBuilder{
+			DefaultSourceConfig().SleepDistribution("pareto"),
+			DefaultSourceConfig().SleepDistribution(DelayDistributionNormal),
+		} {
+			func() {
+				defer func() {
+					if r := recover(); r == nil {
+						t.Errorf("Build did not panic for invalid config: %#v", b.cfg)
+					}
+				}()
+				b.Build()
+			}()
+		}
+	})
+}
+
+// TestSourceConfig_CPUBurnPerElement tests that the source spends at least the
+// configured time on the CPU for each element, and that the work is reflected
+// in the restriction size.
+func TestSourceConfig_CPUBurnPerElement(t *testing.T) {
+	const elms, burn = 10, 2 * time.Millisecond
+	dfn := sourceFn{}
+	cfg := DefaultSourceConfig().NumElements(elms).CPUBurnPerElement(burn).Build()
+
+	start := time.Now()
+	keys, _, err := simulateSourceFn(t, &dfn, cfg)
+	if err != nil {
+		t.Fatalf("Failure processing sourceFn: %v", err)
+	}
+	if got := len(keys); got != elms {
+		t.Errorf("SourceFn emitted wrong number of outputs: got: %v, want: %v", got, elms)
+	}
+	if got, want := time.Since(start), elms*burn; got < want {
+		t.Errorf("SourceFn finished too quickly: got: %v, want at least: %v", got, want)
+	}
+
+	base := DefaultSourceConfig().NumElements(elms).Build()
+	rest := dfn.CreateInitialRestriction(cfg)
+	if busy, idle := dfn.RestrictionSize(cfg, rest), dfn.RestrictionSize(base, rest); busy <= idle {
+		t.Errorf("RestrictionSize with CPUBurnPerElement is not larger than without: got: %v, want > %v", busy, idle)
+	}
+}
+
+// TestSourceConfig_MaxRate tests that the source doesn't emit elements faster
+// than the configured rate, and that the rate is reflected in the restriction
+// size.
+func TestSourceConfig_MaxRate(t *testing.T) {
+	const elms, rate = 100, 500.0
+	dfn := sourceFn{}
+	cfg := DefaultSourceConfig().NumElements(elms).MaxRate(rate).Build()
+
+	start := time.Now()
+	keys, _, err := simulateSourceFn(t, &dfn, cfg)
+	if err != nil {
+		t.Fatalf("Failure processing sourceFn: %v", err)
+	}
+	if got := len(keys); got != elms {
+		t.Errorf("SourceFn emitted wrong number of outputs: got: %v, want: %v", got, elms)
+	}
+	// The token bucket starts full, so the initial burst is emitted immediately.
+	burst := rate * maxBurstWindow.Seconds()
+	want := time.Duration((elms - burst) / rate * float64(time.Second))
+	if got := time.Since(start); got < want {
+		t.Errorf("SourceFn finished too quickly: got: %v, want at least: %v", got, want)
+	}
+
+	base := DefaultSourceConfig().NumElements(elms).Build()
+	rest := dfn.CreateInitialRestriction(cfg)
+	if slow, fast := dfn.RestrictionSize(cfg, rest), dfn.RestrictionSize(base, rest); slow <= fast {
+		t.Errorf("RestrictionSize with MaxRate is not larger than without: got: %v, want > %v", slow, fast)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	slow := DefaultSourceConfig().NumElements(elms).MaxRate(0.001).Build()
+	emit := func(beam.EventTime, []byte, []byte) {}
+	if err := dfn.ProcessElement(ctx, mtime.ZeroTimestamp, dfn.CreateTracker(dfn.CreateInitialRestriction(slow)), slow, emit); !errors.Is(err, context.Canceled) {
+		t.Errorf("SourceFn did not abort rate limiting on cancellation: got: %v, want: %v", err, context.Canceled)
+	}
+}
+
+// TestSourceConfig_TimestampMode tests that elements are emitted with event
+// times according to the configured timestamp mode.
+func TestSourceConfig_TimestampMode(t *testing.T) {
+	const elms = 2000
+	start := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	startTs := mtime.FromTime(start)
+	inputTs := mtime.FromMilliseconds(12345)
+
+	timestamps := func(t *testing.T, b *SourceConfigBuilder) []beam.EventTime {
+		t.Helper()
+		cfg := b.NumElements(elms).InitialSplits(3).StartTimestamp(start).Build()
+		dfn := sourceFn{}
+		var got []beam.EventTime
+		emitFn := func(et beam.EventTime, _, _ []byte) {
+			got = append(got, et)
+		}
+		for _, split := range dfn.SplitRestriction(cfg, dfn.CreateInitialRestriction(cfg)) {
+			if err := dfn.ProcessElement(context.Background(), inputTs, dfn.CreateTracker(split), cfg, emitFn); err != nil {
+				t.Fatalf("Failure processing sourceFn: %v", err)
+			}
+		}
+		if len(got) != elms {
+			t.Fatalf("SourceFn emitted wrong number of outputs: got: %v, want: %v", len(got), elms)
+		}
+		return got
+	}
+
+	t.Run("None", func(t *testing.T) {
+		for i, et := range timestamps(t, DefaultSourceConfig()) {
+			if et != inputTs {
+				t.Fatalf("SourceFn emitted element %v with wrong timestamp: got: %v, want: %v", i, et, inputTs)
+			}
+		}
+	})
+	t.Run("Monotonic", func(t *testing.T) {
+		b := DefaultSourceConfig().TimestampMode(TimestampModeMonotonic).TimestampInterval(time.Second)
+		for i, et := range timestamps(t, b) {
+			if want := startTs.Add(time.Duration(i) * time.Second); et != want {
+				t.Fatalf("SourceFn emitted element %v with wrong timestamp: got: %v, want: %v", i, et, want)
+			}
+		}
+	})
+	t.Run("Jittered", func(t *testing.T) {
+		const jitter = 5 * time.Second
+		b := DefaultSourceConfig().TimestampMode(TimestampModeJittered).TimestampInterval(time.Second).TimestampJitter(jitter)
+		outOfOrder := 0
+		ets := timestamps(t, b)
+		for i, et := range ets {
+			base := startTs.Add(time.Duration(i) * time.Second)
+			if et < base.Subtract(jitter) || et > base.Add(jitter) {
+				t.Errorf("SourceFn emitted element %v with timestamp %v outside of %v +/- %v", i, et, base, jitter)
+			}
+			if i > 0 && et < ets[i-1] {
+				outOfOrder++
+			}
+		}
+		if outOfOrder == 0 {
+			t.Errorf("SourceFn emitted no out of order timestamps with jitter larger than the interval")
+		}
+	})
+	t.Run("Uniform", func(t *testing.T) {
+		b := DefaultSourceConfig().TimestampMode(TimestampModeUniform).TimestampInterval(time.Second)
+		end := startTs.Add(elms * time.Second)
+		var firstHalf int
+		for i, et := range timestamps(t, b) {
+			if et < startTs || et >= end {
+				t.Errorf("SourceFn emitted element %v with timestamp %v outside of [%v, %v)", i, et, startTs, end)
+			}
+			if et < startTs.Add(elms/2*time.Second) {
+				firstHalf++
+			}
+		}
+		if got := float64(firstHalf) / elms; got < 0.4 || got > 0.6 {
+			t.Errorf("SourceFn emitted wrong fraction of timestamps in the first half: got: %v, want: about 0.5", got)
+		}
+	})
+}
+
+// TestSourceSingle_TimestampMode tests that event times assigned by the source
+// are preserved when running in a pipeline.
+func TestSourceSingle_TimestampMode(t *testing.T) {
+	const elms = 10
+	p, s := beam.NewPipelineWithRoot()
+	cfg := DefaultSourceConfig().
+		NumElements(elms).
+		InitialSplits(2).
+		TimestampMode(TimestampModeMonotonic).
+		TimestampInterval(time.Second).
+		Build()
+	src := SourceSingle(s, cfg)
+	millis := beam.ParDo(s, func(et beam.EventTime, _, _ []byte) int64 { return et.Milliseconds() }, src)
+
+	var want []interface{}
+	for i := 0; i < elms; i++ {
+		want = append(want, int64(i*1000))
+	}
+	passert.Equals(s, millis, want...)
+
+	ptest.RunAndValidate(t, p)
+}
+
+// TestSourceFn_RestrictionSize tests that the size of the remaining work after
+// claiming part of a restriction is proportional to the weighted work left.
+func TestSourceFn_RestrictionSize(t *testing.T) {
+	tests := []struct {
+		elms    int
+		claimed int
+	}{
+		{elms: 100, claimed: 50},
+		{elms: 100, claimed: 25},
+		{elms: 10, claimed: 9},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(fmt.Sprintf("(elm = %v, claimed = %v)", test.elms, test.claimed), func(t *testing.T) {
+			dfn := sourceFn{}
+			cfg := DefaultSourceConfig().NumElements(test.elms).Build()
+
+			rest := dfn.CreateInitialRestriction(cfg)
+			full := dfn.RestrictionSize(cfg, rest)
+			rt := dfn.CreateTracker(rest)
+			for i := 0; i < test.claimed; i++ {
+				if !rt.TryClaim(int64(i)) {
+					t.Fatalf("Failed to claim position %v", i)
+				}
+			}
+			done, remaining := rt.GetProgress()
+			residual := offsetrange.Restriction{Start: int64(test.claimed), End: int64(test.elms)}
+
+			wantFraction := float64(test.elms-test.claimed) / float64(test.elms)
+			got := dfn.RestrictionSize(cfg, residual)
+			if want := full * wantFraction; got != want {
+				t.Errorf("RestrictionSize of remaining work is wrong: got: %v, want: %v", got, want)
+			}
+			if got := remaining / (done + remaining); got != wantFraction {
+				t.Errorf("Tracker reported wrong fraction of remaining work: got: %v, want: %v", got, wantFraction)
+			}
+		})
+	}
+}
+
+// TestSourceConfig_Seed tests that the same seed always produces identical
+// data, and that different seeds produce different data.
+func TestSourceConfig_Seed(t *testing.T) {
+	const elms = 50
+	generate := func(seed int64, splits int) (keys, vals [][]byte) {
+		cfg := DefaultSourceConfig().
+			NumElements(elms).
+			InitialSplits(splits).
+			NumHotKeys(3).
+			HotKeyFraction(0.2).
+			Seed(seed).
+			Build()
+		keys, vals, err := simulateSourceFn(t, &sourceFn{}, cfg)
+		if err != nil {
+			t.Fatalf("Failure processing sourceFn: %v", err)
+		}
+		return keys, vals
+	}
+
+	keys, vals := generate(42, 1)
+	gotKeys, gotVals := generate(42, 4)
+	if !reflect.DeepEqual(keys, gotKeys) || !reflect.DeepEqual(vals, gotVals) {
+		t.Errorf("SourceFn emitted different data for the same seed")
+	}
+	otherKeys, otherVals := generate(43, 1)
+	for i := range keys {
+		if bytes.Equal(vals[i], otherVals[i]) {
+			t.Errorf("SourceFn emitted identical value %v for different seeds", i)
+		}
+	}
+	if reflect.DeepEqual(keys, otherKeys) {
+		t.Errorf("SourceFn emitted identical keys for different seeds")
+	}
+}
+
+// TestSourceFn_SplitInvariant tests that the set of elements emitted for a
+// config is the same regardless of how the restriction is split.
+func TestSourceFn_SplitInvariant(t *testing.T) {
+	emitted := func(splits int) []string {
+		dfn := sourceFn{}
+		cfg := DefaultSourceConfig().
+			NumElements(500).
+			InitialSplits(splits).
+			NumHotKeys(5).
+			HotKeyFraction(0.2).
+			Build()
+		keys, vals, err := simulateSourceFn(t, &dfn, cfg)
+		if err != nil {
+			t.Fatalf("Failure processing sourceFn: %v", err)
+		}
+		kvs := make([]string, len(keys))
+		for i := range keys {
+			kvs[i] = hex.EncodeToString(keys[i]) + ":" + hex.EncodeToString(vals[i])
+		}
+		sort.Strings(kvs)
+		return kvs
+	}
+
+	want, got := emitted(1), emitted(7)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("SourceFn emitted different elements with 7 splits than with 1 split")
+	}
+	values := make(map[string]bool)
+	for _, kv := range want {
+		values[kv[strings.Index(kv, ":")+1:]] = true
+	}
+	if len(values) != len(want) {
+		t.Errorf("SourceFn emitted duplicate values: got %v distinct values, want: %v", len(values), len(want))
+	}
+}
+
+// TestSourceConfig_DuplicateFraction tests that the configured fraction of
+// elements is emitted twice, and that the same elements are duplicated
+// regardless of how the source is split.
+func TestSourceConfig_DuplicateFraction(t *testing.T) {
+	const elms, fraction = 4000, 0.25
+	duplicates := func(splits int) map[string]int {
+		cfg := DefaultSourceConfig().NumElements(elms).InitialSplits(splits).DuplicateFraction(fraction).Build()
+		keys, vals, err := simulateSourceFn(t, &sourceFn{}, cfg)
+		if err != nil {
+			t.Fatalf("Failure processing sourceFn: %v", err)
+		}
+		counts := make(map[string]int)
+		for i := range keys {
+			counts[hex.EncodeToString(keys[i])+":"+hex.EncodeToString(vals[i])]++
+		}
+		if got := len(counts); got != elms {
+			t.Errorf("SourceFn emitted wrong number of distinct elements: got: %v, want: %v", got, elms)
+		}
+		dups := make(map[string]int)
+		for kv, n := range counts {
+			if n > 2 {
+				t.Errorf("SourceFn emitted element %v %v times, want at most 2", kv, n)
+			}
+			if n > 1 {
+				dups[kv] = n
+			}
+		}
+		return dups
+	}
+
+	dups := duplicates(1)
+	const tolerance = 0.03
+	if got := float64(len(dups)) / elms; got < fraction-tolerance || got > fraction+tolerance {
+		t.Errorf("SourceFn duplicated wrong fraction of elements: got: %v, want: %v +/- %v", got, fraction, tolerance)
+	}
+	if got := duplicates(7); !reflect.DeepEqual(got, dups) {
+		t.Errorf("SourceFn duplicated different elements with 7 splits than with 1 split")
+	}
+
+	t.Run("Validation", func(t *testing.T) {
+		defer func() {
+			if r := recover(); r == nil {
+				t.Errorf("Build did not panic for DuplicateFraction above 1")
+			}
+		}()
+		DefaultSourceConfig().DuplicateFraction(1.5).Build()
+	})
+}
+
+// TestSourceConfig_RetainedBytesPerElement tests that the source holds the
+// configured amount of memory per element until the end of the bundle.
+func TestSourceConfig_RetainedBytesPerElement(t *testing.T) {
+	const elms, retained = 20, 10000
+	dfn := sourceFn{}
+	cfg := DefaultSourceConfig().NumElements(elms).InitialSplits(3).RetainedBytesPerElement(retained).Build()
+	if _, _, err := simulateSourceFn(t, &dfn, cfg); err != nil {
+		t.Fatalf("Failure processing sourceFn: %v", err)
+	}
+	var got int
+	for _, buf := range dfn.retained {
+		got += len(buf)
+	}
+	if want := elms * retained; got != want {
+		t.Errorf("SourceFn retained wrong amount of memory: got: %v bytes, want: %v bytes", got, want)
+	}
+
+	dfn.FinishBundle(nil)
+	if dfn.retained != nil {
+		t.Errorf("SourceFn did not release retained memory at the end of the bundle")
+	}
+
+	t.Run("Validation", func(t *testing.T) {
+		if err := DefaultSourceConfig().RetainedBytesPerElement(-1).validate(); err == nil {
+			t.Errorf("validate() succeeded for negative RetainedBytesPerElement, want error")
+		}
+	})
+}
+
+// TestSourceConfig_CheckpointEvery tests that a self-checkpointing source
+// stops after the configured number of elements, and that resuming the
+// residuals of its checkpoints emits every element exactly once.
+func TestSourceConfig_CheckpointEvery(t *testing.T) {
+	tests := []struct {
+		elms, every, wantCalls int
+	}{
+		{elms: 10, every: 3, wantCalls: 4},
+		{elms: 9, every: 3, wantCalls: 3},
+		{elms: 2, every: 5, wantCalls: 1},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(fmt.Sprintf("(elms = %v, every = %v)", test.elms, test.every), func(t *testing.T) {
+			cfg := DefaultSourceConfig().
+				NumElements(test.elms).
+				ResumeDelay(time.Second).
+				CheckpointEvery(test.every).
+				Build()
+			dfn := checkpointingSourceFn{}
+			seen := make(map[string]bool)
+			emitFn := func(_ beam.EventTime, key, val []byte) {
+				kv := hex.EncodeToString(key) + ":" + hex.EncodeToString(val)
+				if seen[kv] {
+					t.Errorf("checkpointingSourceFn emitted element %v more than once", kv)
+				}
+				seen[kv] = true
+			}
+
+			var calls int
+			rest := dfn.CreateInitialRestriction(cfg)
+			for {
+				calls++
+				before := len(seen)
+				rt := dfn.CreateTracker(rest)
+				cont, err := dfn.ProcessElement(context.Background(), mtime.ZeroTimestamp, rt, cfg, emitFn)
+				if err != nil {
+					t.Fatalf("Failure processing checkpointingSourceFn: %v", err)
+				}
+				if got := len(seen) - before; got > test.every {
+					t.Fatalf("checkpointingSourceFn emitted too many elements before checkpointing: got: %v, want at most: %v", got, test.every)
+				}
+				if !cont.ShouldResume() {
+					break
+				}
+				if got, want := cont.ResumeDelay(), time.Second; got != want {
+					t.Errorf("checkpointingSourceFn returned wrong resume delay: got: %v, want: %v", got, want)
+				}
+				// Resume from the unclaimed remainder of the restriction.
+				done, remaining := rt.GetProgress()
+				if remaining <= 0 {
+					t.Fatalf("checkpointingSourceFn asked to resume a finished restriction %v", rt.GetRestriction())
+				}
+				rest = offsetrange.Restriction{Start: rest.Start + int64(done), End: rest.End}
+			}
+			if got := len(seen); got != test.elms {
+				t.Errorf("checkpointingSourceFn emitted wrong number of elements: got: %v, want: %v", got, test.elms)
+			}
+			if calls != test.wantCalls {
+				t.Errorf("checkpointingSourceFn processed wrong number of checkpoints: got: %v, want: %v", calls, test.wantCalls)
+			}
+		})
+	}
+
+	t.Run("CheckpointInterval", func(t *testing.T) {
+		cfg := DefaultSourceConfig().
+			NumElements(100).
+			SleepPerElement(time.Millisecond).
+			CheckpointInterval(10 * time.Millisecond).
+			Build()
+		dfn := checkpointingSourceFn{}
+		var emitted int
+		rt := dfn.CreateTracker(dfn.CreateInitialRestriction(cfg))
+		cont, err := dfn.ProcessElement(context.Background(), mtime.ZeroTimestamp, rt, cfg, func(beam.EventTime, []byte, []byte) { emitted++ })
+		if err != nil {
+			t.Fatalf("Failure processing checkpointingSourceFn: %v", err)
+		}
+		if !cont.ShouldResume() || emitted == 0 || emitted >= 100 {
+			t.Errorf("checkpointingSourceFn did not checkpoint after CheckpointInterval: emitted %v of 100 elements, resume: %v", emitted, cont.ShouldResume())
+		}
+	})
+
+	// A checkpoint is always due with a tiny CheckpointInterval, so every call
+	// should still claim and emit one element before checkpointing, rather
+	// than checkpointing without making progress.
+	t.Run("TinyCheckpointInterval", func(t *testing.T) {
+		const elms = 5
+		cfg := DefaultSourceConfig().
+			NumElements(elms).
+			CheckpointInterval(time.Nanosecond).
+			Build()
+		dfn := checkpointingSourceFn{}
+		var emitted int
+		emitFn := func(beam.EventTime, []byte, []byte) { emitted++ }
+
+		rest := dfn.CreateInitialRestriction(cfg)
+		for calls := 1; ; calls++ {
+			if calls > elms {
+				t.Fatalf("checkpointingSourceFn did not finish after %v calls, emitted %v of %v elements", calls-1, emitted, elms)
+			}
+			before := emitted
+			rt := dfn.CreateTracker(rest)
+			cont, err := dfn.ProcessElement(context.Background(), mtime.ZeroTimestamp, rt, cfg, emitFn)
+			if err != nil {
+				t.Fatalf("Failure processing checkpointingSourceFn: %v", err)
+			}
+			if got := emitted - before; got != 1 {
+				t.Errorf("checkpointingSourceFn emitted wrong number of elements in call %v: got: %v, want: 1", calls, got)
+			}
+			if !cont.ShouldResume() {
+				break
+			}
+			done, _ := rt.GetProgress()
+			rest = offsetrange.Restriction{Start: rest.Start + int64(done), End: rest.End}
+		}
+		if emitted != elms {
+			t.Errorf("checkpointingSourceFn emitted wrong number of elements: got: %v, want: %v", emitted, elms)
+		}
+	})
+
+	t.Run("Validation", func(t *testing.T) {
+		tests := []struct {
+			name string
+			cfg  *SourceConfigBuilder
+		}{
+			{"NegativeCheckpointEvery", DefaultSourceConfig().CheckpointEvery(-1)},
+			{"NegativeCheckpointInterval", DefaultSourceConfig().CheckpointInterval(-time.Second)},
+			{"NegativeResumeDelay", DefaultSourceConfig().ResumeDelay(-time.Second)},
+			{"BytesFormat", DefaultSourceConfig().CheckpointEvery(3).OutputFormat(OutputFormatBytes)},
+			{"BundleFinalization", DefaultSourceConfig().CheckpointEvery(3).BundleFinalization(true)},
+		}
+		for _, test := range tests {
+			if err := test.cfg.validate(); err == nil {
+				t.Errorf("%v: validate() succeeded, want error", test.name)
+			}
+		}
+	})
+}
+
+// TestSourceConfig_OutputFormat tests that elements are emitted in the shape
+// and size of the configured output format.
+func TestSourceConfig_OutputFormat(t *testing.T) {
+	const elms, keySize, valSize = 10, 3, 5
+
+	t.Run("KV", func(t *testing.T) {
+		dfn := sourceFn{}
+		cfg := DefaultSourceConfig().NumElements(elms).KeySize(keySize).ValueSize(valSize).Build()
+		keys, vals, err := simulateSourceFn(t, &dfn, cfg)
+		if err != nil {
+			t.Fatalf("Failure processing sourceFn: %v", err)
+		}
+		if got := len(keys); got != elms {
+			t.Errorf("SourceFn emitted wrong number of outputs: got: %v, want: %v", got, elms)
+		}
+		for i := range keys {
+			if len(keys[i]) != keySize || len(vals[i]) != valSize {
+				t.Fatalf("SourceFn emitted KV of wrong size: got: (%v, %v), want: (%v, %v)",
+					len(keys[i]), len(vals[i]), keySize, valSize)
+			}
+		}
+	})
+
+	t.Run("Bytes", func(t *testing.T) {
+		dfn := bytesSourceFn{}
+		cfg := DefaultSourceConfig().
+			NumElements(elms).
+			KeySize(keySize).
+			ValueSize(valSize).
+			OutputFormat(OutputFormatBytes).
+			Build()
+		var recs [][]byte
+		emitFn := func(_ beam.EventTime, rec []byte) {
+			recs = append(recs, rec)
+		}
+		for _, split := range dfn.SplitRestriction(cfg, dfn.CreateInitialRestriction(cfg)) {
+			if err := dfn.ProcessElement(context.Background(), mtime.ZeroTimestamp, dfn.CreateTracker(split), cfg, emitFn); err != nil {
+				t.Fatalf("Failure processing bytesSourceFn: %v", err)
+			}
+		}
+		if got := len(recs); got != elms {
+			t.Errorf("bytesSourceFn emitted wrong number of outputs: got: %v, want: %v", got, elms)
+		}
+
+		// Records should match the KVs emitted for the same config.
+		keys, vals, err := simulateSourceFn(t, &sourceFn{}, DefaultSourceConfig().NumElements(elms).KeySize(keySize).ValueSize(valSize).Build())
+		if err != nil {
+			t.Fatalf("Failure processing sourceFn: %v", err)
+		}
+		for i, rec := range recs {
+			if got, want := hex.EncodeToString(rec), hex.EncodeToString(append(keys[i], vals[i]...)); got != want {
+				t.Fatalf("bytesSourceFn emitted wrong record: got: %v, want: %v", got, want)
+			}
+		}
+	})
+
+	t.Run("Batch", func(t *testing.T) {
+		tests := []struct {
+			elms, batchSize int
+		}{
+			{elms: 10, batchSize: 1},
+			{elms: 10, batchSize: 3},
+			{elms: 10, batchSize: 10},
+			{elms: 10, batchSize: 25},
+		}
+		for _, test := range tests {
+			test := test
+			t.Run(fmt.Sprintf("(elms = %v, batchSize = %v)", test.elms, test.batchSize), func(t *testing.T) {
+				dfn := batchSourceFn{}
+				cfg := DefaultSourceConfig().
+					NumElements(test.elms).
+					OutputFormat(OutputFormatBatch).
+					BatchSize(test.batchSize).
+					Build()
+				var batches [][]Element
+				emitFn := func(_ beam.EventTime, batch []Element) {
+					batches = append(batches, batch)
+				}
+				rt := dfn.CreateTracker(dfn.CreateInitialRestriction(cfg))
+				if err := dfn.ProcessElement(context.Background(), mtime.ZeroTimestamp, rt, cfg, emitFn); err != nil {
+					t.Fatalf("Failure processing batchSourceFn: %v", err)
+				}
+				if got, want := len(batches), (test.elms+test.batchSize-1)/test.batchSize; got != want {
+					t.Errorf("batchSourceFn emitted wrong number of batches: got: %v, want: %v", got, want)
+				}
+
+				// Flattened batches should match the KVs emitted for the same config.
+				keys, vals, err := simulateSourceFn(t, &sourceFn{}, DefaultSourceConfig().NumElements(test.elms).Build())
+				if err != nil {
+					t.Fatalf("Failure processing sourceFn: %v", err)
+				}
+				var elms []Element
+				for _, batch := range batches {
+					elms = append(elms, batch...)
+				}
+				if got := len(elms); got != test.elms {
+					t.Fatalf("batchSourceFn emitted wrong number of elements: got: %v, want: %v", got, test.elms)
+				}
+				for i, elm := range elms {
+					if !bytes.Equal(elm.Key, keys[i]) || !bytes.Equal(elm.Value, vals[i]) {
+						t.Fatalf("batchSourceFn emitted wrong element %v: got: %v, want: (%v, %v)", i, elm, keys[i], vals[i])
+					}
+				}
+			})
+		}
+	})
+
+	t.Run("Row", func(t *testing.T) {
+		dfn := rowSourceFn{}
+		cfg := DefaultSourceConfig().
+			NumElements(elms).
+			KeySize(keySize).
+			ValueSize(valSize).
+			OutputFormat(OutputFormatRow).
+			TimestampMode(TimestampModeMonotonic).
+			Build()
+		var rows []Row
+		emitFn := func(et beam.EventTime, row Row) {
+			if got, want := row.Timestamp, et.Milliseconds(); got != want {
+				t.Errorf("rowSourceFn emitted Row with wrong timestamp: got: %v, want: %v", got, want)
+			}
+			rows = append(rows, row)
+		}
+		rt := dfn.CreateTracker(dfn.CreateInitialRestriction(cfg))
+		if err := dfn.ProcessElement(context.Background(), mtime.ZeroTimestamp, rt, cfg, emitFn); err != nil {
+			t.Fatalf("Failure processing rowSourceFn: %v", err)
+		}
+
+		// Rows should match the KVs emitted for the same config.
+		keys, vals, err := simulateSourceFn(t, &sourceFn{}, DefaultSourceConfig().NumElements(elms).KeySize(keySize).ValueSize(valSize).Build())
+		if err != nil {
+			t.Fatalf("Failure processing sourceFn: %v", err)
+		}
+		if got := len(rows); got != elms {
+			t.Fatalf("rowSourceFn emitted wrong number of outputs: got: %v, want: %v", got, elms)
+		}
+		for i, row := range rows {
+			if row.ID != int64(i) || !bytes.Equal(row.Key, keys[i]) || !bytes.Equal(row.Payload, vals[i]) {
+				t.Fatalf("rowSourceFn emitted wrong Row %v: got: %v, want: {%v _ %v %v}", i, row, i, keys[i], vals[i])
+			}
+		}
+	})
+
+	t.Run("InvalidBatchSize", func(t *testing.T) {
+		defer func() {
+			if r := recover(); r == nil {
+				t.Errorf("Build did not panic for BatchSize of 0")
+			}
+		}()
+		DefaultSourceConfig().OutputFormat(OutputFormatBatch).BatchSize(0).Build()
+	})
+
+	t.Run("Mismatch", func(t *testing.T) {
+		dfn := sourceFn{}
+		cfg := DefaultSourceConfig().OutputFormat(OutputFormatBytes).Build()
+		if _, _, err := simulateSourceFn(t, &dfn, cfg); err == nil {
+			t.Errorf("sourceFn succeeded with OutputFormat %q, want error", OutputFormatBytes)
+		}
+	})
+}
+
+// TestSourceSingle_OutputFormat tests that SourceSingle emits elements of the
+// configured output format in a pipeline.
+func TestSourceSingle_OutputFormat(t *testing.T) {
+	tests := []struct {
+		format OutputFormat
+		want   int
+	}{
+		{format: OutputFormatKV, want: 50},
+		{format: OutputFormatBytes, want: 50},
+		{format: OutputFormatBatch, want: 5},
+		{format: OutputFormatRow, want: 50},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(string(test.format), func(t *testing.T) {
+			p, s := beam.NewPipelineWithRoot()
+			src := SourceSingle(s, DefaultSourceConfig().NumElements(50).OutputFormat(test.format).BatchSize(10).Build())
+			passert.Count(s, src, "out", test.want)
+
+			ptest.RunAndValidate(t, p)
+		})
+	}
+}
+
+// TestSourceSingle_RowCoder tests that Rows emitted by SourceSingle are encoded
+// with a schema row coder.
+func TestSourceSingle_RowCoder(t *testing.T) {
+	_, s := beam.NewPipelineWithRoot()
+	src := SourceSingle(s, DefaultSourceConfig().OutputFormat(OutputFormatRow).Build())
+	if got, want := src.Coder().String(), "R[synthetic.Row]"; got != want {
+		t.Errorf("SourceSingle output has wrong coder: got: %v, want: %v", got, want)
+	}
+}
+
+// TestSource_WithReshuffle tests that the source output is only reshuffled
+// when the WithReshuffle option is used.
+func TestSource_WithReshuffle(t *testing.T) {
+	tests := []struct {
+		name string
+		opts []sourceOption
+		want int
+	}{
+		{name: "Default", opts: nil, want: 0},
+		{name: "WithReshuffle", opts: []sourceOption{WithReshuffle()}, want: 1},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			p, s := beam.NewPipelineWithRoot()
+			src := SourceSingle(s, DefaultSourceConfig().NumElements(10).Build(), test.opts...)
+			passert.Count(s, src, "out", 10)
+
+			edges, _, err := p.Build()
+			if err != nil {
+				t.Fatalf("Failed to build pipeline: %v", err)
+			}
+			reshuffles := 0
+			for _, edge := range edges {
+				if edge.Op == graph.Reshuffle {
+					reshuffles++
+				}
+			}
+			if reshuffles != test.want {
+				t.Errorf("Pipeline has wrong number of reshuffles: got: %v, want: %v", reshuffles, test.want)
+			}
+			ptest.RunAndValidate(t, p)
+		})
+	}
+}
+
+// TestSourceConfig_SideOutputFraction tests that the configured fraction of
+// elements is emitted to the side output.
+func TestSourceConfig_SideOutputFraction(t *testing.T) {
+	tests := []float64{0, 0.1, 0.5, 1}
+	for _, fraction := range tests {
+		fraction := fraction
+		t.Run(fmt.Sprintf("(fraction = %v)", fraction), func(t *testing.T) {
+			const elms = 10000
+			dfn := sideOutputSourceFn{}
+			cfg := DefaultSourceConfig().NumElements(elms).InitialSplits(3).SideOutputFraction(fraction).Build()
+
+			var main, side int
+			emitFn := func(_ beam.EventTime, key []byte, val []byte) { main++ }
+			emitSideFn := func(_ beam.EventTime, key []byte, val []byte) { side++ }
+			for _, split := range dfn.SplitRestriction(cfg, dfn.CreateInitialRestriction(cfg)) {
+				if err := dfn.ProcessElement(context.Background(), mtime.ZeroTimestamp, dfn.CreateTracker(split), cfg, emitFn, emitSideFn); err != nil {
+					t.Fatalf("Failure processing sideOutputSourceFn: %v", err)
+				}
+			}
+			if main+side != elms {
+				t.Errorf("sideOutputSourceFn emitted wrong number of outputs: got: %v, want: %v", main+side, elms)
+			}
+			const tolerance = 0.02
+			if got := float64(side) / elms; got < fraction-tolerance || got > fraction+tolerance {
+				t.Errorf("sideOutputSourceFn emitted wrong fraction to side output: got: %v, want: %v +/- %v",
+					got, fraction, tolerance)
+			}
+		})
+	}
+
+	t.Run("Pipeline", func(t *testing.T) {
+		p, s := beam.NewPipelineWithRoot()
+		cfgs := beam.Create(s, DefaultSourceConfig().NumElements(100).SideOutputFraction(1).Build())
+		main, side := SourceWithSideOutput(s, cfgs)
+		passert.Count(s, main, "main", 0)
+		passert.Count(s, side, "side", 100)
+
+		ptest.RunAndValidate(t, p)
+	})
+}
+
+// TestSourceConfig_BundleFinalization tests that when bundle finalization is
+// enabled, the finalization counter and BundlesFinalized both count the
+// number of bundles executed. The direct runner executes the whole pipeline as
+// a single bundle.
+func TestSourceConfig_BundleFinalization(t *testing.T) {
+	p, s := beam.NewPipelineWithRoot()
+	src := SourceSingle(s, DefaultSourceConfig().NumElements(100).InitialSplits(5).BundleFinalization(true).Build())
+	passert.Count(s, src, "out", 100)
+
+	before := BundlesFinalized()
+	res, err := ptest.RunWithMetrics(p)
+	if err != nil {
+		t.Fatalf("Failed to execute pipeline: %v", err)
+	}
+	if got, want := BundlesFinalized()-before, int64(1); got != want {
+		t.Errorf("Wrong number of bundles counted by BundlesFinalized: got: %v, want: %v", got, want)
+	}
+	counters := res.Metrics().Query(func(r metrics.SingleResult) bool {
+		return r.Namespace() == "synthetic.Source" && r.Name() == "bundles_finalized"
+	}).Counters()
+	if len(counters) != 1 {
+		t.Fatalf("Wrong number of bundles_finalized counters: got: %v, want: 1", len(counters))
+	}
+	if got, want := counters[0].Result(), int64(1); got != want {
+		t.Errorf("Wrong number of finalized bundles: got: %v, want: %v", got, want)
+	}
+}
+
+// TestSourceConfig_MaxBundleStartDelay tests that the delay at the start of
+// each bundle is spread between the InitialDelay and the MaxBundleStartDelay,
+// and that each bundle is counted as started.
+func TestSourceConfig_MaxBundleStartDelay(t *testing.T) {
+	emit := func(beam.EventTime, []byte, []byte) {}
+	tests := []struct {
+		initial, max time.Duration
+	}{
+		{initial: 0, max: 15 * time.Millisecond},
+		{initial: 5 * time.Millisecond, max: 15 * time.Millisecond},
+		{initial: 10 * time.Millisecond, max: time.Millisecond}, // Fixed InitialDelay.
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(fmt.Sprintf("(initial = %v, max = %v)", test.initial, test.max), func(t *testing.T) {
+			cfg := DefaultSourceConfig().InitialDelay(test.initial).MaxBundleStartDelay(test.max).Build()
+			upper := test.max
+			if upper < test.initial {
+				upper = test.initial
+			}
+			dfn := sourceFn{}
+			for bundle := 0; bundle < 3; bundle++ {
+				dfn.StartBundle(context.Background(), emit)
+				start := time.Now()
+				if err := dfn.ProcessElement(context.Background(), mtime.ZeroTimestamp, dfn.CreateTracker(dfn.CreateInitialRestriction(cfg)), cfg, emit); err != nil {
+					t.Fatalf("Failure processing sourceFn: %v", err)
+				}
+				if got := time.Since(start); got < test.initial {
+					t.Errorf("Bundle %v finished too quickly: got: %v, want at least: %v", bundle, got, test.initial)
+				} else if got >= upper+time.Second {
+					t.Errorf("Bundle %v was delayed too long: got: %v, want at most about: %v", bundle, got, upper)
+				}
+			}
+		})
+	}
+
+	t.Run("Pipeline", func(t *testing.T) {
+		p, s := beam.NewPipelineWithRoot()
+		cfg := DefaultSourceConfig().
+			NumElements(100).
+			InitialDelay(time.Millisecond).
+			MaxBundleStartDelay(5 * time.Millisecond).
+			BundleFinalization(true).
+			Build()
+		src := SourceSingle(s, cfg)
+		passert.Count(s, src, "out", 100)
+
+		res, err := ptest.RunWithMetrics(p)
+		if err != nil {
+			t.Fatalf("Failed to execute pipeline: %v", err)
+		}
+		counts := make(map[string]int64)
+		for _, c := range res.Metrics().Query(func(r metrics.SingleResult) bool {
+			return r.Namespace() == "synthetic.Source"
+		}).Counters() {
+			counts[c.Name()] += c.Result()
+		}
+		if got, want := counts["bundles_started"], counts["bundles_finalized"]; got != want || got != 1 {
+			t.Errorf("Wrong number of started and finalized bundles: got: %v started, %v finalized, want: 1 each", got, want)
+		}
+	})
+
+	t.Run("Validation", func(t *testing.T) {
+		defer func() {
+			if r := recover(); r == nil {
+				t.Errorf("Build did not panic for negative max bundle start delay")
+			}
+		}()
+		DefaultSourceConfig().MaxBundleStartDelay(-time.Millisecond).Build()
+	})
+}
+
 // simulateSourceFn calls CreateInitialRestriction, SplitRestriction,
 // CreateTracker, and ProcessElement on the given sourceFn with the given
 // SourceConfig, and outputs the resulting output elements. This method isn't
@@ -218,17 +1966,16 @@ func TestSourceConfigBuilder_NumHotKeys(t *testing.T) {
 func simulateSourceFn(t *testing.T, dfn *sourceFn, cfg SourceConfig) (keys [][]byte, vals [][]byte, err error) {
 	t.Helper()
 
-	emitFn := func(key []byte, val []byte) {
+	emitFn := func(_ beam.EventTime, key []byte, val []byte) {
 		keys = append(keys, key)
-		vals = append(vals, key)
+		vals = append(vals, val)
 	}
 
 	rest := dfn.CreateInitialRestriction(cfg)
 	splits := dfn.SplitRestriction(cfg, rest)
-	dfn.Setup()
 	for _, split := range splits {
 		rt := dfn.CreateTracker(split)
-		if err := dfn.ProcessElement(rt, cfg, emitFn); err != nil {
+		if err := dfn.ProcessElement(context.Background(), mtime.ZeroTimestamp, rt, cfg, emitFn); err != nil {
 			return nil, nil, err
 		}
 	}
@@ -16,12 +16,15 @@
 package synthetic
 
 import (
+	"encoding/json"
 	"fmt"
 	"math/rand"
 	"reflect"
 	"time"
 
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/core/graph"
 	"github.com/apache/beam/sdks/v2/go/pkg/beam/core/sdf"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/core/util/reflectx"
 
 	"github.com/apache/beam/sdks/v2/go/pkg/beam"
 	"github.com/apache/beam/sdks/v2/go/pkg/beam/io/rtrackers/offsetrange"
@@ -30,6 +33,8 @@ import (
 func init() {
 	beam.RegisterType(reflect.TypeOf((*stepFn)(nil)).Elem())
 	beam.RegisterType(reflect.TypeOf((*sdfStepFn)(nil)).Elem())
+	beam.RegisterType(reflect.TypeOf((*checkpointingSdfStepFn)(nil)).Elem())
+	beam.RegisterFunction(makeFanoutStepFn)
 }
 
 // Step creates a synthetic step transform that receives KV<[]byte, []byte>
@@ -46,13 +51,127 @@ func init() {
 //    cfg := synthetic.DefaultStepConfig().OutputPerInput(10).FilterRatio(0.5).Build()
 //    step := synthetic.Step(s, cfg, input)
 func Step(s beam.Scope, cfg StepConfig, col beam.PCollection) beam.PCollection {
+	if len(cfg.OutputRatios) > 1 {
+		panic(fmt.Sprintf("synthetic.Step emits to a single output, but StepConfig has %v OutputRatios. Use StepWithOutputs instead.", len(cfg.OutputRatios)))
+	}
 	s = s.Scope("synthetic.Step")
+	if cfg.Splittable && cfg.CheckpointEvery > 0 {
+		return beam.ParDo(s, &checkpointingSdfStepFn{sdfStepFn{cfg: cfg}}, col)
+	}
 	if cfg.Splittable {
 		return beam.ParDo(s, &sdfStepFn{cfg: cfg}, col)
 	}
 	return beam.ParDo(s, &stepFn{cfg: cfg}, col)
 }
 
+// StepWithOutputs creates a synthetic step transform like Step, except that it
+// emits to multiple outputs, in order to build synthetic pipelines that branch
+// and to exercise runners' handling of stages with multiple outputs. Each
+// output of the step is emitted to one of the returned PCollections, chosen
+// randomly with the probabilities given by the StepConfig's OutputRatios.
+//
+// Steps with multiple outputs must not be splittable. Usage example:
+//
+//    cfg := synthetic.DefaultStepConfig().OutputRatios(70, 20, 10).Build()
+//    outs := synthetic.StepWithOutputs(s, cfg, input) // len(outs) == 3
+func StepWithOutputs(s beam.Scope, cfg StepConfig, col beam.PCollection) []beam.PCollection {
+	if cfg.Splittable {
+		panic("synthetic.StepWithOutputs does not support splittable steps")
+	}
+	s = s.Scope("synthetic.Step")
+
+	// The number of emitters in the DoFn's signature depends on the number of
+	// outputs, so the DoFn is built as a dynamic function, like beam.Partition.
+	n := len(cfg.OutputRatios)
+	if n == 0 {
+		n = 1
+	}
+	emit := reflect.FuncOf([]reflect.Type{reflectx.ByteSlice, reflectx.ByteSlice}, nil, false)
+	in := []reflect.Type{reflectx.ByteSlice, reflectx.ByteSlice}
+	for i := 0; i < n; i++ {
+		in = append(in, emit)
+	}
+	fnT := reflect.FuncOf(in, nil, false)
+
+	data, err := json.Marshal(cfg)
+	if err != nil {
+		panic(fmt.Sprintf("encoding synthetic step config: %v", err))
+	}
+	return beam.ParDoN(s, &graph.DynFn{Name: "synthetic.fanoutStepFn", Data: data, T: fnT, Gen: makeFanoutStepFn}, col)
+}
+
+// fanoutStepFn is the dynamic function implementing behavior for synthetic
+// steps with multiple outputs. For usage information, see
+// synthetic.StepWithOutputs.
+type fanoutStepFn struct {
+	name       string
+	t          reflect.Type
+	cfg        StepConfig
+	cumulative []float64 // Cumulative OutputRatios, used to choose outputs.
+	rng        randWrapper
+}
+
+// makeFanoutStepFn creates a fanoutStepFn from its encoded StepConfig.
+func makeFanoutStepFn(name string, t reflect.Type, enc []byte) reflectx.Func {
+	var cfg StepConfig
+	if err := json.Unmarshal(enc, &cfg); err != nil {
+		panic(fmt.Sprintf("decoding synthetic step config: %v", err))
+	}
+	var sum float64
+	var cumulative []float64
+	for _, ratio := range cfg.OutputRatios {
+		sum += ratio
+		cumulative = append(cumulative, sum)
+	}
+	return &fanoutStepFn{
+		name:       name,
+		t:          t,
+		cfg:        cfg,
+		cumulative: cumulative,
+		rng:        rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+func (fn *fanoutStepFn) Name() string {
+	return fn.name
+}
+
+func (fn *fanoutStepFn) Type() reflect.Type {
+	return fn.t
+}
+
+// Call behaves like stepFn.ProcessElement, emitting each output to an output
+// chosen randomly according to the OutputRatios.
+func (fn *fanoutStepFn) Call(args []interface{}) []interface{} {
+	key, val := args[0].([]byte), args[1].([]byte)
+	emits := args[2:]
+
+	filtered := fn.cfg.FilterRatio > 0 && fn.rng.Float64() < fn.cfg.FilterRatio
+	val = stepOutputValue(fn.cfg, val)
+
+	for i := 0; i < fn.cfg.OutputPerInput; i++ {
+		simulateStepWork(fn.cfg)
+		if !filtered {
+			reflectx.MakeFunc2x0(emits[fn.chooseOutput()]).Call2x0(key, val)
+		}
+	}
+	return nil
+}
+
+// chooseOutput returns the index of the output to emit the next output to.
+func (fn *fanoutStepFn) chooseOutput() int {
+	if len(fn.cumulative) <= 1 {
+		return 0
+	}
+	r := fn.rng.Float64() * fn.cumulative[len(fn.cumulative)-1]
+	for i, c := range fn.cumulative {
+		if r < c {
+			return i
+		}
+	}
+	return len(fn.cumulative) - 1
+}
+
 // stepFn is a DoFn implementing behavior for synthetic steps. For usage
 // information, see synthetic.Step.
 //
@@ -69,12 +188,14 @@ func (fn *stepFn) Setup() {
 }
 
 // ProcessElement takes an input and either filters it or produces a number of
-// outputs identical to that input based on the outputs per input configuration
+// outputs based on that input, according to the outputs per input configuration
 // in StepConfig.
 func (fn *stepFn) ProcessElement(key, val []byte, emit func([]byte, []byte)) {
 	filtered := fn.cfg.FilterRatio > 0 && fn.rng.Float64() < fn.cfg.FilterRatio
+	val = stepOutputValue(fn.cfg, val)
 
 	for i := 0; i < fn.cfg.OutputPerInput; i++ {
+		simulateStepWork(fn.cfg)
 		if !filtered {
 			emit(key, val)
 		}
@@ -127,15 +248,77 @@ func (fn *sdfStepFn) Setup() {
 }
 
 // ProcessElement takes an input and either filters it or produces a number of
-// outputs identical to that input based on the restriction size.
+// outputs based on that input, according to the restriction size.
 func (fn *sdfStepFn) ProcessElement(rt *sdf.LockRTracker, key, val []byte, emit func([]byte, []byte)) {
 	filtered := fn.cfg.FilterRatio > 0 && fn.rng.Float64() < fn.cfg.FilterRatio
+	val = stepOutputValue(fn.cfg, val)
 
 	for i := rt.GetRestriction().(offsetrange.Restriction).Start; rt.TryClaim(i); i++ {
+		simulateStepWork(fn.cfg)
+		if !filtered {
+			emit(key, val)
+		}
+	}
+}
+
+// checkpointingSdfStepFn is a splittable DoFn implementing behavior for
+// synthetic steps that self-checkpoint. It only differs from sdfStepFn in
+// returning a ProcessContinuation, which is kept out of sdfStepFn since it
+// makes the step's output unbounded.
+type checkpointingSdfStepFn struct {
+	sdfStepFn
+}
+
+// ProcessElement behaves like sdfStepFn.ProcessElement, except that after
+// claiming CheckpointEvery outputs it stops and asks the runner to resume the
+// rest of the restriction later. As with split restrictions, FilterRatio is
+// applied separately to each resumed part of the restriction.
+func (fn *checkpointingSdfStepFn) ProcessElement(rt *sdf.LockRTracker, key, val []byte, emit func([]byte, []byte)) sdf.ProcessContinuation {
+	filtered := fn.cfg.FilterRatio > 0 && fn.rng.Float64() < fn.cfg.FilterRatio
+	val = stepOutputValue(fn.cfg, val)
+
+	i := rt.GetRestriction().(offsetrange.Restriction).Start
+	for claimed := 0; claimed < fn.cfg.CheckpointEvery; claimed++ {
+		if !rt.TryClaim(i) {
+			return sdf.StopProcessing()
+		}
+		simulateStepWork(fn.cfg)
 		if !filtered {
 			emit(key, val)
 		}
+		i++
+	}
+	if i >= rt.GetRestriction().(offsetrange.Restriction).End {
+		return sdf.StopProcessing()
 	}
+	return sdf.ResumeProcessingIn(0)
+}
+
+// simulateStepWork performs the simulated work configured for each output of a
+// synthetic step, sleeping and then spending time on the CPU.
+func simulateStepWork(cfg StepConfig) {
+	if cfg.PerElementDelay > 0 {
+		time.Sleep(cfg.PerElementDelay)
+	}
+	if cfg.CPUBurnPerElement > 0 {
+		spinCPU(cfg.CPUBurnPerElement)
+	}
+}
+
+// stepOutputValue returns the value to emit for outputs of an input with the
+// given value. If the StepConfig has an OutputValueSize, the input value is
+// repeated or truncated to that size, and otherwise it is returned unchanged.
+func stepOutputValue(cfg StepConfig, val []byte) []byte {
+	if cfg.OutputValueSize <= 0 || cfg.OutputValueSize == len(val) {
+		return val
+	}
+	out := make([]byte, cfg.OutputValueSize)
+	if len(val) > 0 {
+		for n := 0; n < len(out); {
+			n += copy(out[n:], val)
+		}
+	}
+	return out
 }
 
 // StepConfigBuilder is used to initialize StepConfigs. See StepConfigBuilder's
@@ -161,10 +344,15 @@ type StepConfigBuilder struct {
 func DefaultStepConfig() *StepConfigBuilder {
 	return &StepConfigBuilder{
 		cfg: StepConfig{
-			OutputPerInput: 1,     // Defaults shouldn't drop elements, so at least 1.
-			FilterRatio:    0.0,   // Defaults shouldn't drop elements, so don't filter.
-			Splittable:     false, // Default to non-splittable, SDFs are situational.
-			InitialSplits:  1,     // Defaults to 1, i.e. no initial splitting.
+			OutputPerInput:    1,     // Defaults shouldn't drop elements, so at least 1.
+			FilterRatio:       0.0,   // Defaults shouldn't drop elements, so don't filter.
+			Splittable:        false, // Default to non-splittable, SDFs are situational.
+			InitialSplits:     1,     // Defaults to 1, i.e. no initial splitting.
+			CPUBurnPerElement: 0,     // Defaults to no simulated CPU work.
+			PerElementDelay:   0,     // Defaults to emitting outputs as fast as possible.
+			OutputValueSize:   0,     // Defaults to emitting the input value unchanged.
+			CheckpointEvery:   0,     // Defaults to never self-checkpointing.
+			OutputRatios:      nil,   // Defaults to a single output.
 		},
 	}
 }
@@ -193,9 +381,12 @@ func (b *StepConfigBuilder) OutputPerInput(val int) *StepConfigBuilder {
 //
 // Note that even when elements are filtered out, the work associated with
 // processing those elements is still performed, which differs from setting an
-// OutputPerInput of 0. Also note that if a
+// OutputPerInput of 0. Also note that filtered inputs are chosen randomly, so
+// the number of outputs of a step with a FilterRatio varies between runs. This
+// makes filtering steps useful for building shrinking, sparse stages, such as
+// for testing fusion and watermark advancement.
 //
-// Valid values are in the range if [0.0, 1.0], and the default value is 0. In
+// Valid values are in the range of [0.0, 1.0], and the default value is 0. In
 // order to avoid precision errors, invalid values do not cause errors. Instead,
 // values below 0 are functionally equivalent to 0, and values above 1 are
 // functionally equivalent to 1.
@@ -235,6 +426,74 @@ func (b *StepConfigBuilder) InitialSplits(val int) *StepConfigBuilder {
 	return b
 }
 
+// CPUBurnPerElement is the amount of time the step spends busy on the CPU for
+// each output it emits, in order to model a CPU-bound transform and drive up the
+// CPU utilization of workers. As with FilterRatio, the work is still performed
+// for outputs that are filtered out.
+//
+// Valid values are in the range of [0, ...] and the default value is 0, meaning
+// no CPU work is performed.
+func (b *StepConfigBuilder) CPUBurnPerElement(val time.Duration) *StepConfigBuilder {
+	b.cfg.CPUBurnPerElement = val
+	return b
+}
+
+// PerElementDelay is the amount of time the step sleeps for each output it
+// emits, in order to model a transform with high per-element latency, such as
+// one making a call to an external service. As with FilterRatio, the delay is
+// still incurred for outputs that are filtered out.
+//
+// Valid values are in the range of [0, ...] and the default value is 0, meaning
+// no delay.
+func (b *StepConfigBuilder) PerElementDelay(val time.Duration) *StepConfigBuilder {
+	b.cfg.PerElementDelay = val
+	return b
+}
+
+// OutputValueSize is the size in bytes of the value of each output emitted by
+// the step. Output values are made by repeating or truncating the input value
+// to this size, so steps can be used to grow or shrink the amount of data
+// flowing through a pipeline, such as to stress shuffles. Output keys are
+// always identical to the input key.
+//
+// Valid values are in the range of [0, ...] and the default value is 0, meaning
+// outputs have the same value as the input.
+func (b *StepConfigBuilder) OutputValueSize(val int) *StepConfigBuilder {
+	b.cfg.OutputValueSize = val
+	return b
+}
+
+// CheckpointEvery is only applicable if Splittable is set to true, and makes
+// the step self-checkpoint after emitting this many outputs of a restriction,
+// returning the rest of the restriction to the runner to be resumed later.
+// This exercises checkpointing of intermediate stages, in addition to the
+// dynamic splitting that all splittable steps support.
+//
+// Self-checkpointing steps produce unbounded output, so they require a runner
+// with support for unbounded splittable DoFns. The direct runner doesn't
+// resume checkpoints, and will drop the remainder of each restriction.
+//
+// Valid values are in the range of [0, ...] and the default value is 0, meaning
+// the step never self-checkpoints.
+func (b *StepConfigBuilder) CheckpointEvery(val int) *StepConfigBuilder {
+	b.cfg.CheckpointEvery = val
+	return b
+}
+
+// OutputRatios is only applicable to steps created with StepWithOutputs, and
+// sets the number of outputs of the step along with the relative chance of
+// each of its outputs being emitted to each output. For example, ratios of
+// 70, 20 and 10 create three outputs, which receive about 70%, 20% and 10% of
+// the step's outputs respectively. The ratios don't need to add up to any
+// particular total.
+//
+// Valid values are non-negative ratios with a positive sum. The default is no
+// ratios, meaning the step has a single output.
+func (b *StepConfigBuilder) OutputRatios(ratios ...float64) *StepConfigBuilder {
+	b.cfg.OutputRatios = ratios
+	return b
+}
+
 // Build constructs the StepConfig initialized by this builder. It also performs
 // error checking on the fields, and panics if any have been set to invalid
 // values.
@@ -245,6 +504,34 @@ func (b *StepConfigBuilder) Build() StepConfig {
 	if b.cfg.OutputPerInput < 0 {
 		panic(fmt.Sprintf("StepConfig.OutputPerInput cannot be negative. Got: %v", b.cfg.OutputPerInput))
 	}
+	if b.cfg.CPUBurnPerElement < 0 {
+		panic(fmt.Sprintf("StepConfig.CPUBurnPerElement cannot be negative. Got: %v", b.cfg.CPUBurnPerElement))
+	}
+	if b.cfg.PerElementDelay < 0 {
+		panic(fmt.Sprintf("StepConfig.PerElementDelay cannot be negative. Got: %v", b.cfg.PerElementDelay))
+	}
+	if b.cfg.OutputValueSize < 0 {
+		panic(fmt.Sprintf("StepConfig.OutputValueSize cannot be negative. Got: %v", b.cfg.OutputValueSize))
+	}
+	if b.cfg.CheckpointEvery < 0 {
+		panic(fmt.Sprintf("StepConfig.CheckpointEvery cannot be negative. Got: %v", b.cfg.CheckpointEvery))
+	}
+	if b.cfg.CheckpointEvery > 0 && !b.cfg.Splittable {
+		panic(fmt.Sprintf("StepConfig.CheckpointEvery requires a Splittable step. Got: %v", b.cfg.CheckpointEvery))
+	}
+	var sum float64
+	for _, ratio := range b.cfg.OutputRatios {
+		if ratio < 0 {
+			panic(fmt.Sprintf("StepConfig.OutputRatios cannot be negative. Got: %v", b.cfg.OutputRatios))
+		}
+		sum += ratio
+	}
+	if len(b.cfg.OutputRatios) > 0 && sum <= 0 {
+		panic(fmt.Sprintf("StepConfig.OutputRatios must have a positive sum. Got: %v", b.cfg.OutputRatios))
+	}
+	if len(b.cfg.OutputRatios) > 1 && b.cfg.Splittable {
+		panic(fmt.Sprintf("StepConfig.OutputRatios requires a non-splittable step. Got: %v", b.cfg.OutputRatios))
+	}
 	return b.cfg
 }
 
@@ -252,8 +539,13 @@ func (b *StepConfigBuilder) Build() StepConfig {
 // synthetic step. It should be created via a StepConfigBuilder, not by directly
 // initializing it (the fields are public to allow encoding).
 type StepConfig struct {
-	OutputPerInput int
-	FilterRatio    float64
-	Splittable     bool
-	InitialSplits  int
+	OutputPerInput    int
+	FilterRatio       float64
+	Splittable        bool
+	InitialSplits     int
+	CPUBurnPerElement time.Duration
+	PerElementDelay   time.Duration
+	OutputValueSize   int
+	CheckpointEvery   int
+	OutputRatios      []float64
 }
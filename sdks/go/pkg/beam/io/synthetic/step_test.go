@@ -16,9 +16,16 @@
 package synthetic
 
 import (
+	"encoding/json"
 	"fmt"
 	"math/rand"
 	"testing"
+	"time"
+
+	"github.com/apache/beam/sdks/v2/go/pkg/beam"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/io/rtrackers/offsetrange"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/testing/passert"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/testing/ptest"
 )
 
 // TestStepConfig_OutputPerInput tests that setting the number of output per
@@ -62,6 +69,172 @@ func TestStepConfig_OutputPerInput(t *testing.T) {
 	}
 }
 
+// TestStepConfig_CPUBurnPerElement tests that synthetic steps spend at least the
+// configured time on the CPU for each output, in both SDF and non-SDF synthetic
+// steps.
+func TestStepConfig_CPUBurnPerElement(t *testing.T) {
+	const outPer, burn = 5, 2 * time.Millisecond
+	want := outPer * burn
+
+	cfg := DefaultStepConfig().OutputPerInput(outPer).CPUBurnPerElement(burn).Build()
+	dfn := stepFn{cfg: cfg}
+	dfn.Setup()
+	elm := []byte{0, 0, 0, 0}
+	start := time.Now()
+	dfn.ProcessElement(elm, elm, func([]byte, []byte) {})
+	if got := time.Since(start); got < want {
+		t.Errorf("stepFn finished too quickly: got: %v, want at least: %v", got, want)
+	}
+
+	cfg = DefaultStepConfig().OutputPerInput(outPer).CPUBurnPerElement(burn).Splittable(true).Build()
+	sdf := sdfStepFn{cfg: cfg}
+	start = time.Now()
+	simulateSdfStepFn(t, &sdf)
+	if got := time.Since(start); got < want {
+		t.Errorf("sdfStepFn finished too quickly: got: %v, want at least: %v", got, want)
+	}
+}
+
+// TestStepConfig_PerElementDelay tests that synthetic steps sleep for the
+// configured delay for each output, in both SDF and non-SDF synthetic steps.
+func TestStepConfig_PerElementDelay(t *testing.T) {
+	const outPer, delay = 4, 5 * time.Millisecond
+	want := outPer * delay
+
+	cfg := DefaultStepConfig().OutputPerInput(outPer).PerElementDelay(delay).Build()
+	dfn := stepFn{cfg: cfg}
+	dfn.Setup()
+	elm := []byte{0, 0, 0, 0}
+	start := time.Now()
+	dfn.ProcessElement(elm, elm, func([]byte, []byte) {})
+	if got := time.Since(start); got < want {
+		t.Errorf("stepFn finished too quickly: got: %v, want at least: %v", got, want)
+	}
+
+	cfg = DefaultStepConfig().OutputPerInput(outPer).PerElementDelay(delay).Splittable(true).Build()
+	sdf := sdfStepFn{cfg: cfg}
+	start = time.Now()
+	simulateSdfStepFn(t, &sdf)
+	if got := time.Since(start); got < want {
+		t.Errorf("sdfStepFn finished too quickly: got: %v, want at least: %v", got, want)
+	}
+}
+
+// TestStepConfig_OutputValueSize tests that synthetic steps emit values of the
+// configured size, in both SDF and non-SDF synthetic steps.
+func TestStepConfig_OutputValueSize(t *testing.T) {
+	tests := []struct {
+		size int
+		want int
+	}{
+		{size: 0, want: 4}, // Unchanged from the input.
+		{size: 2, want: 2},
+		{size: 11, want: 11},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(fmt.Sprintf("(size = %v)", test.size), func(t *testing.T) {
+			cfg := DefaultStepConfig().OutputPerInput(3).OutputValueSize(test.size).Build()
+			dfn := stepFn{cfg: cfg}
+			dfn.Setup()
+			var vals [][]byte
+			elm := []byte{1, 2, 3, 4}
+			dfn.ProcessElement(elm, elm, func(_, val []byte) {
+				vals = append(vals, val)
+			})
+			for _, val := range vals {
+				if got := len(val); got != test.want {
+					t.Errorf("stepFn emitted value of wrong size: got: %v, want: %v", got, test.want)
+				}
+			}
+
+			cfg = DefaultStepConfig().OutputPerInput(3).OutputValueSize(test.size).Splittable(true).Build()
+			sdf := sdfStepFn{cfg: cfg}
+			_, vals = simulateSdfStepFn(t, &sdf)
+			for _, val := range vals {
+				if got := len(val); got != test.want {
+					t.Errorf("sdfStepFn emitted value of wrong size: got: %v, want: %v", got, test.want)
+				}
+			}
+		})
+	}
+}
+
+// TestStep_MultiStage tests a multi-stage synthetic pipeline of a source,
+// several steps, and a sink.
+func TestStep_MultiStage(t *testing.T) {
+	p, s := beam.NewPipelineWithRoot()
+	src := SourceSingle(s, DefaultSourceConfig().NumElements(10).InitialSplits(2).Build())
+	fanOut := Step(s, DefaultStepConfig().OutputPerInput(5).OutputValueSize(32).Build(), src)
+	sdfFanOut := Step(s, DefaultStepConfig().OutputPerInput(2).Splittable(true).InitialSplits(2).Build(), fanOut)
+	passert.Count(s, sdfFanOut, "out", 100)
+	Sink(s, DefaultSinkConfig().Build(), sdfFanOut)
+
+	ptest.RunAndValidate(t, p)
+}
+
+// TestStepWithOutputs tests that a step with multiple outputs emits each of its
+// outputs to exactly one output, in about the configured ratios.
+func TestStepWithOutputs(t *testing.T) {
+	cfg := DefaultStepConfig().OutputPerInput(1000).OutputRatios(70, 20, 10).Build()
+	data, err := json.Marshal(cfg)
+	if err != nil {
+		t.Fatalf("Failed to encode StepConfig: %v", err)
+	}
+	fn := makeFanoutStepFn("fanout", nil, data)
+
+	counts := make([]int, 3)
+	var emits []interface{}
+	for i := range counts {
+		i := i
+		emits = append(emits, func(_, _ []byte) { counts[i]++ })
+	}
+	elm := []byte{0, 0, 0, 0}
+	fn.Call(append([]interface{}{elm, elm}, emits...))
+
+	for i, want := range []int{700, 200, 100} {
+		if got := counts[i]; got < want-60 || got > want+60 {
+			t.Errorf("fanoutStepFn emitted wrong number of outputs to output %v: got: %v, want: about %v", i, got, want)
+		}
+	}
+	if got := counts[0] + counts[1] + counts[2]; got != 1000 {
+		t.Errorf("fanoutStepFn emitted wrong total number of outputs: got: %v, want: 1000", got)
+	}
+
+	t.Run("Pipeline", func(t *testing.T) {
+		p, s := beam.NewPipelineWithRoot()
+		src := SourceSingle(s, DefaultSourceConfig().NumElements(10).Build())
+		outs := StepWithOutputs(s, DefaultStepConfig().OutputPerInput(5).OutputRatios(1, 1).Build(), src)
+		if got := len(outs); got != 2 {
+			t.Fatalf("StepWithOutputs returned wrong number of outputs: got: %v, want: 2", got)
+		}
+		passert.Count(s, beam.Flatten(s, outs...), "out", 50)
+
+		ptest.RunAndValidate(t, p)
+	})
+
+	t.Run("Validation", func(t *testing.T) {
+		tests := []struct {
+			name string
+			cfg  *StepConfigBuilder
+		}{
+			{"NegativeRatio", DefaultStepConfig().OutputRatios(1, -1)},
+			{"ZeroSum", DefaultStepConfig().OutputRatios(0, 0)},
+			{"Splittable", DefaultStepConfig().Splittable(true).OutputRatios(1, 1)},
+		}
+		for _, test := range tests {
+			func() {
+				defer func() {
+					if r := recover(); r == nil {
+						t.Errorf("%v: Build did not panic for OutputRatios %v", test.name, test.cfg.cfg.OutputRatios)
+					}
+				}()
+				test.cfg.Build()
+			}()
+		}
+	})
+}
+
 // fakeRand is a rand.Rand implementation used for testing the filter ratio.
 // It outputs the stored values in their corresponding random methods.
 type fakeRand struct {
@@ -197,6 +370,69 @@ func TestStepConfig_InitialSplits(t *testing.T) {
 	})
 }
 
+// TestStepConfig_CheckpointEvery tests that a self-checkpointing step stops
+// after the configured number of outputs, and that resuming the residuals of
+// its checkpoints emits every output exactly once.
+func TestStepConfig_CheckpointEvery(t *testing.T) {
+	tests := []struct {
+		outPer, every, wantCalls int
+	}{
+		{outPer: 10, every: 3, wantCalls: 4},
+		{outPer: 9, every: 3, wantCalls: 3},
+		{outPer: 2, every: 5, wantCalls: 1},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(fmt.Sprintf("(outPer = %v, every = %v)", test.outPer, test.every), func(t *testing.T) {
+			cfg := DefaultStepConfig().
+				OutputPerInput(test.outPer).
+				Splittable(true).
+				CheckpointEvery(test.every).
+				Build()
+			dfn := checkpointingSdfStepFn{sdfStepFn{cfg: cfg}}
+			dfn.Setup()
+			elm := []byte{0, 0, 0, 0}
+
+			var outputs, calls int
+			emitFn := func(_, _ []byte) { outputs++ }
+			rest := dfn.CreateInitialRestriction(elm, elm)
+			for {
+				calls++
+				before := outputs
+				rt := dfn.CreateTracker(rest)
+				cont := dfn.ProcessElement(rt, elm, elm, emitFn)
+				if got := outputs - before; got > test.every {
+					t.Fatalf("checkpointingSdfStepFn emitted too many outputs before checkpointing: got: %v, want at most: %v", got, test.every)
+				}
+				if !cont.ShouldResume() {
+					break
+				}
+				// Resume from the unclaimed remainder of the restriction.
+				done, remaining := rt.GetProgress()
+				if remaining <= 0 {
+					t.Fatalf("checkpointingSdfStepFn asked to resume a finished restriction %v", rt.GetRestriction())
+				}
+				rest = offsetrange.Restriction{Start: rest.Start + int64(done), End: rest.End}
+			}
+			if outputs != test.outPer {
+				t.Errorf("checkpointingSdfStepFn emitted wrong number of outputs: got: %v, want: %v", outputs, test.outPer)
+			}
+			if calls != test.wantCalls {
+				t.Errorf("checkpointingSdfStepFn processed wrong number of checkpoints: got: %v, want: %v", calls, test.wantCalls)
+			}
+		})
+	}
+
+	t.Run("RequiresSplittable", func(t *testing.T) {
+		defer func() {
+			if r := recover(); r == nil {
+				t.Errorf("Build did not panic for CheckpointEvery on a non-splittable step")
+			}
+		}()
+		DefaultStepConfig().CheckpointEvery(3).Build()
+	})
+}
+
 // simulateSdfStepFn calls CreateInitialRestriction, SplitRestriction,
 // CreateTracker, and ProcessElement on the given sdfStepFn with the given
 // StepConfig, and outputs the resulting output elements. This method isn't
@@ -208,7 +444,7 @@ func simulateSdfStepFn(t *testing.T, sdf *sdfStepFn) (keys [][]byte, vals [][]by
 
 	emitFn := func(key []byte, val []byte) {
 		keys = append(keys, key)
-		vals = append(vals, key)
+		vals = append(vals, val)
 	}
 
 	elm := []byte{0, 0, 0, 0}
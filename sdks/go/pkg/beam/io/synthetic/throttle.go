@@ -0,0 +1,68 @@
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements.  See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License.  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package synthetic
+
+import (
+	"context"
+	"math"
+	"time"
+)
+
+// tokenBucket rate limits a single goroutine to a steady number of events per
+// second. Tokens accumulate at the configured rate up to a small burst, so a
+// brief stall lets the caller catch up without exceeding the rate over longer
+// periods.
+type tokenBucket struct {
+	rate   float64 // Tokens added per second.
+	burst  float64 // Maximum number of tokens held.
+	tokens float64
+	last   time.Time
+}
+
+// maxBurstWindow bounds the burst of a tokenBucket to the events it allows in
+// this window, which keeps emission steady while letting high rates avoid
+// sleeping for every event.
+const maxBurstWindow = 10 * time.Millisecond
+
+// newTokenBucket returns a full token bucket allowing rate events per second.
+func newTokenBucket(rate float64) *tokenBucket {
+	burst := math.Max(1, rate*maxBurstWindow.Seconds())
+	return &tokenBucket{rate: rate, burst: burst, tokens: burst, last: time.Now()}
+}
+
+// wait blocks until a token is available and takes it. If the context is
+// cancelled first, the context's error is returned.
+func (b *tokenBucket) wait(ctx context.Context) error {
+	b.refill()
+	if b.tokens < 1 {
+		delay := time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		b.refill()
+	}
+	b.tokens--
+	return nil
+}
+
+// refill adds the tokens accumulated since the last refill.
+func (b *tokenBucket) refill() {
+	now := time.Now()
+	b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+}
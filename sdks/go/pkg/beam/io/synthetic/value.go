@@ -0,0 +1,83 @@
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements.  See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License.  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package synthetic
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"math"
+)
+
+// ValueMode is an enum of the ways a synthetic source can generate values.
+type ValueMode string
+
+const (
+	// ValueModeRandom generates each value as random bytes.
+	ValueModeRandom ValueMode = "random"
+	// ValueModeVerifiable generates each value with a verifiable structure, so
+	// that corrupted or truncated values can be detected with VerifyValue. Each
+	// value is made of a magic prefix, the element's index as a big-endian
+	// integer, a random payload, and a CRC-32 checksum of everything before it.
+	// Values must be at least VerifiableValueOverhead bytes long.
+	ValueModeVerifiable ValueMode = "verifiable"
+)
+
+// VerifiableValueOverhead is the number of bytes of each value generated with
+// ValueModeVerifiable that are used for its structure rather than its payload.
+const VerifiableValueOverhead = len(verifiableMagic) + 8 + crc32.Size
+
+// verifiableMagic is the prefix of every value generated with
+// ValueModeVerifiable.
+const verifiableMagic = "SYN1"
+
+// putVerifiableValue overwrites the structural bytes of val so that it can be
+// verified with VerifyValue, leaving the payload between them untouched. val
+// must be at least VerifiableValueOverhead bytes long.
+func putVerifiableValue(val []byte, idx int64) {
+	n := copy(val, verifiableMagic)
+	binary.BigEndian.PutUint64(val[n:], uint64(idx))
+	sumAt := len(val) - crc32.Size
+	binary.BigEndian.PutUint32(val[sumAt:], crc32.ChecksumIEEE(val[:sumAt]))
+}
+
+// makeCompressible zeroes the trailing fraction of val, so that val compresses
+// to roughly the remaining fraction of its size.
+func makeCompressible(val []byte, fraction float64) {
+	tail := val[len(val)-int(math.Round(fraction*float64(len(val)))):]
+	for i := range tail {
+		tail[i] = 0
+	}
+}
+
+// VerifyValue checks that val is an intact value generated by a synthetic
+// source using ValueModeVerifiable, and returns an error describing the problem
+// if it has been truncated or corrupted.
+func VerifyValue(val []byte) error {
+	if len(val) < VerifiableValueOverhead {
+		return fmt.Errorf("synthetic value of %v bytes is shorter than the minimum of %v bytes", len(val), VerifiableValueOverhead)
+	}
+	if !bytes.HasPrefix(val, []byte(verifiableMagic)) {
+		return fmt.Errorf("synthetic value has invalid prefix %q, want %q", val[:len(verifiableMagic)], verifiableMagic)
+	}
+	sumAt := len(val) - crc32.Size
+	if got, want := crc32.ChecksumIEEE(val[:sumAt]), binary.BigEndian.Uint32(val[sumAt:]); got != want {
+		idx := int64(binary.BigEndian.Uint64(val[len(verifiableMagic):]))
+		return fmt.Errorf("synthetic value for element %v failed checksum: got: %08x, want: %08x", idx, got, want)
+	}
+	return nil
+}
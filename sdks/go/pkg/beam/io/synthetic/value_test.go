@@ -0,0 +1,143 @@
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements.  See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License.  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package synthetic
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"fmt"
+	"testing"
+)
+
+// TestVerifyValue tests that values generated with ValueModeVerifiable pass
+// VerifyValue, and that corrupted or truncated values fail it.
+func TestVerifyValue(t *testing.T) {
+	const elms = 20
+	cfg := DefaultSourceConfig().
+		NumElements(elms).
+		InitialSplits(3).
+		ValueSize(VerifiableValueOverhead + 16).
+		ValueMode(ValueModeVerifiable).
+		Build()
+	_, vals, err := simulateSourceFn(t, &sourceFn{}, cfg)
+	if err != nil {
+		t.Fatalf("Failure processing sourceFn: %v", err)
+	}
+	if got := len(vals); got != elms {
+		t.Fatalf("SourceFn emitted wrong number of outputs: got: %v, want: %v", got, elms)
+	}
+
+	for i, val := range vals {
+		if err := VerifyValue(val); err != nil {
+			t.Errorf("VerifyValue failed for generated value %v: %v", i, err)
+		}
+		if got := int64(binary.BigEndian.Uint64(val[len(verifiableMagic):])); got != int64(i) {
+			t.Errorf("Generated value embedded wrong element index: got: %v, want: %v", got, i)
+		}
+	}
+
+	for i := range vals[0] {
+		corrupted := append([]byte(nil), vals[0]...)
+		corrupted[i] ^= 0x01
+		if err := VerifyValue(corrupted); err == nil {
+			t.Errorf("VerifyValue succeeded for value with byte %v flipped, want error", i)
+		}
+	}
+	if err := VerifyValue(vals[0][:len(vals[0])-1]); err == nil {
+		t.Errorf("VerifyValue succeeded for truncated value, want error")
+	}
+	if err := VerifyValue(vals[0][:VerifiableValueOverhead-1]); err == nil {
+		t.Errorf("VerifyValue succeeded for value shorter than the overhead, want error")
+	}
+}
+
+// TestSourceConfig_ValueModeValidation tests that Build rejects verifiable
+// values too small to hold their structure.
+func TestSourceConfig_ValueModeValidation(t *testing.T) {
+	defer func() {
+		if r := recover(); r == nil {
+			t.Errorf("Build did not panic for ValueSize smaller than VerifiableValueOverhead")
+		}
+	}()
+	DefaultSourceConfig().ValueSize(VerifiableValueOverhead - 1).ValueMode(ValueModeVerifiable).Build()
+}
+
+// TestSourceConfig_ValueCompressibility tests that values compress to roughly
+// the configured fraction of their size, and that verifiable values stay
+// verifiable.
+func TestSourceConfig_ValueCompressibility(t *testing.T) {
+	const elms, valSize = 20, 4096
+	tests := []struct {
+		compressibility float64
+		mode            ValueMode
+	}{
+		{compressibility: 0, mode: ValueModeRandom},
+		{compressibility: 0.5, mode: ValueModeRandom},
+		{compressibility: 0.8, mode: ValueModeRandom},
+		{compressibility: 0.8, mode: ValueModeVerifiable},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(fmt.Sprintf("(compressibility = %v, mode = %v)", test.compressibility, test.mode), func(t *testing.T) {
+			cfg := DefaultSourceConfig().
+				NumElements(elms).
+				ValueSize(valSize).
+				ValueMode(test.mode).
+				ValueCompressibility(test.compressibility).
+				Build()
+			_, vals, err := simulateSourceFn(t, &sourceFn{}, cfg)
+			if err != nil {
+				t.Fatalf("Failure processing sourceFn: %v", err)
+			}
+
+			var buf bytes.Buffer
+			w, err := flate.NewWriter(&buf, flate.DefaultCompression)
+			if err != nil {
+				t.Fatalf("Failed to create flate writer: %v", err)
+			}
+			for i, val := range vals {
+				if len(val) != valSize {
+					t.Fatalf("SourceFn emitted value of wrong size: got: %v, want: %v", len(val), valSize)
+				}
+				if test.mode == ValueModeVerifiable {
+					if err := VerifyValue(val); err != nil {
+						t.Errorf("VerifyValue failed for generated value %v: %v", i, err)
+					}
+				}
+				w.Write(val)
+			}
+			w.Close()
+
+			// Random bytes don't compress, so the compressed size should be
+			// about the size of the random part of the values.
+			const tolerance = 0.05
+			got := float64(buf.Len()) / float64(elms*valSize)
+			if want := 1 - test.compressibility; got < want-tolerance || got > want+tolerance {
+				t.Errorf("Values compressed to wrong fraction of their size: got: %v, want: %v +/- %v", got, want, tolerance)
+			}
+		})
+	}
+
+	t.Run("Validation", func(t *testing.T) {
+		defer func() {
+			if r := recover(); r == nil {
+				t.Errorf("Build did not panic for ValueCompressibility above 1")
+			}
+		}()
+		DefaultSourceConfig().ValueCompressibility(1.5).Build()
+	})
+}
@@ -0,0 +1,57 @@
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements.  See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License.  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package synthetic
+
+import (
+	"reflect"
+	"time"
+
+	"github.com/apache/beam/sdks/v2/go/pkg/beam"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/core/graph/mtime"
+)
+
+func init() {
+	beam.RegisterType(reflect.TypeOf((*lagWatermarkState)(nil)).Elem())
+}
+
+// lagWatermarkState is the state of a lagWatermarkEstimator, which is kept
+// by the runner across splits and checkpoints.
+type lagWatermarkState struct {
+	MaxTimestamp int64         // Maximum timestamp observed so far, in milliseconds.
+	Lag          time.Duration // Amount the watermark trails MaxTimestamp.
+}
+
+// lagWatermarkEstimator is a watermark estimator that observes the timestamps
+// of emitted elements, and advances the output watermark to the maximum
+// observed timestamp minus a fixed lag. Since the watermark follows the
+// maximum timestamp, it never moves backwards, even if elements are emitted
+// out of order.
+type lagWatermarkEstimator struct {
+	state lagWatermarkState
+}
+
+// CurrentWatermark returns the maximum observed timestamp minus the lag. It is
+// used by the SDK harness to set the DoFn's output watermark on splits and
+// checkpoints.
+func (e *lagWatermarkEstimator) CurrentWatermark() time.Time {
+	return mtime.Normalize(mtime.FromMilliseconds(e.state.MaxTimestamp).Subtract(e.state.Lag)).ToTime()
+}
+
+// ObserveTimestamp updates the maximum observed timestamp. It is invoked by
+// the SDK after each emit.
+func (e *lagWatermarkEstimator) ObserveTimestamp(t time.Time) {
+	e.state.MaxTimestamp = mtime.Max(mtime.FromMilliseconds(e.state.MaxTimestamp), mtime.FromTime(t)).Milliseconds()
+}
@@ -0,0 +1,141 @@
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements.  See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License.  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package synthetic
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/apache/beam/sdks/v2/go/pkg/beam"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/core/graph/mtime"
+)
+
+// TestLagWatermarkEstimator tests that the watermark estimated for a source
+// advances monotonically and trails the maximum emitted timestamp by the
+// configured lag, even when timestamps are emitted out of order.
+func TestLagWatermarkEstimator(t *testing.T) {
+	const lag = 5 * time.Second
+	dfn := sourceFn{}
+	cfg := DefaultSourceConfig().WatermarkLag(lag).Build()
+	start := mtime.FromTime(time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC))
+
+	state := dfn.InitialWatermarkEstimatorState(start, dfn.CreateInitialRestriction(cfg), cfg)
+	we := dfn.CreateWatermarkEstimator(state)
+	if got, want := we.CurrentWatermark(), start.Subtract(lag).ToTime(); !got.Equal(want) {
+		t.Errorf("Initial watermark is wrong: got: %v, want: %v", got, want)
+	}
+
+	offsets := []time.Duration{1, 3, 2, 10, 4, 11}
+	maxTs := start
+	prev := we.CurrentWatermark()
+	for _, offset := range offsets {
+		ts := start.Add(offset * time.Second)
+		we.ObserveTimestamp(ts.ToTime())
+		maxTs = mtime.Max(maxTs, ts)
+
+		got := we.CurrentWatermark()
+		if got.Before(prev) {
+			t.Errorf("Watermark moved backwards after observing %v: got: %v, previous: %v", ts, got, prev)
+		}
+		if want := maxTs.Subtract(lag).ToTime(); !got.Equal(want) {
+			t.Errorf("Watermark is wrong after observing %v: got: %v, want: %v", ts, got, want)
+		}
+		prev = got
+	}
+
+	// The estimator state should resume from where it left off.
+	resumed := dfn.CreateWatermarkEstimator(dfn.WatermarkEstimatorState(we))
+	if got, want := resumed.CurrentWatermark(), we.CurrentWatermark(); !got.Equal(want) {
+		t.Errorf("Resumed watermark is wrong: got: %v, want: %v", got, want)
+	}
+}
+
+// TestLagWatermarkEstimator_MinTimestamp tests that the watermark doesn't fall
+// below the minimum timestamp when the lag is applied to it.
+func TestLagWatermarkEstimator_MinTimestamp(t *testing.T) {
+	we := lagWatermarkEstimator{state: lagWatermarkState{MaxTimestamp: mtime.MinTimestamp.Milliseconds(), Lag: time.Hour}}
+	if got, want := we.CurrentWatermark(), mtime.MinTimestamp.ToTime(); !got.Equal(want) {
+		t.Errorf("Watermark is wrong: got: %v, want: %v", got, want)
+	}
+}
+
+// TestSourceConfig_LateDataFraction tests that the configured fraction of
+// elements is emitted behind the watermark, by the configured lateness.
+func TestSourceConfig_LateDataFraction(t *testing.T) {
+	const elms, fraction, lag = 5000, 0.2, time.Second
+	const minLateness, maxLateness = time.Minute, 2 * time.Minute
+	start := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	cfg := DefaultSourceConfig().
+		NumElements(elms).
+		TimestampMode(TimestampModeMonotonic).
+		StartTimestamp(start).
+		TimestampInterval(time.Second).
+		WatermarkLag(lag).
+		LateDataFraction(fraction).
+		Lateness(minLateness, maxLateness).
+		Build()
+
+	dfn := sourceFn{}
+	rest := dfn.CreateInitialRestriction(cfg)
+	startTs := mtime.FromTime(start)
+	we := dfn.CreateWatermarkEstimator(dfn.InitialWatermarkEstimatorState(startTs, rest, cfg))
+	var i, late int
+	emitFn := func(et beam.EventTime, _, _ []byte) {
+		if et.ToTime().Before(we.CurrentWatermark()) {
+			late++
+			onTime := startTs.Add(time.Duration(i) * time.Second)
+			if lateness := onTime.Subtract(lag).ToTime().Sub(et.ToTime()); lateness < minLateness || lateness > maxLateness {
+				t.Errorf("Late element %v has wrong lateness: got: %v, want: in [%v, %v]", i, lateness, minLateness, maxLateness)
+			}
+		}
+		we.ObserveTimestamp(et.ToTime())
+		i++
+	}
+	if err := dfn.ProcessElement(context.Background(), startTs, dfn.CreateTracker(rest), cfg, emitFn); err != nil {
+		t.Fatalf("Failure processing sourceFn: %v", err)
+	}
+
+	const tolerance = 0.03
+	if got := float64(late) / elms; got < fraction-tolerance || got > fraction+tolerance {
+		t.Errorf("SourceFn emitted wrong fraction of late elements: got: %v, want: %v +/- %v", got, fraction, tolerance)
+	}
+}
+
+// TestSourceConfig_LateDataValidation tests that Build rejects late data
+// configurations that can't produce late elements.
+func TestSourceConfig_LateDataValidation(t *testing.T) {
+	tests := []struct {
+		name string
+		b    *SourceConfigBuilder
+	}{
+		{name: "NoTimestamps", b: DefaultSourceConfig().LateDataFraction(0.1).Lateness(time.Second, time.Second)},
+		{name: "NoLateness", b: DefaultSourceConfig().TimestampMode(TimestampModeMonotonic).LateDataFraction(0.1)},
+		{name: "MinAboveMax", b: DefaultSourceConfig().TimestampMode(TimestampModeMonotonic).LateDataFraction(0.1).Lateness(time.Minute, time.Second)},
+		{name: "FractionAboveOne", b: DefaultSourceConfig().TimestampMode(TimestampModeMonotonic).LateDataFraction(1.5).Lateness(time.Second, time.Second)},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			defer func() {
+				if r := recover(); r == nil {
+					t.Errorf("Build did not panic for invalid config: %#v", test.b.cfg)
+				}
+			}()
+			test.b.Build()
+		})
+	}
+}
@@ -0,0 +1,121 @@
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements.  See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License.  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package textio
+
+import (
+	"compress/bzip2"
+	"compress/gzip"
+	"io"
+	"strings"
+
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/internal/errors"
+	"github.com/klauspost/compress/zstd"
+)
+
+// compressionType is the compression of a text file.
+type compressionType int
+
+const (
+	// compressionAuto detects the compression of a file from its extension.
+	compressionAuto compressionType = iota
+	compressionUncompressed
+	compressionGzip
+	compressionBzip2
+	compressionZstd
+)
+
+// String returns the name of the compression type.
+func (c compressionType) String() string {
+	switch c {
+	case compressionAuto:
+		return "auto"
+	case compressionUncompressed:
+		return "uncompressed"
+	case compressionGzip:
+		return "gzip"
+	case compressionBzip2:
+		return "bzip2"
+	case compressionZstd:
+		return "zstd"
+	default:
+		return "unknown"
+	}
+}
+
+// detect resolves compressionAuto to the compression indicated by the
+// extension of filename. Files with other extensions are uncompressed. Other
+// compression types are returned unchanged.
+func (c compressionType) detect(filename string) compressionType {
+	if c != compressionAuto {
+		return c
+	}
+	switch {
+	case strings.HasSuffix(filename, ".gz"):
+		return compressionGzip
+	case strings.HasSuffix(filename, ".bz2"):
+		return compressionBzip2
+	case strings.HasSuffix(filename, ".zst"), strings.HasSuffix(filename, ".zstd"):
+		return compressionZstd
+	default:
+		return compressionUncompressed
+	}
+}
+
+// newDecompressor wraps r in a reader that decompresses its contents with the
+// compression c, resolved for the file filename. Closing the returned reader
+// does not close r.
+func newDecompressor(c compressionType, filename string, r io.Reader) (io.ReadCloser, error) {
+	switch c.detect(filename) {
+	case compressionUncompressed:
+		return io.NopCloser(r), nil
+	case compressionGzip:
+		return gzip.NewReader(r)
+	case compressionBzip2:
+		return io.NopCloser(bzip2.NewReader(r)), nil
+	case compressionZstd:
+		d, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return d.IOReadCloser(), nil
+	default:
+		return nil, errors.Errorf("unsupported compression %v reading %v", c, filename)
+	}
+}
+
+// newCompressor wraps w in a writer that compresses data written to it with
+// the compression c. Closing the returned writer flushes the compressed data,
+// but does not close w. Only uncompressed and gzip outputs are supported.
+func newCompressor(c compressionType, w io.Writer) (io.WriteCloser, error) {
+	switch c {
+	case compressionAuto, compressionUncompressed:
+		return nopWriteCloser{w}, nil
+	case compressionGzip:
+		return gzip.NewWriter(w), nil
+	default:
+		return nil, errors.Errorf("unsupported compression %v for writing", c)
+	}
+}
+
+// nopWriteCloser is an io.WriteCloser with a no-op Close.
+type nopWriteCloser struct {
+	io.Writer
+}
+
+// Close does nothing.
+func (nopWriteCloser) Close() error {
+	return nil
+}
@@ -0,0 +1,120 @@
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements.  See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License.  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package textio
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/apache/beam/sdks/v2/go/pkg/beam"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/testing/passert"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/testing/ptest"
+	"github.com/klauspost/compress/zstd"
+)
+
+// bzip2Lines is "one\ntwo\n" compressed with bzip2, since the standard library
+// can't write bzip2.
+var bzip2Lines = []byte{
+	0x42, 0x5a, 0x68, 0x39, 0x31, 0x41, 0x59, 0x26, 0x53, 0x59, 0xa7, 0x14,
+	0x2b, 0x77, 0x00, 0x00, 0x02, 0xc1, 0x80, 0x00, 0x10, 0x02, 0x01, 0x84,
+	0x80, 0x20, 0x00, 0x21, 0x80, 0x0c, 0x02, 0x38, 0xf5, 0x1b, 0x8b, 0xb9,
+	0x22, 0x9c, 0x28, 0x48, 0x53, 0x8a, 0x15, 0xbb, 0x80,
+}
+
+func gzipLines(t *testing.T) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write([]byte("one\ntwo\n")); err != nil {
+		t.Fatalf("Failed to gzip lines: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Failed to gzip lines: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func zstdLines(t *testing.T) []byte {
+	t.Helper()
+	w, err := zstd.NewWriter(nil)
+	if err != nil {
+		t.Fatalf("Failed to create zstd writer: %v", err)
+	}
+	defer w.Close()
+	return w.EncodeAll([]byte("one\ntwo\n"), nil)
+}
+
+// TestRead_Compressed tests that Read and ReadSdf decompress files, detecting
+// the compression from the extension or using the one given as an option.
+func TestRead_Compressed(t *testing.T) {
+	tests := []struct {
+		name     string
+		filename string
+		contents []byte
+		opts     []ReadOptionFn
+	}{
+		{name: "Gzip", filename: "lines.txt.gz", contents: gzipLines(t)},
+		{name: "Bzip2", filename: "lines.txt.bz2", contents: bzip2Lines},
+		{name: "Zstd", filename: "lines.txt.zst", contents: zstdLines(t)},
+		{name: "Override", filename: "lines.dat", contents: gzipLines(t), opts: []ReadOptionFn{ReadGzip()}},
+		{name: "Uncompressed", filename: "lines.gz", contents: []byte("one\ntwo\n"), opts: []ReadOptionFn{ReadUncompressed()}},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			filename := filepath.Join(t.TempDir(), test.filename)
+			if err := os.WriteFile(filename, test.contents, 0644); err != nil {
+				t.Fatalf("Failed to write file %v, err: %v", filename, err)
+			}
+
+			p, s := beam.NewPipelineWithRoot()
+			passert.Equals(s, Read(s, filename, test.opts...), "one", "two")
+			passert.Equals(s, ReadSdf(s, filename, test.opts...), "one", "two")
+
+			ptest.RunAndValidate(t, p)
+		})
+	}
+}
+
+// TestWrite_Gzip tests that Write compresses the file with WriteGzip.
+func TestWrite_Gzip(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "text.txt.gz")
+	p, s := beam.NewPipelineWithRoot()
+	Write(s, out, beam.Create(s, "one"), WriteGzip())
+
+	ptest.RunAndValidate(t, p)
+
+	fd, err := os.Open(out)
+	if err != nil {
+		t.Fatalf("Failed to open %v: %v", out, err)
+	}
+	defer fd.Close()
+	rd, err := gzip.NewReader(fd)
+	if err != nil {
+		t.Fatalf("Write() did not write a gzip file: %v", err)
+	}
+	got, err := io.ReadAll(rd)
+	if err != nil {
+		t.Fatalf("Failed to read %v: %v", out, err)
+	}
+	if want := "one\n"; string(got) != want {
+		t.Errorf("Write() wrote the wrong contents. Got: %q Want: %q", got, want)
+	}
+}
@@ -0,0 +1,87 @@
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements.  See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License.  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package textio
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+)
+
+// defaultDelimiter is the delimiter of records when no other is given.
+var defaultDelimiter = []byte{'\n'}
+
+// isSelfOverlapping returns whether a proper prefix of delim is also a suffix
+// of it, such as for "aba". Occurrences of such delimiters can overlap, so
+// where records begin depends on where reading starts.
+func isSelfOverlapping(delim []byte) bool {
+	for i := 1; i < len(delim); i++ {
+		if bytes.HasPrefix(delim, delim[i:]) {
+			return true
+		}
+	}
+	return false
+}
+
+// readRecord reads from rd until the first occurrence of delim, returning the
+// record including the delimiter. If rd ends before a delimiter is found, it
+// returns the data read and io.EOF.
+func readRecord(rd *bufio.Reader, delim []byte) (string, error) {
+	last := delim[len(delim)-1]
+	var record []byte
+	for {
+		chunk, err := rd.ReadBytes(last)
+		record = append(record, chunk...)
+		if err != nil {
+			return string(record), err
+		}
+		if bytes.HasSuffix(record, delim) {
+			return string(record), nil
+		}
+	}
+}
+
+// emitRecords emits all remaining records of rd, without their delimiters.
+func emitRecords(rd *bufio.Reader, delim []byte, emit func(string)) error {
+	for {
+		record, err := readRecord(rd, delim)
+		if err == io.EOF {
+			if len(record) != 0 {
+				emit(record)
+			}
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		emit(record[:len(record)-len(delim)])
+	}
+}
+
+// skipRecords returns an emitter that drops the first n records it is given,
+// and passes the rest to emit.
+func skipRecords(n int, emit func(string)) func(string) {
+	if n <= 0 {
+		return emit
+	}
+	return func(record string) {
+		if n > 0 {
+			n--
+			return
+		}
+		emit(record)
+	}
+}
@@ -0,0 +1,117 @@
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements.  See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License.  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package textio
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/apache/beam/sdks/v2/go/pkg/beam"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/io/rtrackers/offsetrange"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/testing/passert"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/testing/ptest"
+)
+
+func TestIsSelfOverlapping(t *testing.T) {
+	tests := []struct {
+		delim string
+		want  bool
+	}{
+		{delim: "\n", want: false},
+		{delim: "\r\n", want: false},
+		{delim: "||", want: true},
+		{delim: "aba", want: true},
+		{delim: "abc", want: false},
+		{delim: "<EOR>", want: false},
+	}
+	for _, test := range tests {
+		if got := isSelfOverlapping([]byte(test.delim)); got != test.want {
+			t.Errorf("isSelfOverlapping(%q) = %v, want %v", test.delim, got, test.want)
+		}
+	}
+}
+
+// TestRead_Delimiter tests that Read and ReadSdf split records on the given
+// delimiter.
+func TestRead_Delimiter(t *testing.T) {
+	tests := []struct {
+		name     string
+		delim    string
+		contents string
+		want     []interface{}
+	}{
+		{name: "CRLF", delim: "\r\n", contents: "one\r\ntwo\nthree\r\n", want: []interface{}{"one", "two\nthree"}},
+		{name: "Null", delim: "\x00", contents: "one\x00two\x00three", want: []interface{}{"one", "two", "three"}},
+		{name: "MultiByte", delim: "<EOR>", contents: "one<EO<EOR>two>R<EOR>", want: []interface{}{"one<EO", "two>R"}},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			filename := filepath.Join(t.TempDir(), "records.txt")
+			writeTestFile(t, filename, test.contents)
+
+			p, s := beam.NewPipelineWithRoot()
+			passert.Equals(s, Read(s, filename, ReadDelimiter(test.delim)), test.want...)
+			passert.Equals(s, ReadSdf(s, filename, ReadDelimiter(test.delim)), test.want...)
+
+			ptest.RunAndValidate(t, p)
+		})
+	}
+}
+
+// TestReadSdfFn_DelimiterSplits tests that every record is read exactly once
+// no matter where a file is split, including splits in the middle of a
+// multi-byte delimiter.
+func TestReadSdfFn_DelimiterSplits(t *testing.T) {
+	contents := "a<EOR>bb<EOR><EOR>ccc<EOR>d"
+	want := []string{"a", "bb", "", "ccc", "d"}
+	filename := filepath.Join(t.TempDir(), "records.txt")
+	writeTestFile(t, filename, contents)
+	size := int64(len(contents))
+
+	fn := newReadSdfFn(readOption{Delimiter: []byte("<EOR>")})
+	read := func(rest offsetrange.Restriction) []string {
+		var got []string
+		err := fn.ProcessElement(context.Background(), fn.CreateTracker(rest), filename, size, func(record string) {
+			got = append(got, record)
+		})
+		if err != nil {
+			t.Fatalf("readSdfFn.ProcessElement(%v) failed: %v", rest, err)
+		}
+		return got
+	}
+	for split := int64(1); split < size; split++ {
+		got := append(read(offsetrange.Restriction{Start: 0, End: split}), read(offsetrange.Restriction{Start: split, End: size})...)
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("readSdfFn split at %v read wrong records: got: %q, want: %q", split, got, want)
+		}
+	}
+}
+
+func TestReadDelimiter_Invalid(t *testing.T) {
+	for _, delim := range []string{"", "aba"} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("ReadDelimiter(%q) did not panic", delim)
+				}
+			}()
+			ReadDelimiter(delim)
+		}()
+	}
+}
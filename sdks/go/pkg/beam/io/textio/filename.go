@@ -0,0 +1,72 @@
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements.  See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License.  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package textio
+
+import (
+	"context"
+	"reflect"
+
+	"github.com/apache/beam/sdks/v2/go/pkg/beam"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/core/sdf"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/io/filesystem"
+)
+
+func init() {
+	beam.RegisterType(reflect.TypeOf((*readWithFilenameFn)(nil)).Elem())
+}
+
+// ReadWithFilename reads a set of files and returns the lines paired with the
+// name of the file they were read from, as a PCollection<KV<string, string>>.
+// The newlines are not part of the lines. Like ReadSdf, it splits files so
+// they can be read in parallel, and it accepts the same options as Read.
+func ReadWithFilename(s beam.Scope, glob string, opts ...ReadOptionFn) beam.PCollection {
+	s = s.Scope("textio.ReadWithFilename")
+
+	filesystem.ValidateScheme(glob)
+	return readWithFilename(s, beam.Create(s, glob), newReadOption(opts))
+}
+
+// ReadAllWithFilename expands and reads the filenames given as globs by the
+// incoming PCollection<string>. It returns the lines of all files paired with
+// the name of the file they were read from, as a single
+// PCollection<KV<string, string>>. ReadAllWithFilename accepts the same
+// options as Read.
+func ReadAllWithFilename(s beam.Scope, col beam.PCollection, opts ...ReadOptionFn) beam.PCollection {
+	s = s.Scope("textio.ReadAllWithFilename")
+
+	return readWithFilename(s, col, newReadOption(opts))
+}
+
+func readWithFilename(s beam.Scope, col beam.PCollection, o readOption) beam.PCollection {
+	files := beam.ParDo(s, expandFn, col)
+	sized := beam.ParDo(s, sizeFn, files)
+	return beam.ParDo(s, &readWithFilenameFn{readSdfFn: *newReadSdfFn(o)}, sized)
+}
+
+// readWithFilenameFn is a splittable DoFn that reads files the same way as
+// readSdfFn, and only differs in outputting the filename with each line.
+type readWithFilenameFn struct {
+	readSdfFn
+}
+
+// ProcessElement outputs all lines in the file that begin within the paired
+// restriction, each keyed by the filename. See readSdfFn.ProcessElement for
+// how restrictions are read.
+func (fn *readWithFilenameFn) ProcessElement(ctx context.Context, rt *sdf.LockRTracker, filename string, _ int64, emit func(string, string)) error {
+	return fn.read(ctx, rt, filename, func(line string) {
+		emit(filename, line)
+	})
+}
@@ -0,0 +1,52 @@
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements.  See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License.  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package textio
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/apache/beam/sdks/v2/go/pkg/beam"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/testing/passert"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/testing/ptest"
+)
+
+// TestReadWithFilename tests that ReadWithFilename pairs each line with the
+// file it was read from.
+func TestReadWithFilename(t *testing.T) {
+	dir := t.TempDir()
+	a, b := filepath.Join(dir, "a.txt"), filepath.Join(dir, "b.txt")
+	writeTestFile(t, a, "one\ntwo\n")
+	writeTestFile(t, b, "three\n")
+
+	p, s := beam.NewPipelineWithRoot()
+	lines := ReadWithFilename(s, filepath.Join(dir, "*.txt"))
+	formatted := beam.ParDo(s, func(filename, line string) string {
+		return filepath.Base(filename) + ":" + line
+	}, lines)
+	passert.Equals(s, formatted, "a.txt:one", "a.txt:two", "b.txt:three")
+
+	ptest.RunAndValidate(t, p)
+}
+
+func TestReadAllWithFilename(t *testing.T) {
+	p, s, files := ptest.CreateList([]string{testFilePath})
+	lines := ReadAllWithFilename(s, files)
+	filenames := beam.DropValue(s, lines)
+	passert.Equals(s, filenames, testFilePath)
+
+	ptest.RunAndValidate(t, p)
+}
@@ -20,7 +20,6 @@ import (
 	"context"
 	"io"
 	"reflect"
-	"strings"
 
 	"github.com/apache/beam/sdks/v2/go/pkg/beam"
 	"github.com/apache/beam/sdks/v2/go/pkg/beam/core/sdf"
@@ -37,28 +36,31 @@ func init() {
 
 // ReadSdf is a variation of Read implemented via SplittableDoFn. This should
 // result in increased performance with runners that support splitting.
-func ReadSdf(s beam.Scope, glob string) beam.PCollection {
+// Compressed files are read whole, since they can't be split. ReadSdf accepts
+// the same options as Read.
+func ReadSdf(s beam.Scope, glob string, opts ...ReadOptionFn) beam.PCollection {
 	s = s.Scope("textio.ReadSdf")
 
 	filesystem.ValidateScheme(glob)
-	return readSdf(s, beam.Create(s, glob))
+	return readSdf(s, beam.Create(s, glob), newReadOption(opts))
 }
 
 // ReadAllSdf is a variation of ReadAll implemented via SplittableDoFn. This
 // should result in increased performance with runners that support splitting.
-func ReadAllSdf(s beam.Scope, col beam.PCollection) beam.PCollection {
+// ReadAllSdf accepts the same options as Read.
+func ReadAllSdf(s beam.Scope, col beam.PCollection, opts ...ReadOptionFn) beam.PCollection {
 	s = s.Scope("textio.ReadAllSdf")
 
-	return readSdf(s, col)
+	return readSdf(s, col, newReadOption(opts))
 }
 
 // readSdf takes a PCollection of globs and returns a PCollection of lines from
 // all files in those globs. Unlike textio.read, this version uses an SDF for
 // reading files.
-func readSdf(s beam.Scope, col beam.PCollection) beam.PCollection {
+func readSdf(s beam.Scope, col beam.PCollection, o readOption) beam.PCollection {
 	files := beam.ParDo(s, expandFn, col)
 	sized := beam.ParDo(s, sizeFn, files)
-	return beam.ParDo(s, &readSdfFn{}, sized)
+	return beam.ParDo(s, newReadSdfFn(o), sized)
 }
 
 // sizeFn pairs a filename with the size of that file in bytes.
@@ -81,6 +83,20 @@ func sizeFn(ctx context.Context, filename string) (string, int64, error) {
 // readSdfFn reads individual lines from a text file, given a filename and a
 // size in bytes for that file.
 type readSdfFn struct {
+	Compression     compressionType `json:"compression"`
+	Delimiter       []byte          `json:"delimiter"`
+	SkipHeaderLines int             `json:"skip_header_lines"`
+}
+
+func newReadSdfFn(o readOption) *readSdfFn {
+	return &readSdfFn{Compression: o.Compression, Delimiter: o.Delimiter, SkipHeaderLines: o.SkipHeaderLines}
+}
+
+func (fn *readSdfFn) delimiter() []byte {
+	if len(fn.Delimiter) == 0 {
+		return defaultDelimiter
+	}
+	return fn.Delimiter
 }
 
 // CreateInitialRestriction creates an offset range restriction representing
@@ -101,8 +117,12 @@ const (
 )
 
 // SplitRestriction splits each file restriction into blocks of a predeterined
-// size, with some checks to avoid having small remainders.
-func (fn *readSdfFn) SplitRestriction(_ string, _ int64, rest offsetrange.Restriction) []offsetrange.Restriction {
+// size, with some checks to avoid having small remainders. Restrictions of
+// compressed files are not split.
+func (fn *readSdfFn) SplitRestriction(filename string, _ int64, rest offsetrange.Restriction) []offsetrange.Restriction {
+	if fn.Compression.detect(filename) != compressionUncompressed {
+		return []offsetrange.Restriction{rest}
+	}
 	splits := rest.SizedSplits(blockSize)
 	numSplits := len(splits)
 	if numSplits > 1 {
@@ -135,7 +155,21 @@ func (fn *readSdfFn) CreateTracker(rest offsetrange.Restriction) *sdf.LockRTrack
 // begin within the restriction and past the restriction (those are entirely
 // output, including the portion outside the restriction). In some cases a
 // valid restriction might not output any lines.
+//
+// Compressed files can't be read from an offset, so the whole file is output
+// by the restriction starting at 0, and restrictions starting past 0 don't
+// output any lines.
+//
+// Header lines skipped with ReadSkipHeaderLines are not output by any
+// restriction. For uncompressed files, the size of the header is found by
+// reading it from the start of the file.
 func (fn *readSdfFn) ProcessElement(ctx context.Context, rt *sdf.LockRTracker, filename string, _ int64, emit func(string)) error {
+	return fn.read(ctx, rt, filename, emit)
+}
+
+// read outputs all lines in the file that begin within the tracker's
+// restriction. See ProcessElement for details.
+func (fn *readSdfFn) read(ctx context.Context, rt *sdf.LockRTracker, filename string, emit func(string)) error {
 	log.Infof(ctx, "Reading from %v", filename)
 
 	fs, err := filesystem.New(ctx, filename)
@@ -150,26 +184,43 @@ func (fn *readSdfFn) ProcessElement(ctx context.Context, rt *sdf.LockRTracker, f
 	}
 	defer fd.Close()
 
+	if fn.Compression.detect(filename) != compressionUncompressed {
+		return fn.readCompressed(rt, filename, fd, skipRecords(fn.SkipHeaderLines, emit))
+	}
+
 	rd := bufio.NewReader(fd)
+	delim := fn.delimiter()
+
+	var headerEnd int64
+	if fn.SkipHeaderLines > 0 {
+		if headerEnd, err = fn.headerSize(ctx, fs, filename); err != nil {
+			return err
+		}
+	}
 
 	i := rt.GetRestriction().(offsetrange.Restriction).Start
 	if i > 0 {
 		// If restriction's starts after 0, we cannot assume a new line starts
 		// at the beginning of the restriction, so we must search for the first
 		// line beginning at or after restriction.Start. This is done by
-		// scanning to the byte just before the restriction and then reading
-		// until the next newline, leaving the reader at the start of a new
-		// line past restriction.Start.
-		i -= 1
+		// scanning to the delimiter's length before the restriction and then
+		// reading until the next delimiter, leaving the reader at the start of
+		// a new line past restriction.Start. Since delimiters can't overlap,
+		// a delimiter found this way ends at or after restriction.Start.
+		start := i
+		i -= int64(len(delim))
+		if i < 0 {
+			i = 0
+		}
 		n, err := rd.Discard(int(i)) // Scan to just before restriction.
 		if err == io.EOF {
 			return errors.Errorf("TextIO restriction lies outside the file being read. "+
-				"Restriction begins at %v bytes, but file is only %v bytes.", i+1, n)
+				"Restriction begins at %v bytes, but file is only %v bytes.", start, n)
 		}
 		if err != nil {
 			return err
 		}
-		line, err := rd.ReadString('\n') // Read until the first line within the restriction.
+		line, err := readRecord(rd, delim) // Read until the first line within the restriction.
 		if err == io.EOF {
 			// No lines start in the restriction but it's still valid, so
 			// finish claiming before returning to avoid errors.
@@ -184,10 +235,10 @@ func (fn *readSdfFn) ProcessElement(ctx context.Context, rt *sdf.LockRTracker, f
 
 	// Claim each line until we claim a line outside the restriction.
 	for rt.TryClaim(i) {
-		line, err := rd.ReadString('\n')
+		line, err := readRecord(rd, delim)
 		if err == io.EOF {
-			if len(line) != 0 {
-				emit(strings.TrimSuffix(line, "\n"))
+			if len(line) != 0 && i >= headerEnd {
+				emit(line)
 			}
 			// Finish claiming restriction before breaking to avoid errors.
 			rt.TryClaim(rt.GetRestriction().(offsetrange.Restriction).End)
@@ -196,8 +247,54 @@ func (fn *readSdfFn) ProcessElement(ctx context.Context, rt *sdf.LockRTracker, f
 		if err != nil {
 			return err
 		}
-		emit(strings.TrimSuffix(line, "\n"))
+		if i >= headerEnd {
+			emit(line[:len(line)-len(delim)])
+		}
 		i += int64(len(line))
 	}
 	return nil
 }
+
+// headerSize returns the size in bytes of the header lines at the start of an
+// uncompressed file.
+func (fn *readSdfFn) headerSize(ctx context.Context, fs filesystem.Interface, filename string) (int64, error) {
+	fd, err := fs.OpenRead(ctx, filename)
+	if err != nil {
+		return 0, err
+	}
+	defer fd.Close()
+
+	rd := bufio.NewReader(fd)
+	var size int64
+	for n := 0; n < fn.SkipHeaderLines; n++ {
+		line, err := readRecord(rd, fn.delimiter())
+		size += int64(len(line))
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+	return size, nil
+}
+
+// readCompressed outputs all lines of a compressed file if the restriction
+// starts at 0, and claims the rest of the restriction.
+func (fn *readSdfFn) readCompressed(rt *sdf.LockRTracker, filename string, fd io.Reader, emit func(string)) error {
+	rest := rt.GetRestriction().(offsetrange.Restriction)
+	if rest.Start == 0 && rt.TryClaim(rest.Start) {
+		rd, err := newDecompressor(fn.Compression, filename, fd)
+		if err != nil {
+			return err
+		}
+		defer rd.Close()
+
+		if err := emitRecords(bufio.NewReader(rd), fn.delimiter(), emit); err != nil {
+			return err
+		}
+	}
+	// Finish claiming restriction to avoid errors.
+	rt.TryClaim(rt.GetRestriction().(offsetrange.Restriction).End)
+	return nil
+}
@@ -0,0 +1,97 @@
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements.  See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License.  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package textio
+
+import (
+	"context"
+	"math/rand"
+	"reflect"
+
+	"github.com/apache/beam/sdks/v2/go/pkg/beam"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/io/fileio"
+)
+
+func init() {
+	beam.RegisterType(reflect.TypeOf((*assignShardFn)(nil)).Elem())
+	beam.RegisterType(reflect.TypeOf((*shardKeysFn)(nil)).Elem())
+	beam.RegisterType(reflect.TypeOf((*writeShardFn)(nil)).Elem())
+}
+
+// DefaultShardTemplate is the shard template used by Write with
+// WriteNumShards, unless another is set with WriteShardTemplate. For example,
+// the second of ten shards of "out" is named "out-00001-of-00010".
+const DefaultShardTemplate = fileio.DefaultShardTemplate
+
+// writeSharded writes the lines of col to o.NumShards files, named by
+// fileio.ShardName. The lines are grouped with an entry for every shard, so
+// that shards without lines are written as well.
+func writeSharded(s beam.Scope, prefix string, col beam.PCollection, o writeOption) {
+	keyed := beam.ParDo(s, &assignShardFn{NumShards: o.NumShards}, col)
+	shards := beam.ParDo(s, &shardKeysFn{NumShards: o.NumShards}, beam.Impulse(s))
+	grouped := beam.CoGroupByKey(s, keyed, shards)
+	beam.ParDo0(s, &writeShardFn{
+		Prefix:      prefix,
+		Template:    o.ShardTemplate,
+		Suffix:      o.Suffix,
+		NumShards:   o.NumShards,
+		Compression: o.Compression,
+		Header:      o.Header,
+	}, grouped)
+}
+
+// assignShardFn keys each line by the shard it is written to. Shards are
+// assigned in round robin order, starting from a random shard in each bundle
+// so that small bundles don't all favor the first shards.
+type assignShardFn struct {
+	NumShards int `json:"num_shards"`
+
+	next int
+}
+
+func (fn *assignShardFn) StartBundle(_ func(int, string)) {
+	fn.next = rand.Intn(fn.NumShards)
+}
+
+func (fn *assignShardFn) ProcessElement(line string, emit func(int, string)) {
+	emit(fn.next, line)
+	fn.next = (fn.next + 1) % fn.NumShards
+}
+
+// shardKeysFn emits an entry for every shard, so that every shard is written.
+type shardKeysFn struct {
+	NumShards int `json:"num_shards"`
+}
+
+func (fn *shardKeysFn) ProcessElement(_ []byte, emit func(int, int)) {
+	for i := 0; i < fn.NumShards; i++ {
+		emit(i, i)
+	}
+}
+
+// writeShardFn writes the lines of a shard to the file named for it.
+type writeShardFn struct {
+	Prefix      string          `json:"prefix"`
+	Template    string          `json:"template"`
+	Suffix      string          `json:"suffix"`
+	NumShards   int             `json:"num_shards"`
+	Compression compressionType `json:"compression"`
+	Header      string          `json:"header"`
+}
+
+func (w *writeShardFn) ProcessElement(ctx context.Context, shard int, lines func(*string) bool, _ func(*int) bool) error {
+	filename := fileio.ShardName(w.Prefix, w.Template, w.Suffix, shard, w.NumShards)
+	return writeFile(ctx, filename, w.Compression, w.Header, lines)
+}
@@ -0,0 +1,56 @@
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements.  See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License.  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package textio
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/apache/beam/sdks/v2/go/pkg/beam"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/testing/ptest"
+	"github.com/google/go-cmp/cmp"
+)
+
+// TestWrite_Sharded tests that Write writes every shard, including empty
+// ones, each with the header followed by its share of the lines.
+func TestWrite_Sharded(t *testing.T) {
+	prefix := filepath.Join(t.TempDir(), "out")
+	p, s := beam.NewPipelineWithRoot()
+	lines := beam.Create(s, "a", "b", "c")
+	Write(s, prefix, lines, WriteNumShards(4), WriteShardTemplate("-SS"), WriteSuffix(".csv"), WriteHeader("letter"))
+
+	ptest.RunAndValidate(t, p)
+
+	var got []string
+	for _, shard := range []string{"-00", "-01", "-02", "-03"} {
+		contents, err := os.ReadFile(prefix + shard + ".csv")
+		if err != nil {
+			t.Fatalf("Write() did not write shard %v: %v", shard, err)
+		}
+		shardLines := strings.Split(strings.TrimSuffix(string(contents), "\n"), "\n")
+		if shardLines[0] != "letter" {
+			t.Errorf("Write() shard %v starts with %q, want header %q", shard, shardLines[0], "letter")
+		}
+		got = append(got, shardLines[1:]...)
+	}
+	sort.Strings(got)
+	if diff := cmp.Diff([]string{"a", "b", "c"}, got); diff != "" {
+		t.Errorf("Write() wrote the wrong lines across shards (-want, +got):\n%v", diff)
+	}
+}
@@ -0,0 +1,52 @@
+// Licensed to the Apache Software Foundation (ASF) under one or more
+// contributor license agreements.  See the NOTICE file distributed with
+// this work for additional information regarding copyright ownership.
+// The ASF licenses this file to You under the Apache License, Version 2.0
+// (the "License"); you may not use this file except in compliance with
+// the License.  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package textio
+
+import (
+	"time"
+
+	"github.com/apache/beam/sdks/v2/go/pkg/beam"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/io/fileio"
+)
+
+func init() {
+	beam.RegisterFunction(metadataSizeFn)
+}
+
+// ReadStream continuously watches a glob and returns the lines of every file
+// that matches it as a PCollection<string>. The glob is polled every interval
+// until end, and each file is read once, when it is first matched. The
+// newlines are not part of the lines. A zero end watches the glob forever.
+//
+// The files are found with fileio.MatchContinuously, so the lines of a file
+// have the time of the poll that matched it as their event time, and files
+// must be complete when they first match the glob. See MatchContinuously for
+// details on watermarks and runner support.
+//
+// ReadStream accepts the same options as Read.
+func ReadStream(s beam.Scope, glob string, interval time.Duration, end time.Time, opts ...ReadOptionFn) beam.PCollection {
+	s = s.Scope("textio.ReadStream")
+
+	files := fileio.MatchContinuously(s, glob, interval, end)
+	sized := beam.ParDo(s, metadataSizeFn, files)
+	return beam.ParDo(s, newReadSdfFn(newReadOption(opts)), sized)
+}
+
+// metadataSizeFn pairs a filename with the size of that file in bytes, taken
+// from its metadata.
+func metadataSizeFn(m fileio.FileMetadata) (string, int64) {
+	return m.Path, m.Size
+}
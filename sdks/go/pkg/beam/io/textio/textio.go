@@ -19,7 +19,7 @@ package textio
 import (
 	"bufio"
 	"context"
-	"io"
+	"fmt"
 	"os"
 	"reflect"
 	"strings"
@@ -30,32 +30,123 @@ import (
 )
 
 func init() {
+	beam.RegisterType(reflect.TypeOf((*readFn)(nil)).Elem())
 	beam.RegisterType(reflect.TypeOf((*writeFileFn)(nil)).Elem())
-	beam.RegisterFunction(readFn)
 	beam.RegisterFunction(expandFn)
 }
 
+// readOption holds the options of the read transforms.
+type readOption struct {
+	Compression     compressionType
+	Delimiter       []byte
+	SkipHeaderLines int
+}
+
+// ReadOptionFn is an option for the read transforms, such as Read and
+// ReadSdf.
+type ReadOptionFn func(*readOption)
+
+// ReadAutoCompression detects the compression of each file from its
+// extension: .gz files are read as gzip, .bz2 files as bzip2, .zst and .zstd
+// files as zstd, and other files as uncompressed. This is the default.
+func ReadAutoCompression() ReadOptionFn {
+	return func(o *readOption) {
+		o.Compression = compressionAuto
+	}
+}
+
+// ReadUncompressed reads all files as uncompressed, regardless of extension.
+func ReadUncompressed() ReadOptionFn {
+	return func(o *readOption) {
+		o.Compression = compressionUncompressed
+	}
+}
+
+// ReadGzip reads all files as gzip compressed, regardless of extension.
+func ReadGzip() ReadOptionFn {
+	return func(o *readOption) {
+		o.Compression = compressionGzip
+	}
+}
+
+// ReadBzip2 reads all files as bzip2 compressed, regardless of extension.
+func ReadBzip2() ReadOptionFn {
+	return func(o *readOption) {
+		o.Compression = compressionBzip2
+	}
+}
+
+// ReadZstd reads all files as zstd compressed, regardless of extension.
+func ReadZstd() ReadOptionFn {
+	return func(o *readOption) {
+		o.Compression = compressionZstd
+	}
+}
+
+// ReadDelimiter splits files into records on every occurrence of delim,
+// instead of on newlines. The delimiter is not part of the records. For
+// example, ReadDelimiter("\r\n") reads files with Windows line endings, and
+// ReadDelimiter("\x00") reads null-separated records.
+//
+// The delimiter must not be empty or self-overlapping, meaning that no proper
+// prefix of it may also be a suffix, such as for "aba". Otherwise where
+// records begin would depend on where reading a file starts, which breaks
+// splitting files in ReadSdf.
+func ReadDelimiter(delim string) ReadOptionFn {
+	if delim == "" {
+		panic("textio.ReadDelimiter delimiter must not be empty")
+	}
+	if isSelfOverlapping([]byte(delim)) {
+		panic(fmt.Sprintf("textio.ReadDelimiter delimiter must not be self-overlapping. Got: %q", delim))
+	}
+	return func(o *readOption) {
+		o.Delimiter = []byte(delim)
+	}
+}
+
+// ReadSkipHeaderLines skips the first n lines of every file, such as the
+// header of a CSV file.
+func ReadSkipHeaderLines(n int) ReadOptionFn {
+	if n < 0 {
+		panic(fmt.Sprintf("textio.ReadSkipHeaderLines number of lines must not be negative. Got: %v", n))
+	}
+	return func(o *readOption) {
+		o.SkipHeaderLines = n
+	}
+}
+
+func newReadOption(opts []ReadOptionFn) readOption {
+	var o readOption
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
 // Read reads a set of file and returns the lines as a PCollection<string>. The
-// newlines are not part of the lines.
-func Read(s beam.Scope, glob string) beam.PCollection {
+// newlines are not part of the lines. Files can be split on other delimiters
+// with ReadDelimiter. Compressed files are decompressed based
+// on their extension, unless overridden by an option such as ReadGzip.
+func Read(s beam.Scope, glob string, opts ...ReadOptionFn) beam.PCollection {
 	s = s.Scope("textio.Read")
 
 	filesystem.ValidateScheme(glob)
-	return read(s, beam.Create(s, glob))
+	return read(s, beam.Create(s, glob), newReadOption(opts))
 }
 
 // ReadAll expands and reads the filename given as globs by the incoming
 // PCollection<string>. It returns the lines of all files as a single
-// PCollection<string>. The newlines are not part of the lines.
-func ReadAll(s beam.Scope, col beam.PCollection) beam.PCollection {
+// PCollection<string>. The newlines are not part of the lines. ReadAll accepts
+// the same options as Read.
+func ReadAll(s beam.Scope, col beam.PCollection, opts ...ReadOptionFn) beam.PCollection {
 	s = s.Scope("textio.ReadAll")
 
-	return read(s, col)
+	return read(s, col, newReadOption(opts))
 }
 
-func read(s beam.Scope, col beam.PCollection) beam.PCollection {
+func read(s beam.Scope, col beam.PCollection, o readOption) beam.PCollection {
 	files := beam.ParDo(s, expandFn, col)
-	return beam.ParDo(s, readFn, files)
+	return beam.ParDo(s, &readFn{Compression: o.Compression, Delimiter: o.Delimiter, SkipHeaderLines: o.SkipHeaderLines}, files)
 }
 
 func expandFn(ctx context.Context, glob string, emit func(string)) error {
@@ -79,7 +170,21 @@ func expandFn(ctx context.Context, glob string, emit func(string)) error {
 	return nil
 }
 
-func readFn(ctx context.Context, filename string, emit func(string)) error {
+// readFn reads the lines of a file, decompressing it if needed.
+type readFn struct {
+	Compression     compressionType `json:"compression"`
+	Delimiter       []byte          `json:"delimiter"`
+	SkipHeaderLines int             `json:"skip_header_lines"`
+}
+
+func (fn *readFn) delimiter() []byte {
+	if len(fn.Delimiter) == 0 {
+		return defaultDelimiter
+	}
+	return fn.Delimiter
+}
+
+func (fn *readFn) ProcessElement(ctx context.Context, filename string, emit func(string)) error {
 	log.Infof(ctx, "Reading from %v", filename)
 
 	fs, err := filesystem.New(ctx, filename)
@@ -94,33 +199,93 @@ func readFn(ctx context.Context, filename string, emit func(string)) error {
 	}
 	defer fd.Close()
 
-	rd := bufio.NewReader(fd)
-	for {
-		line, err := rd.ReadString('\n')
-		if err == io.EOF {
-			if len(line) != 0 {
-				emit(strings.TrimSuffix(line, "\n"))
-			}
-			break
-		}
-		if err != nil {
-			return err
-		}
-		emit(strings.TrimSuffix(line, "\n"))
+	rd, err := newDecompressor(fn.Compression, filename, fd)
+	if err != nil {
+		return err
+	}
+	defer rd.Close()
+
+	return emitRecords(bufio.NewReader(rd), fn.delimiter(), skipRecords(fn.SkipHeaderLines, emit))
+}
+
+// writeOption holds the options of Write.
+type writeOption struct {
+	Compression   compressionType
+	NumShards     int
+	ShardTemplate string
+	Suffix        string
+	Header        string
+}
+
+// WriteOptionFn is an option for Write.
+type WriteOptionFn func(*writeOption)
+
+// WriteGzip compresses the written file with gzip.
+func WriteGzip() WriteOptionFn {
+	return func(o *writeOption) {
+		o.Compression = compressionGzip
+	}
+}
+
+// WriteNumShards writes the lines to n files, or shards, instead of a single
+// file. The filename given to Write is then used as the prefix of the shards'
+// names, which are completed by the shard template and suffix. Every shard is
+// written, even if it has no lines. Lines are assigned to shards in round
+// robin order, starting from a random shard in each bundle.
+func WriteNumShards(n int) WriteOptionFn {
+	if n < 1 {
+		panic(fmt.Sprintf("textio.WriteNumShards number of shards must be positive. Got: %v", n))
+	}
+	return func(o *writeOption) {
+		o.NumShards = n
+	}
+}
+
+// WriteShardTemplate sets the template naming each shard, which follows the
+// filename prefix and precedes the suffix. See fileio.ShardName for how the
+// template is expanded. The default template is DefaultShardTemplate. Only
+// used with WriteNumShards.
+func WriteShardTemplate(template string) WriteOptionFn {
+	return func(o *writeOption) {
+		o.ShardTemplate = template
 	}
-	return nil
 }
 
-// TODO(herohde) 7/12/2017: extend Write to write to a series of files
-// as well as allow sharding.
+// WriteSuffix sets the suffix of each shard's name, such as ".txt". Only used
+// with WriteNumShards.
+func WriteSuffix(suffix string) WriteOptionFn {
+	return func(o *writeOption) {
+		o.Suffix = suffix
+	}
+}
+
+// WriteHeader writes the header as the first line of every file, such as the
+// column names of a CSV file. An empty header writes no header line.
+func WriteHeader(header string) WriteOptionFn {
+	return func(o *writeOption) {
+		o.Header = header
+	}
+}
 
 // Write writes a PCollection<string> to a file as separate lines. The
-// writer add a newline after each element.
-func Write(s beam.Scope, filename string, col beam.PCollection) {
+// writer add a newline after each element. The file is uncompressed, unless
+// compression is requested by an option such as WriteGzip. With
+// WriteNumShards, the lines are instead written to several files, using
+// filename as the prefix of their names.
+func Write(s beam.Scope, filename string, col beam.PCollection, opts ...WriteOptionFn) {
 	s = s.Scope("textio.Write")
 
 	filesystem.ValidateScheme(filename)
 
+	o := writeOption{ShardTemplate: DefaultShardTemplate}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.NumShards > 0 {
+		writeSharded(s, filename, col, o)
+		return
+	}
+
 	// NOTE(BEAM-3579): We may never call Teardown for non-local runners and
 	// FinishBundle doesn't have the right granularity. We therefore
 	// perform a GBK with a fixed key to get all values in a single invocation.
@@ -129,27 +294,48 @@ func Write(s beam.Scope, filename string, col beam.PCollection) {
 
 	pre := beam.AddFixedKey(s, col)
 	post := beam.GroupByKey(s, pre)
-	beam.ParDo0(s, &writeFileFn{Filename: filename}, post)
+	beam.ParDo0(s, &writeFileFn{Filename: filename, Compression: o.Compression, Header: o.Header}, post)
 }
 
 type writeFileFn struct {
-	Filename string `json:"filename"`
+	Filename    string          `json:"filename"`
+	Compression compressionType `json:"compression"`
+	Header      string          `json:"header"`
 }
 
 func (w *writeFileFn) ProcessElement(ctx context.Context, _ int, lines func(*string) bool) error {
-	fs, err := filesystem.New(ctx, w.Filename)
+	return writeFile(ctx, w.Filename, w.Compression, w.Header, lines)
+}
+
+// writeFile writes the lines to a file, preceded by the header if it isn't
+// empty, and compressed with the given compression.
+func writeFile(ctx context.Context, filename string, compression compressionType, header string, lines func(*string) bool) error {
+	fs, err := filesystem.New(ctx, filename)
 	if err != nil {
 		return err
 	}
 	defer fs.Close()
 
-	fd, err := fs.OpenWrite(ctx, w.Filename)
+	fd, err := fs.OpenWrite(ctx, filename)
+	if err != nil {
+		return err
+	}
+	cw, err := newCompressor(compression, fd)
 	if err != nil {
 		return err
 	}
-	buf := bufio.NewWriterSize(fd, 1<<20) // use 1MB buffer
+	buf := bufio.NewWriterSize(cw, 1<<20) // use 1MB buffer
 
-	log.Infof(ctx, "Writing to %v", w.Filename)
+	log.Infof(ctx, "Writing to %v", filename)
+
+	if header != "" {
+		if _, err := buf.WriteString(header); err != nil {
+			return err
+		}
+		if _, err := buf.Write([]byte{'\n'}); err != nil {
+			return err
+		}
+	}
 
 	var line string
 	for lines(&line) {
@@ -164,6 +350,9 @@ func (w *writeFileFn) ProcessElement(ctx context.Context, _ int, lines func(*str
 	if err := buf.Flush(); err != nil {
 		return err
 	}
+	if err := cw.Close(); err != nil {
+		return err
+	}
 	return fd.Close()
 }
 
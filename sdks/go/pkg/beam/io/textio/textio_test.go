@@ -20,10 +20,13 @@ import (
 	"context"
 	"errors"
 	"os"
+	"path/filepath"
+	"reflect"
 	"testing"
 
 	"github.com/apache/beam/sdks/v2/go/pkg/beam"
 	_ "github.com/apache/beam/sdks/v2/go/pkg/beam/io/filesystem/local"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/io/rtrackers/offsetrange"
 	"github.com/apache/beam/sdks/v2/go/pkg/beam/testing/passert"
 	"github.com/apache/beam/sdks/v2/go/pkg/beam/testing/ptest"
 )
@@ -36,7 +39,7 @@ func TestReadFn(t *testing.T) {
 		receivedLines = append(receivedLines, line)
 	}
 
-	err := readFn(context.Background(), testFilePath, getLines)
+	err := (&readFn{}).ProcessElement(context.Background(), testFilePath, getLines)
 	if err != nil {
 		t.Fatalf("failed with %v", err)
 	}
@@ -85,6 +88,50 @@ func TestWrite(t *testing.T) {
 	}
 }
 
+// TestRead_SkipHeaderLines tests that Read and ReadSdf skip the header lines
+// of every file.
+func TestRead_SkipHeaderLines(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, filepath.Join(dir, "a.csv"), "name\nvalue\none\ntwo\n")
+	writeTestFile(t, filepath.Join(dir, "b.csv"), "name\nvalue\nthree")
+	writeTestFile(t, filepath.Join(dir, "c.csv"), "name\n")
+
+	p, s := beam.NewPipelineWithRoot()
+	glob := filepath.Join(dir, "*.csv")
+	passert.Equals(s, Read(s, glob, ReadSkipHeaderLines(2)), "one", "two", "three")
+	passert.Equals(s, ReadSdf(s, glob, ReadSkipHeaderLines(2)), "one", "two", "three")
+
+	ptest.RunAndValidate(t, p)
+}
+
+// TestReadSdfFn_SkipHeaderLinesSplits tests that header lines are skipped no
+// matter where a file is split.
+func TestReadSdfFn_SkipHeaderLinesSplits(t *testing.T) {
+	contents := "h1\nh2\none\ntwo\n"
+	want := []string{"one", "two"}
+	filename := filepath.Join(t.TempDir(), "lines.txt")
+	writeTestFile(t, filename, contents)
+	size := int64(len(contents))
+
+	fn := newReadSdfFn(readOption{SkipHeaderLines: 2})
+	read := func(rest offsetrange.Restriction) []string {
+		var got []string
+		err := fn.ProcessElement(context.Background(), fn.CreateTracker(rest), filename, size, func(line string) {
+			got = append(got, line)
+		})
+		if err != nil {
+			t.Fatalf("readSdfFn.ProcessElement(%v) failed: %v", rest, err)
+		}
+		return got
+	}
+	for split := int64(1); split < size; split++ {
+		got := append(read(offsetrange.Restriction{Start: 0, End: split}), read(offsetrange.Restriction{Start: split, End: size})...)
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("readSdfFn split at %v read wrong lines: got: %q, want: %q", split, got, want)
+		}
+	}
+}
+
 func TestImmediate(t *testing.T) {
 	f, err := os.CreateTemp("", "test2.txt")
 	if err != nil {
@@ -106,3 +153,10 @@ func TestImmediate(t *testing.T) {
 
 	ptest.RunAndValidate(t, p)
 }
+
+func writeTestFile(t *testing.T, filename, contents string) {
+	t.Helper()
+	if err := os.WriteFile(filename, []byte(contents), 0644); err != nil {
+		t.Fatalf("Failed to write file %v, err: %v", filename, err)
+	}
+}
@@ -45,6 +45,7 @@ package kafkaio
 // deserializers.
 
 import (
+	"fmt"
 	"reflect"
 
 	"github.com/apache/beam/sdks/v2/go/pkg/beam"
@@ -96,7 +97,8 @@ var autoStartupAddress string = xlangx.UseAutomatedJavaExpansionService(serviceG
 //
 // Read requires the address for an expansion service for Kafka Read transforms,
 // a comma-seperated list of bootstrap server addresses (see the Kafka property
-// "bootstrap.servers" for details), and at least one topic to read from.
+// "bootstrap.servers" for details), and at least one topic to read from, unless
+// topics are instead matched with the TopicPattern option.
 // If an expansion service address is provided as "", an appropriate expansion
 // service will be automatically started; however this is slower than having a
 // persistent expansion service running.
@@ -115,10 +117,6 @@ var autoStartupAddress string = xlangx.UseAutomatedJavaExpansionService(serviceG
 func Read(s beam.Scope, addr string, servers string, topics []string, opts ...readOption) beam.PCollection {
 	s = s.Scope("kafkaio.Read")
 
-	if len(topics) == 0 {
-		panic("kafkaio.Read requires at least one topic to read from.")
-	}
-
 	if addr == "" {
 		addr = autoStartupAddress
 	}
@@ -139,6 +137,16 @@ func Read(s beam.Scope, addr string, servers string, topics []string, opts ...re
 		opt(&rcfg)
 	}
 
+	if len(topics) == 0 && rpl.TopicPattern == nil {
+		panic("kafkaio.Read requires at least one topic to read from, or a topic pattern.")
+	}
+	if len(topics) > 0 && rpl.TopicPattern != nil {
+		panic("kafkaio.Read requires either topics or a topic pattern, not both.")
+	}
+	if rpl.CommitOffsetInFinalize && rpl.ConsumerConfig["group.id"] == "" {
+		panic("kafkaio.Read requires a \"group.id\" consumer config to commit offsets in finalize.")
+	}
+
 	pl := beam.CrossLanguagePayload(rpl)
 	outT := beam.UnnamedOutput(typex.NewKV(typex.New(rcfg.key), typex.New(rcfg.val)))
 	out := beam.CrossLanguage(s, readURN, pl, addr, nil, outT)
@@ -166,6 +174,39 @@ func ConsumerConfigs(cfgs map[string]string) readOption {
 	}
 }
 
+// TopicPattern is a Read option that specifies a regular expression, in Java
+// syntax, matching the topics to read from, instead of a list of topics. The
+// matching topics are resolved when the pipeline starts, unless DynamicReadSecs
+// is also set, in which case topics created later are read too.
+//
+// Topic patterns are set with the topicPattern field of the Java transform's
+// configuration, so expansion services whose KafkaIO lacks it reject them.
+func TopicPattern(pattern string) readOption {
+	if pattern == "" {
+		panic("kafkaio.TopicPattern requires a non-empty pattern.")
+	}
+	return func(cfg *readConfig) {
+		cfg.pl.TopicPattern = &pattern
+	}
+}
+
+// DynamicReadSecs is a Read option that specifies an interval in seconds at
+// which the transform discovers new partitions of its topics, and new topics
+// matching its TopicPattern, and starts reading them. By default, only the
+// partitions present when the pipeline starts are read.
+//
+// The interval is set with the dynamicReadPollIntervalSeconds field of the
+// Java transform's configuration, so expansion services whose KafkaIO lacks it
+// reject it.
+func DynamicReadSecs(secs int64) readOption {
+	if secs <= 0 {
+		panic(fmt.Sprintf("kafkaio.DynamicReadSecs requires a positive interval, got %v.", secs))
+	}
+	return func(cfg *readConfig) {
+		cfg.pl.DynamicReadPollIntervalSeconds = &secs
+	}
+}
+
 // StartReadTimestamp is a Read option that specifies a start timestamp in
 // milliseconds epoch, so only records after that timestamp will be read.
 //
@@ -198,7 +239,8 @@ func MaxReadSecs(secs int64) readOption {
 }
 
 // CommitOffsetInFinalize is a Read option that specifies whether to commit
-// offsets when finalizing.
+// offsets when finalizing. It requires the consumer group to commit offsets to,
+// set as the "group.id" property with ConsumerConfigs.
 //
 // Default: false
 func CommitOffsetInFinalize(enabled bool) readOption {
@@ -223,15 +265,17 @@ func TimestampPolicy(name policy) readOption {
 // found in the Java SDK class
 // org.apache.beam.sdk.io.kafka.KafkaIO.Read.External.Configuration.
 type readPayload struct {
-	ConsumerConfig         map[string]string
-	Topics                 []string
-	KeyDeserializer        string
-	ValueDeserializer      string
-	StartReadTime          *int64
-	MaxNumRecords          *int64
-	MaxReadTime            *int64
-	CommitOffsetInFinalize bool
-	TimestampPolicy        string
+	ConsumerConfig                 map[string]string
+	Topics                         []string
+	TopicPattern                   *string
+	KeyDeserializer                string
+	ValueDeserializer              string
+	StartReadTime                  *int64
+	MaxNumRecords                  *int64
+	MaxReadTime                    *int64
+	CommitOffsetInFinalize         bool
+	TimestampPolicy                string
+	DynamicReadPollIntervalSeconds *int64
 }
 
 // Write is a cross-language PTransform which writes KV data to a specified
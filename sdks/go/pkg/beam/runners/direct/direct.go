@@ -72,6 +72,12 @@ func Execute(ctx context.Context, p *beam.Pipeline) (beam.PipelineResult, error)
 		plan.Down(ctx) // ignore any teardown errors
 		return nil, err
 	}
+	// The whole pipeline executes as a single bundle, which is committed once
+	// execution succeeds, so run any bundle finalization callbacks.
+	if err = plan.Finalize(); err != nil {
+		plan.Down(ctx) // ignore any teardown errors
+		return nil, err
+	}
 	if err = plan.Down(ctx); err != nil {
 		return nil, err
 	}
@@ -23,9 +23,12 @@ import (
 	"reflect"
 	"sort"
 	"testing"
+	"time"
 
 	"github.com/apache/beam/sdks/v2/go/pkg/beam"
 	"github.com/apache/beam/sdks/v2/go/pkg/beam/core/metrics"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/core/sdf"
+	"github.com/apache/beam/sdks/v2/go/pkg/beam/io/rtrackers/offsetrange"
 	"github.com/google/go-cmp/cmp"
 )
 
@@ -55,6 +58,9 @@ func init() {
 
 	beam.RegisterFunction(dofn1Counter)
 	beam.RegisterFunction(dofnSink)
+
+	beam.RegisterFunction(dofnFinalize)
+	beam.RegisterType(reflect.TypeOf((*sdfFinalize)(nil)))
 }
 
 func dofn1(imp []byte, emit func(int64)) {
@@ -221,6 +227,48 @@ func dofn1Counter(ctx context.Context, _ []byte, emit func(int64)) {
 	beam.NewCounter(ns, "count").Inc(ctx, 1)
 }
 
+// finalized counts the bundle finalization callbacks run by dofnFinalize and
+// sdfFinalize.
+var finalized int
+
+func dofnFinalize(bf beam.BundleFinalization, v int64, emit func(int64)) {
+	bf.RegisterCallback(time.Hour, func() error {
+		finalized++
+		return nil
+	})
+	emit(v)
+}
+
+// sdfFinalize registers a bundle finalization callback for each restriction
+// it processes.
+type sdfFinalize struct{}
+
+func (fn *sdfFinalize) CreateInitialRestriction(_ int64) offsetrange.Restriction {
+	return offsetrange.Restriction{Start: 0, End: 2}
+}
+
+func (fn *sdfFinalize) SplitRestriction(_ int64, rest offsetrange.Restriction) []offsetrange.Restriction {
+	return rest.EvenSplits(2)
+}
+
+func (fn *sdfFinalize) RestrictionSize(_ int64, rest offsetrange.Restriction) float64 {
+	return rest.Size()
+}
+
+func (fn *sdfFinalize) CreateTracker(rest offsetrange.Restriction) *sdf.LockRTracker {
+	return sdf.NewLockRTracker(offsetrange.NewTracker(rest))
+}
+
+func (fn *sdfFinalize) ProcessElement(bf beam.BundleFinalization, rt *sdf.LockRTracker, v int64, emit func(int64)) {
+	bf.RegisterCallback(time.Hour, func() error {
+		finalized++
+		return nil
+	})
+	for i := rt.GetRestriction().(offsetrange.Restriction).Start; rt.TryClaim(i); i++ {
+		emit(v)
+	}
+}
+
 func TestRunner_Pipelines(t *testing.T) {
 	t.Run("simple", func(t *testing.T) {
 		p, s := beam.NewPipelineWithRoot()
@@ -445,6 +493,33 @@ func TestRunner_Metrics(t *testing.T) {
 	})
 }
 
+func TestRunner_BundleFinalization(t *testing.T) {
+	tests := []struct {
+		name string
+		fn   interface{}
+		want int
+	}{
+		{"dofn", dofnFinalize, 3},
+		// Each of the 3 elements is split into 2 restrictions.
+		{"sdf", &sdfFinalize{}, 6},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			finalized = 0
+			p, s := beam.NewPipelineWithRoot()
+			imp := beam.Impulse(s)
+			col := beam.ParDo(s, dofn1, imp)
+			beam.ParDo(s, test.fn, col)
+			if _, err := executeWithT(context.Background(), t, p); err != nil {
+				t.Fatal(err)
+			}
+			if got, want := finalized, test.want; got != want {
+				t.Errorf("bundle finalization callbacks run %v times, want %v", got, want)
+			}
+		})
+	}
+}
+
 func TestMain(m *testing.M) {
 	// Can't use ptest since it causes a loop.
 	if !flag.Parsed() {
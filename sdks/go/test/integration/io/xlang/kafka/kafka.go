@@ -55,6 +55,24 @@ func readInts(s beam.Scope, expansionAddr, bootstrapAddr, topic string, numRecor
 	reads := kafkaio.Read(s, expansionAddr, bootstrapAddr, []string{topic},
 		kafkaio.MaxNumRecords(numRecords),
 		kafkaio.ConsumerConfigs(map[string]string{"auto.offset.reset": "earliest"}))
+	return decodeInts(s, reads)
+}
+
+// readPatternInts reads a set number of elements from the Kafka topics matching
+// a pattern, discovering partitions dynamically, and decodes them to ints.
+func readPatternInts(s beam.Scope, expansionAddr, bootstrapAddr, pattern string, numRecords int64) beam.PCollection {
+	s = s.Scope("kafka_test.ReadPatternFromKafka")
+
+	reads := kafkaio.Read(s, expansionAddr, bootstrapAddr, nil,
+		kafkaio.TopicPattern(pattern),
+		kafkaio.DynamicReadSecs(1),
+		kafkaio.MaxNumRecords(numRecords),
+		kafkaio.ConsumerConfigs(map[string]string{"auto.offset.reset": "earliest"}))
+	return decodeInts(s, reads)
+}
+
+// decodeInts decodes the values of records read from Kafka to ints.
+func decodeInts(s beam.Scope, reads beam.PCollection) beam.PCollection {
 	vals := beam.DropKey(s, reads)
 	decoded := beam.ParDo(s, func(b []byte) (int, error) {
 		buf := bytes.NewBuffer(b)
@@ -83,3 +101,14 @@ func ReadPipeline(expansionAddr, bootstrapAddr, topic string, inputs []int) *bea
 	passert.Equals(s, result, ins)
 	return p
 }
+
+// ReadPatternPipeline creates a pipeline that reads ints from the Kafka topics
+// matching a pattern and asserts that they match a given slice of ints.
+func ReadPatternPipeline(expansionAddr, bootstrapAddr, pattern string, inputs []int) *beam.Pipeline {
+	p, s := beam.NewPipelineWithRoot()
+	result := readPatternInts(s, expansionAddr, bootstrapAddr, pattern, int64(len(inputs)))
+
+	ins := beam.CreateList(s, inputs)
+	passert.Equals(s, result, ins)
+	return p
+}
@@ -19,6 +19,7 @@ import (
 	"flag"
 	"fmt"
 	"log"
+	"regexp"
 	"testing"
 
 	"github.com/apache/beam/sdks/v2/go/pkg/beam"
@@ -36,8 +37,9 @@ var bootstrapAddr string
 var expansionAddr string // Populate with expansion address labelled "io".
 
 const (
-	basicTopic = "xlang_kafkaio_basic_test"
-	numRecords = 1000
+	basicTopic   = "xlang_kafkaio_basic_test"
+	patternTopic = "xlang_kafkaio_pattern_test"
+	numRecords   = 1000
 )
 
 func checkFlags(t *testing.T) {
@@ -64,6 +66,24 @@ func TestKafkaIO_BasicReadWrite(t *testing.T) {
 	ptest.RunAndValidate(t, read)
 }
 
+// TestKafkaIO_TopicPattern writes to a topic and reads it back by matching a
+// topic pattern, with dynamic partition discovery.
+func TestKafkaIO_TopicPattern(t *testing.T) {
+	integration.CheckFilters(t)
+	checkFlags(t)
+
+	inputs := make([]int, numRecords)
+	for i := 0; i < numRecords; i++ {
+		inputs[i] = i
+	}
+	topic := appendUuid(patternTopic)
+
+	write := WritePipeline(expansionAddr, bootstrapAddr, topic, inputs)
+	ptest.RunAndValidate(t, write)
+	read := ReadPatternPipeline(expansionAddr, bootstrapAddr, regexp.QuoteMeta(topic), inputs)
+	ptest.RunAndValidate(t, read)
+}
+
 // TestMain starts up a Kafka cluster from integration.KafkaJar before running
 // tests through ptest.Main.
 func TestMain(m *testing.M) {